@@ -0,0 +1,405 @@
+// Package agent runs profiling sessions on the machine that holds the
+// workload, on behalf of controllers elsewhere, so lab machines and cloud
+// instances can be profiled without copying binaries around.
+//
+// A Server is an http.Handler; a Client drives one over the network.
+// Requests and replies are JSON over HTTP/1.1, snapshots stream as one
+// JSON object per line:
+//
+//	POST   /v1/sessions                start a session (Request → Session)
+//	GET    /v1/sessions                list sessions
+//	GET    /v1/sessions/ID             one session
+//	POST   /v1/sessions/ID/stop        stop it: detach, or kill a launched workload
+//	GET    /v1/sessions/ID/snapshots   stream its snapshots (?from=N skips N)
+//	GET    /v1/sessions/ID/report      its report (?wait=1 blocks until done)
+//	DELETE /v1/sessions/ID             forget a finished session
+//
+// Errors are {"error": "..."} with a 4xx or 5xx status.
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+// Session states.
+const (
+	StateRunning = "running"
+	StateDone    = "done"   // a report is ready
+	StateFailed  = "failed" // no report; see Session.Error
+)
+
+// Request starts a session: it launches Cmd, or attaches to Pid or to the
+// first process of Container (see profiler.FindContainer), exactly one
+// of them. Options are those of profiler.New; Stdin, Stdout, Stderr and
+// OnSnapshot do not travel, and the agent collects the snapshots of a
+// streaming session itself.
+type Request struct {
+	Options   profiler.Options `json:"options"`
+	Cmd       []string         `json:"cmd,omitempty"`
+	Pid       int              `json:"pid,omitempty"`
+	Container string           `json:"container,omitempty"`
+}
+
+// Session describes one profiling session.
+type Session struct {
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	Cmd       []string   `json:"cmd,omitempty"`
+	Pid       int        `json:"pid,omitempty"`
+	Container string     `json:"container,omitempty"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Stopped   bool       `json:"stopped,omitempty"`   // by a stop request
+	Snapshots int        `json:"snapshots,omitempty"` // received so far
+	// Error is why a failed session has no report, or for a done one the
+	// workload's own failure (a non-zero exit status, say).
+	Error string `json:"error,omitempty"`
+}
+
+// session is a Session and its run.
+type session struct {
+	mu      sync.Mutex
+	info    Session
+	snaps   []profiler.Snapshot
+	res     *profiler.Result
+	changed chan struct{} // closed and replaced at every new snapshot and at the end
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Server runs sessions for remote controllers. Create it with NewServer.
+type Server struct {
+	token string
+	hosts map[string]bool
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	order    []string
+	seq      int
+}
+
+// NewServer returns a Server that accepts only requests carrying token as
+// "Authorization: Bearer <token>", with a Host header naming one of hosts
+// (any, when there are none) and no Origin header. An agent runs any
+// command it is sent: the token keeps out other users of the machine,
+// the Host check DNS rebinding, and the Origin check, with starting
+// sessions only from an application/json body, the pages of a browser.
+// A Server without a token accepts no requests.
+func NewServer(token string, hosts ...string) *Server {
+	s := &Server{token: token, sessions: map[string]*session{}}
+	if len(hosts) > 0 {
+		s.hosts = map[string]bool{}
+		for _, h := range hosts {
+			s.hosts[strings.ToLower(h)] = true
+		}
+	}
+	return s
+}
+
+// check returns the status and error refusing r, 0 when it may proceed.
+func (s *Server) check(r *http.Request) (int, error) {
+	if r.Header.Get("Origin") != "" {
+		return http.StatusForbidden, errors.New("requests from web pages are refused")
+	}
+	if s.hosts != nil {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !s.hosts[strings.ToLower(strings.Trim(host, "[]"))] {
+			return http.StatusForbidden, fmt.Errorf("unknown host %q", r.Host)
+		}
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+		return http.StatusUnauthorized, errors.New("missing or wrong token")
+	}
+	if r.Method == http.MethodPost {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			return http.StatusUnsupportedMediaType, errors.New("want Content-Type application/json")
+		}
+	}
+	return 0, nil
+}
+
+// Close stops every running session and waits for them to end.
+func (s *Server) Close() {
+	s.mu.Lock()
+	var all []*session
+	for _, ss := range s.sessions {
+		all = append(all, ss)
+	}
+	s.mu.Unlock()
+	for _, ss := range all {
+		ss.cancel()
+		<-ss.done
+	}
+}
+
+// ServeHTTP implements the protocol of the package comment.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if code, err := s.check(r); err != nil {
+		httpError(w, code, err)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/v1/sessions")
+	if !ok || rest != "" && rest[0] != '/' {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+		return
+	}
+	id, verb, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.start(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		s.list(w)
+	case id == "":
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s", r.Method, r.URL.Path))
+	default:
+		s.mu.Lock()
+		ss := s.sessions[id]
+		s.mu.Unlock()
+		if ss == nil {
+			httpError(w, http.StatusNotFound, fmt.Errorf("no session %s", id))
+			return
+		}
+		switch {
+		case verb == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, ss.snapshot())
+		case verb == "" && r.Method == http.MethodDelete:
+			s.remove(w, ss)
+		case verb == "stop" && r.Method == http.MethodPost:
+			ss.stop()
+			writeJSON(w, http.StatusOK, ss.snapshot())
+		case verb == "snapshots" && r.Method == http.MethodGet:
+			ss.stream(w, r)
+		case verb == "report" && r.Method == http.MethodGet:
+			ss.report(w, r)
+		default:
+			httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s", r.Method, r.URL.Path))
+		}
+	}
+}
+
+func (s *Server) start(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	targets := 0
+	for _, set := range []bool{len(req.Cmd) > 0, req.Pid != 0, req.Container != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		httpError(w, http.StatusBadRequest, errors.New("want exactly one of cmd, pid and container"))
+		return
+	}
+	if req.Container != "" {
+		pid, _, err := profiler.FindContainer(req.Container)
+		if err != nil {
+			httpError(w, http.StatusNotFound, err)
+			return
+		}
+		req.Pid = pid
+	}
+
+	ss := &session{changed: make(chan struct{}), done: make(chan struct{})}
+	opts := req.Options
+	opts.Stdin, opts.Stdout, opts.Stderr, opts.OnSnapshot = nil, nil, nil, nil
+	if opts.Stream != 0 {
+		opts.OnSnapshot = ss.addSnapshot
+	}
+	p, err := profiler.New(opts)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.seq++
+	ss.info = Session{ID: strconv.Itoa(s.seq), State: StateRunning, Cmd: req.Cmd, Pid: req.Pid,
+		Container: req.Container, Started: time.Now()}
+	s.sessions[ss.info.ID] = ss
+	s.order = append(s.order, ss.info.ID)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ss.cancel = cancel
+	go func() {
+		defer cancel()
+		var res *profiler.Result
+		var err error
+		if req.Pid != 0 {
+			res, err = p.Attach(ctx, req.Pid)
+		} else {
+			res, err = p.Run(ctx, req.Cmd)
+		}
+		ss.finish(res, err)
+	}()
+	writeJSON(w, http.StatusCreated, ss.snapshot())
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	out := []Session{}
+	for _, id := range s.order {
+		out = append(out, s.sessions[id].snapshot())
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) remove(w http.ResponseWriter, ss *session) {
+	info := ss.snapshot()
+	if info.State == StateRunning {
+		httpError(w, http.StatusConflict, fmt.Errorf("session %s is running; stop it first", info.ID))
+		return
+	}
+	s.mu.Lock()
+	delete(s.sessions, info.ID)
+	for i, id := range s.order {
+		if id == info.ID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// snapshot returns a copy of the session's description.
+func (ss *session) snapshot() Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	info := ss.info
+	info.Snapshots = len(ss.snaps)
+	return info
+}
+
+// broadcast wakes the streams waiting for news; ss.mu must be held.
+func (ss *session) broadcast() {
+	close(ss.changed)
+	ss.changed = make(chan struct{})
+}
+
+func (ss *session) addSnapshot(snap profiler.Snapshot) {
+	ss.mu.Lock()
+	ss.snaps = append(ss.snaps, snap)
+	ss.broadcast()
+	ss.mu.Unlock()
+}
+
+func (ss *session) finish(res *profiler.Result, err error) {
+	ss.mu.Lock()
+	now := time.Now()
+	ss.info.Finished, ss.res = &now, res
+	ss.info.State = StateDone
+	if res == nil {
+		ss.info.State = StateFailed
+	}
+	if err != nil {
+		ss.info.Error = err.Error()
+	}
+	ss.broadcast()
+	ss.mu.Unlock()
+	close(ss.done)
+}
+
+func (ss *session) stop() {
+	ss.mu.Lock()
+	if ss.info.State == StateRunning {
+		ss.info.Stopped = true
+	}
+	ss.mu.Unlock()
+	ss.cancel()
+}
+
+// stream writes the session's snapshots from ?from= on as JSON lines,
+// following new ones until the session ends or the client goes away.
+func (ss *session) stream(w http.ResponseWriter, r *http.Request) {
+	next := 0
+	if v := r.URL.Query().Get("from"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("bad from %q", v))
+			return
+		}
+		next = n
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		ss.mu.Lock()
+		batch := ss.snaps[min(next, len(ss.snaps)):]
+		running, changed := ss.info.State == StateRunning, ss.changed
+		ss.mu.Unlock()
+		for _, snap := range batch {
+			if enc.Encode(snap) != nil {
+				return
+			}
+			next++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !running {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// report writes the session's report, with ?wait=1 once it ends.
+func (ss *session) report(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("wait") != "" {
+		select {
+		case <-ss.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	ss.mu.Lock()
+	info, res := ss.info, ss.res
+	ss.mu.Unlock()
+	switch info.State {
+	case StateRunning:
+		httpError(w, http.StatusConflict, fmt.Errorf("session %s is still running", info.ID))
+	case StateFailed:
+		httpError(w, http.StatusUnprocessableEntity, fmt.Errorf("session %s failed: %s", info.ID, info.Error))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		res.WriteJSON(w)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
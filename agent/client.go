@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+// ErrNotReady means a session's report was asked for while it runs.
+var ErrNotReady = errors.New("agent: session still running")
+
+// Client talks to an agent's Server.
+type Client struct {
+	// URL is the agent's base URL, e.g. http://lab3:7070; a bare
+	// host:port means http.
+	URL string
+	// Token is sent as a bearer token; the agent needs it.
+	Token string
+	// HTTP is the client requests go through (default
+	// http.DefaultClient).
+	HTTP *http.Client
+}
+
+// do sends a request for path with body, if not nil, encoded as JSON and
+// returns the response when its status is 2xx; any other status is
+// returned as an error carrying the agent's message.
+func (c *Client) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	base := c.URL
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("agent: %w", err)
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(base, "/")+path, rd)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	if body != nil || method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var e struct{ Error string }
+	if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+	if resp.StatusCode == http.StatusConflict && method == http.MethodGet {
+		return nil, ErrNotReady
+	}
+	return nil, fmt.Errorf("agent: %s", e.Error)
+}
+
+// call sends a request and decodes its JSON reply into out, if not nil.
+func (c *Client) call(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("agent: decode reply: %w", err)
+	}
+	return nil
+}
+
+// Start starts a session.
+func (c *Client) Start(ctx context.Context, req Request) (*Session, error) {
+	var s Session
+	if err := c.call(ctx, http.MethodPost, "/v1/sessions", req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Sessions lists the agent's sessions, oldest first.
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	var ss []Session
+	if err := c.call(ctx, http.MethodGet, "/v1/sessions", nil, &ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Session returns session id.
+func (c *Client) Session(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	if err := c.call(ctx, http.MethodGet, "/v1/sessions/"+url.PathEscape(id), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Stop stops session id: an attached session detaches and reports, a
+// launched workload is killed.
+func (c *Client) Stop(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	if err := c.call(ctx, http.MethodPost, "/v1/sessions/"+url.PathEscape(id)+"/stop", nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Delete makes the agent forget finished session id.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, "/v1/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// Watch passes each snapshot of session id to fn, from the first one,
+// until the session ends or ctx is cancelled. A session without
+// Options.Stream has none.
+func (c *Client) Watch(ctx context.Context, id string, fn func(profiler.Snapshot)) error {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/sessions/"+url.PathEscape(id)+"/snapshots", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var s profiler.Snapshot
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return fmt.Errorf("agent: decode snapshot: %w", err)
+		}
+		fn(s)
+	}
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("agent: %w", err)
+	}
+	return ctx.Err()
+}
+
+// Report fetches the report of session id. With wait it blocks until the
+// session ends; without, a running session returns ErrNotReady.
+func (c *Client) Report(ctx context.Context, id string, wait bool) (*profiler.Result, error) {
+	path := "/v1/sessions/" + url.PathEscape(id) + "/report"
+	if wait {
+		path += "?wait=1"
+	}
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return profiler.Decode(resp.Body)
+}
@@ -0,0 +1,3 @@
+// Shared-library build of the client markers, for ctypes and other FFIs:
+//   cc -O2 -shared -fPIC int64profiler.c -o libint64profiler.so
+#include "int64profiler.h"
@@ -0,0 +1,84 @@
+// Int64Profiler client API – region-of-interest markers
+//
+//   #include "int64profiler.h"
+//   Int64ProfilerStart("kernel");
+//   ... work ...
+//   Int64ProfilerStop("kernel");
+//
+// Run under `int64profiler.sh --regions ./prog`: only instructions executed
+// between matching Start/Stop calls are counted, and every region name is
+// reported separately.  Outside the profiler the calls do nothing.
+//
+// The profiler finds the markers by symbol name, so they stay out of line and
+// must not be stripped.  NULL or "" names the region "default".  Regions may
+// nest; counts of an inner region are also included in the outer one.
+//
+// Int64ProfilerPhase("keygen") starts the phase keygen, which lasts until the
+// next call, for the per-phase breakdown of `int64profiler.sh
+// --phases=marker`; it does not scope counting.
+//
+// Int64ProfilerAssertBudget("kernel", "{mul: 1e6, div: 0}") declares what
+// every later exit of region kernel may cost: at most a million MULs and no
+// DIV.  The ops are add, sub, mul, div, int, the -ops categories (shl, xor,
+// bitwise…), vec, wide, fp64, fp32, fp, loads, stores, bytes and ops (all
+// counted operations); declaring one again replaces its limit.  Under
+// `int64profiler.sh --regions` the report lists the exits over budget, and
+// iccad fails the run.
+//
+// Int64ProfilerPyEnter / Leave / Done report Python calls and returns for
+// `int64profiler.sh --python`; the Python module's functions() hook makes
+// them, nobody else needs to.
+#ifndef INT64PROFILER_H
+#define INT64PROFILER_H
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerStart(const char* name)
+{
+    __asm__ __volatile__("" : : "r"(name) : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerStop(const char* name)
+{
+    __asm__ __volatile__("" : : "r"(name) : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerPhase(const char* name)
+{
+    __asm__ __volatile__("" : : "r"(name) : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerAssertBudget(const char* region, const char* budget)
+{
+    __asm__ __volatile__("" : : "r"(region), "r"(budget) : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerPyEnter(const char* func, const char* file, int line)
+{
+    __asm__ __volatile__("" : : "r"(func), "r"(file), "r"(line) : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerPyLeave(void)
+{
+    __asm__ __volatile__("" : : : "memory");
+}
+
+__attribute__((weak, noinline, used))
+void Int64ProfilerPyDone(void)
+{
+    __asm__ __volatile__("" : : : "memory");
+}
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // INT64PROFILER_H
@@ -0,0 +1,128 @@
+"""Int64Profiler client API for Python (ctypes shim).
+
+    import int64profiler
+    with int64profiler.region("kernel"):
+        work()
+
+Calls Int64ProfilerStart / Stop / Phase / AssertBudget in libint64profiler.so (built
+from int64profiler.c), located via $INT64PROFILER_LIB or next to this file.
+Without the library every call is a no-op.  Under the profiler the counts
+are those of the interpreter executing the region.
+
+For `--python` the counts go to the Python functions that ran them:
+
+    python3 -m int64profiler script.py args…
+
+runs script.py under functions(), which can also scope a with-block.
+"""
+import contextlib
+import ctypes
+import os
+import runpy
+import sys
+import threading
+
+_lib = None
+_path = os.environ.get("INT64PROFILER_LIB") or os.path.join(
+    os.path.dirname(os.path.abspath(__file__)), "libint64profiler.so")
+try:
+    _lib = ctypes.CDLL(_path)
+    _lib.Int64ProfilerStart.argtypes = [ctypes.c_char_p]
+    _lib.Int64ProfilerStop.argtypes = [ctypes.c_char_p]
+    _lib.Int64ProfilerPhase.argtypes = [ctypes.c_char_p]
+    _lib.Int64ProfilerAssertBudget.argtypes = [ctypes.c_char_p, ctypes.c_char_p]
+    _lib.Int64ProfilerPyEnter.argtypes = [ctypes.c_char_p, ctypes.c_char_p, ctypes.c_int]
+    _lib.Int64ProfilerPyLeave.argtypes = []
+    _lib.Int64ProfilerPyDone.argtypes = []
+except (OSError, AttributeError):
+    _lib = None
+
+
+def start(name="default"):
+    """Open region name."""
+    if _lib:
+        _lib.Int64ProfilerStart(name.encode())
+
+
+def stop(name="default"):
+    """Close the innermost open region called name."""
+    if _lib:
+        _lib.Int64ProfilerStop(name.encode())
+
+
+def phase(name="default"):
+    """Start phase name, which lasts until the next phase() call."""
+    if _lib:
+        _lib.Int64ProfilerPhase(name.encode())
+
+
+def assert_budget(region, **limits):
+    """Declare the most of each op an exit of region may count:
+    assert_budget("kernel", mul=1e6, div=0)."""
+    if _lib:
+        spec = ", ".join("%s: %r" % kv for kv in sorted(limits.items()))
+        _lib.Int64ProfilerAssertBudget(region.encode(), ("{" + spec + "}").encode())
+
+
+@contextlib.contextmanager
+def region(name="default"):
+    """Count the body of a with-block as region name."""
+    start(name)
+    try:
+        yield
+    finally:
+        stop(name)
+
+
+# The encoded name of each code object seen.  The profiler tells functions
+# apart by these buffers, so they live as long as the process.
+_names = {}
+
+
+def _hook(frame, event, arg):
+    if event == "call":
+        code = frame.f_code
+        n = _names.get(code)
+        if n is None:
+            name = getattr(code, "co_qualname", code.co_name)
+            n = _names[code] = (name.encode(), code.co_filename.encode(), code.co_firstlineno)
+        _lib.Int64ProfilerPyEnter(*n)
+    elif event == "return":
+        _lib.Int64ProfilerPyLeave()
+
+
+@contextlib.contextmanager
+def functions():
+    """Report the Python calls and returns of a with-block, in this thread
+    and the threads it starts, for the per-function breakdown of
+    `int64profiler.sh --python`."""
+    if not _lib:
+        yield
+        return
+    sys.setprofile(_hook)
+    threading.setprofile(_hook)
+    try:
+        yield
+    finally:
+        sys.setprofile(None)
+        threading.setprofile(None)
+        _lib.Int64ProfilerPyDone()
+
+
+def main():
+    if len(sys.argv) < 2:
+        sys.exit("usage: python3 -m int64profiler script.py [args…]")
+    if not _lib:
+        # Without the hook the run would count no Python function at all,
+        # which reads as a profile of nothing rather than a missing library.
+        sys.exit("int64profiler: cannot load %s; set $INT64PROFILER_LIB or build it with\n"
+                 "    cc -O2 -shared -fPIC client/int64profiler.c -o client/libint64profiler.so"
+                 % _path)
+    sys.argv = sys.argv[1:]
+    sys.path[0] = os.path.dirname(os.path.abspath(sys.argv[0]))
+    with functions():
+        runpy.run_path(sys.argv[0], run_name="__main__")
+
+
+if __name__ == "__main__":
+    main()
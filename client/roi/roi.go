@@ -0,0 +1,88 @@
+// Package roi marks regions of interest for Int64Profiler.
+//
+//	defer roi.Region("kernel")()
+//
+// Run the program with `int64profiler.sh --regions` (or Options.Regions):
+// only instructions executed inside Start/Stop pairs are counted, and each
+// region name is reported separately.  Without the profiler the calls cost
+// a function call each.
+//
+// Counting is per OS thread, so Start locks the calling goroutine to its
+// thread until the matching Stop.
+//
+// Phase names the phases of a run for `int64profiler.sh --phases=marker`
+// (or Options.Phases "marker"); it does not scope counting.
+//
+// AssertBudget declares what every later exit of a region may cost:
+//
+//	roi.AssertBudget("kernel", roi.Budget{"mul": 1e6, "div": 0})
+//
+// The report lists the exits over budget and iccad fails the run
+// (profiler.ErrOverBudget).
+package roi
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Start opens region name on the calling goroutine's thread.
+func Start(name string) {
+	runtime.LockOSThread()
+	begin(name)
+}
+
+// Stop closes the innermost open region called name.
+func Stop(name string) {
+	end(name)
+	runtime.UnlockOSThread()
+}
+
+// Region opens region name and returns the function that closes it.
+func Region(name string) func() {
+	Start(name)
+	return func() { Stop(name) }
+}
+
+// Phase starts phase name of the whole process; it lasts until the next
+// Phase call.
+func Phase(name string) {
+	phase(name)
+}
+
+// Budget maps ops to the most of them a region exit may count: add, sub,
+// mul, div, int, a bitwise op (shl, xor…) or bitwise, vec, wide, fp64,
+// fp32, fp, loads, stores, bytes or ops (all counted operations). The
+// categories must be counted in the run.
+type Budget map[string]float64
+
+// AssertBudget declares b for region; an op declared again gets the new
+// limit.
+func AssertBudget(region string, b Budget) {
+	ops := make([]string, 0, len(b))
+	for op := range b {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for i, op := range ops {
+		ops[i] = op + ": " + strconv.FormatFloat(b[op], 'g', -1, 64)
+	}
+	budget(region, "{"+strings.Join(ops, ", ")+"}")
+}
+
+// begin, end, phase and budget are the markers the profiler hooks by symbol name; the
+// string arrives in registers under the Go internal ABI.
+//
+//go:noinline
+func begin(name string) { _ = name }
+
+//go:noinline
+func end(name string) { _ = name }
+
+//go:noinline
+func phase(name string) { _ = name }
+
+//go:noinline
+func budget(region, spec string) { _, _ = region, spec }
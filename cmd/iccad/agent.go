@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/abe5240/iccad/agent"
+)
+
+const agentUsage = "agent [-listen addr] [-token token] [-host name]"
+
+// runAgent serves profiling sessions to iccad remote until interrupted,
+// then stops the running ones.
+func runAgent(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:7070", "serve on `addr`")
+	token := fs.String("token", os.Getenv("ICCAD_AGENT_TOKEN"), "require this bearer `token` (default $ICCAD_AGENT_TOKEN, or a new one printed at start)")
+	var hosts []string
+	fs.Func("host", "also accept requests addressed to host `name`, e.g. that of a proxy (repeatable)", appendFlag(&hosts))
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", agentUsage)
+		return 2
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fail("agent", err)
+	}
+	// an agent runs any command it is sent: never without a token
+	if *token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			ln.Close()
+			return fail("agent", err)
+		}
+		*token = hex.EncodeToString(b)
+		fmt.Fprintf(os.Stderr, "iccad agent: token %s (ICCAD_AGENT_TOKEN for iccad remote)\n", *token)
+	}
+	hosts = append(hosts, listenHosts(ln.Addr().(*net.TCPAddr).IP)...)
+
+	srv := agent.NewServer(*token, hosts...)
+	hs := &http.Server{Handler: srv}
+	ctx, stop := signalContext()
+	defer stop()
+	closed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		// ending the sessions first releases the requests waiting on them
+		srv.Close()
+		hs.Shutdown(context.Background())
+		close(closed)
+	}()
+	fmt.Fprintf(os.Stderr, "iccad agent: serving at http://%s\n", ln.Addr())
+	if err := hs.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return fail("agent", err)
+	}
+	<-closed
+	return 0
+}
+
+// listenHosts returns the host names requests to a listener on ip may
+// carry: ip itself, then localhost for loopback, the machine's host name
+// otherwise, and for all addresses localhost and those of its interfaces.
+func listenHosts(ip net.IP) []string {
+	hosts := []string{ip.String()}
+	if ip.IsLoopback() {
+		return append(hosts, "localhost")
+	}
+	if h, err := os.Hostname(); err == nil {
+		hosts = append(hosts, h)
+		if short, _, ok := strings.Cut(h, "."); ok {
+			hosts = append(hosts, short)
+		}
+	}
+	if !ip.IsUnspecified() {
+		return hosts
+	}
+	hosts = append(hosts, "localhost")
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				hosts = append(hosts, n.IP.String())
+			}
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const annotateUsage = "annotate -func NAME result.json"
+
+// runAnnotate prints the disassembly of a function with the execution
+// count and op type of each instruction, from a report recorded with
+// --annotate.
+func runAnnotate(args []string) int {
+	fs := flag.NewFlagSet("annotate", flag.ContinueOnError)
+	name := fs.String("func", "", "annotate the functions matching this `glob`")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *name == "" {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", annotateUsage)
+		return 2
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("annotate", err)
+	}
+	if res.Annotated == nil {
+		return fail("annotate", errors.New("report has no annotated functions (record with --annotate)"))
+	}
+	if err := res.AnnotateFunc(os.Stdout, *name); err != nil {
+		return fail("annotate", err)
+	}
+	return 0
+}
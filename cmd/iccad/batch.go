@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const batchUsage = "batch [-j N [-cpus list] [-cgroup dir]] [-format text|json] [-o file] [-v] manifest.toml"
+
+// stat summarises one counter over a workload's repetitions.
+type stat struct {
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Stddev float64 `json:"stddev"` // sample standard deviation, 0 for one run
+}
+
+// batchWorkload is one workload's section of a batch report.
+type batchWorkload struct {
+	Name        string             `json:"name"`
+	Labels      []string           `json:"labels,omitempty"`
+	Command     []string           `json:"command"`
+	Runs        int                `json:"runs"` // successful repetitions
+	Errors      []string           `json:"errors,omitempty"`
+	Failures    []batchFailure     `json:"failures,omitempty"` // one per error
+	Stats       map[string]stat    `json:"stats"`              // keyed by category the runs counted
+	WallTimeSec stat               `json:"wall_time_sec"`
+	Results     []*profiler.Result `json:"results"`
+	CPUs        [][]int            `json:"cpus,omitempty"` // each result's, when pinned
+}
+
+// batchFailure is a failed repetition of a workload. By is who failed:
+// "workload" when the target exited non-zero, crashed or was killed,
+// "limit" when it was stopped at a limit or over budget, "profiler" for
+// anything else.
+type batchFailure struct {
+	Run    int              `json:"run"`
+	By     string           `json:"by"`
+	Error  string           `json:"error"`
+	Exit   *profiler.Exit   `json:"exit,omitempty"`
+	Output *profiler.Output `json:"output,omitempty"` // -capture-output
+}
+
+// failedBy classifies the error err of a run.
+func failedBy(err error) string {
+	switch {
+	case errors.Is(err, profiler.ErrTargetFailed), errors.Is(err, profiler.ErrPartial):
+		return "workload"
+	case errors.Is(err, profiler.ErrTruncated), errors.Is(err, profiler.ErrOverBudget):
+		return "limit"
+	}
+	return "profiler"
+}
+
+// batchReport is the aggregate report of a manifest run.
+type batchReport struct {
+	Categories []string        `json:"categories"`
+	Parallel   int             `json:"parallel"` // -j
+	Workloads  []batchWorkload `json:"workloads"`
+}
+
+// batchRun is one repetition of a workload, and its outcome.
+type batchRun struct {
+	w    int // index into the manifest's workloads
+	rep  int
+	opts profiler.Options
+	res  *profiler.Result
+	err  error
+}
+
+// batchSlot is where one of the -j concurrent runs goes: its share of
+// -cpus and its own cgroup under -cgroup.
+type batchSlot struct {
+	cpus   []int
+	cgroup string
+}
+
+// batchSlots splits cpus evenly over n slots, leftovers unused, and gives
+// each slot the cgroup parent/slotN.
+func batchSlots(n int, cpus []int, parent string) ([]batchSlot, error) {
+	if len(cpus) > 0 && len(cpus) < n {
+		return nil, fmt.Errorf("-cpus has %d CPUs for -j %d", len(cpus), n)
+	}
+	slots := make([]batchSlot, n)
+	for i := range slots {
+		if k := len(cpus) / n; k > 0 {
+			slots[i].cpus = cpus[i*k : (i+1)*k]
+		}
+		if parent != "" {
+			slots[i].cgroup = filepath.Join(parent, fmt.Sprintf("slot%d", i))
+		}
+	}
+	return slots, nil
+}
+
+// runBatch profiles every workload of a manifest, each for its number of
+// repetitions, and prints one aggregate report.
+func runBatch(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	format := fs.String("format", "text", "report `format`: text or json")
+	out := fs.String("o", "", "write the report to `file` instead of stdout")
+	verbose := fs.Bool("v", false, "show the workloads' output (on stderr)")
+	jobs := fs.Int("j", 1, "run up to `N` runs at once")
+	cpuList := fs.String("cpus", "", "pin each concurrent run to its own share of these CPUs, e.g. `list` 0-7")
+	cgroup := fs.String("cgroup", "", "run each concurrent run in its own cgroup v2 under this `directory` (relative to /sys/fs/cgroup)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", batchUsage)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		return fail("batch", fmt.Errorf("unknown format %q", *format))
+	}
+	if *jobs < 1 {
+		return fail("batch", fmt.Errorf("-j %d: want at least 1", *jobs))
+	}
+	var cpus []int
+	var err error
+	if *cpuList != "" {
+		if cpus, err = parseCPUs(*cpuList); err != nil {
+			return fail("batch", err)
+		}
+	}
+	slots, err := batchSlots(*jobs, cpus, *cgroup)
+	if err != nil {
+		return fail("batch", err)
+	}
+	m, err := loadManifest(fs.Arg(0))
+	if err != nil {
+		return fail("batch", err)
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	rep := &batchReport{Parallel: *jobs}
+	var runs []*batchRun
+	for i, w := range m.Workloads {
+		wfs := flag.NewFlagSet(w.Name, flag.ContinueOnError)
+		wfs.SetOutput(io.Discard)
+		opts := runFlags(wfs)
+		if err := wfs.Parse(w.Options); err != nil || wfs.NArg() > 0 {
+			if err == nil {
+				err = fmt.Errorf("unexpected argument %q", wfs.Arg(0))
+			}
+			return fail("batch", fmt.Errorf("workload %s: options: %v", w.Name, err))
+		}
+		opts.Env = append(os.Environ(), w.Env...)
+		opts.Dir = m.Dir
+		if *verbose {
+			opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+		}
+		if _, err := profiler.New(*opts); err != nil {
+			return fail("batch", fmt.Errorf("workload %s: %v", w.Name, err))
+		}
+		rep.Workloads = append(rep.Workloads, batchWorkload{Name: w.Name, Labels: w.Labels, Command: append([]string{w.Command}, w.Args...)})
+		for r := 0; r < w.Repetitions; r++ {
+			runs = append(runs, &batchRun{w: i, rep: r, opts: *opts})
+		}
+	}
+
+	// the slot cgroups, and -cgroup itself, go again if the runs made them
+	var created []string
+	if *cgroup != "" {
+		for _, dir := range append([]string{*cgroup}, slotCgroups(slots)...) {
+			if _, err := os.Stat(cgroupPath(dir)); os.IsNotExist(err) {
+				created = append(created, cgroupPath(dir))
+			}
+		}
+	}
+	runBatchRuns(ctx, m, rep, runs, slots)
+	for i := len(created) - 1; i >= 0; i-- {
+		os.Remove(created[i])
+	}
+	if ctx.Err() != nil {
+		return fail("batch", ctx.Err())
+	}
+	failed := map[string]int{}
+	for _, r := range runs {
+		w := &rep.Workloads[r.w]
+		if r.err != nil {
+			f := batchFailure{Run: r.rep + 1, By: failedBy(r.err), Error: r.err.Error()}
+			if r.res != nil {
+				f.Exit, f.Output = r.res.Exit, r.res.Output
+			}
+			w.Errors = append(w.Errors, fmt.Sprintf("run %d: %v", f.Run, r.err))
+			w.Failures = append(w.Failures, f)
+			failed[f.By]++
+			continue
+		}
+		w.Results = append(w.Results, r.res)
+		if len(r.opts.CPUs) > 0 {
+			w.CPUs = append(w.CPUs, r.opts.CPUs)
+		}
+	}
+	rep.summarize()
+
+	var wr io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("batch", err)
+		}
+		defer f.Close()
+		wr = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	} else {
+		err = rep.writeText(wr)
+	}
+	if err != nil {
+		return fail("batch", err)
+	}
+	if len(failed) > 0 {
+		var by []string
+		for _, k := range []string{"workload", "limit", "profiler"} {
+			if n := failed[k]; n > 0 {
+				by = append(by, fmt.Sprintf("%d %s", n, k))
+			}
+		}
+		return fail("batch", fmt.Errorf("some runs failed (%s)", strings.Join(by, ", ")))
+	}
+	return 0
+}
+
+// runBatchRuns runs runs in manifest order, one per slot at a time. A
+// slot's CPUs and cgroup apply to the runs whose workload options don't
+// set their own.
+func runBatchRuns(ctx context.Context, m *manifest, rep *batchReport, runs []*batchRun, slots []batchSlot) {
+	next := make(chan *batchRun)
+	var wg sync.WaitGroup
+	var mu sync.Mutex // serializes the progress lines
+	for _, s := range slots {
+		wg.Add(1)
+		go func(s batchSlot) {
+			defer wg.Done()
+			for r := range next {
+				if len(r.opts.CPUs) == 0 {
+					r.opts.CPUs = s.cpus
+				}
+				if r.opts.Cgroup == "" {
+					r.opts.Cgroup = s.cgroup
+				}
+				w := rep.Workloads[r.w]
+				on := ""
+				if len(r.opts.CPUs) > 0 {
+					on = fmt.Sprintf(" on CPUs %s", cpuString(r.opts.CPUs))
+				}
+				mu.Lock()
+				fmt.Fprintf(os.Stderr, "iccad batch: %s run %d/%d%s\n", w.Name, r.rep+1, m.Workloads[r.w].Repetitions, on)
+				mu.Unlock()
+				p, err := profiler.New(r.opts)
+				if err == nil {
+					r.res, r.err = p.Run(ctx, w.Command)
+				} else {
+					r.err = err
+				}
+			}
+		}(s)
+	}
+	for _, r := range runs {
+		select {
+		case next <- r:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(next)
+	wg.Wait()
+}
+
+// slotCgroups returns the cgroups of slots.
+func slotCgroups(slots []batchSlot) []string {
+	dirs := make([]string, len(slots))
+	for i, s := range slots {
+		dirs[i] = s.cgroup
+	}
+	return dirs
+}
+
+// cgroupPath resolves a cgroup directory as profiler.Options.Cgroup does.
+func cgroupPath(dir string) string {
+	if dir == "" || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join("/sys/fs/cgroup", dir)
+}
+
+// cpuString renders cpus as a list, e.g. 0,1,2.
+func cpuString(cpus []int) string {
+	s := make([]string, len(cpus))
+	for i, c := range cpus {
+		s[i] = strconv.Itoa(c)
+	}
+	return strings.Join(s, ",")
+}
+
+// summarize fills in the categories and each workload's statistics.
+func (rep *batchReport) summarize() {
+	seen := map[string]bool{}
+	for _, w := range rep.Workloads {
+		for _, r := range w.Results {
+			for _, c := range r.Ops() {
+				seen[c] = true
+			}
+		}
+	}
+	rep.Categories = append([]string(nil), profiler.CategoryNames...)
+	for _, c := range profiler.BitCategoryNames {
+		if seen[c] {
+			rep.Categories = append(rep.Categories, c)
+		}
+	}
+	for i := range rep.Workloads {
+		w := &rep.Workloads[i]
+		w.Runs = len(w.Results)
+		w.Stats = map[string]stat{}
+		for _, c := range rep.Categories {
+			if w.Runs == 0 || !counted(w.Results[0], c) {
+				continue
+			}
+			w.Stats[c] = statOf(w.Results, func(r *profiler.Result) float64 { return float64(r.Totals.Get(c)) })
+		}
+		w.WallTimeSec = statOf(w.Results, func(r *profiler.Result) float64 { return r.WallTimeSec })
+	}
+}
+
+// counted reports whether r counted category c.
+func counted(r *profiler.Result, c string) bool {
+	for _, a := range profiler.CategoryNames {
+		if a == c {
+			return true
+		}
+	}
+	_, ok := r.Categories[c]
+	return ok
+}
+
+// statOf summarises f over rs; all fields are zero when rs is empty.
+func statOf(rs []*profiler.Result, f func(*profiler.Result) float64) stat {
+	if len(rs) == 0 {
+		return stat{}
+	}
+	s := stat{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, r := range rs {
+		v := f(r)
+		s.Mean += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	s.Mean /= float64(len(rs))
+	if len(rs) > 1 {
+		for _, r := range rs {
+			d := f(r) - s.Mean
+			s.Stddev += d * d
+		}
+		s.Stddev = math.Sqrt(s.Stddev / float64(len(rs)-1))
+	}
+	return s
+}
+
+// writeText renders one section per workload and a summary table of the
+// means.
+func (rep *batchReport) writeText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, wl := range rep.Workloads {
+		fmt.Fprintf(bw, "===== %s (%d of %d runs)", wl.Name, wl.Runs, wl.Runs+len(wl.Errors))
+		if len(wl.Labels) > 0 {
+			fmt.Fprintf(bw, " [%s]", strings.Join(wl.Labels, ", "))
+		}
+		fmt.Fprintf(bw, " =====\n%s\n", strings.Join(wl.Command, " "))
+		for _, f := range wl.Failures {
+			fmt.Fprintf(bw, "error: run %d (%s", f.Run, f.By)
+			if f.Exit != nil {
+				fmt.Fprintf(bw, ", %s", f.Exit)
+			}
+			fmt.Fprintf(bw, "): %s\n", f.Error)
+			if f.Output != nil && f.Output.Stderr != "" {
+				for _, l := range strings.Split(strings.TrimRight(f.Output.Stderr, "\n"), "\n") {
+					fmt.Fprintf(bw, "  | %s\n", l)
+				}
+			}
+		}
+		if wl.Runs > 0 {
+			fmt.Fprintf(bw, "  %-8s%16s%16s%16s%14s\n", "CATEGORY", "MEAN", "MIN", "MAX", "STDDEV")
+			for _, c := range rep.Categories {
+				if s, ok := wl.Stats[c]; ok {
+					writeStatRow(bw, strings.ToUpper(c), s, "%16.1f")
+				}
+			}
+			writeStatRow(bw, "WALL(s)", wl.WallTimeSec, "%16.3f")
+		}
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintf(bw, "----- Summary (mean per run) -----\n%-24s%6s", "WORKLOAD", "RUNS")
+	for _, c := range rep.Categories {
+		fmt.Fprintf(bw, "%14s", strings.ToUpper(c))
+	}
+	fmt.Fprintf(bw, "%10s\n", "WALL(s)")
+	for _, wl := range rep.Workloads {
+		fmt.Fprintf(bw, "%-24s%6d", wl.Name, wl.Runs)
+		for _, c := range rep.Categories {
+			if s, ok := wl.Stats[c]; ok {
+				fmt.Fprintf(bw, "%14.0f", s.Mean)
+			} else {
+				fmt.Fprintf(bw, "%14s", "-")
+			}
+		}
+		fmt.Fprintf(bw, "%10.3f\n", wl.WallTimeSec.Mean)
+	}
+	return bw.Flush()
+}
+
+func writeStatRow(w io.Writer, label string, s stat, num string) {
+	fmt.Fprintf(w, "  %-8s"+num+num+num+"%14.2f\n", label, s.Mean, s.Min, s.Max, s.Stddev)
+}
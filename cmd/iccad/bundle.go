@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const bundleUsage = "bundle [-o file.iccad] [-binary path] result.json | bundle -info file.iccad"
+
+// runBundle packs a saved report into a bundle, or describes one.
+func runBundle(args []string) int {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	out := fs.String("o", "", "bundle `file` (default: the report's name with .iccad)")
+	binary := fs.String("binary", "", "read symbols and build details from this `path` instead of the report's binary")
+	info := fs.Bool("info", false, "describe the bundle instead")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", bundleUsage)
+		return 2
+	}
+	if *info {
+		b, err := profiler.ReadBundle(fs.Arg(0))
+		if err != nil {
+			return fail("bundle", err)
+		}
+		printBundle(b)
+		return 0
+	}
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("bundle", err)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(fs.Arg(0), ".json") + ".iccad"
+	}
+	if err := writeBundle(*out, res, *binary); err != nil {
+		return fail("bundle", err)
+	}
+	return 0
+}
+
+// isBundle reports whether the output file path asks for a bundle.
+func isBundle(path string) bool { return strings.HasSuffix(path, ".iccad") }
+
+// writeBundle saves a bundle of res, its target read from binary (default
+// the report's), to path.
+func writeBundle(path string, res *profiler.Result, binary string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profiler.NewBundle(res, binary).WriteBundle(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printBundle(b *profiler.Bundle) {
+	m := b.Meta
+	fmt.Printf("Bundle v%d, written %s\n", m.Version, m.Created.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("Host:     %s (%s/%s, %d CPUs)\n", m.Host.Hostname, m.Host.OS, m.Host.Arch, m.Host.CPUs)
+	if m.Host.CPUModel != "" {
+		fmt.Printf("CPU:      %s\n", m.Host.CPUModel)
+	}
+	if m.Host.Kernel != "" {
+		fmt.Printf("Kernel:   %s\n", m.Host.Kernel)
+	}
+	t := m.Target
+	fmt.Printf("Target:   %s", t.Path)
+	if t.Size > 0 {
+		fmt.Printf(" (%d bytes, sha256 %s)", t.Size, t.SHA256)
+	}
+	fmt.Println()
+	if t.BuildID != "" {
+		fmt.Printf("Build ID: %s\n", t.BuildID)
+	}
+	for _, c := range t.Compiler {
+		fmt.Printf("Compiler: %s\n", c)
+	}
+	if t.Go != "" {
+		var set []string
+		for _, kv := range t.Build {
+			if k, v, _ := strings.Cut(kv, "="); v != "" && k != "DefaultGODEBUG" {
+				set = append(set, kv)
+			}
+		}
+		fmt.Printf("Go:       %s %s\n", t.Go, strings.Join(set, " "))
+	}
+	files := map[string]bool{}
+	lines := 0
+	for _, s := range b.Sources {
+		files[s.File] = true
+		lines += len(s.Text)
+	}
+	fmt.Printf("Symbols:  %d functions\n", len(b.Symbols))
+	fmt.Printf("Sources:  %d lines of %d files\n", lines, len(files))
+	fmt.Printf("Report:   %s, %s mode, %.3f s\n", b.Result.Binary.Path, b.Result.Mode, b.Result.WallTimeSec)
+}
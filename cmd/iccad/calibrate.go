@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const calibrateUsage = "calibrate [-force] [-o file] [run flags]"
+
+// runCalibrate measures the tool's overhead with the given run flags and
+// saves it where iccad run finds it.
+func runCalibrate(args []string) int {
+	fs := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	opts := runFlags(fs)
+	force := fs.Bool("force", false, "calibrate again even when a calibration for these options is saved")
+	out := fs.String("o", "", "calibration `file` (default $ICCAD_CALIBRATION or ~/.iccad/calibration.json)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", calibrateUsage)
+		return 2
+	}
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("calibrate", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	c, err := calibration(ctx, p, *out, *force, true)
+	if err != nil {
+		return fail("calibrate", err)
+	}
+	fmt.Printf("Calibration %s (%s)\n", c.Key, c.Time.Format("2006-01-02 15:04"))
+	fmt.Printf("start-up: %.3f s\nslowdown: %.1fx\n\n", c.StartupSec, c.Slowdown)
+	fmt.Printf("%-8s%12s%12s%10s\n", "KERNEL", "NATIVE_S", "PROFILED_S", "SLOWDOWN")
+	for _, k := range c.Kernels {
+		fmt.Printf("%-8s%12.3f%12.3f%9.1fx\n", k.Name, k.NativeSec, k.ProfiledSec, k.Slowdown)
+	}
+	return 0
+}
+
+// calibration returns p's saved calibration from path (default
+// profiler.DefaultCalibrationPath), calibrating and saving one first when
+// create is set and there is none or force is set; without create, a
+// missing one is nil. The kernels are this executable's own; start-up is
+// timed with true(1), whose start-up is closer to most targets' than a Go
+// program's.
+func calibration(ctx context.Context, p *profiler.Profiler, path string, force, create bool) (*profiler.Calibration, error) {
+	if path == "" {
+		var err error
+		if path, err = profiler.DefaultCalibrationPath(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := p.CalibrationKey()
+	if err != nil {
+		return nil, err
+	}
+	if !force {
+		if c, err := profiler.LoadCalibration(path, key); err != nil || c != nil || !create {
+			return c, err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "iccad: calibrating the tool's overhead for these options by timing 4 programs natively and under the tool, which takes minutes with heavy options (once; saved to %s)\n", path)
+	kernel := func(name string) []string { return []string{exe, "calibrate-kernel", name} }
+	empty := kernel("null")
+	if t, err := exec.LookPath("true"); err == nil {
+		empty = []string{t}
+	}
+	c, err := p.Calibrate(ctx, empty, kernel("null"), map[string][]string{
+		"int": kernel("int"), "mem": kernel("mem"), "call": kernel("call"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, profiler.SaveCalibration(path, c)
+}
+
+// Calibration kernels, each about 200ms natively so that they outweigh
+// the noise in start-up under the tool: integer arithmetic, streaming
+// memory reads, and function calls.
+const (
+	kernelIntIters  = 60_000_000
+	kernelMemWords  = 1 << 20
+	kernelMemPasses = 64
+	kernelCalls     = 40_000_000
+)
+
+//go:noinline
+func kernelLeaf(x uint64) uint64 { return x*2654435761 + 1 }
+
+// runKernel runs calibration kernel args[0] (hidden command
+// calibrate-kernel); its result is printed so the work is not optimized
+// away.
+func runKernel(args []string) int {
+	if len(args) != 1 {
+		return 2
+	}
+	var x uint64 = 1
+	switch args[0] {
+	case "null":
+		return 0
+	case "int":
+		for i := uint64(0); i < kernelIntIters; i++ {
+			x = x*6364136223846793005 + i
+			x ^= x >> 17
+		}
+	case "mem":
+		buf := make([]uint64, kernelMemWords)
+		for i := range buf {
+			buf[i] = uint64(i)
+		}
+		for p := 0; p < kernelMemPasses; p++ {
+			for _, v := range buf {
+				x += v
+			}
+		}
+	case "call":
+		for i := 0; i < kernelCalls; i++ {
+			x = kernelLeaf(x)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "iccad: unknown calibration kernel %q\n", args[0])
+		return 2
+	}
+	fmt.Println(strconv.FormatUint(x, 16))
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const checkUsage = "check -baseline file [-tolerance 5%] [-min-delta N] [-update] [-v] [run flags] [--] cmd [args…]"
+
+// runCheck profiles a workload and compares it against a saved baseline
+// report; the exit status is 1 when any counter regressed, so it can gate
+// CI jobs.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	opts := runFlags(fs)
+	baseline := fs.String("baseline", "", "baseline JSON report `file`")
+	tolerance := fs.String("tolerance", "5%", "fail when a counter grows by more than this `percentage`")
+	minDelta := fs.Uint64("min-delta", 0, "ignore changes of at most this many operations")
+	update := fs.Bool("update", false, "write the run's report to -baseline instead of comparing")
+	verbose := fs.Bool("v", false, "show the target's output (on stderr)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *baseline == "" || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", checkUsage)
+		return 2
+	}
+	pct, err := parsePercent(*tolerance)
+	if err != nil {
+		return fail("check", err)
+	}
+	var base *profiler.Result
+	if !*update {
+		if base, err = profiler.Load(*baseline); err != nil {
+			return fail("check", err)
+		}
+	}
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("check", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	res, err := p.Run(ctx, fs.Args())
+	if err != nil {
+		// a workload that fails must not pass the gate
+		return fail("check", err)
+	}
+
+	if *update {
+		f, err := os.Create(*baseline)
+		if err != nil {
+			return fail("check", err)
+		}
+		if err := res.WriteJSON(f); err != nil {
+			f.Close()
+			return fail("check", err)
+		}
+		if err := f.Close(); err != nil {
+			return fail("check", err)
+		}
+		fmt.Printf("baseline %s updated\n", *baseline)
+		return 0
+	}
+
+	if !opts.Funcs {
+		base.Functions = nil // compare functions only when this run has them
+	}
+	t := profiler.Thresholds{Pct: pct, MinAbs: *minDelta}
+	d := profiler.Compare(base, res)
+	if err := d.WriteText(os.Stdout, t); err != nil {
+		return fail("check", err)
+	}
+	if n := d.Regressions(t); n > 0 {
+		fmt.Printf("FAIL: %d counter(s) regressed against %s\n", n, *baseline)
+		return 1
+	}
+	fmt.Printf("ok: no counter grew by more than %.4g%% against %s\n", pct, *baseline)
+	return 0
+}
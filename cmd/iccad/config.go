@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configName is the file iccad run -profile reads its profiles from, in
+// the current directory or the nearest parent that has one, else in the
+// home directory; $ICCAD_CONFIG names another.
+const configName = ".iccad.yaml"
+
+// findConfig returns the path of the config file, "" when there is none.
+func findConfig() (string, error) {
+	if p := os.Getenv("ICCAD_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, configName)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		p := filepath.Join(home, configName)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// loadProfiles reads the profiles of the config file at path: the
+// mappings under its top-level profiles key, each from a flag name to its
+// value, or to a list of them for a repeatable flag.
+func loadProfiles(path string) (map[string]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	top, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	profiles := map[string]map[string]any{}
+	for k, v := range top {
+		if k != "profiles" {
+			return nil, fmt.Errorf("%s: unknown key %q", path, k)
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: profiles: want a mapping of profile names", path)
+		}
+		for name, p := range m {
+			pm, ok := p.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: profile %s: want a mapping of flags", path, name)
+			}
+			profiles[name] = pm
+		}
+	}
+	return profiles, nil
+}
+
+// profileFlag returns the flag.Func callback of -profile for fs: it sets
+// the flags of the named profile as if they stood where -profile does, so
+// flags after it override them and a profile's own profile key includes
+// another one first.
+func profileFlag(fs *flag.FlagSet) func(string) error {
+	var profiles map[string]map[string]any
+	var path string
+	var applying []string
+	var apply func(name string) error
+	apply = func(name string) error {
+		if profiles == nil {
+			var err error
+			if path, err = findConfig(); err != nil {
+				return err
+			}
+			if path == "" {
+				return fmt.Errorf("no %s in this directory, its parents or the home directory", configName)
+			}
+			if profiles, err = loadProfiles(path); err != nil {
+				return err
+			}
+		}
+		p, ok := profiles[name]
+		if !ok {
+			var names []string
+			for n := range profiles {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("no profile %q in %s (it has %s)", name, path, strings.Join(names, ", "))
+		}
+		for _, n := range applying {
+			if n == name {
+				return fmt.Errorf("profile %s includes itself", name)
+			}
+		}
+		applying = append(applying, name)
+		defer func() { applying = applying[:len(applying)-1] }()
+
+		keys := make([]string, 0, len(p))
+		for k := range p {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { // included profiles first
+			if keys[i] == "profile" || keys[j] == "profile" {
+				return keys[i] == "profile"
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			if fs.Lookup(k) == nil {
+				return fmt.Errorf("profile %s: -%s is not a flag of iccad %s", name, k, fs.Name())
+			}
+			vs, ok := p[k].([]any)
+			if !ok {
+				vs = []any{p[k]}
+			}
+			for _, v := range vs {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("profile %s: %s: want a value or a list of values", name, k)
+				}
+				if err := fs.Set(k, s); err != nil {
+					return fmt.Errorf("profile %s: -%s: %v", name, k, err)
+				}
+			}
+		}
+		return nil
+	}
+	return apply
+}
+
+// parseYAML parses the YAML subset config files use: block mappings and
+// sequences nested by indentation, flow sequences ([a, b]), plain, single-
+// and double-quoted scalars, and # comments. Scalars are returned as
+// strings, mappings as map[string]any and sequences as []any.
+func parseYAML(src string) (map[string]any, error) {
+	var lines []yamlLine
+	for i, l := range strings.Split(src, "\n") {
+		l = strings.TrimRight(l, " \r")
+		text := strings.TrimLeft(l, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		if text == "" || text[0] == '#' || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{n: i + 1, indent: len(l) - len(text), text: text})
+	}
+	p := &yamlParser{lines: lines}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("line %d: want a mapping at the top level", lines[0].n)
+	}
+	return m, nil
+}
+
+type yamlLine struct {
+	n, indent int
+	text      string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	n := p.lines[len(p.lines)-1].n
+	if p.pos < len(p.lines) {
+		n = p.lines[p.pos].n
+	}
+	return fmt.Errorf("line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// block parses the mapping or sequence whose lines are indented by
+// indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if l := p.lines[p.pos]; l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		var seq []any
+		for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+			l := p.lines[p.pos]
+			if l.text != "-" && !strings.HasPrefix(l.text, "- ") {
+				return nil, p.errorf("expected a sequence item")
+			}
+			var v any
+			var err error
+			if item := strings.TrimSpace(strings.TrimPrefix(l.text, "-")); item != "" {
+				v, err = p.scalar(item)
+				p.pos++
+			} else {
+				p.pos++
+				v, err = p.nested(indent, false)
+			}
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	}
+
+	m := map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		if strings.HasPrefix(l.text, "- ") {
+			return nil, p.errorf("unexpected sequence item")
+		}
+		key, rest, ok := strings.Cut(l.text, ":")
+		if !ok || (rest != "" && rest[0] != ' ') {
+			return nil, p.errorf("expected key: value")
+		}
+		key = strings.TrimSpace(key)
+		if k, err := p.unquote(key); err == nil {
+			key = k
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %s", key)
+		}
+		var v any
+		var err error
+		if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+			v, err = p.scalar(rest)
+			p.pos++
+		} else {
+			p.pos++
+			v, err = p.nested(indent, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// nested parses the block under a key or a bare sequence item at indent:
+// more indented, or for a key a sequence at the same indentation; an
+// empty value when there is none.
+func (p *yamlParser) nested(indent int, key bool) (any, error) {
+	if p.pos >= len(p.lines) {
+		return "", nil
+	}
+	l := p.lines[p.pos]
+	if l.indent > indent || (key && l.indent == indent && (l.text == "-" || strings.HasPrefix(l.text, "- "))) {
+		return p.block(l.indent)
+	}
+	return "", nil
+}
+
+// scalar parses a value on the line of its key or sequence dash: a flow
+// sequence, a quoted string or a plain one, up to a # comment.
+func (p *yamlParser) scalar(s string) (any, error) {
+	if s[0] == '[' {
+		end := strings.LastIndexByte(s, ']')
+		if end < 0 {
+			return nil, p.errorf("unterminated flow sequence")
+		}
+		if tail := strings.TrimSpace(s[end+1:]); tail != "" && tail[0] != '#' {
+			return nil, p.errorf("unexpected %q after ]", tail)
+		}
+		var seq []any
+		for _, item := range splitFlow(s[1:end]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := p.scalar(item)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		end := quoteEnd(s)
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		if tail := strings.TrimSpace(s[end+1:]); tail != "" && tail[0] != '#' {
+			return nil, p.errorf("unexpected %q after the string", tail)
+		}
+		return p.unquote(s[:end+1])
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// unquote returns the value of a quoted scalar.
+func (p *yamlParser) unquote(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", errors.New("not quoted")
+	}
+	switch s[0] {
+	case '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", p.errorf("bad string %s", s)
+		}
+		return v, nil
+	}
+	return "", errors.New("not quoted")
+}
+
+// quoteEnd returns the index of the quote closing the string s starts
+// with, -1 when there is none.
+func quoteEnd(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitFlow splits the items of a flow sequence at the commas outside
+// quotes.
+func splitFlow(s string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			if end := quoteEnd(s[i:]); end > 0 {
+				i += end
+			}
+		case ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const costUsage = "cost {-model file | -energy 7nm|16nm|file [-dram-fraction F | -dram-bytes N]} [-format text|json] [-o file] result.json…"
+
+// costReport is a priced run, a CostReport or an EnergyReport.
+type costReport interface {
+	WriteText(w io.Writer) error
+}
+
+// runCost prices one or more reports with a cost model, per op type and
+// per function, or estimates their energy by component with -energy; with
+// several reports it adds a per-workload summary.
+func runCost(args []string) int {
+	fs := flag.NewFlagSet("cost", flag.ContinueOnError)
+	model := fs.String("model", "", "JSON cost model `file`")
+	energy := fs.String("energy", "", "estimate energy with the built-in `model` of a node (7nm, 16nm) or a JSON model pricing energy_pj")
+	dramFraction := fs.Float64("dram-fraction", 1, "share of the bytes moved charged as DRAM traffic (-energy; default the simulated share of reports recorded with --cache)")
+	dramBytes := fs.Uint64("dram-bytes", 0, "measured DRAM traffic in `bytes`, e.g. from dram_profiler.sh; sets -dram-fraction (-energy, one report)")
+	format := fs.String("format", "text", "output `format`: text or json")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*model == "") == (*energy == "") || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", costUsage)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		return fail("cost", fmt.Errorf("unknown format %q", *format))
+	}
+	fractionSet := false
+	fs.Visit(func(f *flag.Flag) { fractionSet = fractionSet || f.Name == "dram-fraction" })
+	if *dramBytes > 0 && fs.NArg() > 1 {
+		return fail("cost", errors.New("-dram-bytes applies to a single report"))
+	}
+
+	var m *profiler.CostModel
+	var err error
+	switch {
+	case *model != "":
+		m, err = profiler.LoadCostModel(*model)
+	case strings.HasSuffix(*energy, ".json"):
+		m, err = profiler.LoadCostModel(*energy)
+	default:
+		m, err = profiler.EnergyModel(*energy)
+	}
+	if err != nil {
+		return fail("cost", err)
+	}
+
+	var reps []costReport
+	var totals [][]float64
+	var columns []string
+	for _, path := range fs.Args() {
+		res, err := profiler.Load(path)
+		if err != nil {
+			return fail("cost", err)
+		}
+		if *model != "" {
+			rep, err := res.Cost(m)
+			if err != nil {
+				return fail("cost", fmt.Errorf("%s: %v", path, err))
+			}
+			reps = append(reps, rep)
+			columns = m.Metrics
+			var t []float64
+			for _, name := range m.Metrics {
+				t = append(t, rep.Total[name])
+			}
+			totals = append(totals, t)
+			continue
+		}
+		frac := *dramFraction
+		switch {
+		case *dramBytes > 0:
+			if res.Memory == nil || res.Memory.Bytes() == 0 {
+				return fail("cost", fmt.Errorf("%s: -dram-bytes needs a report recorded with --mem", path))
+			}
+			frac = float64(*dramBytes) / float64(res.Memory.Bytes())
+		case !fractionSet && res.Cache != nil && res.Memory != nil && res.Memory.Bytes() > 0:
+			frac = float64(res.Memory.DRAMBytes()) / float64(res.Memory.Bytes())
+		}
+		rep, err := res.Energy(m, frac)
+		if err != nil {
+			return fail("cost", fmt.Errorf("%s: %v", path, err))
+		}
+		reps = append(reps, rep)
+		columns = []string{"compute_pj", "memory_pj", "total_pj"}
+		t := rep.Total
+		totals = append(totals, []float64{t.ALU + t.Mul + t.Div + t.Vec + t.FP, t.Access + t.DRAM, t.Total()})
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("cost", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		var v any = reps
+		if len(reps) == 1 {
+			v = reps[0]
+		}
+		err = enc.Encode(v)
+	} else {
+		err = writeCostText(w, reps, fs.Args(), columns, totals)
+	}
+	if err != nil {
+		return fail("cost", err)
+	}
+	return 0
+}
+
+// writeCostText prints each report, then with several of them a table of
+// the workloads' totals, labelled by report file.
+func writeCostText(w io.Writer, reps []costReport, files, columns []string, totals [][]float64) error {
+	for i, rep := range reps {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if err := rep.WriteText(w); err != nil {
+			return err
+		}
+	}
+	if len(reps) < 2 {
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "\n----- Workloads -----\n%-32s", "REPORT")
+	for _, name := range columns {
+		fmt.Fprintf(bw, "%18s", strings.ToUpper(name))
+	}
+	fmt.Fprintln(bw)
+	for i, t := range totals {
+		fmt.Fprintf(bw, "%-32s", files[i])
+		for _, v := range t {
+			fmt.Fprintf(bw, "%18.1f", v)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const debuginfoUsage = "debuginfo [-debug-dir dir] [-debuginfod urls] [-deps] binary…"
+
+// runDebuginfo prints where the separate debug info of each stripped
+// binary is, fetching it from the debuginfod servers into their client
+// cache when none is at hand, as iccad run -debuginfod does before a run.
+// With -deps the shared libraries the binaries load are looked up too. It
+// exits 1 when a stripped binary given has none.
+func runDebuginfo(args []string) int {
+	fs := flag.NewFlagSet("debuginfo", flag.ContinueOnError)
+	var dirs []string
+	fs.Func("debug-dir", "look for debug info in this `directory` before /usr/lib/debug (repeatable)", appendFlag(&dirs))
+	servers := fs.String("debuginfod", os.Getenv("DEBUGINFOD_URLS"), "debuginfod server `urls` to fetch from, space-separated (default $DEBUGINFOD_URLS)")
+	deps := fs.Bool("deps", false, "also look up the shared libraries the binaries load")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", debuginfoUsage)
+		return 2
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	status := 0
+	for _, bin := range fs.Args() {
+		paths := []string{bin}
+		if *deps {
+			paths = append(paths, profiler.Dependencies(bin)...)
+		}
+		for i, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				return fail("debuginfo", err)
+			}
+			if !profiler.Stripped(path) {
+				if i == 0 {
+					fmt.Printf("%s: not stripped\n", path)
+				}
+				continue
+			}
+			d, err := profiler.FetchDebugFile(ctx, path, dirs, strings.Fields(*servers))
+			switch {
+			case err == nil:
+				fmt.Printf("%s: %s (%s)\n", path, d.Path, d.Via)
+			case errors.Is(err, profiler.ErrNoDebugInfo):
+				fmt.Printf("%s: none\n", path)
+				if i == 0 {
+					status = 1
+				}
+			default:
+				return fail("debuginfo", err)
+			}
+		}
+	}
+	return status
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const diffUsage = "diff [-threshold 5%] [-min-delta N] runA.json runB.json"
+
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	threshold := fs.String("threshold", "5%", "highlight counters that grow by more than this `percentage`")
+	minDelta := fs.Uint64("min-delta", 0, "ignore changes of at most this many operations")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", diffUsage)
+		return 2
+	}
+
+	pct, err := parsePercent(*threshold)
+	if err != nil {
+		return fail("diff", err)
+	}
+	a, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("diff", err)
+	}
+	b, err := profiler.Load(fs.Arg(1))
+	if err != nil {
+		return fail("diff", err)
+	}
+
+	t := profiler.Thresholds{Pct: pct, MinAbs: *minDelta}
+	if err := profiler.Compare(a, b).WriteText(os.Stdout, t); err != nil {
+		return fail("diff", err)
+	}
+	return 0
+}
+
+// parsePercent accepts "5", "5%" or "0.5%".
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const foldedUsage = "folded [-weight list] result.json"
+
+// runFolded prints the collapsed stacks of a report recorded with
+// --callgraph, weighted by the chosen op types, for flamegraph.pl.
+func runFolded(args []string) int {
+	fs := flag.NewFlagSet("folded", flag.ContinueOnError)
+	weight := fs.String("weight", "int", "comma-separated op types or groups (int, bitwise, vec, wide, fp64, fp32, fp) to weight by")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", foldedUsage)
+		return 2
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("folded", err)
+	}
+	if res.CallGraph == nil {
+		return fail("folded", errors.New("report has no call graph (record with --callgraph)"))
+	}
+	if err := res.WriteFolded(os.Stdout, strings.Split(*weight, ",")...); err != nil {
+		return fail("folded", err)
+	}
+	return 0
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const goUsage = "go test [run flags] [-bench regexp] [-benchtime Nx] [-count N] [-format text|json] [-o file] [packages…]"
+
+// runGo wraps the go command: go test builds the test binaries of
+// packages and profiles their benchmarks, reporting the counts per
+// iteration in go test -bench's format.
+func runGo(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", goUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("go test", flag.ContinueOnError)
+	opts := runFlags(fs)
+	bench := fs.String("bench", ".", "profile the benchmarks matching this `regexp`")
+	benchtime := fs.String("benchtime", "100x", "iterations of the measured run, `N`x: each benchmark runs at N and at 2N iterations")
+	count := fs.Int("count", 1, "measure each benchmark `N` times, a line each for benchstat")
+	format := fs.String("format", "text", "report `format`: text (go test -bench lines) or json")
+	out := fs.String("o", "", "write the report to `file` instead of stdout")
+	verbose := fs.Bool("v", false, "show the output of go and of the test binaries (on stderr)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(*benchtime, "x"), 10, 64)
+	if err != nil || n == 0 || !strings.HasSuffix(*benchtime, "x") {
+		return fail("go", fmt.Errorf("-benchtime %q: want an iteration count such as 100x (times mean little under instrumentation)", *benchtime))
+	}
+	if *count < 1 || (*format != "text" && *format != "json") {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", goUsage)
+		return 2
+	}
+	if _, err := regexp.Compile(*bench); err != nil {
+		return fail("go", fmt.Errorf("-bench: %v", err))
+	}
+	pkgs := fs.Args()
+	if len(pkgs) == 0 {
+		pkgs = []string{"."}
+	}
+	opts.CallGraph = true
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+	ctx, stop := signalContext()
+	defer stop()
+
+	env, err := goOutput(ctx, "env", "GOOS", "GOARCH")
+	if err != nil {
+		return fail("go", err)
+	}
+	g := &profiler.GoBench{GOOS: runtime.GOOS, GOARCH: runtime.GOARCH}
+	if f := strings.Fields(env); len(f) == 2 {
+		g.GOOS, g.GOARCH = f[0], f[1]
+	}
+	list, err := goOutput(ctx, append([]string{"list", "-f", "{{.ImportPath}}\t{{.Dir}}"}, pkgs...)...)
+	if err != nil {
+		return fail("go", err)
+	}
+	tmp, err := os.MkdirTemp("", "iccad-gotest-")
+	if err != nil {
+		return fail("go", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	suffix := goProcsSuffix(opts.CPUs)
+	for i, line := range strings.Split(strings.TrimSpace(list), "\n") {
+		pkg, dir, _ := strings.Cut(line, "\t")
+		bin := filepath.Join(tmp, fmt.Sprintf("%d.test", i))
+		build := exec.CommandContext(ctx, "go", "test", "-c", "-o", bin, pkg)
+		var msg bytes.Buffer
+		build.Stdout, build.Stderr = &msg, &msg
+		if *verbose {
+			build.Stdout, build.Stderr = os.Stderr, os.Stderr
+		}
+		if err := build.Run(); err != nil {
+			return fail("go", fmt.Errorf("build %s: %v\n%s", pkg, err, msg.Bytes()))
+		}
+		if _, err := os.Stat(bin); err != nil {
+			fmt.Fprintf(os.Stderr, "iccad go: %s: no test files\n", pkg)
+			continue
+		}
+		names, err := goBenchmarks(ctx, bin, dir, *bench)
+		if err != nil {
+			return fail("go", fmt.Errorf("%s: %v", pkg, err))
+		}
+		o := *opts
+		o.Dir = dir
+		p, err := profiler.New(o)
+		if err != nil {
+			return fail("go", err)
+		}
+		for _, name := range names {
+			for r := 0; r < *count; r++ {
+				fmt.Fprintf(os.Stderr, "iccad go: %s %s\n", pkg, name)
+				var res [2]*profiler.Result
+				var err error
+				for k, iters := range []uint64{n, 2 * n} {
+					cmd := []string{bin, "-test.run=^$", "-test.bench=^" + name + "$",
+						fmt.Sprintf("-test.benchtime=%dx", iters), "-test.count=1"}
+					if res[k], err = p.Run(ctx, cmd); err != nil {
+						break
+					}
+				}
+				if ctx.Err() != nil {
+					return fail("go", ctx.Err())
+				}
+				if err == nil {
+					err = g.Add(pkg, name+suffix, n, res[0], res[1])
+				}
+				if err != nil {
+					g.AddError(pkg, name+suffix, err)
+					fmt.Fprintf(os.Stderr, "iccad go: %s %s: %v\n", pkg, name, err)
+				}
+			}
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("go", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(g)
+	} else {
+		err = g.WriteText(w)
+	}
+	if err != nil {
+		return fail("go", err)
+	}
+	if n := g.Failed(); n > 0 {
+		return fail("go", fmt.Errorf("%d of %d benchmark runs failed", n, len(g.Benchmarks)))
+	}
+	if len(g.Benchmarks) == 0 {
+		return fail("go", errors.New("no benchmarks matched"))
+	}
+	return 0
+}
+
+// goOutput runs the go command with args and returns its output.
+func goOutput(ctx context.Context, args ...string) (string, error) {
+	c := exec.CommandContext(ctx, "go", args...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("go %s: %v\n%s", args[0], err, stderr.Bytes())
+	}
+	return string(out), nil
+}
+
+// goBenchmarks lists the top-level benchmarks of the test binary bin,
+// run in dir, that match bench.
+func goBenchmarks(ctx context.Context, bin, dir, bench string) ([]string, error) {
+	re := regexp.MustCompile(bench)
+	c := exec.CommandContext(ctx, bin, "-test.list", "^Benchmark")
+	c.Dir = dir
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list benchmarks: %v", err)
+	}
+	var names []string
+	for _, name := range strings.Fields(string(out)) {
+		if strings.HasPrefix(name, "Benchmark") && re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// goProcsSuffix returns the -P suffix go test gives benchmark names,
+// from the GOMAXPROCS the test binary will run with: $GOMAXPROCS, else
+// the CPUs the run is pinned to or the machine has; none for 1.
+func goProcsSuffix(cpus []int) string {
+	procs := runtime.NumCPU()
+	if len(cpus) > 0 {
+		procs = len(cpus)
+	}
+	if n, err := strconv.Atoi(os.Getenv("GOMAXPROCS")); err == nil && n > 0 {
+		procs = n
+	}
+	if procs == 1 {
+		return ""
+	}
+	return "-" + strconv.Itoa(procs)
+}
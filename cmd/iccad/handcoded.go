@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const handcodedUsage = "handcoded [-format text|json] [-o file] result.json"
+
+// runHandcoded lists the functions of a report recorded with --funcs that
+// are hand-written assembly or use asm statements or intrinsics, with
+// their share of the run's operations.
+func runHandcoded(args []string) int {
+	fs := flag.NewFlagSet("handcoded", flag.ContinueOnError)
+	format := fs.String("format", "text", "output `format`: text or json")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", handcodedUsage)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		return fail("handcoded", fmt.Errorf("unknown format %q", *format))
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("handcoded", err)
+	}
+	hc, err := res.HandCoded()
+	if err != nil {
+		return fail("handcoded", err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("handcoded", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(hc)
+	} else {
+		err = hc.WriteText(w)
+	}
+	if err != nil {
+		return fail("handcoded", err)
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const historyUsage = "history [-store file] [-workload name [-since 30d|2006-01-02] [-commit prefix] [-ops list] [-format text|json|csv|svg] [-o file]]"
+
+// runHistory prints how a workload's operation counts trend across the
+// runs in the result store, or without -workload lists its workloads.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	path := fs.String("store", "", "store `file` (default $ICCAD_STORE or ~/.iccad/history.jsonl)")
+	workload := fs.String("workload", "", "workload `name` to trend")
+	since := fs.String("since", "", "only runs from the last `age` (30d, 2w, 12h) or since a date")
+	commit := fs.String("commit", "", "only runs of commits starting with `prefix`")
+	ops := fs.String("ops", "", "comma-separated op `list` to trend, as in csv output (default add,sub,mul,div)")
+	format := fs.String("format", "text", "output `format`: text, json, csv or svg")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", historyUsage)
+		return 2
+	}
+
+	s, err := openStore(*path)
+	if err != nil {
+		return fail("history", err)
+	}
+	q := profiler.HistoryQuery{Workload: *workload, Commit: *commit}
+	if *since != "" {
+		if q.Since, err = parseSince(*since); err != nil {
+			return fail("history", err)
+		}
+	}
+	entries, err := s.Query(q)
+	if err != nil {
+		return fail("history", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("history", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *workload == "" {
+		ws := profiler.Workloads(entries)
+		switch *format {
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(ws)
+		case "text":
+			tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(tw, "WORKLOAD\tRUNS\tFIRST\tLAST\tLAST COMMIT")
+			for _, x := range ws {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%.12s\n", x.Workload, x.Runs, x.First.Local().Format(time.DateTime), x.Last.Local().Format(time.DateTime), x.LastCommit)
+			}
+			err = tw.Flush()
+		default:
+			return fail("history", fmt.Errorf("format %q needs -workload", *format))
+		}
+		if err != nil {
+			return fail("history", err)
+		}
+		return 0
+	}
+
+	var list []string
+	if *ops != "" {
+		list = strings.Split(*ops, ",")
+	}
+	t, err := profiler.NewTrend(entries, list)
+	if err != nil {
+		return fail("history", err)
+	}
+	var write func(io.Writer) error
+	switch *format {
+	case "text":
+		write = t.WriteText
+	case "json":
+		write = t.WriteJSON
+	case "csv":
+		write = t.WriteCSV
+	case "svg":
+		write = t.WriteSVG
+	default:
+		return fail("history", fmt.Errorf("unknown format %q", *format))
+	}
+	if err := write(w); err != nil {
+		return fail("history", err)
+	}
+	return 0
+}
+
+// parseSince turns an age such as 30d, 2w or 90m, or a date, into the
+// time to query from.
+func parseSince(s string) (time.Time, error) {
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'w') {
+		if v, err := strconv.ParseFloat(s[:n-1], 64); err == nil && v >= 0 {
+			day := 24 * time.Hour
+			if s[n-1] == 'w' {
+				day *= 7
+			}
+			return time.Now().Add(-time.Duration(v * float64(day))), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -since %q: want an age (30d, 2w, 12h) or a date", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const kernelsUsage = "kernels [-top N] [-min-share F] [-o file] result.json"
+
+// runKernels writes the kernel descriptors of a report's hot functions,
+// for accelerator and simulator models to import.
+func runKernels(args []string) int {
+	fs := flag.NewFlagSet("kernels", flag.ContinueOnError)
+	top := fs.Int("top", 10, "describe at most `N` functions, the most ops first (0: all)")
+	minShare := fs.Float64("min-share", 0.01, "leave out functions with less than this `fraction` of the run's ops")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", kernelsUsage)
+		return 2
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("kernels", err)
+	}
+	kd, err := res.Kernels(*top, *minShare)
+	if err != nil {
+		return fail("kernels", err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("kernels", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := kd.WriteJSON(w); err != nil {
+		return fail("kernels", err)
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// logHandler is the slog.Handler of the -log-level, -log-format and -log
+// flags. The flags are parsed after runFlags hands out the logger, so the
+// handler writing the records is made at the first one.
+type logHandler struct {
+	level  slog.LevelVar
+	format string
+	w      io.Writer
+	once   sync.Once
+	h      slog.Handler
+}
+
+// logFlags registers the logging flags on fs and returns the logger they
+// configure: warnings and up, as text on stderr, by default.
+func logFlags(fs *flag.FlagSet) *slog.Logger {
+	l := &logHandler{format: "text", w: os.Stderr}
+	l.level.Set(slog.LevelWarn)
+	fs.Func("log-level", "log the diagnostics and steps of the run from this `level` up: debug, info, warn or error (default warn)", func(v string) error {
+		return l.level.UnmarshalText([]byte(v))
+	})
+	fs.Func("log-format", "log `format`: text or json (default text)", func(v string) error {
+		if v != "text" && v != "json" {
+			return fmt.Errorf("unknown log format %q", v)
+		}
+		l.format = v
+		return nil
+	})
+	fs.Func("log", "append the log to `file` instead of stderr", func(v string) error {
+		f, err := os.OpenFile(v, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		l.w = f
+		return nil
+	})
+	return slog.New(l)
+}
+
+// handler returns the handler the flags ask for.
+func (l *logHandler) handler() slog.Handler {
+	l.once.Do(func() {
+		opts := &slog.HandlerOptions{Level: &l.level}
+		if l.format == "json" {
+			l.h = slog.NewJSONHandler(l.w, opts)
+		} else {
+			l.h = slog.NewTextHandler(l.w, opts)
+		}
+	})
+	return l.h
+}
+
+func (l *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= l.level.Level()
+}
+
+func (l *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	return l.handler().Handle(ctx, r)
+}
+
+func (l *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return l.handler().WithAttrs(attrs)
+}
+
+func (l *logHandler) WithGroup(name string) slog.Handler {
+	return l.handler().WithGroup(name)
+}
@@ -0,0 +1,111 @@
+// Command iccad works with Int64Profiler results.
+//
+// Usage:
+//
+//	iccad <command> [flags] [args…]
+//
+// Commands:
+//
+//	run       profile a workload
+//	diff      compare two JSON result files
+//	matrix    compare the operation mix of several builds of a workload
+//	check     fail when a workload's counts regress against a baseline
+//	batch     profile the workloads of a manifest and aggregate the runs
+//	source    annotate source files with per-line counts
+//	annotate  print a function's disassembly with per-instruction counts
+//	folded    print collapsed stacks for flamegraphs
+//	roofline  plot functions against a machine's roofline
+//	kernels   export the hot functions as kernel descriptors for accelerator models
+//	handcoded find the hand-written assembly and intrinsics of a run
+//	cost      estimate a workload's cost or energy with a cost model
+//	stats     summarize the spread of counters over repeated runs
+//	sweep     show how counts scale over the runs of a parameter grid
+//	replay    rerun a recorded workload and check it reproduces
+//	report    render a saved report as text, CSV, TSV, HTML, pprof or DOT
+//	tui       browse a report's functions and call trees interactively
+//	agent     serve profiling sessions to remote controllers
+//	remote    start, watch and fetch sessions on an agent
+//	store     append reports to the local result store
+//	history   show how a workload's counts trend across stored runs
+//	calibrate measure the tool's own overhead for a set of run flags
+//	bundle    pack a report with its machine, symbols and sources
+//	go        profile the benchmarks of Go packages, per iteration
+//	migrate   upgrade reports of an older schema to the current one
+//	redact    replace a report's names with tokens for sharing it
+//	debuginfo find or fetch the separate debug info of stripped binaries
+//	similar   find the stored workloads with the most similar operation mix
+//	selftest  check the counts of the example workloads against their fixtures
+//	verify    compare a backend's counts with the hardware counters of a run
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"run":       {runRun, runUsage},
+	"diff":      {runDiff, diffUsage},
+	"matrix":    {runMatrix, matrixUsage},
+	"check":     {runCheck, checkUsage},
+	"batch":     {runBatch, batchUsage},
+	"source":    {runSource, sourceUsage},
+	"annotate":  {runAnnotate, annotateUsage},
+	"folded":    {runFolded, foldedUsage},
+	"roofline":  {runRoofline, rooflineUsage},
+	"kernels":   {runKernels, kernelsUsage},
+	"handcoded": {runHandcoded, handcodedUsage},
+	"cost":      {runCost, costUsage},
+	"stats":     {runStats, statsUsage},
+	"sweep":     {runSweep, sweepUsage},
+	"replay":    {runReplay, replayUsage},
+	"report":    {runReport, reportUsage},
+	"tui":       {runTUI, tuiUsage},
+	"agent":     {runAgent, agentUsage},
+	"remote":    {runRemote, remoteUsage},
+	"store":     {runStore, storeUsage},
+	"history":   {runHistory, historyUsage},
+	"calibrate": {runCalibrate, calibrateUsage},
+	"bundle":    {runBundle, bundleUsage},
+	"go":        {runGo, goUsage},
+	"migrate":   {runMigrate, migrateUsage},
+	"redact":    {runRedact, redactUsage},
+	"debuginfo": {runDebuginfo, debuginfoUsage},
+	"similar":   {runSimilar, similarUsage},
+	"selftest":  {runSelftest, selftestUsage},
+	"verify":    {runVerify, verifyUsage},
+	// run by calibrate, not listed
+	"calibrate-kernel": {runKernel, ""},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "iccad: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: iccad <command> [flags] [args…]\n\nCommands:")
+	for _, name := range []string{"run", "diff", "matrix", "check", "batch", "source", "annotate", "folded", "roofline", "kernels", "handcoded", "cost", "stats", "sweep", "replay", "report", "tui", "agent", "remote", "store", "history", "similar", "calibrate", "bundle", "go", "migrate", "redact", "debuginfo", "selftest", "verify"} {
+		fmt.Fprintf(os.Stderr, "  iccad %s\n", commands[name].usage)
+	}
+}
+
+// fail prints err prefixed with the command name and returns exit code 1.
+func fail(cmd string, err error) int {
+	fmt.Fprintf(os.Stderr, "iccad %s: %v\n", cmd, err)
+	return 1
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// workload is one [[workload]] table of a batch manifest.
+type workload struct {
+	Name        string
+	Command     string
+	Args        []string
+	Env         []string // KEY=VALUE, added to the environment
+	Repetitions int
+	Labels      []string
+	Options     []string // iccad run flags
+}
+
+// manifest is a parsed batch manifest. Top-level repetitions and options
+// are the defaults of every workload; Dir is the manifest's directory,
+// which relative commands are resolved against and workloads run in.
+type manifest struct {
+	Dir       string
+	Workloads []workload
+}
+
+// loadManifest reads a TOML batch manifest.
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	top, tables, err := parseTOML(string(data), "workload")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	m := &manifest{Dir: filepath.Dir(path)}
+	if abs, err := filepath.Abs(m.Dir); err == nil {
+		m.Dir = abs
+	}
+	var def workload
+	def.Repetitions = 1
+	if err := decodeWorkload(top, &def, true); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, t := range tables {
+		w := workload{Repetitions: def.Repetitions, Options: def.Options}
+		if err := decodeWorkload(t, &w, false); err != nil {
+			return nil, fmt.Errorf("%s: workload %d: %v", path, i+1, err)
+		}
+		if w.Command == "" {
+			return nil, fmt.Errorf("%s: workload %d: missing command", path, i+1)
+		}
+		if w.Name == "" {
+			w.Name = filepath.Base(w.Command)
+		}
+		if w.Repetitions < 1 {
+			return nil, fmt.Errorf("%s: workload %s: repetitions must be at least 1", path, w.Name)
+		}
+		if strings.ContainsRune(w.Command, '/') && !filepath.IsAbs(w.Command) {
+			w.Command = filepath.Join(m.Dir, w.Command)
+		}
+		m.Workloads = append(m.Workloads, w)
+	}
+	if len(m.Workloads) == 0 {
+		return nil, fmt.Errorf("%s: no [[workload]] tables", path)
+	}
+	return m, nil
+}
+
+// decodeWorkload copies the keys of t into w; top restricts them to the
+// ones allowed at the top level.
+func decodeWorkload(t map[string]any, w *workload, top bool) error {
+	for k, v := range t {
+		var err error
+		switch k {
+		case "repetitions":
+			n, ok := v.(int64)
+			if !ok {
+				err = fmt.Errorf("want an integer")
+			}
+			w.Repetitions = int(n)
+		case "options":
+			w.Options, err = tomlStrings(v)
+		case "name", "command":
+			if top {
+				return fmt.Errorf("%s is only valid in a [[workload]] table", k)
+			}
+			s, ok := v.(string)
+			if !ok {
+				err = fmt.Errorf("want a string")
+			}
+			if k == "name" {
+				w.Name = s
+			} else {
+				w.Command = s
+			}
+		case "args", "env", "labels":
+			if top {
+				return fmt.Errorf("%s is only valid in a [[workload]] table", k)
+			}
+			var ss []string
+			ss, err = tomlStrings(v)
+			switch k {
+			case "args":
+				w.Args = ss
+			case "env":
+				w.Env = ss
+			default:
+				w.Labels = ss
+			}
+		default:
+			return fmt.Errorf("unknown key %q", k)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func tomlStrings(v any) ([]string, error) {
+	a, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("want an array of strings")
+	}
+	ss := make([]string, len(a))
+	for i, e := range a {
+		if ss[i], ok = e.(string); !ok {
+			return nil, fmt.Errorf("want an array of strings")
+		}
+	}
+	return ss, nil
+}
+
+// parseTOML parses the TOML subset batch manifests use: key = value pairs
+// at the top level and in [[array]] tables, with string, integer, float,
+// boolean and (possibly multi-line) array values, and # comments. It
+// returns the top-level keys and the tables of the one array allowed.
+func parseTOML(src, array string) (map[string]any, []map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	top := map[string]any{}
+	var tables []map[string]any
+	cur := top
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return top, tables, nil
+		}
+		if strings.HasPrefix(p.src[p.pos:], "[[") {
+			end := strings.Index(p.src[p.pos:], "]]")
+			if end < 0 {
+				return nil, nil, p.errorf("unterminated table header")
+			}
+			name := strings.TrimSpace(p.src[p.pos+2 : p.pos+end])
+			if name != array {
+				return nil, nil, p.errorf("unknown table [[%s]]", name)
+			}
+			p.pos += end + 2
+			cur = map[string]any{}
+			tables = append(tables, cur)
+		} else if p.src[p.pos] == '[' {
+			return nil, nil, p.errorf("only [[%s]] tables are supported", array)
+		} else {
+			key := p.key()
+			if key == "" {
+				return nil, nil, p.errorf("expected a key")
+			}
+			p.skipSpace(false)
+			if p.eof() || p.src[p.pos] != '=' {
+				return nil, nil, p.errorf("expected '=' after %s", key)
+			}
+			p.pos++
+			v, err := p.value()
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, dup := cur[key]; dup {
+				return nil, nil, p.errorf("duplicate key %s", key)
+			}
+			cur[key] = v
+		}
+		p.skipSpace(false)
+		if !p.eof() && p.src[p.pos] != '\n' {
+			return nil, nil, p.errorf("unexpected %q", p.src[p.pos])
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips blanks and comments, and newlines too when nl is set.
+func (p *tomlParser) skipSpace(nl bool) {
+	for !p.eof() {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+		case c == '\n' && nl:
+			p.line++
+		case c == '#':
+			for !p.eof() && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) key() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.src[p.pos]
+		if !(c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *tomlParser) value() (any, error) {
+	p.skipSpace(false)
+	if p.eof() {
+		return nil, p.errorf("missing value")
+	}
+	switch c := p.src[p.pos]; c {
+	case '"', '\'':
+		return p.str(c)
+	case '[':
+		p.pos++
+		var a []any
+		for {
+			p.skipSpace(true)
+			if p.eof() {
+				return nil, p.errorf("unterminated array")
+			}
+			if p.src[p.pos] == ']' {
+				p.pos++
+				return a, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+			p.skipSpace(true)
+			if !p.eof() && p.src[p.pos] == ',' {
+				p.pos++
+			} else if p.eof() || p.src[p.pos] != ']' {
+				return nil, p.errorf("expected ',' or ']' in array")
+			}
+		}
+	}
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]#", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	tok := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(tok, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", p.src[start:p.pos])
+}
+
+// str parses a basic ("…", with escapes) or literal ('…') string.
+func (p *tomlParser) str(q byte) (string, error) {
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == q:
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("newline in string")
+		case c == '\\' && q == '"':
+			if p.eof() {
+				break
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(e)
+			default:
+				return "", p.errorf("unsupported escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
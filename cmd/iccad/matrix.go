@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const matrixUsage = "matrix [run flags] [-format text|json|csv|tsv] [-o file] [-v] {-build [label=]binary | -report label=result.json}… [--] [args…]"
+
+// matrixBuild is one column of a comparison matrix: a binary to run with
+// the workload's arguments, or a saved report of one.
+type matrixBuild struct {
+	label, binary, report string
+}
+
+// runMatrix profiles several builds of one workload, the same arguments
+// and counting options for each, and prints their counts side by side.
+func runMatrix(args []string) int {
+	fs := flag.NewFlagSet("matrix", flag.ContinueOnError)
+	opts := runFlags(fs)
+	var builds []matrixBuild
+	fs.Func("build", "run this `binary`, labelled label= or by its name (repeatable, in column order)", func(v string) error {
+		label, path, ok := strings.Cut(v, "=")
+		if !ok {
+			label, path = filepath.Base(v), v
+		}
+		builds = append(builds, matrixBuild{label: label, binary: path})
+		return nil
+	})
+	fs.Func("report", "use this saved `label=result.json` as a column instead of running a build (repeatable)", func(v string) error {
+		label, path, ok := strings.Cut(v, "=")
+		if !ok {
+			label, path = strings.TrimSuffix(filepath.Base(v), ".json"), v
+		}
+		builds = append(builds, matrixBuild{label: label, report: path})
+		return nil
+	})
+	format := fs.String("format", "text", "output `format`: text, json, csv or tsv")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	verbose := fs.Bool("v", false, "show the workload's output (on stderr)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(builds) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", matrixUsage)
+		return 2
+	}
+	switch *format {
+	case "text", "json", "csv", "tsv":
+	default:
+		return fail("matrix", fmt.Errorf("unknown format %q", *format))
+	}
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("matrix", err)
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	var labels []string
+	var results []*profiler.Result
+	for i, b := range builds {
+		var res *profiler.Result
+		if b.report != "" {
+			res, err = profiler.Load(b.report)
+		} else {
+			fmt.Fprintf(os.Stderr, "iccad matrix: build %d/%d: %s (%s)\n", i+1, len(builds), b.label, b.binary)
+			res, err = p.Run(ctx, append([]string{b.binary}, fs.Args()...))
+		}
+		if err != nil {
+			return fail("matrix", fmt.Errorf("%s: %w", b.label, err))
+		}
+		labels = append(labels, b.label)
+		results = append(results, res)
+	}
+	m, err := profiler.NewMatrix(labels, results)
+	if err != nil {
+		return fail("matrix", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("matrix", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m)
+	case "csv":
+		err = m.WriteCSV(w, ',')
+	case "tsv":
+		err = m.WriteCSV(w, '\t')
+	default:
+		err = m.WriteText(w)
+	}
+	if err != nil {
+		return fail("matrix", err)
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+// serveMetrics serves m at http://addr/metrics until the returned func is
+// called.
+func serveMetrics(addr string, m *profiler.Metrics) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	fmt.Fprintf(os.Stderr, "iccad run: serving metrics at http://%s/metrics\n", ln.Addr())
+	return func() { srv.Close() }, nil
+}
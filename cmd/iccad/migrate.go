@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const migrateUsage = "migrate [-w | -o file | -check] result.json…"
+
+// runMigrate upgrades JSON reports written with an older schema to the
+// current one.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	write := fs.Bool("w", false, "rewrite the files in place")
+	out := fs.String("o", "", "write the upgraded report to `file` instead of stdout")
+	check := fs.Bool("check", false, "only list the files an upgrade would change, failing if there are any")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (!*write && !*check && fs.NArg() != 1) || (*out != "" && (*write || *check)) {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", migrateUsage)
+		return 2
+	}
+
+	stale := 0
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fail("migrate", err)
+		}
+		up, from, err := profiler.Migrate(data)
+		if err != nil {
+			return fail("migrate", fmt.Errorf("%s: %w", path, err))
+		}
+		if from != profiler.SchemaVersion {
+			stale++
+			fmt.Fprintf(os.Stderr, "iccad migrate: %s: schema version %d → %d\n", path, from, profiler.SchemaVersion)
+		}
+		switch {
+		case *check:
+		case *write:
+			if from != profiler.SchemaVersion {
+				if err := replaceFile(path, up); err != nil {
+					return fail("migrate", err)
+				}
+			}
+		case *out != "":
+			if err := os.WriteFile(*out, up, 0o644); err != nil {
+				return fail("migrate", err)
+			}
+		default:
+			os.Stdout.Write(up)
+		}
+	}
+	if *check && stale > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replaceFile atomically replaces the contents of the file at path.
+func replaceFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".migrate-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if st, err := os.Stat(path); err == nil {
+		os.Chmod(f.Name(), st.Mode().Perm())
+	}
+	return os.Rename(f.Name(), path)
+}
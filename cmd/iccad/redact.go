@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const redactUsage = "redact -map names.json [-restore] [-o file] result.json"
+
+// runRedact replaces the function, image and source file names of a
+// report with tokens for sharing it, or maps a redacted report's tokens
+// back with -restore. The mapping file holds the key and the names; it is
+// created on first use and extended by every report redacted with it.
+func runRedact(args []string) int {
+	flags := flag.NewFlagSet("redact", flag.ContinueOnError)
+	mapping := flags.String("map", "", "the mapping `file` of tokens to names, created when missing")
+	restore := flags.Bool("restore", false, "map a redacted report's tokens back to the names")
+	out := flags.String("o", "", "write the report to `file` instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *mapping == "" {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", redactUsage)
+		return 2
+	}
+
+	x, err := profiler.LoadRedaction(*mapping)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && !*restore:
+		if x, err = profiler.NewRedaction(); err != nil {
+			return fail("redact", err)
+		}
+	case err != nil:
+		return fail("redact", err)
+	}
+	res, err := profiler.Load(flags.Arg(0))
+	if err != nil {
+		return fail("redact", err)
+	}
+	if *restore {
+		res, err = x.Restore(res)
+	} else {
+		res, err = x.Redact(res)
+	}
+	if err != nil {
+		return fail("redact", err)
+	}
+	if !*restore {
+		if err := x.Save(*mapping); err != nil {
+			return fail("redact", err)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("redact", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := res.WriteJSON(w); err != nil {
+		return fail("redact", err)
+	}
+	return 0
+}
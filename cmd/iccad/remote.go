@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abe5240/iccad/agent"
+	"github.com/abe5240/iccad/profiler"
+)
+
+const remoteUsage = "remote [-agent url] [-token token] {start [run flags] [-wait [-format f] [-o file]] {[--] cmd [args…] | -attach pid | -container ref} | list | status id | watch [-stream-format tui|jsonl] id | stop id | report [-format f] [-layout l] [-o file] [-wait] id | rm id}"
+
+// runRemote drives the sessions of an iccad agent.
+func runRemote(args []string) int {
+	fs := flag.NewFlagSet("remote", flag.ContinueOnError)
+	addr := fs.String("agent", envOr("ICCAD_AGENT", "localhost:7070"), "agent `url` (default $ICCAD_AGENT or localhost:7070)")
+	token := fs.String("token", os.Getenv("ICCAD_AGENT_TOKEN"), "agent bearer `token` (default $ICCAD_AGENT_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+		return 2
+	}
+	c := &agent.Client{URL: *addr, Token: *token}
+	ctx, stop := signalContext()
+	defer stop()
+
+	verb, rest := fs.Arg(0), fs.Args()[1:]
+	switch verb {
+	case "start":
+		return remoteStart(ctx, c, rest)
+	case "list":
+		ss, err := c.Sessions(ctx)
+		if err != nil {
+			return fail("remote", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATE\tSTARTED\tTARGET")
+		for _, s := range ss {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.ID, s.State, s.Started.Format(time.DateTime), sessionTarget(s))
+		}
+		tw.Flush()
+		return 0
+	case "report":
+		return remoteReport(ctx, c, rest)
+	case "watch":
+		wfs := flag.NewFlagSet("remote watch", flag.ContinueOnError)
+		format := wfs.String("stream-format", "tui", "snapshot `format`: tui (redrawn screen) or jsonl (one JSON object per line)")
+		if err := wfs.Parse(rest); err != nil {
+			return 2
+		}
+		if wfs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+			return 2
+		}
+		if err := c.Watch(ctx, wfs.Arg(0), snapshotPrinter(os.Stdout, *format)); err != nil && !errors.Is(err, context.Canceled) {
+			return fail("remote", err)
+		}
+		return 0
+	}
+
+	if len(rest) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+		return 2
+	}
+	var s *agent.Session
+	var err error
+	switch verb {
+	case "status":
+		s, err = c.Session(ctx, rest[0])
+	case "stop":
+		s, err = c.Stop(ctx, rest[0])
+	case "rm":
+		err = c.Delete(ctx, rest[0])
+	default:
+		fmt.Fprintf(os.Stderr, "iccad remote: unknown command %q\n", verb)
+		fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+		return 2
+	}
+	if err != nil {
+		return fail("remote", err)
+	}
+	if s != nil {
+		printSession(os.Stdout, s)
+	}
+	return 0
+}
+
+// remoteStart starts a session and prints its ID, or with -wait follows
+// it like iccad run: showing the snapshots of a -stream session and
+// writing the report. Ctrl-C while waiting stops the session.
+func remoteStart(ctx context.Context, c *agent.Client, args []string) int {
+	fs := flag.NewFlagSet("remote start", flag.ContinueOnError)
+	opts := runFlags(fs)
+	attach := fs.Int("attach", 0, "attach to the agent machine's process `pid`")
+	container := fs.String("container", "", "attach to the first process of the container `ref` on the agent machine")
+	fs.DurationVar(&opts.Duration, "duration", 0, "with -attach or -container, detach after this long")
+	fs.DurationVar(&opts.Stream, "stream", 0, "take a snapshot of the counts every `interval`")
+	wait := fs.Bool("wait", false, "wait for the session to end and write its report")
+	format := fs.String("format", "text", "with -wait, report `format`: text, json, csv, tsv, html, pprof or dot")
+	layout := fs.String("layout", profiler.LayoutLong, "csv/tsv `layout`: long or wide")
+	out := fs.String("o", "", "with -wait, write the report to `file` instead of stdout")
+	streamFormat := fs.String("stream-format", "tui", "with -wait and -stream, snapshot `format`: tui or jsonl")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	targets := 0
+	for _, set := range []bool{fs.NArg() > 0, *attach != 0, *container != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+		return 2
+	}
+	if err := checkFormat(*format, *layout); err != nil {
+		return fail("remote", err)
+	}
+
+	s, err := c.Start(ctx, agent.Request{Options: *opts, Cmd: fs.Args(), Pid: *attach, Container: *container})
+	if err != nil {
+		return fail("remote", err)
+	}
+	if !*wait {
+		fmt.Println(s.ID)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "iccad remote: session %s started\n", s.ID)
+
+	// Ctrl-C stops the session; its report is still fetched
+	go func() {
+		<-ctx.Done()
+		c.Stop(context.Background(), s.ID)
+	}()
+	bg := context.Background()
+	if opts.Stream != 0 {
+		if err := c.Watch(bg, s.ID, snapshotPrinter(os.Stderr, *streamFormat)); err != nil {
+			return fail("remote", err)
+		}
+	}
+	res, err := c.Report(bg, s.ID, true)
+	if err != nil {
+		return fail("remote", err)
+	}
+	if err := writeReportFile(*out, res, *format, *layout); err != nil {
+		return fail("remote", err)
+	}
+	if s, err := c.Session(bg, s.ID); err == nil && s.Error != "" {
+		return fail("remote", errors.New(s.Error))
+	}
+	return 0
+}
+
+// remoteReport writes a session's report.
+func remoteReport(ctx context.Context, c *agent.Client, args []string) int {
+	fs := flag.NewFlagSet("remote report", flag.ContinueOnError)
+	format := fs.String("format", "text", "report `format`: text, json, csv, tsv, html, pprof or dot")
+	layout := fs.String("layout", profiler.LayoutLong, "csv/tsv `layout`: long or wide")
+	out := fs.String("o", "", "write the report to `file` instead of stdout")
+	wait := fs.Bool("wait", false, "wait for a running session to end")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", remoteUsage)
+		return 2
+	}
+	if err := checkFormat(*format, *layout); err != nil {
+		return fail("remote", err)
+	}
+	res, err := c.Report(ctx, fs.Arg(0), *wait)
+	if err != nil {
+		return fail("remote", err)
+	}
+	if err := writeReportFile(*out, res, *format, *layout); err != nil {
+		return fail("remote", err)
+	}
+	return 0
+}
+
+// writeReportFile writes res to path, or to stdout when path is empty.
+func writeReportFile(path string, res *profiler.Result, format, layout string) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeReport(w, res, format, layout)
+}
+
+// sessionTarget describes what a session profiles.
+func sessionTarget(s agent.Session) string {
+	switch {
+	case s.Container != "":
+		return fmt.Sprintf("container %s (pid %d)", s.Container, s.Pid)
+	case s.Pid != 0:
+		return fmt.Sprintf("pid %d", s.Pid)
+	}
+	return strings.Join(s.Cmd, " ")
+}
+
+func printSession(w io.Writer, s *agent.Session) {
+	fmt.Fprintf(w, "session %s: %s\n", s.ID, s.State)
+	fmt.Fprintf(w, "  target:    %s\n", sessionTarget(*s))
+	fmt.Fprintf(w, "  started:   %s\n", s.Started.Format(time.DateTime))
+	if s.Finished != nil {
+		fmt.Fprintf(w, "  finished:  %s (%.1fs)\n", s.Finished.Format(time.DateTime), s.Finished.Sub(s.Started).Seconds())
+	}
+	if s.Stopped {
+		fmt.Fprintln(w, "  stopped by request")
+	}
+	if s.Snapshots > 0 {
+		fmt.Fprintf(w, "  snapshots: %d\n", s.Snapshots)
+	}
+	if s.Error != "" {
+		fmt.Fprintf(w, "  error:     %s\n", s.Error)
+	}
+}
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
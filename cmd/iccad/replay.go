@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const replayUsage = "replay [-format text|json|csv|tsv|html|pprof|dot] [-layout long|wide] [-o file] [-v] dir"
+
+// runReplay reruns a workload saved with iccad run -record and checks it
+// against the recording. It exits 1 when the counts or system calls
+// differ, after printing the replay's report.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	format := fs.String("format", "text", "report `format`: text, json, csv, tsv, html, pprof or dot")
+	layout := fs.String("layout", profiler.LayoutLong, "csv/tsv `layout`: long (one row per count) or wide (one row per function)")
+	out := fs.String("o", "", "write the report to `file` instead of stdout")
+	verbose := fs.Bool("v", false, "show the target's output (on stderr)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", replayUsage)
+		return 2
+	}
+	if err := checkFormat(*format, *layout); err != nil {
+		return fail("replay", err)
+	}
+
+	rec, err := profiler.LoadRecording(fs.Arg(0))
+	if err != nil {
+		return fail("replay", err)
+	}
+	var opts profiler.Options
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+	p, err := profiler.New(opts)
+	if err != nil {
+		return fail("replay", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	res, runErr := p.Replay(ctx, rec)
+	if res == nil {
+		return fail("replay", runErr)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("replay", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeReport(w, res, *format, *layout); err != nil {
+		return fail("replay", err)
+	}
+	if runErr != nil {
+		return fail("replay", runErr)
+	}
+	fmt.Fprintf(os.Stderr, "iccad replay: matches recording %.12s\n", rec.Hash)
+	return 0
+}
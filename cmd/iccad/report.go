@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const reportUsage = "report [-format text|json|csv|tsv|html|pprof|dot] [-layout long|wide] [-names demangled|raw|both] [-top N] [-gpu gpu.json] [-o file] result.json"
+
+// runReport renders a saved JSON report in another format, e.g. as the
+// HTML page of a run recorded with --format=json. With -gpu, the kernels
+// of a -backend gpu report of the same workload are merged into it;
+// -names shows the functions by their symbols instead, or by both, and
+// -top only the biggest of them.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "text", "output `format`: text, json, csv, tsv, html, pprof or dot")
+	layout := fs.String("layout", profiler.LayoutLong, "csv/tsv `layout`: long (one row per count) or wide (one row per function)")
+	names := fs.String("names", profiler.NamesDemangled, "function `names`: demangled, raw (the symbols) or both (demangled, with the symbol in csv, tsv, html, pprof and dot)")
+	top := fs.Int("top", 0, "show only the `N` functions with the most of each op type, the rest summed as [other]")
+	gpu := fs.String("gpu", "", "merge the kernels of this -backend gpu `report` into the result")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", reportUsage)
+		return 2
+	}
+	if err := checkFormat(*format, *layout); err != nil {
+		return fail("report", err)
+	}
+	if err := profiler.CheckNames(*names); err != nil {
+		return fail("report", err)
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("report", err)
+	}
+	if *gpu != "" {
+		g, err := profiler.Load(*gpu)
+		if err != nil {
+			return fail("report", err)
+		}
+		if err := res.MergeGPU(g); err != nil {
+			return fail("report", err)
+		}
+	}
+	if err := res.SetNames(*names); err != nil {
+		return fail("report", err)
+	}
+	if err := res.SetTop(*top); err != nil {
+		return fail("report", err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("report", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeReport(w, res, *format, *layout); err != nil {
+		return fail("report", err)
+	}
+	return 0
+}
+
+// checkFormat validates the -format and -layout flags of run, report and
+// replay.
+func checkFormat(format, layout string) error {
+	return profiler.CheckFormat(format, layout)
+}
+
+// writeReport renders res in format.
+func writeReport(w io.Writer, res *profiler.Result, format, layout string) error {
+	return res.WriteFormat(w, format, layout)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const rooflineUsage = "roofline -bandwidth R [-peak-int R] [-peak-fp R] [-time sec] [-top N] [-format svg|vega-lite|gnuplot|text] [-o file] result.json"
+
+// runRoofline places the functions of a report recorded with --mem
+// against a machine's peak rates and memory bandwidth.
+func runRoofline(args []string) int {
+	fs := flag.NewFlagSet("roofline", flag.ContinueOnError)
+	var m profiler.Machine
+	fs.Func("bandwidth", "memory bandwidth in bytes/s (`rate`, e.g. 25.6G)", rateFlag(&m.Bandwidth))
+	fs.Func("peak-int", "peak integer ops/s (`rate`, e.g. 200G)", rateFlag(&m.PeakInt))
+	fs.Func("peak-fp", "peak FP lane ops/s (`rate`); needs a report recorded with --fp", rateFlag(&m.PeakFP))
+	seconds := fs.Float64("time", 0, "native run time of the workload in `seconds`, for the program's achieved rate")
+	top := fs.Int("top", 10, "plot at most `N` functions (0: all)")
+	format := fs.String("format", "svg", "output `format`: svg, vega-lite, gnuplot or text")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", rooflineUsage)
+		return 2
+	}
+
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("roofline", err)
+	}
+	rl, err := res.Roofline(m, *top, *seconds)
+	if err != nil {
+		return fail("roofline", err)
+	}
+	var write func(io.Writer) error
+	switch *format {
+	case "svg":
+		write = rl.WriteSVG
+	case "vega-lite":
+		write = rl.WriteVegaLite
+	case "gnuplot":
+		write = rl.WriteGnuplot
+	case "text":
+		write = rl.WriteText
+	default:
+		return fail("roofline", fmt.Errorf("unknown format %q", *format))
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("roofline", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w); err != nil {
+		return fail("roofline", err)
+	}
+	return 0
+}
+
+// rateFlag parses a rate with an optional SI suffix (K, M, G, T; powers
+// of 1000) into v.
+func rateFlag(v *float64) func(string) error {
+	return func(s string) error {
+		mult := 1.0
+		if i := strings.IndexAny(s, "kKMGT"); i > 0 && i == len(s)-1 {
+			mult = map[byte]float64{'k': 1e3, 'K': 1e3, 'M': 1e6, 'G': 1e9, 'T': 1e12}[s[i]]
+			s = s[:i]
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f <= 0 {
+			return fmt.Errorf("invalid rate %q", s)
+		}
+		*v = f * mult
+		return nil
+	}
+}
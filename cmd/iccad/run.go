@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const runUsage = "run [-profile name] [-backend pin|perf|static|ebpf|qemu|gpu|wasm|hybrid [-qemu emulator] [-gpu-profiler ncu|rocprof] [-wasm-runtime node]] [-regions] [-funcs] [-callgraph] [-lines] [-loops] [-blocks N] [-dfg] [-dead] [-modules] [-follow-children] [-threads] [-per-cpu] [-systime] [-fp] [-vec] [-wide] [-mem] [-modarith] [-butterflies] [-divs] [-mulvals N] [-ops list] [-include glob] [-exclude glob] [-include-func re] [-exclude-func re] [-include-module re] [-exclude-module re] [-names demangled|raw|both] [-debug-dir dir] [-debuginfod urls] [-go] [-jit [-jit-dir dir]] [-python] [-sample F] [-cpus list] [-cgroup dir] [-overhead[=false] | -recalibrate] [-log-level level] [-log-format text|json] [-log file] [-format text|json|csv|tsv|html|pprof|dot] [-layout long|wide] [-top N] [-o file] [-folded file [-weight list]] [-stream interval [-stream-format tui|jsonl] [-stream-o file]] [-metrics addr [-metrics-funcs N]] {[--] cmd [args…] | -record dir [-syscalls] [--] cmd [args…] | -repeat N [-cv pct] [--] cmd [args…] | -sweep grid [--] cmd [args with {param}…] | {-attach pid | -container id|name|pod/[ns/]name} [-duration d]}"
+
+// kvFlags collects repeated key=value flags.
+type kvFlags map[string]string
+
+func (kv kvFlags) String() string { return fmt.Sprint(map[string]string(kv)) }
+
+func (kv kvFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+	kv[k] = v
+	return nil
+}
+
+// appendFlag returns a flag.Func callback collecting repeated values in *v.
+func appendFlag(v *[]string) func(string) error {
+	return func(s string) error {
+		*v = append(*v, s)
+		return nil
+	}
+}
+
+// parseCPUs parses a CPU list such as 0-3,6.
+func parseCPUs(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		a, err := strconv.Atoi(lo)
+		b := a
+		if err == nil && isRange {
+			b, err = strconv.Atoi(hi)
+		}
+		if err != nil || a < 0 || b < a {
+			return nil, fmt.Errorf("bad CPU list %q", s)
+		}
+		for c := a; c <= b; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+// runFlags registers the profiling flags shared by commands that launch a
+// workload and returns the Options they fill in.
+func runFlags(fs *flag.FlagSet) *profiler.Options {
+	o := &profiler.Options{PerfEvents: kvFlags{}}
+	fs.StringVar(&o.Backend, "backend", profiler.BackendPin, "counting `backend`: pin, perf, static, ebpf, qemu, gpu, wasm or hybrid")
+	fs.StringVar(&o.QEMU, "qemu", "", "qemu-user `emulator` of -backend qemu (default qemu-aarch64 or qemu-riscv64)")
+	fs.StringVar(&o.GPUProfiler, "gpu-profiler", "", "kernel `profiler` of -backend gpu: ncu or rocprof (default the first in PATH)")
+	fs.StringVar(&o.WASMRuntime, "wasm-runtime", "", "Node.js `binary` running the modules of -backend wasm (default node)")
+	fs.Var(kvFlags(o.PerfEvents), "perf-event", "override a perf category event, e.g. div=r1d4 (repeatable)")
+	fs.StringVar(&o.Func, "func", "", "count only inside this `function`")
+	fs.StringVar(&o.StartMarker, "start", "", "start marker `function` (marker mode)")
+	fs.StringVar(&o.StopMarker, "stop", "", "stop marker `function` (default derived from -start)")
+	fs.BoolVar(&o.Regions, "regions", false, "count only inside client-API regions, reported per name")
+	fs.BoolVar(&o.Funcs, "funcs", false, "per-function breakdown")
+	fs.BoolVar(&o.Lines, "lines", false, "per-source-line breakdown")
+	fs.BoolVar(&o.Loops, "loops", false, "per-loop breakdown with entries and trip counts")
+	fs.IntVar(&o.Blocks, "blocks", 0, "list the `N` basic blocks with the most operations, with their decoded instructions")
+	fs.Func("annotate", "count every instruction of the functions matching this `glob`, for iccad annotate (repeatable)", appendFlag(&o.Annotate))
+	fs.BoolVar(&o.Dataflow, "dfg", false, "build the dataflow graph of the counted code (needs -func, -start, -regions or a filter)")
+	fs.BoolVar(&o.DeadWork, "dead", false, "flag the results of counted ops overwritten before being read, per function")
+	fs.BoolVar(&o.Modules, "modules", false, "per-module breakdown over the executable and its shared libraries, dlopen()ed ones included")
+	fs.BoolVar(&o.FollowChildren, "follow-children", false, "also count forked and exec'd children, reported per process")
+	fs.BoolVar(&o.CallGraph, "callgraph", false, "inclusive/exclusive per-function counts by calling context")
+	fs.BoolVar(&o.Threads, "threads", false, "per-thread breakdown")
+	fs.BoolVar(&o.PerCPU, "per-cpu", false, "per-CPU and per-NUMA-node breakdown, sampling the CPU each thread runs on (pin backend, Linux)")
+	fs.BoolVar(&o.SysTime, "systime", false, "time the system calls and report the kernel's share apart from the counts (pin backend)")
+	fs.BoolVar(&o.FP, "fp", false, "count FP64/FP32 arithmetic")
+	fs.BoolVar(&o.Vec, "vec", false, "count packed int64 lane ops")
+	fs.BoolVar(&o.Wide, "wide", false, "detect 128-bit and wider integer arithmetic")
+	fs.BoolVar(&o.Signedness, "signedness", false, "split mul and div into signed and unsigned operations")
+	fs.BoolVar(&o.Atomics, "atomics", false, "count atomic read-modify-writes, store-exclusives and futex calls")
+	fs.Func("widths", "break the counts down by operand size: `bits` 8,16,32,64,128 or all", func(v string) error {
+		if v == "all" {
+			o.Widths = profiler.OperandWidths
+			return nil
+		}
+		for _, s := range strings.Split(v, ",") {
+			bits, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("bad operand width %q", s)
+			}
+			o.Widths = append(o.Widths, bits)
+		}
+		return nil
+	})
+	fs.StringVar(&o.Compound, "compound", "", "count FMAs, multiply-adds and two-register LEAs as one op (`policy` fused, the default), as their constituent ops (split) or both")
+	fs.StringVar(&o.Agen, "agen", "", "count the adds and shifts of LEAs and memory-operand addressing as their own category (`mode` category) or as add and shl (fold)")
+	fs.BoolVar(&o.Mem, "mem", false, "count loads, stores and bytes moved")
+	fs.StringVar(&o.Cache, "cache", "", "simulate caches on the memory accesses to estimate DRAM traffic: `spec` default or e.g. l1=32k:8,l2=1m:16,line=64; implies -mem")
+	fs.Uint64Var(&o.Footprint, "footprint", 0, "track the working set: distinct cache lines and pages touched per window of `N` data accesses, per function and phase")
+	fs.BoolVar(&o.Mix, "mix", false, "count the instruction mix: branches taken and not, jumps, calls, returns and moves")
+	fs.BoolVar(&o.ModArith, "modarith", false, "recognize modular multiply (Montgomery, Barrett, Shoup), add and subtract sequences")
+	fs.BoolVar(&o.Butterflies, "butterflies", false, "count NTT/FFT butterflies per transform and infer transform sizes (implies -modarith)")
+	fs.BoolVar(&o.Divs, "divs", false, "class 64-bit division sites by divisor (power of two, constant, variable)")
+	fs.IntVar(&o.Branches, "branches", 0, "list the `N` conditional branches a simulated predictor missed most")
+	fs.IntVar(&o.Strides, "strides", 0, "classify loop memory accesses as unit, constant or irregular stride, listing the `N` loops accessing most; implies -loops")
+	fs.IntVar(&o.Reuse, "reuse", 0, "count the loop ops repeating a computation with the same operands, listing the `N` loops repeating most; implies -loops")
+	fs.Uint64Var(&o.MulVals, "mulvals", 0, "histogram the operand widths of every `N`th 64-bit multiply")
+	fs.Func("ops", "also count these `categories`: shl,shr,rol,and,or,xor,not or bitwise", func(v string) error {
+		o.Ops = append(o.Ops, strings.Split(v, ",")...)
+		return nil
+	})
+	fs.Func("class", "also count the custom category `class`: crc32, aes, clmul, pdep_pext, or name=glob,… over x86 mnemonics (repeatable)", appendFlag(&o.Classes))
+	fs.Func("watch", "also count the accesses to data as a custom category: `[name=]symbol|0xaddr[/size][:r|w|rw]`, writes by default (repeatable)", appendFlag(&o.Watch))
+	fs.Func("include", "count only functions matching this `glob` (repeatable)", appendFlag(&o.Include))
+	fs.Func("exclude", "do not count functions matching this `glob`, e.g. 'runtime.*' (repeatable)", appendFlag(&o.Exclude))
+	fs.Func("include-func", "count only functions whose name matches this `regex` (repeatable)", appendFlag(&o.IncludeFunc))
+	fs.Func("exclude-func", "do not count functions whose name matches this `regex` (repeatable)", appendFlag(&o.ExcludeFunc))
+	fs.Func("include-module", "count only code in images whose path matches this `regex`, e.g. 'libcrypto\\.so' (repeatable)", appendFlag(&o.IncludeModule))
+	fs.Func("exclude-module", "do not count code in images whose path matches this `regex` (repeatable)", appendFlag(&o.ExcludeModule))
+	fs.Func("debug-dir", "look for the separate debug info of stripped images in this `directory` before /usr/lib/debug (repeatable)", appendFlag(&o.DebugDirs))
+	o.Debuginfod = profiler.DebuginfodURLs()
+	fs.Func("debuginfod", "fetch missing debug info from these debuginfod `urls`, space-separated (default $DEBUGINFOD_URLS; '' fetches none)", func(v string) error {
+		o.Debuginfod = strings.Fields(v)
+		return nil
+	})
+	fs.StringVar(&o.Names, "names", profiler.NamesDemangled, "function `names`: demangled, raw (the symbols) or both (demangled, with the symbol in csv, tsv, html, pprof and dot)")
+	fs.BoolVar(&o.Go, "go", false, "split a Go binary's counts into user code, standard library and runtime")
+	fs.BoolVar(&o.JIT, "jit", false, "name JIT-compiled code (JVM, .NET) from the runtime's perf map and jitdump files")
+	fs.StringVar(&o.JITDir, "jit-dir", "", "`directory` of the -jit map files (default /tmp)")
+	fs.BoolVar(&o.Python, "python", false, "attribute the counts to Python functions (run the script with python3 -m int64profiler)")
+	fs.Float64Var(&o.Sample, "sample", 0, "count only this `fraction` of instruction windows and extrapolate")
+	fs.Uint64Var(&o.Window, "window", 0, "sampling window length in `instructions` (default 1000000)")
+	fs.Uint64Var(&o.Seed, "seed", 0, "sampling random `seed`")
+	fs.DurationVar(&o.Timeout, "timeout", 0, "stop the workload after this `long` and report the counts so far, flagged as truncated")
+	fs.Uint64Var(&o.MaxOps, "max-ops", 0, "stop the workload once it has run about `N` counted operations")
+	fs.Int64Var(&o.MaxOutputBytes, "max-output-bytes", 0, "stop the workload once its output (stdout and stderr) exceeds `N` bytes")
+	fs.IntVar(&o.CaptureOutput, "capture-output", 0, "keep the last `N` bytes of the workload's stdout and stderr in the report")
+	fs.Func("cpus", "pin the workload and the profiler to these CPUs, e.g. `list` 0-3,6", func(v string) (err error) {
+		o.CPUs, err = parseCPUs(v)
+		return err
+	})
+	fs.StringVar(&o.Cgroup, "cgroup", "", "run the workload in this cgroup v2 `directory` (relative to /sys/fs/cgroup), created when missing; its cpuset follows -cpus")
+	fs.Func("warmup", "discard the counts of the first `duration`, or with auto those up to the steady state", func(v string) error {
+		if v == "auto" {
+			o.SteadyState = true
+			return nil
+		}
+		d, err := time.ParseDuration(v)
+		o.Warmup = d
+		return err
+	})
+	fs.Uint64Var(&o.WarmupOps, "warmup-ops", 0, "discard the counts of the first `N` counted operations")
+	fs.Float64Var(&o.SteadyTolerance, "steady-tol", 10, "with -warmup auto, the rate tolerance in `percent` that counts as steady")
+	fs.StringVar(&o.Phases, "phases", "", "break the report into phases: `mode` auto (by changes in the operation mix) or marker (at roi.Phase calls)")
+	fs.DurationVar(&o.PhaseInterval, "phase-interval", 0, "with -phases auto, compare the operation mix every `period` (default 500ms)")
+	fs.Float64Var(&o.PhaseShift, "phase-shift", 0, "with -phases auto, the change of the operation mix in `percent` that starts a phase (default 25)")
+	fs.StringVar(&o.Checkpoint, "checkpoint", "", "write the counts so far to `file` every -checkpoint-interval, to resume from with -resume")
+	fs.DurationVar(&o.CheckpointInterval, "checkpoint-interval", 0, "checkpoint `period` (default 10m)")
+	fs.StringVar(&o.Resume, "resume", "", "start from the counts of checkpoint `file`, written by a run with the same counting options")
+	fs.StringVar(&o.TimeSeries, "timeseries", "", "write the counts of every -timeseries-interval to `file`, stamped with the Unix time")
+	fs.DurationVar(&o.TimeSeriesInterval, "timeseries-interval", 0, "time series `period` (default 10ms)")
+	fs.StringVar(&o.TimeSeriesFormat, "timeseries-format", "", "time series `format`: json (one object per line) or csv (default: csv for a .csv file)")
+	fs.StringVar(&o.Trace, "trace", "", "write a trace of what is counted to `file`, gzipped if it ends in .gz")
+	fs.StringVar(&o.TraceFormat, "trace-format", "", "trace `format`: ops (a line per op counted, the default) or champsim (the main thread's instructions)")
+	fs.IntVar(&o.Debug, "dbg", 0, "pintool debug `level` (0-2)")
+	o.Logger = logFlags(fs)
+	return o
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func runRun(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	opts := runFlags(fs)
+	format := fs.String("format", "text", "report `format`: text, json, csv, tsv, html, pprof or dot")
+	layout := fs.String("layout", profiler.LayoutLong, "csv/tsv `layout`: long (one row per count) or wide (one row per function)")
+	top := fs.Int("top", 0, "show only the `N` functions with the most of each op type, the rest summed as [other] (implies -funcs)")
+	out := fs.String("o", "", "write the report to `file` instead of stdout; a .iccad file is a bundle (see iccad bundle)")
+	fs.StringVar(out, "output", "", "same as -o")
+	folded := fs.String("folded", "", "also write collapsed stacks for flamegraph tools to `file` (implies -callgraph)")
+	weight := fs.String("weight", "int", "collapsed-stack weight: comma-separated op types or groups")
+	verbose := fs.Bool("v", false, "show the target's output (on stderr)")
+	attach := fs.Int("attach", 0, "attach to the running process `pid` instead of launching one")
+	container := fs.String("container", "", "attach to the first process of the running container `ref`: an ID, a Docker name or pod/[namespace/]name")
+	fs.DurationVar(&opts.Duration, "duration", 0, "with -attach or -container, detach after this long (default: until exit or Ctrl-C)")
+	fs.DurationVar(&opts.Stream, "stream", 0, "show the counts so far and over the last `interval` while the workload runs")
+	streamFormat := fs.String("stream-format", "tui", "snapshot `format`: tui (redrawn screen) or jsonl (one JSON object per line)")
+	streamOut := fs.String("stream-o", "", "write snapshots to `file` instead of stderr")
+	metrics := fs.String("metrics", "", "serve the counts for Prometheus at http://`addr`/metrics, refreshed every -stream interval (default 5s)")
+	fs.IntVar(&opts.StreamFuncs, "metrics-funcs", 0, "also export the counts of the `N` busiest functions (implies -funcs)")
+	record := fs.String("record", "", "save the command line, environment, input and report to `dir` for iccad replay")
+	syscalls := fs.Bool("syscalls", false, "with -record, also save a trace of the workload's system calls")
+	repeat := fs.Int("repeat", 1, "run the workload `N` times and report each counter's mean, median, spread and range")
+	sweep := fs.String("sweep", "", "run the workload once per point of this parameter `grid`, e.g. 'N=1024,2048;threads=1,4', with {N} and {threads} in its arguments replaced, and report how the counts scale")
+	cv := fs.Float64("cv", profiler.DefaultCVThreshold, "with -repeat, flag counters whose coefficient of variation exceeds this `percent`")
+	overhead := fs.Bool("overhead", false, "estimate the tool's share of the wall time, calibrating first when these flags have none saved (pin backend; without it, only a saved calibration is used; =false uses none)")
+	recalibrate := fs.Bool("recalibrate", false, "calibrate the overhead estimate again")
+	fs.Func("profile", "set the flags of this `profile` of .iccad.yaml; flags after it override them", profileFlag(fs))
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	attaching := *attach != 0 || *container != ""
+	if (!attaching) == (fs.NArg() == 0) || (*attach != 0 && *container != "") || (*record != "" && attaching) ||
+		*repeat < 1 || (*repeat > 1 && (attaching || *record != "")) ||
+		(*sweep != "" && (attaching || *record != "" || *repeat > 1)) {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", runUsage)
+		return 2
+	}
+	if *container != "" {
+		pid, c, err := profiler.FindContainer(*container)
+		if err != nil {
+			return fail("run", err)
+		}
+		fmt.Fprintf(os.Stderr, "iccad run: attaching to pid %d of %s\n", pid, c)
+		*attach = pid
+	}
+	if err := checkFormat(*format, *layout); err != nil {
+		return fail("run", err)
+	}
+	if *repeat > 1 && *format != "text" && *format != "json" {
+		return fail("run", errors.New("-repeat reports statistics as text or json"))
+	}
+	var params []profiler.SweepParam
+	if *sweep != "" {
+		var err error
+		if params, err = profiler.ParseSweep(*sweep); err != nil {
+			return fail("run", err)
+		}
+		if _, err := profiler.SweepCommand(fs.Args(), params, profiler.SweepPoints(params)[0]); err != nil {
+			return fail("run", err)
+		}
+		if err := checkSweepFormat(*format); err != nil {
+			return fail("run", err)
+		}
+	}
+	if *streamFormat != "tui" && *streamFormat != "jsonl" {
+		return fail("run", fmt.Errorf("unknown stream format %q", *streamFormat))
+	}
+	if *folded != "" {
+		opts.CallGraph = true
+	}
+	if opts.StreamFuncs > 0 || *top > 0 {
+		opts.Funcs = true
+	}
+	var snapshots []func(profiler.Snapshot)
+	if opts.Stream != 0 {
+		var sw io.Writer = os.Stderr
+		if *streamOut != "" {
+			f, err := os.Create(*streamOut)
+			if err != nil {
+				return fail("run", err)
+			}
+			defer f.Close()
+			sw = f
+		}
+		snapshots = append(snapshots, snapshotPrinter(sw, *streamFormat))
+	}
+	if *metrics != "" {
+		if opts.Stream == 0 {
+			opts.Stream = 5 * time.Second
+		}
+		m := new(profiler.Metrics)
+		closeMetrics, err := serveMetrics(*metrics, m)
+		if err != nil {
+			return fail("run", err)
+		}
+		defer closeMetrics()
+		snapshots = append(snapshots, m.Update)
+	}
+	if len(snapshots) > 0 {
+		opts.OnSnapshot = func(s profiler.Snapshot) {
+			for _, fn := range snapshots {
+				fn(s)
+			}
+		}
+	}
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+	if *record != "" {
+		// piped or redirected input is part of the recording
+		if st, err := os.Stdin.Stat(); err == nil && st.Mode()&os.ModeCharDevice == 0 {
+			opts.Stdin = os.Stdin
+		}
+	}
+
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("run", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	// Calibrating runs several programs under the tool, minutes with heavy
+	// options, so only -overhead and -recalibrate do it; a plain run
+	// uses the calibration saved for its flags, if any.
+	overheadSet := false
+	fs.Visit(func(f *flag.Flag) { overheadSet = overheadSet || f.Name == "overhead" })
+	if (*overhead || !overheadSet) && !attaching && (opts.Backend == "" || opts.Backend == profiler.BackendPin) {
+		if c, err := calibration(ctx, p, "", *recalibrate, *overhead || *recalibrate); err != nil {
+			fmt.Fprintf(os.Stderr, "iccad run: no overhead estimate: %v\n", err)
+		} else if c != nil {
+			opts.Calibration = c
+			if p, err = profiler.New(*opts); err != nil {
+				return fail("run", err)
+			}
+		}
+	}
+	if *repeat > 1 {
+		return runRepeated(ctx, p, fs.Args(), *repeat, *cv, *format, *out)
+	}
+	if params != nil {
+		return runSwept(ctx, p, params, fs.Args(), *format, *out)
+	}
+	var res *profiler.Result
+	var runErr error
+	switch {
+	case *attach != 0:
+		res, runErr = p.Attach(ctx, *attach)
+	case *record != "":
+		res, runErr = p.Record(ctx, *record, fs.Args(), *syscalls)
+	default:
+		res, runErr = p.Run(ctx, fs.Args())
+	}
+	if res == nil {
+		return fail("run", runErr)
+	}
+	if err := res.SetTop(*top); err != nil {
+		return fail("run", err)
+	}
+
+	if isBundle(*out) {
+		if err := writeBundle(*out, res, ""); err != nil {
+			return fail("run", err)
+		}
+	} else {
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return fail("run", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := writeReport(w, res, *format, *layout); err != nil {
+			return fail("run", err)
+		}
+	}
+	if *folded != "" {
+		if err := writeFolded(*folded, res, strings.Split(*weight, ",")); err != nil {
+			return fail("run", err)
+		}
+	}
+	if runErr != nil {
+		code := fail("run", runErr)
+		if errors.Is(runErr, profiler.ErrTargetFailed) && res.Exit != nil {
+			code = res.Exit.Status() // the workload's own
+		}
+		return code
+	}
+	return 0
+}
+
+// writeFolded saves res's collapsed stacks, weighted by weight, to path.
+func writeFolded(path string, res *profiler.Result, weight []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := res.WriteFolded(f, weight...); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runSwept runs cmd once per point of the grid params and writes how the
+// counts scale. A failed point is reported and leaves the others alone,
+// but the command exits with status 1.
+func runSwept(ctx context.Context, p *profiler.Profiler, params []profiler.SweepParam, cmd []string, format, out string) int {
+	points := profiler.SweepPoints(params)
+	results := make([]*profiler.Result, len(points))
+	errs := make([]error, len(points))
+	for i, values := range points {
+		var at []string
+		for j, v := range values {
+			at = append(at, params[j].Name+"="+v)
+		}
+		fmt.Fprintf(os.Stderr, "iccad run: point %d/%d: %s\n", i+1, len(points), strings.Join(at, " "))
+		c, err := profiler.SweepCommand(cmd, params, values)
+		if err == nil {
+			results[i], err = p.Run(ctx, c)
+		}
+		if err != nil {
+			results[i], errs[i] = nil, err
+			fmt.Fprintf(os.Stderr, "iccad run: point %d: %v\n", i+1, err)
+		}
+		if ctx.Err() != nil {
+			return fail("run", ctx.Err())
+		}
+	}
+	s, err := profiler.NewSweep(params, results)
+	if err != nil {
+		return fail("run", err)
+	}
+	for i, err := range errs {
+		if err != nil {
+			s.Points[i].Error = err.Error()
+		}
+	}
+	if err := writeSweep(out, format, s); err != nil {
+		return fail("run", err)
+	}
+	if n := s.Failed(); n > 0 {
+		return fail("run", fmt.Errorf("%d of %d points failed", n, len(points)))
+	}
+	return 0
+}
+
+// runRepeated runs cmd n times and writes the statistics of the runs.
+func runRepeated(ctx context.Context, p *profiler.Profiler, cmd []string, n int, cv float64, format, out string) int {
+	var results []*profiler.Result
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(os.Stderr, "iccad run: run %d/%d\n", i+1, n)
+		res, err := p.Run(ctx, cmd)
+		if err != nil {
+			return fail("run", fmt.Errorf("run %d: %v", i+1, err))
+		}
+		results = append(results, res)
+	}
+	if err := writeStats(out, format, results, cv); err != nil {
+		return fail("run", err)
+	}
+	return 0
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/abe5240/iccad/examples/suite"
+	"github.com/abe5240/iccad/profiler"
+)
+
+const selftestUsage = "selftest [-suite dir] [-only list] [-tolerance pct] [-v] [run flags]"
+
+// selftestSuite is the expected.json of a suite directory.
+type selftestSuite struct {
+	TolerancePct float64            `json:"tolerance_pct"`
+	Workloads    []selftestWorkload `json:"workloads"`
+}
+
+// selftestWorkload is built with Build, then run with both Runs, with
+// the run flags Options added to the command line's; Expected is the
+// counts the second run must have more than the first, and NoSelfEdges
+// that the second run's dataflow graph has no edge from a node to itself.
+// "{suite}" in the commands is the suite directory, "{bin}" the built
+// binary and "{python}" a Python 3 interpreter.
+type selftestWorkload struct {
+	Name         string            `json:"name"`
+	Language     string            `json:"language"`
+	Build        []string          `json:"build,omitempty"`
+	Options      []string          `json:"options,omitempty"`
+	Runs         [][]string        `json:"runs"`
+	Expected     map[string]uint64 `json:"expected"`
+	NoSelfEdges  bool              `json:"no_self_edges,omitempty"`
+	TolerancePct float64           `json:"tolerance_pct,omitempty"`
+}
+
+// selftestFlags are the flags of iccad selftest.
+type selftestFlags struct {
+	opts                 *profiler.Options
+	dir, only, tolerance *string
+	verbose              *bool
+}
+
+// parseSelftestFlags parses args as the flags of iccad selftest.
+func parseSelftestFlags(args []string) (*selftestFlags, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	f := &selftestFlags{opts: runFlags(fs)}
+	f.dir = fs.String("suite", "", "run the suite in `dir`, such as examples/suite (default the one built in)")
+	f.only = fs.String("only", "", "run only these comma-separated `workloads`")
+	f.tolerance = fs.String("tolerance", "", "allow counts this `percentage` off (default the suite's)")
+	f.verbose = fs.Bool("v", false, "show the builds' and workloads' output (on stderr)")
+	err := fs.Parse(args)
+	if err == nil && *f.verbose {
+		f.opts.Stdout, f.opts.Stderr = os.Stderr, os.Stderr
+	}
+	return f, fs, err
+}
+
+// runSelftest builds and profiles the example workloads and checks their
+// counts against the suite's expectations; the exit status is 1 when any
+// is off by more than the tolerance, so it doubles as an accuracy
+// regression test of a backend.
+func runSelftest(args []string) int {
+	f, fs, err := parseSelftestFlags(args)
+	if err != nil {
+		return 2
+	}
+	opts, dir, only, tolerance, verbose := f.opts, f.dir, f.only, f.tolerance, f.verbose
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", selftestUsage)
+		return 2
+	}
+	var pct float64 = -1
+	if *tolerance != "" {
+		if pct, err = parsePercent(*tolerance); err != nil {
+			return fail("selftest", err)
+		}
+	}
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+
+	work, err := os.MkdirTemp("", "iccad-selftest-")
+	if err != nil {
+		return fail("selftest", err)
+	}
+	defer os.RemoveAll(work)
+	if *dir == "" {
+		*dir = filepath.Join(work, "suite")
+		if err := extractSuite(*dir); err != nil {
+			return fail("selftest", err)
+		}
+	} else if *dir, err = filepath.Abs(*dir); err != nil {
+		return fail("selftest", err)
+	}
+	s, err := loadSuite(*dir)
+	if err != nil {
+		return fail("selftest", err)
+	}
+	var want map[string]bool
+	if *only != "" {
+		want = map[string]bool{}
+		for _, n := range strings.Split(*only, ",") {
+			want[strings.TrimSpace(n)] = true
+		}
+	}
+
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("selftest", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	fmt.Printf("%-8s %-4s %12s %12s %8s  %s\n", "WORKLOAD", "OP", "EXPECTED", "COUNTED", "OFF", "STATUS")
+	checked, failed, skipped := 0, 0, 0
+	for _, w := range s.Workloads {
+		if want != nil && !want[w.Name] {
+			continue
+		}
+		tol := w.TolerancePct
+		if tol == 0 {
+			tol = s.TolerancePct
+		}
+		if pct >= 0 {
+			tol = pct
+		}
+		wp := p
+		if len(w.Options) > 0 {
+			// The workload's flags go after the command line's, so that they
+			// win.
+			wf, _, err := parseSelftestFlags(append(args[:len(args):len(args)], w.Options...))
+			if err == nil {
+				wp, err = profiler.New(*wf.opts)
+			}
+			if err != nil {
+				fmt.Printf("%-8s %-4s %12s %12s %8s  skip: %v\n", w.Name, "-", "-", "-", "-", err)
+				skipped++
+				continue
+			}
+		}
+		diff, res, err := selftestWorkloadDiff(ctx, wp, w, *dir, work, *verbose)
+		var missing *exec.Error
+		switch {
+		case errors.As(err, &missing):
+			fmt.Printf("%-8s %-4s %12s %12s %8s  skip: %s not found\n", w.Name, "-", "-", "-", "-", missing.Name)
+			skipped++
+			continue
+		case err != nil:
+			if ctx.Err() != nil {
+				return fail("selftest", err)
+			}
+			fmt.Printf("%-8s %-4s %12s %12s %8s  FAIL: %v\n", w.Name, "-", "-", "-", "-", err)
+			failed++
+			continue
+		}
+		for _, op := range append(profiler.CategoryNames, profiler.BitCategoryNames...) {
+			exp, ok := w.Expected[op]
+			if !ok {
+				continue
+			}
+			got := diff[op]
+			off := 100 * (float64(got) - float64(exp)) / math.Max(float64(exp), 1)
+			status := "ok"
+			if math.Abs(off) > tol {
+				status = fmt.Sprintf("FAIL: more than %.4g%% off", tol)
+				failed++
+			}
+			checked++
+			fmt.Printf("%-8s %-4s %12d %12d %+7.2f%%  %s\n", w.Name, op, exp, got, off, status)
+		}
+		if w.NoSelfEdges {
+			got, status := "-", "FAIL: no dataflow graph"
+			if d := res.Dataflow; d != nil {
+				n := 0
+				for _, e := range d.Edges {
+					if e.From == e.To {
+						n++
+					}
+				}
+				got, status = fmt.Sprint(n), "ok"
+				if n > 0 {
+					status = "FAIL: a node of a loop-free function depends on itself"
+				}
+			}
+			if status != "ok" {
+				failed++
+			}
+			checked++
+			fmt.Printf("%-8s %-4s %12d %12s %8s  %s\n", w.Name, "self", 0, got, "-", status)
+		}
+	}
+	switch {
+	case failed > 0:
+		fmt.Printf("FAIL: %d of the checks failed\n", failed)
+		return 1
+	case checked == 0:
+		fmt.Println("FAIL: no workload could run")
+		return 1
+	}
+	fmt.Printf("ok: %d counts as expected", checked)
+	if skipped > 0 {
+		fmt.Printf(", %d workload(s) skipped", skipped)
+	}
+	fmt.Println()
+	return 0
+}
+
+// extractSuite writes the built-in suite to dir.
+func extractSuite(dir string) error {
+	return fs.WalkDir(suite.FS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0o755)
+		}
+		b, err := suite.FS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, b, 0o644)
+	})
+}
+
+// loadSuite reads and checks dir/expected.json.
+func loadSuite(dir string) (*selftestSuite, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "expected.json"))
+	if err != nil {
+		return nil, err
+	}
+	var s selftestSuite
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, "expected.json"), err)
+	}
+	for _, w := range s.Workloads {
+		if w.Name == "" || len(w.Runs) != 2 || len(w.Runs[0]) == 0 || len(w.Runs[1]) == 0 || (len(w.Expected) == 0 && !w.NoSelfEdges) {
+			return nil, fmt.Errorf("%s: workload %q needs a name, two runs and expected counts or no_self_edges", filepath.Join(dir, "expected.json"), w.Name)
+		}
+	}
+	return &s, nil
+}
+
+// selftestWorkloadDiff builds w and returns, per op type, how many more
+// its second run counted than its first, and the second run's result. It
+// returns an *exec.Error when a tool w needs is missing.
+func selftestWorkloadDiff(ctx context.Context, p *profiler.Profiler, w selftestWorkload, dir, work string, verbose bool) (map[string]int64, *profiler.Result, error) {
+	vars := map[string]string{"{suite}": dir, "{bin}": filepath.Join(work, w.Name)}
+	expand := func(argv []string) ([]string, error) {
+		out := make([]string, len(argv))
+		for i, a := range argv {
+			if strings.Contains(a, "{python}") {
+				py, err := python3(ctx)
+				if err != nil {
+					return nil, err
+				}
+				vars["{python}"] = py
+			}
+			for k, v := range vars {
+				a = strings.ReplaceAll(a, k, v)
+			}
+			out[i] = a
+		}
+		if _, err := exec.LookPath(out[0]); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	if len(w.Build) > 0 {
+		argv, err := expand(w.Build)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Dir = dir
+		var log strings.Builder
+		cmd.Stdout, cmd.Stderr = &log, &log
+		if verbose {
+			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		}
+		if err := cmd.Run(); err != nil {
+			return nil, nil, fmt.Errorf("build: %v%s", err, indentLog(log.String()))
+		}
+	}
+	var counts [2]profiler.Counts
+	var res *profiler.Result
+	for i, run := range w.Runs {
+		argv, err := expand(run)
+		if err != nil {
+			return nil, nil, err
+		}
+		if res, err = p.Run(ctx, argv); err != nil {
+			return nil, nil, err
+		}
+		counts[i] = res.Totals
+	}
+	diff := map[string]int64{}
+	for op := range w.Expected {
+		diff[op] = int64(counts[1].Get(op)) - int64(counts[0].Get(op))
+	}
+	return diff, res, nil
+}
+
+// python3 returns the path of the Python 3 interpreter itself, not of a
+// wrapper script such as a pyenv shim, which the tool would count instead.
+func python3(ctx context.Context) (string, error) {
+	path, err := exec.LookPath("python3")
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, path, "-c", "import sys; print(sys.executable)").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// indentLog returns a build's output indented under the error, "" for
+// none.
+func indentLog(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	return "\n    " + strings.ReplaceAll(s, "\n", "\n    ")
+}
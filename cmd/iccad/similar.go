@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const similarUsage = "similar [-store file] [-top N] [-format text|json] result.json | -workload name"
+
+// runSimilar lists the workloads of the result store whose operation-mix
+// fingerprint is most like that of a report or of a stored workload.
+func runSimilar(args []string) int {
+	fs := flag.NewFlagSet("similar", flag.ContinueOnError)
+	path := fs.String("store", "", "store `file` (default $ICCAD_STORE or ~/.iccad/history.jsonl)")
+	workload := fs.String("workload", "", "compare the latest stored run of workload `name` with the others")
+	top := fs.Int("top", 10, "list at most `N` workloads, the most similar first (0: all)")
+	format := fs.String("format", "text", "output `format`: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*workload == "") == (fs.NArg() == 0) || fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", similarUsage)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		return fail("similar", fmt.Errorf("unknown format %q", *format))
+	}
+
+	s, err := openStore(*path)
+	if err != nil {
+		return fail("similar", err)
+	}
+	entries, err := s.Query(profiler.HistoryQuery{Fingerprints: true})
+	if err != nil {
+		return fail("similar", err)
+	}
+	var f *profiler.Fingerprint
+	probe := *workload
+	if probe != "" {
+		var others []profiler.HistoryEntry
+		for _, e := range entries {
+			if e.Workload == probe {
+				f = e.Fingerprint // entries are oldest first
+			} else {
+				others = append(others, e)
+			}
+		}
+		if f == nil {
+			return fail("similar", fmt.Errorf("no run of %s in the store", probe))
+		}
+		entries = others
+	} else {
+		probe = fs.Arg(0)
+		res, err := profiler.Load(probe)
+		if err != nil {
+			return fail("similar", err)
+		}
+		f = res.Fingerprint()
+	}
+	ranked := profiler.Similar(f, entries, *top)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Fingerprint *profiler.Fingerprint      `json:"fingerprint"`
+			Similar     []profiler.SimilarWorkload `json:"similar"`
+		}{f, ranked})
+		if err != nil {
+			return fail("similar", err)
+		}
+		return 0
+	}
+	fmt.Printf("Workloads most similar to %s (%s):\n", probe, f)
+	if len(ranked) == 0 {
+		fmt.Println("none: the store holds no other workloads")
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "WORKLOAD\tSCORE")
+	for _, g := range profiler.FingerprintGroups {
+		fmt.Fprintf(tw, "\t%s", strings.ToUpper(g))
+	}
+	fmt.Fprintln(tw, "\tLAST RUN\tCOMMIT")
+	for _, x := range ranked {
+		fmt.Fprintf(tw, "%s\t%.1f%%", x.Workload, 100*x.Score)
+		for _, g := range profiler.FingerprintGroups {
+			if v, ok := x.Groups[g]; ok {
+				fmt.Fprintf(tw, "\t%.1f%%", 100*v)
+			} else {
+				fmt.Fprint(tw, "\t-")
+			}
+		}
+		fmt.Fprintf(tw, "\t%s\t%.12s\n", x.Time.Local().Format(time.DateTime), x.Commit)
+	}
+	if err := tw.Flush(); err != nil {
+		return fail("similar", err)
+	}
+	return 0
+}
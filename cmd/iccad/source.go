@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const sourceUsage = "source [-context N] result.json|bundle.iccad [file…]"
+
+// runSource annotates source files with the per-line counts of a report
+// recorded with --lines. Without file arguments every file named in the
+// report that exists locally, or that a bundle keeps, is annotated.
+func runSource(args []string) int {
+	fs := flag.NewFlagSet("source", flag.ContinueOnError)
+	context := fs.Int("context", -1, "show only counted lines plus `N` lines around them (-1: whole file)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", sourceUsage)
+		return 2
+	}
+
+	var bundle *profiler.Bundle
+	var res *profiler.Result
+	var err error
+	if isBundle(fs.Arg(0)) {
+		if bundle, err = profiler.ReadBundle(fs.Arg(0)); err == nil {
+			res = bundle.Result
+		}
+	} else {
+		res, err = profiler.Load(fs.Arg(0))
+	}
+	if err != nil {
+		return fail("source", err)
+	}
+	if res.Lines == nil {
+		return fail("source", errors.New("report has no per-line breakdown (record with --lines)"))
+	}
+
+	files := fs.Args()[1:]
+	explicit := len(files) > 0
+	if !explicit {
+		files = res.SourceFiles()
+	}
+	for i, f := range files {
+		_, err := os.Stat(f)
+		if err != nil && bundle != nil {
+			// the bundle's snippets stand in for a file not checked out here
+			if src, ok := bundle.Source(f); ok {
+				if i > 0 {
+					fmt.Println()
+				}
+				n := *context
+				if n < 0 || n > profiler.BundleContext {
+					n = profiler.BundleContext
+				}
+				if err := res.Annotate(os.Stdout, f, src, n); err != nil {
+					return fail("source", err)
+				}
+				continue
+			}
+		}
+		if err != nil && !explicit {
+			fmt.Fprintf(os.Stderr, "iccad source: skipping %s: not found\n", f)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := res.AnnotateFile(os.Stdout, f, *context); err != nil {
+			return fail("source", err)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const statsUsage = "stats [-cv pct] [-format text|json] [-o file] result.json…"
+
+// runStats summarizes saved reports of repeated runs of one workload.
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	cv := fs.Float64("cv", profiler.DefaultCVThreshold, "flag counters whose coefficient of variation exceeds this `percent`")
+	format := fs.String("format", "text", "output `format`: text or json")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", statsUsage)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		return fail("stats", fmt.Errorf("unknown format %q", *format))
+	}
+	var results []*profiler.Result
+	for _, path := range fs.Args() {
+		res, err := profiler.Load(path)
+		if err != nil {
+			return fail("stats", err)
+		}
+		results = append(results, res)
+	}
+	if err := writeStats(*out, *format, results, *cv); err != nil {
+		return fail("stats", err)
+	}
+	return 0
+}
+
+// writeStats summarizes results and writes the statistics to path, or
+// stdout when path is empty.
+func writeStats(path, format string, results []*profiler.Result, cv float64) error {
+	s, err := profiler.Summarize(results, cv)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return s.WriteText(w)
+}
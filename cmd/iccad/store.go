@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const storeUsage = "store [-store file] [-workload name] [-commit sha] [-time t] result.json…"
+
+// runStore appends reports to the local result store that iccad history
+// queries.
+func runStore(args []string) int {
+	fs := flag.NewFlagSet("store", flag.ContinueOnError)
+	path := fs.String("store", "", "store `file` (default $ICCAD_STORE or ~/.iccad/history.jsonl)")
+	workload := fs.String("workload", "", "workload `name` (default: the profiled binary's base name)")
+	commit := fs.String("commit", "", "git commit `sha` of the workload (default: HEAD of the current directory's repository, if any)")
+	at := fs.String("time", "", "record the runs as taken at `t` (RFC 3339 or 2006-01-02; default now)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", storeUsage)
+		return 2
+	}
+
+	s, err := openStore(*path)
+	if err != nil {
+		return fail("store", err)
+	}
+	t := time.Now()
+	if *at != "" {
+		if t, err = parseTime(*at); err != nil {
+			return fail("store", err)
+		}
+	}
+	if *commit == "" {
+		if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+			*commit = strings.TrimSpace(string(out))
+		}
+	}
+	for _, file := range fs.Args() {
+		res, err := profiler.Load(file)
+		if err != nil {
+			return fail("store", err)
+		}
+		name := *workload
+		if name == "" {
+			name = filepath.Base(res.Binary.Path)
+		}
+		e, err := s.Add(name, *commit, t, res)
+		if err != nil {
+			return fail("store", err)
+		}
+		fmt.Fprintf(os.Stderr, "iccad store: %s: stored as %s", file, e.Workload)
+		if e.Commit != "" {
+			fmt.Fprintf(os.Stderr, " at %.12s", e.Commit)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	return 0
+}
+
+// openStore returns the store at path, or the default one.
+func openStore(path string) (*profiler.Store, error) {
+	if path == "" {
+		var err error
+		if path, err = profiler.DefaultStorePath(); err != nil {
+			return nil, err
+		}
+	}
+	return &profiler.Store{Path: path}, nil
+}
+
+// parseTime accepts RFC 3339 times and local dates.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want RFC 3339 or 2006-01-02", s)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+// snapshotPrinter returns the -stream callback: each snapshot as a JSON
+// line, or with format "tui" a screen redrawn in place.
+func snapshotPrinter(w io.Writer, format string) func(profiler.Snapshot) {
+	if format == "jsonl" {
+		enc := json.NewEncoder(w)
+		return func(s profiler.Snapshot) { enc.Encode(s) }
+	}
+	return func(s profiler.Snapshot) {
+		bw := bufio.NewWriter(w)
+		fmt.Fprint(bw, "\x1b[H\x1b[2J")
+		fmt.Fprintf(bw, "pid %d  %.1fs  %d threads", s.Pid, s.Elapsed, s.Threads)
+		if s.Final {
+			fmt.Fprint(bw, "  (exited)")
+		}
+		fmt.Fprintf(bw, "\n\n%-14s%18s%16s%16s\n", "CATEGORY", "TOTAL", "DELTA", "PER SEC")
+		for _, name := range s.Categories() {
+			rate := 0.0
+			if s.Interval > 0 {
+				rate = float64(s.Delta[name]) / s.Interval
+			}
+			fmt.Fprintf(bw, "%-14s%18d%16d%16.0f\n", strings.ToUpper(name), s.Cumulative[name], s.Delta[name], rate)
+		}
+		bw.Flush()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const sweepUsage = "sweep [-format text|json|csv|tsv] [-o file] grid result.json…"
+
+// runSweep combines saved reports of the runs at each point of a sweep
+// grid, given in the order iccad run -sweep runs them (the last
+// parameter varying fastest), as int64_profiler.sh --sweep does.
+func runSweep(args []string) int {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	format := fs.String("format", "text", "output `format`: text, json, csv or tsv")
+	out := fs.String("o", "", "write to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", sweepUsage)
+		return 2
+	}
+	if err := checkSweepFormat(*format); err != nil {
+		return fail("sweep", err)
+	}
+	params, err := profiler.ParseSweep(fs.Arg(0))
+	if err != nil {
+		return fail("sweep", err)
+	}
+	var results []*profiler.Result
+	for _, path := range fs.Args()[1:] {
+		res, err := profiler.Load(path)
+		if err != nil {
+			return fail("sweep", err)
+		}
+		results = append(results, res)
+	}
+	s, err := profiler.NewSweep(params, results)
+	if err != nil {
+		return fail("sweep", err)
+	}
+	if err := writeSweep(*out, *format, s); err != nil {
+		return fail("sweep", err)
+	}
+	return 0
+}
+
+// checkSweepFormat validates the -format of a sweep report.
+func checkSweepFormat(format string) error {
+	switch format {
+	case "text", "json", "csv", "tsv":
+		return nil
+	}
+	return fmt.Errorf("a sweep reports as text, json, csv or tsv, not %q", format)
+}
+
+// writeSweep writes s in format to path, or stdout when path is empty.
+func writeSweep(path, format string, s *profiler.Sweep) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "csv":
+		return s.WriteCSV(w, ',')
+	case "tsv":
+		return s.WriteCSV(w, '\t')
+	}
+	return s.WriteText(w)
+}
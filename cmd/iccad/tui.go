@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const tuiUsage = "tui result.json"
+
+// runTUI browses a report's functions and call trees in the terminal.
+func runTUI(args []string) int {
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", tuiUsage)
+		return 2
+	}
+	res, err := profiler.Load(fs.Arg(0))
+	if err != nil {
+		return fail("tui", err)
+	}
+	b, err := newBrowser(res)
+	if err != nil {
+		return fail("tui", err)
+	}
+	if err := runTerminal(b); err != nil {
+		return fail("tui", err)
+	}
+	return 0
+}
+
+// runTerminal puts the terminal in raw mode on the alternate screen and
+// redraws b after every key or resize until b quits.
+func runTerminal(b *browser) error {
+	restore, err := rawTerminal(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 32)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+	resize := resizeSignal()
+	for {
+		w, h := terminalSize(os.Stdout)
+		bw := bufio.NewWriter(os.Stdout)
+		b.render(bw, w, h)
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		select {
+		case k, ok := <-keys:
+			if !ok || b.key(k, h) {
+				return nil
+			}
+		case <-resize:
+		}
+	}
+}
+
+// tuiSource is one row's counts, in whichever breakdowns the report has.
+type tuiSource struct {
+	c          profiler.Counts
+	vec        *profiler.Vector
+	wide       *profiler.WideCounts
+	fp64, fp32 *profiler.FPOps
+	mem        *profiler.Memory
+}
+
+// tuiColumn is a sortable column; counts columns are the ones the call
+// graph also has inclusive values for.
+type tuiColumn struct {
+	name   string
+	counts bool
+	get    func(*tuiSource) uint64
+}
+
+// tuiColumns returns the columns r has values for.
+func tuiColumns(r *profiler.Result) []tuiColumn {
+	var cols []tuiColumn
+	for _, name := range append(append([]string{}, profiler.CategoryNames...), r.Ops()...) {
+		name := name
+		cols = append(cols, tuiColumn{name, true, func(s *tuiSource) uint64 { return s.c.Get(name) }})
+	}
+	if r.Vector != nil {
+		cols = append(cols, tuiColumn{"vec", false, func(s *tuiSource) uint64 {
+			if s.vec == nil {
+				return 0
+			}
+			return s.vec.Sum()
+		}})
+	}
+	if r.Wide != nil {
+		cols = append(cols, tuiColumn{"wide", false, func(s *tuiSource) uint64 {
+			if s.wide == nil {
+				return 0
+			}
+			return s.wide.Sum()
+		}})
+	}
+	if r.FP != nil {
+		cols = append(cols, tuiColumn{"fp64", false, func(s *tuiSource) uint64 {
+			if s.fp64 == nil {
+				return 0
+			}
+			return s.fp64.Sum()
+		}}, tuiColumn{"fp32", false, func(s *tuiSource) uint64 {
+			if s.fp32 == nil {
+				return 0
+			}
+			return s.fp32.Sum()
+		}})
+	}
+	if r.Memory != nil {
+		cols = append(cols, tuiColumn{"bytes", false, func(s *tuiSource) uint64 {
+			if s.mem == nil {
+				return 0
+			}
+			return s.mem.Bytes()
+		}})
+	}
+	return cols
+}
+
+// tuiRow is a function of the table: exclusive values per column, and
+// inclusive ones when the call graph has the function.
+type tuiRow struct {
+	name, image string
+	excl, incl  []uint64
+}
+
+// tuiNode is a call-tree node: the contexts below its path, merged.
+type tuiNode struct {
+	name       string
+	depth      int
+	excl, incl []uint64
+	children   []*tuiNode
+	open       bool
+}
+
+// child returns n's child name, adding it when missing.
+func (n *tuiNode) child(name string, ncols int) *tuiNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &tuiNode{name: name, depth: n.depth + 1, excl: make([]uint64, ncols), incl: make([]uint64, ncols)}
+	n.children = append(n.children, c)
+	return c
+}
+
+// browser is the TUI state, kept apart from the terminal so it can be
+// driven by keys and rendered to any writer.
+type browser struct {
+	res     *profiler.Result
+	cols    []tuiColumn
+	funcs   []tuiRow
+	total   []uint64
+	modules []string // "" for every module, then each image
+
+	module  int
+	filter  string
+	typing  bool // editing the name filter
+	sortCol int
+	asc     bool
+	incl    bool
+
+	rows         []tuiRow // the table as shown: filtered and sorted
+	cursor, top  int
+	tree         *tuiNode // non-nil in the call-tree view
+	visible      []*tuiNode
+	tcursor, ttp int
+	status       string
+}
+
+func newBrowser(r *profiler.Result) (*browser, error) {
+	if r.Functions == nil && r.CallGraph == nil {
+		return nil, errors.New("report has no per-function breakdown (record with --funcs or --callgraph)")
+	}
+	b := &browser{res: r, cols: tuiColumns(r)}
+	b.total = b.values(&tuiSource{r.Totals, r.Vector, wideTotals(r.Wide), fpOps(r.FP, true), fpOps(r.FP, false), r.Memory})
+
+	incl := map[string]profiler.Counts{}
+	if g := r.CallGraph; g != nil {
+		for _, f := range g.Functions {
+			incl[f.Name+"\x00"+f.Image] = f.Inclusive
+		}
+	}
+	add := func(name, image string, src *tuiSource) {
+		row := tuiRow{name: name, image: image, excl: b.values(src)}
+		if c, ok := incl[name+"\x00"+image]; ok {
+			row.incl = b.values(&tuiSource{c: c})
+		}
+		b.funcs = append(b.funcs, row)
+	}
+	if r.Functions != nil {
+		for _, f := range r.Functions {
+			add(f.Name, f.Image, &tuiSource{f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory})
+		}
+	} else {
+		for _, f := range r.CallGraph.Functions {
+			add(f.Name, f.Image, &tuiSource{c: f.Exclusive})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, f := range b.funcs {
+		if !seen[f.image] {
+			seen[f.image] = true
+			b.modules = append(b.modules, f.image)
+		}
+	}
+	sort.Strings(b.modules)
+	b.modules = append([]string{""}, b.modules...)
+	b.refresh()
+	return b, nil
+}
+
+func (b *browser) values(s *tuiSource) []uint64 {
+	v := make([]uint64, len(b.cols))
+	for i, c := range b.cols {
+		v[i] = c.get(s)
+	}
+	return v
+}
+
+func wideTotals(w *profiler.Wide) *profiler.WideCounts {
+	if w == nil {
+		return nil
+	}
+	sum := func(by map[int]uint64) (n uint64) {
+		for _, v := range by {
+			n += v
+		}
+		return n
+	}
+	return &profiler.WideCounts{Add: sum(w.Add), Sub: sum(w.Sub), Mul: sum(w.Mul)}
+}
+
+func fpOps(fp *profiler.FP, double bool) *profiler.FPOps {
+	switch {
+	case fp == nil:
+		return nil
+	case double:
+		return &fp.FP64
+	}
+	return &fp.FP32
+}
+
+// shown returns the values a row displays: inclusive ones when toggled
+// on, where the call graph has them.
+func (b *browser) shown(excl, incl []uint64, col int) (uint64, bool) {
+	if !b.incl {
+		return excl[col], true
+	}
+	if incl == nil || !b.cols[col].counts {
+		return 0, false
+	}
+	return incl[col], true
+}
+
+// refresh rebuilds the table after a filter or sort change.
+func (b *browser) refresh() {
+	b.rows = b.rows[:0]
+	module := b.modules[b.module]
+	for _, f := range b.funcs {
+		if module != "" && f.image != module {
+			continue
+		}
+		if b.filter != "" && !strings.Contains(strings.ToLower(f.name), strings.ToLower(b.filter)) {
+			continue
+		}
+		b.rows = append(b.rows, f)
+	}
+	sort.SliceStable(b.rows, func(i, j int) bool {
+		vi, _ := b.shown(b.rows[i].excl, b.rows[i].incl, b.sortCol)
+		vj, _ := b.shown(b.rows[j].excl, b.rows[j].incl, b.sortCol)
+		if b.asc {
+			return vi < vj
+		}
+		return vi > vj
+	})
+	b.cursor = min(b.cursor, max(len(b.rows)-1, 0))
+	if b.tree != nil {
+		b.sortTree(b.tree)
+		b.flatten()
+	}
+}
+
+// openTree switches to the call-tree view: every context below the
+// outermost call of fn, merged, or with fn empty the whole tree.
+func (b *browser) openTree(fn string) {
+	g := b.res.CallGraph
+	if g == nil {
+		b.status = "no call graph in this report (record with --callgraph)"
+		return
+	}
+	root := &tuiNode{name: "(all contexts)", excl: make([]uint64, len(b.cols)), incl: make([]uint64, len(b.cols)), open: true}
+	for _, s := range g.Stacks {
+		frames := s.Frames
+		if fn != "" {
+			i := 0
+			for i < len(frames) && frames[i] != fn {
+				i++
+			}
+			if i == len(frames) {
+				continue
+			}
+			frames = frames[i:]
+		}
+		if len(frames) == 0 {
+			frames = []string{"[no frame]"}
+		}
+		v := b.values(&tuiSource{s.Counts, s.Vector, s.Wide, s.FP64, s.FP32, s.Memory})
+		n := root
+		for _, f := range frames {
+			n = n.child(f, len(b.cols))
+			for i := range v {
+				n.incl[i] += v[i]
+			}
+		}
+		for i := range v {
+			n.excl[i] += v[i]
+			root.incl[i] += v[i]
+		}
+	}
+	if fn != "" && len(root.children) == 1 {
+		root = root.children[0]
+		root.open = true
+	}
+	b.tree, b.tcursor, b.ttp = root, 0, 0
+	b.sortTree(root)
+	b.flatten()
+}
+
+// sortTree orders every level by the sort column, inclusive counts first
+// so the heaviest subtrees lead.
+func (b *browser) sortTree(n *tuiNode) {
+	c := b.sortCol
+	sort.SliceStable(n.children, func(i, j int) bool {
+		vi, vj := n.children[i].incl[c], n.children[j].incl[c]
+		if b.asc {
+			return vi < vj
+		}
+		return vi > vj
+	})
+	for _, ch := range n.children {
+		b.sortTree(ch)
+	}
+}
+
+// flatten lists the open part of the tree, root first.
+func (b *browser) flatten() {
+	b.visible = b.visible[:0]
+	var walk func(*tuiNode)
+	walk = func(n *tuiNode) {
+		b.visible = append(b.visible, n)
+		if n.open {
+			for _, c := range n.children {
+				walk(c)
+			}
+		}
+	}
+	walk(b.tree)
+	b.tcursor = min(b.tcursor, len(b.visible)-1)
+}
+
+// key applies one keypress; h is the screen height, for paging. It
+// reports whether to quit.
+func (b *browser) key(k string, h int) bool {
+	b.status = ""
+	if b.typing {
+		switch k {
+		case "\r", "\n":
+			b.typing = false
+		case "\x1b":
+			b.typing, b.filter = false, ""
+		case "\x7f", "\b":
+			if n := len(b.filter); n > 0 {
+				_, size := utf8.DecodeLastRuneInString(b.filter)
+				b.filter = b.filter[:n-size]
+			}
+		default:
+			if utf8.ValidString(k) && k[0] >= ' ' {
+				b.filter += k
+			}
+		}
+		b.cursor, b.top = 0, 0
+		b.refresh()
+		return false
+	}
+
+	page := max(h-4, 1)
+	cur, n := &b.cursor, len(b.rows)
+	if b.tree != nil {
+		cur, n = &b.tcursor, len(b.visible)
+	}
+	switch k {
+	case "q", "\x03":
+		return true
+	case "\x1b[A", "k":
+		*cur--
+	case "\x1b[B", "j":
+		*cur++
+	case "\x1b[5~":
+		*cur -= page
+	case "\x1b[6~", " ":
+		*cur += page
+	case "\x1b[H", "g":
+		*cur = 0
+	case "\x1b[F", "G":
+		*cur = n - 1
+	case "\t", "s", ">":
+		b.sortCol = (b.sortCol + 1) % len(b.cols)
+		b.refresh()
+	case "\x1b[Z", "S", "<":
+		b.sortCol = (b.sortCol + len(b.cols) - 1) % len(b.cols)
+		b.refresh()
+	case "r":
+		b.asc = !b.asc
+		b.refresh()
+	case "i":
+		if b.res.CallGraph == nil {
+			b.status = "no inclusive counts in this report (record with --callgraph)"
+			break
+		}
+		b.incl = !b.incl
+		b.refresh()
+	case "m", "M":
+		if b.tree != nil {
+			break
+		}
+		d := 1
+		if k == "M" {
+			d = len(b.modules) - 1
+		}
+		b.module = (b.module + d) % len(b.modules)
+		b.cursor, b.top = 0, 0
+		b.refresh()
+	case "/":
+		if b.tree == nil {
+			b.typing = true
+		}
+	case "c":
+		b.openTree("")
+	case "\r", "\n", "\x1b[C", "l":
+		if b.tree == nil {
+			if len(b.rows) > 0 && (k == "\r" || k == "\n") {
+				b.openTree(b.rows[b.cursor].name)
+			}
+			break
+		}
+		if node := b.visible[b.tcursor]; len(node.children) > 0 {
+			node.open = !node.open || k == "\x1b[C" || k == "l"
+			b.flatten()
+		}
+	case "\x1b[D", "h":
+		if b.tree == nil {
+			break
+		}
+		node := b.visible[b.tcursor]
+		if node.open && len(node.children) > 0 {
+			node.open = false
+			b.flatten()
+			break
+		}
+		for i := b.tcursor - 1; i >= 0; i-- { // to the parent
+			if b.visible[i].depth < node.depth {
+				b.tcursor = i
+				break
+			}
+		}
+	case "\x1b", "\x7f", "\b":
+		if b.tree != nil {
+			b.tree = nil
+		} else if b.filter != "" {
+			b.filter = ""
+			b.refresh()
+		}
+	}
+	if b.tree != nil {
+		cur, n = &b.tcursor, len(b.visible)
+	} else {
+		cur, n = &b.cursor, len(b.rows)
+	}
+	*cur = max(min(*cur, n-1), 0)
+	return false
+}
+
+// render draws the current view into a w×h screen.
+func (b *browser) render(out io.Writer, w, h int) {
+	line := func(s string, hl bool) {
+		if utf8.RuneCountInString(s) > w {
+			s = string([]rune(s)[:w])
+		}
+		if hl {
+			fmt.Fprintf(out, "\x1b[7m%-*s\x1b[0m\r\n", w, s)
+			return
+		}
+		fmt.Fprintf(out, "%s\x1b[K\r\n", s)
+	}
+	fmt.Fprint(out, "\x1b[H")
+
+	counts := "exclusive"
+	if b.incl {
+		counts = "inclusive"
+	}
+	order := "↓"
+	if b.asc {
+		order = "↑"
+	}
+	head := fmt.Sprintf("%s  %s counts, by %s %s", b.res.Binary.Path, counts, strings.ToUpper(b.cols[b.sortCol].name), order)
+	if b.tree == nil {
+		module := b.modules[b.module]
+		if module == "" {
+			module = "all"
+		}
+		head += fmt.Sprintf("  module: %s", module)
+		if b.filter != "" || b.typing {
+			head += fmt.Sprintf("  filter: %s", b.filter)
+			if b.typing {
+				head += "_"
+			}
+		}
+	}
+	line(head, false)
+
+	var hdr strings.Builder
+	for i, c := range b.cols {
+		name := strings.ToUpper(c.name)
+		if i == b.sortCol {
+			name = "[" + name + "]"
+		}
+		fmt.Fprintf(&hdr, "%14s", name)
+	}
+	fmt.Fprintf(&hdr, "%8s  ", "SHARE")
+	if b.tree == nil {
+		hdr.WriteString("FUNCTION")
+	} else {
+		hdr.WriteString("CALL TREE")
+	}
+	line(hdr.String(), false)
+
+	cells := func(val func(col int) (uint64, bool)) string {
+		var s strings.Builder
+		for i := range b.cols {
+			if v, ok := val(i); ok {
+				fmt.Fprintf(&s, "%14d", v)
+			} else {
+				fmt.Fprintf(&s, "%14s", "-")
+			}
+		}
+		share := "-"
+		if v, ok := val(b.sortCol); ok && b.total[b.sortCol] > 0 {
+			share = fmt.Sprintf("%.1f%%", 100*float64(v)/float64(b.total[b.sortCol]))
+		}
+		fmt.Fprintf(&s, "%8s  ", share)
+		return s.String()
+	}
+
+	body := max(h-3, 1)
+	cur, top, n := b.cursor, &b.top, len(b.rows)
+	if b.tree != nil {
+		cur, top, n = b.tcursor, &b.ttp, len(b.visible)
+	}
+	*top = min(max(*top, cur-body+1), cur)
+	*top = max(*top, 0)
+	for i := *top; i < *top+body; i++ {
+		if i >= n {
+			line("", false)
+			continue
+		}
+		if b.tree == nil {
+			f := b.rows[i]
+			s := cells(func(c int) (uint64, bool) { return b.shown(f.excl, f.incl, c) }) + f.name
+			if f.image != "" {
+				s += "  [" + f.image + "]"
+			}
+			line(s, i == cur)
+			continue
+		}
+		node := b.visible[i]
+		mark := "  "
+		if len(node.children) > 0 {
+			mark = "▸ "
+			if node.open {
+				mark = "▾ "
+			}
+		}
+		v := node.excl
+		if b.incl {
+			v = node.incl
+		}
+		s := cells(func(c int) (uint64, bool) { return v[c], true })
+		line(s+strings.Repeat("  ", node.depth-b.tree.depth)+mark+node.name, i == cur)
+	}
+
+	help := "↑↓ move  tab/⇧tab sort  r reverse  i incl/excl  m module  / filter  enter callees  c call tree  q quit"
+	if b.tree != nil {
+		help = "↑↓ move  →/enter expand  ← collapse  tab sort  r reverse  i incl/excl  esc back  q quit"
+	}
+	if b.status != "" {
+		help = b.status
+	}
+	fmt.Fprint(out, "\x1b[2m")
+	if utf8.RuneCountInString(help) > w {
+		help = string([]rune(help)[:w])
+	}
+	fmt.Fprintf(out, "%s\x1b[K\x1b[0m", help)
+}
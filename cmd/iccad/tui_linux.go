@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) syscall.Errno {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	return e
+}
+
+// rawTerminal switches f to unbuffered input without echo and returns a
+// func restoring the previous mode.
+func rawTerminal(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if e := ioctl(f, syscall.TCGETS, unsafe.Pointer(&old)); e != 0 {
+		return nil, errors.New("standard input is not a terminal")
+	}
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if e := ioctl(f, syscall.TCSETS, unsafe.Pointer(&raw)); e != 0 {
+		return nil, e
+	}
+	return func() { ioctl(f, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// terminalSize returns f's width and height, 80×24 when unknown.
+func terminalSize(f *os.File) (int, int) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)) != 0 || ws.Col == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// resizeSignal delivers a value whenever the terminal is resized.
+func resizeSignal() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGWINCH)
+	return c
+}
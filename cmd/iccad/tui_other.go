@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func rawTerminal(f *os.File) (func(), error) {
+	return nil, errors.New("the terminal UI requires Linux")
+}
+
+func terminalSize(f *os.File) (int, int) { return 80, 24 }
+
+func resizeSignal() <-chan os.Signal { return nil }
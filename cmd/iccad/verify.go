@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+const verifyUsage = "verify [-tolerance pct] [-format text|json] [-v] [run flags] [--] command [args…]"
+
+// runVerify runs a workload under an instrumenting backend and then
+// under the perf backend's hardware counters, and reports how far their
+// counts of each category the PMU measures are apart, with likely causes.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	opts := runFlags(fs)
+	tolerance := fs.String("tolerance", "2", "flag the categories more than this `percentage` off")
+	format := fs.String("format", "text", "report `format`: text or json")
+	verbose := fs.Bool("v", false, "show the workload's output (on stderr)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: iccad", verifyUsage)
+		return 2
+	}
+	pct, err := parsePercent(*tolerance)
+	if err != nil {
+		return fail("verify", err)
+	}
+	if *format != "text" && *format != "json" {
+		return fail("verify", fmt.Errorf("unknown format %q", *format))
+	}
+	switch opts.Backend {
+	case profiler.BackendPin, profiler.BackendHybrid:
+	default:
+		return fail("verify", fmt.Errorf("%w: verify checks the pin and hybrid backends, not %s", profiler.ErrUnsupported, opts.Backend))
+	}
+	// What the events count: every instruction, divides and FP ops of
+	// all sizes. The narrower operand sizes are the pin backend's only.
+	opts.FP = true
+	if opts.Backend == profiler.BackendPin {
+		opts.Mix = true
+		if len(opts.Widths) == 0 {
+			opts.Widths = profiler.OperandWidths
+		}
+	}
+	if *verbose {
+		opts.Stdout, opts.Stderr = os.Stderr, os.Stderr
+	}
+	hwOpts := profiler.Options{
+		Backend:    profiler.BackendPerf,
+		PerfEvents: opts.PerfEvents,
+		Timeout:    opts.Timeout,
+		CPUs:       opts.CPUs,
+		Cgroup:     opts.Cgroup,
+		Stdout:     opts.Stdout,
+		Stderr:     opts.Stderr,
+		Logger:     opts.Logger,
+	}
+
+	p, err := profiler.New(*opts)
+	if err != nil {
+		return fail("verify", err)
+	}
+	hp, err := profiler.New(hwOpts)
+	if err != nil {
+		return fail("verify", err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	counted, err := p.Run(ctx, fs.Args())
+	if err != nil {
+		return fail("verify", err)
+	}
+	hw, err := hp.Run(ctx, fs.Args())
+	if err != nil {
+		return fail("verify", err)
+	}
+	v, err := profiler.Verify(counted, hw, pct)
+	if err != nil {
+		return fail("verify", err)
+	}
+	if *format == "json" {
+		if err := v.WriteJSON(os.Stdout); err != nil {
+			return fail("verify", err)
+		}
+		return 0
+	}
+	v.WriteText(os.Stdout)
+	return 0
+}
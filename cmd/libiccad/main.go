@@ -0,0 +1,190 @@
+// Command libiccad builds the profiler package as a C shared library for
+// the Python bindings in python/iccad.py:
+//
+//	go build -buildmode=c-shared -o python/libiccad.so ./cmd/libiccad
+//
+// Reports travel as JSON text. Every function returns a malloc'ed buffer
+// of *n bytes, NUL-terminated, that the caller releases with iccad_free;
+// on failure it returns NULL and sets *err to a message to be freed the
+// same way.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+
+	"github.com/abe5240/iccad/profiler"
+)
+
+func main() {}
+
+// ret hands the output of write, or its error, to the caller.
+func ret(n *C.size_t, cerr **C.char, write func(w io.Writer) error) *C.char {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		*cerr = C.CString(err.Error())
+		return nil
+	}
+	*n = C.size_t(buf.Len())
+	return (*C.char)(C.CBytes(append(buf.Bytes(), 0)))
+}
+
+// writeAs renders v as indented JSON, or as text with writeText.
+func writeAs(w io.Writer, format string, v any, writeText func(io.Writer) error) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "text":
+		return writeText(w)
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
+
+func decode(report *C.char) (*profiler.Result, error) {
+	return profiler.Decode(strings.NewReader(C.GoString(report)))
+}
+
+//export iccad_free
+func iccad_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+// iccad_load reads the report at path and returns it as JSON.
+//
+//export iccad_load
+func iccad_load(path *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		res, err := profiler.Load(C.GoString(path))
+		if err != nil {
+			return err
+		}
+		return res.WriteJSON(w)
+	})
+}
+
+// iccad_report renders a report in one of profiler.Formats.
+//
+//export iccad_report
+func iccad_report(report, format, layout *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		res, err := decode(report)
+		if err != nil {
+			return err
+		}
+		return res.WriteFormat(w, C.GoString(format), C.GoString(layout))
+	})
+}
+
+// iccad_diff compares report b against the baseline a, highlighting
+// counters that grow by more than pct percent and minDelta.
+//
+//export iccad_diff
+func iccad_diff(a, b *C.char, pct C.double, minDelta C.uint64_t, format *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		ra, err := decode(a)
+		if err != nil {
+			return err
+		}
+		rb, err := decode(b)
+		if err != nil {
+			return err
+		}
+		d := profiler.Compare(ra, rb)
+		t := profiler.Thresholds{Pct: float64(pct), MinAbs: uint64(minDelta)}
+		switch f := C.GoString(format); f {
+		case "json":
+			return d.WriteJSON(w, t)
+		case "text":
+			return d.WriteText(w, t)
+		default:
+			return fmt.Errorf("unknown format %q (want text or json)", f)
+		}
+	})
+}
+
+// iccad_cost prices a report with a JSON cost model.
+//
+//export iccad_cost
+func iccad_cost(report, model, format *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		res, err := decode(report)
+		if err != nil {
+			return err
+		}
+		m, err := profiler.DecodeCostModel(strings.NewReader(C.GoString(model)))
+		if err != nil {
+			return err
+		}
+		rep, err := res.Cost(m)
+		if err != nil {
+			return err
+		}
+		return writeAs(w, C.GoString(format), rep, rep.WriteText)
+	})
+}
+
+// iccad_energy estimates the energy of a report with the built-in model
+// of node or, when model is not empty, a JSON energy model.
+//
+//export iccad_energy
+func iccad_energy(report, node, model *C.char, dramFraction C.double, format *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		res, err := decode(report)
+		if err != nil {
+			return err
+		}
+		var m *profiler.CostModel
+		if js := C.GoString(model); js != "" {
+			m, err = profiler.DecodeCostModel(strings.NewReader(js))
+		} else {
+			m, err = profiler.EnergyModel(C.GoString(node))
+		}
+		if err != nil {
+			return err
+		}
+		rep, err := res.Energy(m, float64(dramFraction))
+		if err != nil {
+			return err
+		}
+		return writeAs(w, C.GoString(format), rep, rep.WriteText)
+	})
+}
+
+// iccad_stats summarizes repeated runs, given as a JSON array of reports,
+// flagging counters whose coefficient of variation exceeds cv percent.
+//
+//export iccad_stats
+func iccad_stats(reports *C.char, cv C.double, format *C.char, n *C.size_t, cerr **C.char) *C.char {
+	return ret(n, cerr, func(w io.Writer) error {
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(C.GoString(reports)), &raw); err != nil {
+			return fmt.Errorf("stats: want a JSON array of reports: %w", err)
+		}
+		var results []*profiler.Result
+		for _, r := range raw {
+			res, err := profiler.Decode(bytes.NewReader(r))
+			if err != nil {
+				return err
+			}
+			results = append(results, res)
+		}
+		s, err := profiler.Summarize(results, float64(cv))
+		if err != nil {
+			return err
+		}
+		return writeAs(w, C.GoString(format), s, s.WriteText)
+	})
+}
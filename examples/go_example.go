@@ -1,3 +1,5 @@
+//go:build ignore
+
 // Minimal Go workload for Int64Profiler
 package main
 
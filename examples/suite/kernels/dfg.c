@@ -0,0 +1,27 @@
+// The selftest dataflow kernel: f has no loop, so however often main calls
+// it, its graph under -dfg -include f has no edge from a node to itself.
+#include <inttypes.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+// f multiplies slot in place and main stores to it before each call: a
+// graph that kept the multiply as the producer of slot across main's
+// stores would make it depend on itself.
+uint64_t slot;
+
+__attribute__((noinline)) void f(uint64_t x)
+{
+    slot *= x;
+}
+
+int main(int argc, char **argv)
+{
+    uint64_t n = argc > 1 ? strtoull(argv[1], NULL, 10) : 1000000, s = 0;
+    for (uint64_t i = 1; i <= n; i++) {
+        slot = i;
+        f(i + 1);
+        s += slot;
+    }
+    printf("%" PRIu64 "\n", s);
+    return 0;
+}
@@ -0,0 +1,26 @@
+// The selftest kernel in C: per iteration, one 64-bit add, sub, mul and div.
+#include <inttypes.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+// a and b come from the command line, so the compiler cannot fold the
+// multiply or turn the division into one by a constant; x and y depend on
+// each other, so the loop has no closed form to replace it.
+__attribute__((noinline)) static uint64_t kernel(uint64_t n, uint64_t a, uint64_t b)
+{
+    uint64_t x = a, y = b;
+    for (uint64_t i = 0; i < n; i++) {
+        x = x * a + y;      // mul, add
+        y = y - x / b;      // div, sub
+    }
+    return x ^ y;
+}
+
+int main(int argc, char **argv)
+{
+    uint64_t n = argc > 1 ? strtoull(argv[1], NULL, 10) : 1000000;
+    uint64_t a = argc > 2 ? strtoull(argv[2], NULL, 10) : 6364136223846793005ull;
+    uint64_t b = argc > 3 ? strtoull(argv[3], NULL, 10) : 1442695040888963407ull;
+    printf("%" PRIu64 "\n", kernel(n, a, b));
+    return 0;
+}
@@ -0,0 +1,26 @@
+// The selftest kernel in C++: per iteration, one 64-bit add, sub, mul and div.
+#include <cstdint>
+#include <cstdlib>
+#include <iostream>
+
+namespace {
+
+// As in kernel.c: runtime operands and a loop with no closed form.
+[[gnu::noinline]] std::uint64_t kernel(std::uint64_t n, std::uint64_t a, std::uint64_t b)
+{
+    std::uint64_t x = a, y = b;
+    for (std::uint64_t i = 0; i < n; ++i) {
+        x = x * a + y;      // mul, add
+        y = y - x / b;      // div, sub
+    }
+    return x ^ y;
+}
+
+}  // namespace
+
+int main(int argc, char** argv)
+{
+    auto arg = [&](int i, std::uint64_t def) { return argc > i ? std::strtoull(argv[i], nullptr, 10) : def; };
+    std::cout << kernel(arg(1, 1000000), arg(2, 6364136223846793005ull), arg(3, 1442695040888963407ull)) << '\n';
+    return 0;
+}
@@ -0,0 +1,35 @@
+//go:build ignore
+
+// The selftest kernel in Go: per iteration, one 64-bit add, sub, mul and div.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// kernel is as in kernel.c: runtime operands and a loop with no closed
+// form.
+//
+//go:noinline
+func kernel(n, a, b uint64) uint64 {
+	x, y := a, b
+	for i := uint64(0); i < n; i++ {
+		x = x*a + y // mul, add
+		y = y - x/b // div, sub
+	}
+	return x ^ y
+}
+
+func main() {
+	arg := func(i int, def uint64) uint64 {
+		if len(os.Args) > i {
+			if v, err := strconv.ParseUint(os.Args[i], 10, 64); err == nil {
+				return v
+			}
+		}
+		return def
+	}
+	fmt.Println(kernel(arg(1, 1000000), arg(2, 6364136223846793005), arg(3, 1442695040888963407)))
+}
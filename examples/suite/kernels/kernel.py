@@ -0,0 +1,30 @@
+#!/usr/bin/env python3
+# The selftest kernel in Python.  The interpreter runs far more arithmetic
+# than the script asks for, so the suite compares two runs of the same
+# loop: with "extra" 1 each iteration does one more multiply.  CPython
+# multiplies two ints under 2**30 with three 64-bit multiplies: each
+# operand's sign by its digit (medium_value), then the product.
+import sys
+
+
+def kernel(n, extra, a, b):
+    x = t = a
+    if extra:
+        for _ in range(n):
+            x = x * a % b
+            t = x * a
+    else:
+        for _ in range(n):
+            x = x * a % b
+            t = x
+    return x ^ t
+
+
+def main():
+    args = [int(v) for v in sys.argv[1:5]]
+    n, extra, a, b = args + [1000000, 0, 48271, 1000003][len(args):]
+    print(kernel(n, extra, a, b))
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,19 @@
+// The selftest kernel in Rust: per iteration, one 64-bit add, sub, mul and div.
+use std::env;
+
+// As in kernel.c: runtime operands and a loop with no closed form.
+#[inline(never)]
+fn kernel(n: u64, a: u64, b: u64) -> u64 {
+    let (mut x, mut y) = (a, b);
+    for _ in 0..n {
+        x = x.wrapping_mul(a).wrapping_add(y); // mul, add
+        y = y.wrapping_sub(x / b); // div, sub
+    }
+    x ^ y
+}
+
+fn main() {
+    let args: Vec<String> = env::args().collect();
+    let arg = |i: usize, def: u64| args.get(i).and_then(|s| s.parse().ok()).unwrap_or(def);
+    println!("{}", kernel(arg(1, 1000000), arg(2, 6364136223846793005), arg(3, 1442695040888963407)));
+}
@@ -0,0 +1,18 @@
+// Package suite holds the workloads of iccad selftest: the same small
+// kernel in C, C++, Go, Rust and Python, a loop-free function whose
+// dataflow graph must have no self-edge, and expected.json, the counts
+// each must produce.
+//
+// A workload is run twice and only the difference between the runs is
+// checked, which cancels the counts of start-up, the language runtime and
+// the dynamic loader: the compiled kernels do one 64-bit add, sub, mul
+// and div per iteration, so doubling the iterations adds exactly that
+// many of each.
+package suite
+
+import "embed"
+
+// FS is the suite: expected.json and the kernels/ it builds and runs.
+//
+//go:embed expected.json kernels
+var FS embed.FS
@@ -0,0 +1,3 @@
+module github.com/abe5240/iccad
+
+go 1.21
@@ -108,6 +108,21 @@ make -s -C "$TOOL_DIR" clean
 make -s -C "$TOOL_DIR" EXTRA_LDFLAGS=-Wl,-w
 ok "Pintool built → $TOOL_DIR/obj-intel64/${TOOL_NAME}.so"
 
+###############################################################################
+# 6b. QEMU TCG plugin for iccad's qemu backend (needs qemu-plugin.h, QEMU 9.1+)
+###############################################################################
+QEMU_DIR="$HOME/iccad-qemu"
+QEMU_H=$(ls /usr/include/qemu-plugin.h /usr/include/qemu/qemu-plugin.h 2>/dev/null | head -n1 || true)
+if [[ -n "$QEMU_H" ]] && pkg-config --exists glib-2.0 2>/dev/null; then
+    step "Compiling the QEMU plugin"
+    mkdir -p "$QEMU_DIR"
+    gcc -shared -fPIC -O2 $(pkg-config --cflags glib-2.0) -I"$(dirname "$QEMU_H")" \
+        "$INSTALL_DIR/int64_qemu.c" -o "$QEMU_DIR/libint64qemu.so"
+    ok "QEMU plugin built → $QEMU_DIR/libint64qemu.so"
+else
+    echo "ℹ️  qemu-plugin.h or glib-2.0 not found – skipping the QEMU plugin"
+fi
+
 ###############################################################################
 # 7. build test binary with toBenchmark() (non‑PIE, exported symbol)
 ###############################################################################
@@ -3,15 +3,148 @@
 // Int64Profiler.cpp  –  Intel® Pin 3.31
 //
 // Counts 64‑bit scalar integer arithmetic instructions
-// Supports three modes:
+// Supports four modes:
 //   1. Whole program (default)
 //   2. Address-based region (-addr 0xADDRESS)
 //   3. Marker-based region (-start NAME -stop NAME)
+//   4. Named regions from the client API (-regions 1): counts only between
+//      Int64ProfilerStart(name) / Int64ProfilerStop(name) calls (C, Python
+//      via ctypes) or roi.Start / roi.Stop (Go) and reports each region
+//
+// Optionally attributes counts to individual functions (-funcs 1) using the
+// symbol table, with source locations taken from DWARF when available,
+// to individual source lines (-lines 1, DWARF line tables), to the loops
+// found in each function's control-flow graph with their trip counts
+// (-loops 1), to individual
+// threads (-threads 1) and the CPUs and NUMA nodes they ran on (-percpu 1),
+// and to the executable or shared library holding the
+// code (-modules 1, dlopen()ed libraries included).  With -children 1 (and
+// pin -follow_execv) forked and exec'd children are counted too, each on
+// its own, and listed per process in the report.  With -callgraph 1 a shadow call stack attributes
+// counts to calling contexts: inclusive / exclusive per-function totals and
+// collapsed stacks for flamegraph tools (-folded FILE), weighted by any mix
+// of categories (-folded_weight mul, -folded_weight fp64, …).
+// SSE/AVX floating-point arithmetic can be counted in the same pass (-fp 1),
+// as can 64-bit shifts, rotates and bitwise logic (-ops shl,xor,… or
+// -ops bitwise for all of them).  Multi-limb (128-bit and wider) add, sub
+// and multiply sequences are detected per basic block (-wide 1), and
+// packed SSE/AVX/AVX-512 int64 lanes are counted alongside scalars (-vec 1).
+// Each 64-bit DIV/IDIV site can be classed by the divisors it saw as
+// power-of-two, constant or variable (-divs 1), and multiply operands
+// histogrammed by effective bit width (-mulvals N, every Nth multiply).
+// Montgomery, Barrett and Shoup modular multiplies and modular add / sub
+// sequences are recognized per basic block (-modarith 1), and NTT
+// butterflies counted per transform (-butterflies 1).  The basic blocks
+// that ran the most operations can be listed with their decoded
+// instructions (-blocks N), and the dataflow graph of a function or region
+// built (-dfg 1), and the counted results that were never read flagged as
+// dead work (-dead 1).  Functions matching -annotate GLOB (repeatable) are listed
+// instruction by instruction with execution counts, as by perf annotate.
+// Compound instructions – FMA, the AVX-512 IFMA multiply-adds and a LEA
+// that adds two registers – count as one op of their kind by default
+// (-compound fused; a LEA is then address arithmetic and not counted), as
+// their multiply and add (-compound split), or fused with the constituent
+// ops tallied alongside (-compound both); the policy is in the report.
+// The adds and shifts hidden in LEAs and memory-operand addressing can be
+// counted as their own category (-agen category) or folded into add and
+// shl (-agen fold).  The whole instruction mix can be profiled too:
+// branches taken and not, jumps, calls, returns and moves (-mix 1).  The
+// conditional branches a simulated predictor misses most are listed with
+// their source lines and loops (-branches N).  A cache hierarchy can be
+// simulated on the memory accesses to estimate DRAM traffic (-cache SPEC),
+// and the working set tracked as the distinct lines and pages touched per
+// window of N accesses, per function and per phase (-footprint N).  The
+// memory accesses of loops can be classified by stride (-strides N), and
+// their counted ops checked for computations repeated with the same
+// operands (-reuse N).
+// Functions can be left uninstrumented by name glob (-include / -exclude),
+// name regex (-include_func / -exclude_func) or image path regex
+// (-include_module / -exclude_module), all repeatable, and Go binaries split into user code, standard library and
+// runtime (-go 1).  Code a JIT compiler generated is named from the perf
+// map and jitdump files of JVMs and .NET (-jit 1).  Under CPython the
+// counts can go to the Python functions that ran them (-python 1), which
+// the client module's profile hook reports through marker calls.
+// Reports are plain text by default, versioned JSON (-format json), or flat
+// CSV / TSV tables for spreadsheets (-format csv|tsv, -layout long|wide).
+// JSON reports list the images mapped with their ranges and load biases,
+// to relocate the addresses of PIE and shared-library code to link time.
+//
+// Attach mode (pin -pid PID -t …): counts until the process exits, for
+// -duration seconds, or until the -detach_file appears, then detaches and
+// writes the report without stopping the process.
+//
+// Limits (launched runs): -timeout SECONDS, -max_ops N counted operations, or
+// the appearance of -stop_file (holding the reason) end the target as if it
+// had exited, and the report of the counts so far is flagged "truncated".
+// A target killed outright never gets to write one; -partial FILE keeps the
+// totals so far in FILE, updated every -partial_interval seconds, instead.
+//
+// Warm-up (-warmup SECONDS, -warmup_ops N, or -warmup auto): the counts of
+// the first seconds, of the first N counted operations, or up to the steady
+// state are discarded; "auto" waits until the rate of every category stays
+// within -steady_tol percent of its mean over a second of 250 ms intervals.
+//
+// Phases (-phases auto|marker): the report is also broken into phases of
+// the run, each with its own counts.  "auto" starts a phase when the
+// operation mix of two -phase_interval periods in a row differs from that
+// of the current phase by more than -phase_shift percent; "marker" starts
+// one at every Int64ProfilerPhase(name) client-API call.
+//
+// Streaming (-stream SECONDS): every period a JSON line with the cumulative
+// counts and the delta since the previous line is appended to -stream_file
+// (default stderr), and a final one at exit, for watching long runs.  With
+// -stream_funcs N each line also has the cumulative counts of the N
+// functions with the most operations so far.
+//
+// Time series (-timeseries FILE): every -timeseries_interval seconds
+// (default 0.01) the counts of the period are written to FILE as one JSON
+// object per line or, with -timeseries_format csv, one CSV row, stamped
+// with the Unix time for lining them up with application logs.
+//
+// Syscall trace (-syscalls FILE): one line per system call of the launched
+// process, "TID NR RET" with Pin's thread number, for checking that a
+// replayed run saw the same system calls.
+//
+// System-call time (-systime 1): every system call of the workload is
+// timed from entry to exit and reported by call with the process's user
+// and kernel CPU time, apart from the counts, which are of user-mode
+// instructions only.
+//
+// Sampling (-sample FRACTION): execution is cut into per-thread windows of
+// -window instructions and each window is counted with probability
+// FRACTION; totals are extrapolated and reported with 95% confidence
+// intervals.
+//
+// Projection (-projection FILE): the cheapest pass.  Only the executions of
+// each basic block are counted, and written to FILE with the block's code;
+// iccad -backend hybrid classifies the blocks by decoding them and projects
+// the counts as executions × operations per block.  No other knob applies.
 // ─────────────────────────────────────────────────────────────────────────────
 #include "pin.H"
+#include <regex.h>
+#if !defined(TARGET_WINDOWS)
+#include <fcntl.h>
+#include <sys/mman.h>
+#include <sys/stat.h>
+#include <sys/times.h>
+#include <unistd.h>
+#endif
+#include <algorithm>
+#include <cerrno>
+#include <chrono>
+#include <cmath>
+#include <cstring>
+#include <deque>
+#include <fstream>
+#include <iomanip>
 #include <iostream>
+#include <map>
+#include <set>
 #include <sstream>
 #include <string>
+#include <tuple>
+#include <unordered_map>
+#include <unordered_set>
 #include <vector>
 
 // ── command‑line knobs ───────────────────────────────────────────────────────
@@ -24,9 +157,255 @@ KNOB<std::string> knobStart(KNOB_MODE_WRITEONCE, "pintool",
 KNOB<std::string> knobStop(KNOB_MODE_WRITEONCE, "pintool",
                            "stop", "",
                            "Stop marker function name");
+KNOB<std::string> knobRegions(KNOB_MODE_WRITEONCE, "pintool",
+                              "regions", "0",
+                              "Count only inside client-API regions (0‑off, 1‑on)");
+KNOB<std::string> knobChildren(KNOB_MODE_WRITEONCE, "pintool",
+                               "children", "0",
+                               "Follow forked / exec'd children and report each process; run pin with -follow_execv (0‑off, 1‑on)");
+KNOB<std::string> knobRootPid(KNOB_MODE_WRITEONCE, "pintool",
+                              "root_pid", "0",
+                              "Process that writes the report (set by -children for exec'd children)");
 KNOB<std::string> knobDbg(KNOB_MODE_WRITEONCE, "pintool",
                           "dbg",  "0",
                           "Debug verbosity (0‑silent, 1‑info, 2‑verbose)");
+KNOB<std::string> knobFuncs(KNOB_MODE_WRITEONCE, "pintool",
+                            "funcs", "0",
+                            "Per-function attribution (0‑off, 1‑on)");
+KNOB<std::string> knobModules(KNOB_MODE_WRITEONCE, "pintool",
+                              "modules", "0",
+                              "Per-module (executable / shared library) breakdown (0‑off, 1‑on)");
+KNOB<std::string> knobCallgraph(KNOB_MODE_WRITEONCE, "pintool",
+                                "callgraph", "0",
+                                "Calling-context attribution (0‑off, 1‑on)");
+KNOB<std::string> knobFolded(KNOB_MODE_WRITEONCE, "pintool",
+                             "folded", "",
+                             "Write collapsed stacks to this file (implies -callgraph 1)");
+KNOB<std::string> knobFoldedWeight(KNOB_MODE_WRITEONCE, "pintool",
+                                   "folded_weight", "int",
+                                   "Collapsed-stack weight: op types or groups (int, bitwise, vec, wide, fp64, fp32, fp)");
+KNOB<std::string> knobThreads(KNOB_MODE_WRITEONCE, "pintool",
+                              "threads", "0",
+                              "Per-thread breakdown (0‑off, 1‑on)");
+KNOB<std::string> knobPerCpu(KNOB_MODE_WRITEONCE, "pintool",
+                             "percpu", "0",
+                             "Per-CPU and per-NUMA-node breakdown (0‑off, 1‑on)");
+KNOB<std::string> knobLines(KNOB_MODE_WRITEONCE, "pintool",
+                            "lines", "0",
+                            "Per-source-line attribution (0‑off, 1‑on)");
+KNOB<std::string> knobLoops(KNOB_MODE_WRITEONCE, "pintool",
+                            "loops", "0",
+                            "Attribute counts to loops, with entries and trip counts (1 = yes)");
+KNOB<std::string> knobFp(KNOB_MODE_WRITEONCE, "pintool",
+                         "fp", "0",
+                         "Count FP64/FP32 arithmetic (0‑off, 1‑on)");
+KNOB<std::string> knobWide(KNOB_MODE_WRITEONCE, "pintool",
+                           "wide", "0",
+                           "Detect multi-limb integer arithmetic (0‑off, 1‑on)");
+KNOB<std::string> knobWidths(KNOB_MODE_WRITEONCE, "pintool",
+                             "widths", "",
+                             "Break the counts down by operand size: 8, 16, 32, 64, 128, a list of them, or all");
+KNOB<std::string> knobSignedness(KNOB_MODE_WRITEONCE, "pintool",
+                                 "signedness", "0",
+                                 "Split mul and div into signed and unsigned (0‑off, 1‑on)");
+KNOB<std::string> knobAtomics(KNOB_MODE_WRITEONCE, "pintool",
+                              "atomics", "0",
+                              "Count atomic read-modify-writes and futex calls (0‑off, 1‑on)");
+KNOB<std::string> knobVec(KNOB_MODE_WRITEONCE, "pintool",
+                          "vec", "0",
+                          "Count packed int64 lane ops (0‑off, 1‑on)");
+KNOB<std::string> knobMem(KNOB_MODE_WRITEONCE, "pintool",
+                          "mem", "0",
+                          "Count loads, stores and bytes moved (0‑off, 1‑on)");
+KNOB<std::string> knobCache(KNOB_MODE_WRITEONCE, "pintool",
+                            "cache", "",
+                            "Simulate caches, e.g. l1=32k:8,l2=1m:16,llc=32m:16,line=64, or default; implies -mem");
+KNOB<std::string> knobMix(KNOB_MODE_WRITEONCE, "pintool",
+                          "mix", "0",
+                          "Instruction mix: branches, jumps, calls, returns, moves (0‑off, 1‑on)");
+KNOB<std::string> knobButterflies(KNOB_MODE_WRITEONCE, "pintool",
+                                  "butterflies", "0",
+                                  "Count NTT/FFT butterflies per transform; implies -modarith (0‑off, 1‑on)");
+KNOB<std::string> knobBlocks(KNOB_MODE_WRITEONCE, "pintool",
+                             "blocks", "0",
+                             "List the N basic blocks with the most operations, decoded (0 = off)");
+KNOB<std::string> knobProjection(KNOB_MODE_WRITEONCE, "pintool",
+                                 "projection", "",
+                                 "Only count basic-block executions, written with their code to this file (iccad -backend hybrid)");
+KNOB<std::string> knobCompound(KNOB_MODE_WRITEONCE, "pintool",
+                               "compound", "fused",
+                               "Compound instructions (FMA, IFMA, LEA b+i): fused, split or both");
+KNOB<std::string> knobAgen(KNOB_MODE_WRITEONCE, "pintool",
+                           "agen", "off",
+                           "Address-generation arithmetic of LEAs and memory operands: off, category or fold");
+KNOB<std::string> knobDfg(KNOB_MODE_WRITEONCE, "pintool",
+                          "dfg", "0",
+                          "Build the dataflow graph of the counted code; needs -addr, -start, -regions or -include (1 = yes)");
+KNOB<std::string> knobDead(KNOB_MODE_WRITEONCE, "pintool",
+                           "dead", "0",
+                           "Flag the counted results overwritten before being read (0‑off, 1‑on)");
+KNOB<std::string> knobDivs(KNOB_MODE_WRITEONCE, "pintool",
+                           "divs", "0",
+                           "Classify 64-bit divisions by divisor value (0‑off, 1‑on)");
+KNOB<std::string> knobBranches(KNOB_MODE_WRITEONCE, "pintool",
+                               "branches", "0",
+                               "List the N conditional branches a simulated predictor missed most (0 = off)");
+KNOB<std::string> knobStrides(KNOB_MODE_WRITEONCE, "pintool",
+                              "strides", "0",
+                              "Classify loop memory accesses by stride, listing the N loops accessing most (0 = off); implies -loops");
+KNOB<std::string> knobReuse(KNOB_MODE_WRITEONCE, "pintool",
+                            "reuse", "0",
+                            "Check loop ops for repeated identical computations, listing the N loops repeating most (0 = off); implies -loops");
+KNOB<std::string> knobFootprint(KNOB_MODE_WRITEONCE, "pintool",
+                                "footprint", "0",
+                                "Track the working set per window of N data accesses (0 = off)");
+KNOB<std::string> knobMulVals(KNOB_MODE_WRITEONCE, "pintool",
+                              "mulvals", "0",
+                              "Histogram 64-bit multiply operand widths, every Nth multiply (0‑off)");
+KNOB<std::string> knobModArith(KNOB_MODE_WRITEONCE, "pintool",
+                               "modarith", "0",
+                               "Recognize modular multiply/add/sub sequences (0‑off, 1‑on)");
+KNOB<std::string> knobOps(KNOB_MODE_WRITEONCE, "pintool",
+                          "ops", "",
+                          "Extra categories: comma-separated shl,shr,rol,and,or,xor,not or 'bitwise'");
+KNOB<std::string> knobClass(KNOB_MODE_APPEND, "pintool",
+                            "class", "",
+                            "Custom category: name=glob,… over instruction mnemonics (repeatable)");
+KNOB<std::string> knobWatch(KNOB_MODE_APPEND, "pintool",
+                            "watch", "",
+                            "Custom category: name=addr:size:r|w|rw, accesses to main-executable data at its link-time address (repeatable)");
+KNOB<std::string> knobAnnotate(KNOB_MODE_APPEND, "pintool",
+                               "annotate", "",
+                               "Count every instruction of functions matching this glob (repeatable)");
+KNOB<std::string> knobInclude(KNOB_MODE_APPEND, "pintool",
+                              "include", "",
+                              "Count only functions matching this glob (repeatable)");
+KNOB<std::string> knobExclude(KNOB_MODE_APPEND, "pintool",
+                              "exclude", "",
+                              "Do not count functions matching this glob (repeatable)");
+KNOB<std::string> knobIncludeFunc(KNOB_MODE_APPEND, "pintool",
+                                  "include_func", "",
+                                  "Count only functions matching this regex (repeatable)");
+KNOB<std::string> knobExcludeFunc(KNOB_MODE_APPEND, "pintool",
+                                  "exclude_func", "",
+                                  "Do not count functions matching this regex (repeatable)");
+KNOB<std::string> knobIncludeModule(KNOB_MODE_APPEND, "pintool",
+                                    "include_module", "",
+                                    "Count only images whose path matches this regex (repeatable)");
+KNOB<std::string> knobExcludeModule(KNOB_MODE_APPEND, "pintool",
+                                    "exclude_module", "",
+                                    "Do not count images whose path matches this regex (repeatable)");
+KNOB<std::string> knobGo(KNOB_MODE_WRITEONCE, "pintool",
+                         "go", "0",
+                         "Split counts into Go user code, stdlib and runtime (0‑off, 1‑on)");
+KNOB<std::string> knobJit(KNOB_MODE_WRITEONCE, "pintool",
+                          "jit", "0",
+                          "Name JIT-compiled code from perf-PID.map and jit-PID.dump files (0‑off, 1‑on)");
+KNOB<std::string> knobJitDir(KNOB_MODE_WRITEONCE, "pintool",
+                             "jit_dir", "/tmp",
+                             "Directory of the -jit map files");
+KNOB<std::string> knobDebugDir(KNOB_MODE_APPEND, "pintool",
+                               "debug_dir", "",
+                               "Look for stripped images' separate debug info here before /usr/lib/debug (repeatable)");
+KNOB<std::string> knobPython(KNOB_MODE_WRITEONCE, "pintool",
+                             "python", "0",
+                             "Attribute counts to the Python functions the client hook reports (0‑off, 1‑on)");
+KNOB<std::string> knobOut(KNOB_MODE_WRITEONCE, "pintool",
+                          "o", "",
+                          "Report file (empty → stdout)");
+KNOB<std::string> knobSample(KNOB_MODE_WRITEONCE, "pintool",
+                             "sample", "1",
+                             "Fraction of windows to count (1 → count everything)");
+KNOB<std::string> knobWindow(KNOB_MODE_WRITEONCE, "pintool",
+                             "window", "1000000",
+                             "Sampling window length in instructions");
+KNOB<std::string> knobSeed(KNOB_MODE_WRITEONCE, "pintool",
+                           "seed", "1",
+                           "Sampling random seed");
+KNOB<std::string> knobDuration(KNOB_MODE_WRITEONCE, "pintool",
+                               "duration", "0",
+                               "Detach after this many seconds (0 → run to exit)");
+KNOB<std::string> knobDetachFile(KNOB_MODE_WRITEONCE, "pintool",
+                                 "detach_file", "",
+                                 "Detach as soon as this file exists");
+KNOB<std::string> knobTimeout(KNOB_MODE_WRITEONCE, "pintool",
+                              "timeout", "0",
+                              "End the launched target after this many seconds (0 → no limit)");
+KNOB<std::string> knobMaxOps(KNOB_MODE_WRITEONCE, "pintool",
+                             "max_ops", "0",
+                             "End the launched target after this many counted operations (0 → no limit)");
+KNOB<std::string> knobStopFile(KNOB_MODE_WRITEONCE, "pintool",
+                               "stop_file", "",
+                               "End the launched target as soon as this file exists; its content is the reason");
+KNOB<std::string> knobPartial(KNOB_MODE_WRITEONCE, "pintool",
+                              "partial", "",
+                              "Keep the totals so far in this file, mapped shared, for runs killed before their report");
+KNOB<std::string> knobPartialInterval(KNOB_MODE_WRITEONCE, "pintool",
+                                      "partial_interval", "1",
+                                      "Seconds between -partial updates");
+KNOB<std::string> knobWarmup(KNOB_MODE_WRITEONCE, "pintool",
+                             "warmup", "0",
+                             "Discard the counts of this many seconds, or up to the steady state (auto)");
+KNOB<std::string> knobWarmupOps(KNOB_MODE_WRITEONCE, "pintool",
+                                "warmup_ops", "0",
+                                "Discard the counts of this many counted operations");
+KNOB<std::string> knobSteadyTol(KNOB_MODE_WRITEONCE, "pintool",
+                                "steady_tol", "10",
+                                "-warmup auto: rate tolerance in percent");
+KNOB<std::string> knobPhases(KNOB_MODE_WRITEONCE, "pintool",
+                             "phases", "",
+                             "Break the report into phases: auto (by operation mix) or marker");
+KNOB<std::string> knobPhaseInterval(KNOB_MODE_WRITEONCE, "pintool",
+                                    "phase_interval", "0.5",
+                                    "-phases auto: sampling period in seconds");
+KNOB<std::string> knobPhaseShift(KNOB_MODE_WRITEONCE, "pintool",
+                                 "phase_shift", "25",
+                                 "-phases auto: change of the operation mix, in percent, that starts a phase");
+KNOB<std::string> knobStream(KNOB_MODE_WRITEONCE, "pintool",
+                             "stream", "0",
+                             "Emit a snapshot of the counts every this many seconds, e.g. 0.2 (0 → off)");
+KNOB<std::string> knobStreamFile(KNOB_MODE_WRITEONCE, "pintool",
+                                 "stream_file", "",
+                                 "Append the snapshots, one JSON object per line, to this file (default stderr)");
+KNOB<std::string> knobStreamFuncs(KNOB_MODE_WRITEONCE, "pintool",
+                                  "stream_funcs", "0",
+                                  "Add the counts of this many top functions to each snapshot (needs -funcs, 0 → off)");
+KNOB<std::string> knobTimeSeries(KNOB_MODE_WRITEONCE, "pintool",
+                                 "timeseries", "",
+                                 "Write the counts of every -timeseries_interval to this file");
+KNOB<std::string> knobTimeSeriesInterval(KNOB_MODE_WRITEONCE, "pintool",
+                                         "timeseries_interval", "0.01",
+                                         "-timeseries period in seconds");
+KNOB<std::string> knobTimeSeriesFormat(KNOB_MODE_WRITEONCE, "pintool",
+                                       "timeseries_format", "json",
+                                       "-timeseries format (json: one object per line, csv)");
+KNOB<std::string> knobSyscalls(KNOB_MODE_WRITEONCE, "pintool",
+                               "syscalls", "",
+                               "Write a trace of the system calls, one \"tid nr ret\" line each, to this file");
+KNOB<std::string> knobTrace(KNOB_MODE_WRITEONCE, "pintool",
+                            "trace", "",
+                            "Write a trace of the counted code, in -trace_format, to this file");
+KNOB<std::string> knobTraceFormat(KNOB_MODE_WRITEONCE, "pintool",
+                                  "trace_format", "ops",
+                                  "-trace format (ops: a \"tid pc op region\" line per op counted, champsim: the main thread's instructions as ChampSim records)");
+KNOB<std::string> knobSysTime(KNOB_MODE_WRITEONCE, "pintool",
+                              "systime", "0",
+                              "Time the system calls and report them by call (0‑off, 1‑on)");
+KNOB<std::string> knobCheckpoint(KNOB_MODE_WRITEONCE, "pintool",
+                                 "checkpoint", "",
+                                 "Write the accumulated counts to this file every -checkpoint_interval seconds");
+KNOB<std::string> knobCheckpointInterval(KNOB_MODE_WRITEONCE, "pintool",
+                                         "checkpoint_interval", "600",
+                                         "-checkpoint period in seconds");
+KNOB<std::string> knobResume(KNOB_MODE_WRITEONCE, "pintool",
+                             "resume", "",
+                             "Start from the counts of this -checkpoint file");
+KNOB<std::string> knobFormat(KNOB_MODE_WRITEONCE, "pintool",
+                             "format", "text",
+                             "Report format (text, json, csv, tsv)");
+KNOB<std::string> knobLayout(KNOB_MODE_WRITEONCE, "pintool",
+                             "layout", "long",
+                             "CSV/TSV layout (long: one row per count, wide: one row per function)");
 
 static int g_dbg = 0;
 
@@ -35,29 +414,336 @@ static int g_dbg = 0;
          std::cerr << "[Int64Profiler] " << msg << std::endl; } while (0)
 
 // ── per‑thread structures ───────────────────────────────────────────────────
+// Floating-point slots: fp[precision][op], counted in vector lanes
+enum FpPrec { FP64, FP32, FP_PRECS };
+enum FpOp   { FADD, FSUB, FMUL, FDIV, FFMA, FP_OPS };
+// Optional shift / rotate / logic categories: bit[op][0 = rr, 1 = rm]
+enum BitOp  { BSHL, BSHR, BROL, BAND, BOR, BXOR, BNOT, BIT_OPS };
+static const char* BIT_OP_NAMES[BIT_OPS] = {"shl", "shr", "rol", "and", "or", "xor", "not"};
+// Detected wide-integer operations: wide[kind][limbs - 2], 2 … 8+ limbs
+enum WideKind { WADD, WSUB, WMUL, WIDE_KINDS };
+static const int WIDE_SLOTS = 7;
+// Packed int64 lane ops: vec[op]
+enum VecOp  { VADD, VSUB, VMUL, VEC_OPS };
+// Data memory traffic: mem[kind], access counts and bytes
+enum MemKind { MLOADS, MSTORES, MBYTES_R, MBYTES_W, MDRAM_R, MDRAM_W, MEM_KINDS };
+static const int MEM_ACCESS_KINDS = MDRAM_R;    // all but the -cache DRAM bytes
+// Recognized modular operations: mod[kind], four modmul reductions first
+enum ModKind { MOD_MONT, MOD_BARRETT, MOD_SHOUP, MOD_DIV, MOD_ADD, MOD_SUB, MOD_KINDS };
+static const int MOD_MULS = MOD_DIV + 1;
+// -widths: the ops of add, sub, mul, div and the -ops categories by operand
+// size, width[category][slot]; slot W128 holds the 64-bit multiplies and
+// divides of double width
+enum WidthSlot { W8, W16, W32, W64, W128, WIDTH_SLOTS };
+static const int WIDTH_CATS = 4 + BIT_OPS;
+// -signedness: sign[0 = mul, 1 = div][kind]
+enum SignKind { SSIGNED, SUNSIGNED, SIGN_KINDS };
+// -atomics: the atomic read-modify-writes and the futex calls
+enum AtomicKind { AT_RMW, AT_FUTEX, ATOMIC_KINDS };
+
+static const int MAX_CLASSES = 16;   // -class categories
+
+// -compound: how compound instructions count, and the kinds tallied
+// (lanes for packed forms) unless fused
+enum CompoundPolicy { CMP_FUSED, CMP_SPLIT, CMP_BOTH };
+enum CompoundKind { CFMA64, CFMA32, CVMADD, CLEA, CLEA_SCALED, COMPOUND_KINDS };
+
+// -agen: how address arithmetic counts, and its adds and shifts by source
+enum AgenMode { AGEN_OFF, AGEN_CATEGORY, AGEN_FOLD };
+enum AgenKind { AG_LEA_ADD, AG_LEA_SHL, AG_MEM_ADD, AG_MEM_SHL, AGEN_KINDS };
+
+// -mix: every instruction, and those of each control and move kind
+enum MixKind { MX_INSNS, MX_TAKEN, MX_NOT_TAKEN, MX_JUMP, MX_CALL, MX_RET, MX_INDIRECT,
+               MX_MOVE_REG, MX_MOVE_MEM, MX_MOVE_IMM, MIX_KINDS };
+
 struct alignas(64) Cnts {
     UINT64 add_rr{}, sub_rr{}, adc_rr{}, sbb_rr{};
     UINT64 mul_rr{}, mulx_rr{}, adcx_rr{}, adox_rr{}, div_rr{};
     UINT64 add_rm{}, sub_rm{}, adc_rm{}, sbb_rm{};
     UINT64 mul_rm{}, mulx_rm{}, adcx_rm{}, adox_rm{}, div_rm{};
+    UINT64 lea_rr{};                // -compound split: a LEA b+i as an add
+    UINT64 agen[AGEN_KINDS]{};      // -agen
+    UINT64 bit[BIT_OPS][2]{};
+    UINT64 wide[WIDE_KINDS][WIDE_SLOTS]{};
+    UINT64 vec[VEC_OPS]{};
+    UINT64 mem[MEM_KINDS]{};
+    UINT64 mod[MOD_KINDS]{};
+    UINT64 fp[FP_PRECS][FP_OPS]{};
+    UINT64 width[WIDTH_CATS][WIDTH_SLOTS]{};
+    UINT64 sign[2][SIGN_KINDS]{};
+    UINT64 atomic[ATOMIC_KINDS]{};
+    UINT64 cls[MAX_CLASSES]{};   // last: NumWords ends here
+};
+
+// Per-window sums for the sampling estimator: x = instructions in the
+// window, y[c] = count of category c (add, sub, mul, div) in the window.
+struct SampleStats {
+    double n = 0, sx = 0, sxx = 0;
+    double sy[4]{}, syy[4]{}, sxy[4]{};
+};
+
+// One calling context: a function reached through the parent's path
+struct CtxNode {
+    UINT32 func;                    // function id; NO_FUNC for the root
+    UINT32 parent;
+    Cnts   cnts;                    // exclusive counts in this context
+};
+
+// A live shadow-stack frame: the stack pointer at function entry
+struct Frame {
+    ADDRINT sp;
+    UINT32  node;
+};
+
+// Outcomes of one conditional branch in one thread, and the state of the
+// predictors simulated on it: a 2-bit counter, and 2-bit counters indexed
+// by its last BR_HISTORY outcomes
+static const UINT32 BR_HISTORY = 8;
+struct BranchStats {
+    UINT64 n = 0, taken = 0, flips = 0, miss_bimodal = 0, miss_local = 0;
+    UINT32 hist = 0;
+    bool   last = false;
+    UINT8  bimodal = 2;             // weakly taken
+    UINT8  local[1 << BR_HISTORY];
+    BranchStats() { std::fill(local, local + (1 << BR_HISTORY), UINT8(2)); }
+};
+
+// Strides of one loop memory operand in one thread: its last address and
+// step, and its accesses by class
+struct StrideStats {
+    ADDRINT last = 0;
+    ADDRDELTA step = 0;
+    UINT64  n = 0, unit = 0, constant = 0, irregular = 0;
+};
+
+// Repeated computations of one loop op in one thread (-reuse): its last
+// operands, and its executions, those with the same operands as the one
+// before and those found in the reuse buffer
+struct ReuseStats {
+    UINT64 last[3] = {};
+    UINT64 n = 0, same = 0, hits = 0;
+};
+
+// An entry of a thread's reuse buffer: an opcode and its operands
+struct ReuseEntry {
+    UINT64 v[3] = {};
+    UINT32 opc = 0;                 // XED_ICLASS_INVALID when empty
+};
+
+// One simulated cache level of a thread: the line held by each way of
+// each set (line number + 1, 0 when empty), when it was last used, and
+// for the last level whether it is dirty
+struct CacheState {
+    std::vector<UINT64> tags, used;
+    std::vector<UINT8>  dirty;
+    UINT64 accesses = 0, misses = 0;
+};
+
+// The distinct lines and pages one scope (a thread, function or phase)
+// touched, each stamped with the -footprint window it was last counted in,
+// and how many of them its current window and fullest window touched
+struct FootSet {
+    std::unordered_map<UINT64, UINT64> lines, pages;
+    UINT64 window = ~0ull;
+    UINT64 win_lines = 0, win_pages = 0;
+    UINT64 peak_lines = 0, peak_pages = 0;
+};
+
+// Divisors seen by one DIV/IDIV instruction: executions, how many divided
+// by a power of two, and the first distinct divisors
+static const UINT32 DIV_VALUES = 8;
+struct DivStats {
+    UINT64 n = 0, pow2 = 0;
+    UINT64 vals[DIV_VALUES]{};
+    UINT32 nvals = 0;               // DIV_VALUES + 1 once more were seen
+};
+
+// Time one thread spent in one system call: calls, those that failed,
+// and the total and longest time from entry to exit in nanoseconds
+struct SysTime {
+    UINT64 calls = 0, errors = 0, ns = 0, max_ns = 0;
+};
+
+// Multiply operand widths in bits (0..64), of the wider and the narrower
+// operand of each sampled multiply
+static const int MUL_WIDTHS = 65;
+
+// -dead: one result of a counted op, held in refs registers and memory
+// chunks, used once anything but a plain move has read it
+struct DeadVal {
+    UINT32 site;
+    UINT32 refs;
+    bool   used;
 };
 
 struct alignas(64) ThreadState {
-    Cnts  cnts;
-    bool  active = false;
+    Cnts               cnts;
+    std::vector<Cnts>  sites;       // indexed by site id
+    std::vector<DivStats> divs;     // -divs: indexed by division site id
+    std::vector<BranchStats> branches;  // -branches: indexed by branch site id
+    std::vector<StrideStats> strides;   // -strides: indexed by stride site id
+    std::vector<ReuseStats> reuse;      // -reuse: indexed by reuse site id
+    std::vector<ReuseEntry> reuse_buf;  // -reuse: REUSE_ENTRIES once it ran
+    std::vector<CacheState> cache;  // -cache: the simulated levels, nearest first
+    UINT64             cache_clock = 0;
+    FootSet            foot;            // -footprint: the thread's working set
+    std::unordered_map<UINT32, FootSet> foot_funcs;   // by function id
+    std::vector<FootSet> foot_phases;   // by phase index
+    std::vector<std::pair<UINT64, UINT64>> foot_curve;  // lines, pages of each full window
+    UINT64             foot_n = 0;      // data accesses seen
+    std::vector<UINT64> block_execs;  // -blocks: indexed by block id
+    std::vector<UINT64> ann_execs;    // -annotate: indexed by instruction id
+    UINT64             compound[COMPOUND_KINDS]{};  // -compound split|both
+    UINT64             mix[MIX_KINDS]{};            // -mix
+    // -dfg: the node that produced each register's and each 8-byte memory
+    // chunk's value, node executions and (producer, consumer) edge counts
+    std::vector<UINT32> dfg_regs;
+    std::unordered_map<ADDRINT, UINT32> dfg_mem;
+    std::vector<UINT64> dfg_execs;
+    std::unordered_map<UINT64, UINT64> dfg_edges;
+    // -dead: the value each register and each 8-byte memory chunk holds,
+    // the values (freed ones listed for reuse) and each site's results
+    // and dead results
+    std::vector<UINT32> dead_regs;
+    std::unordered_map<ADDRINT, UINT32> dead_mem;
+    std::vector<DeadVal> dead_vals;
+    std::vector<UINT32> dead_free;
+    std::vector<UINT64> dead_results, dead_n;
+    UINT64             mulw[2][MUL_WIDTHS]{};  // -mulvals: [wider, narrower]
+    UINT64             mul_seen = 0;           // -mulvals: multiplies seen
+    // -butterflies: butterflies since each function's last entry, and the
+    // finished invocations keyed by (function, butterflies)
+    std::vector<UINT64> bfly_open;
+    std::map<std::pair<UINT32, UINT64>, UINT64> bfly_calls;
+    bool               active = false;
+
+    // sampling windows (-sample < 1)
+    UINT64             icount = 0;  // instructions in the current window
+    bool               sampled = true;
+    UINT64             rng = 0;
+    Cnts               win_start;   // cnts when the current window began
+    UINT64             insns = 0, insns_sampled = 0;
+    UINT64             windows = 0, windows_sampled = 0;
+    SampleStats        stats;
+
+    // -regions: open regions (innermost last) with the counts at entry, and
+    // each region's accumulated counts, indexed by region id
+    struct Open { UINT32 id; Cnts at; };
+    std::vector<Open>  open;
+    std::vector<Cnts>  regions;
+    std::vector<UINT64> entries;
+
+    // -python: the Python frames the hook reported (function ids, innermost
+    // last), the counts at the last call or return, each function's own
+    // counts and calls by id, the ids of marker arguments already read, and
+    // how many profile hooks are running, which are not counted
+    std::vector<UINT32> py_stack;
+    Cnts               py_mark;
+    std::vector<Cnts>  py_self;
+    std::vector<UINT64> py_calls;
+    std::map<std::tuple<ADDRINT, ADDRINT, ADDRINT>, UINT32> py_ids;
+    UINT32             py_hook = 0;
+
+    // -loops: executions of each loop header and taken back edges,
+    // indexed by loop id
+    std::vector<UINT64> loop_heads, loop_backs;
+
+    // -callgraph: calling-context tree (node 0 = root) and shadow stack
+    std::vector<CtxNode> nodes;
+    std::map<std::pair<UINT32, UINT32>, UINT32> children;   // (parent, func)
+    std::vector<Frame> frames;
+    UINT32             node = 0;    // context of the running code
+
+    // identity / lifecycle, for the per-thread breakdown
+    THREADID           tid = 0;
+    OS_THREAD_ID       os_tid = INVALID_OS_THREAD_ID;
+    OS_THREAD_ID       parent = INVALID_OS_THREAD_ID;
+    bool               exited = false;
+    INT32              exit_code = 0;
+    INT64              syscall = -1;  // -syscalls: the call in progress
+    INT64              sys_nr = -1;   // -systime: the call in progress,
+    UINT64             sys_t0 = 0;    // entered at this steady-clock ns
+    std::map<INT64, SysTime> sys;     // -systime: by call number
+    std::string        trace;         // -trace: records not yet written
+    // -percpu: the counts charged to each CPU the thread was seen on, the
+    // counts at the last charge and the CPU it was last seen on
+    std::map<INT32, Cnts> cpus;
+    Cnts               cpu_mark;
+    INT32              cpu = -1;
 };
 
 static TLS_KEY                     tlsKey;
 static PIN_LOCK                    g_lock;
 static std::vector<ThreadState*>   g_all;
 
+// -trace: the format, off in a forked child (the trace is the parent's)
+enum TraceFormat { TRACE_OFF, TRACE_OPS, TRACE_CHAMPSIM };
+static TraceFormat                 g_trace = TRACE_OFF;
+static std::ofstream               g_trace_out;
+
 // Mode detection
-enum Mode { WHOLE, ADDRESS, MARKER };
+enum Mode { WHOLE, ADDRESS, MARKER, REGIONS };
 static Mode g_mode = WHOLE;
-static ADDRINT g_start_addr = 0;
+static bool g_threads_on = false;
+static bool g_percpu_on = false;
+static bool g_fp_on = false;
+static CompoundPolicy g_compound = CMP_FUSED;
+static AgenMode g_agen = AGEN_OFF;
+static bool g_bit_on[BIT_OPS] = {};  // categories selected with -ops
+static bool g_bits_on = false;       // any of them
+static bool g_wide_on = false;
+static bool g_width_on[WIDTH_SLOTS] = {};  // operand sizes selected with -widths
+static bool g_widths_on = false;           // any of them
+static bool g_sign_on = false;             // -signedness
+static bool g_atomics_on = false;          // -atomics
+static bool g_vec_on = false;
+static bool g_mem_on = false;
+static bool g_mix_on = false;
+static bool g_divs_on = false;
+static bool g_mod_on = false;
+static bool g_bfly_on = false;
+static UINT64 g_mulvals = 0;         // -mulvals period, 0 = off
+static bool g_go_on = false;
+static bool g_jit_on = false;        // -jit
+static bool g_py_on = false;         // -python
+static bool g_calls_on = false;      // -callgraph (or -folded)
+static bool g_children_on = false;   // -children
+static INT  g_root_pid = 0;          // pid writing the report
+static const char* g_started = "launch";   // how this process image began
+static double g_stream = 0;          // -stream period in seconds, 0 = off
+static UINT64 g_stream_funcs = 0;    // -stream_funcs
+static bool g_sampling = false;
+static double g_sample_frac = 1.0;
+static UINT64 g_window = 1000000;
+static UINT64 g_seed = 1;
+static ADDRINT g_start_addr = 0;   // link-time address, as nm or the PDB give it
+static ADDRINT g_load_offset = 0;  // of the main executable (PIE, ASLR'd PE)
 static std::string g_start_marker = "";
 static std::string g_stop_marker = "";
 
+// Run metadata (for the JSON report)
+static std::string               g_binary;
+static std::vector<std::string>  g_args;
+struct DebugFile { std::string image, path, build_id, via; };
+static std::vector<DebugFile>    g_debug_files;   // -debug_dir: images named from them
+// What the counts leave out or cannot attribute, for the report's
+// diagnostics: level "info" or "warn", kind as the Go package names them
+struct Diag { const char* level; const char* kind; std::string image; UINT64 count; std::string msg; };
+static std::vector<Diag>         g_diags;         // found while loading images
+// Every image mapped, with its range and load bias (what the loader added
+// to its link-time addresses), for relocating the report's addresses
+struct ImageMap { std::string path; ADDRINT low, high, bias; };
+static std::vector<ImageMap>     g_images;
+static std::chrono::steady_clock::time_point g_t0;
+static bool g_attached = false;      // Pin was attached with -pid
+static bool g_detached = false;      // the report is written at detach
+static std::string g_truncated;          // limit that ended the run, "" = none
+static double      g_truncated_at = 0;   // seconds into the run
+static const char* g_warmup = nullptr;   // -warmup mode: "time", "ops", "auto"
+static bool        g_warmup_done = false;
+static double      g_warmup_at = 0;      // seconds into the run it ended
+static Cnts        g_warmup_cnts;        // the discarded counts
+
 static inline ThreadState* St(THREADID tid)
 {
     return static_cast<ThreadState*>(PIN_GetThreadData(tlsKey, tid));
@@ -65,7 +751,761 @@ static inline ThreadState* St(THREADID tid)
 
 static inline bool Counting(THREADID tid)
 {
-    return g_mode == WHOLE || St(tid)->active;
+    const ThreadState* st = St(tid);
+    return (g_mode == WHOLE || st->active) && !st->py_hook;
+}
+
+// Cnts is nothing but UINT64 counters, from add_rr to the end of cls
+static inline UINT64* Words(Cnts& c) { return &c.add_rr; }
+static inline const UINT64* Words(const Cnts& c) { return &c.add_rr; }
+static inline size_t NumWords(const Cnts& c)
+{
+    return size_t(&c.cls[0] + MAX_CLASSES - &c.add_rr);
+}
+
+static bool Zero(const Cnts& c)
+{
+    const UINT64* w = Words(c);
+    return std::all_of(w, w + NumWords(c), [](UINT64 n) { return n == 0; });
+}
+
+// ── sampling windows ───────────────────────────────────────────────────────
+// Counter calls are guarded by InSample() so unsampled windows only pay for
+// the inlined predicate and the per-block instruction tally.
+static VOID EndWindow(ThreadState* st);     // defined with the report code
+
+static inline UINT64 NextRandom(ThreadState* st)
+{
+    // xorshift64*
+    st->rng ^= st->rng >> 12;
+    st->rng ^= st->rng << 25;
+    st->rng ^= st->rng >> 27;
+    return st->rng * 2685821657736338717ULL;
+}
+
+static inline bool DrawSample(ThreadState* st)
+{
+    return (NextRandom(st) >> 11) * (1.0 / 9007199254740992.0) < g_sample_frac;
+}
+
+static ADDRINT PIN_FAST_ANALYSIS_CALL InSample(THREADID tid)
+{
+    return St(tid)->sampled;
+}
+
+static ADDRINT PIN_FAST_ANALYSIS_CALL WindowFull(THREADID tid, UINT32 ninst)
+{
+    ThreadState* st = St(tid);
+    st->icount += ninst;
+    return st->icount >= g_window;
+}
+
+static VOID NextWindow(THREADID tid)
+{
+    EndWindow(St(tid));
+}
+
+static VOID InstrumentWindows(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        BBL_InsertIfCall(bbl, IPOINT_BEFORE, (AFUNPTR)WindowFull,
+                         IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID,
+                         IARG_UINT32, BBL_NumIns(bbl), IARG_END);
+        BBL_InsertThenCall(bbl, IPOINT_BEFORE, (AFUNPTR)NextWindow,
+                           IARG_THREAD_ID, IARG_END);
+    }
+}
+
+// ── function filters ───────────────────────────────────────────────────────
+// -include / -exclude globs over function names: '*' matches any run of
+// characters (dots and slashes included), '?' one character, [...] a class.
+// -include_func / -exclude_func are POSIX extended regexes over the same
+// names and -include_module / -exclude_module over image paths; regexes
+// match anywhere in the string unless anchored.  A function is counted when
+// it passes every kind of include given and matches no exclude.
+enum FilterKey { F_INCLUDE, F_EXCLUDE, F_INCLUDE_FUNC, F_EXCLUDE_FUNC,
+                 F_INCLUDE_MODULE, F_EXCLUDE_MODULE, FILTER_KEYS };
+static const char* FILTER_KEY_NAMES[FILTER_KEYS] = {
+    "include", "exclude", "include_func", "exclude_func", "include_module", "exclude_module"};
+
+struct Filter {
+    std::string pat;
+    regex_t     re;                 // compiled, except for globs
+};
+static std::vector<Filter> g_filters[FILTER_KEYS];
+
+static bool GlobMatch(const char* p, const char* s)
+{
+    for (; *p; ++p, ++s) {
+        switch (*p) {
+        case '*':
+            while (p[1] == '*') ++p;
+            for (const char* t = s; ; ++t) {
+                if (GlobMatch(p + 1, t)) return true;
+                if (!*t) return false;
+            }
+        case '?':
+            if (!*s) return false;
+            break;
+        case '[': {
+            if (!*s) return false;
+            const char* q = p + 1;
+            bool neg = *q == '!' || *q == '^';
+            if (neg) ++q;
+            bool hit = false;
+            for (bool first = true; *q && (first || *q != ']'); first = false, ++q) {
+                if (q[1] == '-' && q[2] && q[2] != ']') {
+                    hit = hit || (*s >= *q && *s <= q[2]);
+                    q += 2;
+                } else {
+                    hit = hit || *s == *q;
+                }
+            }
+            if (!*q) {                  // no closing ']': a literal '['
+                if (*s != '[') return false;
+                break;
+            }
+            if (hit == neg) return false;
+            p = q;
+            break;
+        }
+        default:
+            if (*s != *p) return false;
+        }
+    }
+    return !*s;
+}
+
+static bool FilterMatch(int key, const std::string& s)
+{
+    for (const auto& f : g_filters[key]) {
+        bool hit = key == F_INCLUDE || key == F_EXCLUDE
+                       ? GlobMatch(f.pat.c_str(), s.c_str())
+                       : regexec(&f.re, s.c_str(), 0, nullptr, 0) == 0;
+        if (hit) return true;
+    }
+    return false;
+}
+
+static bool Filtering()
+{
+    for (const auto& f : g_filters)
+        if (!f.empty()) return true;
+    return false;
+}
+
+// Reads the -KEY values into g_filters[key]; false on a bad regex
+static bool ParseFilters(int key, KNOB<std::string>& knob)
+{
+    for (UINT32 i = 0; i < knob.NumberOfValues(); ++i) {
+        Filter f;
+        f.pat = knob.Value(i);
+        if (f.pat.empty()) continue;
+        if (key != F_INCLUDE && key != F_EXCLUDE) {
+            int rc = regcomp(&f.re, f.pat.c_str(), REG_EXTENDED | REG_NOSUB);
+            if (rc != 0) {
+                char msg[128];
+                regerror(rc, &f.re, msg, sizeof msg);
+                std::cerr << "Int64Profiler: bad -" << FILTER_KEY_NAMES[key] << " regex '"
+                          << f.pat << "': " << msg << std::endl;
+                return false;
+            }
+        }
+        g_filters[key].push_back(f);
+    }
+    return true;
+}
+
+// ── JIT code maps (-jit) ────────────────────────────────────────────────────
+// JIT-compiled code lies in no image, so Pin finds no routine for it.  With
+// -jit it is named from the maps runtimes write for perf in -jit_dir:
+// perf-PID.map, a "START SIZE name" line (hex) per method, written by .NET
+// with DOTNET_PerfMapEnabled and by JVM agents such as perf-map-agent, and
+// the binary jitdump file jit-PID.dump of JVMTI agents (perf's
+// libperf-jvmti.so) and .NET.  Both grow as methods are compiled, so a
+// lookup that misses first reads what was appended since the last one.
+// Code freed and recompiled at the same address takes the newest name.
+
+struct JitSym {
+    ADDRINT     end;
+    std::string name;
+    std::string file;               // the map it came from, its image
+};
+
+struct JitMap {
+    std::string path;
+    bool        dump;               // jitdump, else perf map
+    UINT64      off = 0;            // bytes consumed
+};
+
+static std::map<ADDRINT, JitSym> g_jit_syms;    // by start address
+static std::vector<JitMap>       g_jit_maps;
+
+static VOID JitAdd(ADDRINT start, UINT64 size, const std::string& name, const std::string& file)
+{
+    if (size == 0) return;
+    // drop what the new code overlaps
+    auto it = g_jit_syms.lower_bound(start);
+    if (it != g_jit_syms.begin() && std::prev(it)->second.end > start) --it;
+    while (it != g_jit_syms.end() && it->first < start + size) it = g_jit_syms.erase(it);
+    g_jit_syms[start] = JitSym{start + size, name, file};
+}
+
+// Reads the perf map lines appended since m.off; a partial last line is
+// left for the next read.
+static VOID JitReadPerfMap(JitMap& m)
+{
+    std::ifstream in(m.path.c_str(), std::ios::binary);
+    if (!in) return;
+    in.seekg(static_cast<std::streamoff>(m.off));
+    std::string line;
+    while (std::getline(in, line)) {
+        if (in.eof()) break;        // no newline yet
+        m.off += line.size() + 1;
+        char* end;
+        ADDRINT start = strtoull(line.c_str(), &end, 16);
+        UINT64 size = strtoull(end, &end, 16);
+        while (*end == ' ') end++;
+        if (*end) JitAdd(start, size, end, m.path);
+    }
+}
+
+// Reads the jitdump records appended since m.off: JIT_CODE_LOAD (0) adds
+// a method, JIT_CODE_MOVE (1) moves one; the others are skipped.
+static VOID JitReadDump(JitMap& m)
+{
+    std::ifstream in(m.path.c_str(), std::ios::binary);
+    if (!in) return;
+    std::string data((std::istreambuf_iterator<char>(in)), std::istreambuf_iterator<char>());
+    auto u32 = [&](size_t at) { UINT32 v; memcpy(&v, &data[at], 4); return v; };
+    auto u64 = [&](size_t at) { UINT64 v; memcpy(&v, &data[at], 8); return v; };
+    if (m.off == 0) {
+        // header: magic, version, header size, ...
+        if (data.size() < 12 || u32(0) != 0x4A695444) return;
+        m.off = u32(8);
+    }
+    while (m.off + 16 <= data.size()) {
+        UINT32 id = u32(m.off), size = u32(m.off + 4);
+        if (size < 16 || m.off + size > data.size()) break;
+        size_t body = m.off + 16;
+        if (id == 0 && size >= 16 + 40) {
+            size_t name = body + 40, nul = data.find('\0', name);
+            if (nul != std::string::npos && nul < m.off + size)
+                JitAdd(u64(body + 16), u64(body + 24), data.substr(name, nul - name), m.path);
+        } else if (id == 1 && size >= 16 + 48) {
+            // pid, tid, vma, old code address, new code address, size, index
+            auto it = g_jit_syms.find(u64(body + 16));
+            if (it != g_jit_syms.end()) {
+                JitSym s = it->second;
+                g_jit_syms.erase(it);
+                JitAdd(u64(body + 24), u64(body + 32), s.name, s.file);
+            }
+        }
+        m.off += size;
+    }
+}
+
+// The JIT symbol holding addr, or null.
+static const JitSym* JitFind(ADDRINT addr, ADDRINT* start = nullptr)
+{
+    for (int pass = 0; pass < 2; pass++) {
+        auto it = g_jit_syms.upper_bound(addr);
+        if (it != g_jit_syms.begin() && std::prev(it)->second.end > addr) {
+            if (start) *start = std::prev(it)->first;
+            return &std::prev(it)->second;
+        }
+        if (pass == 0) {
+            static INT pid = 0;
+            if (pid != PIN_GetPid()) {      // a forked child has maps of its own
+                pid = PIN_GetPid();
+                g_jit_maps.clear();
+                g_jit_syms.clear();
+            }
+            if (g_jit_maps.empty()) {
+                std::string dir = knobJitDir.Value(), pid = decstr(PIN_GetPid());
+                g_jit_maps.push_back(JitMap{dir + "/perf-" + pid + ".map", false});
+                g_jit_maps.push_back(JitMap{dir + "/jit-" + pid + ".dump", true});
+            }
+            for (auto& m : g_jit_maps) m.dump ? JitReadDump(m) : JitReadPerfMap(m);
+        }
+    }
+    return nullptr;
+}
+
+// Whether -include_module and -exclude_module leave the image out
+static bool ModuleSkipped(const std::string& image)
+{
+    return (!g_filters[F_INCLUDE_MODULE].empty() && !FilterMatch(F_INCLUDE_MODULE, image)) ||
+           FilterMatch(F_EXCLUDE_MODULE, image);
+}
+
+// Whether the code is counted; decided once per routine.  Code outside any
+// routine is judged by its image alone.
+static bool Counted(INS ins)
+{
+    static std::map<ADDRINT, bool> cache;
+    RTN rtn = INS_Rtn(ins);
+    if (RTN_Valid(rtn)) {
+        auto it = cache.find(RTN_Address(rtn));
+        if (it != cache.end()) return it->second;
+    }
+
+    std::string name = RTN_Valid(rtn) ? RTN_Name(rtn) : "[unknown]";
+    if (!RTN_Valid(rtn) && g_jit_on) {
+        if (const JitSym* s = JitFind(INS_Address(ins))) name = s->name;
+    }
+    IMG img = RTN_Valid(rtn) ? SEC_Img(RTN_Sec(rtn)) : IMG_FindByAddress(INS_Address(ins));
+    std::string image = IMG_Valid(img) ? IMG_Name(img) : "";
+
+    bool on = (g_filters[F_INCLUDE].empty() && g_filters[F_INCLUDE_FUNC].empty()) ||
+              FilterMatch(F_INCLUDE, name) || FilterMatch(F_INCLUDE_FUNC, name);
+    on = on && !ModuleSkipped(image) && !FilterMatch(F_EXCLUDE, name) &&
+         !FilterMatch(F_EXCLUDE_FUNC, name);
+    if (!RTN_Valid(rtn)) return on;
+    DBG(2, "Filter: " << name << " [" << image << "]" << (on ? " counted" : " skipped"));
+    return cache[RTN_Address(rtn)] = on;
+}
+
+static VOID InsertTraceOp(INS ins, const char* op);   // -trace ops
+
+// Inserts a fast counter call (THREADID first, then args), guarded by the
+// sampling predicate when sampling is on.  Takes ownership of args.  Every
+// counter goes through here, so filtered functions get no analysis calls
+// at all.
+static VOID InsertCounter(INS ins, AFUNPTR fn, IARGLIST args)
+{
+    if (Filtering() && !Counted(ins)) {
+        IARGLIST_Free(args);
+        return;
+    }
+    if (g_sampling) {
+        INS_InsertIfCall(ins, IPOINT_BEFORE, (AFUNPTR)InSample,
+                         IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_END);
+        INS_InsertThenCall(ins, IPOINT_BEFORE, fn,
+                           IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID,
+                           IARG_IARGLIST, args, IARG_END);
+    } else {
+        INS_InsertCall(ins, IPOINT_BEFORE, fn,
+                       IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID,
+                       IARG_IARGLIST, args, IARG_END);
+    }
+    IARGLIST_Free(args);
+}
+
+// ── function / source-line attribution ─────────────────────────────────────
+// Every instrumented instruction maps to a "site": its function plus, with
+// -lines 1, its source line.  Site ids are handed out at instrumentation time
+// (serialised by Pin's client lock), so analysis code only ever touches its
+// own thread's vector.  Reports fold sites back into functions and lines.
+// -go: where a function's code comes from
+enum GoOrigin { GO_USER, GO_STDLIB, GO_RUNTIME, GO_OTHER, GO_ORIGINS };
+static const char* GO_ORIGIN_NAMES[GO_ORIGINS] = {"user", "stdlib", "runtime", "other"};
+
+struct FuncInfo {
+    std::string name;
+    std::string image;
+    std::string file;               // from DWARF; empty if unavailable
+    INT32       line = 0;
+    GoOrigin    origin = GO_OTHER;  // with -go 1
+    UINT32      module = ~0u;       // index into g_modules; ~0u if unknown
+    bool        carried = false;    // only counts -resume carried over
+};
+
+// A loaded image: the executable, a shared library it was linked with
+// ("startup") or one loaded later with dlopen()
+struct ModuleInfo {
+    std::string path;
+    bool        dynamic = false;
+    bool        unloaded = false;
+};
+
+struct LineInfo {
+    std::string file;
+    INT32       line = 0;
+};
+
+struct SiteInfo {
+    UINT32 func;
+    UINT32 line;                    // NO_SITE unless -lines 1
+    UINT32 loop;                    // innermost loop; NO_SITE outside loops
+};
+
+static const UINT32                NO_SITE = ~0u;
+static bool                        g_funcs_on = false;
+static bool                        g_lines_on = false;
+static bool                        g_loops_on = false;
+static std::vector<FuncInfo>       g_funcs;
+static std::vector<LineInfo>       g_lines;
+static std::vector<SiteInfo>       g_sites;
+static std::map<ADDRINT, UINT32>   g_func_ids;      // RTN start → id
+static bool                        g_modules_on = false;
+static std::vector<ModuleInfo>     g_modules;       // in load order
+static std::map<UINT32, UINT32>    g_module_ids;    // IMG_Id → index
+static bool                        g_entered = false;   // program entry ran
+static bool                        g_modules_exit = false;
+static std::map<std::pair<std::string, INT32>, UINT32> g_line_ids;
+static std::map<std::tuple<UINT32, UINT32, UINT32>, UINT32> g_site_ids;
+static std::map<ADDRINT, UINT32>   g_loop_at;       // -loops: instruction → innermost loop
+
+// Go symbols are "import/path.Name": the runtime (and the internal packages,
+// compiler-generated helpers and package-less assembly bodies such as
+// memeqbody it is made of), the standard library (first path element
+// without a dot), else user code.  Only images that define runtime.main are
+// taken for Go, so C names like "foo.cold" elsewhere are not.
+static GoOrigin GoOriginOf(const std::string& name)
+{
+    static const char* const runtime[] = {
+        "runtime.", "runtime/internal/", "internal/runtime/", "internal/bytealg.",
+        "internal/abi.", "internal/cpu.", "internal/chacha8rand.",
+        "type:", "type..", "go:", "_rt0_", "_cgo_", "x_cgo_", "crosscall",
+    };
+    for (const char* p : runtime)
+        if (name.rfind(p, 0) == 0) return GO_RUNTIME;
+
+    // the package path ends at the first dot after its last slash; type
+    // arguments in brackets may hold slashes of their own
+    std::string path = name.substr(0, name.find('['));
+    size_t slash = path.rfind('/');
+    size_t dot = path.find('.', slash == std::string::npos ? 0 : slash);
+    if (dot == std::string::npos) return GO_RUNTIME;
+    path.resize(dot);
+    if (path == "main") return GO_USER;
+    if (path.compare(0, 7, "vendor/") == 0) return GO_STDLIB;
+    std::string elem = path.substr(0, path.find('/'));
+    return elem.find('.') == std::string::npos ? GO_STDLIB : GO_USER;
+}
+
+static bool IsGoImage(IMG img)
+{
+    static std::map<UINT32, bool> cache;
+    auto it = cache.find(IMG_Id(img));
+    if (it != cache.end()) return it->second;
+    return cache[IMG_Id(img)] = RTN_Valid(RTN_FindByName(img, "runtime.main"));
+}
+
+static UINT32 FuncId(RTN rtn)
+{
+    ADDRINT key = RTN_Valid(rtn) ? RTN_Address(rtn) : 0;
+
+    auto it = g_func_ids.find(key);
+    if (it != g_func_ids.end()) return it->second;
+
+    FuncInfo fi;
+    if (RTN_Valid(rtn)) {
+        fi.name  = RTN_Name(rtn);
+        fi.image = IMG_Name(SEC_Img(RTN_Sec(rtn)));
+        PIN_GetSourceLocation(key, nullptr, &fi.line, &fi.file);
+        if (g_go_on && IsGoImage(SEC_Img(RTN_Sec(rtn)))) fi.origin = GoOriginOf(fi.name);
+        auto m = g_module_ids.find(IMG_Id(SEC_Img(RTN_Sec(rtn))));
+        if (m != g_module_ids.end()) fi.module = m->second;
+    } else {
+        fi.name = "[unknown]";
+    }
+
+    UINT32 id = static_cast<UINT32>(g_funcs.size());
+    g_funcs.push_back(fi);
+    g_func_ids[key] = id;
+    DBG(2, "Function #" << id << ": " << fi.name);
+    return id;
+}
+
+// Code outside any routine is a function of its own when a JIT map names
+// it, keyed by start address and name as code addresses get reused.
+static UINT32 FuncId(INS ins)
+{
+    RTN rtn = INS_Rtn(ins);
+    ADDRINT start;
+    const JitSym* s;
+    if (RTN_Valid(rtn) || !g_jit_on || !(s = JitFind(INS_Address(ins), &start))) return FuncId(rtn);
+
+    static std::map<std::pair<ADDRINT, std::string>, UINT32> ids;
+    auto key = std::make_pair(start, s->name);
+    auto it = ids.find(key);
+    if (it != ids.end()) return it->second;
+    FuncInfo fi;
+    fi.name = s->name;
+    fi.image = s->file;
+    UINT32 id = static_cast<UINT32>(g_funcs.size());
+    g_funcs.push_back(fi);
+    ids[key] = id;
+    DBG(2, "JIT function #" << id << ": " << fi.name);
+    return id;
+}
+
+// Instructions without line info collapse into a single "??:0" entry
+static UINT32 LineId(INS ins)
+{
+    LineInfo li;
+    PIN_GetSourceLocation(INS_Address(ins), nullptr, &li.line, &li.file);
+    if (li.file.empty()) { li.file = "??"; li.line = 0; }
+
+    auto key = std::make_pair(li.file, li.line);
+    auto it = g_line_ids.find(key);
+    if (it != g_line_ids.end()) return it->second;
+
+    UINT32 id = static_cast<UINT32>(g_lines.size());
+    g_lines.push_back(li);
+    g_line_ids[key] = id;
+    return id;
+}
+
+static UINT32 SiteId(INS ins)
+{
+    if (!g_funcs_on && !g_lines_on && !g_go_on && !g_modules_on && !g_loops_on) return NO_SITE;
+
+    SiteInfo si{FuncId(ins), g_lines_on ? LineId(ins) : NO_SITE, NO_SITE};
+    if (g_loops_on) {
+        auto at = g_loop_at.find(INS_Address(ins));
+        if (at != g_loop_at.end()) si.loop = at->second;
+    }
+    auto key = std::make_tuple(si.func, si.line, si.loop);
+    auto it = g_site_ids.find(key);
+    if (it != g_site_ids.end()) return it->second;
+
+    UINT32 id = static_cast<UINT32>(g_sites.size());
+    g_sites.push_back(si);
+    g_site_ids[key] = id;
+    return id;
+}
+
+static inline Cnts& SiteCnts(ThreadState* st, UINT32 sid)
+{
+    if (sid >= st->sites.size()) st->sites.resize(sid + 1);
+    return st->sites[sid];
+}
+
+static inline Cnts& CtxCnts(ThreadState* st) { return st->nodes[st->node].cnts; }
+
+// ── loops (-loops) ──────────────────────────────────────────────────────────
+// -loops 1 rebuilds each routine's control-flow graph when its image loads:
+// basic blocks split at jump targets and after jumps, with direct jumps
+// inside the routine as edges (calls fall through; returns, indirect jumps
+// and tail calls end the path).  An edge to a block that dominates its
+// source is a back edge, and the blocks reaching it without passing that
+// header are its natural loop; back edges to one header make one loop.
+// Irreducible cycles, entered at more than one place, are not loops.
+// Every instruction is attributed to its innermost loop through its site.
+//
+// Headers count their executions and back edges their taken jumps, so a
+// loop's entries are the executions less the back edges.  One that tests
+// its exit at the header (while, for) runs its body once per back edge,
+// one tested at the bottom (do-while, rotated for loops) by a back edge's
+// source once per header execution.  Both are exact under -sample; the op counts are extrapolated.
+struct LoopInfo {
+    UINT32      func;
+    ADDRINT     offset;             // header address − function start
+    LineInfo    line;               // of the header; "??:0" without DWARF
+    UINT32      parent = NO_SITE;   // enclosing loop
+    UINT32      depth = 1;          // 1 for an outermost loop
+    UINT32      blocks = 0;
+    bool        head_exit = false;  // tested at the header, not the bottom
+};
+
+static std::vector<LoopInfo> g_loops;
+
+static VOID PIN_FAST_ANALYSIS_CALL LoopHead(THREADID tid, UINT32 lid)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (lid >= st->loop_heads.size()) {
+        st->loop_heads.resize(lid + 1);
+        st->loop_backs.resize(lid + 1);
+    }
+    st->loop_heads[lid]++;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL LoopBack(THREADID tid, UINT32 lid)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (lid >= st->loop_backs.size()) {
+        st->loop_heads.resize(lid + 1);
+        st->loop_backs.resize(lid + 1);
+    }
+    st->loop_backs[lid]++;
+}
+
+// One instruction of a routine as the CFG sees it
+struct CfgIns {
+    INS     ins;
+    ADDRINT addr;
+    ADDRINT target = 0;             // direct jump target, 0 for none
+    bool    ends = false;           // a jump, return or indirect jump
+    bool    falls = true;           // can continue at the next instruction
+};
+
+static const UINT32 NO_BLOCK = ~0u;
+
+static VOID InstrumentLoopsRtn(RTN rtn, VOID*)
+{
+    RTN_Open(rtn);
+    std::vector<CfgIns> code;
+    std::map<ADDRINT, UINT32> at;   // address → index into code
+    for (INS ins = RTN_InsHead(rtn); INS_Valid(ins); ins = INS_Next(ins)) {
+        CfgIns c{ins, INS_Address(ins)};
+        if (INS_IsControlFlow(ins) && !INS_IsCall(ins)) {
+            c.ends = true;
+            c.falls = INS_HasFallThrough(ins);
+            if (INS_IsDirectControlFlow(ins)) c.target = INS_DirectControlFlowTargetAddress(ins);
+        }
+        at[c.addr] = static_cast<UINT32>(code.size());
+        code.push_back(c);
+    }
+    if (code.empty() || (Filtering() && !Counted(code[0].ins))) {
+        RTN_Close(rtn);
+        return;
+    }
+    auto index = [&](ADDRINT a) {
+        auto it = at.find(a);
+        return it == at.end() ? NO_BLOCK : it->second;
+    };
+
+    // basic blocks: first instruction of each, block of each instruction
+    std::vector<bool> leader(code.size());
+    leader[0] = true;
+    for (size_t i = 0; i < code.size(); ++i) {
+        UINT32 t = code[i].target ? index(code[i].target) : NO_BLOCK;
+        if (t != NO_BLOCK) leader[t] = true;
+        if (code[i].ends && i + 1 < code.size()) leader[i + 1] = true;
+    }
+    std::vector<UINT32> first, block(code.size());
+    for (size_t i = 0; i < code.size(); ++i) {
+        if (leader[i]) first.push_back(static_cast<UINT32>(i));
+        block[i] = static_cast<UINT32>(first.size() - 1);
+    }
+    const UINT32 nb = static_cast<UINT32>(first.size());
+    auto last = [&](UINT32 b) { return (b + 1 < nb ? first[b + 1] : code.size()) - 1; };
+    std::vector<std::vector<UINT32>> succ(nb), pred(nb);
+    for (UINT32 b = 0; b < nb; ++b) {
+        const CfgIns& c = code[last(b)];
+        UINT32 t = c.target ? index(c.target) : NO_BLOCK;
+        if (t != NO_BLOCK) succ[b].push_back(block[t]);
+        if (c.falls && last(b) + 1 < code.size()) succ[b].push_back(b + 1);
+        for (UINT32 s : succ[b]) pred[s].push_back(b);
+    }
+
+    // dominators (Cooper, Harvey and Kennedy) over the reverse postorder
+    // of the blocks reachable from the entry
+    std::vector<UINT32> post, num(nb, NO_BLOCK), next(nb), stack{0};
+    std::vector<bool> seen(nb);
+    seen[0] = true;
+    while (!stack.empty()) {
+        UINT32 b = stack.back();
+        if (next[b] < succ[b].size()) {
+            UINT32 s = succ[b][next[b]++];
+            if (!seen[s]) { seen[s] = true; stack.push_back(s); }
+        } else {
+            post.push_back(b);
+            stack.pop_back();
+        }
+    }
+    std::vector<UINT32> rpo(post.rbegin(), post.rend());
+    for (UINT32 k = 0; k < rpo.size(); ++k) num[rpo[k]] = k;
+    std::vector<UINT32> idom(nb, NO_BLOCK);
+    idom[0] = 0;
+    auto intersect = [&](UINT32 a, UINT32 b) {
+        while (a != b) {
+            while (num[a] > num[b]) a = idom[a];
+            while (num[b] > num[a]) b = idom[b];
+        }
+        return a;
+    };
+    for (bool changed = true; changed; ) {
+        changed = false;
+        for (UINT32 k = 1; k < rpo.size(); ++k) {
+            UINT32 b = rpo[k], d = NO_BLOCK;
+            for (UINT32 p : pred[b])
+                if (idom[p] != NO_BLOCK) d = d == NO_BLOCK ? p : intersect(p, d);
+            if (idom[b] != d) { idom[b] = d; changed = true; }
+        }
+    }
+    auto dominates = [&](UINT32 h, UINT32 u) {
+        for (;; u = idom[u]) {
+            if (u == h) return true;
+            if (u == 0) return false;
+        }
+    };
+
+    // natural loops, one per header, in address order
+    std::map<UINT32, std::vector<UINT32>> latches;
+    for (UINT32 u : rpo)
+        for (UINT32 h : succ[u])
+            if (dominates(h, u)) latches[h].push_back(u);
+    if (latches.empty()) {
+        RTN_Close(rtn);
+        return;
+    }
+    struct Body { UINT32 head; std::vector<bool> in; UINT32 size; };
+    std::vector<Body> bodies;
+    for (const auto& kv : latches) {
+        Body l{kv.first, std::vector<bool>(nb), 1};
+        l.in[l.head] = true;
+        std::vector<UINT32> work;
+        for (UINT32 u : kv.second)
+            if (!l.in[u]) { l.in[u] = true; l.size++; work.push_back(u); }
+        while (!work.empty()) {
+            UINT32 u = work.back();
+            work.pop_back();
+            for (UINT32 p : pred[u])
+                if (seen[p] && !l.in[p]) { l.in[p] = true; l.size++; work.push_back(p); }
+        }
+        bodies.push_back(l);
+    }
+
+    // a loop holding another's header holds all of it and is larger; the
+    // smallest such is its parent, the smallest loop holding a block is
+    // the block's innermost loop
+    const UINT32 base = static_cast<UINT32>(g_loops.size());
+    const UINT32 func = FuncId(rtn);
+    auto smallest = [&](UINT32 b, UINT32 skip) {
+        UINT32 best = NO_BLOCK;
+        for (UINT32 k = 0; k < bodies.size(); ++k)
+            if (k != skip && bodies[k].in[b] &&
+                (best == NO_BLOCK || bodies[k].size < bodies[best].size)) best = k;
+        return best;
+    };
+    for (UINT32 k = 0; k < bodies.size(); ++k) {
+        const Body& l = bodies[k];
+        ADDRINT head = code[first[l.head]].addr;
+        LoopInfo li;
+        li.func = func;
+        li.offset = head - RTN_Address(rtn);
+        PIN_GetSourceLocation(head, nullptr, &li.line.line, &li.line.file);
+        if (li.line.file.empty()) li.line.file = "??";
+        li.blocks = l.size;
+        // tested at the bottom when a back edge's source can leave too
+        bool bottom_exit = false;
+        for (UINT32 u : latches[l.head])
+            for (UINT32 s : succ[u]) bottom_exit = bottom_exit || !l.in[s];
+        for (UINT32 s : succ[l.head]) li.head_exit = li.head_exit || !l.in[s];
+        li.head_exit = li.head_exit && !bottom_exit;
+        UINT32 p = smallest(l.head, k);
+        if (p != NO_BLOCK) li.parent = base + p;
+        for (; p != NO_BLOCK; p = smallest(bodies[p].head, p)) li.depth++;
+        g_loops.push_back(li);
+
+        UINT32 id = base + k;
+        INS_InsertCall(code[first[l.head]].ins, IPOINT_BEFORE, (AFUNPTR)LoopHead,
+                       IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_UINT32, id, IARG_END);
+        for (UINT32 u : latches[l.head]) {
+            // the jump back, the fall-through of a conditional jump or
+            // the last instruction of a block running into the header
+            const CfgIns& c = code[last(u)];
+            UINT32 t = c.target ? index(c.target) : NO_BLOCK;
+            IPOINT where = t != NO_BLOCK && block[t] == l.head ? IPOINT_TAKEN_BRANCH
+                         : c.ends                              ? IPOINT_AFTER
+                                                               : IPOINT_BEFORE;
+            INS_InsertCall(c.ins, where, (AFUNPTR)LoopBack,
+                           IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_UINT32, id, IARG_END);
+        }
+        DBG(2, "Loop #" << id << ": " << g_funcs[func].name << "+0x" << std::hex << li.offset
+               << std::dec << ", " << l.size << " blocks, depth " << li.depth);
+    }
+    for (size_t i = 0; i < code.size(); ++i) {
+        UINT32 k = seen[block[i]] ? smallest(block[i], NO_BLOCK) : NO_BLOCK;
+        if (k != NO_BLOCK) g_loop_at[code[i].addr] = base + k;
+    }
+    RTN_Close(rtn);
 }
 
 // ── region toggles ─────────────────────────────────────────────────────────
@@ -85,8 +1525,14 @@ static VOID StopRegion(THREADID tid)
 
 // ── fast counter stubs ─────────────────────────────────────────────────────
 #define DEF_COUNTER(name)                                             \
-    static VOID PIN_FAST_ANALYSIS_CALL name(THREADID tid)             \
-    { if (Counting(tid)) St(tid)->cnts.name++; }
+    static VOID PIN_FAST_ANALYSIS_CALL name(THREADID tid, UINT32 sid) \
+    {                                                                 \
+        if (!Counting(tid)) return;                                   \
+        ThreadState* st = St(tid);                                    \
+        st->cnts.name++;                                              \
+        if (sid != NO_SITE) SiteCnts(st, sid).name++;                 \
+        if (g_calls_on) CtxCnts(st).name++;                           \
+    }
 
 DEF_COUNTER(add_rr)  DEF_COUNTER(sub_rr)  DEF_COUNTER(adc_rr)  DEF_COUNTER(sbb_rr)
 DEF_COUNTER(mul_rr)  DEF_COUNTER(mulx_rr) DEF_COUNTER(adcx_rr) DEF_COUNTER(adox_rr)
@@ -94,9 +1540,21 @@ DEF_COUNTER(div_rr)
 DEF_COUNTER(add_rm)  DEF_COUNTER(sub_rm)  DEF_COUNTER(adc_rm)  DEF_COUNTER(sbb_rm)
 DEF_COUNTER(mul_rm)  DEF_COUNTER(mulx_rm) DEF_COUNTER(adcx_rm) DEF_COUNTER(adox_rm)
 DEF_COUNTER(div_rm)
+DEF_COUNTER(lea_rr)
 
 // ── instruction classification helpers ─────────────────────────────────────
-static inline bool Is64Gpr(REG r)   { return REG_is_gr64(r); }
+// The categories count 64-bit operands; -widths applies the same rules to
+// 8-, 16- and 32-bit ones, so the helpers take the operand size in bytes.
+static inline bool IsGpr(REG r, UINT32 bytes)
+{
+    switch (bytes) {
+        case 8:  return REG_is_gr64(r);
+        case 4:  return REG_is_gr32(r);
+        case 2:  return REG_is_gr16(r);
+        case 1:  return REG_is_gr8(r);
+        default: return false;
+    }
+}
 static inline bool IsStack(REG r)   { return r == REG_RSP || r == REG_RBP; }
 
 static inline bool HasImm(INS ins)
@@ -115,37 +1573,37 @@ static inline bool TouchesStack(INS ins)
     return INS_IsStackRead(ins) || INS_IsStackWrite(ins);
 }
 
-static inline bool Has64R(INS ins)
+static inline bool HasGprR(INS ins, UINT32 bytes)
 {
     for (UINT32 i = 0; i < INS_MaxNumRRegs(ins); ++i) {
         REG r = INS_RegR(ins, i);
-        if (Is64Gpr(r) && !IsStack(r)) return true;
+        if (IsGpr(r, bytes) && !IsStack(REG_FullRegName(r))) return true;
     }
     return false;
 }
 
-static inline bool Has64W(INS ins)
+static inline bool HasGprW(INS ins, UINT32 bytes)
 {
     for (UINT32 i = 0; i < INS_MaxNumWRegs(ins); ++i) {
         REG r = INS_RegW(ins, i);
-        if (Is64Gpr(r) && !IsStack(r)) return true;
+        if (IsGpr(r, bytes) && !IsStack(REG_FullRegName(r))) return true;
     }
     return false;
 }
 
-static inline bool MemRead8(INS ins)
+static inline bool MemRead(INS ins, UINT32 bytes)
 {
     for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i)
         if (INS_MemoryOperandIsRead(ins, i) &&
-            INS_MemoryOperandSize(ins, i) == 8) return true;
+            INS_MemoryOperandSize(ins, i) == bytes) return true;
     return false;
 }
 
-static inline bool MemWrite8(INS ins)
+static inline bool MemWrite(INS ins, UINT32 bytes)
 {
     for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i)
         if (INS_MemoryOperandIsWritten(ins, i) &&
-            INS_MemoryOperandSize(ins, i) == 8) return true;
+            INS_MemoryOperandSize(ins, i) == bytes) return true;
     return false;
 }
 
@@ -162,138 +1620,8220 @@ static inline bool IsALU64(xed_iclass_enum_t opc)
     }
 }
 
-static inline bool IsRegReg64(INS ins)
+static inline bool IsRegReg(INS ins, UINT32 bytes, bool imm_ok)
 {
     return INS_MemoryOperandCount(ins) == 0 &&
-           !HasImm(ins) && !TouchesStack(ins) &&
-           Has64R(ins) && Has64W(ins);
+           (imm_ok || !HasImm(ins)) && !TouchesStack(ins) &&
+           HasGprR(ins, bytes) && HasGprW(ins, bytes);
 }
 
-static inline bool IsRegMem64(INS ins)
+static inline bool IsRegMem(INS ins, UINT32 bytes, bool imm_ok)
 {
-    if (HasImm(ins) || TouchesStack(ins)) return false;
-    bool mr  = MemRead8(ins)  && Has64W(ins) && !MemWrite8(ins);
-    bool rmw = MemWrite8(ins) && Has64R(ins);
+    if ((!imm_ok && HasImm(ins)) || TouchesStack(ins)) return false;
+    bool mr  = MemRead(ins, bytes)  && HasGprW(ins, bytes) && !MemWrite(ins, bytes);
+    bool rmw = MemWrite(ins, bytes) && HasGprR(ins, bytes);
     return mr || rmw;
 }
 
+static inline bool IsRegReg64(INS ins, bool imm_ok = false) { return IsRegReg(ins, 8, imm_ok); }
+static inline bool IsRegMem64(INS ins, bool imm_ok = false) { return IsRegMem(ins, 8, imm_ok); }
+
 // ── instrumentation – arithmetic instructions ───────────────────────────────
-static VOID InstrumentArith(INS ins, VOID*)
+// Whether ins is counted as arithmetic on operands of bytes bytes (64-bit
+// unless -widths asks), and in which form
+static bool CountedArith(INS ins, bool& rr, UINT32 bytes = 8)
 {
-    if (!IsALU64(static_cast<xed_iclass_enum_t>(INS_Opcode(ins)))) return;
-    if (HasImm(ins)) return;
+    if (!IsALU64(static_cast<xed_iclass_enum_t>(INS_Opcode(ins)))) return false;
+    if (HasImm(ins)) return false;
+    rr = IsRegReg(ins, bytes, false);
+    return rr || IsRegMem(ins, bytes, false);
+}
 
-    bool rr = IsRegReg64(ins);
-    bool rm = !rr && IsRegMem64(ins);
-    if (!rr && !rm) return;
+static VOID InstrumentArith(INS ins, VOID*)
+{
+    bool rr;
+    if (!CountedArith(ins, rr)) return;
 
     AFUNPTR fn = nullptr;
+    const char* op = "add";
     switch (INS_Opcode(ins)) {
         case XED_ICLASS_ADD : fn = (AFUNPTR)(rr ? add_rr  : add_rm);  break;
-        case XED_ICLASS_SUB : fn = (AFUNPTR)(rr ? sub_rr  : sub_rm);  break;
+        case XED_ICLASS_SUB : fn = (AFUNPTR)(rr ? sub_rr  : sub_rm);  op = "sub"; break;
         case XED_ICLASS_ADC : fn = (AFUNPTR)(rr ? adc_rr  : adc_rm);  break;
-        case XED_ICLASS_SBB : fn = (AFUNPTR)(rr ? sbb_rr  : sbb_rm);  break;
+        case XED_ICLASS_SBB : fn = (AFUNPTR)(rr ? sbb_rr  : sbb_rm);  op = "sub"; break;
         case XED_ICLASS_MUL :
-        case XED_ICLASS_IMUL: fn = (AFUNPTR)(rr ? mul_rr  : mul_rm);  break;
-        case XED_ICLASS_MULX: fn = (AFUNPTR)(rr ? mulx_rr : mulx_rm); break;
+        case XED_ICLASS_IMUL: fn = (AFUNPTR)(rr ? mul_rr  : mul_rm);  op = "mul"; break;
+        case XED_ICLASS_MULX: fn = (AFUNPTR)(rr ? mulx_rr : mulx_rm); op = "mul"; break;
         case XED_ICLASS_ADCX: fn = (AFUNPTR)(rr ? adcx_rr : adcx_rm); break;
         case XED_ICLASS_ADOX: fn = (AFUNPTR)(rr ? adox_rr : adox_rm); break;
         case XED_ICLASS_DIV :
-        case XED_ICLASS_IDIV: fn = (AFUNPTR)(rr ? div_rr  : div_rm);  break;
+        case XED_ICLASS_IDIV: fn = (AFUNPTR)(rr ? div_rr  : div_rm);  op = "div"; break;
         default: return;
     }
-    INS_InsertCall(ins, IPOINT_BEFORE, fn,
-                   IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_END);
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_END);
+    InsertCounter(ins, fn, args);
+    InsertTraceOp(ins, op);
 }
 
-// ── instrumentation for marker functions (MARKER mode) ──────────────────────
-static VOID InstrumentMarkerRtn(RTN rtn, VOID*)
+// ── instrumentation – shifts, rotates and bitwise logic ─────────────────────
+// SHL/SHLD/SHLX, SHR/SAR/SHRD/SHRX/SARX, ROL/ROR/RCL/RCR/RORX, AND/ANDN,
+// OR, XOR and NOT on 64-bit operands.  Shifts and rotates by an immediate
+// count are included (the immediate is the shift amount, not data); logic
+// with an immediate operand is skipped, as for add/sub.
+static VOID PIN_FAST_ANALYSIS_CALL BitOpCount(THREADID tid, UINT32 sid, UINT32 slot)
 {
-    if (g_mode != MARKER) return;
-    
-    std::string name = RTN_Name(rtn);
-    
-    if (name == g_start_marker) {
-        DBG(1, "Found start marker: " << name);
-        RTN_Open(rtn);
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    (&st->cnts.bit[0][0])[slot]++;
+    if (sid != NO_SITE) (&SiteCnts(st, sid).bit[0][0])[slot]++;
+    if (g_calls_on) (&CtxCnts(st).bit[0][0])[slot]++;
+}
+
+static int ClassifyBit(xed_iclass_enum_t opc)
+{
+    switch (opc) {
+        case XED_ICLASS_SHL:  case XED_ICLASS_SHLD: case XED_ICLASS_SHLX:
+            return BSHL;
+        case XED_ICLASS_SHR:  case XED_ICLASS_SAR:  case XED_ICLASS_SHRD:
+        case XED_ICLASS_SHRX: case XED_ICLASS_SARX:
+            return BSHR;
+        case XED_ICLASS_ROL:  case XED_ICLASS_ROR:  case XED_ICLASS_RCL:
+        case XED_ICLASS_RCR:  case XED_ICLASS_RORX:
+            return BROL;
+        case XED_ICLASS_AND:  case XED_ICLASS_ANDN: return BAND;
+        case XED_ICLASS_OR:   return BOR;
+        case XED_ICLASS_XOR:  return BXOR;
+        case XED_ICLASS_NOT:  return BNOT;
+        default:              return -1;
+    }
+}
+
+// The selected category ins is counted in, and its form; -1 if none
+static int CountedBit(INS ins, bool& rm, UINT32 bytes = 8)
+{
+    int op = ClassifyBit(static_cast<xed_iclass_enum_t>(INS_Opcode(ins)));
+    if (op < 0 || !g_bit_on[op]) return -1;
+
+    bool imm_ok = op == BSHL || op == BSHR || op == BROL;
+    if (HasImm(ins) && !imm_ok) return -1;
+
+    bool rr = IsRegReg(ins, bytes, imm_ok);
+    rm = !rr && IsRegMem(ins, bytes, imm_ok);
+    return rr || rm ? op : -1;
+}
+
+static VOID InstrumentBits(INS ins, VOID*)
+{
+    bool rm;
+    int op = CountedBit(ins, rm);
+    if (op < 0) return;
+
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                          IARG_UINT32, UINT32(op * 2 + (rm ? 1 : 0)), IARG_END);
+    InsertCounter(ins, (AFUNPTR)BitOpCount, args);
+    InsertTraceOp(ins, BIT_OP_NAMES[op]);
+}
+
+// ── instrumentation – signed vs unsigned (-signedness) ──────────────────────
+// The counted multiplies and divides by instruction: IMUL and IDIV are
+// signed, MUL, MULX and DIV unsigned.  The two-operand IMUL's product is
+// the same either way, but it runs as a signed multiply.
+static VOID PIN_FAST_ANALYSIS_CALL SignCount(THREADID tid, UINT32 sid, UINT32 slot)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    (&st->cnts.sign[0][0])[slot]++;
+    if (sid != NO_SITE) (&SiteCnts(st, sid).sign[0][0])[slot]++;
+    if (g_calls_on) (&CtxCnts(st).sign[0][0])[slot]++;
+}
+
+static VOID InstrumentSign(INS ins, VOID*)
+{
+    bool rr;
+    if (!CountedArith(ins, rr)) return;
+
+    UINT32 slot;
+    switch (INS_Opcode(ins)) {
+        case XED_ICLASS_IMUL: slot = SSIGNED;                  break;
+        case XED_ICLASS_MUL:
+        case XED_ICLASS_MULX: slot = SUNSIGNED;                break;
+        case XED_ICLASS_IDIV: slot = SIGN_KINDS + SSIGNED;     break;
+        case XED_ICLASS_DIV:  slot = SIGN_KINDS + SUNSIGNED;   break;
+        default: return;
+    }
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, slot, IARG_END);
+    InsertCounter(ins, (AFUNPTR)SignCount, args);
+}
+
+// ── instrumentation – atomics and futex calls (-atomics) ────────────────────
+// Synchronization: the LOCK-prefixed instructions (XED's *_LOCK iclasses,
+// which the categories never count) and XCHG with memory, which is locked
+// without the prefix, whatever their operand size.  Futex calls are
+// counted at the SYSCALL instruction by its number, futex and
+// futex_waitv, in the function issuing it.
+static VOID PIN_FAST_ANALYSIS_CALL AtomicCount(THREADID tid, UINT32 sid, UINT32 slot)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    st->cnts.atomic[slot]++;
+    if (sid != NO_SITE) SiteCnts(st, sid).atomic[slot]++;
+    if (g_calls_on) CtxCnts(st).atomic[slot]++;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL FutexCount(THREADID tid, UINT32 sid, ADDRINT nr)
+{
+    if (nr == 202 || nr == 449) AtomicCount(tid, sid, AT_FUTEX);   // futex, futex_waitv
+}
+
+static VOID InstrumentAtomics(INS ins, VOID*)
+{
+    IARGLIST args = IARGLIST_Alloc();
+    if (INS_IsSyscall(ins)) {
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_SYSCALL_NUMBER, IARG_END);
+        InsertCounter(ins, (AFUNPTR)FutexCount, args);
+        return;
+    }
+    if (!INS_LockPrefix(ins) && !(INS_Opcode(ins) == XED_ICLASS_XCHG && INS_IsMemoryRead(ins))) {
+        IARGLIST_Free(args);
+        return;
+    }
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, UINT32(AT_RMW), IARG_END);
+    InsertCounter(ins, (AFUNPTR)AtomicCount, args);
+}
+
+// ── instrumentation – operand widths (-widths) ──────────────────────────────
+// An instruction add, sub, mul, div or a selected -ops category would count
+// if its operands were 64-bit, tallied by their size: register and memory
+// operands of 8, 16, 32 or 64 bits, by the rules above.  The 64-bit
+// multiplies and divides of double width (MUL and one-operand IMUL into
+// RDX:RAX, MULX, DIV and IDIV of RDX:RAX) take the 128-bit slot instead, so
+// the 64- and 128-bit slots sum to the category.  The LEAs of -compound
+// split and the address arithmetic of -agen fold have no operand size and
+// are left out.
+static VOID PIN_FAST_ANALYSIS_CALL WidthCount(THREADID tid, UINT32 sid, UINT32 slot)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    (&st->cnts.width[0][0])[slot]++;
+    if (sid != NO_SITE) (&SiteCnts(st, sid).width[0][0])[slot]++;
+    if (g_calls_on) (&CtxCnts(st).width[0][0])[slot]++;
+}
+
+static int ArithCategory(INS ins)
+{
+    switch (INS_Opcode(ins)) {
+        case XED_ICLASS_SUB:  case XED_ICLASS_SBB:  return 1;
+        case XED_ICLASS_MUL:  case XED_ICLASS_IMUL:
+        case XED_ICLASS_MULX:                       return 2;
+        case XED_ICLASS_DIV:  case XED_ICLASS_IDIV: return 3;
+        default:                                    return 0;
+    }
+}
+
+static bool DoubleWidth(INS ins)
+{
+    switch (INS_Opcode(ins)) {
+        case XED_ICLASS_MUL:  case XED_ICLASS_MULX:
+        case XED_ICLASS_DIV:  case XED_ICLASS_IDIV:
+            return true;
+        case XED_ICLASS_IMUL:
+            return INS_RegWContain(ins, REG_RAX) && INS_RegWContain(ins, REG_RDX);
+        default:
+            return false;
+    }
+}
+
+static VOID InstrumentWidths(INS ins, VOID*)
+{
+    static const UINT32 bytes[W128] = {1, 2, 4, 8};
+    for (int w = W64; w >= W8; --w) {
+        bool rr, rm;
+        int cat = -1;
+        if (CountedArith(ins, rr, bytes[w])) {
+            cat = ArithCategory(ins);
+        } else if (g_bits_on) {
+            int op = CountedBit(ins, rm, bytes[w]);
+            if (op >= 0) cat = 4 + op;
+        }
+        if (cat < 0) continue;
+
+        int slot = w == W64 && DoubleWidth(ins) ? W128 : w;
+        if (!g_width_on[slot]) return;
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                              IARG_UINT32, UINT32(cat * WIDTH_SLOTS + slot), IARG_END);
+        InsertCounter(ins, (AFUNPTR)WidthCount, args);
+        return;
+    }
+}
+
+// ── instrumentation – custom categories ─────────────────────────────────────
+// -class name=glob,… counts every instruction whose mnemonic matches one of
+// the globs (CRC32, AESENC*, PDEP, …) under name, whatever its operands.
+// -watch name=addr:size:mode is a watchpoint instead: every memory operand
+// executed that reads (r) or writes (w) any of the size bytes at addr, a
+// link-time address of the main executable, counts once.
+struct ClassInfo {
+    std::string              name;
+    std::vector<std::string> pats;
+    bool                     watch = false, rd = false, wr = false;
+    ADDRINT                  addr = 0, size = 0;
+};
+static std::vector<ClassInfo> g_classes;
+
+static VOID PIN_FAST_ANALYSIS_CALL ClassCount(THREADID tid, UINT32 sid, UINT32 k)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    st->cnts.cls[k]++;
+    if (sid != NO_SITE) SiteCnts(st, sid).cls[k]++;
+    if (g_calls_on) CtxCnts(st).cls[k]++;
+}
+
+// the main executable is relocated by the time its code runs
+static VOID PIN_FAST_ANALYSIS_CALL WatchCount(THREADID tid, UINT32 sid, UINT32 k,
+                                              ADDRINT ea, UINT32 size)
+{
+    const ClassInfo& w = g_classes[k];
+    const ADDRINT lo = w.addr + g_load_offset;
+    if (ea >= lo + w.size || ea + std::max<UINT32>(size, 1) <= lo) return;
+    ClassCount(tid, sid, k);
+}
+
+static VOID InstrumentWatch(INS ins, UINT32 k)
+{
+    const ClassInfo& w = g_classes[k];
+    if (INS_IsPrefetch(ins)) return;
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        if (!(w.rd && INS_MemoryOperandIsRead(ins, i)) && !(w.wr && INS_MemoryOperandIsWritten(ins, i)))
+            continue;
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, k,
+                              IARG_MEMORYOP_EA, i, IARG_UINT32, INS_MemoryOperandSize(ins, i), IARG_END);
+        InsertCounter(ins, (AFUNPTR)WatchCount, args);
+    }
+}
+
+static VOID InstrumentClasses(INS ins, VOID*)
+{
+    std::string mnem = INS_Mnemonic(ins);
+    for (size_t k = 0; k < g_classes.size(); ++k) {
+        if (g_classes[k].watch) {
+            InstrumentWatch(ins, UINT32(k));
+            continue;
+        }
+        for (const auto& pat : g_classes[k].pats)
+            if (GlobMatch(pat.c_str(), mnem.c_str())) {
+                IARGLIST args = IARGLIST_Alloc();
+                IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                                      IARG_UINT32, UINT32(k), IARG_END);
+                InsertCounter(ins, (AFUNPTR)ClassCount, args);
+                InsertTraceOp(ins, g_classes[k].name.c_str());
+                break;
+            }
+    }
+}
+
+// ── instrumentation – compound instructions (-compound) ─────────────────────
+// A fused multiply-add does a multiply and an add (subtracting forms
+// included) and a LEA of base + index*scale an add, and a shift when
+// scaled; a LEA with one register or RIP is address arithmetic, as an add
+// of an immediate is.  Unless fused, each compound instruction executed is
+// tallied per kind and lane.
+static const char* const COMPOUND_POLICY_NAMES[] = {"fused", "split", "both"};
+static const char* const COMPOUND_KIND_NAMES[COMPOUND_KINDS] = {
+    "fp64_fma", "fp32_fma", "vec_muladd", "lea", "lea_scaled"};
+
+static VOID PIN_FAST_ANALYSIS_CALL CompoundCount(THREADID tid, UINT32 sid,
+                                                 UINT32 kind, UINT32 lanes)
+{
+    if (!Counting(tid)) return;
+    St(tid)->compound[kind] += lanes;
+}
+
+static VOID InsertCompound(INS ins, CompoundKind kind, UINT32 lanes)
+{
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, UINT32(kind),
+                          IARG_UINT32, lanes, IARG_END);
+    InsertCounter(ins, (AFUNPTR)CompoundCount, args);
+}
+
+// A 64-bit LEA adding two registers; scaled if the index is multiplied
+static bool ArithLea(INS ins, bool& scaled)
+{
+    if (INS_Opcode(ins) != XED_ICLASS_LEA || INS_OperandWidth(ins, 0) != 64) return false;
+    REG base = INS_MemoryBaseReg(ins), index = INS_MemoryIndexReg(ins);
+    if (!REG_valid(base) || !REG_valid(index) || base == REG_RIP) return false;
+    scaled = INS_MemoryScale(ins) > 1;
+    return true;
+}
+
+static VOID InstrumentLea(INS ins, VOID*)
+{
+    bool scaled;
+    if (!ArithLea(ins, scaled)) return;
+    InsertCompound(ins, CLEA, 1);
+    if (scaled) InsertCompound(ins, CLEA_SCALED, 1);
+    if (g_compound != CMP_SPLIT) return;
+
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_END);
+    InsertCounter(ins, (AFUNPTR)lea_rr, args);
+    InsertTraceOp(ins, "add");
+    if (scaled && g_bit_on[BSHL]) {
+        args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, UINT32(BSHL * 2), IARG_END);
+        InsertCounter(ins, (AFUNPTR)BitOpCount, args);
+        InsertTraceOp(ins, BIT_OP_NAMES[BSHL]);
+    }
+}
+
+// ── instrumentation – address generation (-agen) ────────────────────────────
+// An address base + index*scale + disp takes an add per term past the first
+// and a shift when the index is scaled.  Each LEA of a 64-bit result
+// computes one, and so does each explicit memory operand; RIP-relative and
+// absolute addresses are constants and take none, and NOPs compute nothing.
+// The adds and shifts are counted as their own category (-agen category) or
+// as add and shl (-agen fold), by site like any other op.
+static const char* const AGEN_MODE_NAMES[] = {"off", "category", "fold"};
+
+static VOID PIN_FAST_ANALYSIS_CALL AgenCount(THREADID tid, UINT32 sid, UINT32 kind,
+                                             UINT32 adds, UINT32 shifts)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    auto count = [&](Cnts& c) {
+        c.agen[kind] += adds;
+        c.agen[kind + 1] += shifts;
+    };
+    count(st->cnts);
+    if (sid != NO_SITE) count(SiteCnts(st, sid));
+    if (g_calls_on) count(CtxCnts(st));
+}
+
+// Adds to *adds and *shifts the arithmetic of one address
+static VOID AgenAddress(REG base, REG index, UINT32 scale, ADDRDELTA disp,
+                        UINT32* adds, UINT32* shifts)
+{
+    if (base == REG_RIP) return;
+    UINT32 terms = (REG_valid(base) ? 1 : 0) + (REG_valid(index) ? 1 : 0) + (disp ? 1 : 0);
+    if (terms > 1) *adds += terms - 1;
+    if (REG_valid(index) && scale > 1) ++*shifts;
+}
+
+static VOID InstrumentAgen(INS ins, VOID*)
+{
+    if (INS_IsNop(ins)) return;
+    UINT32 kind = AG_MEM_ADD, adds = 0, shifts = 0;
+    if (INS_Opcode(ins) == XED_ICLASS_LEA) {
+        if (INS_OperandWidth(ins, 0) != 64) return;
+        kind = AG_LEA_ADD;
+        AgenAddress(INS_MemoryBaseReg(ins), INS_MemoryIndexReg(ins), INS_MemoryScale(ins),
+                    INS_MemoryDisplacement(ins), &adds, &shifts);
+    } else {
+        for (UINT32 i = 0; i < INS_OperandCount(ins); ++i)
+            if (INS_OperandIsMemory(ins, i) && !INS_OperandIsImplicit(ins, i))
+                AgenAddress(INS_OperandMemoryBaseReg(ins, i), INS_OperandMemoryIndexReg(ins, i),
+                            INS_OperandMemoryScale(ins, i), INS_OperandMemoryDisplacement(ins, i),
+                            &adds, &shifts);
+    }
+    if (!adds && !shifts) return;
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, kind,
+                          IARG_UINT32, adds, IARG_UINT32, shifts, IARG_END);
+    InsertCounter(ins, (AFUNPTR)AgenCount, args);
+}
+
+// ── instrumentation – floating-point instructions ───────────────────────────
+// Scalar and packed SSE/AVX arithmetic, classified by mnemonic:
+//   [V]{ADD,SUB,MUL,DIV}{SD,SS,PD,PS}, [V]ADDSUBP{D,S} (as add) and the
+//   FMA3 families V{F,FN}{MADD,MSUB}[SUB|ADD]nnn{SD,SS,PD,PS}.
+// Packed forms count one op per lane; write masks are ignored.  x87 is not
+// counted.
+static VOID PIN_FAST_ANALYSIS_CALL FpCount(THREADID tid, UINT32 sid,
+                                           UINT32 slot, UINT32 lanes)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    (&st->cnts.fp[0][0])[slot] += lanes;
+    if (sid != NO_SITE) (&SiteCnts(st, sid).fp[0][0])[slot] += lanes;
+    if (g_calls_on) (&CtxCnts(st).fp[0][0])[slot] += lanes;
+}
+
+// Lanes of lane_bits in a packed instruction's destination, at least 1
+static UINT32 Lanes(INS ins, UINT32 lane_bits)
+{
+    UINT32 n = INS_OperandWidth(ins, 0) / lane_bits;
+    return n ? n : 1;
+}
+
+static bool ClassifyFp(const std::string& mnem, FpPrec& prec, FpOp& op,
+                       bool& packed)
+{
+    std::string m = mnem;
+    if (m.size() < 4) return false;
+
+    // suffix: S/P + D/S
+    const std::string sfx = m.substr(m.size() - 2);
+    if      (sfx == "SD") { prec = FP64; packed = false; }
+    else if (sfx == "SS") { prec = FP32; packed = false; }
+    else if (sfx == "PD") { prec = FP64; packed = true;  }
+    else if (sfx == "PS") { prec = FP32; packed = true;  }
+    else return false;
+    m.resize(m.size() - 2);
+
+    if (m[0] == 'V') m.erase(0, 1);
+
+    if (m.compare(0, 3, "FMA") == 0 || m.compare(0, 3, "FMS") == 0 ||
+        m.compare(0, 4, "FNMA") == 0 || m.compare(0, 4, "FNMS") == 0) {
+        // FMA3 operand order digits (132/213/231) must be present
+        if (m.find_first_of("0123456789") == std::string::npos) return false;
+        op = FFMA;
+        return true;
+    }
+    if (m == "ADD" || m == "ADDSUB") { op = FADD; return true; }
+    if (m == "SUB")                  { op = FSUB; return true; }
+    if (m == "MUL")                  { op = FMUL; return true; }
+    if (m == "DIV")                  { op = FDIV; return true; }
+    return false;
+}
+
+static VOID InstrumentFp(INS ins, VOID*)
+{
+    FpPrec prec; FpOp op; bool packed;
+    if (!ClassifyFp(INS_Mnemonic(ins), prec, op, packed)) return;
+
+    UINT32 lanes = packed ? Lanes(ins, prec == FP64 ? 64 : 32) : 1;
+    if (op == FFMA && g_compound != CMP_FUSED)
+        InsertCompound(ins, prec == FP64 ? CFMA64 : CFMA32, lanes);
+    const bool split = op == FFMA && g_compound == CMP_SPLIT;
+    for (FpOp o : {split ? FMUL : op, FADD}) {
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                              IARG_UINT32, UINT32(prec * FP_OPS + o),
+                              IARG_UINT32, lanes, IARG_END);
+        InsertCounter(ins, (AFUNPTR)FpCount, args);
+        if (!split) break;
+    }
+}
+
+// ── instrumentation – packed integer instructions ───────────────────────────
+// [V]PADDQ, [V]PSUBQ, [V]PMULLQ, [V]PMULUDQ, [V]PMULDQ and the AVX-512 IFMA
+// VPMADD52{L,H}UQ (as mul), counted per 64-bit lane: a 512-bit VPADDQ is 8
+// adds.  Write masks are ignored; narrower element types are not counted.
+static VOID PIN_FAST_ANALYSIS_CALL VecCount(THREADID tid, UINT32 sid,
+                                            UINT32 op, UINT32 lanes)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    st->cnts.vec[op] += lanes;
+    if (sid != NO_SITE) SiteCnts(st, sid).vec[op] += lanes;
+    if (g_calls_on) CtxCnts(st).vec[op] += lanes;
+}
+
+static bool ClassifyVec(std::string m, VecOp& op)
+{
+    if (m.size() > 1 && m[0] == 'V') m.erase(0, 1);
+    if (m == "PADDQ")                                  { op = VADD; return true; }
+    if (m == "PSUBQ")                                  { op = VSUB; return true; }
+    if (m == "PMULLQ" || m == "PMULUDQ" || m == "PMULDQ" ||
+        m == "PMADD52LUQ" || m == "PMADD52HUQ")        { op = VMUL; return true; }
+    return false;
+}
+
+static VOID InstrumentVec(INS ins, VOID*)
+{
+    VecOp op;
+    if (!ClassifyVec(INS_Mnemonic(ins), op)) return;
+
+    UINT32 lanes = Lanes(ins, 64);
+    // the IFMA VPMADD52{L,H}UQ multiply-adds
+    const bool fused = INS_Mnemonic(ins).find("PMADD52") != std::string::npos;
+    if (fused && g_compound != CMP_FUSED) InsertCompound(ins, CVMADD, lanes);
+    const bool split = fused && g_compound == CMP_SPLIT;
+    for (VecOp o : {op, VADD}) {
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                              IARG_UINT32, UINT32(o), IARG_UINT32, lanes, IARG_END);
+        InsertCounter(ins, (AFUNPTR)VecCount, args);
+        if (!split) break;
+    }
+}
+
+// ── instrumentation – memory operations ─────────────────────────────────────
+// Every explicit and implicit data memory operand: a read is a load, a
+// write a store (read-modify-write is both), sized by the operand.  Stack
+// traffic (PUSH, POP, CALL, RET) is included, prefetches are not; REP
+// string instructions count once per iteration and a gather or scatter as
+// one access of its operand size.
+static VOID PIN_FAST_ANALYSIS_CALL MemCount(THREADID tid, UINT32 sid,
+                                            UINT32 loads, UINT32 stores,
+                                            UINT32 rbytes, UINT32 wbytes)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    const UINT64 d[MEM_ACCESS_KINDS] = {loads, stores, rbytes, wbytes};
+    for (int k = 0; k < MEM_ACCESS_KINDS; ++k) {
+        st->cnts.mem[k] += d[k];
+        if (sid != NO_SITE) SiteCnts(st, sid).mem[k] += d[k];
+        if (g_calls_on) CtxCnts(st).mem[k] += d[k];
+    }
+}
+
+static VOID InstrumentMem(INS ins, VOID*)
+{
+    if (INS_IsPrefetch(ins)) return;
+    UINT32 loads = 0, stores = 0, rbytes = 0, wbytes = 0;
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        UINT32 size = INS_MemoryOperandSize(ins, i);
+        if (INS_MemoryOperandIsRead(ins, i))    { loads++;  rbytes += size; }
+        if (INS_MemoryOperandIsWritten(ins, i)) { stores++; wbytes += size; }
+    }
+    if (loads == 0 && stores == 0) return;
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins),
+                          IARG_UINT32, loads, IARG_UINT32, stores,
+                          IARG_UINT32, rbytes, IARG_UINT32, wbytes, IARG_END);
+    InsertCounter(ins, (AFUNPTR)MemCount, args);
+}
+
+// ── instrumentation – cache simulation (-cache) ─────────────────────────────
+// -cache SPEC runs each thread's data accesses (those -mem counts) through
+// its own inclusive hierarchy of set-associative LRU caches, nearest level
+// first: a line missing from every level is read from DRAM and filled into
+// all of them, and a line the last level evicts leaves the upper levels
+// too, written back to DRAM if it was stored to.  Stores allocate, so a
+// store miss also reads its line.  The DRAM bytes go to the site whose
+// access caused them, as MDRAM_R and MDRAM_W memory counts; lines still
+// dirty when the program ends are not written back.
+struct CacheConfig {
+    std::string name;
+    UINT64      size;
+    UINT32      ways, sets;
+};
+
+static bool                     g_cache_on = false;
+static std::vector<CacheConfig> g_cache_levels;
+static UINT32                   g_cache_line = 64;
+
+// Parses "name=SIZE[:WAYS],…,line=N" or "default" into g_cache_levels
+static bool ParseCache(std::string spec)
+{
+    if (spec == "default") spec = "l1=32k:8,l2=1m:16,llc=32m:16,line=64";
+    std::istringstream in(spec);
+    std::string tok;
+    while (std::getline(in, tok, ',')) {
+        const size_t eq = tok.find('=');
+        if (eq == std::string::npos || eq == 0) {
+            std::cerr << "Int64Profiler: -cache: '" << tok << "' is not name=SIZE[:WAYS] or line=N"
+                      << std::endl;
+            return false;
+        }
+        const std::string name = tok.substr(0, eq);
+        char* end;
+        UINT64 size = strtoull(tok.c_str() + eq + 1, &end, 10);
+        switch (*end) {
+            case 'k': case 'K': size <<= 10; ++end; break;
+            case 'm': case 'M': size <<= 20; ++end; break;
+            case 'g': case 'G': size <<= 30; ++end; break;
+        }
+        if (name == "line") {
+            if (*end || size == 0 || (size & (size - 1))) {
+                std::cerr << "Int64Profiler: -cache: line must be a power of two" << std::endl;
+                return false;
+            }
+            g_cache_line = UINT32(size);
+            continue;
+        }
+        UINT32 ways = 8;
+        if (*end == ':') ways = UINT32(strtoul(end + 1, &end, 10));
+        if (*end || size == 0 || ways == 0) {
+            std::cerr << "Int64Profiler: -cache: bad level '" << tok << "'" << std::endl;
+            return false;
+        }
+        g_cache_levels.push_back({name, size, ways, 0});
+    }
+    if (g_cache_levels.empty()) {
+        std::cerr << "Int64Profiler: -cache needs at least one level" << std::endl;
+        return false;
+    }
+    for (size_t i = 0; i < g_cache_levels.size(); ++i) {
+        CacheConfig& c = g_cache_levels[i];
+        const UINT64 set_bytes = UINT64(g_cache_line) * c.ways;
+        if (c.size % set_bytes) {
+            std::cerr << "Int64Profiler: -cache: " << c.name << " size is not a multiple of "
+                      << "line × ways (" << set_bytes << " bytes)" << std::endl;
+            return false;
+        }
+        if (i && c.size < g_cache_levels[i - 1].size) {
+            std::cerr << "Int64Profiler: -cache: " << c.name << " is smaller than "
+                      << g_cache_levels[i - 1].name << std::endl;
+            return false;
+        }
+        c.sets = UINT32(c.size / set_bytes);
+    }
+    return true;
+}
+
+// The way of level c holding line, or -1
+static inline int CacheFind(const CacheState& c, const CacheConfig& cfg, UINT64 line)
+{
+    const size_t base = size_t(line % cfg.sets) * cfg.ways;
+    for (UINT32 w = 0; w < cfg.ways; ++w)
+        if (c.tags[base + w] == line + 1) return int(w);
+    return -1;
+}
+
+// Fills line into level c over its least recently used way; returns the
+// index of that way, with the line it held in *victim (0 if none)
+static inline size_t CacheFill(CacheState& c, const CacheConfig& cfg, UINT64 line,
+                               UINT64 now, UINT64* victim)
+{
+    const size_t base = size_t(line % cfg.sets) * cfg.ways;
+    size_t lru = base;
+    for (size_t i = base; i < base + cfg.ways; ++i)
+        if (c.used[i] < c.used[lru]) lru = i;
+    *victim = c.tags[lru];
+    c.tags[lru] = line + 1;
+    c.used[lru] = now;
+    return lru;
+}
+
+static VOID AccessLine(ThreadState* st, UINT32 sid, UINT64 line, bool write)
+{
+    const size_t n = g_cache_levels.size(), last = n - 1;
+    UINT64 dram[2] = {0, 0};        // bytes read, written
+    const UINT64 now = ++st->cache_clock;
+    size_t hit = n;
+    for (size_t i = 0; i < n; ++i) {
+        CacheState& c = st->cache[i];
+        c.accesses++;
+        const int w = CacheFind(c, g_cache_levels[i], line);
+        if (w >= 0) {
+            c.used[size_t(line % g_cache_levels[i].sets) * g_cache_levels[i].ways + w] = now;
+            hit = i;
+            break;
+        }
+        c.misses++;
+    }
+    if (hit == n) {
+        dram[0] = g_cache_line;
+        CacheState& c = st->cache[last];
+        UINT64 victim;
+        const size_t way = CacheFill(c, g_cache_levels[last], line, now, &victim);
+        if (victim) {
+            if (c.dirty[way]) dram[1] = g_cache_line;
+            for (size_t i = 0; i < last; ++i) {     // inclusive: back-invalidate
+                const int w = CacheFind(st->cache[i], g_cache_levels[i], victim - 1);
+                if (w >= 0)
+                    st->cache[i].tags[size_t((victim - 1) % g_cache_levels[i].sets) *
+                                      g_cache_levels[i].ways + w] = 0;
+            }
+        }
+        c.dirty[way] = 0;
+        hit = last;
+    }
+    for (size_t i = 0; i < hit; ++i) {
+        UINT64 victim;
+        CacheFill(st->cache[i], g_cache_levels[i], line, now, &victim);
+    }
+    if (write) {
+        CacheState& c = st->cache[last];
+        const int w = CacheFind(c, g_cache_levels[last], line);
+        c.dirty[size_t(line % g_cache_levels[last].sets) * g_cache_levels[last].ways + w] = 1;
+    }
+    for (int k = 0; k < 2; ++k) {
+        if (!dram[k]) continue;
+        st->cnts.mem[MDRAM_R + k] += dram[k];
+        if (sid != NO_SITE) SiteCnts(st, sid).mem[MDRAM_R + k] += dram[k];
+        if (g_calls_on) CtxCnts(st).mem[MDRAM_R + k] += dram[k];
+    }
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL CacheAccess(THREADID tid, UINT32 sid, ADDRINT ea,
+                                               UINT32 size, BOOL write)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (st->cache.empty()) {
+        st->cache.resize(g_cache_levels.size());
+        for (size_t i = 0; i < g_cache_levels.size(); ++i) {
+            const size_t lines = size_t(g_cache_levels[i].sets) * g_cache_levels[i].ways;
+            st->cache[i].tags.assign(lines, 0);
+            st->cache[i].used.assign(lines, 0);
+        }
+        st->cache.back().dirty.assign(st->cache.back().tags.size(), 0);
+    }
+    const UINT64 first = ea / g_cache_line, last = (ea + std::max<UINT32>(size, 1) - 1) / g_cache_line;
+    for (UINT64 line = first; line <= last; ++line) AccessLine(st, sid, line, write);
+}
+
+static VOID InstrumentCache(INS ins, VOID*)
+{
+    if (INS_IsPrefetch(ins)) return;
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        const bool read = INS_MemoryOperandIsRead(ins, i), write = INS_MemoryOperandIsWritten(ins, i);
+        if (!read && !write) continue;
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_MEMORYOP_EA, i,
+                              IARG_UINT32, INS_MemoryOperandSize(ins, i), IARG_BOOL, write, IARG_END);
+        InsertCounter(ins, (AFUNPTR)CacheAccess, args);
+    }
+}
+
+// ── instrumentation – working set (-footprint N) ───────────────────────────
+// Every data access (those -mem counts) touches the 64-byte lines and
+// 4 KiB pages it spans.  A thread's accesses fall into windows of N; the
+// working set of a window is the distinct lines and pages it touched.  The
+// thread keeps that for itself, for the function of each access and for
+// the running phase, each scope's footprint being everything it touched.
+static const UINT32 FOOT_LINE = 64, FOOT_PAGE = 4096;
+
+static UINT64          g_foot_window = 0;   // -footprint N, 0 = off
+static volatile INT32  g_phase_cur = -1;    // index of the running phase, -1 without -phases
+
+// Counts line and page for s in window win
+static inline VOID FootTouch(FootSet& s, UINT64 line, UINT64 page, UINT64 win)
+{
+    if (s.window != win) {
+        s.peak_lines = std::max(s.peak_lines, s.win_lines);
+        s.peak_pages = std::max(s.peak_pages, s.win_pages);
+        s.win_lines = s.win_pages = 0;
+        s.window = win;
+    }
+    auto l = s.lines.emplace(line, win);
+    if (l.second || l.first->second != win) { l.first->second = win; s.win_lines++; }
+    auto p = s.pages.emplace(page, win);
+    if (p.second || p.first->second != win) { p.first->second = win; s.win_pages++; }
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL FootAccess(THREADID tid, UINT32 fid, ADDRINT ea, UINT32 size)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    const UINT64 win = st->foot_n++ / g_foot_window;
+    if (st->foot.window != win && st->foot.window != ~0ull)
+        st->foot_curve.push_back({st->foot.win_lines, st->foot.win_pages});
+    FootSet& f = st->foot_funcs[fid];
+    FootSet* ph = nullptr;
+    if (g_phase_cur >= 0) {
+        const size_t cur = size_t(g_phase_cur);
+        if (st->foot_phases.size() <= cur) st->foot_phases.resize(cur + 1);
+        ph = &st->foot_phases[cur];
+    }
+    const UINT64 last = (ea + std::max<UINT32>(size, 1) - 1) / FOOT_LINE;
+    for (UINT64 line = ea / FOOT_LINE; line <= last; ++line) {
+        const UINT64 page = line * FOOT_LINE / FOOT_PAGE;
+        FootTouch(st->foot, line, page, win);
+        FootTouch(f, line, page, win);
+        if (ph) FootTouch(*ph, line, page, win);
+    }
+}
+
+static VOID InstrumentFootprint(INS ins, VOID*)
+{
+    if (INS_IsPrefetch(ins)) return;
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        if (!INS_MemoryOperandIsRead(ins, i) && !INS_MemoryOperandIsWritten(ins, i)) continue;
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, FuncId(ins), IARG_MEMORYOP_EA, i,
+                              IARG_UINT32, INS_MemoryOperandSize(ins, i), IARG_END);
+        InsertCounter(ins, (AFUNPTR)FootAccess, args);
+    }
+}
+
+// ── instrumentation – instruction mix (-mix) ────────────────────────────────
+// -mix 1 counts every instruction, a basic block at a time, and classes
+// the control transfers and moves among them by XED category: conditional
+// branches as taken or not (JRCXZ and LOOP included), unconditional
+// jumps, calls and returns, with the jumps and calls through a register or
+// memory also counted as indirect; and the MOV, MOVZX/MOVSX, CMOV and
+// vector move family by operands, register to register, to or from
+// memory, or of an immediate.  What is left is the other work, arithmetic
+// included.
+static const char* const MIX_KIND_NAMES[MIX_KINDS] = {
+    "instructions", "branch_taken", "branch_not_taken", "jump", "call", "ret", "indirect",
+    "move_reg", "move_mem", "move_imm"};
+
+static VOID PIN_FAST_ANALYSIS_CALL MixCount(THREADID tid, UINT32 sid, UINT32 kind, UINT32 n)
+{
+    if (!Counting(tid)) return;
+    St(tid)->mix[kind] += n;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL MixBranch(THREADID tid, UINT32 sid, BOOL taken)
+{
+    if (!Counting(tid)) return;
+    St(tid)->mix[taken ? MX_TAKEN : MX_NOT_TAKEN]++;
+}
+
+static VOID InsertMix(INS ins, MixKind kind, UINT32 n)
+{
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, UINT32(kind),
+                          IARG_UINT32, n, IARG_END);
+    InsertCounter(ins, (AFUNPTR)MixCount, args);
+}
+
+// The move kind of ins, or MIX_KINDS if it is no move
+static MixKind MoveKind(INS ins)
+{
+    const xed_category_enum_t cat = xed_category_enum_t(INS_Category(ins));
+    if (cat != XED_CATEGORY_DATAXFER && cat != XED_CATEGORY_CMOV) return MIX_KINDS;
+    if (INS_MemoryOperandCount(ins) > 0) return MX_MOVE_MEM;
+    for (UINT32 i = 0; i < INS_OperandCount(ins); ++i)
+        if (INS_OperandIsImmediate(ins, i)) return MX_MOVE_IMM;
+    return MX_MOVE_REG;
+}
+
+static VOID InstrumentMix(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        InsertMix(BBL_InsHead(bbl), MX_INSNS, BBL_NumIns(bbl));
+        for (INS ins = BBL_InsHead(bbl); INS_Valid(ins); ins = INS_Next(ins)) {
+            const xed_category_enum_t cat = xed_category_enum_t(INS_Category(ins));
+            if (cat == XED_CATEGORY_COND_BR) {
+                IARGLIST args = IARGLIST_Alloc();
+                IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_BRANCH_TAKEN, IARG_END);
+                InsertCounter(ins, (AFUNPTR)MixBranch, args);
+                continue;
+            }
+            MixKind kind = MoveKind(ins);
+            if (INS_IsRet(ins))         kind = MX_RET;
+            else if (INS_IsCall(ins))   kind = MX_CALL;
+            else if (INS_IsBranch(ins)) kind = MX_JUMP;
+            if (kind == MIX_KINDS) continue;
+            InsertMix(ins, kind, 1);
+            if ((kind == MX_CALL || kind == MX_JUMP) && INS_IsIndirectControlFlow(ins))
+                InsertMix(ins, MX_INDIRECT, 1);
+        }
+    }
+}
+
+// ── instrumentation – divisor analysis ──────────────────────────────────────
+// -divs 1 records the divisor of every counted 64-bit DIV/IDIV (the same
+// register and memory forms as the DIV count) per instruction.  A site
+// that always divided by one value divides by a constant; one whose
+// divisors were all powers of two (|d| for IDIV) can use shifts; the rest
+// are variable divisions.  Sites are handed out at instrumentation time
+// like the attribution sites, one per address: an instruction is
+// instrumented again in every trace that contains it.
+struct DivSiteInfo {
+    ADDRINT     addr;
+    UINT32      func;
+    LineInfo    line;               // "??:0" without DWARF info
+    bool        is_signed;
+};
+
+static std::vector<DivSiteInfo>   g_div_sites;
+static std::map<ADDRINT, UINT32>  g_div_ids;       // instruction → site id
+
+static inline bool IsPow2(UINT64 d, bool is_signed)
+{
+    if (is_signed && static_cast<INT64>(d) < 0) d = 0 - d;
+    return d != 0 && (d & (d - 1)) == 0;
+}
+
+static inline VOID DivSeen(THREADID tid, UINT32 did, bool is_signed, UINT64 d)
+{
+    ThreadState* st = St(tid);
+    if (did >= st->divs.size()) st->divs.resize(did + 1);
+    DivStats& s = st->divs[did];
+    s.n++;
+    if (IsPow2(d, is_signed)) s.pow2++;
+    if (s.nvals > DIV_VALUES) return;
+    for (UINT32 i = 0; i < s.nvals; ++i)
+        if (s.vals[i] == d) return;
+    if (s.nvals < DIV_VALUES) s.vals[s.nvals] = d;
+    s.nvals++;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL DivReg(THREADID tid, UINT32 did, BOOL is_signed, ADDRINT d)
+{
+    if (Counting(tid)) DivSeen(tid, did, is_signed, d);
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL DivMem(THREADID tid, UINT32 did, BOOL is_signed, ADDRINT ea)
+{
+    if (!Counting(tid)) return;
+    UINT64 d = 0;
+    if (PIN_SafeCopy(&d, reinterpret_cast<VOID*>(ea), sizeof d) == sizeof d)
+        DivSeen(tid, did, is_signed, d);
+}
+
+static VOID InstrumentDivs(INS ins, VOID*)
+{
+    const OPCODE opc = INS_Opcode(ins);
+    if (opc != XED_ICLASS_DIV && opc != XED_ICLASS_IDIV) return;
+    bool rr = IsRegReg64(ins);
+    if (!rr && !IsRegMem64(ins)) return;
+
+    DivSiteInfo di{INS_Address(ins), FuncId(ins), {}, opc == XED_ICLASS_IDIV};
+    auto it = g_div_ids.find(di.addr);
+    UINT32 did;
+    if (it != g_div_ids.end()) {
+        did = it->second;
+    } else {
+        PIN_GetSourceLocation(di.addr, nullptr, &di.line.line, &di.line.file);
+        if (di.line.file.empty()) di.line.file = "??";
+        did = static_cast<UINT32>(g_div_sites.size());
+        g_div_sites.push_back(di);
+        g_div_ids[di.addr] = did;
+    }
+
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, did, IARG_BOOL, di.is_signed, IARG_END);
+    if (rr) {
+        IARGLIST_AddArguments(args, IARG_REG_VALUE, INS_OperandReg(ins, 0), IARG_END);
+        InsertCounter(ins, (AFUNPTR)DivReg, args);
+    } else {
+        IARGLIST_AddArguments(args, IARG_MEMORYREAD_EA, IARG_END);
+        InsertCounter(ins, (AFUNPTR)DivMem, args);
+    }
+}
+
+// ── instrumentation – branch predictability (-branches) ─────────────────────
+// -branches N follows every counted conditional branch, per thread as a
+// core would see it, through two simulated predictors: a bimodal 2-bit
+// saturating counter per branch, and a local-history predictor whose 2-bit
+// counters are indexed by the branch's last 8 outcomes, which learns loop
+// exits and other short patterns.  The N branches the bimodal predictor
+// missed most are reported with their taken rate, the entropy of their
+// outcomes (1 bit for a coin flip, 0 for a branch that always goes one
+// way) and, with -loops 1, their innermost loop; a branch back to a lower
+// address closes a loop whether or not -loops found one.  Sites are handed
+// out per address like the division sites.
+struct BranchSiteInfo {
+    ADDRINT     addr;
+    UINT32      func;
+    LineInfo    line;               // "??:0" without DWARF info
+    bool        backward;
+    UINT32      loop;               // innermost loop; NO_SITE outside or without -loops
+};
+
+static UINT64                       g_branches = 0;  // -branches N, 0 = off
+static std::vector<BranchSiteInfo>  g_br_sites;
+static std::map<ADDRINT, UINT32>    g_br_ids;       // instruction → site id
+
+// Predicts with the 2-bit counter ctr and trains it; true on a hit
+static inline bool Predict(UINT8& ctr, bool taken)
+{
+    const bool hit = (ctr >= 2) == taken;
+    if (taken && ctr < 3) ctr++;
+    else if (!taken && ctr > 0) ctr--;
+    return hit;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL BranchSeen(THREADID tid, UINT32 bid, BOOL taken)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (bid >= st->branches.size()) st->branches.resize(bid + 1);
+    BranchStats& b = st->branches[bid];
+    const bool t = taken;
+    if (b.n && t != b.last) b.flips++;
+    b.n++;
+    if (t) b.taken++;
+    if (!Predict(b.bimodal, t)) b.miss_bimodal++;
+    if (!Predict(b.local[b.hist], t)) b.miss_local++;
+    b.hist = ((b.hist << 1) | t) & ((1u << BR_HISTORY) - 1);
+    b.last = t;
+}
+
+static VOID InstrumentBranches(INS ins, VOID*)
+{
+    if (INS_Category(ins) != XED_CATEGORY_COND_BR) return;
+    const ADDRINT addr = INS_Address(ins);
+    auto it = g_br_ids.find(addr);
+    UINT32 bid;
+    if (it != g_br_ids.end()) {
+        bid = it->second;
+    } else {
+        BranchSiteInfo bi{addr, FuncId(ins), {}, false, NO_SITE};
+        bi.backward = INS_IsDirectControlFlow(ins) && INS_DirectControlFlowTargetAddress(ins) <= addr;
+        PIN_GetSourceLocation(addr, nullptr, &bi.line.line, &bi.line.file);
+        if (bi.line.file.empty()) bi.line.file = "??";
+        if (g_loops_on) {
+            auto at = g_loop_at.find(addr);
+            if (at != g_loop_at.end()) bi.loop = at->second;
+        }
+        bid = static_cast<UINT32>(g_br_sites.size());
+        g_br_sites.push_back(bi);
+        g_br_ids[addr] = bid;
+    }
+
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, bid, IARG_BRANCH_TAKEN, IARG_END);
+    InsertCounter(ins, (AFUNPTR)BranchSeen, args);
+}
+
+// ── instrumentation – access patterns (-strides N) ─────────────────────────
+// Every memory operand of an instruction in a loop is a stride site.  Its
+// first access is not classified.  After that, an access is unit stride
+// when it steps by its own size either way from the previous one, constant
+// stride when it steps as the previous one did (by zero too), and
+// irregular otherwise; the second access is classified only if unit.  A
+// site belongs to the innermost loop of its instruction.
+static UINT64                     g_strides = 0;   // -strides N, 0 = off
+static std::vector<std::pair<UINT32, UINT32>> g_stride_sites;  // loop, operand size
+static std::map<std::pair<ADDRINT, UINT32>, UINT32> g_stride_ids;  // instruction, operand → site
+
+static VOID PIN_FAST_ANALYSIS_CALL StrideSeen(THREADID tid, UINT32 sid, ADDRINT ea)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (sid >= st->strides.size()) st->strides.resize(sid + 1);
+    StrideStats& s = st->strides[sid];
+    const ADDRDELTA step = ADDRDELTA(ea - s.last);
+    const ADDRDELTA size = ADDRDELTA(g_stride_sites[sid].second);
+    if (s.n++ == 0) {
+        s.last = ea;
+        return;
+    }
+    if (step == size || step == -size) s.unit++;
+    else if (s.n > 2 && step == s.step) s.constant++;
+    else if (s.n > 2) s.irregular++;
+    s.last = ea;
+    s.step = step;
+}
+
+static VOID InstrumentStrides(INS ins, VOID*)
+{
+    if (INS_IsPrefetch(ins)) return;
+    auto at = g_loop_at.find(INS_Address(ins));
+    if (at == g_loop_at.end()) return;
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        if (!INS_MemoryOperandIsRead(ins, i) && !INS_MemoryOperandIsWritten(ins, i)) continue;
+        const auto key = std::make_pair(INS_Address(ins), i);
+        auto it = g_stride_ids.find(key);
+        UINT32 sid;
+        if (it != g_stride_ids.end()) {
+            sid = it->second;
+        } else {
+            sid = static_cast<UINT32>(g_stride_sites.size());
+            g_stride_sites.push_back({at->second, INS_MemoryOperandSize(ins, i)});
+            g_stride_ids[key] = sid;
+        }
+        IARGLIST args = IARGLIST_Alloc();
+        IARGLIST_AddArguments(args, IARG_UINT32, sid, IARG_MEMORYOP_EA, i, IARG_END);
+        InsertCounter(ins, (AFUNPTR)StrideSeen, args);
+    }
+}
+
+// ── instrumentation – multiply operand widths ───────────────────────────────
+// -mulvals N reads both source operands of every Nth counted 64-bit
+// multiply per thread (the same forms as the MUL count): RAX and the
+// operand of one-operand MUL/IMUL, both operands of two-operand IMUL, RDX
+// and the source of MULX.  An operand's effective width is its bit length,
+// unsigned for MUL/MULX and two's complement (sign bit included) for IMUL,
+// so a w-bit multiplier of the same signedness takes it.
+static inline UINT32 OperandBits(UINT64 v, bool is_signed)
+{
+    if (is_signed) {
+        if (static_cast<INT64>(v) < 0) v = ~v;
+        return v ? std::min<UINT32>(65 - __builtin_clzll(v), 64) : 1;
+    }
+    return v ? 64 - __builtin_clzll(v) : 0;
+}
+
+static inline VOID MulSeen(THREADID tid, bool is_signed, UINT64 a, UINT64 b)
+{
+    ThreadState* st = St(tid);
+    if (st->mul_seen++ % g_mulvals) return;
+    UINT32 wa = OperandBits(a, is_signed), wb = OperandBits(b, is_signed);
+    st->mulw[0][std::max(wa, wb)]++;
+    st->mulw[1][std::min(wa, wb)]++;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL MulValReg(THREADID tid, BOOL is_signed, ADDRINT a, ADDRINT b)
+{
+    if (Counting(tid)) MulSeen(tid, is_signed, a, b);
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL MulValMem(THREADID tid, BOOL is_signed, ADDRINT a, ADDRINT ea)
+{
+    if (!Counting(tid)) return;
+    UINT64 b = 0;
+    if (PIN_SafeCopy(&b, reinterpret_cast<VOID*>(ea), sizeof b) == sizeof b)
+        MulSeen(tid, is_signed, a, b);
+}
+
+static VOID InstrumentMulVals(INS ins, VOID*)
+{
+    const OPCODE opc = INS_Opcode(ins);
+    if (opc != XED_ICLASS_MUL && opc != XED_ICLASS_IMUL && opc != XED_ICLASS_MULX) return;
+    if (HasImm(ins)) return;
+    bool rr = IsRegReg64(ins);
+    if (!rr && !IsRegMem64(ins)) return;
+
+    UINT32 explicit_ops = 0;
+    for (UINT32 i = 0; i < INS_OperandCount(ins); ++i)
+        if (!INS_OperandIsImplicit(ins, i)) explicit_ops++;
+    // the register operand, then the reg-or-memory source
+    REG    a;
+    UINT32 src;
+    if (opc == XED_ICLASS_MULX)  { a = REG_RDX; src = 2; }
+    else if (explicit_ops == 1)  { a = REG_RAX; src = 0; }
+    else                         { a = INS_OperandReg(ins, 0); src = 1; }
+
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_BOOL, opc == XED_ICLASS_IMUL,
+                          IARG_REG_VALUE, a, IARG_END);
+    if (rr) {
+        IARGLIST_AddArguments(args, IARG_REG_VALUE, INS_OperandReg(ins, src), IARG_END);
+        InsertCounter(ins, (AFUNPTR)MulValReg, args);
+    } else {
+        IARGLIST_AddArguments(args, IARG_MEMORYREAD_EA, IARG_END);
+        InsertCounter(ins, (AFUNPTR)MulValMem, args);
+    }
+}
+
+// ── instrumentation – wide-integer arithmetic ───────────────────────────────
+// A static pass over each basic block looks for multi-limb arithmetic:
+//   add / sub   ADD (SUB) followed by ADC (SBB) on 64-bit operands with the
+//               carry left untouched in between; ADCX and ADOX chains track
+//               CF and OF separately.  Limbs = chain length.
+//   multiply    a widening 64×64→128 multiply (MULX, one-operand MUL/IMUL)
+//               in a block that also adds; k 64-bit multiplies in the block
+//               are read as a ⌈√k⌉-limb operand product.
+// Each detected operation counts once, at its first instruction; its
+// instructions are still counted under add/sub/mul.  Chains that keep the
+// carry across a loop branch are not seen.
+static VOID PIN_FAST_ANALYSIS_CALL WideCount(THREADID tid, UINT32 sid, UINT32 slot)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    (&st->cnts.wide[0][0])[slot]++;
+    if (sid != NO_SITE) (&SiteCnts(st, sid).wide[0][0])[slot]++;
+    if (g_calls_on) (&CtxCnts(st).wide[0][0])[slot]++;
+}
+
+static VOID InsertWide(INS ins, int kind, UINT32 limbs)
+{
+    UINT32 slot = kind * WIDE_SLOTS + std::min<UINT32>(limbs, WIDE_SLOTS + 1) - 2;
+    DBG(2, "Wide " << limbs << "-limb op @ 0x" << std::hex << INS_Address(ins) << std::dec);
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, slot, IARG_END);
+    InsertCounter(ins, (AFUNPTR)WideCount, args);
+}
+
+static inline bool Is64Op(INS ins)
+{
+    return INS_OperandCount(ins) > 0 && INS_OperandWidth(ins, 0) == 64;
+}
+
+static inline xed_flag_set_t FlagsWritten(INS ins)
+{
+    xed_flag_set_t fs;
+    fs.flat = 0;
+    const xed_simple_flag_t* f = xed_decoded_inst_get_rflags_info(INS_XedDec(ins));
+    if (f) fs = *xed_simple_flag_get_written_flag_set(f);
+    return fs;
+}
+
+struct Chain {
+    INS    head  = INS_Invalid();
+    int    kind  = WADD;
+    UINT32 limbs = 0;
+};
+
+static VOID CloseChain(Chain& c)
+{
+    if (c.limbs >= 2) InsertWide(c.head, c.kind, c.limbs);
+    c.limbs = 0;
+}
+
+static VOID Extend(Chain& c, INS ins, int kind, bool starts)
+{
+    if (!starts && c.limbs && c.kind == kind) { c.limbs++; return; }
+    CloseChain(c);
+    c.head = ins; c.kind = kind; c.limbs = 1;
+}
+
+static bool InstrumentWideMul(BBL bbl)
+{
+    UINT32 muls = 0;
+    bool adds = false;
+    INS head = INS_Invalid();
+    for (INS ins = BBL_InsHead(bbl); INS_Valid(ins); ins = INS_Next(ins)) {
+        if (!Is64Op(ins)) continue;
+        switch (INS_Opcode(ins)) {
+            case XED_ICLASS_MULX:
+                if (!INS_Valid(head)) head = ins;
+                muls++;
+                break;
+            case XED_ICLASS_MUL:
+            case XED_ICLASS_IMUL:
+                // the one-operand forms leave the high half in RDX
+                if (!INS_Valid(head) && INS_RegWContain(ins, REG_RAX) &&
+                    INS_RegWContain(ins, REG_RDX)) head = ins;
+                muls++;
+                break;
+            case XED_ICLASS_ADD:  case XED_ICLASS_ADC:
+            case XED_ICLASS_ADCX: case XED_ICLASS_ADOX:
+                adds = true;
+                break;
+            default:
+                break;
+        }
+    }
+    if (!INS_Valid(head) || !adds) return false;
+    UINT32 limbs = std::max<UINT32>(2, UINT32(std::ceil(std::sqrt(double(muls)))));
+    InsertWide(head, WMUL, limbs);
+    return true;
+}
+
+static VOID InstrumentWide(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        // carries inside a multiply block are its partial-product sums
+        if (InstrumentWideMul(bbl)) continue;
+
+        Chain cf, of;
+        for (INS ins = BBL_InsHead(bbl); INS_Valid(ins); ins = INS_Next(ins)) {
+            bool ext_cf = false, ext_of = false;
+            if (Is64Op(ins)) {
+                switch (INS_Opcode(ins)) {
+                    case XED_ICLASS_ADD:  Extend(cf, ins, WADD, true);  ext_cf = true; break;
+                    case XED_ICLASS_SUB:  Extend(cf, ins, WSUB, true);  ext_cf = true; break;
+                    case XED_ICLASS_ADC:
+                    case XED_ICLASS_ADCX: Extend(cf, ins, WADD, false); ext_cf = true; break;
+                    case XED_ICLASS_SBB:  Extend(cf, ins, WSUB, false); ext_cf = true; break;
+                    case XED_ICLASS_ADOX: Extend(of, ins, WADD, false); ext_of = true; break;
+                    default: break;
+                }
+            }
+            xed_flag_set_t fs = FlagsWritten(ins);
+            if (!ext_cf && fs.s.cf) CloseChain(cf);
+            if (!ext_of && fs.s.of) CloseChain(of);
+        }
+        CloseChain(cf);
+        CloseChain(of);
+    }
+}
+
+// ── instrumentation – modular arithmetic ────────────────────────────────────
+// -modarith 1 reads each basic block as a string of tokens, W widening
+// 64×64→128 multiply, L low multiply (IMUL r, r/m), A add (ADD/ADC/ADCX/
+// ADOX, LEA b+i), S SUB/SBB, P CMP, C CMOVcc, D DIV and K a call to
+// __umodti3 / __modti3, and matches the single-word reductions compilers
+// emit for 64-bit moduli:
+//   montgomery   3 multiplies, 2 W + 1 L, then A    t=ab, m=t·q', t+mq
+//   barrett      3 multiplies, 2 W + 1 L or 3 W, then S    ab − ⌊ab·μ⌋q
+//   shoup        3 multiplies, 1 W + 2 L, then S    aw − ⌊aw'⌋q
+//   division     W followed by D or K               (ab) mod q
+//   modadd       A, S/P… with at least one S, C     a+b, conditional −q
+//   modsub       S, S/P…, A, S/P…, C                a−b, conditional +q
+// Anything up to the next C ends a modmul match (its final conditional
+// subtract).  Matches count at their first instruction, whose
+// instructions still count under add/sub/mul; reductions branching on
+// the compare are split across blocks and not seen.
+enum ModTok { TNONE, TW, TL, TA, TS, TP, TC, TD, TK };
+
+struct ModTokIns {
+    ModTok t;
+    INS    ins;
+};
+
+static VOID PIN_FAST_ANALYSIS_CALL ModCount(THREADID tid, UINT32 sid, UINT32 kind)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    st->cnts.mod[kind]++;
+    if (sid != NO_SITE) SiteCnts(st, sid).mod[kind]++;
+    if (g_calls_on) CtxCnts(st).mod[kind]++;
+}
+
+static VOID InsertMod(INS ins, ModKind kind, UINT32* found)
+{
+    found[kind]++;
+    DBG(2, "Modular op " << kind << " @ 0x" << std::hex << INS_Address(ins) << std::dec);
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, SiteId(ins), IARG_UINT32, kind, IARG_END);
+    InsertCounter(ins, (AFUNPTR)ModCount, args);
+}
+
+static ModTok ModToken(INS ins)
+{
+    if (INS_IsDirectCall(ins)) {
+        RTN rtn = RTN_FindByAddress(INS_DirectControlFlowTargetAddress(ins));
+        if (!RTN_Valid(rtn)) return TNONE;
+        const std::string& name = RTN_Name(rtn);
+        return name.rfind("__umodti3", 0) == 0 || name.rfind("__modti3", 0) == 0 ? TK : TNONE;
+    }
+    if (TouchesStack(ins) || !Is64Op(ins)) return TNONE;
+    switch (INS_Opcode(ins)) {
+        case XED_ICLASS_MULX:
+        case XED_ICLASS_MUL:  return TW;
+        case XED_ICLASS_IMUL:
+            return INS_RegWContain(ins, REG_RAX) && INS_RegWContain(ins, REG_RDX) ? TW : TL;
+        case XED_ICLASS_ADD:  case XED_ICLASS_ADC:
+        case XED_ICLASS_ADCX: case XED_ICLASS_ADOX:
+            return TA;
+        case XED_ICLASS_LEA:
+            return REG_valid(INS_MemoryBaseReg(ins)) && REG_valid(INS_MemoryIndexReg(ins)) &&
+                   INS_MemoryScale(ins) == 1 ? TA : TNONE;
+        case XED_ICLASS_SUB:  case XED_ICLASS_SBB: return TS;
+        case XED_ICLASS_CMP:  return TP;
+        case XED_ICLASS_DIV:  return TD;
+        default:
+            return INS_Category(ins) == XED_CATEGORY_CMOV ? TC : TNONE;
+    }
+}
+
+static inline bool IsMulTok(ModTok t) { return t == TW || t == TL; }
+
+// Index just past the C that closes a match from k, or of the next multiply
+static size_t SkipToCmov(const std::vector<ModTokIns>& T, size_t k)
+{
+    while (k < T.size() && T[k].t != TC && !IsMulTok(T[k].t)) ++k;
+    return k < T.size() && T[k].t == TC ? k + 1 : k;
+}
+
+static VOID InsertBfly(INS ins, UINT32 n);
+
+static VOID InstrumentModArith(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        UINT32 found[MOD_KINDS] = {};
+        std::vector<ModTokIns> T;
+        for (INS ins = BBL_InsHead(bbl); INS_Valid(ins); ins = INS_Next(ins)) {
+            ModTok t = ModToken(ins);
+            if (t != TNONE) T.push_back({t, ins});
+        }
+        const size_t n = T.size();
+        for (size_t i = 0; i < n;) {
+            if (IsMulTok(T[i].t)) {
+                UINT32 w = 0, l = 0;
+                size_t j = i, last = i;
+                for (; j < n && w + l < 3; ++j) {
+                    if (T[j].t == TD || T[j].t == TK) break;
+                    if (T[j].t == TW) { w++; last = j; }
+                    if (T[j].t == TL) { l++; last = j; }
+                }
+                if (T[i].t == TW && w + l == 1 && j < n) {
+                    InsertMod(T[i].ins, MOD_DIV, found);
+                    i = j + 1;
+                    continue;
+                }
+                size_t k = last + 1;
+                while (k < n && T[k].t != TA && T[k].t != TS && T[k].t != TD &&
+                       T[k].t != TK && !IsMulTok(T[k].t)) ++k;
+                int kind = -1;
+                if (w + l == 3 && k < n) {
+                    if (T[k].t == TA && w == 2)               kind = MOD_MONT;
+                    else if (T[k].t == TS && w >= 2)          kind = MOD_BARRETT;
+                    else if (T[k].t == TS && w == 1)          kind = MOD_SHOUP;
+                }
+                if (kind >= 0) {
+                    InsertMod(T[i].ins, static_cast<ModKind>(kind), found);
+                    i = SkipToCmov(T, k);
+                    continue;
+                }
+            } else if (T[i].t == TA || T[i].t == TS) {
+                // modadd: A (S|P)+ C with an S; modsub: S (S|P)* A (S|P)* C
+                size_t k = i + 1;
+                bool sub = T[i].t == TS;
+                if (sub) {
+                    while (k < n && (T[k].t == TS || T[k].t == TP)) ++k;
+                    if (k >= n || T[k].t != TA) { ++i; continue; }
+                    ++k;
+                }
+                bool has_s = sub;
+                size_t from = k;
+                for (; k < n && (T[k].t == TS || T[k].t == TP); ++k) has_s |= T[k].t == TS;
+                if (k < n && T[k].t == TC && has_s && (sub || k > from)) {
+                    InsertMod(T[i].ins, sub ? MOD_SUB : MOD_ADD, found);
+                    i = k + 1;
+                    continue;
+                }
+            }
+            ++i;
+        }
+        if (g_bfly_on) {
+            UINT32 muls = found[MOD_MONT] + found[MOD_BARRETT] + found[MOD_SHOUP] + found[MOD_DIV];
+            UINT32 b = std::min({muls, found[MOD_ADD], found[MOD_SUB]});
+            if (b) InsertBfly(BBL_InsHead(bbl), b);
+        }
+    }
+}
+
+// ── instrumentation – NTT / FFT butterflies ─────────────────────────────────
+// -butterflies 1 reads a basic block with a modmul, a modadd and a modsub
+// as a butterfly (Cooley–Tukey a ± w·b or Gentleman–Sande (a ± b), w·(a − b)),
+// min of the three per execution.  Butterflies are tallied per invocation
+// of their function, closed at its next entry or at exit: a radix-2
+// transform of size N runs (N/2)·log₂N of them, which gives the size.
+// Recursive transforms and per-stage functions read as several smaller
+// invocations.
+static VOID PIN_FAST_ANALYSIS_CALL BflyCount(THREADID tid, UINT32 func, UINT32 n)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (func >= st->bfly_open.size()) st->bfly_open.resize(func + 1);
+    st->bfly_open[func] += n;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL BflyEnter(THREADID tid, UINT32 func)
+{
+    ThreadState* st = St(tid);
+    if (func < st->bfly_open.size() && st->bfly_open[func]) {
+        st->bfly_calls[{func, st->bfly_open[func]}]++;
+        st->bfly_open[func] = 0;
+    }
+}
+
+static VOID InsertBfly(INS ins, UINT32 n)
+{
+    DBG(2, n << " butterflies @ 0x" << std::hex << INS_Address(ins) << std::dec);
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, FuncId(ins), IARG_UINT32, n, IARG_END);
+    InsertCounter(ins, (AFUNPTR)BflyCount, args);
+}
+
+static VOID InstrumentBflyRtn(RTN rtn, VOID*)
+{
+    UINT32 func = FuncId(rtn);
+    RTN_Open(rtn);
+    RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)BflyEnter, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_UINT32, func, IARG_END);
+    RTN_Close(rtn);
+}
+
+// Transform size N with (N/2)·log₂N butterflies, 0 if there is none
+static UINT64 BflySize(UINT64 b)
+{
+    for (UINT32 k = 1; k < 48; ++k)
+        if ((UINT64(1) << (k - 1)) * k == b) return UINT64(1) << k;
+    return 0;
+}
+
+// ── hot basic blocks (-blocks) ──────────────────────────────────────────────
+// -blocks N lists the N basic blocks that ran the most counted operations,
+// with their instructions decoded (mnemonic, bytes, operands and the op
+// each one counts as), for tools that turn hot code into accelerator
+// kernels.  Blocks are Pin's: single entry, single exit, ended by any
+// control transfer, calls included.  A block is keyed by its address and
+// length, since a trace may start in the middle of another one's block;
+// its operations are the per-execution count of its counted instructions
+// (vector and FP lanes included) times its executions.
+struct BlockOperand {
+    char        kind;               // 'r'eg, 'm'em, 'i'mm, 'a'ddress (LEA), 'b'ranch
+    bool        read, written, implicit;
+    UINT32      width;
+    std::string reg;                // 'r'; the base register for 'm' and 'a'
+    std::string index;              // 'm', 'a'
+    UINT32      scale = 0;
+    INT64       value = 0;          // immediate, displacement
+};
+
+struct BlockIns {
+    ADDRINT     offset;             // from the block start
+    std::string bytes;              // hex
+    std::string disasm;
+    std::string mnemonic;
+    char        op_kind = 0;        // 'i'nt, 'b'it, 'v'ec, 'f'p; 0 if not counted
+    int         op = 0;             // category within the kind
+    UINT32      lanes = 1;
+    std::vector<BlockOperand> operands;
+};
+
+struct BlockInfo {
+    ADDRINT     addr;
+    ADDRINT     image_offset;       // from the image's load address
+    UINT32      func;
+    ADDRINT     func_offset;        // from the function start
+    LineInfo    line;               // of the first instruction
+    UINT32      ops = 0;            // counted operations per execution
+    std::vector<BlockIns> ins;
+};
+
+static UINT64                    g_blocks = 0;        // -blocks N, 0 = off
+static std::vector<BlockInfo>    g_block_info;
+static std::map<std::pair<ADDRINT, UINT32>, UINT32> g_block_ids;   // (address, insns)
+
+static VOID PIN_FAST_ANALYSIS_CALL BlockCount(THREADID tid, UINT32 bid)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (bid >= st->block_execs.size()) st->block_execs.resize(bid + 1);
+    st->block_execs[bid]++;
+}
+
+// The counter ins bumps with the enabled categories, as the instrumentation
+// above decides
+static VOID ClassifyBlockIns(INS ins, BlockIns& bi)
+{
+    bool rr, rm;
+    if (CountedArith(ins, rr)) {
+        bi.op_kind = 'i';
+        switch (INS_Opcode(ins)) {
+            case XED_ICLASS_SUB: case XED_ICLASS_SBB:   bi.op = 1; break;
+            case XED_ICLASS_MUL: case XED_ICLASS_IMUL:
+            case XED_ICLASS_MULX:                       bi.op = 2; break;
+            case XED_ICLASS_DIV: case XED_ICLASS_IDIV:  bi.op = 3; break;
+            default:                                    bi.op = 0; break;
+        }
+        return;
+    }
+    bool scaled;
+    if (g_compound == CMP_SPLIT && ArithLea(ins, scaled)) {
+        bi.op_kind = 'i';
+        bi.op = 0;
+        return;
+    }
+    int bop = g_bits_on ? CountedBit(ins, rm) : -1;
+    if (bop >= 0) {
+        bi.op_kind = 'b';
+        bi.op = bop;
+        return;
+    }
+    VecOp vop;
+    if (g_vec_on && ClassifyVec(INS_Mnemonic(ins), vop)) {
+        bi.op_kind = 'v';
+        bi.op = vop;
+        bi.lanes = Lanes(ins, 64);
+        return;
+    }
+    FpPrec prec; FpOp fop; bool packed;
+    if (g_fp_on && ClassifyFp(INS_Mnemonic(ins), prec, fop, packed)) {
+        bi.op_kind = 'f';
+        bi.op = prec * FP_OPS + fop;
+        bi.lanes = packed ? Lanes(ins, prec == FP64 ? 64 : 32) : 1;
+    }
+}
+
+// Counted operations per execution of bbl
+static UINT32 BlockOps(BBL bbl)
+{
+    UINT32 n = 0;
+    for (INS ins = BBL_InsHead(bbl); INS_Valid(ins); ins = INS_Next(ins)) {
+        BlockIns bi;
+        ClassifyBlockIns(ins, bi);
+        if (bi.op_kind) n += bi.lanes;
+    }
+    return n;
+}
+
+// Fills in the offset from base, encoding, disassembly and op of ins
+static VOID DescribeIns(INS ins, ADDRINT base, BlockIns& bi)
+{
+    bi.offset = INS_Address(ins) - base;
+    UINT8 raw[16];
+    size_t n = PIN_SafeCopy(raw, reinterpret_cast<VOID*>(INS_Address(ins)),
+                            std::min<size_t>(INS_Size(ins), sizeof raw));
+    std::ostringstream hex;
+    for (size_t i = 0; i < n; ++i)
+        hex << std::hex << std::setw(2) << std::setfill('0') << UINT32(raw[i]);
+    bi.bytes = hex.str();
+    bi.disasm = INS_Disassemble(ins);
+    bi.mnemonic = INS_Mnemonic(ins);
+    std::transform(bi.mnemonic.begin(), bi.mnemonic.end(), bi.mnemonic.begin(), ::tolower);
+    ClassifyBlockIns(ins, bi);
+}
+
+static UINT32 BlockId(BBL bbl)
+{
+    auto key = std::make_pair(BBL_Address(bbl), BBL_NumIns(bbl));
+    auto it = g_block_ids.find(key);
+    if (it != g_block_ids.end()) return it->second;
+
+    BlockInfo b;
+    INS head = BBL_InsHead(bbl);
+    b.addr = BBL_Address(bbl);
+    IMG img = IMG_FindByAddress(b.addr);
+    b.image_offset = IMG_Valid(img) ? b.addr - IMG_LowAddress(img) : b.addr;
+    RTN rtn = INS_Rtn(head);
+    b.func = FuncId(rtn);
+    b.func_offset = RTN_Valid(rtn) ? b.addr - RTN_Address(rtn) : 0;
+    PIN_GetSourceLocation(b.addr, nullptr, &b.line.line, &b.line.file);
+    if (b.line.file.empty()) b.line.file = "??";
+    for (INS ins = head; INS_Valid(ins); ins = INS_Next(ins)) {
+        BlockIns bi;
+        DescribeIns(ins, b.addr, bi);
+        if (bi.op_kind) b.ops += bi.lanes;
+
+        for (UINT32 i = 0; i < INS_OperandCount(ins); ++i) {
+            BlockOperand o{};
+            o.read = INS_OperandRead(ins, i);
+            o.written = INS_OperandWritten(ins, i);
+            o.implicit = INS_OperandIsImplicit(ins, i);
+            o.width = INS_OperandWidth(ins, i);
+            if (INS_OperandIsReg(ins, i)) {
+                o.kind = 'r';
+                o.reg = REG_StringShort(INS_OperandReg(ins, i));
+            } else if (INS_OperandIsMemory(ins, i) || INS_OperandIsAddressGenerator(ins, i)) {
+                o.kind = INS_OperandIsMemory(ins, i) ? 'm' : 'a';
+                REG base = INS_OperandMemoryBaseReg(ins, i), index = INS_OperandMemoryIndexReg(ins, i);
+                if (REG_valid(base)) o.reg = REG_StringShort(base);
+                if (REG_valid(index)) {
+                    o.index = REG_StringShort(index);
+                    o.scale = INS_OperandMemoryScale(ins, i);
+                }
+                o.value = INS_OperandMemoryDisplacement(ins, i);
+            } else if (INS_OperandIsImmediate(ins, i)) {
+                o.kind = 'i';
+                o.value = static_cast<INT64>(INS_OperandImmediate(ins, i));
+            } else if (INS_OperandIsBranchDisplacement(ins, i)) {
+                o.kind = 'b';
+                if (INS_IsDirectControlFlow(ins))
+                    o.value = static_cast<INT64>(INS_DirectControlFlowTargetAddress(ins) - b.addr);
+            } else {
+                continue;
+            }
+            bi.operands.push_back(o);
+        }
+        b.ins.push_back(bi);
+    }
+
+    UINT32 id = static_cast<UINT32>(g_block_info.size());
+    g_block_info.push_back(b);
+    g_block_ids[key] = id;
+    return id;
+}
+
+static VOID InstrumentBlocks(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        INS head = BBL_InsHead(bbl);
+        if ((Filtering() && !Counted(head)) || BlockOps(bbl) == 0) continue;
+        UINT32 bid = BlockId(bbl);
+        BBL_InsertCall(bbl, IPOINT_BEFORE, (AFUNPTR)BlockCount, IARG_FAST_ANALYSIS_CALL,
+                       IARG_THREAD_ID, IARG_UINT32, bid, IARG_END);
+    }
+}
+
+// ── block projection (-projection) ──────────────────────────────────────────
+// The cheapest pass: one counter per basic block and nothing else. The
+// blocks are written with their code and iccad decodes and classifies
+// them, multiplying each block's ops by its executions.
+struct ProjBlock {
+    ADDRINT     addr;
+    std::string bytes;              // hex
+    std::string func;               // "-" outside any routine
+    std::string image;              // "-" outside any image
+    UINT64      execs;
+};
+
+static std::deque<ProjBlock>                          g_proj;      // stable for IARG_PTR
+static std::map<std::pair<ADDRINT, USIZE>, ProjBlock*> g_proj_ids;
+
+static VOID PIN_FAST_ANALYSIS_CALL ProjCount(UINT64* execs)
+{
+    __atomic_fetch_add(execs, 1, __ATOMIC_RELAXED);
+}
+
+static VOID InstrumentProjection(TRACE trace, VOID*)
+{
+    for (BBL bbl = TRACE_BblHead(trace); BBL_Valid(bbl); bbl = BBL_Next(bbl)) {
+        auto key = std::make_pair(BBL_Address(bbl), BBL_Size(bbl));
+        auto it = g_proj_ids.find(key);
+        if (it == g_proj_ids.end()) {
+            ProjBlock b{BBL_Address(bbl), "", "-", "-", 0};
+            std::vector<UINT8> raw(BBL_Size(bbl));
+            size_t n = PIN_SafeCopy(raw.data(), reinterpret_cast<VOID*>(b.addr), raw.size());
+            std::ostringstream hex;
+            for (size_t i = 0; i < n; ++i)
+                hex << std::hex << std::setw(2) << std::setfill('0') << UINT32(raw[i]);
+            b.bytes = hex.str();
+            RTN rtn = INS_Rtn(BBL_InsHead(bbl));
+            if (RTN_Valid(rtn) && !RTN_Name(rtn).empty()) b.func = RTN_Name(rtn);
+            IMG img = IMG_FindByAddress(b.addr);
+            if (IMG_Valid(img)) b.image = IMG_Name(img);
+            g_proj.push_back(b);
+            it = g_proj_ids.emplace(key, &g_proj.back()).first;
+        }
+        BBL_InsertCall(bbl, IPOINT_BEFORE, (AFUNPTR)ProjCount, IARG_FAST_ANALYSIS_CALL,
+                       IARG_PTR, &it->second->execs, IARG_END);
+    }
+}
+
+// One "<executions> <address> <bytes> <function> <image>" line per executed
+// block
+static VOID ProjectionFini(INT32, VOID*)
+{
+    std::ofstream os(knobProjection.Value().c_str());
+    for (const auto& b : g_proj) {
+        if (b.execs == 0 || b.bytes.empty()) continue;
+        os << b.execs << " 0x" << std::hex << b.addr << std::dec << ' ' << b.bytes << ' ' << b.func << ' ' << b.image << '\n';
+    }
+}
+
+// ── annotated disassembly (-annotate) ───────────────────────────────────────
+// Every instruction of a function matching -annotate is listed when its
+// routine is instrumented, executed or not, and counted on its own; the
+// instructions of all annotated functions share one id space.
+struct AnnIns {
+    BlockIns    ins;                // offset from the function start, no operands
+    LineInfo    line;
+};
+
+struct AnnFunc {
+    UINT32      func;
+    ADDRINT     addr;
+    UINT32      first;              // id of its first instruction
+    std::vector<AnnIns> ins;
+};
+
+static std::vector<std::string>  g_ann_pats;
+static std::vector<AnnFunc>      g_ann_funcs;
+static std::set<ADDRINT>         g_ann_seen;
+static UINT32                    g_ann_total = 0;
+
+static VOID PIN_FAST_ANALYSIS_CALL AnnCount(THREADID tid, UINT32 id)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    if (id >= st->ann_execs.size()) st->ann_execs.resize(id + 1);
+    st->ann_execs[id]++;
+}
+
+static VOID InstrumentAnnotateRtn(RTN rtn, VOID*)
+{
+    const std::string& name = RTN_Name(rtn);
+    bool hit = false;
+    for (const auto& pat : g_ann_pats) hit = hit || GlobMatch(pat.c_str(), name.c_str());
+    if (!hit || !g_ann_seen.insert(RTN_Address(rtn)).second) return;
+
+    AnnFunc f;
+    f.func = FuncId(rtn);
+    f.addr = RTN_Address(rtn);
+    f.first = g_ann_total;
+    RTN_Open(rtn);
+    for (INS ins = RTN_InsHead(rtn); INS_Valid(ins); ins = INS_Next(ins)) {
+        AnnIns a;
+        DescribeIns(ins, f.addr, a.ins);
+        PIN_GetSourceLocation(INS_Address(ins), nullptr, &a.line.line, &a.line.file);
+        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)AnnCount, IARG_FAST_ANALYSIS_CALL,
+                       IARG_THREAD_ID, IARG_UINT32, g_ann_total++, IARG_END);
+        f.ins.push_back(a);
+    }
+    RTN_Close(rtn);
+    DBG(1, "Annotating " << name << " (" << f.ins.size() << " instructions)");
+    g_ann_funcs.push_back(f);
+}
+
+// ── dataflow graph (-dfg) ───────────────────────────────────────────────────
+// -dfg 1 builds the dataflow graph of the counted code, meant to be scoped
+// to a hot function, marked region or -include: nodes are the static
+// counted instructions, plus loads of values the graph did not produce (its
+// inputs), and an edge joins the producer of a value to each instruction
+// that consumed it, counted per execution.  Values are followed through
+// registers and through memory in 8-byte chunks, so spills and stack
+// temporaries at -O0 do not break a chain; moves and other uncounted
+// instructions pass their first source on.  Every instruction is
+// followed, counted or not: one outside the scope or the filters makes no
+// node and passes nothing on, so what it overwrites no longer holds a
+// value of the graph and a later entry does not depend on the last.
+// Addresses feeding a memory operand, flags and the stack pointer are not
+// data.  Dependencies between threads are not followed.
+static const UINT32 NO_NODE = ~0u;
+
+struct DfgIns {
+    UINT32            node;        // NO_NODE if the instruction is no node
+    bool              op;          // a counted instruction, else a load
+    bool              in;          // in the filters: may hold graph values
+    std::vector<REG>  src, dst;    // data registers read and written
+    UINT32            rsize, wsize;  // memory read and written, bytes
+};
+
+struct DfgNode {
+    ADDRINT   addr;
+    UINT32    func;
+    LineInfo  line;
+    BlockIns  ins;                 // offset from the function start
+};
+
+static bool                  g_dfg_on = false;
+static std::deque<DfgIns>    g_dfg_ins;     // stable: analysis calls point in
+static std::map<ADDRINT, const DfgIns*> g_dfg_at;   // re-instrumented traces
+static std::vector<DfgNode>  g_dfg_nodes;
+
+static inline VOID DfgSources(ThreadState* st, const DfgIns* d, ADDRINT rea,
+                              UINT32* srcs, UINT32& n)
+{
+    auto add = [&](UINT32 t) {
+        if (t == NO_NODE) return;
+        for (UINT32 i = 0; i < n; ++i) if (srcs[i] == t) return;
+        if (n < 8) srcs[n++] = t;
+    };
+    for (REG r : d->src) add(st->dfg_regs[r]);
+    for (ADDRINT a = rea & ~ADDRINT(7); a < rea + d->rsize; a += 8) {
+        auto it = st->dfg_mem.find(a);
+        if (it != st->dfg_mem.end()) add(it->second);
+    }
+}
+
+static VOID DfgStep(THREADID tid, const DfgIns* d, ADDRINT rea, ADDRINT wea)
+{
+    ThreadState* st = St(tid);
+    if (st->dfg_regs.empty()) st->dfg_regs.assign(REG_LAST, NO_NODE);
+    UINT32 srcs[8], n = 0;
+    bool counting = d->in && Counting(tid);
+    if (counting) DfgSources(st, d, rea, srcs, n);
+
+    UINT32 out = n ? srcs[0] : NO_NODE;
+    if (counting && d->node != NO_NODE && (d->op || n == 0)) {
+        if (d->node >= st->dfg_execs.size()) st->dfg_execs.resize(d->node + 1);
+        st->dfg_execs[d->node]++;
+        if (d->op)
+            for (UINT32 i = 0; i < n; ++i)
+                st->dfg_edges[(UINT64(srcs[i]) << 32) | d->node]++;
+        out = d->node;
+    }
+    for (REG r : d->dst) st->dfg_regs[r] = out;
+    for (ADDRINT a = wea & ~ADDRINT(7); a < wea + d->wsize; a += 8) {
+        if (out == NO_NODE) st->dfg_mem.erase(a);
+        else                st->dfg_mem[a] = out;
+    }
+}
+
+static inline bool DfgDataReg(REG r)
+{
+    return REG_valid(r) && r != REG_RFLAGS && r != REG_INST_PTR && r != REG_STACK_PTR;
+}
+
+// ins's registers, memory operands and node, nullptr if it moves no data
+static const DfgIns* DescribeDfg(INS ins)
+{
+    DfgIns d{NO_NODE, false, !Filtering() || Counted(ins), {}, {}, 0, 0};
+    BlockIns bi;
+    ClassifyBlockIns(ins, bi);
+    for (UINT32 i = 0; i < INS_OperandCount(ins); ++i) {
+        if (INS_OperandIsReg(ins, i)) {
+            REG r = REG_FullRegName(INS_OperandReg(ins, i));
+            if (!DfgDataReg(r)) continue;
+            if (INS_OperandRead(ins, i)) d.src.push_back(r);
+            if (INS_OperandWritten(ins, i)) d.dst.push_back(r);
+        }
+    }
+    if (INS_IsStandardMemop(ins))   // not gathers and scatters
+        for (UINT32 m = 0; m < INS_MemoryOperandCount(ins); ++m) {
+            if (INS_MemoryOperandIsRead(ins, m))    d.rsize = INS_MemoryOperandSize(ins, m);
+            if (INS_MemoryOperandIsWritten(ins, m)) d.wsize = INS_MemoryOperandSize(ins, m);
+        }
+    // xor rax, rax and the like depend on nothing
+    if (INS_OperandCount(ins) >= 2 && INS_OperandIsReg(ins, 0) && INS_OperandIsReg(ins, 1) &&
+        INS_OperandReg(ins, 0) == INS_OperandReg(ins, 1) &&
+        (INS_Opcode(ins) == XED_ICLASS_XOR || INS_Opcode(ins) == XED_ICLASS_SUB ||
+         INS_Opcode(ins) == XED_ICLASS_PXOR || INS_Opcode(ins) == XED_ICLASS_VPXOR))
+        d.src.clear();
+
+    if (d.in && (bi.op_kind || (d.rsize && !d.wsize && !d.dst.empty() && INS_Opcode(ins) != XED_ICLASS_POP))) {
+        d.op = bi.op_kind != 0;
+        d.node = static_cast<UINT32>(g_dfg_nodes.size());
+        DfgNode nd{INS_Address(ins), FuncId(ins), {}, bi};
+        RTN rtn = INS_Rtn(ins);
+        nd.ins.offset = RTN_Valid(rtn) ? nd.addr - RTN_Address(rtn) : 0;
+        nd.ins.disasm = INS_Disassemble(ins);
+        PIN_GetSourceLocation(nd.addr, nullptr, &nd.line.line, &nd.line.file);
+        if (nd.line.file.empty()) nd.line.file = "??";
+        g_dfg_nodes.push_back(nd);
+    } else if (d.src.empty() && d.dst.empty() && !d.rsize && !d.wsize) {
+        return nullptr;
+    }
+    g_dfg_ins.push_back(d);
+    return &g_dfg_ins.back();
+}
+
+static VOID InstrumentDfg(INS ins, VOID*)
+{
+    if (INS_IsControlFlow(ins)) return;
+    const DfgIns* dp;
+    auto it = g_dfg_at.find(INS_Address(ins));
+    if (it != g_dfg_at.end()) {
+        dp = it->second;
+    } else {
+        dp = DescribeDfg(ins);
+        g_dfg_at[INS_Address(ins)] = dp;
+    }
+    if (!dp) return;
+
+    IARGLIST args = IARGLIST_Alloc();
+    if (dp->rsize) IARGLIST_AddArguments(args, IARG_MEMORYREAD_EA, IARG_END);
+    else           IARGLIST_AddArguments(args, IARG_ADDRINT, ADDRINT(0), IARG_END);
+    if (dp->wsize) IARGLIST_AddArguments(args, IARG_MEMORYWRITE_EA, IARG_END);
+    else           IARGLIST_AddArguments(args, IARG_ADDRINT, ADDRINT(0), IARG_END);
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)DfgStep, IARG_THREAD_ID,
+                   IARG_PTR, dp, IARG_IARGLIST, args, IARG_END);
+    IARGLIST_Free(args);
+}
+
+// ── dead work (-dead) ───────────────────────────────────────────────────────
+// -dead 1 follows every result of a counted op that lands in a general
+// register, the flags or memory until it is read or overwritten: one
+// overwritten everywhere it was held before anything read it was dead or
+// speculative work.  A plain 64-bit move, push or pop copies the value
+// without reading it, so a result spilled and never reloaded is dead
+// too; anything else reading it, a branch on its flags included, uses it.
+// Every instruction is followed, counted or not, since a result may be
+// read outside the scope or the filters; only counted ones in them make
+// results.  Partial register and memory-chunk writes, predicated writes
+// and system calls (all registers) count as reads, so doubt goes to the
+// live side, as does a result still held at exit.  Results in vector
+// registers are not followed, nor values read by another thread.
+static const UINT32 NO_VAL = ~0u;
+
+struct DeadIns {
+    UINT32            site;        // NO_SITE unless a counted op with results
+    bool              move, syscall;
+    REG               copy;        // a move's source register, else invalid
+    std::vector<REG>  src, dst;    // followed registers read and written
+    UINT32            rsize, wsize;  // memory read and written, bytes
+};
+
+struct DeadSiteInfo {
+    ADDRINT   addr;
+    UINT32    func;
+    LineInfo  line;
+    BlockIns  ins;                 // offset from the function start
+};
+
+static bool                       g_dead_on = false;
+static std::deque<DeadIns>        g_dead_ins;   // stable: analysis calls point in
+static std::map<ADDRINT, const DeadIns*> g_dead_at;
+static std::vector<DeadSiteInfo>  g_dead_sites;
+
+static inline VOID DeadUse(ThreadState* st, UINT32 v)
+{
+    if (v != NO_VAL) st->dead_vals[v].used = true;
+}
+
+// Drops one hold of v, which dies unread when it was the last
+static inline VOID DeadDrop(ThreadState* st, UINT32 v)
+{
+    if (v == NO_VAL) return;
+    DeadVal& d = st->dead_vals[v];
+    if (--d.refs) return;
+    if (!d.used) {
+        if (d.site >= st->dead_n.size()) st->dead_n.resize(d.site + 1);
+        st->dead_n[d.site]++;
+    }
+    st->dead_free.push_back(v);
+}
+
+static inline VOID DeadSet(ThreadState* st, UINT32& loc, UINT32 v)
+{
+    UINT32 old = loc;
+    if (old == v) return;
+    if (v != NO_VAL) st->dead_vals[v].refs++;
+    loc = v;
+    DeadDrop(st, old);
+}
+
+static VOID DeadStep(THREADID tid, const DeadIns* d, ADDRINT rea, ADDRINT wea)
+{
+    ThreadState* st = St(tid);
+    if (st->dead_regs.empty()) st->dead_regs.assign(REG_LAST, NO_VAL);
+    UINT32 v = NO_VAL;
+    for (REG r : d->src)            // a move's other registers form the address
+        if (r != d->copy) DeadUse(st, st->dead_regs[r]);
+    if (d->move && REG_valid(d->copy)) {
+        v = st->dead_regs[d->copy];
+    } else if (d->move && d->rsize == 8 && !(rea & 7)) {
+        auto it = st->dead_mem.find(rea);
+        if (it != st->dead_mem.end()) v = it->second;
+    } else {
+        for (ADDRINT a = rea & ~ADDRINT(7); a < rea + d->rsize; a += 8) {
+            auto it = st->dead_mem.find(a);
+            if (it != st->dead_mem.end()) DeadUse(st, it->second);
+        }
+        if (d->syscall)
+            for (UINT32 x : st->dead_regs) DeadUse(st, x);
+        if (d->site != NO_SITE && Counting(tid)) {
+            if (st->dead_free.empty()) {
+                v = static_cast<UINT32>(st->dead_vals.size());
+                st->dead_vals.push_back({});
+            } else {
+                v = st->dead_free.back();
+                st->dead_free.pop_back();
+            }
+            st->dead_vals[v] = {d->site, 0, false};
+            if (d->site >= st->dead_results.size()) st->dead_results.resize(d->site + 1);
+            st->dead_results[d->site]++;
+        }
+    }
+    if (v != NO_VAL) st->dead_vals[v].refs++;   // held until every location is set
+    for (REG r : d->dst) DeadSet(st, st->dead_regs[r], v);
+    for (ADDRINT a = wea & ~ADDRINT(7); a < wea + d->wsize; a += 8) {
+        auto it = st->dead_mem.find(a);
+        if (it == st->dead_mem.end()) {
+            if (v == NO_VAL) continue;
+            it = st->dead_mem.emplace(a, NO_VAL).first;
+        }
+        if (a < wea || a + 8 > wea + d->wsize) DeadUse(st, it->second);   // partly kept
+        DeadSet(st, it->second, v);
+        if (v == NO_VAL) st->dead_mem.erase(it);
+    }
+    DeadDrop(st, v);
+}
+
+// The followed register r names, REG_INVALID() for one not followed
+static inline REG DeadReg(REG r)
+{
+    if (r == REG_RFLAGS || REG_is_status_flags(r)) return REG_RFLAGS;
+    REG f = REG_FullRegName(r);
+    return REG_is_gr64(f) && f != REG_STACK_PTR ? f : REG_INVALID();
+}
+
+static const DeadIns* DescribeDead(INS ins)
+{
+    DeadIns d{NO_SITE, false, INS_IsSyscall(ins), REG_INVALID(), {}, {}, 0, 0};
+    const OPCODE opc = INS_Opcode(ins);
+    auto add = [](std::vector<REG>& v, REG r) {
+        if (std::find(v.begin(), v.end(), r) == v.end()) v.push_back(r);
+    };
+    for (UINT32 i = 0; i < INS_MaxNumRRegs(ins); ++i) {
+        REG r = DeadReg(INS_RegR(ins, i));
+        if (REG_valid(r)) add(d.src, r);
+    }
+    for (UINT32 i = 0; i < INS_MaxNumWRegs(ins); ++i) {
+        REG w = INS_RegW(ins, i), r = DeadReg(w);
+        if (!REG_valid(r)) continue;
+        add(d.dst, r);
+        if (REG_is_gr8(w) || REG_is_gr16(w) || INS_IsPredicated(ins)) add(d.src, r);
+    }
+    if (INS_IsStandardMemop(ins))   // not gathers and scatters
+        for (UINT32 m = 0; m < INS_MemoryOperandCount(ins); ++m) {
+            if (INS_MemoryOperandIsRead(ins, m))    d.rsize = INS_MemoryOperandSize(ins, m);
+            if (INS_MemoryOperandIsWritten(ins, m)) d.wsize = INS_MemoryOperandSize(ins, m);
+        }
+    // xor rax, rax and the like read nothing
+    if (INS_OperandCount(ins) >= 2 && INS_OperandIsReg(ins, 0) && INS_OperandIsReg(ins, 1) &&
+        INS_OperandReg(ins, 0) == INS_OperandReg(ins, 1) &&
+        (opc == XED_ICLASS_XOR || opc == XED_ICLASS_SUB))
+        d.src.clear();
+
+    // a 64-bit move copies the register or memory it reads
+    UINT32 from = opc == XED_ICLASS_MOV ? 1 : 0;
+    if ((opc == XED_ICLASS_MOV || opc == XED_ICLASS_PUSH || opc == XED_ICLASS_POP) &&
+        INS_OperandWidth(ins, 0) == 64 && INS_OperandCount(ins) > from &&
+        (INS_OperandIsReg(ins, from) || INS_OperandIsMemory(ins, from))) {
+        d.move = true;
+        if (opc != XED_ICLASS_POP && INS_OperandIsReg(ins, from))
+            d.copy = DeadReg(INS_OperandReg(ins, from));
+    }
+
+    BlockIns bi;
+    ClassifyBlockIns(ins, bi);
+    if ((bi.op_kind == 'i' || bi.op_kind == 'b') && (!Filtering() || Counted(ins)) &&
+        (!d.dst.empty() || d.wsize)) {
+        d.site = static_cast<UINT32>(g_dead_sites.size());
+        DeadSiteInfo si{INS_Address(ins), FuncId(ins), {}, bi};
+        RTN rtn = INS_Rtn(ins);
+        si.ins.offset = RTN_Valid(rtn) ? si.addr - RTN_Address(rtn) : 0;
+        si.ins.disasm = INS_Disassemble(ins);
+        PIN_GetSourceLocation(si.addr, nullptr, &si.line.line, &si.line.file);
+        if (si.line.file.empty()) si.line.file = "??";
+        g_dead_sites.push_back(si);
+    } else if (d.src.empty() && d.dst.empty() && !d.rsize && !d.wsize && !d.syscall) {
+        return nullptr;
+    }
+    g_dead_ins.push_back(d);
+    return &g_dead_ins.back();
+}
+
+static VOID InstrumentDead(INS ins, VOID*)
+{
+    const DeadIns* dp;
+    auto it = g_dead_at.find(INS_Address(ins));
+    if (it != g_dead_at.end()) {
+        dp = it->second;
+    } else {
+        dp = DescribeDead(ins);
+        g_dead_at[INS_Address(ins)] = dp;
+    }
+    if (!dp) return;
+
+    IARGLIST args = IARGLIST_Alloc();
+    if (dp->rsize) IARGLIST_AddArguments(args, IARG_MEMORYREAD_EA, IARG_END);
+    else           IARGLIST_AddArguments(args, IARG_ADDRINT, ADDRINT(0), IARG_END);
+    if (dp->wsize) IARGLIST_AddArguments(args, IARG_MEMORYWRITE_EA, IARG_END);
+    else           IARGLIST_AddArguments(args, IARG_ADDRINT, ADDRINT(0), IARG_END);
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)DeadStep, IARG_THREAD_ID,
+                   IARG_PTR, dp, IARG_IARGLIST, args, IARG_END);
+    IARGLIST_Free(args);
+}
+
+// ── instrumentation – repeated computations (-reuse N) ──────────────────────
+// Every counted add..div and bitwise op in a loop is a reuse site.  Each
+// execution reads the op's sources: its register, memory and immediate
+// operands, implicit ones such as the RAX and RDX of MUL, DIV and MULX
+// included, and the carry ADC, SBB, ADCX, RCL and RCR take or the
+// overflow ADOX takes.  The opcode and operands are looked up in the
+// thread's reuse buffer, a direct-mapped table of REUSE_ENTRIES recent
+// computations as a hardware result-reuse cache would keep: a hit could
+// have been memoized.  One with the same operands as the site's previous
+// execution is loop-invariant work besides.  Commutative ops put their
+// two operands in order, so a+b and b+a are the same computation.
+static const UINT32 REUSE_BITS = 12;
+static const UINT32 REUSE_ENTRIES = 1u << REUSE_BITS;
+
+struct ReuseSiteInfo {
+    UINT32    loop, func;
+    LineInfo  line;
+    BlockIns  ins;                 // offset from the function start
+    UINT32    opc;
+    bool      commute;
+    REG       reg[3];              // the operands: a register read,
+    ADDRINT   imm[3];              // else this value
+    UINT64    mask[3];             // of the value kept, 0 for no operand
+    INT32     mem;                 // the operand read from memory, -1 for none
+    UINT32    size;                // its bytes
+};
+
+static UINT64                     g_reuse = 0;   // -reuse N, 0 = off
+static std::vector<ReuseSiteInfo> g_reuse_sites;
+static std::map<ADDRINT, UINT32>  g_reuse_ids;   // instruction → site
+
+static VOID PIN_FAST_ANALYSIS_CALL ReuseSeen(THREADID tid, UINT32 sid, ADDRINT a, ADDRINT b, ADDRINT c,
+                                             ADDRINT ea)
+{
+    if (!Counting(tid)) return;
+    ThreadState* st = St(tid);
+    const ReuseSiteInfo& si = g_reuse_sites[sid];
+    UINT64 v[3] = {a, b, c};
+    if (si.mem >= 0) {
+        v[si.mem] = 0;
+        if (PIN_SafeCopy(&v[si.mem], reinterpret_cast<VOID*>(ea), si.size) != si.size) return;
+    }
+    for (int i = 0; i < 3; ++i) v[i] &= si.mask[i];
+    if (si.commute && v[0] > v[1]) std::swap(v[0], v[1]);
+
+    if (sid >= st->reuse.size()) st->reuse.resize(sid + 1);
+    ReuseStats& s = st->reuse[sid];
+    if (s.n++ && std::equal(v, v + 3, s.last)) s.same++;
+    std::copy(v, v + 3, s.last);
+
+    if (st->reuse_buf.empty()) st->reuse_buf.resize(REUSE_ENTRIES);
+    UINT64 h = (si.opc * 0x9E3779B97F4A7C15ull) ^ v[0];
+    h = (h * 0xBF58476D1CE4E5B9ull) ^ v[1];
+    h = (h * 0x94D049BB133111EBull) ^ v[2];
+    ReuseEntry& e = st->reuse_buf[(h * 0x9E3779B97F4A7C15ull) >> (64 - REUSE_BITS)];
+    if (e.opc == si.opc && std::equal(v, v + 3, e.v)) {
+        s.hits++;
+        return;
+    }
+    e.opc = si.opc;
+    std::copy(v, v + 3, e.v);
+}
+
+// The reuse site of ins, false when it is no counted loop op with up to
+// three general-register, memory or immediate sources
+static bool DescribeReuse(INS ins, UINT32 loop, ReuseSiteInfo& si)
+{
+    BlockIns bi;
+    ClassifyBlockIns(ins, bi);
+    if (bi.op_kind != 'i' && bi.op_kind != 'b') return false;
+    const OPCODE opc = INS_Opcode(ins);
+    si = ReuseSiteInfo{loop, FuncId(ins), {}, bi, opc, false, {}, {}, {}, -1, 0};
+    UINT32 n = 0;
+    bool ok = true;
+    auto add = [&](REG r, ADDRINT v, UINT64 mask) {
+        if (n == 3) {
+            ok = false;
+            return;
+        }
+        si.reg[n] = r;
+        si.imm[n] = v;
+        si.mask[n++] = mask;
+    };
+    for (UINT32 i = 0; i < 3; ++i) si.reg[i] = REG_INVALID();
+    for (UINT32 i = 0; i < INS_OperandCount(ins); ++i) {
+        if (!INS_OperandRead(ins, i)) continue;
+        if (INS_OperandIsReg(ins, i)) {
+            REG r = INS_OperandReg(ins, i), f = REG_FullRegName(r);
+            if (REG_is_gr64(f) && f != REG_STACK_PTR) add(r, 0, ~0ull);
+            else if (!INS_OperandIsImplicit(ins, i)) return false;
+        } else if (INS_OperandIsImplicit(ins, i)) {
+            continue;                       // the 1 of a shift by one
+        } else if (INS_OperandIsMemory(ins, i)) {
+            si.mem = static_cast<INT32>(n);
+            si.size = std::min<UINT32>(INS_MemoryOperandSize(ins, 0), 8);
+            add(REG_INVALID(), 0, ~0ull);
+        } else if (INS_OperandIsImmediate(ins, i)) {
+            add(REG_INVALID(), static_cast<ADDRINT>(INS_OperandImmediate(ins, i)), ~0ull);
+        } else {
+            return false;                // LEA's address, for one
+        }
+    }
+    switch (opc) {
+        case XED_ICLASS_ADC: case XED_ICLASS_SBB: case XED_ICLASS_ADCX:
+        case XED_ICLASS_RCL: case XED_ICLASS_RCR:
+            add(REG_RFLAGS, 0, 1);           // CF
+            break;
+        case XED_ICLASS_ADOX:
+            add(REG_RFLAGS, 0, 1u << 11);    // OF
+            break;
+        default:
+            break;
+    }
+    if (!ok || n == 0) return false;
+    switch (opc) {
+        case XED_ICLASS_ADD: case XED_ICLASS_ADC: case XED_ICLASS_ADCX: case XED_ICLASS_ADOX:
+        case XED_ICLASS_MUL: case XED_ICLASS_IMUL: case XED_ICLASS_MULX:
+        case XED_ICLASS_AND: case XED_ICLASS_OR: case XED_ICLASS_XOR:
+            si.commute = n >= 2;
+            break;
+        default:
+            break;
+    }
+    RTN rtn = INS_Rtn(ins);
+    si.ins.offset = RTN_Valid(rtn) ? INS_Address(ins) - RTN_Address(rtn) : 0;
+    si.ins.disasm = INS_Disassemble(ins);
+    PIN_GetSourceLocation(INS_Address(ins), nullptr, &si.line.line, &si.line.file);
+    if (si.line.file.empty()) si.line.file = "??";
+    return true;
+}
+
+static VOID InstrumentReuse(INS ins, VOID*)
+{
+    auto at = g_loop_at.find(INS_Address(ins));
+    if (at == g_loop_at.end() || (Filtering() && !Counted(ins))) return;
+    UINT32 sid;
+    auto it = g_reuse_ids.find(INS_Address(ins));
+    if (it != g_reuse_ids.end()) {
+        sid = it->second;
+    } else {
+        ReuseSiteInfo si;
+        if (!DescribeReuse(ins, at->second, si)) sid = NO_SITE;
+        else {
+            sid = static_cast<UINT32>(g_reuse_sites.size());
+            g_reuse_sites.push_back(si);
+        }
+        g_reuse_ids[INS_Address(ins)] = sid;
+    }
+    if (sid == NO_SITE) return;
+
+    const ReuseSiteInfo& si = g_reuse_sites[sid];
+    IARGLIST args = IARGLIST_Alloc();
+    IARGLIST_AddArguments(args, IARG_UINT32, sid, IARG_END);
+    for (UINT32 i = 0; i < 3; ++i) {
+        if (REG_valid(si.reg[i])) IARGLIST_AddArguments(args, IARG_REG_VALUE, si.reg[i], IARG_END);
+        else                      IARGLIST_AddArguments(args, IARG_ADDRINT, si.imm[i], IARG_END);
+    }
+    if (si.mem >= 0) IARGLIST_AddArguments(args, IARG_MEMORYREAD_EA, IARG_END);
+    else             IARGLIST_AddArguments(args, IARG_ADDRINT, ADDRINT(0), IARG_END);
+    InsertCounter(ins, (AFUNPTR)ReuseSeen, args);
+}
+
+// ── instrumentation for marker functions (MARKER mode) ──────────────────────
+static VOID InstrumentMarkerRtn(RTN rtn, VOID*)
+{
+    if (g_mode != MARKER) return;
+    
+    std::string name = RTN_Name(rtn);
+    
+    if (name == g_start_marker) {
+        DBG(1, "Found start marker: " << name);
+        RTN_Open(rtn);
         RTN_InsertCall(rtn, IPOINT_AFTER, (AFUNPTR)StartRegion,
                       IARG_THREAD_ID, IARG_END);
         RTN_Close(rtn);
     }
-    
-    if (name == g_stop_marker) {
-        DBG(1, "Found stop marker: " << name);
-        RTN_Open(rtn);
-        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)StopRegion,
-                      IARG_THREAD_ID, IARG_END);
-        RTN_Close(rtn);
+    
+    if (name == g_stop_marker) {
+        DBG(1, "Found stop marker: " << name);
+        RTN_Open(rtn);
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)StopRegion,
+                      IARG_THREAD_ID, IARG_END);
+        RTN_Close(rtn);
+    }
+}
+
+// ── instrumentation for address-based regions (ADDRESS mode) ────────────────
+static VOID InstrumentAddressRegion(INS ins, VOID*)
+{
+    if (g_mode != ADDRESS) return;
+    
+    // Start at exact address
+    if (INS_Address(ins) == g_start_addr + g_load_offset) {
+        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)StartRegion,
+                       IARG_THREAD_ID, IARG_END);
+    }
+
+    // Stop at first RET while active
+    if (INS_IsRet(ins)) {
+        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)StopRegion,
+                       IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_END);
+    }
+}
+
+// ── client-API regions (REGIONS mode) ──────────────────────────────────────
+// The markers are empty functions found by symbol name:
+//   C / ctypes   Int64ProfilerStart(const char*), Int64ProfilerStop(const char*)
+//   Go           github.com/abe5240/iccad/client/roi.begin / .end, whose
+//                string argument arrives in RAX (data) and RBX (length)
+// A region's counts are the difference between entry and exit, so nested
+// regions are inclusive.  Names are interned under g_lock.
+static const char* const C_START  = "Int64ProfilerStart";
+static const char* const C_STOP   = "Int64ProfilerStop";
+static const char* const GO_START = "github.com/abe5240/iccad/client/roi.begin";
+static const char* const GO_STOP  = "github.com/abe5240/iccad/client/roi.end";
+
+static std::vector<std::string>        g_region_names;
+static std::map<std::string, UINT32>   g_region_ids;
+
+// len == 0 → NUL-terminated; NULL or empty names map to "default"
+static std::string ReadName(ADDRINT p, size_t len)
+{
+    if (!p) return "default";
+    char buf[256];
+    size_t want = len ? std::min(len, sizeof buf) : sizeof buf;
+    size_t n = PIN_SafeCopy(buf, reinterpret_cast<VOID*>(p), want);
+    std::string s(buf, n);
+    if (!len) s = s.substr(0, s.find('\0'));
+    return s.empty() ? "default" : s;
+}
+
+static UINT32 RegionId(THREADID tid, const std::string& name)
+{
+    PIN_GetLock(&g_lock, tid + 1);
+    auto it = g_region_ids.find(name);
+    UINT32 id;
+    if (it != g_region_ids.end()) {
+        id = it->second;
+    } else {
+        id = static_cast<UINT32>(g_region_names.size());
+        g_region_names.push_back(name);
+        g_region_ids[name] = id;
+        if (g_trace == TRACE_OPS) g_trace_out << "region " << id << ' ' << name << '\n';
+    }
+    PIN_ReleaseLock(&g_lock);
+    return id;
+}
+
+static volatile bool g_budgets_on = false;                // a budget was declared
+static VOID CheckBudgets(UINT32 id, const Cnts& c);      // defined with the report code
+
+static VOID CloseRegion(ThreadState* st)
+{
+    ThreadState::Open o = st->open.back();
+    st->open.pop_back();
+    if (o.id >= st->regions.size()) {
+        st->regions.resize(o.id + 1);
+        st->entries.resize(o.id + 1);
+    }
+    UINT64* dst = Words(st->regions[o.id]);
+    const UINT64 *now = Words(st->cnts), *then = Words(o.at);
+    for (size_t i = 0; i < NumWords(o.at); ++i) dst[i] += now[i] - then[i];
+    st->entries[o.id]++;
+    st->active = !st->open.empty();
+    if (g_budgets_on) {
+        Cnts c = st->cnts;
+        UINT64* d = Words(c);
+        for (size_t i = 0; i < NumWords(c); ++i) d[i] -= then[i];
+        CheckBudgets(o.id, c);
+    }
+}
+
+static VOID EnterRegion(THREADID tid, const std::string& name)
+{
+    ThreadState* st = St(tid);
+    st->open.push_back({RegionId(tid, name), st->cnts});
+    st->active = true;
+    DBG(2, "Enter region " << name << " (tid=" << tid << ")");
+}
+
+// Closes the innermost open region called name; unmatched stops are ignored
+static VOID LeaveRegion(THREADID tid, const std::string& name)
+{
+    ThreadState* st = St(tid);
+    UINT32 id = RegionId(tid, name);
+    for (size_t i = st->open.size(); i-- > 0;) {
+        if (st->open[i].id != id) continue;
+        while (st->open.size() > i) CloseRegion(st);
+        DBG(2, "Leave region " << name << " (tid=" << tid << ")");
+        return;
+    }
+}
+
+static VOID CStart(THREADID tid, ADDRINT p)  { EnterRegion(tid, ReadName(p, 0)); }
+static VOID CStop(THREADID tid, ADDRINT p)   { LeaveRegion(tid, ReadName(p, 0)); }
+static VOID GoStart(THREADID tid, ADDRINT p, ADDRINT n)
+{
+    EnterRegion(tid, n ? ReadName(p, n) : "default");
+}
+static VOID GoStop(THREADID tid, ADDRINT p, ADDRINT n)
+{
+    LeaveRegion(tid, n ? ReadName(p, n) : "default");
+}
+
+static VOID InstrumentRegionRtn(RTN rtn, VOID*)
+{
+    const std::string& name = RTN_Name(rtn);
+    bool c = name == C_START || name == C_STOP;
+    bool go = name == GO_START || name == GO_STOP;
+    if (!c && !go) return;
+
+    DBG(1, "Found region marker: " << name);
+    RTN_Open(rtn);
+    if (c)
+        RTN_InsertCall(rtn, IPOINT_BEFORE,
+                       (AFUNPTR)(name == C_START ? CStart : CStop),
+                       IARG_THREAD_ID, IARG_FUNCARG_ENTRYPOINT_VALUE, 0, IARG_END);
+    else
+        RTN_InsertCall(rtn, IPOINT_BEFORE,
+                       (AFUNPTR)(name == GO_START ? GoStart : GoStop),
+                       IARG_THREAD_ID, IARG_REG_VALUE, REG_RAX,
+                       IARG_REG_VALUE, REG_RBX, IARG_END);
+    RTN_Close(rtn);
+}
+
+// ── Python functions (-python) ──────────────────────────────────────────────
+// The client module's profile hook (int64profiler.functions()) calls
+//   Int64ProfilerPyEnter(const char* func, const char* file, int line)
+//   Int64ProfilerPyLeave(void)
+// on every Python call and return, generator resumes and yields included,
+// and Int64ProfilerPyDone(void) once it is removed, which drops the frames.
+// The counts between two of them are the innermost frame's own, so C code
+// a Python function calls is charged to it.  The hook itself runs inside
+// CPython's profile_trampoline, which is not counted when its symbol is
+// there.  The hook passes the same buffers for a function every time, so
+// each thread reads a name once per (func, file, line) pointers.
+static const char* const PY_ENTER = "Int64ProfilerPyEnter";
+static const char* const PY_LEAVE = "Int64ProfilerPyLeave";
+static const char* const PY_DONE  = "Int64ProfilerPyDone";
+static const char* const PY_HOOK  = "profile_trampoline";
+
+struct PyFunc {
+    std::string name, file;
+    UINT32      line;
+};
+static std::vector<PyFunc> g_py_funcs;   // under g_lock
+static std::map<std::tuple<std::string, std::string, UINT32>, UINT32> g_py_ids;
+static bool g_py_hooked = false;         // profile_trampoline was found
+
+static UINT32 PyFuncId(THREADID tid, ADDRINT func, ADDRINT file, ADDRINT line)
+{
+    ThreadState* st = St(tid);
+    auto key = std::make_tuple(func, file, line);
+    auto it = st->py_ids.find(key);
+    if (it != st->py_ids.end()) return it->second;
+
+    PyFunc f{ReadName(func, 0), ReadName(file, 0), UINT32(line)};
+    PIN_GetLock(&g_lock, tid + 1);
+    auto name = std::make_tuple(f.name, f.file, f.line);
+    auto g = g_py_ids.find(name);
+    UINT32 id;
+    if (g != g_py_ids.end()) {
+        id = g->second;
+    } else {
+        id = static_cast<UINT32>(g_py_funcs.size());
+        g_py_funcs.push_back(f);
+        g_py_ids[name] = id;
+    }
+    PIN_ReleaseLock(&g_lock);
+    return st->py_ids[key] = id;
+}
+
+// Charges the counts since the last call or return to the innermost frame
+static VOID PyCharge(ThreadState* st)
+{
+    if (!st->py_stack.empty()) {
+        UINT32 id = st->py_stack.back();
+        if (id >= st->py_self.size()) {
+            st->py_self.resize(id + 1);
+            st->py_calls.resize(id + 1);
+        }
+        UINT64* dst = Words(st->py_self[id]);
+        const UINT64 *now = Words(st->cnts), *then = Words(st->py_mark);
+        for (size_t i = 0; i < NumWords(st->cnts); ++i) dst[i] += now[i] - then[i];
+    }
+    st->py_mark = st->cnts;
+}
+
+static VOID PyEnter(THREADID tid, ADDRINT func, ADDRINT file, ADDRINT line)
+{
+    UINT32 id = PyFuncId(tid, func, file, line);
+    ThreadState* st = St(tid);
+    PyCharge(st);
+    st->py_stack.push_back(id);
+    if (id >= st->py_calls.size()) {
+        st->py_self.resize(id + 1);
+        st->py_calls.resize(id + 1);
+    }
+    st->py_calls[id]++;
+}
+
+// A return with no frame left (the hook was installed mid-call) is ignored
+static VOID PyLeave(THREADID tid)
+{
+    ThreadState* st = St(tid);
+    PyCharge(st);
+    if (!st->py_stack.empty()) st->py_stack.pop_back();
+}
+
+static VOID PyDone(THREADID tid)
+{
+    ThreadState* st = St(tid);
+    PyCharge(st);
+    st->py_stack.clear();
+}
+
+static VOID PyHookIn(THREADID tid)  { St(tid)->py_hook++; }
+static VOID PyHookOut(THREADID tid)
+{
+    ThreadState* st = St(tid);
+    if (st->py_hook) st->py_hook--;
+}
+
+static VOID InstrumentPythonRtn(RTN rtn, VOID*)
+{
+    const std::string& name = RTN_Name(rtn);
+    if (name != PY_ENTER && name != PY_LEAVE && name != PY_DONE && name != PY_HOOK) return;
+
+    RTN_Open(rtn);
+    if (name == PY_ENTER) {
+        DBG(1, "Found Python marker: " << name);
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)PyEnter, IARG_THREAD_ID,
+                       IARG_FUNCARG_ENTRYPOINT_VALUE, 0, IARG_FUNCARG_ENTRYPOINT_VALUE, 1,
+                       IARG_FUNCARG_ENTRYPOINT_VALUE, 2, IARG_END);
+    } else if (name == PY_LEAVE) {
+        DBG(1, "Found Python marker: " << name);
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)PyLeave, IARG_THREAD_ID, IARG_END);
+    } else if (name == PY_DONE) {
+        DBG(1, "Found Python marker: " << name);
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)PyDone, IARG_THREAD_ID, IARG_END);
+    } else {
+        DBG(1, "Found CPython profile hook in " << IMG_Name(SEC_Img(RTN_Sec(rtn))));
+        g_py_hooked = true;
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)PyHookIn, IARG_THREAD_ID, IARG_END);
+        RTN_InsertCall(rtn, IPOINT_AFTER, (AFUNPTR)PyHookOut, IARG_THREAD_ID, IARG_END);
+    }
+    RTN_Close(rtn);
+}
+
+// ── phases (-phases) ───────────────────────────────────────────────────────
+// A phase starts from the counts of every thread at that moment and ends
+// where the next one starts, or at the report.  Marker phases come from
+//   C / ctypes   Int64ProfilerPhase(const char*)
+//   Go           github.com/abe5240/iccad/client/roi.phase (RAX, RBX)
+// and before the first marker the run is in phase "default".
+static const char* const C_PHASE  = "Int64ProfilerPhase";
+static const char* const GO_PHASE = "github.com/abe5240/iccad/client/roi.phase";
+
+struct PhaseMark {
+    std::string name;
+    double      start;   // seconds into the run
+    Cnts        at;      // counts of every thread at the start
+};
+static std::vector<PhaseMark> g_phases;   // under g_lock, in start order
+static const char* g_phase_mode = nullptr;
+
+static VOID MarkPhase(THREADID tid, const std::string& name)
+{
+    double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+    PIN_GetLock(&g_lock, tid + 1);
+    Cnts c{};
+    UINT64* dst = Words(c);
+    for (auto* st : g_all) {
+        const UINT64* src = Words(st->cnts);
+        for (size_t i = 0; i < NumWords(c); ++i) dst[i] += src[i];
+    }
+    g_phases.push_back({name, now, c});
+    g_phase_cur = INT32(g_phases.size() - 1);
+    PIN_ReleaseLock(&g_lock);
+    DBG(2, "Phase " << name << " at " << now << " s");
+}
+
+static VOID CPhase(THREADID tid, ADDRINT p) { MarkPhase(tid, ReadName(p, 0)); }
+static VOID GoPhase(THREADID tid, ADDRINT p, ADDRINT n)
+{
+    MarkPhase(tid, n ? ReadName(p, n) : "default");
+}
+
+static VOID InstrumentPhaseRtn(RTN rtn, VOID*)
+{
+    const std::string& name = RTN_Name(rtn);
+    if (name != C_PHASE && name != GO_PHASE) return;
+
+    DBG(1, "Found phase marker: " << name);
+    RTN_Open(rtn);
+    if (name == C_PHASE)
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)CPhase,
+                       IARG_THREAD_ID, IARG_FUNCARG_ENTRYPOINT_VALUE, 0, IARG_END);
+    else
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)GoPhase,
+                       IARG_THREAD_ID, IARG_REG_VALUE, REG_RAX,
+                       IARG_REG_VALUE, REG_RBX, IARG_END);
+    RTN_Close(rtn);
+}
+
+// ── calling contexts (-callgraph) ───────────────────────────────────────────
+// Each thread keeps a shadow stack of the functions it has entered, keyed by
+// the stack pointer at entry (the address of the return address).  Entering
+// a function first drops frames at or below the current stack pointer, so a
+// tail call replaces its caller; a RET drops its own frame and anything a
+// longjmp or exception skipped.  Counts go to the context of the top frame.
+// Code reached without passing a function entry (no symbols) is charged to
+// the caller's context.
+static const UINT32 NO_FUNC = ~0u;
+
+static inline VOID PopFrames(ThreadState* st, ADDRINT sp)
+{
+    while (!st->frames.empty() && st->frames.back().sp <= sp) st->frames.pop_back();
+    st->node = st->frames.empty() ? 0 : st->frames.back().node;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL EnterFunc(THREADID tid, UINT32 func, ADDRINT sp)
+{
+    ThreadState* st = St(tid);
+    PopFrames(st, sp);
+    auto key = std::make_pair(st->node, func);
+    auto it = st->children.find(key);
+    UINT32 node;
+    if (it != st->children.end()) {
+        node = it->second;
+    } else {
+        node = static_cast<UINT32>(st->nodes.size());
+        st->nodes.push_back({func, st->node, Cnts{}});
+        st->children[key] = node;
+    }
+    st->frames.push_back({sp, node});
+    st->node = node;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL LeaveFunc(THREADID tid, ADDRINT sp)
+{
+    PopFrames(St(tid), sp);
+}
+
+static VOID InstrumentCallRtn(RTN rtn, VOID*)
+{
+    UINT32 func = FuncId(rtn);
+    RTN_Open(rtn);
+    RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)EnterFunc, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_UINT32, func,
+                   IARG_REG_VALUE, REG_STACK_PTR, IARG_END);
+    RTN_Close(rtn);
+}
+
+static VOID InstrumentRet(INS ins, VOID*)
+{
+    if (!INS_IsRet(ins)) return;
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)LeaveFunc, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_REG_VALUE, REG_STACK_PTR, IARG_END);
+}
+
+// ── syscall trace and time ──────────────────────────────────────────────────
+// Lines are written at syscall exit, so threads interleave by completion;
+// calls that never return (exit, a successful execve) are written with
+// "-" when their thread ends.
+static std::ofstream g_sys_out;
+static bool          g_sys_on = false;
+static bool          g_systime_on = false;   // -systime 1
+
+static inline UINT64 SteadyNs()
+{
+    return std::chrono::duration_cast<std::chrono::nanoseconds>(
+               std::chrono::steady_clock::now().time_since_epoch()).count();
+}
+
+// SysTimeEnd ends st's call in progress; ok is false if it failed, and a
+// call that never returned (exit, a successful execve) counts without time
+static VOID SysTimeEnd(ThreadState* st, bool ok, bool returned)
+{
+    if (st->sys_nr < 0) return;
+    SysTime& t = st->sys[st->sys_nr];
+    t.calls++;
+    if (!ok) t.errors++;
+    if (returned) {
+        UINT64 ns = SteadyNs() - st->sys_t0;
+        t.ns += ns;
+        t.max_ns = std::max(t.max_ns, ns);
+    }
+    st->sys_nr = -1;
+}
+
+static VOID SyscallLine(const ThreadState* st, const std::string& ret)
+{
+    PIN_GetLock(&g_lock, st->tid + 1);
+    g_sys_out << st->tid << ' ' << st->syscall << ' ' << ret << '\n';
+    PIN_ReleaseLock(&g_lock);
+}
+
+static VOID SyscallEntry(THREADID tid, CONTEXT* ctxt, SYSCALL_STANDARD std, VOID*)
+{
+    ThreadState* st = St(tid);
+    if (g_systime_on) {
+        SysTimeEnd(st, true, false);
+        st->sys_nr = static_cast<INT64>(PIN_GetSyscallNumber(ctxt, std));
+        st->sys_t0 = SteadyNs();
+    }
+    if (!g_sys_on) return;
+    if (st->syscall >= 0) SyscallLine(st, "-");
+    st->syscall = static_cast<INT64>(PIN_GetSyscallNumber(ctxt, std));
+}
+
+static VOID SyscallExit(THREADID tid, CONTEXT* ctxt, SYSCALL_STANDARD std, VOID*)
+{
+    ThreadState* st = St(tid);
+    if (g_systime_on) SysTimeEnd(st, PIN_GetSyscallErrno(ctxt, std) == 0, true);
+    if (!g_sys_on || st->syscall < 0) return;
+    SyscallLine(st, std::to_string(static_cast<INT64>(PIN_GetSyscallReturn(ctxt, std))));
+    st->syscall = -1;
+}
+
+// Ends the trace: the calls still in progress, then the file
+static VOID SyscallsDone()
+{
+    if (!g_sys_on) return;
+    for (auto* st : g_all)
+        if (st->syscall >= 0) {
+            SyscallLine(st, "-");
+            st->syscall = -1;
+        }
+    g_sys_out.close();
+    g_sys_on = false;
+}
+
+// The child of a fork must not write the parent's buffered lines again
+static VOID SyscallsFork(THREADID, const CONTEXT*, VOID*)
+{
+    if (g_sys_on) g_sys_out.flush();
+}
+
+// ── instruction trace (-trace) ──────────────────────────────────────────────
+// What the run counts, for simulators to replay.  -trace_format ops writes
+// a "tid pc op region" line per op counted in the four categories, those
+// of -ops and the -class ones: pc in hex, region the id of the innermost
+// client-API region, declared by a "region id name" line before its first
+// use, or "-" outside one.  champsim writes each instruction the main
+// thread executes while counting as a ChampSim input_instr record, with
+// Pin's register numbers, as ChampSim's own Pin tracer does.  Threads
+// buffer their records and write them under g_lock.
+struct ChampSimInstr {
+    UINT64 ip;
+    UINT8  is_branch, branch_taken;
+    UINT8  dst_regs[2], src_regs[4];
+    UINT64 dst_mem[2], src_mem[4];
+};
+static_assert(sizeof(ChampSimInstr) == 64, "ChampSim records are 64 bytes");
+
+static ChampSimInstr g_cs;             // the main thread's instruction
+static bool          g_cs_on = false;  // … is being traced
+static const size_t  TRACE_BUFFER = 1 << 20;
+
+static VOID TraceFlush(ThreadState* st)
+{
+    if (st->trace.empty()) return;
+    PIN_GetLock(&g_lock, st->tid + 1);
+    g_trace_out.write(st->trace.data(), st->trace.size());
+    PIN_ReleaseLock(&g_lock);
+    st->trace.clear();
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL TraceOp(THREADID tid, ADDRINT pc, const char* op)
+{
+    if (g_trace != TRACE_OPS || !Counting(tid)) return;
+    ThreadState* st = St(tid);
+    char hex[16];
+    int n = 0;
+    do hex[n++] = "0123456789abcdef"[pc & 15]; while (pc >>= 4);
+    st->trace += std::to_string(tid);
+    st->trace += " 0x";
+    while (n) st->trace += hex[--n];
+    st->trace += ' ';
+    st->trace += op;
+    st->trace += ' ';
+    st->trace += st->open.empty() ? std::string("-") : std::to_string(st->open.back().id);
+    st->trace += '\n';
+    if (st->trace.size() >= TRACE_BUFFER) TraceFlush(st);
+}
+
+static VOID InsertTraceOp(INS ins, const char* op)
+{
+    if (g_trace != TRACE_OPS || (Filtering() && !Counted(ins))) return;
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)TraceOp, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_INST_PTR, IARG_PTR, op, IARG_END);
+}
+
+// regs packs the destination registers in its low bytes, the sources above
+static VOID PIN_FAST_ANALYSIS_CALL ChampSimBegin(THREADID tid, ADDRINT ip, UINT64 regs,
+                                                 BOOL branch)
+{
+    g_cs_on = tid == 0 && g_trace == TRACE_CHAMPSIM && Counting(tid);
+    if (!g_cs_on) return;
+    g_cs = ChampSimInstr{};
+    g_cs.ip = ip;
+    g_cs.is_branch = branch;
+    for (int i = 0; i < 2; ++i) g_cs.dst_regs[i] = UINT8(regs >> (8 * i));
+    for (int i = 0; i < 4; ++i) g_cs.src_regs[i] = UINT8(regs >> (16 + 8 * i));
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL ChampSimMem(THREADID tid, ADDRINT ea, BOOL write)
+{
+    if (tid != 0 || !g_cs_on) return;
+    UINT64* mem = write ? g_cs.dst_mem : g_cs.src_mem;
+    const int n = write ? 2 : 4;
+    for (int i = 0; i < n; ++i) {
+        if (mem[i] == ea) return;
+        if (!mem[i]) {
+            mem[i] = ea;
+            return;
+        }
+    }
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL ChampSimTaken(THREADID tid, BOOL taken)
+{
+    if (tid == 0 && g_cs_on) g_cs.branch_taken = taken;
+}
+
+static VOID PIN_FAST_ANALYSIS_CALL ChampSimEnd(THREADID tid)
+{
+    if (tid != 0 || !g_cs_on) return;
+    ThreadState* st = St(tid);
+    st->trace.append(reinterpret_cast<const char*>(&g_cs), sizeof g_cs);
+    if (st->trace.size() >= TRACE_BUFFER) TraceFlush(st);
+}
+
+static VOID InstrumentChampSim(INS ins, VOID*)
+{
+    if (Filtering() && !Counted(ins)) return;
+    UINT64 regs = 0;
+    int dst = 0, src = 0;
+    auto add = [&regs](int& n, int max, int shift, REG r) {
+        if (!REG_valid(r) || n == max) return;
+        for (int i = 0; i < n; ++i)
+            if (UINT8(regs >> (shift + 8 * i)) == UINT8(r)) return;
+        regs |= UINT64(UINT8(r)) << (shift + 8 * n++);
+    };
+    for (UINT32 i = 0; i < INS_MaxNumWRegs(ins); ++i) add(dst, 2, 0, INS_RegW(ins, i));
+    for (UINT32 i = 0; i < INS_MaxNumRRegs(ins); ++i) add(src, 4, 16, INS_RegR(ins, i));
+
+    const BOOL branch = INS_IsBranch(ins);
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)ChampSimBegin, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_INST_PTR, IARG_UINT64, regs, IARG_BOOL, branch, IARG_END);
+    for (UINT32 i = 0; i < INS_MemoryOperandCount(ins); ++i) {
+        if (INS_MemoryOperandIsRead(ins, i))
+            INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)ChampSimMem, IARG_FAST_ANALYSIS_CALL,
+                           IARG_THREAD_ID, IARG_MEMORYOP_EA, i, IARG_BOOL, FALSE, IARG_END);
+        if (INS_MemoryOperandIsWritten(ins, i))
+            INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)ChampSimMem, IARG_FAST_ANALYSIS_CALL,
+                           IARG_THREAD_ID, IARG_MEMORYOP_EA, i, IARG_BOOL, TRUE, IARG_END);
+    }
+    if (branch)
+        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)ChampSimTaken, IARG_FAST_ANALYSIS_CALL,
+                       IARG_THREAD_ID, IARG_BRANCH_TAKEN, IARG_END);
+    INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)ChampSimEnd, IARG_FAST_ANALYSIS_CALL,
+                   IARG_THREAD_ID, IARG_END);
+}
+
+// Ends the trace: every thread's buffered records, then the file
+static VOID TraceDone()
+{
+    if (g_trace == TRACE_OFF) return;
+    for (auto* st : g_all) TraceFlush(st);
+    g_trace_out.close();
+    g_trace = TRACE_OFF;
+}
+
+// The child of a fork must not write the parent's buffered records again
+static VOID TraceFork(THREADID tid, const CONTEXT*, VOID*)
+{
+    if (g_trace == TRACE_OFF) return;
+    TraceFlush(St(tid));
+    PIN_GetLock(&g_lock, tid + 1);
+    g_trace_out.flush();
+    PIN_ReleaseLock(&g_lock);
+}
+
+// ── per-CPU breakdown (-percpu) ─────────────────────────────────────────────
+// Pin cannot tell which CPU runs an instruction, so each thread's CPU is
+// sampled: every CPU_SAMPLE_MS an internal thread reads the CPU each
+// live thread last ran on from /proc and charges it the thread's counts
+// since the previous sample.  A thread migrating between samples has the
+// whole period charged to the CPU it ended on.
+static const UINT32   CPU_SAMPLE_MS = 10;
+static PIN_THREAD_UID g_cpu_uid;
+static volatile bool  g_cpu_stop = false;
+
+// The CPU the kernel thread os_tid last ran on, -1 once it is gone
+static INT32 CpuOf(OS_THREAD_ID os_tid)
+{
+    std::ifstream in("/proc/self/task/" + std::to_string(os_tid) + "/stat");
+    std::string stat;
+    if (!std::getline(in, stat)) return -1;
+    size_t p = stat.rfind(')');            // the command may hold spaces
+    if (p == std::string::npos) return -1;
+    std::istringstream fields(stat.substr(p + 1));
+    std::string f;
+    for (int i = 3; i <= 39 && fields >> f; ++i)   // field 39: processor
+        if (i == 39) return INT32(strtol(f.c_str(), nullptr, 10));
+    return -1;
+}
+
+// Charges st's counts since the last charge to the CPU it is on now, or
+// the one it was last seen on; the caller holds g_lock
+static VOID ChargeCpu(ThreadState* st)
+{
+    INT32 cpu = CpuOf(st->os_tid);
+    if (cpu >= 0) st->cpu = cpu;
+    Cnts now = st->cnts;               // one read of counters still running
+    UINT64* dst = Words(st->cpus[st->cpu]);
+    const UINT64 *n = Words(now), *then = Words(st->cpu_mark);
+    for (size_t i = 0; i < NumWords(now); ++i) dst[i] += n[i] - then[i];
+    st->cpu_mark = now;
+}
+
+static VOID CpuController(VOID*)
+{
+    while (!g_cpu_stop) {
+        PIN_Sleep(CPU_SAMPLE_MS);
+        PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+        for (auto* st : g_all)
+            if (!st->exited) ChargeCpu(st);
+        PIN_ReleaseLock(&g_lock);
+    }
+}
+
+// BuildReport charges what is left to each thread's last CPU
+static VOID CpuExit(VOID*)
+{
+    g_cpu_stop = true;
+    PIN_WaitForThreadTermination(g_cpu_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// ── thread lifecycle ────────────────────────────────────────────────────────
+// Every thread gets its own ThreadState, kept in g_all until Fini so counts
+// from threads that exit early are still reported.
+static VOID ThreadStart(THREADID tid, CONTEXT*, INT32, VOID*)
+{
+    auto* st = new ThreadState;
+    st->tid    = tid;
+    st->os_tid = PIN_GetTid();
+    st->parent = PIN_GetParentTid();
+    st->rng    = (g_seed + tid) * 0x9E3779B97F4A7C15ULL | 1;
+    if (g_sampling) st->sampled = DrawSample(st);
+    if (g_calls_on) st->nodes.push_back({NO_FUNC, 0, Cnts{}});
+    PIN_SetThreadData(tlsKey, st, tid);
+
+    PIN_GetLock(&g_lock, tid + 1);
+    g_all.push_back(st);
+    PIN_ReleaseLock(&g_lock);
+    DBG(1, "Thread start (tid=" << tid << " os_tid=" << st->os_tid << ")");
+}
+
+static VOID ThreadFini(THREADID tid, const CONTEXT*, INT32 code, VOID*)
+{
+    ThreadState* st = St(tid);
+    st->exited    = true;
+    st->exit_code = code;
+    if (g_sys_on && st->syscall >= 0) {
+        SyscallLine(st, "-");
+        st->syscall = -1;
+    }
+    if (g_systime_on) SysTimeEnd(st, true, false);
+    if (g_trace != TRACE_OFF) TraceFlush(st);
+    if (g_percpu_on) {
+        PIN_GetLock(&g_lock, tid + 1);
+        ChargeCpu(st);
+        PIN_ReleaseLock(&g_lock);
+    }
+    DBG(1, "Thread exit (tid=" << tid << " code=" << code << ")");
+}
+
+// ── report ──────────────────────────────────────────────────────────────────
+struct Totals {
+    UINT64 add{}, sub{}, mul{}, div{};
+    UINT64 bit[BIT_OPS]{};
+    UINT64 wide[WIDE_KINDS][WIDE_SLOTS]{};
+    UINT64 vec[VEC_OPS]{};
+    UINT64 mem[MEM_KINDS]{};
+    UINT64 mod[MOD_KINDS]{};
+    UINT64 fp[FP_PRECS][FP_OPS]{};
+    UINT64 cls[MAX_CLASSES]{};
+    UINT64 agen[AGEN_KINDS]{};
+    UINT64 width[WIDTH_CATS][WIDTH_SLOTS]{};
+    UINT64 sign[2][SIGN_KINDS]{};
+    UINT64 atomic[ATOMIC_KINDS]{};
+    UINT64 Sum() const { return add + sub + mul + div; }
+    UINT64 BitSum() const
+    {
+        UINT64 s = 0;
+        for (int o = 0; o < BIT_OPS; ++o) s += bit[o];
+        return s;
+    }
+    UINT64 FpSum(FpPrec p) const
+    {
+        UINT64 s = 0;
+        for (int o = 0; o < FP_OPS; ++o) s += fp[p][o];
+        return s;
+    }
+    UINT64 FpSum() const { return FpSum(FP64) + FpSum(FP32); }
+    UINT64 WideSum(WideKind k) const
+    {
+        UINT64 s = 0;
+        for (int w = 0; w < WIDE_SLOTS; ++w) s += wide[k][w];
+        return s;
+    }
+    UINT64 WideSum() const { return WideSum(WADD) + WideSum(WSUB) + WideSum(WMUL); }
+    UINT64 VecSum() const { return vec[VADD] + vec[VSUB] + vec[VMUL]; }
+    UINT64 WidthSum() const
+    {
+        UINT64 s = 0;
+        for (int k = 0; k < WIDTH_CATS; ++k)
+            for (int w = 0; w < WIDTH_SLOTS; ++w) s += width[k][w];
+        return s;
+    }
+    UINT64 ClsSum() const
+    {
+        UINT64 s = 0;
+        for (int k = 0; k < MAX_CLASSES; ++k) s += cls[k];
+        return s;
+    }
+    UINT64 AtomicSum() const { return atomic[AT_RMW] + atomic[AT_FUTEX]; }
+    UINT64 Bytes() const { return mem[MBYTES_R] + mem[MBYTES_W]; }
+    UINT64 DramBytes() const { return mem[MDRAM_R] + mem[MDRAM_W]; }
+    UINT64 ModMuls() const
+    {
+        UINT64 s = 0;
+        for (int k = 0; k < MOD_MULS; ++k) s += mod[k];
+        return s;
+    }
+    // sort key for breakdown rows
+    UINT64 Weight() const { return Sum() + BitSum() + VecSum() + FpSum() + ClsSum(); }
+};
+
+struct FuncRow {
+    const FuncInfo* info;
+    Totals          t;
+};
+
+struct LineRow {
+    const LineInfo* info;
+    Totals          t;
+};
+
+// A loop with its nested loops' counts folded in
+struct LoopRow {
+    const LoopInfo* info;
+    UINT32          id;
+    Totals          t;
+    UINT64          entries, iterations;
+};
+
+struct ModuleRow {
+    const ModuleInfo* info;
+    Totals            t;
+    UINT32            funcs;        // functions with counts
+    const char*       loaded;       // "startup", "dlopen"; null if unknown
+};
+
+struct ThreadRow {
+    const ThreadState* st;
+    Totals             t;
+};
+
+// -percpu: one CPU, or one NUMA node, with the counts charged to it
+struct CpuRow {
+    INT32  cpu;         // -1: threads that were never seen on one
+    INT32  node;
+    UINT32 threads;     // threads with counts charged to it
+    Totals t;
+};
+
+struct NodeRow {
+    INT32  node;
+    UINT32 cpus;        // CPUs with counts
+    Totals t;
+};
+
+struct CallRow {
+    const FuncInfo* info;
+    Totals          incl, excl;
+};
+
+struct StackRow {
+    std::vector<UINT32> path;       // function ids, outermost first
+    Totals              t;          // exclusive counts
+};
+
+// -children: one process image, this one or a child that reported before
+// the root exited
+struct ProcRow {
+    INT                      pid = 0, ppid = 0;
+    std::string              started;  // launch, attach, fork or exec
+    UINT64                   n[4 + BIT_OPS]{};  // add, sub, mul, div, bit ops
+    std::string              binary;
+    std::vector<std::string> args;
+};
+
+// Divisor classes, as named in reports
+enum DivClass { DIV_POW2, DIV_CONSTANT, DIV_VARIABLE, DIV_CLASSES };
+static const char* const DIV_CLASS_NAMES[DIV_CLASSES] = {"pow2", "constant", "variable"};
+
+// The working set of one -footprint scope merged over threads: the lines
+// and pages of their union, and the fullest window of any of them
+struct FootRow {
+    UINT32             func = NO_SITE;      // per-function rows
+    const std::string* phase = nullptr;     // per-phase rows
+    UINT64 lines = 0, pages = 0, peak_lines = 0, peak_pages = 0;
+};
+
+// The memory accesses of one loop's stride sites merged over threads
+struct StrideRow {
+    const LoopInfo* info;
+    UINT32          id;
+    UINT32          sites = 0;
+    UINT64          unit = 0, constant = 0, irregular = 0;
+    UINT64 Classified() const { return unit + constant + irregular; }
+};
+
+// The executions of one loop's reuse sites, or of one site, merged over
+// threads
+struct ReuseRow {
+    const LoopInfo*      info;
+    UINT32               id;               // the loop's
+    const ReuseSiteInfo* site = nullptr;   // per-instruction rows
+    UINT32               sites = 0;
+    UINT64               n = 0, same = 0, hits = 0;
+};
+
+// One branch site merged over threads
+struct BranchRow {
+    const BranchSiteInfo* info;
+    UINT64 n = 0, taken = 0, flips = 0, miss_bimodal = 0, miss_local = 0;
+    // bits per outcome of a branch taken at its rate
+    double Entropy() const
+    {
+        if (!n || taken == 0 || taken == n) return 0;
+        const double p = double(taken) / n;
+        return -p * std::log2(p) - (1 - p) * std::log2(1 - p);
+    }
+};
+
+// One division site merged over threads
+struct DivRow {
+    const DivSiteInfo*  info;
+    UINT64              n = 0, pow2 = 0;
+    std::vector<UINT64> vals;       // distinct divisors, up to DIV_VALUES
+    bool                more = false;   // more than DIV_VALUES distinct
+    bool Constant() const { return !more && vals.size() == 1; }
+    DivClass Class() const
+    {
+        if (Constant()) return IsPow2(vals[0], info->is_signed) ? DIV_POW2 : DIV_CONSTANT;
+        return pow2 == n ? DIV_POW2 : DIV_VARIABLE;
+    }
+};
+
+// Invocations of one butterfly function that ran the same number of
+// butterflies, merged over threads
+struct BflyRow {
+    const FuncInfo* info;
+    UINT64          per_call, calls;
+    UINT64 Butterflies() const { return per_call * calls; }
+};
+
+struct BlockRow {
+    const BlockInfo* info;
+    UINT64           execs;
+    UINT64 Ops() const { return execs * info->ops; }
+};
+
+struct AnnRow {
+    const AnnFunc*      func;
+    std::vector<UINT64> execs;     // per instruction
+    UINT64              total;
+};
+
+struct DfgEdge {
+    UINT32 from, to;               // indices into Report.dfg
+    UINT64 count;
+};
+
+// -dead: a site, or the sites of a function, with results and dead ones
+struct DeadRow {
+    const DeadSiteInfo* info;      // null for a function's row
+    UINT32 func;
+    UINT64 results, dead;
+};
+
+struct RegionRow {
+    const std::string* name;
+    UINT64             entries;
+    Totals             t;
+};
+
+struct PyRow {
+    const PyFunc* f;
+    UINT64        calls;
+    Totals        t;
+};
+
+struct PhaseRow {
+    const std::string* name;
+    double             start, end;   // seconds into the run
+    Totals             t;
+};
+
+// Extrapolation of a sampled run; ci95 < 0 when fewer than two windows
+// were sampled.
+struct SampleSummary {
+    UINT64 windows = 0, windows_sampled = 0;
+    UINT64 insns = 0, insns_sampled = 0;
+    double scale = 1;               // insns / insns_sampled
+    double ci95[4]{};               // add, sub, mul, div
+};
+
+struct Report {
+    Cnts                   raw;     // merged per-variant counters
+    Totals                 total;
+    std::vector<FuncRow>   funcs;   // sorted by descending Sum()
+    std::vector<LineRow>   lines;   // sorted by file, then line
+    std::vector<LoopRow>   loops;   // sorted by descending Weight()
+    std::vector<ModuleRow> modules; // most counts first, then load order
+    std::vector<ThreadRow> threads; // in creation order
+    std::vector<CpuRow>    cpus;    // -percpu: by CPU number
+    std::vector<NodeRow>   nodes;   // -percpu: by node number
+    std::vector<RegionRow> regions; // in first-entry order
+    std::vector<PhaseRow>  phases;  // -phases: in start order, empty ones left out
+    std::vector<PyRow>     python;  // -python: most counts first
+    std::vector<CallRow>   calls;   // sorted by descending inclusive weight
+    std::vector<StackRow>  stacks;  // every context with counts, tree order
+    std::vector<DivRow>    divs;    // executed division sites, most first
+    std::vector<BranchRow> branches;  // executed branch sites, most missed first
+    std::vector<StrideRow> strides;   // -strides: loops by classified accesses, most first
+    std::vector<ReuseRow>  reuse;        // -reuse: loops by hits, most first
+    std::vector<ReuseRow>  reuse_sites;  // instructions by hits, most first
+    FootRow                foot;      // -footprint: the whole program
+    std::vector<std::pair<UINT64, UINT64>> foot_curve;  // lines, pages per window
+    std::vector<FootRow>   foot_funcs;   // most lines first
+    std::vector<FootRow>   foot_phases;  // in start order, untouched ones left out
+    std::vector<BflyRow>   bfly;    // most butterflies first
+    std::vector<BlockRow>  blocks;  // -blocks: the hottest, most ops first
+    std::vector<AnnRow>    annotated;  // -annotate: most executions first
+    std::vector<std::pair<const DfgNode*, UINT64>> dfg;  // -dfg: executed nodes, by address
+    std::vector<DfgEdge>   dfg_edges;                    // most frequent first
+    std::vector<DeadRow>   dead_sites;   // -dead: most dead first, none left out
+    std::vector<DeadRow>   dead_funcs;   // most dead first
+    UINT64                 dead_results = 0, dead = 0, dead_live = 0;
+    std::vector<ProcRow>   procs;   // -children: this process first
+    std::vector<Diag>      diags;   // g_diags and those found folding the counts
+    UINT64                 mulw[2][MUL_WIDTHS]{};   // -mulvals, over threads
+    UINT64                 mul_seen = 0;
+    UINT64                 compound[COMPOUND_KINDS]{};  // -compound split|both
+    UINT64                 mix[MIX_KINDS]{};            // -mix
+    std::vector<UINT64>    cache_accesses, cache_misses;  // -cache: per level
+    Totals                 origin[GO_ORIGINS];      // -go, folded from functions
+    UINT32                 origin_funcs[GO_ORIGINS]{};
+    double                 wall_sec = 0;
+    SampleSummary          sample;
+};
+
+static VOID Accumulate(Cnts& dst, const Cnts& src)
+{
+#define ACC(f) dst.f += src.f
+    ACC(add_rr);  ACC(sub_rr);  ACC(adc_rr);  ACC(sbb_rr);
+    ACC(mul_rr);  ACC(mulx_rr); ACC(adcx_rr); ACC(adox_rr); ACC(div_rr);
+    ACC(add_rm);  ACC(sub_rm);  ACC(adc_rm);  ACC(sbb_rm);
+    ACC(mul_rm);  ACC(mulx_rm); ACC(adcx_rm); ACC(adox_rm); ACC(div_rm);
+    ACC(lea_rr);
+#undef ACC
+    for (int o = 0; o < BIT_OPS; ++o) {
+        dst.bit[o][0] += src.bit[o][0];
+        dst.bit[o][1] += src.bit[o][1];
+    }
+    for (int k = 0; k < WIDE_KINDS; ++k)
+        for (int w = 0; w < WIDE_SLOTS; ++w) dst.wide[k][w] += src.wide[k][w];
+    for (int v = 0; v < VEC_OPS; ++v) dst.vec[v] += src.vec[v];
+    for (int k = 0; k < MEM_KINDS; ++k) dst.mem[k] += src.mem[k];
+    for (int k = 0; k < MOD_KINDS; ++k) dst.mod[k] += src.mod[k];
+    for (int p = 0; p < FP_PRECS; ++p)
+        for (int o = 0; o < FP_OPS; ++o) dst.fp[p][o] += src.fp[p][o];
+    for (int k = 0; k < MAX_CLASSES; ++k) dst.cls[k] += src.cls[k];
+    for (int k = 0; k < AGEN_KINDS; ++k) dst.agen[k] += src.agen[k];
+    for (int k = 0; k < WIDTH_CATS; ++k)
+        for (int w = 0; w < WIDTH_SLOTS; ++w) dst.width[k][w] += src.width[k][w];
+    for (int k = 0; k < 2; ++k)
+        for (int s = 0; s < SIGN_KINDS; ++s) dst.sign[k][s] += src.sign[k][s];
+    for (int k = 0; k < ATOMIC_KINDS; ++k) dst.atomic[k] += src.atomic[k];
+}
+
+static Totals Summarize(const Cnts& c)
+{
+    Totals t;
+    t.add = c.add_rr + c.add_rm + c.adc_rr + c.adc_rm +
+            c.adcx_rr + c.adcx_rm + c.adox_rr + c.adox_rm + c.lea_rr;
+    t.sub = c.sub_rr + c.sub_rm + c.sbb_rr + c.sbb_rm;
+    t.mul = c.mul_rr + c.mul_rm + c.mulx_rr + c.mulx_rm;
+    t.div = c.div_rr + c.div_rm;
+    for (int o = 0; o < BIT_OPS; ++o) t.bit[o] = c.bit[o][0] + c.bit[o][1];
+    for (int k = 0; k < WIDE_KINDS; ++k)
+        for (int w = 0; w < WIDE_SLOTS; ++w) t.wide[k][w] = c.wide[k][w];
+    for (int v = 0; v < VEC_OPS; ++v) t.vec[v] = c.vec[v];
+    for (int k = 0; k < MEM_KINDS; ++k) t.mem[k] = c.mem[k];
+    for (int k = 0; k < MOD_KINDS; ++k) t.mod[k] = c.mod[k];
+    for (int p = 0; p < FP_PRECS; ++p)
+        for (int o = 0; o < FP_OPS; ++o) t.fp[p][o] = c.fp[p][o];
+    for (int k = 0; k < MAX_CLASSES; ++k) t.cls[k] = c.cls[k];
+    for (int k = 0; k < AGEN_KINDS; ++k) t.agen[k] = c.agen[k];
+    for (int k = 0; k < WIDTH_CATS; ++k)
+        for (int w = 0; w < WIDTH_SLOTS; ++w) t.width[k][w] = c.width[k][w];
+    for (int k = 0; k < 2; ++k)
+        for (int s = 0; s < SIGN_KINDS; ++s) t.sign[k][s] = c.sign[k][s];
+    for (int k = 0; k < ATOMIC_KINDS; ++k) t.atomic[k] = c.atomic[k];
+    if (g_agen == AGEN_FOLD) {
+        t.add += c.agen[AG_LEA_ADD] + c.agen[AG_MEM_ADD];
+        if (g_bit_on[BSHL]) t.bit[BSHL] += c.agen[AG_LEA_SHL] + c.agen[AG_MEM_SHL];
+    }
+    return t;
+}
+
+static VOID EndWindow(ThreadState* st)
+{
+    st->insns += st->icount;
+    st->windows++;
+    if (st->sampled) {
+        Totals now = Summarize(st->cnts), then = Summarize(st->win_start);
+        double x = double(st->icount);
+        double y[4] = {double(now.add - then.add), double(now.sub - then.sub),
+                       double(now.mul - then.mul), double(now.div - then.div)};
+        SampleStats& ss = st->stats;
+        ss.n += 1; ss.sx += x; ss.sxx += x * x;
+        for (int c = 0; c < 4; ++c) {
+            ss.sy[c] += y[c]; ss.syy[c] += y[c] * y[c]; ss.sxy[c] += x * y[c];
+        }
+        st->insns_sampled += st->icount;
+        st->windows_sampled++;
+    }
+    st->icount    = 0;
+    st->sampled   = DrawSample(st);
+    st->win_start = st->cnts;
+}
+
+// Ratio estimator: ops per sampled instruction × total instructions, with
+// the finite-population-corrected standard error of the ratio.
+static SampleSummary Extrapolate()
+{
+    SampleSummary sm;
+    SampleStats ss;
+    for (auto* st : g_all) {
+        sm.windows += st->windows;             sm.windows_sampled += st->windows_sampled;
+        sm.insns   += st->insns;               sm.insns_sampled   += st->insns_sampled;
+        ss.n += st->stats.n; ss.sx += st->stats.sx; ss.sxx += st->stats.sxx;
+        for (int c = 0; c < 4; ++c) {
+            ss.sy[c]  += st->stats.sy[c];
+            ss.syy[c] += st->stats.syy[c];
+            ss.sxy[c] += st->stats.sxy[c];
+        }
+    }
+    sm.scale = sm.insns_sampled ? double(sm.insns) / double(sm.insns_sampled) : 0;
+
+    for (int c = 0; c < 4; ++c) {
+        if (ss.n < 2 || ss.sx == 0) { sm.ci95[c] = -1; continue; }
+        double R    = ss.sy[c] / ss.sx;
+        double s2   = (ss.syy[c] - 2 * R * ss.sxy[c] + R * R * ss.sxx) / (ss.n - 1);
+        double xbar = ss.sx / ss.n;
+        double fpc  = 1 - ss.n / double(sm.windows);
+        double varR = std::max(0.0, fpc * s2 / (ss.n * xbar * xbar));
+        sm.ci95[c]  = 1.96 * double(sm.insns) * std::sqrt(varR);
+    }
+    return sm;
+}
+
+static VOID Scale(Cnts& c, double k)
+{
+    UINT64* v = Words(c);
+    for (size_t i = 0; i < NumWords(c); ++i)
+        v[i] = UINT64(std::llround(double(v[i]) * k));
+}
+
+// Merges the threads' context trees by path, then derives per-function
+// inclusive counts (each context counted once per function on its path,
+// so recursion is not double-counted) and exclusive counts.
+static VOID BuildCallGraph(Report& r)
+{
+    std::vector<CtxNode> all(1, CtxNode{NO_FUNC, 0, Cnts{}});
+    std::map<std::pair<UINT32, UINT32>, UINT32> ids;
+    for (auto* st : g_all) {
+        std::vector<UINT32> to(st->nodes.size(), 0);   // thread → merged node
+        for (size_t i = 1; i < st->nodes.size(); ++i) {
+            const CtxNode& n = st->nodes[i];
+            auto key = std::make_pair(to[n.parent], n.func);
+            auto it = ids.find(key);
+            if (it == ids.end()) {
+                it = ids.emplace(key, static_cast<UINT32>(all.size())).first;
+                all.push_back({n.func, to[n.parent], Cnts{}});
+            }
+            to[i] = it->second;
+        }
+        for (size_t i = 0; i < st->nodes.size(); ++i)
+            Accumulate(all[to[i]].cnts, st->nodes[i].cnts);
+    }
+
+    std::vector<Cnts> incl(g_funcs.size()), excl(g_funcs.size());
+    for (size_t i = 0; i < all.size(); ++i) {
+        if (g_sampling) Scale(all[i].cnts, r.sample.scale);
+        Totals t = Summarize(all[i].cnts);
+        if (t.Weight() == 0 && t.WideSum() == 0 && t.AtomicSum() == 0) continue;
+
+        StackRow row{{}, t};
+        for (UINT32 n = UINT32(i); n != 0; n = all[n].parent) row.path.push_back(all[n].func);
+        std::reverse(row.path.begin(), row.path.end());
+        if (i) Accumulate(excl[all[i].func], all[i].cnts);
+        std::set<UINT32> seen(row.path.begin(), row.path.end());
+        for (UINT32 f : seen) Accumulate(incl[f], all[i].cnts);
+        r.stacks.push_back(row);
+    }
+
+    for (size_t f = 0; f < g_funcs.size(); ++f) {
+        Totals t = Summarize(incl[f]);
+        if (t.Weight() == 0 && t.WideSum() == 0 && t.AtomicSum() == 0) continue;
+        r.calls.push_back({&g_funcs[f], t, Summarize(excl[f])});
+    }
+    std::stable_sort(r.calls.begin(), r.calls.end(),
+                     [](const CallRow& a, const CallRow& b)
+                     { return a.incl.Weight() > b.incl.Weight(); });
+}
+
+static VOID BuildBfly(Report& r)
+{
+    std::map<std::pair<UINT32, UINT64>, UINT64> calls;
+    for (auto* st : g_all) {
+        for (const auto& kv : st->bfly_calls) calls[kv.first] += kv.second;
+        for (size_t f = 0; f < st->bfly_open.size(); ++f)   // running at exit
+            if (st->bfly_open[f]) calls[{UINT32(f), st->bfly_open[f]}]++;
+    }
+    for (const auto& kv : calls)
+        r.bfly.push_back({&g_funcs[kv.first.first], kv.first.second, kv.second});
+    std::stable_sort(r.bfly.begin(), r.bfly.end(), [](const BflyRow& a, const BflyRow& b)
+                     { return a.Butterflies() > b.Butterflies(); });
+}
+
+// Folds every loop's counts into its ancestors, deepest first, and adds
+// the header and back-edge counts of all threads.  Loops without counted
+// operations are left out, like functions.
+static VOID BuildLoops(Report& r, std::vector<Cnts>& loops)
+{
+    std::vector<UINT32> order;
+    for (UINT32 i = 0; i < loops.size(); ++i) order.push_back(i);
+    std::stable_sort(order.begin(), order.end(), [](UINT32 a, UINT32 b)
+                     { return g_loops[a].depth > g_loops[b].depth; });
+    for (UINT32 i : order)
+        if (g_loops[i].parent != NO_SITE) Accumulate(loops[g_loops[i].parent], loops[i]);
+
+    std::vector<UINT64> heads(loops.size()), backs(loops.size());
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->loop_heads.size(); ++i) {
+            heads[i] += st->loop_heads[i];
+            backs[i] += st->loop_backs[i];
+        }
+    for (UINT32 i = 0; i < loops.size(); ++i) {
+        Totals t = Summarize(loops[i]);
+        if (t.Weight() == 0 && t.WideSum() == 0 && t.Bytes() == 0) continue;
+        const LoopInfo& li = g_loops[i];
+        UINT64 entries = heads[i] > backs[i] ? heads[i] - backs[i] : 0;
+        r.loops.push_back({&li, i, t, entries, li.head_exit ? backs[i] : heads[i]});
+    }
+    std::stable_sort(r.loops.begin(), r.loops.end(), [](const LoopRow& a, const LoopRow& b)
+                     { return a.t.Weight() > b.t.Weight(); });
+}
+
+static VOID BuildBlocks(Report& r)
+{
+    std::vector<UINT64> execs(g_block_info.size());
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->block_execs.size(); ++i) execs[i] += st->block_execs[i];
+    for (size_t i = 0; i < execs.size(); ++i)
+        if (execs[i]) r.blocks.push_back({&g_block_info[i], execs[i]});
+    std::stable_sort(r.blocks.begin(), r.blocks.end(), [](const BlockRow& a, const BlockRow& b)
+                     { return a.Ops() > b.Ops(); });
+    if (r.blocks.size() > g_blocks) r.blocks.resize(g_blocks);
+}
+
+static VOID BuildAnnotated(Report& r)
+{
+    std::vector<UINT64> execs(g_ann_total);
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->ann_execs.size(); ++i) execs[i] += st->ann_execs[i];
+    for (const auto& f : g_ann_funcs) {
+        AnnRow a{&f, std::vector<UINT64>(execs.begin() + f.first, execs.begin() + f.first + f.ins.size()), 0};
+        for (UINT64 n : a.execs) a.total += n;
+        r.annotated.push_back(a);
+    }
+    std::stable_sort(r.annotated.begin(), r.annotated.end(), [](const AnnRow& a, const AnnRow& b)
+                     { return a.total > b.total; });
+}
+
+static VOID BuildDfg(Report& r)
+{
+    std::vector<UINT64> execs(g_dfg_nodes.size());
+    std::map<std::pair<UINT32, UINT32>, UINT64> edges;
+    for (auto* st : g_all) {
+        for (size_t i = 0; i < st->dfg_execs.size(); ++i) execs[i] += st->dfg_execs[i];
+        for (const auto& e : st->dfg_edges)
+            edges[{UINT32(e.first >> 32), UINT32(e.first)}] += e.second;
+    }
+    std::vector<UINT32> order;
+    for (UINT32 i = 0; i < execs.size(); ++i)
+        if (execs[i]) order.push_back(i);
+    std::stable_sort(order.begin(), order.end(), [](UINT32 a, UINT32 b)
+                     { return g_dfg_nodes[a].addr < g_dfg_nodes[b].addr; });
+    std::vector<UINT32> index(g_dfg_nodes.size(), NO_NODE);
+    for (UINT32 i = 0; i < order.size(); ++i) {
+        index[order[i]] = i;
+        r.dfg.push_back({&g_dfg_nodes[order[i]], execs[order[i]]});
+    }
+    for (const auto& e : edges)
+        if (index[e.first.first] != NO_NODE && index[e.first.second] != NO_NODE)
+            r.dfg_edges.push_back({index[e.first.first], index[e.first.second], e.second});
+    std::stable_sort(r.dfg_edges.begin(), r.dfg_edges.end(), [](const DfgEdge& a, const DfgEdge& b)
+                     { return a.count > b.count; });
+}
+
+static VOID BuildDead(Report& r)
+{
+    std::vector<DeadRow> sites(g_dead_sites.size());
+    for (size_t i = 0; i < sites.size(); ++i) sites[i] = {&g_dead_sites[i], g_dead_sites[i].func, 0, 0};
+    for (auto* st : g_all) {
+        for (size_t i = 0; i < st->dead_results.size(); ++i) sites[i].results += st->dead_results[i];
+        for (size_t i = 0; i < st->dead_n.size(); ++i) sites[i].dead += st->dead_n[i];
+        for (const auto& v : st->dead_vals)
+            r.dead_live += v.refs && !v.used;
+    }
+    std::map<UINT32, DeadRow> funcs;
+    for (const auto& s : sites) {
+        if (!s.results) continue;
+        r.dead_results += s.results;
+        r.dead += s.dead;
+        DeadRow& f = funcs.emplace(s.func, DeadRow{nullptr, s.func, 0, 0}).first->second;
+        f.results += s.results;
+        f.dead += s.dead;
+        if (s.dead) r.dead_sites.push_back(s);
+    }
+    for (const auto& f : funcs) r.dead_funcs.push_back(f.second);
+    auto most = [](const DeadRow& a, const DeadRow& b) {
+        return a.dead != b.dead ? a.dead > b.dead : a.results > b.results;
+    };
+    std::stable_sort(r.dead_sites.begin(), r.dead_sites.end(), most);
+    std::stable_sort(r.dead_funcs.begin(), r.dead_funcs.end(), most);
+}
+
+static VOID BuildBranches(Report& r)
+{
+    std::vector<BranchRow> rows(g_br_sites.size());
+    for (size_t i = 0; i < rows.size(); ++i) rows[i].info = &g_br_sites[i];
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->branches.size(); ++i) {
+            const BranchStats& s = st->branches[i];
+            BranchRow& b = rows[i];
+            b.n += s.n;
+            b.taken += s.taken;
+            b.flips += s.flips;
+            b.miss_bimodal += s.miss_bimodal;
+            b.miss_local += s.miss_local;
+        }
+    for (auto& b : rows)
+        if (b.n) r.branches.push_back(b);
+    std::stable_sort(r.branches.begin(), r.branches.end(), [](const BranchRow& a, const BranchRow& b)
+                     { return a.miss_bimodal != b.miss_bimodal ? a.miss_bimodal > b.miss_bimodal : a.n > b.n; });
+}
+
+static FootRow MergeFoot(const std::vector<const FootSet*>& sets)
+{
+    FootRow row;
+    std::unordered_set<UINT64> lines, pages;
+    for (const FootSet* s : sets) {
+        for (const auto& l : s->lines) lines.insert(l.first);
+        for (const auto& p : s->pages) pages.insert(p.first);
+        row.peak_lines = std::max({row.peak_lines, s->peak_lines, s->win_lines});
+        row.peak_pages = std::max({row.peak_pages, s->peak_pages, s->win_pages});
+    }
+    row.lines = lines.size();
+    row.pages = pages.size();
+    return row;
+}
+
+// Merges the threads' working sets; the curve adds up their windows of
+// the same index, the last, partial, ones included
+static VOID BuildFootprint(Report& r)
+{
+    std::vector<const FootSet*> all;
+    std::map<UINT32, std::vector<const FootSet*>> funcs;
+    std::vector<std::vector<const FootSet*>> phases(g_phases.size());
+    for (auto* st : g_all) {
+        all.push_back(&st->foot);
+        for (const auto& f : st->foot_funcs) funcs[f.first].push_back(&f.second);
+        for (size_t i = 0; i < st->foot_phases.size(); ++i) phases[i].push_back(&st->foot_phases[i]);
+        std::vector<std::pair<UINT64, UINT64>> curve = st->foot_curve;
+        if (st->foot.window != ~0ull) curve.push_back({st->foot.win_lines, st->foot.win_pages});
+        if (r.foot_curve.size() < curve.size()) r.foot_curve.resize(curve.size());
+        for (size_t i = 0; i < curve.size(); ++i) {
+            r.foot_curve[i].first  += curve[i].first;
+            r.foot_curve[i].second += curve[i].second;
+        }
+    }
+    r.foot = MergeFoot(all);
+    for (const auto& f : funcs) {
+        FootRow row = MergeFoot(f.second);
+        row.func = f.first;
+        r.foot_funcs.push_back(row);
+    }
+    std::stable_sort(r.foot_funcs.begin(), r.foot_funcs.end(), [](const FootRow& a, const FootRow& b)
+                     { return a.lines != b.lines ? a.lines > b.lines : a.pages > b.pages; });
+    for (size_t i = 0; i < phases.size(); ++i) {
+        FootRow row = MergeFoot(phases[i]);
+        row.phase = &g_phases[i].name;
+        if (row.lines) r.foot_phases.push_back(row);
+    }
+}
+
+static VOID BuildStrides(Report& r)
+{
+    std::vector<StrideRow> rows(g_loops.size());
+    for (UINT32 i = 0; i < rows.size(); ++i) {
+        rows[i].info = &g_loops[i];
+        rows[i].id = i;
+    }
+    for (const auto& site : g_stride_sites) rows[site.first].sites++;
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->strides.size(); ++i) {
+            const StrideStats& s = st->strides[i];
+            StrideRow& l = rows[g_stride_sites[i].first];
+            l.unit += s.unit;
+            l.constant += s.constant;
+            l.irregular += s.irregular;
+        }
+    for (auto& l : rows)
+        if (l.Classified()) r.strides.push_back(l);
+    std::stable_sort(r.strides.begin(), r.strides.end(), [](const StrideRow& a, const StrideRow& b)
+                     { return a.Classified() > b.Classified(); });
+}
+
+static VOID BuildReuse(Report& r)
+{
+    std::vector<ReuseRow> loops(g_loops.size()), sites(g_reuse_sites.size());
+    for (UINT32 i = 0; i < loops.size(); ++i) {
+        loops[i].info = &g_loops[i];
+        loops[i].id = i;
+    }
+    for (UINT32 i = 0; i < sites.size(); ++i) {
+        const UINT32 l = g_reuse_sites[i].loop;
+        sites[i].info = &g_loops[l];
+        sites[i].id = l;
+        sites[i].site = &g_reuse_sites[i];
+        sites[i].sites = 1;
+        loops[l].sites++;
+    }
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->reuse.size(); ++i) {
+            const ReuseStats& s = st->reuse[i];
+            for (ReuseRow* row : {&sites[i], &loops[g_reuse_sites[i].loop]}) {
+                row->n += s.n;
+                row->same += s.same;
+                row->hits += s.hits;
+            }
+        }
+    for (const auto& l : loops)
+        if (l.n) r.reuse.push_back(l);
+    for (const auto& s : sites)
+        if (s.n) r.reuse_sites.push_back(s);
+    auto most = [](const ReuseRow& a, const ReuseRow& b) {
+        return a.hits != b.hits ? a.hits > b.hits : a.n > b.n;
+    };
+    std::stable_sort(r.reuse.begin(), r.reuse.end(), most);
+    std::stable_sort(r.reuse_sites.begin(), r.reuse_sites.end(), most);
+}
+
+static VOID BuildDivs(Report& r)
+{
+    std::vector<DivRow> rows(g_div_sites.size());
+    for (size_t i = 0; i < rows.size(); ++i) rows[i].info = &g_div_sites[i];
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->divs.size(); ++i) {
+            const DivStats& s = st->divs[i];
+            DivRow& d = rows[i];
+            d.n += s.n;
+            d.pow2 += s.pow2;
+            d.more = d.more || s.nvals > DIV_VALUES;
+            for (UINT32 v = 0; v < std::min(s.nvals, DIV_VALUES); ++v)
+                if (std::find(d.vals.begin(), d.vals.end(), s.vals[v]) == d.vals.end())
+                    d.vals.push_back(s.vals[v]);
+            if (d.vals.size() > DIV_VALUES) {
+                d.vals.resize(DIV_VALUES);
+                d.more = true;
+            }
+        }
+    for (auto& d : rows)
+        if (d.n) r.divs.push_back(d);
+    std::stable_sort(r.divs.begin(), r.divs.end(),
+                     [](const DivRow& a, const DivRow& b) { return a.n > b.n; });
+}
+
+// Folds functions into their images.  Every loaded image gets a row, so
+// libraries that ran no counted code still show as covered; code outside
+// any known image goes to an "[unknown]" row when it counted anything.
+static VOID BuildModules(Report& r, const std::vector<Cnts>& funcs)
+{
+    static const ModuleInfo unknown{"[unknown]"};
+    std::vector<Cnts>   mc(g_modules.size() + 1);
+    std::vector<UINT32> n(g_modules.size() + 1);
+    for (size_t i = 0; i < funcs.size(); ++i) {
+        size_t m = g_funcs[i].module < g_modules.size() ? g_funcs[i].module : g_modules.size();
+        Accumulate(mc[m], funcs[i]);
+        Totals t = Summarize(funcs[i]);
+        if (t.Weight() || t.WideSum() || t.Bytes()) n[m]++;
+    }
+    for (size_t m = 0; m <= g_modules.size(); ++m) {
+        Totals t = Summarize(mc[m]);
+        if (m == g_modules.size() && !n[m]) break;
+        if (m == g_modules.size())
+            r.modules.push_back({&unknown, t, n[m], nullptr});
+        else
+            r.modules.push_back({&g_modules[m], t, n[m],
+                                 g_modules[m].dynamic ? "dlopen" : "startup"});
+    }
+    std::stable_sort(r.modules.begin(), r.modules.end(),
+                     [](const ModuleRow& a, const ModuleRow& b)
+                     { return a.t.Weight() > b.t.Weight(); });
+}
+
+// -resume: the counts of the runs before this one, from their last
+// checkpoint.  Lines and regions get their ids when it is loaded; functions
+// are matched by name and image when a report is built, and those this run
+// has not reached (yet) get entries of their own.
+struct Carried {
+    std::string from;
+    UINT64      seq = 0;            // checkpoints written before
+    double      elapsed = 0;        // seconds the runs before took
+    Cnts        total{};
+    std::vector<std::pair<FuncInfo, Cnts>> funcs;
+    std::vector<std::pair<UINT32, Cnts>>   lines;     // g_lines id
+    std::vector<Cnts>   regions;                      // by region id
+    std::vector<UINT64> entries;
+    UINT64 mix[MIX_KINDS] = {};
+    UINT64 compound[COMPOUND_KINDS] = {};
+};
+static bool    g_resumed = false;
+static Carried g_carry;
+
+static VOID AddCarried(Cnts& total, std::vector<Cnts>& funcs, std::vector<Cnts>& lines)
+{
+    Accumulate(total, g_carry.total);
+    std::map<std::pair<std::string, std::string>, UINT32> ids;
+    for (UINT32 i = 0; i < g_funcs.size(); ++i) {
+        auto it = ids.emplace(std::make_pair(g_funcs[i].name, g_funcs[i].image), i).first;
+        if (g_funcs[it->second].carried && !g_funcs[i].carried) it->second = i;
+    }
+    for (const auto& f : g_carry.funcs) {
+        auto key = std::make_pair(f.first.name, f.first.image);
+        auto it = ids.find(key);
+        if (it == ids.end()) {
+            it = ids.emplace(key, static_cast<UINT32>(g_funcs.size())).first;
+            g_funcs.push_back(f.first);
+            g_funcs.back().carried = true;
+            funcs.push_back(Cnts{});
+        }
+        Accumulate(funcs[it->second], f.second);
+    }
+    for (const auto& l : g_carry.lines) Accumulate(lines[l.first], l.second);
+}
+
+// FoldSites sums every thread's counts, and what -resume carries over, into
+// the run's total and its functions, lines and loops.
+static VOID FoldSites(Cnts& total, std::vector<Cnts>& funcs, std::vector<Cnts>& lines,
+                      std::vector<Cnts>& loops)
+{
+    funcs.assign(g_funcs.size(), Cnts{});
+    lines.assign(g_lines.size(), Cnts{});
+    loops.assign(g_loops.size(), Cnts{});
+    for (auto* st : g_all) {
+        Accumulate(total, st->cnts);
+        for (size_t i = 0; i < st->sites.size(); ++i) {
+            const SiteInfo& si = g_sites[i];
+            Accumulate(funcs[si.func], st->sites[i]);
+            if (si.line != NO_SITE) Accumulate(lines[si.line], st->sites[i]);
+            if (si.loop != NO_SITE) Accumulate(loops[si.loop], st->sites[i]);
+        }
+    }
+    if (g_resumed) AddCarried(total, funcs, lines);
+}
+
+// Parses a sysfs CPU or node list such as "0-3,8,10-11"
+static std::vector<INT32> ParseRangeList(const std::string& s)
+{
+    std::vector<INT32> out;
+    std::istringstream in(s);
+    std::string r;
+    while (std::getline(in, r, ',')) {
+        char* end;
+        long lo = strtol(r.c_str(), &end, 10), hi = lo;
+        if (end == r.c_str()) continue;
+        if (*end == '-') hi = strtol(end + 1, nullptr, 10);
+        for (long i = lo; i <= hi; ++i) out.push_back(INT32(i));
+    }
+    return out;
+}
+
+// The NUMA node of each CPU from sysfs; a CPU it does not list, as on a
+// kernel without NUMA, is on node 0
+static std::map<INT32, INT32> NumaNodes()
+{
+    std::map<INT32, INT32> node;
+    std::ifstream online("/sys/devices/system/node/online");
+    std::string s;
+    if (!std::getline(online, s)) return node;
+    for (INT32 n : ParseRangeList(s)) {
+        std::ifstream in("/sys/devices/system/node/node" + std::to_string(n) + "/cpulist");
+        if (std::getline(in, s))
+            for (INT32 cpu : ParseRangeList(s)) node[cpu] = n;
+    }
+    return node;
+}
+
+// FoldCpus merges the threads' per-CPU counts, with what each has done
+// since its last charge on the CPU it was last seen on, into r's CPU and
+// node rows
+static VOID FoldCpus(Report& r)
+{
+    std::map<INT32, std::pair<Cnts, UINT32>> cpus;
+    for (auto* st : g_all) {
+        std::map<INT32, Cnts> own = st->cpus;
+        UINT64* dst = Words(own[st->cpu]);
+        const UINT64 *now = Words(st->cnts), *then = Words(st->cpu_mark);
+        for (size_t i = 0; i < NumWords(st->cnts); ++i) dst[i] += now[i] - then[i];
+        for (auto& kv : own) {
+            if (Zero(kv.second)) continue;
+            Accumulate(cpus[kv.first].first, kv.second);
+            cpus[kv.first].second++;
+        }
+    }
+    std::map<INT32, INT32> numa = NumaNodes();
+    std::map<INT32, std::pair<Cnts, UINT32>> nodes;
+    for (auto& kv : cpus) {
+        if (g_sampling) Scale(kv.second.first, r.sample.scale);
+        auto it = numa.find(kv.first);
+        INT32 node = kv.first < 0 ? -1 : it == numa.end() ? 0 : it->second;
+        r.cpus.push_back({kv.first, node, kv.second.second, Summarize(kv.second.first)});
+        if (node < 0) continue;
+        Accumulate(nodes[node].first, kv.second.first);
+        nodes[node].second++;
+    }
+    for (auto& kv : nodes)
+        r.nodes.push_back({kv.first, kv.second.second, Summarize(kv.second.first)});
+}
+
+// FoldRegions does the same for the regions and their entries
+static VOID FoldRegions(std::vector<Cnts>& rc, std::vector<UINT64>& entries)
+{
+    rc.assign(g_region_names.size(), Cnts{});
+    entries.assign(g_region_names.size(), 0);
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->regions.size(); ++i) {
+            Accumulate(rc[i], st->regions[i]);
+            entries[i] += st->entries[i];
+        }
+    for (size_t i = 0; i < g_carry.regions.size(); ++i) {
+        Accumulate(rc[i], g_carry.regions[i]);
+        entries[i] += g_carry.entries[i];
+    }
+}
+
+// BuildDiags gives r the g_diags and the counts it cannot attribute: those
+// of code in no routine Pin or a debug file names, and the -percpu counts
+// of threads whose CPU was never read
+static VOID BuildDiags(Report& r)
+{
+    r.diags = g_diags;
+    UINT64 unknown = 0;
+    for (const FuncRow& f : r.funcs)
+        if (f.info->name == "[unknown]") unknown += f.t.Weight();
+    if (unknown)   // a warning from 1% of the counts, as for the other backends
+        r.diags.push_back({100 * unknown >= r.total.Weight() ? "warn" : "info", "unresolved_symbols", "", unknown,
+                           "ops counted in code outside any named function, reported as [unknown]"});
+    for (const CpuRow& c : r.cpus)
+        if (c.cpu < 0)
+            r.diags.push_back({"warn", "dropped_samples", "", c.t.Weight(),
+                               "ops of " + std::to_string(c.threads) +
+                               " threads whose CPU was never read, reported under CPU ?"});
+}
+
+static Report BuildReport()
+{
+    Cnts total{};
+    std::vector<Cnts> funcs, lines, loops;
+    FoldSites(total, funcs, lines, loops);
+
+    Report r;
+    if (g_sampling) {
+        // every breakdown is extrapolated with the same instruction ratio
+        r.sample = Extrapolate();
+        Scale(total, r.sample.scale);
+        for (auto& c : funcs) Scale(c, r.sample.scale);
+        for (auto& c : lines) Scale(c, r.sample.scale);
+        for (auto& c : loops) Scale(c, r.sample.scale);
+    }
+    r.raw   = total;
+    r.total = Summarize(total);
+    r.wall_sec = g_carry.elapsed + std::chrono::duration<double>(
+                     std::chrono::steady_clock::now() - g_t0).count();
+    for (auto* st : g_all) {
+        Cnts c = st->cnts;
+        if (g_sampling) Scale(c, r.sample.scale);
+        r.threads.push_back({st, Summarize(c)});
+    }
+    if (g_percpu_on) FoldCpus(r);
+    for (size_t i = 0; i < funcs.size(); ++i) {
+        Totals t = Summarize(funcs[i]);
+        if (t.Sum() == 0 && t.BitSum() == 0 && t.VecSum() == 0 &&
+            t.FpSum() == 0 && t.WideSum() == 0 && t.Bytes() == 0 && t.WidthSum() == 0 &&
+            t.ClsSum() == 0 && t.AtomicSum() == 0) continue;
+        r.funcs.push_back({&g_funcs[i], t});
+        r.origin_funcs[g_funcs[i].origin]++;
+    }
+    if (g_modules_on) BuildModules(r, funcs);
+    BuildDiags(r);
+    if (g_go_on) {
+        Cnts oc[GO_ORIGINS]{};
+        for (size_t i = 0; i < funcs.size(); ++i) Accumulate(oc[g_funcs[i].origin], funcs[i]);
+        for (int k = 0; k < GO_ORIGINS; ++k) r.origin[k] = Summarize(oc[k]);
+    }
+    std::stable_sort(r.funcs.begin(), r.funcs.end(),
+                     [](const FuncRow& a, const FuncRow& b)
+                     { return a.t.Weight() > b.t.Weight(); });
+
+    if (g_calls_on) BuildCallGraph(r);
+
+    if (g_mode == REGIONS) {
+        std::vector<Cnts>   rc;
+        std::vector<UINT64> entries;
+        FoldRegions(rc, entries);
+        for (size_t i = 0; i < rc.size(); ++i) {
+            if (g_sampling) Scale(rc[i], r.sample.scale);
+            r.regions.push_back({&g_region_names[i], entries[i], Summarize(rc[i])});
+        }
+    }
+
+    if (g_py_on) {
+        std::vector<Cnts>   pc(g_py_funcs.size());
+        std::vector<UINT64> calls(g_py_funcs.size());
+        for (auto* st : g_all)
+            for (size_t i = 0; i < st->py_self.size(); ++i) {
+                Accumulate(pc[i], st->py_self[i]);
+                calls[i] += st->py_calls[i];
+            }
+        for (size_t i = 0; i < pc.size(); ++i) {
+            if (g_sampling) Scale(pc[i], r.sample.scale);
+            r.python.push_back({&g_py_funcs[i], calls[i], Summarize(pc[i])});
+        }
+        std::stable_sort(r.python.begin(), r.python.end(),
+                         [](const PyRow& a, const PyRow& b)
+                         { return a.t.Weight() > b.t.Weight(); });
+    }
+
+    for (size_t i = 0; i < g_phases.size(); ++i) {
+        bool last = i + 1 == g_phases.size();
+        const Cnts& end = last ? total : g_phases[i + 1].at;
+        Cnts c = end;
+        UINT64* dst = Words(c);
+        const UINT64* then = Words(g_phases[i].at);
+        for (size_t k = 0; k < NumWords(c); ++k) dst[k] -= then[k];
+        Totals t = Summarize(c);
+        if (t.Weight() == 0 && t.WideSum() == 0) continue;
+        r.phases.push_back({&g_phases[i].name, g_phases[i].start,
+                            last ? r.wall_sec : g_phases[i + 1].start, t});
+    }
+
+    for (size_t i = 0; i < lines.size(); ++i) {
+        Totals t = Summarize(lines[i]);
+        if (t.Sum() == 0 && t.BitSum() == 0 && t.VecSum() == 0 &&
+            t.FpSum() == 0 && t.WideSum() == 0 && t.Bytes() == 0 && t.ClsSum() == 0) continue;
+        r.lines.push_back({&g_lines[i], t});
+    }
+    std::sort(r.lines.begin(), r.lines.end(),
+              [](const LineRow& a, const LineRow& b)
+              { return a.info->file != b.info->file ? a.info->file < b.info->file
+                                                    : a.info->line < b.info->line; });
+
+    if (g_loops_on) BuildLoops(r, loops);
+    if (g_divs_on) BuildDivs(r);
+    if (g_branches) BuildBranches(r);
+    if (g_strides) BuildStrides(r);
+    if (g_reuse) BuildReuse(r);
+    if (g_foot_window) BuildFootprint(r);
+    if (g_bfly_on) BuildBfly(r);
+    if (g_blocks)  BuildBlocks(r);
+    if (!g_ann_pats.empty()) BuildAnnotated(r);
+    if (g_dfg_on)  BuildDfg(r);
+    if (g_dead_on) BuildDead(r);
+    r.cache_accesses.assign(g_cache_levels.size(), 0);
+    r.cache_misses.assign(g_cache_levels.size(), 0);
+    for (auto* st : g_all) {
+        r.mul_seen += st->mul_seen;
+        for (size_t i = 0; i < st->cache.size(); ++i) {
+            r.cache_accesses[i] += st->cache[i].accesses;
+            r.cache_misses[i]   += st->cache[i].misses;
+        }
+        for (int k = 0; k < COMPOUND_KINDS; ++k) r.compound[k] += st->compound[k];
+        for (int k = 0; k < MIX_KINDS; ++k) r.mix[k] += st->mix[k];
+        for (int k = 0; k < 2; ++k)
+            for (int w = 0; w < MUL_WIDTHS; ++w) r.mulw[k][w] += st->mulw[k][w];
+    }
+    for (int k = 0; k < COMPOUND_KINDS; ++k) r.compound[k] += g_carry.compound[k];
+    for (int k = 0; k < MIX_KINDS; ++k) r.mix[k] += g_carry.mix[k];
+    return r;
+}
+
+static const char* FP_OP_NAMES[FP_OPS]   = {"add", "sub", "mul", "div", "fma"};
+static const char* FP_PREC_NAMES[FP_PRECS] = {"fp64", "fp32"};
+static const char* WIDE_KIND_NAMES[WIDE_KINDS] = {"add", "sub", "mul"};
+static const char* VEC_OP_NAMES[VEC_OPS] = {"add", "sub", "mul"};
+static const char* MEM_KIND_NAMES[MEM_KINDS] = {"loads", "stores", "bytes_read", "bytes_written",
+                                                "dram_bytes_read", "dram_bytes_written"};
+
+// The memory kinds reported: the DRAM bytes only with -cache
+static inline int MemKinds() { return g_cache_on ? MEM_KINDS : MEM_ACCESS_KINDS; }
+
+static inline int WideBits(int slot) { return (slot + 2) * 64; }
+
+static const int WIDTH_BITS[WIDTH_SLOTS] = {8, 16, 32, 64, 128};
+
+// The -widths categories: add, sub, mul, div, then the -ops ones
+static inline const char* WidthCatName(int k)
+{
+    static const char* const base[4] = {"add", "sub", "mul", "div"};
+    return k < 4 ? base[k] : BIT_OP_NAMES[k - 4];
+}
+static inline bool WidthCatOn(int k) { return k < 4 || g_bit_on[k - 4]; }
+
+// ── operation budgets ───────────────────────────────────────────────────────
+// With -regions 1 the workload can declare what a region may cost:
+//   C / ctypes   Int64ProfilerAssertBudget(const char* region, const char* spec)
+//   Go           github.com/abe5240/iccad/client/roi.budget (RAX, RBX, RCX, RDI)
+// spec lists "op: limit" pairs, e.g. "{mul: 1e6, div: 0}".  Every exit of the
+// region checks the counts of that entry against the limits declared so
+// far; declaring an op again replaces its limit.  The report lists each
+// budget with the exits that broke it, and those make iccad fail the run.
+static const char* const C_BUDGET  = "Int64ProfilerAssertBudget";
+static const char* const GO_BUDGET = "github.com/abe5240/iccad/client/roi.budget";
+
+struct Budget {
+    std::string op;
+    UINT64      limit;
+    UINT64      checked = 0;        // region exits
+    UINT64      over = 0;           // of them over the limit
+    UINT64      worst = 0;          // most of op in one exit
+};
+static std::map<UINT32, std::vector<Budget>> g_budgets;       // by region id, under g_lock
+static std::map<UINT32, std::string>         g_budget_specs;  // the last spec of each region
+
+// BudgetCount sets n to t's count of op; false unless op names a counted
+// category.
+static bool BudgetCount(const Totals& t, const std::string& op, UINT64& n)
+{
+    if      (op == "add") n = t.add;
+    else if (op == "sub") n = t.sub;
+    else if (op == "mul") n = t.mul;
+    else if (op == "div") n = t.div;
+    else if (op == "int") n = t.Sum();
+    else if (op == "ops") n = t.Weight();
+    else if (op == "bitwise") n = t.BitSum();
+    else if (op == "vec"  && g_vec_on)  n = t.VecSum();
+    else if (op == "wide" && g_wide_on) n = t.WideSum();
+    else if (op == "fp64" && g_fp_on)   n = t.FpSum(FP64);
+    else if (op == "fp32" && g_fp_on)   n = t.FpSum(FP32);
+    else if (op == "fp"   && g_fp_on)   n = t.FpSum();
+    else if (op == "loads"  && g_mem_on) n = t.mem[MLOADS];
+    else if (op == "stores" && g_mem_on) n = t.mem[MSTORES];
+    else if (op == "bytes"  && g_mem_on) n = t.Bytes();
+    else {
+        for (int o = 0; o < BIT_OPS; ++o)
+            if (op == BIT_OP_NAMES[o] && g_bit_on[o]) {
+                n = t.bit[o];
+                return true;
+            }
+        return false;
+    }
+    return true;
+}
+
+static std::string Trim(const std::string& s)
+{
+    size_t b = s.find_first_not_of(" \t\n{}"), e = s.find_last_not_of(" \t\n{}");
+    return b == std::string::npos ? "" : s.substr(b, e - b + 1);
+}
+
+static VOID DeclareBudget(THREADID tid, const std::string& region, const std::string& spec)
+{
+    UINT32 id = RegionId(tid, region);
+    PIN_GetLock(&g_lock, tid + 1);
+    std::string& last = g_budget_specs[id];
+    if (last == spec) {             // a budget declared in a loop
+        PIN_ReleaseLock(&g_lock);
+        return;
+    }
+    last = spec;
+    std::vector<Budget>& bs = g_budgets[id];
+    std::istringstream is(spec);
+    for (std::string item; std::getline(is, item, ',');) {
+        size_t sep = item.find_first_of(":=");
+        std::string op = Trim(item.substr(0, sep));
+        if (op.empty() && sep == std::string::npos) continue;
+        char* end = nullptr;
+        std::string v = sep == std::string::npos ? "" : Trim(item.substr(sep + 1));
+        double limit = strtod(v.c_str(), &end);
+        UINT64 n;
+        if (v.empty() || *end || limit < 0 || limit >= 1.8e19 || !BudgetCount(Totals{}, op, n)) {
+            std::cerr << "Int64Profiler: ignoring budget '" << Trim(item) << "' of region "
+                      << region << " (not an op with a limit, or its category is not counted)\n";
+            continue;
+        }
+        auto it = std::find_if(bs.begin(), bs.end(), [&](const Budget& b) { return b.op == op; });
+        if (it != bs.end()) it->limit = UINT64(limit);
+        else                bs.push_back({op, UINT64(limit)});
+    }
+    g_budgets_on = true;
+    PIN_ReleaseLock(&g_lock);
+    DBG(2, "Budget of region " << region << ": " << spec);
+}
+
+static VOID CheckBudgets(UINT32 id, const Cnts& c)
+{
+    PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+    auto it = g_budgets.find(id);
+    if (it != g_budgets.end()) {
+        Totals t = Summarize(c);
+        for (auto& b : it->second) {
+            UINT64 n = 0;
+            BudgetCount(t, b.op, n);
+            b.checked++;
+            if (n > b.limit) b.over++;
+            b.worst = std::max(b.worst, n);
+        }
+    }
+    PIN_ReleaseLock(&g_lock);
+}
+
+static VOID CBudget(THREADID tid, ADDRINT r, ADDRINT s)
+{
+    DeclareBudget(tid, ReadName(r, 0), s ? ReadName(s, 0) : "");
+}
+static VOID GoBudget(THREADID tid, ADDRINT r, ADDRINT rn, ADDRINT s, ADDRINT sn)
+{
+    DeclareBudget(tid, rn ? ReadName(r, rn) : "default", sn ? ReadName(s, sn) : "");
+}
+
+static VOID InstrumentBudgetRtn(RTN rtn, VOID*)
+{
+    const std::string& name = RTN_Name(rtn);
+    if (name != C_BUDGET && name != GO_BUDGET) return;
+
+    DBG(1, "Found budget marker: " << name);
+    RTN_Open(rtn);
+    if (name == C_BUDGET)
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)CBudget,
+                       IARG_THREAD_ID, IARG_FUNCARG_ENTRYPOINT_VALUE, 0,
+                       IARG_FUNCARG_ENTRYPOINT_VALUE, 1, IARG_END);
+    else
+        RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)GoBudget,
+                       IARG_THREAD_ID, IARG_REG_VALUE, REG_RAX, IARG_REG_VALUE, REG_RBX,
+                       IARG_REG_VALUE, REG_RCX, IARG_REG_VALUE, REG_RDI, IARG_END);
+    RTN_Close(rtn);
+}
+
+// Over budget: kernel mul 1200000 > 1000000 in 3 of 10 exits
+static VOID PrintOverBudgetText(std::ostream& os)
+{
+    for (const auto& kv : g_budgets)
+        for (const auto& b : kv.second)
+            if (b.over)
+                os << "Over budget: " << g_region_names[kv.first] << ' ' << b.op << ' ' << b.worst
+                   << " > " << b.limit << " in " << b.over << " of " << b.checked << " exits\n";
+}
+
+static VOID PrintBudgetsText(std::ostream& os)
+{
+    os << "\n----- Operation budgets -----\n"
+       << std::setw(14) << "LIMIT" << std::setw(14) << "WORST" << std::setw(10) << "EXITS"
+       << std::setw(10) << "OVER" << "  OP        REGION\n";
+    for (const auto& kv : g_budgets)
+        for (const auto& b : kv.second)
+            os << std::setw(14) << b.limit << std::setw(14) << b.worst << std::setw(10) << b.checked
+               << std::setw(10) << b.over << "  " << std::left << std::setw(10) << b.op << std::right
+               << g_region_names[kv.first] << '\n';
+}
+
+// ── system-call time ────────────────────────────────────────────────────────
+// With -systime 1 the report sets the kernel's share of the run apart from
+// the counts: the wall time spent in each system call, summed over the
+// threads (blocked time included), and the process's kernel and user CPU
+// time since the tool started, from times(2).
+#if defined(TARGET_LINUX) && defined(TARGET_IA32E)
+// x86-64 Linux system call names by number, 0..334 and 424..450
+static const char* const SYSCALL_NAMES[] = {
+    "read", "write", "open", "close", "stat", "fstat", "lstat", "poll", "lseek", "mmap",
+    "mprotect", "munmap", "brk", "rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "ioctl",
+    "pread64", "pwrite64", "readv", "writev", "access", "pipe", "select", "sched_yield",
+    "mremap", "msync", "mincore", "madvise", "shmget", "shmat", "shmctl", "dup", "dup2",
+    "pause", "nanosleep", "getitimer", "alarm", "setitimer", "getpid", "sendfile", "socket",
+    "connect", "accept", "sendto", "recvfrom", "sendmsg", "recvmsg", "shutdown", "bind",
+    "listen", "getsockname", "getpeername", "socketpair", "setsockopt", "getsockopt", "clone",
+    "fork", "vfork", "execve", "exit", "wait4", "kill", "uname", "semget", "semop", "semctl",
+    "shmdt", "msgget", "msgsnd", "msgrcv", "msgctl", "fcntl", "flock", "fsync", "fdatasync",
+    "truncate", "ftruncate", "getdents", "getcwd", "chdir", "fchdir", "rename", "mkdir",
+    "rmdir", "creat", "link", "unlink", "symlink", "readlink", "chmod", "fchmod", "chown",
+    "fchown", "lchown", "umask", "gettimeofday", "getrlimit", "getrusage", "sysinfo", "times",
+    "ptrace", "getuid", "syslog", "getgid", "setuid", "setgid", "geteuid", "getegid", "setpgid",
+    "getppid", "getpgrp", "setsid", "setreuid", "setregid", "getgroups", "setgroups",
+    "setresuid", "getresuid", "setresgid", "getresgid", "getpgid", "setfsuid", "setfsgid",
+    "getsid", "capget", "capset", "rt_sigpending", "rt_sigtimedwait", "rt_sigqueueinfo",
+    "rt_sigsuspend", "sigaltstack", "utime", "mknod", "uselib", "personality", "ustat",
+    "statfs", "fstatfs", "sysfs", "getpriority", "setpriority", "sched_setparam",
+    "sched_getparam", "sched_setscheduler", "sched_getscheduler", "sched_get_priority_max",
+    "sched_get_priority_min", "sched_rr_get_interval", "mlock", "munlock", "mlockall",
+    "munlockall", "vhangup", "modify_ldt", "pivot_root", "_sysctl", "prctl", "arch_prctl",
+    "adjtimex", "setrlimit", "chroot", "sync", "acct", "settimeofday", "mount", "umount2",
+    "swapon", "swapoff", "reboot", "sethostname", "setdomainname", "iopl", "ioperm",
+    "create_module", "init_module", "delete_module", "get_kernel_syms", "query_module",
+    "quotactl", "nfsservctl", "getpmsg", "putpmsg", "afs_syscall", "tuxcall", "security",
+    "gettid", "readahead", "setxattr", "lsetxattr", "fsetxattr", "getxattr", "lgetxattr",
+    "fgetxattr", "listxattr", "llistxattr", "flistxattr", "removexattr", "lremovexattr",
+    "fremovexattr", "tkill", "time", "futex", "sched_setaffinity", "sched_getaffinity",
+    "set_thread_area", "io_setup", "io_destroy", "io_getevents", "io_submit", "io_cancel",
+    "get_thread_area", "lookup_dcookie", "epoll_create", "epoll_ctl_old", "epoll_wait_old",
+    "remap_file_pages", "getdents64", "set_tid_address", "restart_syscall", "semtimedop",
+    "fadvise64", "timer_create", "timer_settime", "timer_gettime", "timer_getoverrun",
+    "timer_delete", "clock_settime", "clock_gettime", "clock_getres", "clock_nanosleep",
+    "exit_group", "epoll_wait", "epoll_ctl", "tgkill", "utimes", "vserver", "mbind",
+    "set_mempolicy", "get_mempolicy", "mq_open", "mq_unlink", "mq_timedsend", "mq_timedreceive",
+    "mq_notify", "mq_getsetattr", "kexec_load", "waitid", "add_key", "request_key", "keyctl",
+    "ioprio_set", "ioprio_get", "inotify_init", "inotify_add_watch", "inotify_rm_watch",
+    "migrate_pages", "openat", "mkdirat", "mknodat", "fchownat", "futimesat", "newfstatat",
+    "unlinkat", "renameat", "linkat", "symlinkat", "readlinkat", "fchmodat", "faccessat",
+    "pselect6", "ppoll", "unshare", "set_robust_list", "get_robust_list", "splice", "tee",
+    "sync_file_range", "vmsplice", "move_pages", "utimensat", "epoll_pwait", "signalfd",
+    "timerfd_create", "eventfd", "fallocate", "timerfd_settime", "timerfd_gettime", "accept4",
+    "signalfd4", "eventfd2", "epoll_create1", "dup3", "pipe2", "inotify_init1", "preadv",
+    "pwritev", "rt_tgsigqueueinfo", "perf_event_open", "recvmmsg", "fanotify_init",
+    "fanotify_mark", "prlimit64", "name_to_handle_at", "open_by_handle_at", "clock_adjtime",
+    "syncfs", "sendmmsg", "setns", "getcpu", "process_vm_readv", "process_vm_writev", "kcmp",
+    "finit_module", "sched_setattr", "sched_getattr", "renameat2", "seccomp", "getrandom",
+    "memfd_create", "kexec_file_load", "bpf", "execveat", "userfaultfd", "membarrier", "mlock2",
+    "copy_file_range", "preadv2", "pwritev2", "pkey_mprotect", "pkey_alloc", "pkey_free",
+    "statx", "io_pgetevents", "rseq",
+};
+static const char* const SYSCALL_NAMES_424[] = {
+    "pidfd_send_signal", "io_uring_setup", "io_uring_enter", "io_uring_register", "open_tree",
+    "move_mount", "fsopen", "fsconfig", "fsmount", "fspick", "pidfd_open", "clone3",
+    "close_range", "openat2", "pidfd_getfd", "faccessat2", "process_madvise", "epoll_pwait2",
+    "mount_setattr", "quotactl_fd", "landlock_create_ruleset", "landlock_add_rule",
+    "landlock_restrict_self", "memfd_secret", "process_mrelease", "futex_waitv",
+    "set_mempolicy_home_node",
+};
+#endif
+
+static std::string SyscallName(INT64 nr)
+{
+#if defined(TARGET_LINUX) && defined(TARGET_IA32E)
+    const INT64 n1 = sizeof(SYSCALL_NAMES) / sizeof(*SYSCALL_NAMES);
+    const INT64 n2 = sizeof(SYSCALL_NAMES_424) / sizeof(*SYSCALL_NAMES_424);
+    if (nr >= 0 && nr < n1) return SYSCALL_NAMES[nr];
+    if (nr >= 424 && nr < 424 + n2) return SYSCALL_NAMES_424[nr - 424];
+#endif
+    return "syscall_" + std::to_string(nr);
+}
+
+#if !defined(TARGET_WINDOWS)
+static struct tms g_tms0;             // at tool start, so attached runs count from then
+#endif
+
+struct SysRow {
+    INT64   nr;
+    SysTime t;
+};
+
+// All threads' calls merged by number, the longest total time first
+static std::vector<SysRow> SysRows(SysTime& all)
+{
+    std::map<INT64, SysTime> by;
+    for (auto* st : g_all)
+        for (const auto& kv : st->sys) {
+            SysTime& t = by[kv.first];
+            t.calls  += kv.second.calls;
+            t.errors += kv.second.errors;
+            t.ns     += kv.second.ns;
+            t.max_ns = std::max(t.max_ns, kv.second.max_ns);
+        }
+    std::vector<SysRow> rows;
+    all = SysTime{};
+    for (const auto& kv : by) {
+        rows.push_back({kv.first, kv.second});
+        all.calls  += kv.second.calls;
+        all.errors += kv.second.errors;
+        all.ns     += kv.second.ns;
+        all.max_ns = std::max(all.max_ns, kv.second.max_ns);
+    }
+    std::stable_sort(rows.begin(), rows.end(),
+                     [](const SysRow& a, const SysRow& b) { return a.t.ns > b.t.ns; });
+    return rows;
+}
+
+// SysCpu sets user and kernel to the process's CPU seconds since the tool
+// started; false where times(2) is missing
+static bool SysCpu(double& user, double& kernel)
+{
+#if !defined(TARGET_WINDOWS)
+    struct tms t;
+    double hz = double(sysconf(_SC_CLK_TCK));
+    if (times(&t) == (clock_t)-1 || hz <= 0) return false;
+    user   = double(t.tms_utime - g_tms0.tms_utime) / hz;
+    kernel = double(t.tms_stime - g_tms0.tms_stime) / hz;
+    return true;
+#else
+    return false;
+#endif
+}
+
+static VOID PrintSysTimeText(std::ostream& os)
+{
+    SysTime all;
+    std::vector<SysRow> rows = SysRows(all);
+    os << "\n----- System calls -----\n"
+       << "The counts above are of user-mode instructions; the kernel work below is not counted.\n"
+       << "Calls:    " << all.calls << " (" << all.errors << " failed)\n"
+       << std::fixed << std::setprecision(3)
+       << "In calls: " << all.ns / 1e9 << " s wall, summed over threads\n";
+    double user, kernel;
+    if (SysCpu(user, kernel))
+        os << "CPU:      " << kernel << " s kernel, " << user << " s user (the tool's included)\n";
+    os << std::setw(12) << "CALLS" << std::setw(10) << "ERRORS" << std::setw(12) << "TIME_S"
+       << std::setw(12) << "AVG_US" << std::setw(12) << "MAX_US" << "  SYSCALL\n";
+    for (const auto& row : rows)
+        os << std::setw(12) << row.t.calls << std::setw(10) << row.t.errors
+           << std::setw(12) << row.t.ns / 1e9
+           << std::setw(12) << std::setprecision(1)
+           << (row.t.calls ? row.t.ns / 1e3 / row.t.calls : 0.0)
+           << std::setw(12) << row.t.max_ns / 1e3 << std::setprecision(3)
+           << "  " << SyscallName(row.nr) << '\n';
+    os << std::defaultfloat;
+}
+
+// The WriteCSV op type a block instruction counts as; empty if none
+static std::string BlockOpName(const BlockIns& bi)
+{
+    static const char* const ints[4] = {"add", "sub", "mul", "div"};
+    switch (bi.op_kind) {
+        case 'i': return ints[bi.op];
+        case 'b': return BIT_OP_NAMES[bi.op];
+        case 'v': return std::string("vec_") + VEC_OP_NAMES[bi.op];
+        case 'f': return std::string(FP_PREC_NAMES[bi.op / FP_OPS]) + '_' + FP_OP_NAMES[bi.op % FP_OPS];
+        default:  return "";
+    }
+}
+
+static std::string Upper(std::string s)
+{
+    std::transform(s.begin(), s.end(), s.begin(), ::toupper);
+    return s;
+}
+
+// Column headers / values for the categories selected with -ops
+static VOID BitHeaderText(std::ostream& os)
+{
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) os << std::setw(14) << Upper(BIT_OP_NAMES[o]);
+}
+
+static VOID BitColsText(std::ostream& os, const Totals& t)
+{
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) os << std::setw(14) << t.bit[o];
+}
+
+// INT/FP ratio column; "-" when no FP ops were counted
+static std::string IntFpRatio(const Totals& t)
+{
+    if (t.FpSum() == 0) return "-";
+    std::ostringstream os;
+    os << std::fixed << std::setprecision(2)
+       << double(t.Sum()) / double(t.FpSum());
+    return os.str();
+}
+
+// Arithmetic intensity: integer ops (add..div plus vector lanes) or FP
+// lane ops per byte moved; "-" when no bytes were moved
+static std::string OpsPerByte(UINT64 ops, UINT64 bytes)
+{
+    if (bytes == 0) return "-";
+    std::ostringstream os;
+    os << std::fixed << std::setprecision(4) << double(ops) / double(bytes);
+    return os.str();
+}
+
+static std::string OpsPerByte(UINT64 ops, const Totals& t) { return OpsPerByte(ops, t.Bytes()); }
+
+static inline UINT64 IntOps(const Totals& t) { return t.Sum() + t.VecSum(); }
+
+static VOID PrintFpText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Floating point (lane ops) -----\n" << std::setw(6) << "";
+    for (const char* n : FP_OP_NAMES) os << std::setw(14) << Upper(n);
+    os << '\n';
+    for (int p = 0; p < FP_PRECS; ++p) {
+        os << std::setw(6) << (p == FP64 ? "FP64" : "FP32");
+        for (int o = 0; o < FP_OPS; ++o) os << std::setw(14) << r.total.fp[p][o];
+        os << '\n';
+    }
+    os << "INT/FP ratio: " << IntFpRatio(r.total) << '\n';
+}
+
+static VOID PrintWideText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Wide-integer operations (estimated) -----\n" << std::setw(6) << "";
+    for (int w = 0; w < WIDE_SLOTS; ++w)
+        os << std::setw(14) << (std::to_string(WideBits(w)) +
+                                (w == WIDE_SLOTS - 1 ? "+-bit" : "-bit"));
+    os << '\n';
+    for (int k = 0; k < WIDE_KINDS; ++k) {
+        os << std::setw(6) << Upper(WIDE_KIND_NAMES[k]);
+        for (int w = 0; w < WIDE_SLOTS; ++w) os << std::setw(14) << r.total.wide[k][w];
+        os << '\n';
+    }
+}
+
+static VOID PrintWidthsText(std::ostream& os, const Report& r)
+{
+    auto header = [&]() {
+        os << std::setw(6) << "";
+        for (int w = 0; w < WIDTH_SLOTS; ++w)
+            if (g_width_on[w]) os << std::setw(14) << (std::to_string(WIDTH_BITS[w]) + "-bit");
+    };
+    os << "\n----- Operand widths -----\n";
+    header();
+    os << '\n';
+    for (int k = 0; k < WIDTH_CATS; ++k) {
+        if (!WidthCatOn(k)) continue;
+        os << std::setw(6) << Upper(WidthCatName(k));
+        for (int w = 0; w < WIDTH_SLOTS; ++w)
+            if (g_width_on[w]) os << std::setw(14) << r.total.width[k][w];
+        os << '\n';
+    }
+    if (!g_funcs_on) return;
+    os << "\n----- Operand widths by function -----\n";
+    header();
+    os << "  FUNCTION\n";
+    for (const auto& f : r.funcs) {
+        os << std::setw(6) << "";
+        for (int w = 0; w < WIDTH_SLOTS; ++w) {
+            if (!g_width_on[w]) continue;
+            UINT64 n = 0;
+            for (int k = 0; k < WIDTH_CATS; ++k)
+                if (WidthCatOn(k)) n += f.t.width[k][w];
+            os << std::setw(14) << n;
+        }
+        os << "  " << f.info->name << '\n';
+    }
+}
+
+static VOID PrintSignText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Signed vs unsigned -----\n"
+       << std::setw(6) << "" << std::setw(14) << "SIGNED" << std::setw(14) << "UNSIGNED" << '\n';
+    for (int k = 0; k < 2; ++k)
+        os << std::setw(6) << (k ? "DIV" : "MUL") << std::setw(14) << r.total.sign[k][SSIGNED]
+           << std::setw(14) << r.total.sign[k][SUNSIGNED] << '\n';
+}
+
+static VOID PrintAtomicsText(std::ostream& os, const Report& r)
+{
+    const Totals& t = r.total;
+    UINT64 ops = t.Sum() + t.BitSum();
+    os << "\n----- Synchronization -----\n"
+       << "Atomic RMW:    " << t.atomic[AT_RMW] << '\n'
+       << "Futex calls:   " << t.atomic[AT_FUTEX] << '\n';
+    if (ops) os << "Per int op:    " << std::fixed << std::setprecision(4)
+                << double(t.AtomicSum()) / double(ops) << std::defaultfloat << '\n';
+}
+
+static VOID PrintVecText(std::ostream& os, const Report& r)
+{
+    const UINT64 scalar[VEC_OPS] = {r.total.add, r.total.sub, r.total.mul};
+    os << "\n----- Scalar vs vector (int64 lane ops) -----\n"
+       << std::setw(6) << "" << std::setw(14) << "SCALAR" << std::setw(14) << "VECTOR" << '\n';
+    for (int v = 0; v < VEC_OPS; ++v)
+        os << std::setw(6) << Upper(VEC_OP_NAMES[v])
+           << std::setw(14) << scalar[v] << std::setw(14) << r.total.vec[v] << '\n';
+}
+
+static VOID PrintMemText(std::ostream& os, const Report& r)
+{
+    const Totals& t = r.total;
+    os << "\n----- Memory -----\n"
+       << "Loads:         " << t.mem[MLOADS]  << " (" << t.mem[MBYTES_R] << " bytes)\n"
+       << "Stores:        " << t.mem[MSTORES] << " (" << t.mem[MBYTES_W] << " bytes)\n"
+       << "INT ops/byte:  " << OpsPerByte(IntOps(t), t) << '\n';
+    if (g_fp_on) os << "FP ops/byte:   " << OpsPerByte(t.FpSum(), t) << '\n';
+}
+
+// "12.3%", "0.0%" of nothing
+static std::string Percent(UINT64 n, UINT64 all)
+{
+    std::ostringstream os;
+    os << std::fixed << std::setprecision(1) << (all ? 100.0 * n / all : 0.0) << '%';
+    return os.str();
+}
+
+// 32K, 1M, …: a cache size in the largest unit dividing it
+static std::string SizeName(UINT64 size)
+{
+    static const char* UNITS = "BKMG";
+    int u = 0;
+    while (u < 3 && size >= 1024 && size % 1024 == 0) { size /= 1024; ++u; }
+    return std::to_string(size) + (u ? std::string(1, UNITS[u]) : "");
+}
+
+static const size_t FOOT_TEXT_ROWS = 20;    // groups of the curve, and functions, printed
+
+// The curve in at most FOOT_TEXT_ROWS groups of windows, each with the
+// fullest window in it; then the phases, and the functions touching most
+static VOID PrintFootprintText(std::ostream& os, const Report& r)
+{
+    const auto& curve = r.foot_curve;
+    UINT64 sum = 0;
+    for (const auto& w : curve) sum += w.first;
+    os << "\n----- Working set (" << FOOT_LINE << "-byte lines, " << FOOT_PAGE / 1024 << "K pages, "
+       << g_foot_window << "-access windows) -----\n"
+       << "Footprint:   " << r.foot.lines << " lines (" << r.foot.lines * FOOT_LINE << " bytes), "
+       << r.foot.pages << " pages (" << r.foot.pages * FOOT_PAGE << " bytes)\n"
+       << "Windows:     " << curve.size() << ", mean " << (curve.empty() ? 0 : sum / curve.size())
+       << " lines, peak " << r.foot.peak_lines << " lines (" << r.foot.peak_lines * FOOT_LINE
+       << " bytes), " << r.foot.peak_pages << " pages\n";
+    const size_t group = (curve.size() + FOOT_TEXT_ROWS - 1) / FOOT_TEXT_ROWS;
+    if (!curve.empty())
+        os << std::left << std::setw(16) << "WINDOWS" << std::right << std::setw(12) << "LINES"
+           << std::setw(10) << "PAGES" << '\n';
+    for (size_t i = 0; i < curve.size(); i += group) {
+        const size_t end = std::min(curve.size(), i + group);
+        UINT64 lines = 0, pages = 0;
+        for (size_t k = i; k < end; ++k) {
+            lines = std::max(lines, curve[k].first);
+            pages = std::max(pages, curve[k].second);
+        }
+        std::string span = std::to_string(i + 1);
+        if (end > i + 1) span += "-" + std::to_string(end);
+        os << std::left << std::setw(16) << span << std::right << std::setw(12) << lines
+           << std::setw(10) << pages << '\n';
+    }
+    auto row = [&](const FootRow& f, const std::string& name) {
+        os << std::setw(12) << f.lines << std::setw(10) << f.pages << std::setw(12) << f.peak_lines
+           << std::setw(10) << f.peak_pages << "  " << name << '\n';
+    };
+    auto header = [&](const char* scope) {
+        os << std::setw(12) << "LINES" << std::setw(10) << "PAGES" << std::setw(12) << "PEAK LINES"
+           << std::setw(10) << "PAGES" << "  " << scope << '\n';
+    };
+    if (!r.foot_phases.empty()) {
+        header("PHASE");
+        for (const auto& p : r.foot_phases) row(p, *p.phase);
+    }
+    if (!r.foot_funcs.empty()) header("FUNCTION");
+    for (size_t i = 0; i < r.foot_funcs.size() && i < FOOT_TEXT_ROWS; ++i)
+        row(r.foot_funcs[i], g_funcs[r.foot_funcs[i].func].name);
+}
+
+static VOID PrintCacheText(std::ostream& os, const Report& r)
+{
+    const Totals& t = r.total;
+    os << "\n----- Cache simulation (" << g_cache_line << "-byte lines) -----\n"
+       << std::left << std::setw(8) << "LEVEL" << std::right << std::setw(8) << "SIZE"
+       << std::setw(6) << "WAYS" << std::setw(14) << "ACCESSES" << std::setw(14) << "MISSES"
+       << std::setw(8) << "HIT" << '\n';
+    for (size_t i = 0; i < g_cache_levels.size(); ++i) {
+        const CacheConfig& c = g_cache_levels[i];
+        os << std::left << std::setw(8) << c.name << std::right << std::setw(8) << SizeName(c.size)
+           << std::setw(6) << c.ways << std::setw(14) << r.cache_accesses[i]
+           << std::setw(14) << r.cache_misses[i]
+           << std::setw(8) << Percent(r.cache_accesses[i] - r.cache_misses[i], r.cache_accesses[i])
+           << '\n';
+    }
+    os << "DRAM read:     " << t.mem[MDRAM_R] << " bytes\n"
+       << "DRAM written:  " << t.mem[MDRAM_W] << " bytes\n"
+       << "INT ops/DRAM byte: " << OpsPerByte(IntOps(t), t.DramBytes()) << '\n';
+    if (g_fp_on) os << "FP ops/DRAM byte:  " << OpsPerByte(t.FpSum(), t.DramBytes()) << '\n';
+}
+
+static const char* MOD_MUL_NAMES[MOD_MULS] = {"montgomery", "barrett", "shoup", "division"};
+
+// The instructions of no -mix kind: arithmetic, logic, compares, …
+static UINT64 MixOther(const Report& r)
+{
+    UINT64 n = r.mix[MX_INSNS];
+    for (int k = MX_TAKEN; k < MIX_KINDS; ++k)
+        if (k != MX_INDIRECT) n -= r.mix[k];
+    return n;
+}
+
+static VOID PrintMixText(std::ostream& os, const Report& r)
+{
+    const UINT64 all = r.mix[MX_INSNS];
+    os << "\n----- Instruction mix -----\n"
+       << std::left << std::setw(18) << "KIND" << std::right << std::setw(14) << "COUNT"
+       << std::setw(8) << "PCT" << '\n';
+    auto row = [&](const std::string& kind, UINT64 n) {
+        os << std::left << std::setw(18) << kind << std::right << std::setw(14) << n
+           << std::setw(8) << Percent(n, all) << '\n';
+    };
+    for (int k = MX_TAKEN; k < MIX_KINDS; ++k)
+        row(k == MX_INDIRECT ? "  indirect" : MIX_KIND_NAMES[k], r.mix[k]);
+    row("other", MixOther(r));
+    row("total", all);
+}
+
+static VOID PrintModText(std::ostream& os, const Report& r)
+{
+    const Totals& t = r.total;
+    os << "\n----- Modular arithmetic -----\n"
+       << "MODMUL:  " << std::setw(14) << t.ModMuls() << '\n';
+    for (int k = 0; k < MOD_MULS; ++k)
+        os << "  " << std::left << std::setw(12) << MOD_MUL_NAMES[k] << std::right
+           << std::setw(9) << t.mod[k] << '\n';
+    os << "MODADD:  " << std::setw(14) << t.mod[MOD_ADD] << '\n'
+       << "MODSUB:  " << std::setw(14) << t.mod[MOD_SUB] << '\n';
+}
+
+static VOID PrintBflyText(std::ostream& os, const Report& r)
+{
+    UINT64 total = 0;
+    for (const auto& b : r.bfly) total += b.Butterflies();
+    os << "\n----- NTT butterflies -----\n"
+       << "Butterflies: " << total << '\n'
+       << std::setw(14) << "BUTTERFLIES" << std::setw(10) << "CALLS"
+       << std::setw(12) << "PER CALL" << std::setw(10) << "SIZE" << "  FUNCTION\n";
+    for (const auto& b : r.bfly) {
+        UINT64 n = BflySize(b.per_call);
+        os << std::setw(14) << b.Butterflies() << std::setw(10) << b.calls
+           << std::setw(12) << b.per_call << std::setw(10) << (n ? std::to_string(n) : "-")
+           << "  " << b.info->name;
+        if (!b.info->file.empty())
+            os << "  (" << b.info->file << ':' << b.info->line << ')';
+        else if (!b.info->image.empty())
+            os << "  [" << b.info->image << ']';
+        os << '\n';
+    }
+}
+
+// "mul mat+0x2a", "load dw+0x8"
+static std::string DfgLabel(const DfgNode& n)
+{
+    std::ostringstream os;
+    std::string op = BlockOpName(n.ins);
+    os << (op.empty() ? "load" : op) << ' ' << g_funcs[n.func].name << "+0x" << std::hex << n.ins.offset;
+    return os.str();
+}
+
+static VOID PrintDfgText(std::ostream& os, const Report& r)
+{
+    size_t loads = 0;
+    for (const auto& n : r.dfg) loads += n.first->ins.op_kind == 0;
+    os << "\n----- Dataflow graph -----\n"
+       << "Nodes: " << r.dfg.size() << " (" << r.dfg.size() - loads << " ops, " << loads << " loads)"
+       << "  Edges: " << r.dfg_edges.size() << '\n'
+       << std::setw(14) << "COUNT" << "  EDGE (10 most frequent)\n";
+    for (size_t i = 0; i < r.dfg_edges.size() && i < 10; ++i) {
+        const DfgEdge& e = r.dfg_edges[i];
+        os << std::setw(14) << e.count << "  " << DfgLabel(*r.dfg[e.from].first)
+           << " -> " << DfgLabel(*r.dfg[e.to].first) << '\n';
+    }
+}
+
+static VOID PrintDeadText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Dead work -----\n"
+       << "Results: " << r.dead_results << ", dead " << r.dead << " (" << Percent(r.dead, r.dead_results)
+       << "), live at exit " << r.dead_live << '\n'
+       << std::setw(14) << "RESULTS" << std::setw(14) << "DEAD" << std::setw(8) << "DEAD%" << "  FUNCTION\n";
+    for (size_t i = 0; i < r.dead_funcs.size() && i < 10; ++i) {
+        const DeadRow& f = r.dead_funcs[i];
+        os << std::setw(14) << f.results << std::setw(14) << f.dead
+           << std::setw(8) << Percent(f.dead, f.results) << "  " << g_funcs[f.func].name << '\n';
+    }
+    if (r.dead_sites.empty()) return;
+    os << std::setw(14) << "DEAD" << std::setw(8) << "DEAD%" << "  INSTRUCTION (10 most dead)\n";
+    for (size_t i = 0; i < r.dead_sites.size() && i < 10; ++i) {
+        const DeadRow& s = r.dead_sites[i];
+        os << std::setw(14) << s.dead << std::setw(8) << Percent(s.dead, s.results) << "  "
+           << BlockOpName(s.info->ins) << ' ' << g_funcs[s.func].name << "+0x" << std::hex
+           << s.info->ins.offset << std::dec << "  " << s.info->ins.disasm
+           << "  (" << s.info->line.file << ':' << s.info->line.line << ")\n";
+    }
+}
+
+static VOID PrintBlocksText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Hot basic blocks -----\n"
+       << std::setw(14) << "OPS" << std::setw(14) << "EXECUTIONS"
+       << std::setw(10) << "OPS/EXEC" << std::setw(7) << "INSNS" << "  BLOCK\n";
+    for (const auto& b : r.blocks) {
+        os << std::setw(14) << b.Ops() << std::setw(14) << b.execs
+           << std::setw(10) << b.info->ops << std::setw(7) << b.info->ins.size()
+           << "  " << g_funcs[b.info->func].name << "+0x" << std::hex << b.info->func_offset << std::dec;
+        if (b.info->line.line > 0) os << "  (" << b.info->line.file << ':' << b.info->line.line << ')';
+        os << '\n';
+        for (const auto& in : b.info->ins) {
+            std::string op = BlockOpName(in);
+            if (in.lanes > 1) op += " x" + std::to_string(in.lanes);
+            std::ostringstream off;
+            off << "+0x" << std::hex << in.offset;
+            os << std::setw(20) << off.str() << "  ";
+            if (op.empty()) os << in.disasm << '\n';
+            else os << std::left << std::setw(40) << in.disasm << std::right << op << '\n';
+        }
+    }
+}
+
+// Each annotated function in full; the file:line above an instruction
+// marks where the source line changes
+static VOID PrintAnnotatedText(std::ostream& os, const Report& r)
+{
+    for (const auto& a : r.annotated) {
+        const FuncInfo& f = g_funcs[a.func->func];
+        os << "\n----- Annotated disassembly: " << f.name << " (" << f.image << ") -----\n"
+           << std::setw(14) << "EXECUTIONS" << std::setw(8) << "PCT" << "  "
+           << std::left << std::setw(12) << "OP" << std::right << std::setw(10) << "OFFSET"
+           << "  INSTRUCTION\n";
+        const LineInfo* last = nullptr;
+        for (size_t i = 0; i < a.func->ins.size(); ++i) {
+            const AnnIns& in = a.func->ins[i];
+            if (in.line.line > 0 && (!last || last->line != in.line.line || last->file != in.line.file))
+                os << std::string(48, ' ') << in.line.file << ':' << in.line.line << '\n';
+            last = &in.line;
+            std::string op = BlockOpName(in.ins);
+            if (in.ins.lanes > 1) op += " x" + std::to_string(in.ins.lanes);
+            std::ostringstream off;
+            off << "+0x" << std::hex << in.ins.offset;
+            if (a.execs[i]) os << std::setw(14) << a.execs[i] << std::setw(8) << Percent(a.execs[i], a.total);
+            else os << std::string(22, ' ');
+            os << "  " << std::left << std::setw(12) << op << std::right << std::setw(10) << off.str()
+               << "  " << in.ins.disasm << '\n';
+        }
+    }
+}
+
+// The compound kinds reported: those of the enabled categories
+static bool CompoundShown(int k)
+{
+    if (k == CFMA64 || k == CFMA32) return g_fp_on;
+    if (k == CVMADD) return g_vec_on;
+    return true;
+}
+
+static VOID PrintCompoundText(std::ostream& os, const Report& r)
+{
+    static const char* const as[COMPOUND_KINDS] = {
+        "fp64 mul + fp64 add", "fp32 mul + fp32 add", "vec mul + vec add", "add", "shl (also in lea)"};
+    os << "\n----- Compound instructions (" << COMPOUND_POLICY_NAMES[g_compound] << ") -----\n"
+       << std::left << std::setw(12) << "KIND" << std::right << std::setw(14) << "COUNT"
+       << "  SPLIT INTO\n";
+    for (int k = 0; k < COMPOUND_KINDS; ++k)
+        if (CompoundShown(k))
+            os << std::left << std::setw(12) << COMPOUND_KIND_NAMES[k] << std::right
+               << std::setw(14) << r.compound[k] << "  " << as[k] << '\n';
+}
+
+static VOID PrintAgenText(std::ostream& os, const Report& r)
+{
+    const UINT64* a = r.total.agen;
+    os << "\n----- Address generation (" << AGEN_MODE_NAMES[g_agen] << ") -----\n"
+       << std::left << std::setw(12) << "SOURCE" << std::right << std::setw(14) << "ADDS"
+       << std::setw(14) << "SHIFTS" << '\n';
+    auto row = [&](const char* src, UINT64 adds, UINT64 shifts) {
+        os << std::left << std::setw(12) << src << std::right << std::setw(14) << adds
+           << std::setw(14) << shifts << '\n';
+    };
+    row("lea", a[AG_LEA_ADD], a[AG_LEA_SHL]);
+    row("memory", a[AG_MEM_ADD], a[AG_MEM_SHL]);
+    row("total", a[AG_LEA_ADD] + a[AG_MEM_ADD], a[AG_LEA_SHL] + a[AG_MEM_SHL]);
+}
+
+static VOID PrintDivsText(std::ostream& os, const Report& r)
+{
+    UINT64 n[DIV_CLASSES] = {}, sites[DIV_CLASSES] = {}, all = 0;
+    for (const auto& d : r.divs) {
+        n[d.Class()] += d.n;
+        sites[d.Class()]++;
+        all += d.n;
+    }
+    os << "\n----- Divisions by divisor -----\n";
+    for (int k = 0; k < DIV_CLASSES; ++k) {
+        os << std::left << std::setw(10) << Upper(DIV_CLASS_NAMES[k]) + ':' << std::right
+           << std::setw(14) << n[k] << std::setw(8) << Percent(n[k], all) << "  (" << sites[k]
+           << (sites[k] == 1 ? " site)\n" : " sites)\n");
+    }
+
+    os << std::setw(14) << "DIVISIONS" << std::setw(10) << "DISTINCT"
+       << std::setw(22) << "DIVISOR" << std::setw(10) << "CLASS" << "  LOCATION\n";
+    for (size_t i = 0; i < r.divs.size() && i < 10; ++i) {
+        const DivRow& d = r.divs[i];
+        std::string distinct = std::to_string(d.vals.size()) + (d.more ? "+" : "");
+        std::string divisor = "-";
+        if (d.Constant())
+            divisor = d.info->is_signed ? std::to_string(static_cast<INT64>(d.vals[0]))
+                                        : std::to_string(d.vals[0]);
+        os << std::setw(14) << d.n << std::setw(10) << distinct
+           << std::setw(22) << divisor << std::setw(10) << DIV_CLASS_NAMES[d.Class()]
+           << "  " << g_funcs[d.info->func].name
+           << "  (" << d.info->line.file << ':' << d.info->line.line << ")\n";
+    }
+}
+
+// The most missed branches, with the misses of both predictors by rate
+static VOID PrintBranchesText(std::ostream& os, const Report& r)
+{
+    UINT64 n = 0, taken = 0, bimodal = 0, local = 0;
+    for (const auto& b : r.branches) {
+        n += b.n;
+        taken += b.taken;
+        bimodal += b.miss_bimodal;
+        local += b.miss_local;
+    }
+    os << "\n----- Branch predictability -----\n"
+       << "Branches:     " << r.branches.size() << (r.branches.size() == 1 ? " site, " : " sites, ")
+       << n << " executions, " << Percent(taken, n) << " taken\n"
+       << "Mispredicted: " << bimodal << " bimodal (" << Percent(bimodal, n) << "), "
+       << local << " local history (" << Percent(local, n) << ")\n"
+       << std::setw(14) << "EXECUTIONS" << std::setw(8) << "TAKEN" << std::setw(9) << "ENTROPY"
+       << std::setw(12) << "MISSES" << std::setw(9) << "BIMODAL" << std::setw(8) << "LOCAL"
+       << "  DIR   LOOP  LOCATION\n";
+    for (size_t i = 0; i < r.branches.size() && i < g_branches; ++i) {
+        const BranchRow& b = r.branches[i];
+        std::string loop = "-";
+        if (b.info->loop != NO_SITE) loop = "d" + std::to_string(g_loops[b.info->loop].depth);
+        os << std::setw(14) << b.n << std::setw(8) << Percent(b.taken, b.n)
+           << std::setw(9) << std::fixed << std::setprecision(3) << b.Entropy() << std::defaultfloat
+           << std::setw(12) << b.miss_bimodal << std::setw(9) << Percent(b.miss_bimodal, b.n)
+           << std::setw(8) << Percent(b.miss_local, b.n)
+           << "  " << (b.info->backward ? "back" : "fwd ") << std::setw(6) << loop
+           << "  " << g_funcs[b.info->func].name
+           << "  (" << b.info->line.file << ':' << b.info->line.line << ")\n";
+    }
+}
+
+// "unit" when at least 90% of a loop's classified accesses are unit
+// stride, "strided" when 90% are unit or constant stride, else "irregular"
+static const char* StridePattern(UINT64 unit, UINT64 constant, UINT64 irregular)
+{
+    const UINT64 n = unit + constant + irregular;
+    if (unit * 10 >= n * 9) return "unit";
+    if ((unit + constant) * 10 >= n * 9) return "strided";
+    return "irregular";
+}
+
+static VOID PrintStridesText(std::ostream& os, const Report& r)
+{
+    UINT64 unit = 0, constant = 0, irregular = 0;
+    for (const auto& l : r.strides) {
+        unit += l.unit;
+        constant += l.constant;
+        irregular += l.irregular;
+    }
+    const UINT64 n = unit + constant + irregular;
+    os << "\n----- Access patterns (loops) -----\n"
+       << "Accesses:  " << n << " classified in " << r.strides.size()
+       << (r.strides.size() == 1 ? " loop: " : " loops: ") << Percent(unit, n) << " unit stride, "
+       << Percent(constant, n) << " constant stride, " << Percent(irregular, n) << " irregular\n"
+       << std::setw(14) << "ACCESSES" << std::setw(8) << "UNIT" << std::setw(10) << "CONSTANT"
+       << std::setw(11) << "IRREGULAR" << std::setw(7) << "SITES" << "  PATTERN    DEPTH  LOOP\n";
+    for (size_t i = 0; i < r.strides.size() && i < g_strides; ++i) {
+        const StrideRow& l = r.strides[i];
+        const UINT64 c = l.Classified();
+        os << std::setw(14) << c << std::setw(8) << Percent(l.unit, c)
+           << std::setw(10) << Percent(l.constant, c) << std::setw(11) << Percent(l.irregular, c)
+           << std::setw(7) << l.sites << "  " << std::left << std::setw(11)
+           << StridePattern(l.unit, l.constant, l.irregular) << std::right << std::setw(5) << l.info->depth
+           << "  " << g_funcs[l.info->func].name << "+0x" << std::hex << l.info->offset << std::dec;
+        if (l.info->line.line > 0) os << "  (" << l.info->line.file << ':' << l.info->line.line << ')';
+        os << '\n';
+    }
+}
+
+// REPEAT% is the share of a loop's ops found in the reuse buffer, SAME%
+// that with the operands of the same instruction's previous execution
+static VOID PrintReuseText(std::ostream& os, const Report& r)
+{
+    UINT64 n = 0, same = 0, hits = 0;
+    for (const auto& l : r.reuse) {
+        n += l.n;
+        same += l.same;
+        hits += l.hits;
+    }
+    os << "\n----- Repeated computations (loops) -----\n"
+       << "Ops:  " << n << " checked in " << r.reuse.size() << (r.reuse.size() == 1 ? " loop: " : " loops: ")
+       << Percent(hits, n) << " repeated (" << REUSE_ENTRIES << "-entry reuse buffer), "
+       << Percent(same, n) << " same as last time\n"
+       << std::setw(14) << "OPS" << std::setw(14) << "REPEATED" << std::setw(8) << "REPEAT%"
+       << std::setw(8) << "SAME%" << std::setw(7) << "SITES" << "  DEPTH  LOOP\n";
+    for (size_t i = 0; i < r.reuse.size() && i < g_reuse; ++i) {
+        const ReuseRow& l = r.reuse[i];
+        os << std::setw(14) << l.n << std::setw(14) << l.hits << std::setw(8) << Percent(l.hits, l.n)
+           << std::setw(8) << Percent(l.same, l.n) << std::setw(7) << l.sites << std::setw(7) << l.info->depth
+           << "  " << g_funcs[l.info->func].name << "+0x" << std::hex << l.info->offset << std::dec;
+        if (l.info->line.line > 0) os << "  (" << l.info->line.file << ':' << l.info->line.line << ')';
+        os << '\n';
+    }
+    if (r.reuse_sites.empty() || !r.reuse_sites[0].hits) return;
+    os << std::setw(14) << "REPEATED" << std::setw(8) << "REPEAT%" << std::setw(8) << "SAME%"
+       << "  INSTRUCTION (10 most repeated)\n";
+    for (size_t i = 0; i < r.reuse_sites.size() && i < 10 && r.reuse_sites[i].hits; ++i) {
+        const ReuseRow& s = r.reuse_sites[i];
+        os << std::setw(14) << s.hits << std::setw(8) << Percent(s.hits, s.n) << std::setw(8) << Percent(s.same, s.n)
+           << "  " << BlockOpName(s.site->ins) << ' ' << g_funcs[s.site->func].name << "+0x" << std::hex
+           << s.site->ins.offset << std::dec << "  " << s.site->ins.disasm
+           << "  (" << s.site->line.file << ':' << s.site->line.line << ")\n";
+    }
+}
+
+// Widths in 8-bit bands; FITS is the share of multiplies whose wider
+// (narrower) operand fits the band's upper width
+static VOID PrintMulValsText(std::ostream& os, const Report& r)
+{
+    UINT64 sampled = 0;
+    for (int w = 0; w < MUL_WIDTHS; ++w) sampled += r.mulw[0][w];
+    os << "\n----- Multiply operand widths -----\n"
+       << "Sampled: " << sampled << " of " << r.mul_seen << " multiplies\n"
+       << std::setw(8) << "BITS" << std::setw(14) << "WIDER" << std::setw(8) << "FITS"
+       << std::setw(14) << "NARROWER" << std::setw(8) << "FITS" << '\n';
+    UINT64 cum[2] = {};
+    for (int hi = 8; hi < MUL_WIDTHS; hi += 8) {
+        UINT64 band[2] = {};
+        for (int k = 0; k < 2; ++k)
+            for (int w = hi == 8 ? 0 : hi - 7; w <= hi; ++w) band[k] += r.mulw[k][w];
+        os << std::setw(8) << std::to_string(hi == 8 ? 0 : hi - 7) + '-' + std::to_string(hi);
+        for (int k = 0; k < 2; ++k) {
+            cum[k] += band[k];
+            os << std::setw(14) << band[k] << std::setw(8) << Percent(cum[k], sampled);
+        }
+        os << '\n';
+    }
+}
+
+static VOID PrintGoText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Go code by origin -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(8) << "SHARE" << std::setw(8) << "FUNCS" << "  ORIGIN\n";
+    UINT64 all = 0;
+    for (const auto& t : r.origin) all += t.Sum();
+    for (int k = 0; k < GO_ORIGINS; ++k) {
+        const Totals& t = r.origin[k];
+        os << std::setw(14) << t.add << std::setw(14) << t.sub
+           << std::setw(14) << t.mul << std::setw(14) << t.div;
+        BitColsText(os, t);
+        if (g_vec_on)  os << std::setw(14) << t.VecSum();
+        if (g_wide_on) os << std::setw(14) << t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << t.FpSum(FP64) << std::setw(14) << t.FpSum(FP32);
+        os << std::setw(8) << Percent(t.Sum(), all) << std::setw(8) << r.origin_funcs[k]
+           << "  " << GO_ORIGIN_NAMES[k] << '\n';
+    }
+}
+
+static VOID PrintModulesText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-module breakdown -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(8) << "SHARE" << std::setw(8) << "FUNCS" << "  LOADED   MODULE\n";
+    for (const auto& m : r.modules) {
+        const Totals& t = m.t;
+        os << std::setw(14) << t.add << std::setw(14) << t.sub
+           << std::setw(14) << t.mul << std::setw(14) << t.div;
+        BitColsText(os, t);
+        if (g_vec_on)  os << std::setw(14) << t.VecSum();
+        if (g_wide_on) os << std::setw(14) << t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << t.FpSum(FP64) << std::setw(14) << t.FpSum(FP32);
+        os << std::setw(8) << Percent(t.Sum(), r.total.Sum()) << std::setw(8) << m.funcs
+           << "  " << std::left << std::setw(9) << (m.loaded ? m.loaded : "-")
+           << std::right << m.info->path;
+        if (m.info->unloaded) os << "  (unloaded)";
+        os << '\n';
+    }
+}
+
+static VOID PrintProcsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-process breakdown -----\n"
+       << std::setw(8) << "PID" << std::setw(8) << "PPID" << "  START "
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    os << "  COMMAND\n";
+    UINT64 all[4 + BIT_OPS] = {};
+    auto cols = [&](const UINT64* n) {
+        for (int c = 0; c < 4; ++c) os << std::setw(14) << n[c];
+        for (int o = 0; o < BIT_OPS; ++o)
+            if (g_bit_on[o]) os << std::setw(14) << n[4 + o];
+    };
+    for (const auto& p : r.procs) {
+        os << std::setw(8) << p.pid << std::setw(8) << p.ppid
+           << "  " << std::left << std::setw(6) << p.started << std::right;
+        cols(p.n);
+        os << " ";
+        for (const auto& a : p.args) os << ' ' << a;
+        if (p.args.empty()) os << ' ' << p.binary;
+        os << '\n';
+        for (int k = 0; k < 4 + BIT_OPS; ++k) all[k] += p.n[k];
+    }
+    os << std::setw(8) << "" << std::setw(8) << "" << "  " << std::left << std::setw(6) << "all"
+       << std::right;
+    cols(all);
+    os << "  (" << r.procs.size() << " processes)\n";
+}
+
+static VOID PrintFuncsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-function breakdown -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on)
+        os << std::setw(14) << "FP64" << std::setw(14) << "FP32"
+           << std::setw(8) << "INT/FP";
+    if (g_mem_on) os << std::setw(14) << "BYTES" << std::setw(10) << "OPS/B";
+    if (g_mod_on) os << std::setw(14) << "MODMUL";
+    os << "  FUNCTION\n";
+    for (const auto& f : r.funcs) {
+        os << std::setw(14) << f.t.add << std::setw(14) << f.t.sub
+           << std::setw(14) << f.t.mul << std::setw(14) << f.t.div;
+        BitColsText(os, f.t);
+        if (g_vec_on)  os << std::setw(14) << f.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << f.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << f.t.FpSum(FP64)
+               << std::setw(14) << f.t.FpSum(FP32)
+               << std::setw(8) << IntFpRatio(f.t);
+        if (g_mem_on)
+            os << std::setw(14) << f.t.Bytes() << std::setw(10) << OpsPerByte(IntOps(f.t), f.t);
+        if (g_mod_on) os << std::setw(14) << f.t.ModMuls();
+        os << "  " << f.info->name;
+        if (!f.info->file.empty())
+            os << "  (" << f.info->file << ':' << f.info->line << ')';
+        else if (!f.info->image.empty())
+            os << "  [" << f.info->image << ']';
+        os << '\n';
+    }
+}
+
+static VOID PrintLinesText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-line breakdown -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << "  LOCATION\n";
+    for (const auto& l : r.lines) {
+        os << std::setw(14) << l.t.add << std::setw(14) << l.t.sub
+           << std::setw(14) << l.t.mul << std::setw(14) << l.t.div;
+        BitColsText(os, l.t);
+        if (g_vec_on)  os << std::setw(14) << l.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << l.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << l.t.FpSum(FP64)
+               << std::setw(14) << l.t.FpSum(FP32);
+        os << "  " << l.info->file << ':' << l.info->line << '\n';
+    }
+}
+
+// n / d to one decimal; "-" when d is 0
+static std::string PerText(UINT64 n, UINT64 d)
+{
+    if (d == 0) return "-";
+    std::ostringstream os;
+    os << std::fixed << std::setprecision(1) << double(n) / double(d);
+    return os.str();
+}
+
+static VOID PrintLoopsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Loops (including nested loops) -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(12) << "ENTRIES" << std::setw(14) << "ITERATIONS"
+       << std::setw(10) << "TRIPS" << std::setw(10) << "OPS/ITER"
+       << std::setw(7) << "DEPTH" << "  LOOP\n";
+    for (const auto& l : r.loops) {
+        os << std::setw(14) << l.t.add << std::setw(14) << l.t.sub
+           << std::setw(14) << l.t.mul << std::setw(14) << l.t.div;
+        BitColsText(os, l.t);
+        if (g_vec_on)  os << std::setw(14) << l.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << l.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << l.t.FpSum(FP64)
+               << std::setw(14) << l.t.FpSum(FP32);
+        os << std::setw(12) << l.entries << std::setw(14) << l.iterations
+           << std::setw(10) << PerText(l.iterations, l.entries)
+           << std::setw(10) << PerText(l.t.Weight(), l.iterations)
+           << std::setw(7) << l.info->depth
+           << "  " << g_funcs[l.info->func].name << "+0x" << std::hex << l.info->offset << std::dec;
+        if (l.info->line.line > 0) os << "  (" << l.info->line.file << ':' << l.info->line.line << ')';
+        os << '\n';
+    }
+}
+
+static VOID PrintCallsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Call graph (inclusive / exclusive) -----\n";
+    for (const char* g : {"INCL-", "SELF-"})
+        for (const char* c : {"ADD", "SUB", "MUL", "DIV"})
+            os << std::setw(14) << std::string(g) + c;
+    os << "  FUNCTION\n";
+    for (const auto& c : r.calls) {
+        for (const Totals* t : {&c.incl, &c.excl})
+            os << std::setw(14) << t->add << std::setw(14) << t->sub
+               << std::setw(14) << t->mul << std::setw(14) << t->div;
+        os << "  " << c.info->name << '\n';
+    }
+}
+
+static VOID PrintRegionsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-region breakdown -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(10) << "ENTRIES" << "  REGION\n";
+    for (const auto& g : r.regions) {
+        os << std::setw(14) << g.t.add << std::setw(14) << g.t.sub
+           << std::setw(14) << g.t.mul << std::setw(14) << g.t.div;
+        BitColsText(os, g.t);
+        if (g_vec_on)  os << std::setw(14) << g.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << g.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << g.t.FpSum(FP64)
+               << std::setw(14) << g.t.FpSum(FP32);
+        os << std::setw(10) << g.entries << "  " << *g.name << '\n';
+    }
+}
+
+static VOID PrintPythonText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Python functions (own counts) -----\n";
+    if (!g_py_hooked)
+        os << "(CPython's profile_trampoline has no symbol: the counts include the profile hook's)\n";
+    os << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(10) << "CALLS" << "  FUNCTION\n";
+    for (const auto& p : r.python) {
+        os << std::setw(14) << p.t.add << std::setw(14) << p.t.sub
+           << std::setw(14) << p.t.mul << std::setw(14) << p.t.div;
+        BitColsText(os, p.t);
+        if (g_vec_on)  os << std::setw(14) << p.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << p.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << p.t.FpSum(FP64)
+               << std::setw(14) << p.t.FpSum(FP32);
+        os << std::setw(10) << p.calls << "  " << p.f->name
+           << "  [" << p.f->file << ':' << p.f->line << "]\n";
+    }
+}
+
+static VOID PrintPhasesText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-phase breakdown (" << g_phase_mode << ") -----\n"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    if (g_vec_on)  os << std::setw(14) << "VEC";
+    if (g_wide_on) os << std::setw(14) << "WIDE";
+    if (g_fp_on) os << std::setw(14) << "FP64" << std::setw(14) << "FP32";
+    os << std::setw(10) << "START" << std::setw(10) << "END" << "  PHASE\n";
+    for (const auto& p : r.phases) {
+        os << std::setw(14) << p.t.add << std::setw(14) << p.t.sub
+           << std::setw(14) << p.t.mul << std::setw(14) << p.t.div;
+        BitColsText(os, p.t);
+        if (g_vec_on)  os << std::setw(14) << p.t.VecSum();
+        if (g_wide_on) os << std::setw(14) << p.t.WideSum();
+        if (g_fp_on)
+            os << std::setw(14) << p.t.FpSum(FP64)
+               << std::setw(14) << p.t.FpSum(FP32);
+        os << std::fixed << std::setprecision(1) << std::setw(10) << p.start
+           << std::setw(10) << p.end << std::defaultfloat << "  " << *p.name << '\n';
+    }
+}
+
+static VOID PrintThreadsText(std::ostream& os, const Report& r)
+{
+    os << "\n----- Per-thread breakdown -----\n"
+       << std::setw(6) << "TID" << std::setw(10) << "OS-TID"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    os << "  STATUS\n";
+    for (const auto& t : r.threads) {
+        os << std::setw(6) << t.st->tid << std::setw(10) << t.st->os_tid
+           << std::setw(14) << t.t.add << std::setw(14) << t.t.sub
+           << std::setw(14) << t.t.mul << std::setw(14) << t.t.div;
+        BitColsText(os, t.t);
+        os << "  ";
+        if (t.st->exited) os << "exited(" << t.st->exit_code << ')';
+        else              os << "running";
+        os << '\n';
+    }
+}
+
+// "Busiest: CPU 3, 1.85x the mean of the 4 with counts", over the rows'
+// (number, weight) pairs w
+static VOID BusiestText(std::ostream& os, const char* what,
+                        const std::vector<std::pair<INT32, UINT64>>& w)
+{
+    if (w.size() < 2) return;
+    UINT64 sum = 0;
+    auto top = w.front();
+    for (const auto& x : w) {
+        sum += x.second;
+        if (x.second > top.second) top = x;
+    }
+    if (sum == 0) return;
+    os << "Busiest: " << what << ' ' << top.first << ", " << std::fixed << std::setprecision(2)
+       << double(top.second) * w.size() / sum << std::defaultfloat
+       << "x the mean of the " << w.size() << " with counts\n";
+}
+
+static VOID PrintCpusText(std::ostream& os, const Report& r)
+{
+    double all = double(r.total.Sum() + r.total.BitSum());
+    std::vector<std::pair<INT32, UINT64>> cpus, nodes;
+    os << "\n----- Per-CPU breakdown -----\n"
+       << std::setw(6) << "CPU" << std::setw(6) << "NODE" << std::setw(9) << "THREADS"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    os << std::setw(8) << "SHARE" << '\n';
+    for (const auto& c : r.cpus) {
+        UINT64 w = c.t.Sum() + c.t.BitSum();
+        if (c.cpu >= 0) {
+            os << std::setw(6) << c.cpu << std::setw(6) << c.node;
+            cpus.push_back({c.cpu, w});
+        } else {
+            os << std::setw(6) << "?" << std::setw(6) << "?";
+        }
+        os << std::setw(9) << c.threads
+           << std::setw(14) << c.t.add << std::setw(14) << c.t.sub
+           << std::setw(14) << c.t.mul << std::setw(14) << c.t.div;
+        BitColsText(os, c.t);
+        os << std::fixed << std::setprecision(1) << std::setw(7) << (all ? 100.0 * w / all : 0.0)
+           << '%' << std::defaultfloat << '\n';
+    }
+    BusiestText(os, "CPU", cpus);
+
+    os << "\n----- Per-NUMA-node breakdown -----\n"
+       << std::setw(6) << "NODE" << std::setw(6) << "CPUS"
+       << std::setw(14) << "ADD" << std::setw(14) << "SUB"
+       << std::setw(14) << "MUL" << std::setw(14) << "DIV";
+    BitHeaderText(os);
+    os << std::setw(8) << "SHARE" << '\n';
+    for (const auto& n : r.nodes) {
+        UINT64 w = n.t.Sum() + n.t.BitSum();
+        nodes.push_back({n.node, w});
+        os << std::setw(6) << n.node << std::setw(6) << n.cpus
+           << std::setw(14) << n.t.add << std::setw(14) << n.t.sub
+           << std::setw(14) << n.t.mul << std::setw(14) << n.t.div;
+        BitColsText(os, n.t);
+        os << std::fixed << std::setprecision(1) << std::setw(7) << (all ? 100.0 * w / all : 0.0)
+           << '%' << std::defaultfloat << '\n';
+    }
+    BusiestText(os, "node", nodes);
+}
+
+static VOID PrintSampleText(std::ostream& os, const Report& r)
+{
+    const SampleSummary& sm = r.sample;
+    const UINT64 est[4] = {r.total.add, r.total.sub, r.total.mul, r.total.div};
+    static const char* const names[4] = {"ADD", "SUB", "MUL", "DIV"};
+
+    os << "\n----- Sampling (extrapolated) -----\n"
+       << "Windows:      " << sm.windows_sampled << " of " << sm.windows
+       << " (fraction " << g_sample_frac << ", " << g_window << " instructions each)\n"
+       << "Instructions: " << sm.insns_sampled << " of " << sm.insns << '\n'
+       << std::setw(6) << "" << std::setw(16) << "ESTIMATE" << std::setw(16) << "CI95 (+/-)" << '\n';
+    for (int c = 0; c < 4; ++c) {
+        os << std::setw(6) << names[c] << std::setw(16) << est[c];
+        if (sm.ci95[c] < 0) os << std::setw(16) << "n/a";
+        else                os << std::setw(16) << std::llround(sm.ci95[c]);
+        os << '\n';
+    }
+}
+
+static VOID PrintDiagsText(std::ostream& os, const Report& r)
+{
+    UINT32 warnings = 0;
+    for (const Diag& d : r.diags) warnings += d.level[0] == 'w';
+    os << "\n----- Diagnostics -----\nWarnings: " << warnings << '\n';
+    if (r.diags.empty()) os << "None\n";
+    for (const Diag& d : r.diags) {
+        os << std::left << std::setw(6) << Upper(d.level) << std::setw(20) << d.kind << std::right;
+        if (!d.image.empty()) os << d.image << ": ";
+        if (d.count) os << d.count << ' ';
+        os << d.msg << '\n';
+    }
+}
+
+static VOID PrintText(std::ostream& os, const Report& r)
+{
+    if (!g_truncated.empty())
+        os << "Truncated: stopped by " << g_truncated << " after " << std::fixed
+           << std::setprecision(1) << g_truncated_at << std::defaultfloat
+           << " s; partial counts\n";
+    if (g_warmup && g_warmup_done)
+        os << "Warm-up: first " << std::fixed << std::setprecision(1) << g_warmup_at
+           << std::defaultfloat << " s excluded (" << g_warmup << ")\n";
+    else if (g_warmup)
+        os << "Warm-up: not over at exit; the counts include it\n";
+    if (g_resumed)
+        os << "Resumed: from " << g_carry.from << " (checkpoint " << g_carry.seq << "), "
+           << std::fixed << std::setprecision(1) << g_carry.elapsed << std::defaultfloat
+           << " s carried over\n";
+    PrintOverBudgetText(os);
+    os << "ADD: " << r.total.add << '\n'
+       << "SUB: " << r.total.sub << '\n'
+       << "MUL: " << r.total.mul << '\n'
+       << "DIV: " << r.total.div << '\n';
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) os << Upper(BIT_OP_NAMES[o]) << ": " << r.total.bit[o] << '\n';
+    for (size_t k = 0; k < g_classes.size(); ++k)
+        os << Upper(g_classes[k].name) << ": " << r.total.cls[k] << '\n';
+
+    if (g_systime_on) PrintSysTimeText(os);
+    if (g_sampling)   PrintSampleText(os, r);
+    if (g_compound != CMP_FUSED) PrintCompoundText(os, r);
+    if (g_agen != AGEN_OFF) PrintAgenText(os, r);
+    if (g_fp_on)      PrintFpText(os, r);
+    if (g_vec_on)     PrintVecText(os, r);
+    if (g_wide_on)    PrintWideText(os, r);
+    if (g_widths_on)  PrintWidthsText(os, r);
+    if (g_sign_on)    PrintSignText(os, r);
+    if (g_atomics_on) PrintAtomicsText(os, r);
+    if (g_mem_on)     PrintMemText(os, r);
+    if (g_cache_on)   PrintCacheText(os, r);
+    if (g_foot_window) PrintFootprintText(os, r);
+    if (g_mix_on)     PrintMixText(os, r);
+    if (g_mod_on)     PrintModText(os, r);
+    if (g_bfly_on)    PrintBflyText(os, r);
+    if (g_divs_on)    PrintDivsText(os, r);
+    if (g_branches)   PrintBranchesText(os, r);
+    if (g_strides)    PrintStridesText(os, r);
+    if (g_reuse)      PrintReuseText(os, r);
+    if (g_blocks)     PrintBlocksText(os, r);
+    if (!g_ann_pats.empty()) PrintAnnotatedText(os, r);
+    if (g_dfg_on)     PrintDfgText(os, r);
+    if (g_dead_on)    PrintDeadText(os, r);
+    if (g_mulvals)    PrintMulValsText(os, r);
+    if (g_go_on)      PrintGoText(os, r);
+    if (g_modules_on) PrintModulesText(os, r);
+    if (g_children_on) PrintProcsText(os, r);
+    if (g_funcs_on)   PrintFuncsText(os, r);
+    if (g_calls_on)   PrintCallsText(os, r);
+    if (g_lines_on)   PrintLinesText(os, r);
+    if (g_loops_on)   PrintLoopsText(os, r);
+    if (g_mode == REGIONS) PrintRegionsText(os, r);
+    if (!g_budgets.empty()) PrintBudgetsText(os);
+    if (g_py_on) PrintPythonText(os, r);
+    if (g_phase_mode) PrintPhasesText(os, r);
+    if (g_threads_on) PrintThreadsText(os, r);
+    if (g_percpu_on) PrintCpusText(os, r);
+    PrintDiagsText(os, r);
+}
+
+// ── JSON report ─────────────────────────────────────────────────────────────
+// Schema changes that rename or remove fields must bump JSON_SCHEMA_VERSION;
+// adding fields does not.
+static const int JSON_SCHEMA_VERSION = 1;
+
+static std::string JsonStr(const std::string& s)
+{
+    std::ostringstream os;
+    os << '"';
+    for (unsigned char c : s) {
+        switch (c) {
+            case '"':  os << "\\\""; break;
+            case '\\': os << "\\\\"; break;
+            case '\n': os << "\\n";  break;
+            case '\t': os << "\\t";  break;
+            default:
+                if (c < 0x20)
+                    os << "\\u" << std::hex << std::setw(4)
+                       << std::setfill('0') << int(c) << std::dec;
+                else
+                    os << c;
+        }
+    }
+    os << '"';
+    return os.str();
+}
+
+// "fp64": {…}, "fp32": {…}
+static std::string JsonFp(const Totals& t)
+{
+    std::ostringstream os;
+    for (int p = 0; p < FP_PRECS; ++p) {
+        os << (p ? ", " : "") << '"' << FP_PREC_NAMES[p] << "\": {";
+        for (int o = 0; o < FP_OPS; ++o)
+            os << (o ? ", " : "") << '"' << FP_OP_NAMES[o] << "\": " << t.fp[p][o];
+        os << '}';
+    }
+    return os.str();
+}
+
+// , "shl": n, … for the categories selected with -ops
+static std::string JsonBits(const Totals& t)
+{
+    std::ostringstream os;
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) os << ", \"" << BIT_OP_NAMES[o] << "\": " << t.bit[o];
+    return os.str();
+}
+
+// "wide": {"add": n, "sub": n, "mul": n} for breakdown rows
+static std::string JsonWideRow(const Totals& t)
+{
+    if (!g_wide_on) return "";
+    std::ostringstream os;
+    os << ", \"wide\": {";
+    for (int k = 0; k < WIDE_KINDS; ++k)
+        os << (k ? ", " : "") << '"' << WIDE_KIND_NAMES[k] << "\": "
+           << t.WideSum(static_cast<WideKind>(k));
+    os << '}';
+    return os.str();
+}
+
+// "vector": {"add": n, "sub": n, "mul": n}
+static std::string JsonVec(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"vector\": {";
+    for (int v = 0; v < VEC_OPS; ++v)
+        os << (v ? ", " : "") << '"' << VEC_OP_NAMES[v] << "\": " << t.vec[v];
+    os << '}';
+    return os.str();
+}
+
+// "memory": {"loads": n, …, "int_ops_per_byte": x, "fp_ops_per_byte": x},
+// with -cache the DRAM bytes and the intensities over them besides; the
+// intensities are left out when no bytes were moved
+static std::string JsonMem(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"memory\": {";
+    for (int k = 0; k < MemKinds(); ++k)
+        os << (k ? ", " : "") << '"' << MEM_KIND_NAMES[k] << "\": " << t.mem[k];
+    if (t.Bytes()) {
+        os << ", \"int_ops_per_byte\": " << OpsPerByte(IntOps(t), t);
+        if (g_fp_on) os << ", \"fp_ops_per_byte\": " << OpsPerByte(t.FpSum(), t);
+    }
+    if (t.DramBytes()) {
+        os << ", \"int_ops_per_dram_byte\": " << OpsPerByte(IntOps(t), t.DramBytes());
+        if (g_fp_on) os << ", \"fp_ops_per_dram_byte\": " << OpsPerByte(t.FpSum(), t.DramBytes());
+    }
+    os << '}';
+    return os.str();
+}
+
+// "op_widths": {"add": {"8": n, …}, …}, the sizes selected with -widths
+static std::string JsonWidths(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"op_widths\": {";
+    bool first = true;
+    for (int k = 0; k < WIDTH_CATS; ++k) {
+        if (!WidthCatOn(k)) continue;
+        os << (first ? "" : ", ") << '"' << WidthCatName(k) << "\": {";
+        first = false;
+        bool firstw = true;
+        for (int w = 0; w < WIDTH_SLOTS; ++w) {
+            if (!g_width_on[w]) continue;
+            os << (firstw ? "" : ", ") << '"' << WIDTH_BITS[w] << "\": " << t.width[k][w];
+            firstw = false;
+        }
+        os << '}';
+    }
+    os << '}';
+    return os.str();
+}
+
+// "signedness": {"mul": {"signed": n, "unsigned": n}, "div": {…}}
+static std::string JsonSign(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"signedness\": {";
+    for (int k = 0; k < 2; ++k)
+        os << (k ? ", \"div\"" : "\"mul\"") << ": {\"signed\": " << t.sign[k][SSIGNED]
+           << ", \"unsigned\": " << t.sign[k][SUNSIGNED] << '}';
+    os << '}';
+    return os.str();
+}
+
+// "atomics": {"rmw": n, "futex": n}
+static std::string JsonAtomics(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"atomics\": {\"rmw\": " << t.atomic[AT_RMW] << ", \"futex\": " << t.atomic[AT_FUTEX] << '}';
+    return os.str();
+}
+
+// "custom": {"crc32": n, …} for the -class categories
+static std::string JsonClasses(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"custom\": {";
+    for (size_t k = 0; k < g_classes.size(); ++k)
+        os << (k ? ", " : "") << JsonStr(g_classes[k].name) << ": " << t.cls[k];
+    os << '}';
+    return os.str();
+}
+
+// "modular": {"mul": {"montgomery": n, …}, "add": n, "sub": n}
+static std::string JsonMod(const Totals& t)
+{
+    std::ostringstream os;
+    os << "\"modular\": {\"mul\": {";
+    for (int k = 0; k < MOD_MULS; ++k)
+        os << (k ? ", " : "") << '"' << MOD_MUL_NAMES[k] << "\": " << t.mod[k];
+    os << "}, \"add\": " << t.mod[MOD_ADD] << ", \"sub\": " << t.mod[MOD_SUB] << '}';
+    return os.str();
+}
+
+static const char* ModeName()
+{
+    switch (g_mode) {
+        case ADDRESS: return "address";
+        case MARKER:  return "marker";
+        case REGIONS: return "regions";
+        default:      return "whole";
+    }
+}
+
+static VOID JsonSnapshotCounts(std::ostream& os, const Totals& t);
+
+static VOID PrintJson(std::ostream& os, const Report& r)
+{
+    const Cnts& c = r.raw;
+#define VARIANT(key, f) "\"" key "\": {\"rr\": " << c.f##_rr \
+                        << ", \"rm\": " << c.f##_rm << '}'
+
+    os << "{\n"
+       << "  \"schema_version\": " << JSON_SCHEMA_VERSION << ",\n"
+       << "  \"tool\": \"Int64Profiler\",\n"
+       << "  \"binary\": {\n"
+       << "    \"path\": " << JsonStr(g_binary) << ",\n"
+       << "    \"args\": [";
+    for (size_t i = 0; i < g_args.size(); ++i)
+        os << (i ? ", " : "") << JsonStr(g_args[i]);
+    os << "],\n"
+       << "    \"pid\": " << PIN_GetPid() << "\n"
+       << "  },\n";
+    if (g_attached)
+        os << "  \"attached\": true,\n"
+           << "  \"detached\": " << (g_detached ? "true" : "false") << ",\n";
+    if (g_warmup) {
+        os << "  \"warmup\": {\"mode\": \"" << g_warmup << "\", \"reached\": "
+           << (g_warmup_done ? "true" : "false");
+        if (g_warmup_done) {
+            os << ", \"elapsed_sec\": " << std::fixed << std::setprecision(3) << g_warmup_at
+               << std::defaultfloat << ", \"excluded\": ";
+            JsonSnapshotCounts(os, Summarize(g_warmup_cnts));
+        }
+        os << "},\n";
+    }
+    if (g_resumed)
+        os << "  \"resumed\": {\"from\": " << JsonStr(g_carry.from) << ", \"checkpoint\": "
+           << g_carry.seq << ", \"elapsed_sec\": " << std::fixed << std::setprecision(3)
+           << g_carry.elapsed << std::defaultfloat << "},\n";
+    if (!g_truncated.empty())
+        os << "  \"truncated\": {\"reason\": " << JsonStr(g_truncated)
+           << ", \"elapsed_sec\": " << std::fixed << std::setprecision(3) << g_truncated_at
+           << std::defaultfloat << "},\n";
+    os
+       << "  \"mode\": \"" << ModeName() << "\",\n"
+       << "  \"compound\": {\"policy\": \"" << COMPOUND_POLICY_NAMES[g_compound] << '"';
+    if (g_compound != CMP_FUSED)
+        for (int k = 0; k < COMPOUND_KINDS; ++k)
+            if (CompoundShown(k)) os << ", \"" << COMPOUND_KIND_NAMES[k] << "\": " << r.compound[k];
+    os << "},\n";
+    if (g_agen != AGEN_OFF) {
+        const UINT64* a = r.total.agen;
+        os << "  \"agen\": {\"mode\": \"" << AGEN_MODE_NAMES[g_agen]
+           << "\", \"lea\": {\"adds\": " << a[AG_LEA_ADD] << ", \"shifts\": " << a[AG_LEA_SHL]
+           << "}, \"memory\": {\"adds\": " << a[AG_MEM_ADD] << ", \"shifts\": " << a[AG_MEM_SHL]
+           << "}},\n";
+    }
+    if (g_mode == ADDRESS)
+        os << "  \"region\": {\"addr\": \"0x" << std::hex << g_start_addr
+           << std::dec << "\"},\n";
+    else if (g_mode == MARKER)
+        os << "  \"region\": {\"start\": " << JsonStr(g_start_marker)
+           << ", \"stop\": " << JsonStr(g_stop_marker) << "},\n";
+    os << "  \"wall_time_sec\": " << std::fixed << std::setprecision(6)
+       << r.wall_sec << ",\n"
+       << "  \"totals\": {\"add\": " << r.total.add
+       << ", \"sub\": " << r.total.sub
+       << ", \"mul\": " << r.total.mul
+       << ", \"div\": " << r.total.div << JsonBits(r.total) << "},\n"
+       << "  \"categories\": {\n"
+       << "    \"add\": {" << VARIANT("add", add) << ", " << VARIANT("adc", adc)
+       << ", " << VARIANT("adcx", adcx) << ", " << VARIANT("adox", adox);
+    if (g_compound == CMP_SPLIT) os << ", \"lea\": {\"rr\": " << c.lea_rr << ", \"rm\": 0}";
+    if (g_agen == AGEN_FOLD)
+        os << ", \"agen\": {\"rr\": " << c.agen[AG_LEA_ADD] << ", \"rm\": " << c.agen[AG_MEM_ADD] << '}';
+    os << "},\n"
+       << "    \"sub\": {" << VARIANT("sub", sub) << ", " << VARIANT("sbb", sbb)
+       << "},\n"
+       << "    \"mul\": {" << VARIANT("mul", mul) << ", " << VARIANT("mulx", mulx)
+       << "},\n"
+       << "    \"div\": {" << VARIANT("div", div) << '}';
+#undef VARIANT
+    for (int o = 0; o < BIT_OPS; ++o) {
+        if (!g_bit_on[o]) continue;
+        os << ",\n    \"" << BIT_OP_NAMES[o] << "\": {\"" << BIT_OP_NAMES[o]
+           << "\": {\"rr\": " << c.bit[o][0] << ", \"rm\": " << c.bit[o][1] << '}';
+        if (o == BSHL && g_agen == AGEN_FOLD)
+            os << ", \"agen\": {\"rr\": " << c.agen[AG_LEA_SHL] << ", \"rm\": " << c.agen[AG_MEM_SHL] << '}';
+        os << '}';
+    }
+    os << "\n  }";
+
+    if (g_fp_on) {
+        os << ",\n  \"fp\": {" << JsonFp(r.total);
+        if (r.total.FpSum())
+            os << ", \"int_fp_ratio\": " << IntFpRatio(r.total);
+        os << '}';
+    }
+
+    if (g_vec_on) os << ",\n  " << JsonVec(r.total);
+    if (g_mem_on) os << ",\n  " << JsonMem(r.total);
+    if (g_cache_on) {
+        os << ",\n  \"cache\": {\"line\": " << g_cache_line << ", \"levels\": [";
+        for (size_t i = 0; i < g_cache_levels.size(); ++i) {
+            const CacheConfig& c = g_cache_levels[i];
+            os << (i ? ", " : "") << "{\"name\": " << JsonStr(c.name) << ", \"size\": " << c.size
+               << ", \"ways\": " << c.ways << ", \"accesses\": " << r.cache_accesses[i]
+               << ", \"misses\": " << r.cache_misses[i] << '}';
+        }
+        os << "]}";
+    }
+    if (g_foot_window) {
+        auto counts = [&](const FootRow& f) {
+            os << "\"lines\": " << f.lines << ", \"pages\": " << f.pages
+               << ", \"peak_lines\": " << f.peak_lines << ", \"peak_pages\": " << f.peak_pages;
+        };
+        os << ",\n  \"footprint\": {\"line\": " << FOOT_LINE << ", \"page\": " << FOOT_PAGE
+           << ", \"window\": " << g_foot_window << ", ";
+        counts(r.foot);
+        os << ",\n    \"curve\": [";
+        for (size_t i = 0; i < r.foot_curve.size(); ++i)
+            os << (i ? ", " : "") << "{\"lines\": " << r.foot_curve[i].first
+               << ", \"pages\": " << r.foot_curve[i].second << '}';
+        os << "],\n    \"functions\": [";
+        for (size_t i = 0; i < r.foot_funcs.size(); ++i) {
+            const FuncInfo& fi = g_funcs[r.foot_funcs[i].func];
+            os << (i ? "," : "") << "\n      {\"function\": " << JsonStr(fi.name)
+               << ", \"image\": " << JsonStr(fi.image) << ", ";
+            counts(r.foot_funcs[i]);
+            os << '}';
+        }
+        os << (r.foot_funcs.empty() ? "]" : "\n    ]");
+        if (g_phase_mode) {
+            os << ",\n    \"phases\": [";
+            for (size_t i = 0; i < r.foot_phases.size(); ++i) {
+                os << (i ? ", " : "") << "{\"name\": " << JsonStr(*r.foot_phases[i].phase) << ", ";
+                counts(r.foot_phases[i]);
+                os << '}';
+            }
+            os << ']';
+        }
+        os << '}';
+    }
+    if (g_mix_on) {
+        os << ",\n  \"mix\": {";
+        for (int k = 0; k < MIX_KINDS; ++k)
+            os << (k ? ", " : "") << '"' << MIX_KIND_NAMES[k] << "\": " << r.mix[k];
+        os << ", \"other\": " << MixOther(r) << '}';
+    }
+    if (g_mod_on) os << ",\n  " << JsonMod(r.total);
+    if (!g_classes.empty()) os << ",\n  " << JsonClasses(r.total);
+
+    if (g_wide_on) {
+        // keyed by operand width in bits; empty buckets are left out
+        os << ",\n  \"wide\": {";
+        for (int k = 0; k < WIDE_KINDS; ++k) {
+            os << (k ? ", " : "") << '"' << WIDE_KIND_NAMES[k] << "\": {";
+            bool first = true;
+            for (int w = 0; w < WIDE_SLOTS; ++w) {
+                if (!r.total.wide[k][w]) continue;
+                os << (first ? "" : ", ") << '"' << WideBits(w) << "\": " << r.total.wide[k][w];
+                first = false;
+            }
+            os << '}';
+        }
+        os << '}';
+    }
+    if (g_widths_on) os << ",\n  " << JsonWidths(r.total);
+    if (g_sign_on) os << ",\n  " << JsonSign(r.total);
+    if (g_atomics_on) os << ",\n  " << JsonAtomics(r.total);
+
+    if (g_bfly_on) {
+        // one transform row per function and butterflies per invocation;
+        // "size" only when (N/2)·log₂N matches
+        UINT64 total = 0;
+        for (const auto& b : r.bfly) total += b.Butterflies();
+        os << ",\n  \"butterflies\": {\"total\": " << total << ", \"transforms\": [";
+        for (size_t i = 0; i < r.bfly.size(); ++i) {
+            const BflyRow& b = r.bfly[i];
+            os << (i ? "," : "") << "\n    {\"function\": " << JsonStr(b.info->name)
+               << ", \"image\": " << JsonStr(b.info->image);
+            if (!b.info->file.empty())
+                os << ", \"file\": " << JsonStr(b.info->file) << ", \"line\": " << b.info->line;
+            os << ", \"calls\": " << b.calls << ", \"per_call\": " << b.per_call;
+            if (UINT64 n = BflySize(b.per_call)) os << ", \"size\": " << n;
+            os << ", \"butterflies\": " << b.Butterflies() << '}';
+        }
+        os << (r.bfly.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_divs_on) {
+        // per executed DIV/IDIV site, most divisions first; "distinct" stops
+        // counting at DIV_VALUES + 1
+        UINT64 n[DIV_CLASSES] = {};
+        for (const auto& d : r.divs) n[d.Class()] += d.n;
+        os << ",\n  \"divisors\": {";
+        for (int k = 0; k < DIV_CLASSES; ++k)
+            os << '"' << DIV_CLASS_NAMES[k] << "\": " << n[k] << ", ";
+        os << "\"sites\": [";
+        for (size_t i = 0; i < r.divs.size(); ++i) {
+            const DivRow& d = r.divs[i];
+            os << (i ? "," : "") << "\n    {\"address\": \"0x" << std::hex << d.info->addr
+               << std::dec << "\", \"function\": " << JsonStr(g_funcs[d.info->func].name)
+               << ", \"file\": " << JsonStr(d.info->line.file)
+               << ", \"line\": " << d.info->line.line
+               << ", \"signed\": " << (d.info->is_signed ? "true" : "false")
+               << ", \"count\": " << d.n << ", \"pow2\": " << d.pow2
+               << ", \"distinct\": " << d.vals.size() + (d.more ? 1 : 0);
+            if (d.Constant()) {
+                os << ", \"divisor\": ";
+                if (d.info->is_signed) os << static_cast<INT64>(d.vals[0]);
+                else                   os << d.vals[0];
+            }
+            os << ", \"class\": \"" << DIV_CLASS_NAMES[d.Class()] << "\"}";
+        }
+        os << (r.divs.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_branches) {
+        // the most missed branch sites; loop offsets are of the header from
+        // the function start
+        UINT64 n = 0, taken = 0, bimodal = 0, local = 0;
+        for (const auto& b : r.branches) {
+            n += b.n; taken += b.taken; bimodal += b.miss_bimodal; local += b.miss_local;
+        }
+        os << ",\n  \"branches\": {\"sites\": " << r.branches.size() << ", \"executions\": " << n
+           << ", \"taken\": " << taken << ", \"bimodal_misses\": " << bimodal
+           << ", \"local_misses\": " << local << ", \"top\": [";
+        for (size_t i = 0; i < r.branches.size() && i < g_branches; ++i) {
+            const BranchRow& b = r.branches[i];
+            os << (i ? "," : "") << "\n    {\"address\": \"0x" << std::hex << b.info->addr
+               << std::dec << "\", \"function\": " << JsonStr(g_funcs[b.info->func].name)
+               << ", \"file\": " << JsonStr(b.info->line.file)
+               << ", \"line\": " << b.info->line.line
+               << ", \"backward\": " << (b.info->backward ? "true" : "false");
+            if (b.info->loop != NO_SITE) {
+                const LoopInfo& l = g_loops[b.info->loop];
+                os << ", \"loop\": {\"offset\": \"0x" << std::hex << l.offset << std::dec
+                   << "\", \"line\": " << l.line.line << ", \"depth\": " << l.depth << '}';
+            }
+            os << ", \"executions\": " << b.n << ", \"taken\": " << b.taken
+               << ", \"transitions\": " << b.flips
+               << ", \"entropy\": " << std::fixed << std::setprecision(4) << b.Entropy() << std::defaultfloat
+               << ", \"bimodal_misses\": " << b.miss_bimodal
+               << ", \"local_misses\": " << b.miss_local << '}';
+        }
+        os << (r.branches.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_strides) {
+        UINT64 unit = 0, constant = 0, irregular = 0;
+        for (const auto& l : r.strides) {
+            unit += l.unit; constant += l.constant; irregular += l.irregular;
+        }
+        os << ",\n  \"strides\": {\"loops\": " << r.strides.size() << ", \"unit\": " << unit
+           << ", \"constant\": " << constant << ", \"irregular\": " << irregular << ", \"top\": [";
+        for (size_t i = 0; i < r.strides.size() && i < g_strides; ++i) {
+            const StrideRow& l = r.strides[i];
+            const FuncInfo& f = g_funcs[l.info->func];
+            os << (i ? "," : "") << "\n    {\"loop\": " << l.id << ", \"depth\": " << l.info->depth
+               << ", \"function\": " << JsonStr(f.name) << ", \"image\": " << JsonStr(f.image)
+               << ", \"offset\": \"0x" << std::hex << l.info->offset << std::dec << '"'
+               << ", \"file\": " << JsonStr(l.info->line.file) << ", \"line\": " << l.info->line.line
+               << ", \"sites\": " << l.sites << ", \"unit\": " << l.unit
+               << ", \"constant\": " << l.constant << ", \"irregular\": " << l.irregular << '}';
+        }
+        os << (r.strides.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_reuse) {
+        UINT64 n = 0, same = 0, hits = 0;
+        for (const auto& l : r.reuse) {
+            n += l.n; same += l.same; hits += l.hits;
+        }
+        os << ",\n  \"reuse\": {\"entries\": " << REUSE_ENTRIES << ", \"loops\": " << r.reuse.size()
+           << ", \"ops\": " << n << ", \"repeated\": " << hits << ", \"same\": " << same << ", \"top\": [";
+        for (size_t i = 0; i < r.reuse.size() && i < g_reuse; ++i) {
+            const ReuseRow& l = r.reuse[i];
+            const FuncInfo& f = g_funcs[l.info->func];
+            os << (i ? "," : "") << "\n    {\"loop\": " << l.id << ", \"depth\": " << l.info->depth
+               << ", \"function\": " << JsonStr(f.name) << ", \"image\": " << JsonStr(f.image)
+               << ", \"offset\": \"0x" << std::hex << l.info->offset << std::dec << '"'
+               << ", \"file\": " << JsonStr(l.info->line.file) << ", \"line\": " << l.info->line.line
+               << ", \"sites\": " << l.sites << ", \"ops\": " << l.n
+               << ", \"repeated\": " << l.hits << ", \"same\": " << l.same << '}';
+        }
+        os << (r.reuse.empty() ? "]" : "\n  ]") << ", \"sites\": [";
+        for (size_t i = 0; i < r.reuse_sites.size() && i < g_reuse; ++i) {
+            const ReuseRow& s = r.reuse_sites[i];
+            const FuncInfo& f = g_funcs[s.site->func];
+            os << (i ? "," : "") << "\n    {\"loop\": " << s.id
+               << ", \"function\": " << JsonStr(f.name) << ", \"image\": " << JsonStr(f.image)
+               << ", \"offset\": \"0x" << std::hex << s.site->ins.offset << std::dec
+               << "\", \"file\": " << JsonStr(s.site->line.file) << ", \"line\": " << s.site->line.line
+               << ", \"op\": \"" << BlockOpName(s.site->ins) << "\", \"disasm\": " << JsonStr(s.site->ins.disasm)
+               << ", \"ops\": " << s.n << ", \"repeated\": " << s.hits << ", \"same\": " << s.same << '}';
+        }
+        os << (r.reuse_sites.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_blocks) {
+        // the hottest blocks with their decoded instructions; offsets and
+        // branch targets are from the block start
+        static const char* const kinds[] = {"reg", "mem", "imm", "agen", "rel"};
+        os << ",\n  \"blocks\": [";
+        for (size_t i = 0; i < r.blocks.size(); ++i) {
+            const BlockRow& b = r.blocks[i];
+            const FuncInfo& f = g_funcs[b.info->func];
+            os << (i ? "," : "") << "\n    {\"address\": \"0x" << std::hex << b.info->addr
+               << "\", \"image\": " << JsonStr(f.image)
+               << ", \"image_offset\": \"0x" << b.info->image_offset
+               << "\", \"function\": " << JsonStr(f.name)
+               << ", \"function_offset\": \"0x" << b.info->func_offset << std::dec
+               << "\", \"file\": " << JsonStr(b.info->line.file) << ", \"line\": " << b.info->line.line
+               << ", \"executions\": " << b.execs << ", \"ops_per_execution\": " << b.info->ops
+               << ", \"ops\": " << b.Ops() << ",\n     \"instructions\": [";
+            for (size_t j = 0; j < b.info->ins.size(); ++j) {
+                const BlockIns& in = b.info->ins[j];
+                os << (j ? "," : "") << "\n      {\"offset\": " << in.offset
+                   << ", \"bytes\": \"" << in.bytes << "\", \"mnemonic\": " << JsonStr(in.mnemonic)
+                   << ", \"disasm\": " << JsonStr(in.disasm);
+                if (in.op_kind) os << ", \"op\": \"" << BlockOpName(in) << '"';
+                if (in.lanes > 1) os << ", \"lanes\": " << in.lanes;
+                os << ", \"operands\": [";
+                for (size_t k = 0; k < in.operands.size(); ++k) {
+                    const BlockOperand& o = in.operands[k];
+                    const char* kind = kinds[std::string("rmiab").find(o.kind)];
+                    os << (k ? ", " : "") << "{\"kind\": \"" << kind << '"';
+                    if (o.kind == 'r') os << ", \"reg\": \"" << o.reg << '"';
+                    if (o.kind == 'm' || o.kind == 'a') {
+                        if (!o.reg.empty()) os << ", \"base\": \"" << o.reg << '"';
+                        if (!o.index.empty())
+                            os << ", \"index\": \"" << o.index << "\", \"scale\": " << o.scale;
+                        os << ", \"disp\": " << o.value;
+                    }
+                    if (o.kind == 'i') os << ", \"value\": " << o.value;
+                    if (o.kind == 'b') os << ", \"target\": " << o.value;
+                    os << ", \"width\": " << o.width << ", \"access\": \""
+                       << (o.read ? "r" : "") << (o.written ? "w" : "") << '"';
+                    if (o.implicit) os << ", \"implicit\": true";
+                    os << '}';
+                }
+                os << "]}";
+            }
+            os << "]}";
+        }
+        os << (r.blocks.empty() ? "]" : "\n  ]");
+    }
+
+    if (!g_ann_pats.empty()) {
+        // offsets from the function start
+        os << ",\n  \"annotated\": [";
+        for (size_t i = 0; i < r.annotated.size(); ++i) {
+            const AnnRow& a = r.annotated[i];
+            const FuncInfo& f = g_funcs[a.func->func];
+            os << (i ? "," : "") << "\n    {\"function\": " << JsonStr(f.name)
+               << ", \"image\": " << JsonStr(f.image) << ", \"address\": \"0x" << std::hex
+               << a.func->addr << std::dec << "\", \"executions\": " << a.total << ",\n     \"instructions\": [";
+            for (size_t j = 0; j < a.func->ins.size(); ++j) {
+                const AnnIns& in = a.func->ins[j];
+                os << (j ? "," : "") << "\n      {\"offset\": " << in.ins.offset
+                   << ", \"bytes\": \"" << in.ins.bytes << "\", \"mnemonic\": " << JsonStr(in.ins.mnemonic)
+                   << ", \"disasm\": " << JsonStr(in.ins.disasm);
+                if (in.ins.op_kind) os << ", \"op\": \"" << BlockOpName(in.ins) << '"';
+                if (in.ins.lanes > 1) os << ", \"lanes\": " << in.ins.lanes;
+                if (in.line.line > 0)
+                    os << ", \"file\": " << JsonStr(in.line.file) << ", \"line\": " << in.line.line;
+                os << ", \"executions\": " << a.execs[j] << '}';
+            }
+            os << "]}";
+        }
+        os << (r.annotated.empty() ? "]" : "\n  ]");
+    }
+
+    if (g_dfg_on) {
+        // nodes by address, ids are indices; edges most frequent first
+        os << ",\n  \"dataflow\": {\"nodes\": [";
+        for (size_t i = 0; i < r.dfg.size(); ++i) {
+            const DfgNode& n = *r.dfg[i].first;
+            const FuncInfo& f = g_funcs[n.func];
+            os << (i ? "," : "") << "\n    {\"id\": " << i << ", \"kind\": \""
+               << (n.ins.op_kind ? "op" : "load") << '"';
+            if (n.ins.op_kind) os << ", \"op\": \"" << BlockOpName(n.ins) << '"';
+            if (n.ins.lanes > 1) os << ", \"lanes\": " << n.ins.lanes;
+            os << ", \"function\": " << JsonStr(f.name) << ", \"image\": " << JsonStr(f.image)
+               << ", \"offset\": \"0x" << std::hex << n.ins.offset << std::dec
+               << "\", \"file\": " << JsonStr(n.line.file) << ", \"line\": " << n.line.line
+               << ", \"disasm\": " << JsonStr(n.ins.disasm) << ", \"executions\": " << r.dfg[i].second << '}';
+        }
+        os << (r.dfg.empty() ? "]" : "\n  ]") << ",\n  \"edges\": [";
+        for (size_t i = 0; i < r.dfg_edges.size(); ++i) {
+            const DfgEdge& e = r.dfg_edges[i];
+            os << (i ? "," : "") << "\n    {\"from\": " << e.from << ", \"to\": " << e.to
+               << ", \"count\": " << e.count << '}';
+        }
+        os << (r.dfg_edges.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_dead_on) {
+        // functions and the sites with dead results, most dead first
+        os << ",\n  \"dead_work\": {\"results\": " << r.dead_results << ", \"dead\": " << r.dead
+           << ", \"live_at_exit\": " << r.dead_live << ", \"functions\": [";
+        for (size_t i = 0; i < r.dead_funcs.size(); ++i) {
+            const DeadRow& f = r.dead_funcs[i];
+            os << (i ? "," : "") << "\n    {\"function\": " << JsonStr(g_funcs[f.func].name)
+               << ", \"image\": " << JsonStr(g_funcs[f.func].image)
+               << ", \"results\": " << f.results << ", \"dead\": " << f.dead << '}';
+        }
+        os << (r.dead_funcs.empty() ? "]" : "\n  ]") << ", \"sites\": [";
+        for (size_t i = 0; i < r.dead_sites.size(); ++i) {
+            const DeadRow& s = r.dead_sites[i];
+            os << (i ? "," : "") << "\n    {\"function\": " << JsonStr(g_funcs[s.func].name)
+               << ", \"image\": " << JsonStr(g_funcs[s.func].image)
+               << ", \"offset\": \"0x" << std::hex << s.info->ins.offset << std::dec
+               << "\", \"file\": " << JsonStr(s.info->line.file) << ", \"line\": " << s.info->line.line
+               << ", \"op\": \"" << BlockOpName(s.info->ins) << "\", \"disasm\": " << JsonStr(s.info->ins.disasm)
+               << ", \"results\": " << s.results << ", \"dead\": " << s.dead << '}';
+        }
+        os << (r.dead_sites.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_mulvals) {
+        // keyed by operand width in bits; empty buckets are left out
+        UINT64 sampled = 0;
+        for (int w = 0; w < MUL_WIDTHS; ++w) sampled += r.mulw[0][w];
+        os << ",\n  \"mul_widths\": {\"period\": " << g_mulvals
+           << ", \"multiplies\": " << r.mul_seen << ", \"sampled\": " << sampled;
+        static const char* const kinds[2] = {"wider", "narrower"};
+        for (int k = 0; k < 2; ++k) {
+            os << ", \"" << kinds[k] << "\": {";
+            bool first = true;
+            for (int w = 0; w < MUL_WIDTHS; ++w) {
+                if (!r.mulw[k][w]) continue;
+                os << (first ? "" : ", ") << '"' << w << "\": " << r.mulw[k][w];
+                first = false;
+            }
+            os << '}';
+        }
+        os << '}';
+    }
+
+    if (Filtering()) {
+        os << ",\n  \"filters\": {";
+        bool first = true;
+        for (int k = 0; k < FILTER_KEYS; ++k) {
+            if (g_filters[k].empty()) continue;
+            os << (first ? "" : ", ") << '"' << FILTER_KEY_NAMES[k] << "\": [";
+            for (size_t i = 0; i < g_filters[k].size(); ++i)
+                os << (i ? ", " : "") << JsonStr(g_filters[k][i].pat);
+            os << ']';
+            first = false;
+        }
+        os << '}';
+    }
+
+    if (g_go_on) {
+        // functions folded by where their code comes from
+        os << ",\n  \"go_origins\": {";
+        for (int k = 0; k < GO_ORIGINS; ++k) {
+            const Totals& t = r.origin[k];
+            os << (k ? "," : "") << "\n    \"" << GO_ORIGIN_NAMES[k] << "\": {\"functions\": "
+               << r.origin_funcs[k] << ", \"add\": " << t.add << ", \"sub\": " << t.sub
+               << ", \"mul\": " << t.mul << ", \"div\": " << t.div << JsonBits(t)
+               << JsonWideRow(t);
+            if (g_vec_on) os << ", " << JsonVec(t);
+            if (g_fp_on) os << ", " << JsonFp(t);
+            if (g_mem_on) os << ", " << JsonMem(t);
+            if (g_mod_on) os << ", " << JsonMod(t);
+            os << '}';
+        }
+        os << "\n  }";
+    }
+
+    if (!g_debug_files.empty()) {
+        os << ",\n  \"debug_info\": [";
+        for (size_t i = 0; i < g_debug_files.size(); ++i) {
+            const DebugFile& d = g_debug_files[i];
+            os << (i ? "," : "") << "\n    {\"image\": " << JsonStr(d.image)
+               << ", \"path\": " << JsonStr(d.path);
+            if (!d.build_id.empty()) os << ", \"build_id\": \"" << d.build_id << '"';
+            os << ", \"via\": \"" << d.via << "\"}";
+        }
+        os << "\n  ]";
+    }
+    if (!g_images.empty()) {
+        os << ",\n  \"images\": [";
+        for (size_t i = 0; i < g_images.size(); ++i) {
+            const ImageMap& m = g_images[i];
+            os << (i ? "," : "") << "\n    {\"path\": " << JsonStr(m.path) << std::hex
+               << ", \"low\": \"0x" << m.low << "\", \"high\": \"0x" << m.high
+               << "\", \"bias\": \"0x" << m.bias << '"' << std::dec << '}';
+        }
+        os << "\n  ]";
+    }
+    UINT32 warnings = 0;
+    for (const Diag& d : r.diags) warnings += d.level[0] == 'w';
+    os << ",\n  \"diagnostics\": {\"warnings\": " << warnings << ", \"events\": [";
+    for (size_t i = 0; i < r.diags.size(); ++i) {
+        const Diag& d = r.diags[i];
+        os << (i ? "," : "") << "\n    {\"level\": \"" << d.level << "\", \"kind\": \"" << d.kind << '"';
+        if (!d.image.empty()) os << ", \"image\": " << JsonStr(d.image);
+        if (d.count) os << ", \"count\": " << d.count;
+        os << ", \"message\": " << JsonStr(d.msg) << '}';
+    }
+    os << (r.diags.empty() ? "" : "\n  ") << "]}";
+    if (g_modules_on) {
+        os << ",\n  \"modules\": [";
+        for (size_t i = 0; i < r.modules.size(); ++i) {
+            const ModuleRow& m = r.modules[i];
+            os << (i ? "," : "") << "\n    {\"path\": " << JsonStr(m.info->path);
+            if (m.loaded) os << ", \"loaded\": \"" << m.loaded << '"';
+            if (m.info->unloaded) os << ", \"unloaded\": true";
+            os << ", \"functions\": " << m.funcs
+               << ", \"add\": " << m.t.add << ", \"sub\": " << m.t.sub
+               << ", \"mul\": " << m.t.mul << ", \"div\": " << m.t.div << JsonBits(m.t)
+               << JsonWideRow(m.t);
+            if (g_vec_on) os << ", " << JsonVec(m.t);
+            if (g_fp_on) os << ", " << JsonFp(m.t);
+            if (g_mem_on) os << ", " << JsonMem(m.t);
+            if (g_mod_on) os << ", " << JsonMod(m.t);
+            os << '}';
+        }
+        os << (r.modules.empty() ? "]" : "\n  ]");
+    }
+
+    if (g_children_on) {
+        // this process first, then children in the order they exited
+        UINT64 all[4 + BIT_OPS] = {};
+        static const char* const names[4] = {"add", "sub", "mul", "div"};
+        auto counts = [&](const UINT64* n) {
+            for (int c = 0; c < 4; ++c) os << (c ? ", " : "") << '"' << names[c] << "\": " << n[c];
+            for (int o = 0; o < BIT_OPS; ++o)
+                if (g_bit_on[o]) os << ", \"" << BIT_OP_NAMES[o] << "\": " << n[4 + o];
+        };
+        os << ",\n  \"processes\": {\"list\": [";
+        for (size_t i = 0; i < r.procs.size(); ++i) {
+            const ProcRow& p = r.procs[i];
+            os << (i ? "," : "") << "\n    {\"pid\": " << p.pid << ", \"ppid\": " << p.ppid
+               << ", \"started\": \"" << p.started << "\", \"binary\": " << JsonStr(p.binary)
+               << ", \"args\": [";
+            for (size_t j = 0; j < p.args.size(); ++j) os << (j ? ", " : "") << JsonStr(p.args[j]);
+            os << "], ";
+            counts(p.n);
+            os << '}';
+            for (int k = 0; k < 4 + BIT_OPS; ++k) all[k] += p.n[k];
+        }
+        os << "\n  ], \"total\": {";
+        counts(all);
+        os << "}}";
+    }
+
+    if (g_sampling) {
+        const SampleSummary& sm = r.sample;
+        const UINT64 est[4] = {r.total.add, r.total.sub, r.total.mul, r.total.div};
+        static const char* const names[4] = {"add", "sub", "mul", "div"};
+        os << ",\n  \"sampling\": {\"fraction\": " << g_sample_frac
+           << ", \"window\": " << g_window << ", \"seed\": " << g_seed
+           << ", \"windows_total\": " << sm.windows
+           << ", \"windows_sampled\": " << sm.windows_sampled
+           << ", \"instructions_total\": " << sm.insns
+           << ", \"instructions_sampled\": " << sm.insns_sampled
+           << ",\n    \"estimates\": {";
+        for (int c = 0; c < 4; ++c) {
+            os << (c ? ", " : "") << '"' << names[c] << "\": {\"value\": " << est[c];
+            if (sm.ci95[c] >= 0) os << ", \"ci95\": " << sm.ci95[c];
+            os << '}';
+        }
+        os << "}}";
+    }
+
+    if (g_funcs_on) {
+        os << ",\n  \"functions\": [";
+        for (size_t i = 0; i < r.funcs.size(); ++i) {
+            const FuncRow& f = r.funcs[i];
+            os << (i ? "," : "") << "\n    {\"name\": " << JsonStr(f.info->name)
+               << ", \"image\": " << JsonStr(f.info->image);
+            if (!f.info->file.empty())
+                os << ", \"file\": " << JsonStr(f.info->file)
+                   << ", \"line\": " << f.info->line;
+            if (g_go_on) os << ", \"origin\": \"" << GO_ORIGIN_NAMES[f.info->origin] << '"';
+            os << ", \"add\": " << f.t.add << ", \"sub\": " << f.t.sub
+               << ", \"mul\": " << f.t.mul << ", \"div\": " << f.t.div << JsonBits(f.t)
+               << JsonWideRow(f.t);
+            if (g_vec_on) os << ", " << JsonVec(f.t);
+            if (g_fp_on) os << ", " << JsonFp(f.t);
+            if (g_mem_on) os << ", " << JsonMem(f.t);
+            if (g_mod_on) os << ", " << JsonMod(f.t);
+            if (!g_classes.empty()) os << ", " << JsonClasses(f.t);
+            if (g_widths_on) os << ", " << JsonWidths(f.t);
+            if (g_sign_on) os << ", " << JsonSign(f.t);
+            if (g_atomics_on) os << ", " << JsonAtomics(f.t);
+            os << '}';
+        }
+        os << (r.funcs.empty() ? "]" : "\n  ]");
+    }
+
+    if (g_lines_on) {
+        os << ",\n  \"lines\": [";
+        for (size_t i = 0; i < r.lines.size(); ++i) {
+            const LineRow& l = r.lines[i];
+            os << (i ? "," : "") << "\n    {\"file\": " << JsonStr(l.info->file)
+               << ", \"line\": " << l.info->line
+               << ", \"add\": " << l.t.add << ", \"sub\": " << l.t.sub
+               << ", \"mul\": " << l.t.mul << ", \"div\": " << l.t.div << JsonBits(l.t)
+               << JsonWideRow(l.t);
+            if (g_vec_on) os << ", " << JsonVec(l.t);
+            if (g_fp_on) os << ", " << JsonFp(l.t);
+            if (g_mem_on) os << ", " << JsonMem(l.t);
+            if (g_mod_on) os << ", " << JsonMod(l.t);
+            os << '}';
+        }
+        os << (r.lines.empty() ? "]" : "\n  ]");
+    }
+
+    if (g_loops_on) {
+        os << ",\n  \"loops\": [";
+        for (size_t i = 0; i < r.loops.size(); ++i) {
+            const LoopRow& l = r.loops[i];
+            const FuncInfo& f = g_funcs[l.info->func];
+            os << (i ? "," : "") << "\n    {\"id\": " << l.id;
+            if (l.info->parent != NO_SITE) os << ", \"parent\": " << l.info->parent;
+            os << ", \"depth\": " << l.info->depth
+               << ", \"function\": " << JsonStr(f.name) << ", \"image\": " << JsonStr(f.image)
+               << ", \"offset\": \"0x" << std::hex << l.info->offset << std::dec << '"'
+               << ", \"file\": " << JsonStr(l.info->line.file) << ", \"line\": " << l.info->line.line
+               << ", \"blocks\": " << l.info->blocks
+               << ", \"entries\": " << l.entries << ", \"iterations\": " << l.iterations
+               << ", \"add\": " << l.t.add << ", \"sub\": " << l.t.sub
+               << ", \"mul\": " << l.t.mul << ", \"div\": " << l.t.div << JsonBits(l.t)
+               << JsonWideRow(l.t);
+            if (g_vec_on) os << ", " << JsonVec(l.t);
+            if (g_fp_on) os << ", " << JsonFp(l.t);
+            if (g_mem_on) os << ", " << JsonMem(l.t);
+            if (g_mod_on) os << ", " << JsonMod(l.t);
+            os << '}';
+        }
+        os << (r.loops.empty() ? "]" : "\n  ]");
+    }
+
+    if (g_calls_on) {
+        // per-row counts are add/sub/mul/div plus the -ops categories
+#define COUNTS(t) "\"add\": " << (t).add << ", \"sub\": " << (t).sub \
+                  << ", \"mul\": " << (t).mul << ", \"div\": " << (t).div << JsonBits(t)
+        os << ",\n  \"callgraph\": {\n    \"functions\": [";
+        for (size_t i = 0; i < r.calls.size(); ++i) {
+            const CallRow& c = r.calls[i];
+            os << (i ? "," : "") << "\n      {\"name\": " << JsonStr(c.info->name)
+               << ", \"image\": " << JsonStr(c.info->image)
+               << ", \"inclusive\": {" << COUNTS(c.incl)
+               << "}, \"exclusive\": {" << COUNTS(c.excl) << "}}";
+        }
+        os << (r.calls.empty() ? "]" : "\n    ]") << ",\n    \"stacks\": [";
+        for (size_t i = 0; i < r.stacks.size(); ++i) {
+            const StackRow& k = r.stacks[i];
+            os << (i ? "," : "") << "\n      {\"frames\": [";
+            for (size_t j = 0; j < k.path.size(); ++j)
+                os << (j ? ", " : "") << JsonStr(g_funcs[k.path[j]].name);
+            os << "], " << COUNTS(k.t) << JsonWideRow(k.t);
+            if (g_vec_on) os << ", " << JsonVec(k.t);
+            if (g_fp_on)  os << ", " << JsonFp(k.t);
+            if (g_mem_on) os << ", " << JsonMem(k.t);
+            if (g_mod_on) os << ", " << JsonMod(k.t);
+            if (!g_classes.empty()) os << ", " << JsonClasses(k.t);
+            if (g_atomics_on) os << ", " << JsonAtomics(k.t);
+            os << '}';
+        }
+        os << (r.stacks.empty() ? "]" : "\n    ]") << "\n  }";
+#undef COUNTS
+    }
+
+    if (g_mode == REGIONS) {
+        os << ",\n  \"regions\": [";
+        for (size_t i = 0; i < r.regions.size(); ++i) {
+            const RegionRow& g = r.regions[i];
+            os << (i ? "," : "") << "\n    {\"name\": " << JsonStr(*g.name)
+               << ", \"entries\": " << g.entries
+               << ", \"add\": " << g.t.add << ", \"sub\": " << g.t.sub
+               << ", \"mul\": " << g.t.mul << ", \"div\": " << g.t.div << JsonBits(g.t)
+               << JsonWideRow(g.t);
+            if (g_vec_on) os << ", " << JsonVec(g.t);
+            if (g_fp_on) os << ", " << JsonFp(g.t);
+            if (g_mem_on) os << ", " << JsonMem(g.t);
+            if (g_mod_on) os << ", " << JsonMod(g.t);
+            os << '}';
+        }
+        os << (r.regions.empty() ? "]" : "\n  ]");
+    }
+    if (!g_budgets.empty()) {
+        os << ",\n  \"budgets\": [";
+        bool first = true;
+        for (const auto& kv : g_budgets)
+            for (const auto& b : kv.second) {
+                os << (first ? "" : ",") << "\n    {\"region\": " << JsonStr(g_region_names[kv.first])
+                   << ", \"op\": \"" << b.op << "\", \"limit\": " << b.limit
+                   << ", \"worst\": " << b.worst << ", \"exits\": " << b.checked
+                   << ", \"over\": " << b.over << '}';
+                first = false;
+            }
+        os << (first ? "]" : "\n  ]");
+    }
+    if (g_systime_on) {
+        SysTime all;
+        std::vector<SysRow> rows = SysRows(all);
+        os << ",\n  \"syscalls\": {\"calls\": " << all.calls << ", \"errors\": " << all.errors
+           << std::fixed << std::setprecision(6) << ", \"time_sec\": " << all.ns / 1e9;
+        double user, kernel;
+        if (SysCpu(user, kernel))
+            os << ", \"kernel_cpu_sec\": " << kernel << ", \"user_cpu_sec\": " << user;
+        os << ", \"by_call\": [";
+        for (size_t i = 0; i < rows.size(); ++i)
+            os << (i ? "," : "") << "\n    {\"nr\": " << rows[i].nr << ", \"name\": "
+               << JsonStr(SyscallName(rows[i].nr)) << ", \"calls\": " << rows[i].t.calls
+               << ", \"errors\": " << rows[i].t.errors << ", \"time_sec\": " << rows[i].t.ns / 1e9
+               << ", \"max_sec\": " << rows[i].t.max_ns / 1e9 << '}';
+        os << (rows.empty() ? "]}" : "\n  ]}") << std::defaultfloat;
+    }
+
+    if (g_py_on) {
+        os << ",\n  \"python\": {\"hook_excluded\": " << (g_py_hooked ? "true" : "false")
+           << ", \"functions\": [";
+        for (size_t i = 0; i < r.python.size(); ++i) {
+            const PyRow& p = r.python[i];
+            os << (i ? "," : "") << "\n    {\"name\": " << JsonStr(p.f->name)
+               << ", \"file\": " << JsonStr(p.f->file) << ", \"line\": " << p.f->line
+               << ", \"calls\": " << p.calls
+               << ", \"add\": " << p.t.add << ", \"sub\": " << p.t.sub
+               << ", \"mul\": " << p.t.mul << ", \"div\": " << p.t.div << JsonBits(p.t)
+               << JsonWideRow(p.t);
+            if (g_vec_on) os << ", " << JsonVec(p.t);
+            if (g_fp_on) os << ", " << JsonFp(p.t);
+            if (g_mem_on) os << ", " << JsonMem(p.t);
+            if (g_mod_on) os << ", " << JsonMod(p.t);
+            os << '}';
+        }
+        os << (r.python.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_phase_mode) {
+        os << ",\n  \"phases\": {\"mode\": \"" << g_phase_mode << "\", \"list\": [";
+        for (size_t i = 0; i < r.phases.size(); ++i) {
+            const PhaseRow& p = r.phases[i];
+            os << (i ? "," : "") << "\n    {\"name\": " << JsonStr(*p.name)
+               << std::fixed << std::setprecision(3)
+               << ", \"start_sec\": " << p.start << ", \"end_sec\": " << p.end << std::defaultfloat
+               << ", \"add\": " << p.t.add << ", \"sub\": " << p.t.sub
+               << ", \"mul\": " << p.t.mul << ", \"div\": " << p.t.div << JsonBits(p.t)
+               << JsonWideRow(p.t);
+            if (g_vec_on) os << ", " << JsonVec(p.t);
+            if (g_fp_on) os << ", " << JsonFp(p.t);
+            if (g_mem_on) os << ", " << JsonMem(p.t);
+            if (g_mod_on) os << ", " << JsonMod(p.t);
+            os << '}';
+        }
+        os << (r.phases.empty() ? "]}" : "\n  ]}");
+    }
+
+    if (g_threads_on) {
+        os << ",\n  \"threads\": [";
+        for (size_t i = 0; i < r.threads.size(); ++i) {
+            const ThreadRow& t = r.threads[i];
+            os << (i ? "," : "") << "\n    {\"tid\": " << t.st->tid
+               << ", \"os_tid\": " << t.st->os_tid;
+            if (t.st->parent != INVALID_OS_THREAD_ID)
+                os << ", \"parent_os_tid\": " << t.st->parent;
+            os << ", \"exited\": " << (t.st->exited ? "true" : "false");
+            if (t.st->exited) os << ", \"exit_code\": " << t.st->exit_code;
+            os << ", \"add\": " << t.t.add << ", \"sub\": " << t.t.sub
+               << ", \"mul\": " << t.t.mul << ", \"div\": " << t.t.div
+               << JsonBits(t.t) << '}';
+        }
+        os << (r.threads.empty() ? "]" : "\n  ]");
+    }
+    if (g_percpu_on) {
+        os << ",\n  \"cpus\": [";
+        for (size_t i = 0; i < r.cpus.size(); ++i) {
+            const CpuRow& c = r.cpus[i];
+            os << (i ? "," : "") << "\n    {\"cpu\": " << c.cpu << ", \"node\": " << c.node
+               << ", \"threads\": " << c.threads
+               << ", \"add\": " << c.t.add << ", \"sub\": " << c.t.sub
+               << ", \"mul\": " << c.t.mul << ", \"div\": " << c.t.div
+               << JsonBits(c.t) << '}';
+        }
+        os << (r.cpus.empty() ? "]" : "\n  ]") << ",\n  \"numa_nodes\": [";
+        for (size_t i = 0; i < r.nodes.size(); ++i) {
+            const NodeRow& n = r.nodes[i];
+            os << (i ? "," : "") << "\n    {\"node\": " << n.node << ", \"cpus\": " << n.cpus
+               << ", \"add\": " << n.t.add << ", \"sub\": " << n.t.sub
+               << ", \"mul\": " << n.t.mul << ", \"div\": " << n.t.div
+               << JsonBits(n.t) << '}';
+        }
+        os << (r.nodes.empty() ? "]" : "\n  ]");
+    }
+    os << "\n}\n";
+}
+
+// ── CSV / TSV report ────────────────────────────────────────────────────────
+// Column sets depend only on the selected options, never on the counts, so
+// files from runs with the same flags line up.  Op-type columns are
+// add..div, the -ops categories, then vec_*, wide_*, fp64_*, fp32_* and
+// mem_*.
+//
+//   long:  scope,function,image,file,line,category,instruction,form,count
+//          scope "total"       – one row per op type
+//          scope "instruction" – one row per instruction and operand form
+//          scope "function"    – one row per function and op type (-funcs)
+//   wide:  function,image,file,line,<op types…>, one row per function
+//
+// Fields are quoted as by Go's encoding/csv.
+static std::string CsvField(const std::string& f, char sep)
+{
+    bool quote = f == "\\." || (!f.empty() && std::isspace((unsigned char)f[0]));
+    for (char c : f)
+        if (c == sep || c == '"' || c == '\n' || c == '\r') quote = true;
+    if (!quote) return f;
+    std::string q = "\"";
+    for (char c : f) q += c == '"' ? "\"\"" : std::string(1, c);
+    return q + '"';
+}
+
+static std::vector<std::string> CsvOpNames()
+{
+    std::vector<std::string> v = {"add", "sub", "mul", "div"};
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) v.push_back(BIT_OP_NAMES[o]);
+    if (g_vec_on)
+        for (int o = 0; o < VEC_OPS; ++o) v.push_back(std::string("vec_") + VEC_OP_NAMES[o]);
+    if (g_wide_on)
+        for (int k = 0; k < WIDE_KINDS; ++k) v.push_back(std::string("wide_") + WIDE_KIND_NAMES[k]);
+    if (g_fp_on)
+        for (int p = 0; p < FP_PRECS; ++p)
+            for (int o = 0; o < FP_OPS; ++o)
+                v.push_back(std::string(FP_PREC_NAMES[p]) + '_' + FP_OP_NAMES[o]);
+    if (g_mem_on)
+        for (int k = 0; k < MemKinds(); ++k) v.push_back(std::string("mem_") + MEM_KIND_NAMES[k]);
+    return v;
+}
+
+// Values in CsvOpNames() order
+static std::vector<UINT64> CsvOpValues(const Totals& t)
+{
+    std::vector<UINT64> v = {t.add, t.sub, t.mul, t.div};
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) v.push_back(t.bit[o]);
+    if (g_vec_on)
+        for (int o = 0; o < VEC_OPS; ++o) v.push_back(t.vec[o]);
+    if (g_wide_on)
+        for (int k = 0; k < WIDE_KINDS; ++k) v.push_back(t.WideSum(static_cast<WideKind>(k)));
+    if (g_fp_on)
+        for (int p = 0; p < FP_PRECS; ++p)
+            for (int o = 0; o < FP_OPS; ++o) v.push_back(t.fp[p][o]);
+    if (g_mem_on)
+        for (int k = 0; k < MemKinds(); ++k) v.push_back(t.mem[k]);
+    return v;
+}
+
+// function,image,file,line of a breakdown row
+static std::string CsvFunc(const FuncInfo& f, char sep)
+{
+    std::ostringstream os;
+    os << CsvField(f.name, sep) << sep << CsvField(f.image, sep) << sep;
+    if (!f.file.empty()) os << CsvField(f.file, sep) << sep << f.line;
+    else                 os << sep;
+    return os.str();
+}
+
+static VOID PrintCsv(std::ostream& os, const Report& r, char sep)
+{
+    const std::vector<std::string> ops = CsvOpNames();
+
+    if (knobLayout.Value() == "wide") {
+        os << "function" << sep << "image" << sep << "file" << sep << "line";
+        for (const auto& o : ops) os << sep << o;
+        os << '\n';
+        for (const auto& f : r.funcs) {
+            os << CsvFunc(*f.info, sep);
+            for (UINT64 v : CsvOpValues(f.t)) os << sep << v;
+            os << '\n';
+        }
+        return;
+    }
+
+    const char* cols[] = {"scope", "function", "image", "file", "line",
+                          "category", "instruction", "form", "count"};
+    for (size_t i = 0; i < 9; ++i) os << (i ? std::string(1, sep) : "") << cols[i];
+    os << '\n';
+
+    const std::string blank4 = std::string(4, sep);   // function..line
+    std::vector<UINT64> tv = CsvOpValues(r.total);
+    for (size_t i = 0; i < ops.size(); ++i)
+        os << "total" << blank4 << sep << ops[i] << sep << sep << sep << tv[i] << '\n';
+
+    const Cnts& c = r.raw;
+    struct Insn { const char* cat; const char* name; UINT64 rr, rm; };
+    std::vector<Insn> insns = {
+        {"add", "add",  c.add_rr,  c.add_rm},  {"add", "adc",  c.adc_rr,  c.adc_rm},
+        {"add", "adcx", c.adcx_rr, c.adcx_rm}, {"add", "adox", c.adox_rr, c.adox_rm},
+        {"sub", "sub",  c.sub_rr,  c.sub_rm},  {"sub", "sbb",  c.sbb_rr,  c.sbb_rm},
+        {"mul", "mul",  c.mul_rr,  c.mul_rm},  {"mul", "mulx", c.mulx_rr, c.mulx_rm},
+        {"div", "div",  c.div_rr,  c.div_rm},
+    };
+    if (g_compound == CMP_SPLIT) insns.push_back({"add", "lea", c.lea_rr, 0});
+    if (g_agen == AGEN_FOLD) insns.push_back({"add", "agen", c.agen[AG_LEA_ADD], c.agen[AG_MEM_ADD]});
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) {
+            insns.push_back({BIT_OP_NAMES[o], BIT_OP_NAMES[o], c.bit[o][0], c.bit[o][1]});
+            if (o == BSHL && g_agen == AGEN_FOLD)
+                insns.push_back({"shl", "agen", c.agen[AG_LEA_SHL], c.agen[AG_MEM_SHL]});
+        }
+    for (const auto& in : insns) {
+        os << "instruction" << blank4 << sep << in.cat << sep << in.name
+           << sep << "rr" << sep << in.rr << '\n';
+        os << "instruction" << blank4 << sep << in.cat << sep << in.name
+           << sep << "rm" << sep << in.rm << '\n';
+    }
+
+    for (const auto& f : r.funcs) {
+        std::vector<UINT64> fv = CsvOpValues(f.t);
+        for (size_t i = 0; i < ops.size(); ++i)
+            os << "function" << sep << CsvFunc(*f.info, sep) << sep << ops[i]
+               << sep << sep << sep << fv[i] << '\n';
+    }
+}
+
+// ── collapsed stacks (-folded) ──────────────────────────────────────────────
+// "main;solve;mulmod 1000": one line per calling context with a non-zero
+// weight, the sum of the selected op types (CsvOpNames) in its exclusive
+// counts.  ';' inside names becomes ':'; counts outside any known function
+// are reported under [unknown].
+static std::vector<size_t> g_weight;   // indices into CsvOpNames()
+
+static bool ParseWeight(const std::string& list)
+{
+    const std::vector<std::string> names = CsvOpNames();
+    std::set<size_t> sel;
+    std::istringstream in(list);
+    std::string tok;
+    while (std::getline(in, tok, ',')) {
+        // a group selects every enabled op type with its prefix
+        std::string prefix = tok == "vec" || tok == "wide" || tok == "fp64" ||
+                             tok == "fp32" || tok == "fp" ? tok : "";
+        size_t before = sel.size();
+        for (size_t i = 0; i < names.size(); ++i) {
+            const std::string& n = names[i];
+            bool base = i < 4, bit = !base && n.find('_') == std::string::npos;
+            if (n == tok || (tok == "int" && base) || (tok == "bitwise" && bit) ||
+                (!prefix.empty() && n.compare(0, prefix.size(), prefix) == 0))
+                sel.insert(i);
+        }
+        if (sel.size() == before) {
+            std::cerr << "Int64Profiler: -folded_weight " << tok
+                      << " is unknown or not enabled" << std::endl;
+            return false;
+        }
+    }
+    g_weight.assign(sel.begin(), sel.end());
+    return !g_weight.empty();
+}
+
+static VOID PrintFolded(std::ostream& os, const Report& r)
+{
+    for (const auto& s : r.stacks) {
+        std::vector<UINT64> v = CsvOpValues(s.t);
+        UINT64 w = 0;
+        for (size_t i : g_weight) w += v[i];
+        if (w == 0) continue;
+        if (s.path.empty()) os << "[unknown]";
+        for (size_t i = 0; i < s.path.size(); ++i) {
+            std::string name = g_funcs[s.path[i]].name;
+            std::replace(name.begin(), name.end(), ';', ':');
+            os << (i ? ";" : "") << name;
+        }
+        os << ' ' << w << '\n';
+    }
+}
+
+static VOID PrintReport(std::ostream& os, const Report& r)
+{
+    const std::string& f = knobFormat.Value();
+    if      (f == "json") PrintJson(os, r);
+    else if (f == "csv")  PrintCsv(os, r, ',');
+    else if (f == "tsv")  PrintCsv(os, r, '\t');
+    else                  PrintText(os, r);
+}
+
+// ── separate debug info (-debug_dir) ────────────────────────────────────────
+// A stripped image gets the function symbols of its separate debug file,
+// found as GDB finds it: by GNU build ID under DIR/.build-id/xx/yyyy.debug
+// of every -debug_dir and /usr/lib/debug, in the debuginfod client cache,
+// then by its .gnu_debuglink name next to it, in its .debug directory and
+// under each debug directory, checked by CRC.  Pin reads DWARF lines from
+// the image alone, so iccad fills in the functions' source locations.
+#if defined(TARGET_LINUX)
+struct ElfSec {                      // Elf64_Shdr
+    UINT32 name, type;
+    UINT64 flags, addr, off, size;
+    UINT32 link, info;
+    UINT64 align, entsize;
+};
+
+// The section headers of a 64-bit little-endian ELF file, and the
+// sections read on demand.
+class ElfReader {
+  public:
+    bool Open(const std::string& path)
+    {
+        in.open(path.c_str(), std::ios::binary);
+        char eh[64];
+        if (!in.read(eh, sizeof eh) || memcmp(eh, "\177ELF", 4) || eh[4] != 2 || eh[5] != 1) return false;
+        UINT64 shoff; UINT16 shentsize, shnum, shstrndx;
+        memcpy(&shoff, eh + 40, 8);
+        memcpy(&shentsize, eh + 58, 2);
+        memcpy(&shnum, eh + 60, 2);
+        memcpy(&shstrndx, eh + 62, 2);
+        if (shentsize != sizeof(ElfSec) || shnum == 0 || shstrndx >= shnum) return false;
+        secs.resize(shnum);
+        in.seekg(shoff);
+        if (!in.read(reinterpret_cast<char*>(&secs[0]), shnum * sizeof(ElfSec))) return false;
+        names = Data(secs[shstrndx]);
+        return true;
+    }
+    const ElfSec* Find(const char* name) const
+    {
+        for (const ElfSec& s : secs)
+            if (s.name < names.size() && strcmp(names.c_str() + s.name, name) == 0) return &s;
+        return nullptr;
+    }
+    const ElfSec* FindType(UINT32 type) const
+    {
+        for (const ElfSec& s : secs)
+            if (s.type == type) return &s;
+        return nullptr;
+    }
+    std::string Data(const ElfSec& s)
+    {
+        if (s.type == 8 /* SHT_NOBITS */ || s.size > (1ULL << 32)) return "";
+        std::string d(s.size, '\0');
+        in.clear();
+        in.seekg(s.off);
+        if (!in.read(&d[0], s.size)) return "";
+        return d;
+    }
+    std::vector<ElfSec> secs;
+
+  private:
+    std::ifstream in;
+    std::string names;
+};
+
+static bool FileExists(const std::string& path)
+{
+    struct stat st;
+    return stat(path.c_str(), &st) == 0 && S_ISREG(st.st_mode);
+}
+
+// The CRC-32 of a file, as .gnu_debuglink records it.
+static UINT32 FileCrc(const std::string& path)
+{
+    static UINT32 table[256];
+    if (!table[1]) {
+        for (UINT32 i = 0; i < 256; i++) {
+            UINT32 c = i;
+            for (int k = 0; k < 8; k++) c = c & 1 ? 0xEDB88320 ^ (c >> 1) : c >> 1;
+            table[i] = c;
+        }
+    }
+    std::ifstream in(path.c_str(), std::ios::binary);
+    std::vector<char> buf(1 << 16);
+    UINT32 crc = 0xFFFFFFFF;
+    while (in.read(&buf[0], buf.size()) || in.gcount() > 0) {
+        for (std::streamsize i = 0; i < in.gcount(); i++)
+            crc = table[(crc ^ static_cast<unsigned char>(buf[i])) & 0xFF] ^ (crc >> 8);
+    }
+    return crc ^ 0xFFFFFFFF;
+}
+
+// The debuginfod client cache directory, as elfutils names it.
+static std::string DebuginfodCache()
+{
+    const char* c = getenv("DEBUGINFOD_CACHE_PATH");
+    if (c && *c) return c;
+    const char* x = getenv("XDG_CACHE_HOME");
+    if (x && *x) return std::string(x) + "/debuginfod_client";
+    const char* h = getenv("HOME");
+    return h && *h ? std::string(h) + "/.cache/debuginfod_client" : "";
+}
+
+// Finds the separate debug file of the image read by im into d.
+static bool FindDebugFile(ElfReader& im, DebugFile& d)
+{
+    std::vector<std::string> dirs;
+    for (UINT32 i = 0; i < knobDebugDir.NumberOfValues(); i++)
+        if (!knobDebugDir.Value(i).empty()) dirs.push_back(knobDebugDir.Value(i));
+    dirs.push_back("/usr/lib/debug");
+
+    for (const ElfSec& s : im.secs) {
+        if (s.type != 7 /* SHT_NOTE */ || !d.build_id.empty()) continue;
+        std::string n = im.Data(s);
+        for (size_t at = 0; at + 12 <= n.size();) {
+            UINT32 nsz, dsz, type;
+            memcpy(&nsz, &n[at], 4); memcpy(&dsz, &n[at + 4], 4); memcpy(&type, &n[at + 8], 4);
+            size_t desc = at + 12 + ((nsz + 3) & ~3u), next = desc + ((dsz + 3) & ~3u);
+            if (next > n.size()) break;
+            if (type == 3 && nsz == 4 && n.compare(at + 12, 3, "GNU") == 0) {   // NT_GNU_BUILD_ID
+                static const char* hex = "0123456789abcdef";
+                for (UINT32 i = 0; i < dsz; i++) {
+                    d.build_id += hex[static_cast<unsigned char>(n[desc + i]) >> 4];
+                    d.build_id += hex[n[desc + i] & 0xF];
+                }
+                break;
+            }
+            at = next;
+        }
+    }
+    if (d.build_id.size() > 2) {
+        for (const std::string& dir : dirs) {
+            d.path = dir + "/.build-id/" + d.build_id.substr(0, 2) + "/" + d.build_id.substr(2) + ".debug";
+            if (FileExists(d.path)) { d.via = "build-id"; return true; }
+        }
+        std::string cache = DebuginfodCache();
+        d.path = cache + "/" + d.build_id + "/debuginfo";
+        if (!cache.empty() && FileExists(d.path)) { d.via = "debuginfod"; return true; }
+    }
+
+    const ElfSec* dl = im.Find(".gnu_debuglink");
+    std::string link = dl ? im.Data(*dl) : "";
+    size_t nul = link.find('\0'), at = (nul + 4) & ~size_t(3);
+    if (nul == std::string::npos || nul == 0 || at + 4 > link.size()) return false;
+    std::string name = link.substr(0, nul);
+    UINT32 crc;
+    memcpy(&crc, &link[at], 4);
+    std::string dir = d.image.substr(0, d.image.rfind('/') + 1);
+    std::vector<std::string> cands = {dir + name, dir + ".debug/" + name};
+    for (const std::string& dd : dirs) cands.push_back(dd + dir + name);
+    for (const std::string& c : cands) {
+        if (c != d.image && FileExists(c) && FileCrc(c) == crc) {
+            d.path = c;
+            d.via = "debuglink";
+            return true;
+        }
+    }
+    return false;
+}
+
+// Names the routines of the stripped image img from its debug file's
+// symbol table.  Pin has made one routine of each unnamed stretch of
+// code; RTN_CreateAt splits it at every function the debug file names.
+static VOID LoadDebugSymbols(IMG img)
+{
+    ElfReader im;
+    if (!im.Open(IMG_Name(img)) || im.FindType(2 /* SHT_SYMTAB */)) return;
+    DebugFile d;
+    d.image = IMG_Name(img);
+    ElfReader dbg;
+    if (!FindDebugFile(im, d) || !dbg.Open(d.path)) {
+        g_diags.push_back({"info", "unresolved_symbols", d.image, 0,
+                           "stripped and no debug file found: functions named from the dynamic symbols only"});
+        return;
+    }
+    const ElfSec* st = dbg.FindType(2);
+    if (!st || st->link >= dbg.secs.size()) return;
+    std::string syms = dbg.Data(*st), strs = dbg.Data(dbg.secs[st->link]);
+    UINT32 named = 0;
+    for (size_t at = 0; at + 24 <= syms.size(); at += 24) {   // Elf64_Sym
+        UINT32 name;
+        UINT16 shndx;
+        UINT64 value;
+        memcpy(&name, &syms[at], 4);
+        memcpy(&shndx, &syms[at + 6], 2);
+        memcpy(&value, &syms[at + 8], 8);
+        if ((syms[at + 4] & 0xF) != 2 /* STT_FUNC */ || value == 0 || shndx == 0 || name >= strs.size())
+            continue;
+        ADDRINT addr = value + IMG_LoadOffset(img);
+        RTN rtn = RTN_FindByAddress(addr);
+        if (!RTN_Valid(rtn) || RTN_Address(rtn) == addr) continue;   // not code, or named already
+        if (RTN_Valid(RTN_CreateAt(addr, strs.c_str() + name))) named++;
+    }
+    DBG(1, "Debug info: " << d.image << " → " << d.path << " (" << d.via << ", "
+           << named << " functions)");
+    if (named) g_debug_files.push_back(d);
+}
+#else
+static VOID LoadDebugSymbols(IMG) {}
+#endif
+
+// ── binary metadata ─────────────────────────────────────────────────────────
+// Images mapped once the program's entry point has run came from dlopen();
+// the executable's dependencies are all in place by then.
+static VOID EnterProgram() { g_entered = true; }
+
+static VOID AppStart(VOID*) { if (g_attached) g_entered = true; }
+
+static VOID ImageLoad(IMG img, VOID*)
+{
+    LoadDebugSymbols(img);
+    g_images.push_back({IMG_Name(img), IMG_LowAddress(img), IMG_HighAddress(img), IMG_LoadOffset(img)});
+    if (ModuleSkipped(IMG_Name(img))) {
+        g_diags.push_back({"info", "skipped_module", IMG_Name(img), 0,
+                           "left out by the module filters: not counted"});
+        DBG(1, "Module skipped: " << IMG_Name(img));
+    }
+    if (IMG_IsMainExecutable(img)) {
+        g_binary = IMG_Name(img);
+        g_load_offset = IMG_LoadOffset(img);
+    }
+    if (!g_modules_on) return;
+
+    ModuleInfo mi;
+    mi.path = IMG_Name(img);
+    mi.dynamic = g_entered;
+    g_module_ids[IMG_Id(img)] = static_cast<UINT32>(g_modules.size());
+    g_modules.push_back(mi);
+    DBG(1, "Module #" << g_modules.size() - 1 << ": " << mi.path
+           << (mi.dynamic ? " (dlopen)" : ""));
+
+    RTN entry = RTN_FindByAddress(IMG_EntryAddress(img));
+    if (IMG_IsMainExecutable(img) && RTN_Valid(entry)) {
+        RTN_Open(entry);
+        RTN_InsertCall(entry, IPOINT_BEFORE, (AFUNPTR)EnterProgram, IARG_END);
+        RTN_Close(entry);
+    }
+}
+
+// Pin unloads every image at exit as well; only dlclose() before that counts
+static VOID ModulesExit(VOID*) { g_modules_exit = true; }
+
+static VOID ImageUnload(IMG img, VOID*)
+{
+    if (g_modules_exit) return;
+    auto it = g_module_ids.find(IMG_Id(img));
+    if (it == g_module_ids.end()) return;
+    g_modules[it->second].unloaded = true;
+    DBG(1, "Module #" << it->second << " unloaded");
+}
+
+// ── live snapshots ──────────────────────────────────────────────────────────
+// An internal thread sums every thread's counters once per -stream period.
+// They are read while the application updates them, so a snapshot may lag
+// by a few instructions; the final one, written at exit, is exact.  The
+// per-function counts live in vectors the application threads grow, so
+// with -stream_funcs the application is stopped while they are summed.
+static std::ofstream   g_stream_file;
+static std::ostream*   g_stream_out = &std::cerr;
+static PIN_THREAD_UID  g_stream_uid;
+static volatile bool   g_stream_stop = false;
+static bool            g_stream_done = false;     // final snapshot written
+static Totals          g_stream_last;
+static double          g_stream_at = 0;           // time of the last snapshot
+static UINT64          g_stream_seq = 0;
+
+static Totals Minus(const Totals& a, const Totals& b)
+{
+    Totals d;
+    d.add = a.add - b.add; d.sub = a.sub - b.sub;
+    d.mul = a.mul - b.mul; d.div = a.div - b.div;
+    for (int o = 0; o < BIT_OPS; ++o) d.bit[o] = a.bit[o] - b.bit[o];
+    for (int k = 0; k < WIDE_KINDS; ++k)
+        for (int w = 0; w < WIDE_SLOTS; ++w) d.wide[k][w] = a.wide[k][w] - b.wide[k][w];
+    for (int v = 0; v < VEC_OPS; ++v) d.vec[v] = a.vec[v] - b.vec[v];
+    for (int m = 0; m < MEM_KINDS; ++m) d.mem[m] = a.mem[m] - b.mem[m];
+    for (int m = 0; m < MOD_KINDS; ++m) d.mod[m] = a.mod[m] - b.mod[m];
+    for (int p = 0; p < FP_PRECS; ++p)
+        for (int o = 0; o < FP_OPS; ++o) d.fp[p][o] = a.fp[p][o] - b.fp[p][o];
+    for (int k = 0; k < MAX_CLASSES; ++k) d.cls[k] = a.cls[k] - b.cls[k];
+    for (int k = 0; k < AGEN_KINDS; ++k) d.agen[k] = a.agen[k] - b.agen[k];
+    for (int k = 0; k < WIDTH_CATS; ++k)
+        for (int w = 0; w < WIDTH_SLOTS; ++w) d.width[k][w] = a.width[k][w] - b.width[k][w];
+    for (int k = 0; k < 2; ++k)
+        for (int s = 0; s < SIGN_KINDS; ++s) d.sign[k][s] = a.sign[k][s] - b.sign[k][s];
+    for (int k = 0; k < ATOMIC_KINDS; ++k) d.atomic[k] = a.atomic[k] - b.atomic[k];
+    return d;
+}
+
+// {"add": n, …} with the optional categories that are enabled
+static VOID JsonSnapshotCounts(std::ostream& os, const Totals& t)
+{
+    os << "{\"add\": " << t.add << ", \"sub\": " << t.sub
+       << ", \"mul\": " << t.mul << ", \"div\": " << t.div;
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) os << ", \"" << BIT_OP_NAMES[o] << "\": " << t.bit[o];
+    if (g_vec_on)  os << ", \"vec\": " << t.VecSum();
+    if (g_wide_on) os << ", \"wide\": " << t.WideSum();
+    if (g_fp_on)   os << ", \"fp64\": " << t.FpSum(FP64) << ", \"fp32\": " << t.FpSum(FP32);
+    if (g_mem_on)
+        for (int m = 0; m < MEM_ACCESS_KINDS; ++m)
+            os << ", \"" << MEM_KIND_NAMES[m] << "\": " << t.mem[m];
+    os << '}';
+}
+
+// [{"name": …, "image": …, "cumulative": {…}}, …] for the -stream_funcs
+// functions with the most operations
+static VOID JsonSnapshotFuncs(std::ostream& os)
+{
+    std::vector<Cnts> funcs(g_funcs.size());
+    for (auto* st : g_all)
+        for (size_t i = 0; i < st->sites.size(); ++i) Accumulate(funcs[g_sites[i].func], st->sites[i]);
+    std::vector<FuncRow> rows;
+    for (size_t i = 0; i < funcs.size(); ++i) {
+        Totals t = Summarize(funcs[i]);
+        if (t.Weight() != 0) rows.push_back({&g_funcs[i], t});
+    }
+    std::stable_sort(rows.begin(), rows.end(),
+                     [](const FuncRow& a, const FuncRow& b)
+                     { return a.t.Weight() > b.t.Weight(); });
+    if (rows.size() > g_stream_funcs) rows.resize(g_stream_funcs);
+    os << '[';
+    for (size_t i = 0; i < rows.size(); ++i) {
+        os << (i ? ", " : "") << "{\"name\": " << JsonStr(rows[i].info->name)
+           << ", \"image\": " << JsonStr(rows[i].info->image) << ", \"cumulative\": ";
+        JsonSnapshotCounts(os, rows[i].t);
+        os << '}';
+    }
+    os << ']';
+}
+
+// funcs: the per-function counts may be read (application stopped or done)
+static VOID EmitSnapshot(bool final, bool funcs = true)
+{
+    PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+    if (g_stream_done) {
+        PIN_ReleaseLock(&g_lock);
+        return;
+    }
+    Cnts c{};
+    for (auto* st : g_all) Accumulate(c, st->cnts);
+    Totals t = Summarize(c);
+    double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+
+    std::ostringstream os;
+    os << "{\"seq\": " << ++g_stream_seq << ", \"pid\": " << PIN_GetPid()
+       << ", \"elapsed_sec\": " << now << ", \"interval_sec\": " << now - g_stream_at
+       << ", \"threads\": " << g_all.size();
+    if (final) os << ", \"final\": true";
+    os << ", \"cumulative\": ";
+    JsonSnapshotCounts(os, t);
+    os << ", \"delta\": ";
+    JsonSnapshotCounts(os, Minus(t, g_stream_last));
+    if (g_stream_funcs && funcs) {
+        os << ", \"functions\": ";
+        JsonSnapshotFuncs(os);
+    }
+    os << "}\n";
+    *g_stream_out << os.str() << std::flush;
+    g_stream_last = t;
+    g_stream_at = now;
+    g_stream_done = final;
+    PIN_ReleaseLock(&g_lock);
+}
+
+static VOID StreamController(VOID*)
+{
+    double next = g_stream;
+    while (!g_stream_stop) {
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        if (now < next) {   // at most 100 ms, so that StreamExit does not wait
+            PIN_Sleep(std::min<UINT32>(100, std::max<UINT32>(1, static_cast<UINT32>((next - now) * 1000))));
+            continue;
+        }
+        if (g_stream_funcs) {
+            bool stopped = PIN_StopApplicationThreads(PIN_ThreadId());
+            EmitSnapshot(false, stopped);
+            if (stopped) PIN_ResumeApplicationThreads(PIN_ThreadId());
+        } else {
+            EmitSnapshot(false);
+        }
+        while (next <= now) next += g_stream;   // skip the periods missed
+    }
+}
+
+static VOID StreamExit(VOID*)
+{
+    g_stream_stop = true;
+    PIN_WaitForThreadTermination(g_stream_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// ── checkpoints ─────────────────────────────────────────────────────────────
+// With -checkpoint an internal thread stops the application every
+// -checkpoint_interval seconds and writes what has been counted so far, next
+// to the file and renamed into place, so a run that dies (reboot, OOM kill)
+// leaves the last complete one.  -resume starts a new run from it: the
+// totals, functions, lines, regions, mix and compound counts carry over;
+// the other sections cover this run alone.
+static const char* const CHECKPOINT_MAGIC = "Int64Profiler checkpoint 1";
+static PIN_THREAD_UID  g_ckpt_uid;
+static volatile bool   g_ckpt_stop = false;
+static UINT64          g_ckpt_seq = 0;          // written, the resumed runs' included
+static size_t          g_ckpt_diag = ~size_t(0);   // its g_diags entry, once one failed
+
+// The knobs the counts depend on, which a resumed run must repeat
+static std::string CheckpointOptions()
+{
+    const std::pair<const char*, KNOB<std::string>*> knobs[] = {
+        {"addr", &knobAddr}, {"start", &knobStart}, {"stop", &knobStop},
+        {"regions", &knobRegions}, {"funcs", &knobFuncs}, {"modules", &knobModules},
+        {"lines", &knobLines}, {"loops", &knobLoops}, {"fp", &knobFp}, {"wide", &knobWide},
+        {"widths", &knobWidths}, {"signedness", &knobSignedness}, {"atomics", &knobAtomics},
+        {"vec", &knobVec}, {"mem", &knobMem}, {"cache", &knobCache}, {"mix", &knobMix},
+        {"compound", &knobCompound}, {"agen", &knobAgen}, {"modarith", &knobModArith},
+        {"ops", &knobOps}, {"class", &knobClass}, {"watch", &knobWatch}, {"include", &knobInclude},
+        {"exclude", &knobExclude}, {"include_func", &knobIncludeFunc},
+        {"exclude_func", &knobExcludeFunc}, {"include_module", &knobIncludeModule},
+        {"exclude_module", &knobExcludeModule}, {"go", &knobGo}, {"jit", &knobJit},
+    };
+    std::string s;
+    for (const auto& k : knobs)
+        for (UINT32 i = 0; i < k.second->NumberOfValues(); ++i)
+            s += std::string(s.empty() ? "" : " ") + '-' + k.first + '=' + k.second->Value(i);
+    return s;
+}
+
+// Tabs and newlines would break the line format
+static std::string Field(std::string s)
+{
+    std::replace(s.begin(), s.end(), '\t', ' ');
+    std::replace(s.begin(), s.end(), '\n', ' ');
+    return s;
+}
+
+static VOID WriteWords(std::ostream& os, const Cnts& c)
+{
+    const UINT64* w = Words(c);
+    for (size_t i = 0; i < NumWords(c); ++i) os << (i ? " " : "") << w[i];
+}
+
+static bool ReadWords(std::istream& is, Cnts& c)
+{
+    UINT64* w = Words(c);
+    for (size_t i = 0; i < NumWords(c); ++i)
+        if (!(is >> w[i])) return false;
+    return true;
+}
+
+// CheckpointFailed reports that a checkpoint could not be written at
+// step what, with errno if set: on stderr, and in the report's diagnostics as one
+// warning counting the checkpoints lost, since `iccad run` drops pin's
+// output
+static VOID CheckpointFailed(const char* what)
+{
+    std::string err = what;
+    if (errno) err += std::string(": ") + strerror(errno);
+    std::cerr << "Int64Profiler: checkpoint " << knobCheckpoint.Value() << ": " << err << std::endl;
+    std::string msg = "checkpoints could not be written (" + err + "); a run killed now resumes from an older one or none";
+    if (g_ckpt_diag < g_diags.size()) {
+        g_diags[g_ckpt_diag].count++;
+        g_diags[g_ckpt_diag].msg = msg;
+        return;
+    }
+    g_ckpt_diag = g_diags.size();
+    g_diags.push_back({"warn", "checkpoint_failed", "", 1, msg});
+}
+
+// Called with the application stopped
+static VOID WriteCheckpoint(double now)
+{
+    Cnts total{};
+    std::vector<Cnts> funcs, lines, loops;
+    FoldSites(total, funcs, lines, loops);
+    UINT64 mix[MIX_KINDS], compound[COMPOUND_KINDS];
+    std::copy(g_carry.mix, g_carry.mix + MIX_KINDS, mix);
+    std::copy(g_carry.compound, g_carry.compound + COMPOUND_KINDS, compound);
+    for (auto* st : g_all) {
+        for (int k = 0; k < MIX_KINDS; ++k) mix[k] += st->mix[k];
+        for (int k = 0; k < COMPOUND_KINDS; ++k) compound[k] += st->compound[k];
+    }
+
+    std::string tmp = knobCheckpoint.Value() + ".part";
+    {
+        int fd = open(tmp.c_str(), O_WRONLY | O_CREAT | O_TRUNC, 0644);   // for errno
+        if (fd < 0) {
+            CheckpointFailed("cannot create it");
+            return;
+        }
+        close(fd);
+        std::ofstream os(tmp.c_str());
+        os << CHECKPOINT_MAGIC << "\nwords " << NumWords(total)
+           << "\noptions " << CheckpointOptions()
+           << "\nseq " << ++g_ckpt_seq << "\nelapsed " << std::setprecision(17)
+           << g_carry.elapsed + now << std::defaultfloat << "\ntotal ";
+        WriteWords(os, total);
+        os << "\nmix";
+        for (int k = 0; k < MIX_KINDS; ++k) os << ' ' << mix[k];
+        os << "\ncompound";
+        for (int k = 0; k < COMPOUND_KINDS; ++k) os << ' ' << compound[k];
+        os << '\n';
+        for (size_t i = 0; i < funcs.size(); ++i) {
+            if (Zero(funcs[i])) continue;
+            const FuncInfo& fi = g_funcs[i];
+            os << "func\t" << Field(fi.name) << '\t' << Field(fi.image) << '\t' << Field(fi.file)
+               << '\t' << fi.line << '\t' << fi.origin << '\t';
+            WriteWords(os, funcs[i]);
+            os << '\n';
+        }
+        for (size_t i = 0; i < lines.size(); ++i) {
+            if (Zero(lines[i])) continue;
+            os << "line\t" << Field(g_lines[i].file) << '\t' << g_lines[i].line << '\t';
+            WriteWords(os, lines[i]);
+            os << '\n';
+        }
+        if (g_mode == REGIONS) {
+            std::vector<Cnts>   rc;
+            std::vector<UINT64> entries;
+            FoldRegions(rc, entries);
+            for (size_t i = 0; i < rc.size(); ++i) {
+                os << "region\t" << Field(g_region_names[i]) << '\t' << entries[i] << '\t';
+                WriteWords(os, rc[i]);
+                os << '\n';
+            }
+        }
+        os << "end\n";
+        if (!os.flush()) {
+            CheckpointFailed("cannot write it");
+            std::remove(tmp.c_str());
+            return;
+        }
+    }
+    if (std::rename(tmp.c_str(), knobCheckpoint.Value().c_str()) != 0) {
+        CheckpointFailed("cannot rename it into place");
+        std::remove(tmp.c_str());
+        return;
+    }
+    DBG(1, "Checkpoint " << g_ckpt_seq << " after " << now << " s");
+}
+
+static VOID CheckpointController(VOID*)
+{
+    double every = strtod(knobCheckpointInterval.Value().c_str(), nullptr);
+    double next = every;
+    while (!g_ckpt_stop) {
+        PIN_Sleep(100);
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        if (now < next) continue;
+        if (PIN_StopApplicationThreads(PIN_ThreadId())) {
+            PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+            WriteCheckpoint(now);
+            PIN_ReleaseLock(&g_lock);
+            PIN_ResumeApplicationThreads(PIN_ThreadId());
+        }
+        next = now + every;
+    }
+}
+
+static VOID CheckpointExit(VOID*)
+{
+    g_ckpt_stop = true;
+    PIN_WaitForThreadTermination(g_ckpt_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// LoadCheckpoint reads the -resume file into g_carry; "" or what is wrong
+static std::string LoadCheckpoint(const std::string& path)
+{
+    std::ifstream in(path.c_str());
+    if (!in) return "cannot open it";
+    std::string line;
+    if (!std::getline(in, line) || line != CHECKPOINT_MAGIC) return "not a checkpoint";
+    bool end = false;
+    while (std::getline(in, line)) {
+        std::string key = line.substr(0, line.find_first_of(" \t"));
+        std::string rest = key.size() < line.size() ? line.substr(key.size() + 1) : "";
+        std::istringstream is(rest);
+        if (key == "words") {
+            size_t n = 0;
+            if (!(is >> n) || n != NumWords(Cnts{}))
+                return "written by a tool with other counters";
+        } else if (key == "options") {
+            if (rest != CheckpointOptions())
+                return "counted with other options (" + rest + ")";
+        } else if (key == "seq") {
+            is >> g_carry.seq;
+        } else if (key == "elapsed") {
+            is >> g_carry.elapsed;
+        } else if (key == "total") {
+            if (!ReadWords(is, g_carry.total)) return "bad total";
+        } else if (key == "mix") {
+            for (int k = 0; k < MIX_KINDS; ++k) is >> g_carry.mix[k];
+        } else if (key == "compound") {
+            for (int k = 0; k < COMPOUND_KINDS; ++k) is >> g_carry.compound[k];
+        } else if (key == "func" || key == "line" || key == "region") {
+            std::vector<std::string> f;
+            std::istringstream fs(rest);
+            for (std::string s; std::getline(fs, s, '\t');) f.push_back(s);
+            size_t want = key == "func" ? 6 : 3;
+            if (f.size() != want) return "bad " + key + " line";
+            Cnts c{};
+            std::istringstream ws(f.back());
+            if (!ReadWords(ws, c)) return "bad " + key + " counts";
+            if (key == "func") {
+                FuncInfo fi;
+                fi.name = f[0];
+                fi.image = f[1];
+                fi.file = f[2];
+                fi.line = atoi(f[3].c_str());
+                fi.origin = static_cast<GoOrigin>(atoi(f[4].c_str()) % GO_ORIGINS);
+                g_carry.funcs.push_back({fi, c});
+            } else if (key == "line") {
+                LineInfo li{f[0], atoi(f[1].c_str())};
+                auto id_key = std::make_pair(li.file, li.line);
+                auto it = g_line_ids.find(id_key);
+                if (it == g_line_ids.end()) {
+                    it = g_line_ids.emplace(id_key, static_cast<UINT32>(g_lines.size())).first;
+                    g_lines.push_back(li);
+                }
+                g_carry.lines.push_back({it->second, c});
+            } else {
+                UINT32 id = RegionId(0, f[0]);
+                g_carry.regions.resize(g_region_names.size());
+                g_carry.entries.resize(g_region_names.size());
+                Accumulate(g_carry.regions[id], c);
+                g_carry.entries[id] += strtoull(f[1].c_str(), nullptr, 0);
+            }
+        } else if (key == "end") {
+            end = true;
+        } else {
+            return "unknown line '" + key + "'";
+        }
+    }
+    if (!end) return "truncated";
+    g_carry.from = path;
+    g_ckpt_seq = g_carry.seq;
+    g_resumed = true;
+    return "";
+}
+
+// ── child processes ─────────────────────────────────────────────────────────
+// With -children every process image under Pin counts on its own: forked
+// children start from zero, exec'd ones get a fresh tool told the root's
+// pid.  Images other than the root's last one add a line with their totals
+// to "-o.procs" when they end (at exit, or just before exec), and the root
+// reads those lines into its report.  Children still running when the root
+// exits are left out.
+static std::vector<std::string> g_pin_argv;    // Pin command line before "--"
+
+static std::string ProcsPath() { return knobOut.Value() + ".procs"; }
+
+static ProcRow ThisProcess(const Report& r)
+{
+    ProcRow p;
+    p.pid = PIN_GetPid();
+#if defined(TARGET_WINDOWS)
+    p.ppid = 0;                       // Windows keeps no parent link
+#else
+    p.ppid = getppid();
+#endif
+    p.started = g_started;
+    p.n[0] = r.total.add; p.n[1] = r.total.sub; p.n[2] = r.total.mul; p.n[3] = r.total.div;
+    for (int o = 0; o < BIT_OPS; ++o) p.n[4 + o] = r.total.bit[o];
+    p.binary = g_binary;
+    p.args = g_args;
+    return p;
+}
+
+// One line per image: pid, ppid, start, the counts, binary, then the
+// arguments, tab-separated (tabs and newlines in arguments become spaces)
+static VOID AppendProcess(const ProcRow& p)
+{
+    auto clean = [](std::string s) {
+        std::replace(s.begin(), s.end(), '\t', ' ');
+        std::replace(s.begin(), s.end(), '\n', ' ');
+        return s;
+    };
+    std::ostringstream line;
+    line << p.pid << '\t' << p.ppid << '\t' << p.started;
+    for (UINT64 v : p.n) line << '\t' << v;
+    line << '\t' << clean(p.binary);
+    for (const auto& a : p.args) line << '\t' << clean(a);
+    line << '\n';
+    std::ofstream out(ProcsPath().c_str(), std::ios::app);
+    out << line.str();                // one write: lines from processes don't interleave
+}
+
+static VOID LoadProcesses(Report& r)
+{
+    r.procs.push_back(ThisProcess(r));
+    std::ifstream in(ProcsPath().c_str());
+    std::string line;
+    while (std::getline(in, line)) {
+        std::vector<std::string> f;
+        std::istringstream ls(line);
+        for (std::string v; std::getline(ls, v, '\t');) f.push_back(v);
+        if (f.size() < 8 + BIT_OPS) continue;
+        ProcRow p;
+        p.pid = std::atoi(f[0].c_str());
+        p.ppid = std::atoi(f[1].c_str());
+        p.started = f[2];
+        for (int k = 0; k < 4 + BIT_OPS; ++k) p.n[k] = strtoull(f[3 + k].c_str(), nullptr, 10);
+        p.binary = f[7 + BIT_OPS];
+        p.args.assign(f.begin() + 8 + BIT_OPS, f.end());
+        r.procs.push_back(p);
+    }
+    std::remove(ProcsPath().c_str());
+}
+
+// Without -children a forked child is still instrumented (Pin keeps the
+// tool across fork) but must not overwrite its parent's report.
+static bool IsRoot()
+{
+    if (!g_children_on) return g_started != std::string("fork");
+    return PIN_GetPid() == g_root_pid;
+}
+
+// ResetCounts zeroes every count of st, keeping what it has learned about
+// the code (calling contexts, open regions and frames, dataflow producers).
+static VOID ResetCounts(ThreadState* st)
+{
+    st->cnts = Cnts{};
+    st->sites.clear();
+    st->divs.clear();
+    st->branches.clear();
+    st->strides.clear();
+    st->reuse.clear();                  // the reuse buffer stays warm
+    for (auto& c : st->cache) c.accesses = c.misses = 0;     // the caches stay warm
+    st->foot = FootSet{};
+    st->foot_funcs.clear();
+    st->foot_phases.clear();
+    st->foot_curve.clear();
+    st->foot_n = 0;
+    st->block_execs.clear();
+    st->ann_execs.clear();
+    std::fill(st->compound, st->compound + COMPOUND_KINDS, 0);
+    std::fill(st->mix, st->mix + MIX_KINDS, 0);
+    st->dfg_execs.clear();
+    st->dfg_edges.clear();
+    for (auto& v : st->dead_vals) v.used = true;   // earlier results do not count
+    st->dead_results.clear();
+    st->dead_n.clear();
+    std::fill(&st->mulw[0][0], &st->mulw[0][0] + 2 * MUL_WIDTHS, 0);
+    st->mul_seen = 0;
+    std::fill(st->bfly_open.begin(), st->bfly_open.end(), 0);
+    st->bfly_calls.clear();
+    st->win_start = Cnts{};
+    st->insns = st->insns_sampled = st->windows = st->windows_sampled = 0;
+    st->stats = SampleStats{};
+    for (auto& o : st->open) o.at = Cnts{};
+    st->regions.clear();
+    st->entries.clear();
+    st->py_mark = Cnts{};
+    st->py_self.clear();
+    st->py_calls.clear();
+    st->loop_heads.clear();
+    st->loop_backs.clear();
+    for (auto& n : st->nodes) n.cnts = Cnts{};
+    st->cpus.clear();
+    st->cpu_mark = Cnts{};
+}
+
+static VOID ForkChild(THREADID tid, const CONTEXT*, VOID*)
+{
+    g_started = "fork";
+    g_sys_on = false;                 // the traces are the parent's
+    g_trace = TRACE_OFF;
+    St(tid)->trace.clear();
+    if (!g_children_on) return;
+
+    // only the forking thread lives on, and the parent's counts are not ours
+    ThreadState* st = St(tid);
+    g_all.assign(1, st);
+    ResetCounts(st);
+    st->os_tid = PIN_GetTid();
+    st->parent = PIN_GetParentTid();
+    g_t0 = std::chrono::steady_clock::now();
+    DBG(1, "Forked child " << PIN_GetPid());
+}
+
+static BOOL FollowChild(CHILD_PROCESS child, VOID*)
+{
+    // this image is about to be replaced and will not reach Fini
+    AppendProcess(ThisProcess(BuildReport()));
+    std::vector<const char*> argv;
+    for (const auto& a : g_pin_argv) argv.push_back(a.c_str());
+    argv.push_back("--");             // Pin appends the child's command line
+    CHILD_PROCESS_SetPinCommandLine(child, static_cast<int>(argv.size()), argv.data());
+    DBG(1, "Following exec in " << PIN_GetPid());
+    return TRUE;
+}
+
+// Attached runs have nobody waiting on the process, so the report is
+// written next to -o and renamed into place: readers see all or nothing.
+static VOID WriteReport()
+{
+    for (auto* st : g_all)            // regions still open at exit / detach
+        while (!st->open.empty()) CloseRegion(st);
+    if (g_py_on)                      // and the frames still running
+        for (auto* st : g_all) PyCharge(st);
+    if (g_sampling)                   // close each thread's partial window
+        for (auto* st : g_all)
+            if (st->icount) EndWindow(st);
+
+    Report r = BuildReport();
+    if (g_children_on) LoadProcesses(r);
+
+    if (!knobFolded.Value().empty()) {
+        std::ofstream out(knobFolded.Value().c_str());
+        PrintFolded(out, r);
+    }
+    if (knobOut.Value().empty()) {
+        PrintReport(std::cout, r);
+        std::cout.flush();
+    } else if (g_attached) {
+        std::string tmp = knobOut.Value() + ".part";
+        {
+            std::ofstream out(tmp.c_str());
+            PrintReport(out, r);
+        }
+        std::rename(tmp.c_str(), knobOut.Value().c_str());
+    } else {
+        std::ofstream out(knobOut.Value().c_str());
+        PrintReport(out, r);
     }
 }
 
-// ── instrumentation for address-based regions (ADDRESS mode) ────────────────
-static VOID InstrumentAddressRegion(INS ins, VOID*)
+static VOID Fini(INT32, VOID*)
 {
-    if (g_mode != ADDRESS) return;
-    
-    // Start at exact address
-    if (INS_Address(ins) == g_start_addr) {
-        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)StartRegion,
-                       IARG_THREAD_ID, IARG_END);
+    if (g_detached) return;           // already reported at detach
+    SyscallsDone();
+    TraceDone();
+    if (g_stream && IsRoot()) EmitSnapshot(true);
+    if (IsRoot())            WriteReport();
+    else if (g_children_on) AppendProcess(ThisProcess(BuildReport()));
+    for (auto* st : g_all) delete st;
+}
+
+// ── attach / detach ─────────────────────────────────────────────────────────
+// An internal thread polls the stop conditions and asks Pin to detach; the
+// detach callback runs once every application thread is parked in the VM.
+static PIN_THREAD_UID  g_ctl_uid;
+static volatile bool   g_exiting = false;
+
+static bool DetachRequested()
+{
+    UINT64 dur = strtoull(knobDuration.Value().c_str(), nullptr, 0);
+    if (dur && std::chrono::steady_clock::now() - g_t0 >= std::chrono::seconds(dur))
+        return true;
+    return !knobDetachFile.Value().empty() &&
+           std::ifstream(knobDetachFile.Value().c_str()).good();
+}
+
+static VOID DetachController(VOID*)
+{
+    while (!g_exiting) {
+        if (DetachRequested()) {
+            DBG(1, "Detaching");
+            PIN_Detach();
+            return;
+        }
+        PIN_Sleep(100);
     }
+}
 
-    // Stop at first RET while active
-    if (INS_IsRet(ins)) {
-        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)StopRegion,
-                       IARG_FAST_ANALYSIS_CALL, IARG_THREAD_ID, IARG_END);
+static VOID PrepareForFini(VOID*)
+{
+    g_exiting = true;
+    PIN_WaitForThreadTermination(g_ctl_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+static VOID Detach(VOID*)
+{
+    g_detached = true;
+    g_stream_stop = true;
+    SyscallsDone();
+    TraceDone();
+    if (g_stream) EmitSnapshot(true);
+    WriteReport();
+}
+
+// ── limits ──────────────────────────────────────────────────────────────────
+// An internal thread polls the limits of a launched run and ends the process
+// through PIN_ExitApplication, so the Fini callbacks write the report.
+static PIN_THREAD_UID  g_limit_uid;
+static volatile bool   g_limit_stop = false;
+
+// LimitReached returns the limit that ends the run, or "".
+static std::string LimitReached(double elapsed, UINT64 timeout, UINT64 max_ops)
+{
+    if (timeout && elapsed >= timeout) return "timeout";
+    if (max_ops) {
+        Cnts c{};
+        PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+        for (auto* st : g_all) Accumulate(c, st->cnts);
+        PIN_ReleaseLock(&g_lock);
+        if (Summarize(c).Weight() >= max_ops) return "max_ops";
+    }
+    if (!knobStopFile.Value().empty()) {
+        std::ifstream in(knobStopFile.Value().c_str());
+        if (in) {
+            std::string why;
+            std::getline(in, why);
+            return why.empty() ? "stop_file" : why;
+        }
     }
+    return "";
 }
 
-// ── thread lifecycle ────────────────────────────────────────────────────────
-static VOID ThreadStart(THREADID tid, CONTEXT*, INT32, VOID*)
+static VOID LimitController(VOID*)
 {
-    auto* st = new ThreadState;
-    PIN_SetThreadData(tlsKey, st, tid);
+    UINT64 timeout = strtoull(knobTimeout.Value().c_str(), nullptr, 0);
+    UINT64 max_ops = strtoull(knobMaxOps.Value().c_str(), nullptr, 0);
+    while (!g_limit_stop) {
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        std::string why = LimitReached(now, timeout, max_ops);
+        if (!why.empty()) {
+            DBG(1, "Limit reached: " << why);
+            g_truncated = why;
+            g_truncated_at = now;
+            g_limit_stop = true;
+            PIN_ExitApplication(0);
+        }
+        PIN_Sleep(100);
+    }
+}
 
-    PIN_GetLock(&g_lock, tid + 1);
-    g_all.push_back(st);
+static VOID LimitExit(VOID*)
+{
+    // PIN_ExitApplication from the controller runs this on its own thread
+    if (PIN_ThreadUid() == g_limit_uid) return;
+    g_limit_stop = true;
+    PIN_WaitForThreadTermination(g_limit_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+static bool Limited()
+{
+    return knobTimeout.Value() != "0" || knobMaxOps.Value() != "0" || !knobStopFile.Value().empty();
+}
+
+// ── warm-up ─────────────────────────────────────────────────────────────────
+// An internal thread ends the warm-up: with every application thread
+// stopped it keeps the counts so far as the excluded ones and zeroes them.
+static PIN_THREAD_UID  g_warmup_uid;
+static volatile bool   g_warmup_stop = false;
+
+static const UINT32 STEADY_MS = 250;      // -warmup auto sampling interval
+static const size_t STEADY_N = 4;         // intervals that must agree
+
+static Cnts CountsNow()
+{
+    Cnts c{};
+    PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+    for (auto* st : g_all) Accumulate(c, st->cnts);
     PIN_ReleaseLock(&g_lock);
+    return c;
 }
 
-// ── report ──────────────────────────────────────────────────────────────────
-static VOID Fini(INT32, VOID*)
+// Rates returns the weight and the per-category counts of an interval.
+static std::vector<double> Rates(const Totals& t)
 {
-    Cnts total{};
+    return {double(t.Weight()), double(t.add), double(t.sub), double(t.mul), double(t.div),
+            double(t.BitSum()), double(t.VecSum()), double(t.FpSum()), double(t.ClsSum())};
+}
+
+// Steady reports whether, over the last STEADY_N intervals, the weight and
+// each category above 1% of it stay within tol of their means.
+static bool Steady(const std::deque<std::vector<double>>& win, double tol)
+{
+    if (win.size() < STEADY_N) return false;
+    std::vector<double> mean(win[0].size());
+    for (const auto& r : win)
+        for (size_t k = 0; k < r.size(); ++k) mean[k] += r[k] / win.size();
+    if (mean[0] == 0) return false;
+    for (size_t k = 0; k < mean.size(); ++k) {
+        if (mean[k] < 0.01 * mean[0]) continue;
+        for (const auto& r : win)
+            if (std::fabs(r[k] - mean[k]) > tol * mean[k]) return false;
+    }
+    return true;
+}
+
+static VOID EndWarmup(double now)
+{
+    bool stopped = PIN_StopApplicationThreads(PIN_ThreadId());
+    PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+    Cnts c{};
     for (auto* st : g_all) {
-#define ACC(f) total.f += st->cnts.f
-        ACC(add_rr);  ACC(sub_rr);  ACC(adc_rr);  ACC(sbb_rr);
-        ACC(mul_rr);  ACC(mulx_rr); ACC(adcx_rr); ACC(adox_rr); ACC(div_rr);
-        ACC(add_rm);  ACC(sub_rm);  ACC(adc_rm);  ACC(sbb_rm);
-        ACC(mul_rm);  ACC(mulx_rm); ACC(adcx_rm); ACC(adox_rm); ACC(div_rm);
-        delete st;
+        Accumulate(c, st->cnts);
+        ResetCounts(st);
+    }
+    g_warmup_cnts = c;
+    g_stream_last = Totals{};
+    g_warmup_at = now;
+    g_warmup_done = true;
+    PIN_ReleaseLock(&g_lock);
+    if (stopped) PIN_ResumeApplicationThreads(PIN_ThreadId());
+    DBG(1, "Warm-up over after " << now << " s");
+}
+
+static VOID WarmupController(VOID*)
+{
+    double secs = strtod(knobWarmup.Value().c_str(), nullptr);
+    UINT64 ops = strtoull(knobWarmupOps.Value().c_str(), nullptr, 0);
+    double tol = strtod(knobSteadyTol.Value().c_str(), nullptr) / 100;
+    bool is_auto = knobWarmup.Value() == "auto";
+    std::deque<std::vector<double>> win;
+    Totals last;
+    while (!g_warmup_stop) {
+        PIN_Sleep(is_auto ? STEADY_MS : 100);
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        bool over = false;
+        if (is_auto) {
+            Totals t = Summarize(CountsNow());
+            win.push_back(Rates(Minus(t, last)));
+            if (win.size() > STEADY_N) win.pop_front();
+            last = t;
+            over = Steady(win, tol);
+        } else if (ops) {
+            over = Summarize(CountsNow()).Weight() >= ops;
+        } else {
+            over = now >= secs;
+        }
+        if (over && !g_warmup_stop) {
+            EndWarmup(now);
+            return;
+        }
+    }
+}
+
+static VOID WarmupExit(VOID*)
+{
+    if (PIN_ThreadUid() == g_warmup_uid) return;
+    g_warmup_stop = true;
+    PIN_WaitForThreadTermination(g_warmup_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// ── partial counts (-partial) ──────────────────────────────────────────────
+// A workload killed outright (SIGKILL, the OOM killer) never reaches Fini,
+// so it leaves no report.  With -partial FILE an internal thread publishes
+// the totals every -partial_interval seconds in FILE, mapped shared so its
+// pages outlive the process.  The layout is an 8-byte magic, the number of
+// the last complete flush, then two slots of an 8-byte length and a JSON
+// snapshot.  Flush n goes to slot n % 2 and its number is stored after it,
+// in one aligned write, so a reader always finds a whole snapshot.
+static const char   PARTIAL_MAGIC[8] = {'I', '6', '4', 'P', 'A', 'R', 'T', '1'};
+static const size_t PARTIAL_SLOT = 4096;
+static char*           g_partial = nullptr;
+static PIN_THREAD_UID  g_partial_uid;
+static volatile bool   g_partial_stop = false;
+
+static VOID FlushPartial(UINT64 seq)
+{
+    Totals t = Summarize(CountsNow());
+    double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+    std::ostringstream os;
+    os << "{\"seq\": " << seq << ", \"pid\": " << PIN_GetPid() << ", \"mode\": \"" << ModeName()
+       << "\", \"elapsed_sec\": " << now << ", \"threads\": " << g_all.size() << ", \"cumulative\": ";
+    JsonSnapshotCounts(os, t);
+    os << '}';
+    const std::string s = os.str();
+    if (s.size() > PARTIAL_SLOT - 8) return;
+    char* slot = g_partial + 16 + (seq % 2) * PARTIAL_SLOT;
+    UINT64 n = s.size();
+    memcpy(slot, &n, 8);
+    memcpy(slot + 8, s.data(), n);
+    __atomic_store_n(reinterpret_cast<UINT64*>(g_partial + 8), seq, __ATOMIC_RELEASE);
+}
+
+static VOID PartialController(VOID*)
+{
+    auto every = std::chrono::duration_cast<std::chrono::steady_clock::duration>(
+        std::chrono::duration<double>(strtod(knobPartialInterval.Value().c_str(), nullptr)));
+    auto next = std::chrono::steady_clock::now();
+    for (UINT64 seq = 1; !g_partial_stop; PIN_Sleep(100))
+        if (std::chrono::steady_clock::now() >= next) {
+            FlushPartial(seq++);
+            next += every;
+        }
+}
+
+static VOID PartialExit(VOID*)
+{
+    g_partial_stop = true;
+    PIN_WaitForThreadTermination(g_partial_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// OpenPartial maps a fresh -partial file; the error, or "" on success
+static std::string OpenPartial(const std::string& path)
+{
+#if !defined(TARGET_WINDOWS)
+    const size_t size = 16 + 2 * PARTIAL_SLOT;
+    int fd = open(path.c_str(), O_RDWR | O_CREAT | O_TRUNC, 0644);
+    if (fd < 0) return "cannot open " + path;
+    if (ftruncate(fd, size) != 0) {
+        close(fd);
+        return "cannot size " + path;
+    }
+    void* m = mmap(nullptr, size, PROT_READ | PROT_WRITE, MAP_SHARED, fd, 0);
+    close(fd);
+    if (m == MAP_FAILED) return "cannot map " + path;
+    g_partial = static_cast<char*>(m);
+    memcpy(g_partial, PARTIAL_MAGIC, 8);
+    return "";
+#else
+    return "-partial is not supported on Windows";
+#endif
+}
+
+// ── phase detection (-phases auto) ────────────────────────────────────────
+// Every -phase_interval an internal thread compares the operation mix of
+// the last period with that of the current phase.  One period that differs
+// may be noise; when the next one differs too, a phase starts at the first,
+// and the second, which is past the transition, sets the new phase's mix.
+static PIN_THREAD_UID  g_phase_uid;
+static volatile bool   g_phase_stop = false;
+
+// MixShift is the share of the operations of b that would have to change
+// category for b to have the mix of a (0 to 1).
+static double MixShift(const Totals& a, const Totals& b)
+{
+    std::vector<double> ra = Rates(a), rb = Rates(b);
+    double d = 0;
+    for (size_t k = 1; k < ra.size(); ++k) d += std::fabs(ra[k] / ra[0] - rb[k] / rb[0]);
+    return d / 2;
+}
+
+static VOID PhaseController(VOID*)
+{
+    double period = strtod(knobPhaseInterval.Value().c_str(), nullptr);
+    double shift = strtod(knobPhaseShift.Value().c_str(), nullptr) / 100;
+    UINT32 ms = static_cast<UINT32>(std::max(period, 0.05) * 1000);
+    Totals ref, last, pend;           // start of the phase's mix, previous period, first differing period
+    Cnts last_c{}, pend_c{};
+    double last_at = 0, pend_at = 0;
+    bool pending = false;
+    while (!g_phase_stop) {
+        PIN_Sleep(ms);
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        Cnts c = CountsNow();
+        Totals t = Summarize(c);
+        Totals d = Minus(t, last);
+        if (d.Weight() == 0) continue;   // idle: the mix is unknown
+        Totals phase = Minus(pending ? pend : last, ref);
+        if (phase.Weight() != 0 && MixShift(phase, d) > shift) {
+            if (pending) {
+                PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+                g_phases.push_back({"phase" + std::to_string(g_phases.size() + 1), pend_at, pend_c});
+                g_phase_cur = INT32(g_phases.size() - 1);
+                PIN_ReleaseLock(&g_lock);
+                DBG(1, "Phase " << g_phases.size() << " from " << pend_at << " s");
+                ref = last;
+                pending = false;
+            } else {
+                pending = true;
+                pend = last;
+                pend_c = last_c;
+                pend_at = last_at;
+            }
+        } else {
+            pending = false;
+        }
+        last = t;
+        last_c = c;
+        last_at = now;
+    }
+}
+
+static VOID PhaseExit(VOID*)
+{
+    g_phase_stop = true;
+    PIN_WaitForThreadTermination(g_phase_uid, PIN_INFINITE_TIMEOUT, nullptr);
+}
+
+// ── time series (-timeseries) ──────────────────────────────────────────────
+// An internal thread writes the counts of each period; the last, shorter
+// one is written at exit.  The counts restart with the warm-up's end.
+static std::ofstream   g_ts_out;
+static bool            g_ts_csv = false;
+static PIN_THREAD_UID  g_ts_uid;
+static volatile bool   g_ts_stop = false;
+static Totals          g_ts_last;
+static double          g_ts_at = 0;
+static bool            g_ts_warm = false;   // the warm-up's end was seen
+
+static VOID TimeSeriesHeader()
+{
+    if (!g_ts_csv) return;
+    g_ts_out << "time,elapsed_sec,interval_sec,add,sub,mul,div";
+    for (int o = 0; o < BIT_OPS; ++o)
+        if (g_bit_on[o]) g_ts_out << ',' << BIT_OP_NAMES[o];
+    if (g_vec_on)  g_ts_out << ",vec";
+    if (g_wide_on) g_ts_out << ",wide";
+    if (g_fp_on)   g_ts_out << ",fp64,fp32";
+    if (g_mem_on)
+        for (int m = 0; m < MEM_ACCESS_KINDS; ++m) g_ts_out << ',' << MEM_KIND_NAMES[m];
+    g_ts_out << '\n';
+}
+
+static VOID TimeSeriesRow()
+{
+    Cnts c{};
+    PIN_GetLock(&g_lock, PIN_ThreadId() + 1);
+    for (auto* st : g_all) Accumulate(c, st->cnts);
+    bool warm = g_warmup_done;
+    PIN_ReleaseLock(&g_lock);
+    if (warm && !g_ts_warm) {
+        g_ts_last = Totals{};
+        g_ts_warm = true;
+    }
+    Totals t = Summarize(c), d = Minus(t, g_ts_last);
+    double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+    double epoch = std::chrono::duration<double>(
+                      std::chrono::system_clock::now().time_since_epoch()).count();
+
+    std::ostringstream os;
+    os << std::fixed << std::setprecision(6);
+    if (g_ts_csv) {
+        os << epoch << ',' << now << ',' << now - g_ts_at << std::defaultfloat
+           << ',' << d.add << ',' << d.sub << ',' << d.mul << ',' << d.div;
+        for (int o = 0; o < BIT_OPS; ++o)
+            if (g_bit_on[o]) os << ',' << d.bit[o];
+        if (g_vec_on)  os << ',' << d.VecSum();
+        if (g_wide_on) os << ',' << d.WideSum();
+        if (g_fp_on)   os << ',' << d.FpSum(FP64) << ',' << d.FpSum(FP32);
+        if (g_mem_on)
+            for (int m = 0; m < MEM_ACCESS_KINDS; ++m) os << ',' << d.mem[m];
+    } else {
+        os << "{\"time\": " << epoch << ", \"elapsed_sec\": " << now
+           << ", \"interval_sec\": " << now - g_ts_at << std::defaultfloat << ", \"counts\": ";
+        JsonSnapshotCounts(os, d);
+        os << '}';
     }
+    g_ts_out << os.str() << '\n' << std::flush;
+    g_ts_last = t;
+    g_ts_at = now;
+}
+
+static VOID TimeSeriesController(VOID*)
+{
+    double period = std::max(strtod(knobTimeSeriesInterval.Value().c_str(), nullptr), 0.001);
+    double next = period;
+    while (!g_ts_stop) {
+        double now = std::chrono::duration<double>(std::chrono::steady_clock::now() - g_t0).count();
+        if (now < next) {
+            PIN_Sleep(std::max<UINT32>(1, static_cast<UINT32>((next - now) * 1000)));
+            continue;
+        }
+        TimeSeriesRow();
+        while (next <= now) next += period;   // skip the periods missed
+    }
+}
 
-    const auto ADD = total.add_rr + total.add_rm + total.adc_rr + total.adc_rm +
-                     total.adcx_rr + total.adcx_rm + total.adox_rr + total.adox_rm;
-    const auto SUB = total.sub_rr + total.sub_rm + total.sbb_rr + total.sbb_rm;
-    const auto MUL = total.mul_rr + total.mul_rm + total.mulx_rr + total.mulx_rm;
-    const auto DIV = total.div_rr + total.div_rm;
+static VOID TimeSeriesExit(VOID*)
+{
+    g_ts_stop = true;
+    PIN_WaitForThreadTermination(g_ts_uid, PIN_INFINITE_TIMEOUT, nullptr);
+    TimeSeriesRow();
+}
 
-    std::cout << "ADD: " << ADD << '\n'
-              << "SUB: " << SUB << '\n'
-              << "MUL: " << MUL << '\n'
-              << "DIV: " << DIV << std::endl;
+// An attached tool sees no "--" command line; read the process's own
+// (Linux only: Windows and macOS processes attached to report no arguments).
+static VOID ReadCmdline()
+{
+#if !defined(TARGET_WINDOWS)
+    std::ifstream in("/proc/self/cmdline");
+    std::string arg;
+    while (std::getline(in, arg, '\0')) g_args.push_back(arg);
+#endif
 }
 
 // ── main ─────────────────────────────────────────────────────────────────────
+// -ops: comma-separated category names, or "bitwise" for all of them
+static bool ParseClasses()
+{
+    for (UINT32 i = 0; i < knobClass.NumberOfValues(); ++i) {
+        std::string v = knobClass.Value(i);
+        if (v.empty()) continue;
+        size_t eq = v.find('=');
+        if (eq == 0 || eq == std::string::npos || eq + 1 == v.size()) {
+            std::cerr << "Int64Profiler: -class wants name=glob,…, not '" << v << "'" << std::endl;
+            return false;
+        }
+        if (g_classes.size() == MAX_CLASSES) {
+            std::cerr << "Int64Profiler: at most " << MAX_CLASSES << " -class categories" << std::endl;
+            return false;
+        }
+        ClassInfo c;
+        c.name = v.substr(0, eq);
+        std::istringstream in(v.substr(eq + 1));
+        std::string pat;
+        while (std::getline(in, pat, ','))
+            if (!pat.empty()) c.pats.push_back(Upper(pat));
+        g_classes.push_back(c);
+    }
+    for (UINT32 i = 0; i < knobWatch.NumberOfValues(); ++i) {
+        std::string v = knobWatch.Value(i);
+        if (v.empty()) continue;
+        size_t eq = v.find('='), c1 = v.find(':', eq), c2 = v.find(':', c1 + 1);
+        ClassInfo c;
+        c.watch = true;
+        if (eq != 0 && eq != std::string::npos && c1 != std::string::npos && c2 != std::string::npos) {
+            c.name = v.substr(0, eq);
+            c.addr = strtoull(v.c_str() + eq + 1, nullptr, 0);
+            c.size = strtoull(v.c_str() + c1 + 1, nullptr, 0);
+            std::string mode = v.substr(c2 + 1);
+            c.rd = mode == "r" || mode == "rw";
+            c.wr = mode == "w" || mode == "rw";
+        }
+        if (c.name.empty() || c.size == 0 || (!c.rd && !c.wr)) {
+            std::cerr << "Int64Profiler: -watch wants name=addr:size:r|w|rw, not '" << v << "'" << std::endl;
+            return false;
+        }
+        if (g_classes.size() == MAX_CLASSES) {
+            std::cerr << "Int64Profiler: at most " << MAX_CLASSES << " -class and -watch categories" << std::endl;
+            return false;
+        }
+        g_classes.push_back(c);
+    }
+    return true;
+}
+
+static bool ParseOps(const std::string& list)
+{
+    std::istringstream in(list);
+    std::string name;
+    while (std::getline(in, name, ',')) {
+        if (name.empty()) continue;
+        bool found = false;
+        for (int o = 0; o < BIT_OPS; ++o)
+            if (name == "bitwise" || name == BIT_OP_NAMES[o])
+                g_bit_on[o] = found = true;
+        if (!found) {
+            std::cerr << "Int64Profiler: unknown -ops category '" << name << "'" << std::endl;
+            return false;
+        }
+        g_bits_on = true;
+    }
+    return true;
+}
+
+static bool ParseWidths(const std::string& list)
+{
+    std::istringstream in(list);
+    std::string bits;
+    while (std::getline(in, bits, ',')) {
+        if (bits.empty()) continue;
+        bool found = false;
+        for (int w = 0; w < WIDTH_SLOTS; ++w)
+            if (bits == "all" || bits == std::to_string(WIDTH_BITS[w]))
+                g_width_on[w] = found = true;
+        if (!found) {
+            std::cerr << "Int64Profiler: -widths takes 8, 16, 32, 64, 128 or all, not '"
+                      << bits << "'" << std::endl;
+            return false;
+        }
+        g_widths_on = true;
+    }
+    return true;
+}
+
 int main(int argc, char* argv[])
 {
     PIN_InitSymbols();
-    PIN_Init(argc, argv);
+    if (PIN_Init(argc, argv)) {
+        std::cerr << KNOB_BASE::StringKnobSummary() << std::endl;
+        return 1;
+    }
+    g_t0 = std::chrono::steady_clock::now();
+
+    // Application command line follows the "--" separator
+    g_attached = PIN_IsAttaching();
+    for (int i = 1; i < argc; ++i) {
+        if (std::string(argv[i]) != "--") continue;
+        for (int j = i + 1; j < argc; ++j) g_args.push_back(argv[j]);
+        break;
+    }
+    if (g_attached) ReadCmdline();
+
+    if (!knobProjection.Value().empty()) {
+        TRACE_AddInstrumentFunction(InstrumentProjection, nullptr);
+        PIN_AddFiniFunction(ProjectionFini, nullptr);
+        PIN_StartProgram();
+        return 0;
+    }
 
     g_dbg = std::atoi(knobDbg.Value().c_str());
+    g_funcs_on = knobFuncs.Value() == "1";
+    g_modules_on = knobModules.Value() == "1";
+    g_children_on = knobChildren.Value() == "1";
+    if (g_attached) g_started = "attach";
+    if (g_children_on) {
+        if (knobOut.Value().empty()) {
+            std::cerr << "Int64Profiler: -children needs -o" << std::endl;
+            return 1;
+        }
+        // exec'd children are started with the root's pid; the root adds it
+        for (int i = 0; i < argc && std::string(argv[i]) != "--"; ++i)
+            g_pin_argv.push_back(argv[i]);
+        g_root_pid = std::atoi(knobRootPid.Value().c_str());
+        if (g_root_pid) {
+            g_started = "exec";
+        } else {
+            g_root_pid = PIN_GetPid();
+            g_pin_argv.push_back("-root_pid");
+            g_pin_argv.push_back(std::to_string(g_root_pid));
+        }
+    }
+    g_threads_on = knobThreads.Value() == "1";
+    g_percpu_on = knobPerCpu.Value() == "1";
+    g_calls_on = knobCallgraph.Value() == "1" || !knobFolded.Value().empty();
+    g_fp_on = knobFp.Value() == "1";
+    const std::string& compound = knobCompound.Value();
+    if      (compound == "fused") g_compound = CMP_FUSED;
+    else if (compound == "split") g_compound = CMP_SPLIT;
+    else if (compound == "both")  g_compound = CMP_BOTH;
+    else {
+        std::cerr << "Int64Profiler: -compound must be fused, split or both" << std::endl;
+        return 1;
+    }
+    const std::string& agen = knobAgen.Value();
+    if      (agen == "off")      g_agen = AGEN_OFF;
+    else if (agen == "category") g_agen = AGEN_CATEGORY;
+    else if (agen == "fold")     g_agen = AGEN_FOLD;
+    else {
+        std::cerr << "Int64Profiler: -agen must be off, category or fold" << std::endl;
+        return 1;
+    }
+    if (g_agen != AGEN_OFF && g_compound == CMP_SPLIT) {
+        std::cerr << "Int64Profiler: -agen counts LEAs itself; use it with -compound fused or both"
+                  << std::endl;
+        return 1;
+    }
+    g_lines_on = knobLines.Value() == "1";
+    g_loops_on = knobLoops.Value() == "1";
+    g_wide_on = knobWide.Value() == "1";
+    g_sign_on = knobSignedness.Value() == "1";
+    g_atomics_on = knobAtomics.Value() == "1";
+    g_vec_on = knobVec.Value() == "1";
+    g_mem_on = knobMem.Value() == "1";
+    g_mix_on = knobMix.Value() == "1";
+    g_divs_on = knobDivs.Value() == "1";
+    g_bfly_on = knobButterflies.Value() == "1";
+    g_mod_on = knobModArith.Value() == "1" || g_bfly_on;
+    g_mulvals = strtoull(knobMulVals.Value().c_str(), nullptr, 0);
+    g_go_on = knobGo.Value() == "1";
+    g_jit_on = knobJit.Value() == "1";
+    g_py_on = knobPython.Value() == "1";
+    if (!ParseFilters(F_INCLUDE, knobInclude) || !ParseFilters(F_EXCLUDE, knobExclude) ||
+        !ParseFilters(F_INCLUDE_FUNC, knobIncludeFunc) ||
+        !ParseFilters(F_EXCLUDE_FUNC, knobExcludeFunc) ||
+        !ParseFilters(F_INCLUDE_MODULE, knobIncludeModule) ||
+        !ParseFilters(F_EXCLUDE_MODULE, knobExcludeModule)) return 1;
+    if (!ParseOps(knobOps.Value()) || !ParseClasses() || !ParseWidths(knobWidths.Value())) return 1;
+    if (g_calls_on && !ParseWeight(knobFoldedWeight.Value())) return 1;
+    g_sample_frac = std::atof(knobSample.Value().c_str());
+    g_sampling = g_sample_frac < 1.0;
+    g_window = std::max<UINT64>(1, strtoull(knobWindow.Value().c_str(), nullptr, 0));
+    g_seed = strtoull(knobSeed.Value().c_str(), nullptr, 0);
+    if (g_sample_frac <= 0.0 || g_sample_frac > 1.0) {
+        std::cerr << "Int64Profiler: -sample must be in (0, 1]" << std::endl;
+        return 1;
+    }
+    if (g_divs_on && g_sampling) {
+        std::cerr << "Int64Profiler: -divs excludes -sample" << std::endl;
+        return 1;
+    }
+    g_branches = strtoull(knobBranches.Value().c_str(), nullptr, 0);
+    if (g_branches && g_sampling) {
+        std::cerr << "Int64Profiler: -branches excludes -sample" << std::endl;
+        return 1;
+    }
+    g_strides = strtoull(knobStrides.Value().c_str(), nullptr, 0);
+    if (g_strides && g_sampling) {
+        std::cerr << "Int64Profiler: -strides excludes -sample" << std::endl;
+        return 1;
+    }
+    if (g_strides) g_loops_on = true;
+    g_reuse = strtoull(knobReuse.Value().c_str(), nullptr, 0);
+    if (g_reuse && g_sampling) {
+        std::cerr << "Int64Profiler: -reuse excludes -sample" << std::endl;
+        return 1;
+    }
+    if (g_reuse) g_loops_on = true;
+    g_foot_window = strtoull(knobFootprint.Value().c_str(), nullptr, 0);
+    if (g_foot_window && g_sampling) {
+        std::cerr << "Int64Profiler: -footprint excludes -sample" << std::endl;
+        return 1;
+    }
+    if (!knobCache.Value().empty()) {
+        if (!ParseCache(knobCache.Value())) return 1;
+        if (g_sampling) {
+            std::cerr << "Int64Profiler: -cache excludes -sample" << std::endl;
+            return 1;
+        }
+        g_cache_on = g_mem_on = true;
+    }
+    g_dfg_on = knobDfg.Value() == "1";
+    g_dead_on = knobDead.Value() == "1";
+    if (g_dfg_on && g_sampling) {
+        std::cerr << "Int64Profiler: -dfg excludes -sample" << std::endl;
+        return 1;
+    }
+    if (g_dead_on && g_sampling) {
+        std::cerr << "Int64Profiler: -dead excludes -sample" << std::endl;
+        return 1;
+    }
+    g_blocks = strtoull(knobBlocks.Value().c_str(), nullptr, 0);
+    if (g_blocks && g_sampling) {
+        std::cerr << "Int64Profiler: -blocks excludes -sample" << std::endl;
+        return 1;
+    }
+    if (g_bfly_on && g_sampling) {
+        std::cerr << "Int64Profiler: -butterflies excludes -sample" << std::endl;
+        return 1;
+    }
+    for (UINT32 i = 0; i < knobAnnotate.NumberOfValues(); ++i)
+        if (!knobAnnotate.Value(i).empty()) g_ann_pats.push_back(knobAnnotate.Value(i));
+    if (!g_ann_pats.empty() && g_sampling) {
+        std::cerr << "Int64Profiler: -annotate excludes -sample" << std::endl;
+        return 1;
+    }
+    g_stream = strtod(knobStream.Value().c_str(), nullptr);
+    if (g_stream < 0) {
+        std::cerr << "Int64Profiler: -stream must not be negative" << std::endl;
+        return 1;
+    }
+    if (g_stream && g_sampling) {
+        std::cerr << "Int64Profiler: -stream excludes -sample" << std::endl;
+        return 1;
+    }
+    g_stream_funcs = strtoull(knobStreamFuncs.Value().c_str(), nullptr, 0);
+    if (g_stream_funcs && (!g_stream || !g_funcs_on)) {
+        std::cerr << "Int64Profiler: -stream_funcs needs -stream and -funcs" << std::endl;
+        return 1;
+    }
+    if (g_children_on && PIN_GetPid() != g_root_pid) g_stream = 0;   // exec'd child
+    g_systime_on = knobSysTime.Value() == "1";
+    if (g_systime_on && g_children_on) {
+        std::cerr << "Int64Profiler: -systime excludes -children" << std::endl;
+        return 1;
+    }
+#if !defined(TARGET_WINDOWS)
+    times(&g_tms0);
+#endif
+    if (!knobSyscalls.Value().empty() && !(g_children_on && PIN_GetPid() != g_root_pid)) {
+        g_sys_out.open(knobSyscalls.Value().c_str());
+        if (!g_sys_out) {
+            std::cerr << "Int64Profiler: cannot open " << knobSyscalls.Value() << std::endl;
+            return 1;
+        }
+        g_sys_on = true;
+    }
+    if (!knobTrace.Value().empty() && !(g_children_on && PIN_GetPid() != g_root_pid)) {
+        const std::string& f = knobTraceFormat.Value();
+        if (f != "ops" && f != "champsim") {
+            std::cerr << "Int64Profiler: -trace_format wants ops or champsim, not '" << f << "'" << std::endl;
+            return 1;
+        }
+        if (g_sampling) {
+            std::cerr << "Int64Profiler: -trace excludes -sample" << std::endl;
+            return 1;
+        }
+        g_trace_out.open(knobTrace.Value().c_str(), std::ios::binary);
+        if (!g_trace_out) {
+            std::cerr << "Int64Profiler: cannot open " << knobTrace.Value() << std::endl;
+            return 1;
+        }
+        g_trace = f == "ops" ? TRACE_OPS : TRACE_CHAMPSIM;
+    }
+    if (!knobTimeSeries.Value().empty() && !(g_children_on && PIN_GetPid() != g_root_pid)) {
+        const std::string& f = knobTimeSeriesFormat.Value();
+        if (f != "json" && f != "csv") {
+            std::cerr << "Int64Profiler: -timeseries_format wants json or csv, not '" << f << "'" << std::endl;
+            return 1;
+        }
+        if (g_sampling) {
+            std::cerr << "Int64Profiler: -timeseries excludes -sample" << std::endl;
+            return 1;
+        }
+        g_ts_csv = f == "csv";
+        g_ts_out.open(knobTimeSeries.Value().c_str());
+        if (!g_ts_out) {
+            std::cerr << "Int64Profiler: cannot open " << knobTimeSeries.Value() << std::endl;
+            return 1;
+        }
+    }
+    if (g_stream && !knobStreamFile.Value().empty()) {
+        g_stream_file.open(knobStreamFile.Value().c_str(), std::ios::app);
+        if (!g_stream_file) {
+            std::cerr << "Int64Profiler: cannot open " << knobStreamFile.Value() << std::endl;
+            return 1;
+        }
+        g_stream_out = &g_stream_file;
+    }
+    {
+        const std::string& f = knobFormat.Value();
+        const std::string& l = knobLayout.Value();
+        if (f != "text" && f != "json" && f != "csv" && f != "tsv") {
+            std::cerr << "Int64Profiler: unknown -format " << f << std::endl;
+            return 1;
+        }
+        if (l != "long" && l != "wide") {
+            std::cerr << "Int64Profiler: unknown -layout " << l << std::endl;
+            return 1;
+        }
+    }
+    if (g_sampling)
+        DBG(1, "Sampling " << g_sample_frac << " of " << g_window << "-instruction windows");
     
     // Determine mode based on arguments
-    if (!knobStart.Value().empty()) {
+    if (knobRegions.Value() == "1") {
+        // REGIONS mode: client-API markers, reported per region name
+        if (!knobStart.Value().empty() ||
+            (knobAddr.Value() != "0x0" && knobAddr.Value() != "0")) {
+            std::cerr << "Int64Profiler: -regions excludes -start and -addr" << std::endl;
+            return 1;
+        }
+        g_mode = REGIONS;
+        DBG(1, "REGIONS mode");
+
+    } else if (!knobStart.Value().empty()) {
         // MARKER mode: use start/stop function names
         g_mode = MARKER;
         g_start_marker = knobStart.Value();
@@ -329,18 +9869,222 @@ int main(int argc, char* argv[])
     tlsKey = PIN_CreateThreadDataKey(nullptr);
 
     PIN_AddThreadStartFunction(ThreadStart, nullptr);
+    PIN_AddThreadFiniFunction(ThreadFini, nullptr);
+    IMG_AddInstrumentFunction(ImageLoad, nullptr);
+#if !defined(TARGET_WINDOWS)
+    PIN_AddForkFunction(FPOINT_AFTER_IN_CHILD, ForkChild, nullptr);   // no fork() on Windows
+#endif
+    if (g_children_on) PIN_AddFollowChildProcessFunction(FollowChild, nullptr);
+    if (g_sys_on || g_systime_on) {
+        PIN_AddSyscallEntryFunction(SyscallEntry, nullptr);
+        PIN_AddSyscallExitFunction(SyscallExit, nullptr);
+    }
+#if !defined(TARGET_WINDOWS)
+    if (g_sys_on) PIN_AddForkFunction(FPOINT_BEFORE, SyscallsFork, nullptr);
+    if (g_trace != TRACE_OFF) PIN_AddForkFunction(FPOINT_BEFORE, TraceFork, nullptr);
+#endif
+    if (g_modules_on) {
+        IMG_AddUnloadFunction(ImageUnload, nullptr);
+        PIN_AddPrepareForFiniFunction(ModulesExit, nullptr);
+        PIN_AddApplicationStartFunction(AppStart, nullptr);
+    }
     
     // Add appropriate instrumentation based on mode
     if (g_mode == MARKER) {
         RTN_AddInstrumentFunction(InstrumentMarkerRtn, nullptr);
     } else if (g_mode == ADDRESS) {
         INS_AddInstrumentFunction(InstrumentAddressRegion, nullptr);
+    } else if (g_mode == REGIONS) {
+        RTN_AddInstrumentFunction(InstrumentRegionRtn, nullptr);
+        RTN_AddInstrumentFunction(InstrumentBudgetRtn, nullptr);
     }
+    if (g_py_on) RTN_AddInstrumentFunction(InstrumentPythonRtn, nullptr);
     
+    if (g_dfg_on && g_mode == WHOLE && !Filtering()) {
+        std::cerr << "Int64Profiler: -dfg needs -addr, -start, -regions or -include" << std::endl;
+        return 1;
+    }
+    if (g_sampling) TRACE_AddInstrumentFunction(InstrumentWindows, nullptr);
+
     // Always instrument arithmetic operations
     INS_AddInstrumentFunction(InstrumentArith, nullptr);
+    if (g_compound != CMP_FUSED) INS_AddInstrumentFunction(InstrumentLea, nullptr);
+    if (g_agen != AGEN_OFF) INS_AddInstrumentFunction(InstrumentAgen, nullptr);
+    if (g_bits_on) INS_AddInstrumentFunction(InstrumentBits, nullptr);
+    if (g_widths_on) INS_AddInstrumentFunction(InstrumentWidths, nullptr);
+    if (g_sign_on) INS_AddInstrumentFunction(InstrumentSign, nullptr);
+    if (g_atomics_on) INS_AddInstrumentFunction(InstrumentAtomics, nullptr);
+    if (g_wide_on) TRACE_AddInstrumentFunction(InstrumentWide, nullptr);
+    if (g_mod_on) TRACE_AddInstrumentFunction(InstrumentModArith, nullptr);
+    if (g_bfly_on) RTN_AddInstrumentFunction(InstrumentBflyRtn, nullptr);
+    if (g_loops_on) RTN_AddInstrumentFunction(InstrumentLoopsRtn, nullptr);
+    if (g_blocks) TRACE_AddInstrumentFunction(InstrumentBlocks, nullptr);
+    if (!g_ann_pats.empty()) RTN_AddInstrumentFunction(InstrumentAnnotateRtn, nullptr);
+    if (g_dfg_on) INS_AddInstrumentFunction(InstrumentDfg, nullptr);
+    if (g_dead_on) INS_AddInstrumentFunction(InstrumentDead, nullptr);
+    if (g_vec_on) INS_AddInstrumentFunction(InstrumentVec, nullptr);
+    if (g_fp_on) INS_AddInstrumentFunction(InstrumentFp, nullptr);
+    if (g_mem_on) INS_AddInstrumentFunction(InstrumentMem, nullptr);
+    if (g_cache_on) INS_AddInstrumentFunction(InstrumentCache, nullptr);
+    if (g_foot_window) INS_AddInstrumentFunction(InstrumentFootprint, nullptr);
+    if (g_mix_on) TRACE_AddInstrumentFunction(InstrumentMix, nullptr);
+    if (g_divs_on) INS_AddInstrumentFunction(InstrumentDivs, nullptr);
+    if (g_branches) INS_AddInstrumentFunction(InstrumentBranches, nullptr);
+    if (g_strides)  INS_AddInstrumentFunction(InstrumentStrides, nullptr);
+    if (g_reuse)    INS_AddInstrumentFunction(InstrumentReuse, nullptr);
+    if (g_mulvals) INS_AddInstrumentFunction(InstrumentMulVals, nullptr);
+    if (!g_classes.empty()) INS_AddInstrumentFunction(InstrumentClasses, nullptr);
+    if (g_trace == TRACE_CHAMPSIM) INS_AddInstrumentFunction(InstrumentChampSim, nullptr);
+    if (g_calls_on) {
+        RTN_AddInstrumentFunction(InstrumentCallRtn, nullptr);
+        INS_AddInstrumentFunction(InstrumentRet, nullptr);
+    }
     PIN_AddFiniFunction(Fini, nullptr);
 
+    if (knobDuration.Value() != "0" || !knobDetachFile.Value().empty()) {
+        PIN_AddDetachFunction(Detach, nullptr);
+        PIN_AddPrepareForFiniFunction(PrepareForFini, nullptr);
+        if (PIN_SpawnInternalThread(DetachController, nullptr, 0, &g_ctl_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start detach thread" << std::endl;
+            return 1;
+        }
+    }
+    if (Limited()) {
+        if (g_attached) {
+            std::cerr << "Int64Profiler: -timeout, -max_ops and -stop_file apply to launched runs"
+                      << " (attached ones stop with -duration or -detach_file)" << std::endl;
+            return 1;
+        }
+        PIN_AddPrepareForFiniFunction(LimitExit, nullptr);
+        if (PIN_SpawnInternalThread(LimitController, nullptr, 0, &g_limit_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start limit thread" << std::endl;
+            return 1;
+        }
+    }
+    if (!knobPartial.Value().empty()) {
+        if (g_attached || g_children_on) {
+            std::cerr << "Int64Profiler: -partial applies to launched runs without -children" << std::endl;
+            return 1;
+        }
+        if (strtod(knobPartialInterval.Value().c_str(), nullptr) <= 0) {
+            std::cerr << "Int64Profiler: -partial_interval must be positive" << std::endl;
+            return 1;
+        }
+        std::string err = OpenPartial(knobPartial.Value());
+        if (!err.empty()) {
+            std::cerr << "Int64Profiler: " << err << std::endl;
+            return 1;
+        }
+        PIN_AddPrepareForFiniFunction(PartialExit, nullptr);
+        if (PIN_SpawnInternalThread(PartialController, nullptr, 0, &g_partial_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start partial-counts thread" << std::endl;
+            return 1;
+        }
+    }
+    if (knobWarmup.Value() != "0" || knobWarmupOps.Value() != "0") {
+        if (knobWarmup.Value() != "0" && knobWarmupOps.Value() != "0") {
+            std::cerr << "Int64Profiler: -warmup and -warmup_ops are exclusive" << std::endl;
+            return 1;
+        }
+        g_warmup = knobWarmupOps.Value() != "0" ? "ops"
+                 : knobWarmup.Value() == "auto" ? "auto" : "time";
+        PIN_AddPrepareForFiniFunction(WarmupExit, nullptr);
+        if (PIN_SpawnInternalThread(WarmupController, nullptr, 0, &g_warmup_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start warm-up thread" << std::endl;
+            return 1;
+        }
+    }
+    if (!knobPhases.Value().empty()) {
+        const std::string& m = knobPhases.Value();
+        if (m != "auto" && m != "marker") {
+            std::cerr << "Int64Profiler: -phases wants auto or marker, not '" << m << "'" << std::endl;
+            return 1;
+        }
+        if (g_sampling || g_children_on || g_warmup) {
+            std::cerr << "Int64Profiler: -phases excludes -sample, -children and -warmup" << std::endl;
+            return 1;
+        }
+        g_phase_mode = m == "auto" ? "auto" : "marker";
+        g_phases.push_back({m == "auto" ? "phase1" : "default", 0, Cnts{}});
+        g_phase_cur = 0;
+        if (m == "marker") {
+            RTN_AddInstrumentFunction(InstrumentPhaseRtn, nullptr);
+        } else {
+            PIN_AddPrepareForFiniFunction(PhaseExit, nullptr);
+            if (PIN_SpawnInternalThread(PhaseController, nullptr, 0, &g_phase_uid)
+                    == INVALID_THREADID) {
+                std::cerr << "Int64Profiler: cannot start phase thread" << std::endl;
+                return 1;
+            }
+        }
+    }
+    if (g_percpu_on) {
+        PIN_AddPrepareForFiniFunction(CpuExit, nullptr);
+        if (PIN_SpawnInternalThread(CpuController, nullptr, 0, &g_cpu_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start CPU sampling thread" << std::endl;
+            return 1;
+        }
+    }
+    if (g_ts_out.is_open()) {
+        TimeSeriesHeader();
+        PIN_AddPrepareForFiniFunction(TimeSeriesExit, nullptr);
+        if (PIN_SpawnInternalThread(TimeSeriesController, nullptr, 0, &g_ts_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start time series thread" << std::endl;
+            return 1;
+        }
+    }
+    if (g_stream) {
+        PIN_AddPrepareForFiniFunction(StreamExit, nullptr);
+        if (PIN_SpawnInternalThread(StreamController, nullptr, 0, &g_stream_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start stream thread" << std::endl;
+            return 1;
+        }
+    }
+    if (!knobCheckpoint.Value().empty() || !knobResume.Value().empty()) {
+        if (g_sampling || g_children_on || g_warmup || g_phase_mode) {
+            std::cerr << "Int64Profiler: -checkpoint and -resume exclude -sample, -children, -warmup"
+                      << " and -phases" << std::endl;
+            return 1;
+        }
+        if (!knobResume.Value().empty()) {
+            std::string err = LoadCheckpoint(knobResume.Value());
+            if (!err.empty()) {
+                std::cerr << "Int64Profiler: -resume " << knobResume.Value() << ": " << err << std::endl;
+                return 1;
+            }
+            DBG(1, "Resuming from checkpoint " << g_carry.seq << ", " << g_carry.elapsed << " s");
+        }
+    }
+    if (!knobCheckpoint.Value().empty()) {
+        if (strtod(knobCheckpointInterval.Value().c_str(), nullptr) <= 0) {
+            std::cerr << "Int64Profiler: -checkpoint_interval must be positive" << std::endl;
+            return 1;
+        }
+        // fail now rather than at the first checkpoint, an interval into the run
+        std::string part = knobCheckpoint.Value() + ".part";
+        int fd = open(part.c_str(), O_WRONLY | O_CREAT, 0644);
+        if (fd < 0) {
+            std::cerr << "Int64Profiler: -checkpoint " << knobCheckpoint.Value() << ": cannot write "
+                      << part << ": " << strerror(errno) << std::endl;
+            return 1;
+        }
+        close(fd);
+        std::remove(part.c_str());
+        PIN_AddPrepareForFiniFunction(CheckpointExit, nullptr);
+        if (PIN_SpawnInternalThread(CheckpointController, nullptr, 0, &g_ckpt_uid)
+                == INVALID_THREADID) {
+            std::cerr << "Int64Profiler: cannot start checkpoint thread" << std::endl;
+            return 1;
+        }
+    }
+
     PIN_StartProgram();
     return 0;
 }
\ No newline at end of file
@@ -0,0 +1,156 @@
+/*
+ * int64_qemu.c – QEMU TCG plugin behind iccad's qemu backend
+ *
+ * Counts how often every translated block executes and, when the guest
+ * exits, writes one line per instruction of each block that ran:
+ *
+ *     <executions> <vaddr hex> <bytes hex> <symbol or ->
+ *
+ * and, with futex=<syscall number> arguments (iccad's -atomics), a
+ * "# futex <calls>" line counting those system calls.
+ *
+ * iccad classifies the bytes with the static backend's aarch64 / riscv64
+ * decoders, so the plugin itself knows nothing about the guest ISA.
+ *
+ * Needs QEMU 9.1 or later (per-vCPU scoreboards, qemu_plugin_insn_data
+ * copying into a buffer).  Build and use:
+ *
+ *     gcc -shared -fPIC -O2 $(pkg-config --cflags glib-2.0) \
+ *         -I<qemu>/include/qemu int64_qemu.c -o libint64qemu.so
+ *     qemu-aarch64 -plugin ./libint64qemu.so,out=counts.txt ./prog
+ */
+#include <inttypes.h>
+#include <stdio.h>
+#include <string.h>
+
+#include <glib.h>
+#include <qemu-plugin.h>
+
+#if QEMU_PLUGIN_VERSION < 3
+#error "int64_qemu needs the QEMU 9.1 plugin API or later"
+#endif
+
+QEMU_PLUGIN_EXPORT int qemu_plugin_version = QEMU_PLUGIN_VERSION;
+
+// ── translated blocks ─────────────────────────────────────────────
+// A block is identified by its start address and instruction count;
+// QEMU retranslates the same code many times (after a TB flush, per
+// vCPU …) and every translation adds to the same counter.
+typedef struct {
+    GString *insns;                         // "<vaddr> <bytes> <symbol>\n" each
+    struct qemu_plugin_scoreboard *execs;   // one uint64_t per vCPU
+} Block;
+
+static GHashTable *g_blocks;   // "vaddr/n" → Block
+static GMutex      g_lock;
+static char       *g_out;      // out= argument
+static int64_t     g_futex_nr[4];   // futex= arguments
+static int         g_futex_nrs;
+static uint64_t    g_futex;         // calls to them
+
+static Block *BlockFor(struct qemu_plugin_tb *tb)
+{
+    uint64_t vaddr = qemu_plugin_tb_vaddr(tb);
+    size_t   n     = qemu_plugin_tb_n_insns(tb);
+    char    *key   = g_strdup_printf("%" PRIx64 "/%zu", vaddr, n);
+
+    Block *b = g_hash_table_lookup(g_blocks, key);
+    if (b) {
+        g_free(key);
+        return b;
+    }
+    b = g_new0(Block, 1);
+    b->insns = g_string_new(NULL);
+    b->execs = qemu_plugin_scoreboard_new(sizeof(uint64_t));
+    for (size_t i = 0; i < n; i++) {
+        struct qemu_plugin_insn *insn = qemu_plugin_tb_get_insn(tb, i);
+        uint8_t     bytes[16];
+        size_t      size = qemu_plugin_insn_data(insn, bytes, sizeof bytes);
+        const char *sym  = qemu_plugin_insn_symbol(insn);
+
+        g_string_append_printf(b->insns, "%" PRIx64 " ", qemu_plugin_insn_vaddr(insn));
+        for (size_t j = 0; j < size; j++)
+            g_string_append_printf(b->insns, "%02x", bytes[j]);
+        g_string_append_printf(b->insns, " %s\n", sym && *sym ? sym : "-");
+    }
+    g_hash_table_insert(g_blocks, key, b);
+    return b;
+}
+
+static void VcpuTbTrans(qemu_plugin_id_t id, struct qemu_plugin_tb *tb)
+{
+    g_mutex_lock(&g_lock);
+    Block *b = BlockFor(tb);
+    g_mutex_unlock(&g_lock);
+
+    qemu_plugin_register_vcpu_tb_exec_inline_per_vcpu(
+        tb, QEMU_PLUGIN_INLINE_ADD_U64, qemu_plugin_scoreboard_u64(b->execs), 1);
+}
+
+// ── futex calls ───────────────────────────────────────────────────
+static void VcpuSyscall(qemu_plugin_id_t id, unsigned int vcpu, int64_t num, uint64_t a1,
+                        uint64_t a2, uint64_t a3, uint64_t a4, uint64_t a5, uint64_t a6,
+                        uint64_t a7, uint64_t a8)
+{
+    for (int i = 0; i < g_futex_nrs; i++)
+        if (num == g_futex_nr[i])
+            __atomic_fetch_add(&g_futex, 1, __ATOMIC_RELAXED);
+}
+
+// ── report ────────────────────────────────────────────────────────
+static void PluginExit(qemu_plugin_id_t id, void *p)
+{
+    FILE *out = g_out ? fopen(g_out, "w") : stderr;
+    if (!out) {
+        qemu_plugin_outs("int64_qemu: cannot open the out= file\n");
+        return;
+    }
+
+    GHashTableIter it;
+    gpointer       key, val;
+    g_mutex_lock(&g_lock);
+    g_hash_table_iter_init(&it, g_blocks);
+    while (g_hash_table_iter_next(&it, &key, &val)) {
+        Block   *b     = val;
+        uint64_t execs = qemu_plugin_u64_sum(qemu_plugin_scoreboard_u64(b->execs));
+        if (!execs)
+            continue;
+        for (char *line = b->insns->str; *line;) {
+            char *end = strchr(line, '\n');
+            fprintf(out, "%" PRIu64 " %.*s\n", execs, (int)(end - line), line);
+            line = end + 1;
+        }
+    }
+    g_mutex_unlock(&g_lock);
+    if (g_futex_nrs)
+        fprintf(out, "# futex %" PRIu64 "\n", __atomic_load_n(&g_futex, __ATOMIC_RELAXED));
+
+    if (out != stderr)
+        fclose(out);
+}
+
+QEMU_PLUGIN_EXPORT int qemu_plugin_install(qemu_plugin_id_t id, const qemu_info_t *info,
+                                           int argc, char **argv)
+{
+    if (info->system_emulation) {
+        fprintf(stderr, "int64_qemu: user-mode emulation only\n");
+        return -1;
+    }
+    for (int i = 0; i < argc; i++) {
+        if (g_str_has_prefix(argv[i], "out=")) {
+            g_out = g_strdup(argv[i] + 4);
+        } else if (g_str_has_prefix(argv[i], "futex=") && g_futex_nrs < 4) {
+            g_futex_nr[g_futex_nrs++] = g_ascii_strtoll(argv[i] + 6, NULL, 10);
+        } else {
+            fprintf(stderr, "int64_qemu: unknown option %s\n", argv[i]);
+            return -1;
+        }
+    }
+
+    g_blocks = g_hash_table_new(g_str_hash, g_str_equal);
+    qemu_plugin_register_vcpu_tb_trans_cb(id, VcpuTbTrans);
+    if (g_futex_nrs)
+        qemu_plugin_register_vcpu_syscall_cb(id, VcpuSyscall);
+    qemu_plugin_register_atexit_cb(id, PluginExit, NULL);
+    return 0;
+}
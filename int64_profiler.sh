@@ -2,11 +2,165 @@
 ###############################################################################
 # int64_profiler.sh – run Int64Profiler
 #
-#   ./int64_profiler.sh <target> [function] [--verbose] [-- <prog-args…>]
+#   ./int64_profiler.sh <target|--attach=PID> [function] [--funcs] [--callgraph] [--folded=FILE] [--folded-weight=LIST]
+#                       [--lines] [--loops] [--blocks=N] [--annotate=GLOB] [--dfg] [--modules] [--follow-children] [--threads] [--systime] [--fp]
+#                       [--regions] [--vec] [--wide] [--widths=LIST] [--signedness] [--compound=fused|split|both] [--agen=off|category|fold] [--mem] [--cache=SPEC] [--mix] [--modarith] [--butterflies] [--divs] [--branches=N] [--strides=N] [--footprint=N] [--mulvals[=N]] [--ops=LIST] [--class=NAME=GLOB,…] [--include=GLOB] [--exclude=GLOB]
+#                       [--include-func=RE] [--exclude-func=RE] [--include-module=RE] [--exclude-module=RE] [--debug-dir=DIR] [--debuginfod=URLS] [--go] [--jit] [--python] [--sample=FRACTION] [--window=N] [--seed=N] [--duration=SEC] [--stream=SEC] [--stream-funcs=N] [--syscalls=FILE]
+#                       [--timeout=SEC] [--max-ops=N] [--max-output-bytes=N] [--warmup=SEC|auto] [--warmup-ops=N] [--steady-tol=PCT]
+#                       [--phases=auto|marker] [--phase-interval=SEC] [--phase-shift=PCT]
+#                       [--checkpoint=FILE] [--checkpoint-interval=SEC] [--resume=FILE]
+#                       [--timeseries=FILE] [--timeseries-interval=SEC] [--timeseries-format=json|csv]
+#                       [--repeat=N] [--cv=PCT] [--sweep=GRID] [--format=text|json|csv|tsv|html|pprof|dot] [--layout=long|wide] [--names=demangled|raw|both] [--top=N] [--verbose] [-- <prog-args…>]
 #
+#   • --attach=PID → attach to a running process instead of launching one;
+#                    counts for --duration=SEC, or until Ctrl-C, then
+#                    detaches and leaves the process running
 #   • If <function> is omitted → count the whole program
 #   • If provided  → counts only inside that symbol using -addr 0x…
 #   • If function starts with "start_" or "begin_" → use marker mode
+#   • --funcs      → add a per-function breakdown to the report
+#   • --callgraph  → add inclusive/exclusive counts by calling context
+#   • --folded=FILE → also write collapsed stacks for flamegraph.pl, weighted
+#                    by --folded-weight=LIST (op types such as mul or fp64_fma,
+#                    or int, bitwise, vec, wide, fp64, fp32, fp; default int)
+#   • --lines      → add a per-source-line breakdown (needs -g)
+#   • --loops      → add a per-loop breakdown with entries, trip counts and
+#                    ops per iteration
+#   • --blocks=N   → list the N basic blocks with the most operations, with
+#                    their decoded instructions (operands and op types in JSON)
+#   • --annotate=GLOB → list every instruction of the matching functions with
+#                    its execution count (repeatable; see iccad annotate)
+#   • --dfg        → build the dataflow graph of <function>, the marked region
+#                    or the --include code; --format=dot draws it
+#   • --modules    → add a per-module breakdown (executable, shared
+#                    libraries, dlopen()ed ones marked as such)
+#   • --follow-children → also count forked and exec'd children and add a
+#                    per-process breakdown with their total
+#   • --threads    → add a per-thread breakdown to the report
+#   • --systime    → time the system calls and report them, with the
+#                    kernel CPU time, apart from the user-mode counts
+#   • --regions    → count only inside Int64ProfilerStart/Stop (client/)
+#                    markers and report each named region, checking the
+#                    budgets Int64ProfilerAssertBudget declares at every
+#                    exit (exit status 4 when one is broken)
+#   • --fp         → also count FP64/FP32 add/sub/mul/div/fma (lane ops)
+#   • --vec        → also count packed int64 lane ops (SSE/AVX/AVX-512)
+#   • --wide       → detect 128-bit and wider add/sub/mul limb sequences
+#   • --widths=LIST → break the counts down by operand size: 8,16,32,64,128
+#                    or all; narrower operands are counted by the 64-bit
+#                    rules, double-width MUL/DIV go to 128
+#   • --signedness → split MUL and DIV into signed (IMUL, IDIV) and
+#                    unsigned (MUL, MULX, DIV)
+#   • --compound=P → count FMA, IFMA and LEA b+i as one op (fused, the
+#                    default), as their multiply and add (split), or fused
+#                    with the split-out ops listed too (both)
+#   • --agen=M     → count the adds and shifts of LEAs and memory-operand
+#                    addressing in their own section (category), or as
+#                    add and shl too (fold)
+#   • --mem        → also count loads, stores and bytes moved (ops per byte)
+#   • --cache=SPEC → simulate caches (default, or e.g. l1=32k:8,l2=1m:16,line=64)
+#                    on the memory accesses: hit rates, DRAM bytes and ops per
+#                    DRAM byte; implies --mem
+#   • --mix        → also count branches (taken / not taken), jumps, calls,
+#                    returns and moves: the whole instruction mix
+#   • --modarith   → recognize modmul (Montgomery/Barrett/Shoup), modadd and
+#                    modsub sequences
+#   • --butterflies → count NTT butterflies per transform, with the sizes
+#                    (implies --modarith)
+#   • --divs       → class each 64-bit division site by its divisors
+#                    (power of two, constant, variable)
+#   • --branches=N → list the N conditional branches a simulated predictor
+#                    missed most, with their lines (and loops with --loops)
+#   • --strides=N  → classify loop memory accesses as unit, constant or
+#                    irregular stride, listing the N loops accessing most
+#                    (implies --loops)
+#   • --footprint=N → track the working set: distinct cache lines and pages
+#                    touched per window of N data accesses, per function and
+#                    per phase (with --phases)
+#   • --mulvals[=N] → histogram multiply operand bit widths, reading every
+#                    Nth multiply (default every one)
+#   • --ops=LIST   → also count shl,shr,rol,and,or,xor,not (or "bitwise")
+#   • --class=NAME=GLOB,… → also count the instructions whose mnemonic
+#                    matches a GLOB as category NAME (repeatable; e.g.
+#                    --class=crc32=CRC32 --class='aes=AES*,VAES*')
+#   • --include=GLOB / --exclude=GLOB → count only functions whose name
+#                    matches / does not match GLOB (repeatable; e.g.
+#                    --exclude='runtime.*'); filtered code is not instrumented
+#   • --include-func=RE / --exclude-func=RE → the same with an extended
+#                    regex matched anywhere in the name (--exclude-func='memcpy')
+#   • --include-module=RE / --exclude-module=RE → count only / never code in
+#                    images whose path matches RE (--include-module='libcrypto\.so')
+#   • --debug-dir=DIR → look for the separate debug info of stripped images
+#                    under DIR (by build ID, DIR/.build-id/xx/yyyy.debug, or
+#                    .gnu_debuglink name) before /usr/lib/debug (repeatable);
+#                    --debuginfod=URLS (default $DEBUGINFOD_URLS) first
+#                    fetches what is missing for the target and its libraries
+#                    from debuginfod servers (needs iccad)
+#   • --go         → split the counts of a Go binary into user code, standard
+#                    library and runtime
+#   • --jit        → name JIT-compiled code (JVM, .NET) from the perf map and
+#                    jitdump files the runtime writes to /tmp
+#   • --python     → attribute counts to Python functions; run the script
+#                    with python3 -m int64profiler (client/)
+#   • --sample=F   → count a random fraction F of instruction windows and
+#                    extrapolate (--window=N instructions each, --seed=N)
+#   • --stream=SEC → while the target runs, print a JSON line with the counts
+#                    so far and over the last SEC seconds to stderr;
+#                    --stream-funcs=N adds the N busiest functions (implies
+#                    --funcs)
+#   • --syscalls=FILE → write the target's system calls to FILE, one
+#                    "TID NR RET" line each
+#   • --timeout=SEC / --max-ops=N / --max-output-bytes=N → stop the target
+#                    after SEC seconds, about N counted operations, or once
+#                    it printed more than N bytes on stdout, and report the
+#                    counts so far flagged as truncated (exit status 3).
+#                    Ctrl-C does the same.  A target that crashes still
+#                    gets its report and the script exits with its status
+#                    (128 plus the signal number for a crash); one killed
+#                    outright (SIGKILL, OOM) gets the totals of the last
+#                    second (exit status 3, needs iccad)
+#   • --warmup=SEC / --warmup-ops=N → discard the counts of the first SEC
+#                    seconds or N counted operations; --warmup=auto waits
+#                    until every category's rate stays within --steady-tol=PCT
+#                    (default 10) of its mean for a second
+#   • --phases=auto|marker → also break the report into phases: where the
+#                    operation mix of two --phase-interval=SEC periods
+#                    (default 0.5) in a row moves by more than
+#                    --phase-shift=PCT (default 25), or at every
+#                    Int64ProfilerPhase(name) call
+#   • --checkpoint=FILE → write the counts so far to FILE every
+#                    --checkpoint-interval=SEC (default 600); --resume=FILE
+#                    starts a new run, with the same counting options, from
+#                    them (the target itself starts over)
+#   • --timeseries=FILE → write the counts of every --timeseries-interval=SEC
+#                    (default 0.01) to FILE with the Unix time, one JSON
+#                    object per line or CSV rows (--timeseries-format=csv,
+#                    the default for a .csv file)
+#   • --repeat=N   → run the target N times and print each counter's mean,
+#                    median, stddev and range, flagging counters whose
+#                    coefficient of variation exceeds --cv=PCT (default 1;
+#                    text or json, needs iccad)
+#   • --sweep=GRID → run the target once per point of a parameter grid,
+#                    e.g. 'N=1024,2048;threads=1,4', with {N} and {threads}
+#                    in its arguments replaced by each point's values, and
+#                    print every point's totals and how each counter scales
+#                    with each parameter (text, json, csv or tsv, needs iccad)
+#   • --format=json → print the versioned JSON report (status lines → stderr)
+#   • --format=csv|tsv → print flat totals / per-instruction / per-function
+#                    tables (status lines → stderr); --layout=wide gives one
+#                    row per function with one column per op type
+#   • --format=html → print a self-contained HTML page with charts, sortable
+#                    tables and annotated sources (needs iccad on PATH)
+#   • --format=pprof → print a gzipped pprof profile for go tool pprof, one
+#                    sample per call stack with --callgraph (needs iccad)
+#   • --names=raw  → name functions by their symbols (_ZN2ns3AccImE3mulEm)
+#                    instead of demangling C++ and Rust ones, the default
+#                    when iccad is on PATH; --names=both gives the symbol
+#                    too in CSV, TSV, HTML, pprof and DOT (needs iccad).
+#                    --include and the other filters match the symbols
+#   • --top=N      → show only the N functions with the most of each op
+#                    type, with their share and cumulative share, the rest
+#                    summed as one [other] row (implies --funcs, needs iccad)
 ###############################################################################
 set -euo pipefail
 
@@ -16,51 +170,458 @@ TOOL_SO="$PIN_HOME/source/tools/Int64Profiler/obj-intel64/Int64Profiler.so"
 ###############################################################################
 # 1. parse positional args
 ###############################################################################
-[[ $# -ge 1 ]] || { echo "Usage: $(basename "$0") <target> [function]"; exit 1; }
-TARGET=$1; shift
+[[ $# -ge 1 ]] || { echo "Usage: $(basename "$0") <target|--attach=PID> [function] [--funcs] [--callgraph] [--folded=FILE] [--folded-weight=LIST] [--lines] [--loops] [--blocks=N] [--annotate=GLOB] [--dfg] [--modules] [--follow-children] [--threads] [--systime] [--fp] [--regions] [--vec] [--wide] [--widths=LIST] [--signedness] [--compound=fused|split|both] [--agen=off|category|fold] [--mem] [--cache=SPEC] [--mix] [--modarith] [--butterflies] [--divs] [--branches=N] [--strides=N] [--footprint=N] [--mulvals[=N]] [--ops=LIST] [--class=NAME=GLOB,…] [--include=GLOB] [--exclude=GLOB] [--include-func=RE] [--exclude-func=RE] [--include-module=RE] [--exclude-module=RE] [--debug-dir=DIR] [--debuginfod=URLS] [--go] [--jit] [--python] [--sample=F] [--window=N] [--seed=N] [--duration=SEC] [--stream=SEC] [--stream-funcs=N] [--syscalls=FILE] [--timeout=SEC] [--max-ops=N] [--max-output-bytes=N] [--warmup=SEC|auto] [--warmup-ops=N] [--steady-tol=PCT] [--phases=auto|marker] [--phase-interval=SEC] [--phase-shift=PCT] [--checkpoint=FILE] [--checkpoint-interval=SEC] [--resume=FILE] [--timeseries=FILE] [--timeseries-interval=SEC] [--timeseries-format=json|csv] [--repeat=N] [--cv=PCT] [--sweep=GRID] [--format=text|json|csv|tsv|html|pprof|dot] [--layout=long|wide] [--names=demangled|raw|both] [--top=N] [--verbose]"; exit 1; }
+ATTACH=""
+if [[ $1 == --attach=* ]]; then
+  ATTACH=${1#--attach=}
+  TARGET=/proc/$ATTACH/exe
+else
+  TARGET=$1
+fi
+shift
 
 FUNC=""
 if [[ $# -gt 0 && $1 != --* ]]; then FUNC=$1; shift; fi
 
 VERBOSE=0
-if [[ "${1:-}" == "--verbose" ]]; then VERBOSE=1; shift; fi
-if [[ "${1:-}" == "--" ]]; then shift; fi   # discard separator
+FUNCS=0
+CALLGRAPH=0
+FOLDED=""
+WEIGHT=""
+LINES=0
+LOOPS=0
+BLOCKS=""
+ANNOTATE=()
+DFG=0
+MODULES=0
+FOLLOW=0
+THREADS=0
+SYSTIME=0
+FP=0
+REGIONS=0
+WIDE=0
+WIDTHS=""
+SIGNEDNESS=0
+COMPOUND=""
+AGEN=""
+MEM=0
+CACHE=""
+MIX=0
+MODARITH=0
+BUTTERFLIES=0
+DIVS=0
+BRANCHES=""
+STRIDES=""
+FOOTPRINT=""
+MULVALS=""
+VEC=0
+OPS=""
+CLASSES=()
+FILTERS=()
+DEBUG_DIRS=()
+DEBUG_FETCH=( -deps )   # iccad debuginfo flags
+DEBUGINFOD=${DEBUGINFOD_URLS:-}
+GO=0
+JIT=0
+PYTHON=0
+SAMPLE=""
+WINDOW=""
+SEED=""
+DURATION=""
+STREAM=""
+STREAM_FUNCS=""
+SYSCALLS=""
+TIMEOUT=""
+MAX_OPS=""
+MAX_OUTPUT=""
+WARMUP=""
+WARMUP_OPS=""
+STEADY_TOL=""
+PHASES=""
+PHASE_INTERVAL=""
+PHASE_SHIFT=""
+CHECKPOINT=""
+CHECKPOINT_INTERVAL=""
+RESUME=""
+TIMESERIES=""
+TS_INTERVAL=""
+TS_FORMAT=""
+REPEAT=1
+CV=""
+SWEEP=""
+FORMAT=text
+LAYOUT=long
+NAMES=demangled
+TOP=0
+while [[ $# -gt 0 ]]; do
+  case $1 in
+    --verbose)  VERBOSE=1; shift ;;
+    --funcs)    FUNCS=1;   shift ;;
+    --callgraph) CALLGRAPH=1; shift ;;
+    --folded=*) FOLDED=${1#--folded=}; shift ;;
+    --folded-weight=*) WEIGHT=${1#--folded-weight=}; shift ;;
+    --lines)    LINES=1;   shift ;;
+    --loops)    LOOPS=1;   shift ;;
+    --blocks=*) BLOCKS=${1#--blocks=}; shift ;;
+    --annotate=*) ANNOTATE+=( -annotate "${1#--annotate=}" ); shift ;;
+    --dfg)      DFG=1;     shift ;;
+    --modules)  MODULES=1; shift ;;
+    --follow-children) FOLLOW=1; shift ;;
+    --threads)  THREADS=1; shift ;;
+    --systime)  SYSTIME=1; shift ;;
+    --fp)       FP=1;      shift ;;
+    --regions)  REGIONS=1; shift ;;
+    --wide)     WIDE=1;    shift ;;
+    --widths=*) WIDTHS=${1#--widths=}; shift ;;
+    --signedness) SIGNEDNESS=1; shift ;;
+    --compound=*) COMPOUND=${1#--compound=}; shift ;;
+    --agen=*)   AGEN=${1#--agen=}; shift ;;
+    --mem)      MEM=1;     shift ;;
+    --cache=*)  CACHE=${1#--cache=}; shift ;;
+    --mix)      MIX=1;     shift ;;
+    --modarith) MODARITH=1; shift ;;
+    --butterflies) BUTTERFLIES=1; shift ;;
+    --divs)     DIVS=1;    shift ;;
+    --branches=*) BRANCHES=${1#--branches=}; shift ;;
+    --strides=*) STRIDES=${1#--strides=}; shift ;;
+    --footprint=*) FOOTPRINT=${1#--footprint=}; shift ;;
+    --mulvals)  MULVALS=1; shift ;;
+    --mulvals=*) MULVALS=${1#--mulvals=}; shift ;;
+    --vec)      VEC=1;     shift ;;
+    --ops=*)    OPS=${1#--ops=};       shift ;;
+    --class=*)  CLASSES+=( -class "${1#--class=}" ); shift ;;
+    --include=*) FILTERS+=( -include "${1#--include=}" ); shift ;;
+    --exclude=*) FILTERS+=( -exclude "${1#--exclude=}" ); shift ;;
+    --include-func=*) FILTERS+=( -include_func "${1#--include-func=}" ); shift ;;
+    --exclude-func=*) FILTERS+=( -exclude_func "${1#--exclude-func=}" ); shift ;;
+    --include-module=*) FILTERS+=( -include_module "${1#--include-module=}" ); shift ;;
+    --debug-dir=*) DEBUG_DIRS+=( -debug_dir "${1#--debug-dir=}" ); DEBUG_FETCH+=( -debug-dir "${1#--debug-dir=}" ); shift ;;
+    --debuginfod=*) DEBUGINFOD=${1#--debuginfod=}; shift ;;
+    --exclude-module=*) FILTERS+=( -exclude_module "${1#--exclude-module=}" ); shift ;;
+    --go)       GO=1;      shift ;;
+    --jit)      JIT=1;     shift ;;
+    --python)   PYTHON=1;  shift ;;
+    --sample=*) SAMPLE=${1#--sample=}; shift ;;
+    --window=*) WINDOW=${1#--window=}; shift ;;
+    --seed=*)   SEED=${1#--seed=};     shift ;;
+    --duration=*) DURATION=${1#--duration=}; shift ;;
+    --stream=*) STREAM=${1#--stream=}; shift ;;
+    --stream-funcs=*) STREAM_FUNCS=${1#--stream-funcs=}; FUNCS=1; shift ;;
+    --syscalls=*) SYSCALLS=${1#--syscalls=}; shift ;;
+    --timeout=*) TIMEOUT=${1#--timeout=}; shift ;;
+    --max-ops=*) MAX_OPS=${1#--max-ops=}; shift ;;
+    --max-output-bytes=*) MAX_OUTPUT=${1#--max-output-bytes=}; shift ;;
+    --warmup=*) WARMUP=${1#--warmup=}; shift ;;
+    --warmup-ops=*) WARMUP_OPS=${1#--warmup-ops=}; shift ;;
+    --steady-tol=*) STEADY_TOL=${1#--steady-tol=}; shift ;;
+    --phases=*) PHASES=${1#--phases=}; shift ;;
+    --phase-interval=*) PHASE_INTERVAL=${1#--phase-interval=}; shift ;;
+    --phase-shift=*) PHASE_SHIFT=${1#--phase-shift=}; shift ;;
+    --checkpoint=*) CHECKPOINT=${1#--checkpoint=}; shift ;;
+    --checkpoint-interval=*) CHECKPOINT_INTERVAL=${1#--checkpoint-interval=}; shift ;;
+    --resume=*) RESUME=${1#--resume=}; shift ;;
+    --timeseries=*) TIMESERIES=${1#--timeseries=}; shift ;;
+    --timeseries-interval=*) TS_INTERVAL=${1#--timeseries-interval=}; shift ;;
+    --timeseries-format=*) TS_FORMAT=${1#--timeseries-format=}; shift ;;
+    --repeat=*) REPEAT=${1#--repeat=}; shift ;;
+    --cv=*)     CV=${1#--cv=};         shift ;;
+    --sweep=*)  SWEEP=${1#--sweep=};   shift ;;
+    --format=*) FORMAT=${1#--format=}; shift ;;
+    --layout=*) LAYOUT=${1#--layout=}; shift ;;
+    --names=*)  NAMES=${1#--names=}; shift ;;
+    --top=*)    TOP=${1#--top=}; FUNCS=1; shift ;;
+    --)         shift; break ;;     # discard separator
+    *)          break ;;
+  esac
+done
+[[ $FORMAT =~ ^(text|json|csv|tsv|html|pprof|dot)$ ]] || { echo "Unknown format '$FORMAT'"; exit 1; }
+[[ $LAYOUT == long || $LAYOUT == wide ]] || { echo "Unknown layout '$LAYOUT'"; exit 1; }
+[[ $NAMES =~ ^(demangled|raw|both)$ ]] || { echo "Unknown names mode '$NAMES'"; exit 1; }
+[[ $TOP =~ ^[0-9]+$ ]] || { echo "--top needs a number of functions"; exit 1; }
+[[ $REPEAT =~ ^[1-9][0-9]*$ ]] || { echo "--repeat needs a positive count"; exit 1; }
+
+# status lines (and target output) go to fd 3 so JSON and CSV on stdout stay clean
+if [[ $FORMAT == text ]]; then exec 3>&1; else exec 3>&2; fi
 
 ###############################################################################
 # 2. sanity checks
 ###############################################################################
-[[ -x "$TARGET" ]]   || { echo "Target $TARGET not executable"; exit 1; }
+if (( REPEAT > 1 )); then
+  [[ -z $ATTACH ]] || { echo "--repeat cannot be combined with --attach"; exit 1; }
+  [[ $FORMAT == text || $FORMAT == json ]] || { echo "--repeat reports statistics as text or json"; exit 1; }
+fi
+if [[ -n $SWEEP ]]; then
+  [[ $SWEEP =~ ^([A-Za-z_][A-Za-z0-9_]*=[^\;,=\ ]+(,[^\;,=\ ]+)*(\;|$))+$ ]] || { echo "--sweep needs name=value,value… for each parameter, separated by ';'"; exit 1; }
+  [[ -z $ATTACH ]] && (( REPEAT == 1 )) || { echo "--sweep cannot be combined with --attach or --repeat"; exit 1; }
+  [[ $FORMAT =~ ^(text|json|csv|tsv)$ ]] || { echo "--sweep reports as text, json, csv or tsv"; exit 1; }
+  command -v iccad >/dev/null || { echo "--sweep needs iccad on PATH (go install ./cmd/iccad)"; exit 1; }
+  IFS=';' read -ra SWEEP_PARAMS <<< "$SWEEP"
+  for p in "${SWEEP_PARAMS[@]}"; do
+    [[ " $* " == *"{${p%%=*}}"* ]] || { echo "--sweep parameter ${p%%=*} is not in the target's arguments (write {${p%%=*}} where its values go)"; exit 1; }
+  done
+fi
+if [[ $FORMAT == html || $FORMAT == pprof || $FORMAT == dot ]] || (( REPEAT > 1 )); then
+  command -v iccad >/dev/null || { echo "--format=$FORMAT needs iccad on PATH (go install ./cmd/iccad)"; exit 1; }
+fi
+if [[ $NAMES == both ]]; then
+  command -v iccad >/dev/null || { echo "--names=both needs iccad on PATH (go install ./cmd/iccad)"; exit 1; }
+fi
+if (( TOP > 0 )); then
+  command -v iccad >/dev/null || { echo "--top needs iccad on PATH (go install ./cmd/iccad)"; exit 1; }
+fi
+# the pintool reports symbols, which iccad demangles
+RENDER=
+if [[ $FORMAT == html || $FORMAT == pprof || $FORMAT == dot ]] || (( TOP > 0 )) || { [[ $NAMES != raw ]] && command -v iccad >/dev/null; }; then
+  RENDER=1
+fi
+if [[ -n $TIMEOUT$MAX_OPS$MAX_OUTPUT ]]; then
+  [[ -z $ATTACH ]] || { echo "--timeout, --max-ops and --max-output-bytes bound launched runs (use --duration with --attach)"; exit 1; }
+  [[ $MAX_OUTPUT =~ ^[0-9]*$ ]] || { echo "--max-output-bytes needs a byte count"; exit 1; }
+fi
+if [[ -n $WARMUP && -n $WARMUP_OPS ]]; then
+  echo "--warmup and --warmup-ops are mutually exclusive"; exit 1
+fi
+if [[ -n $ATTACH ]]; then
+  kill -0 "$ATTACH" 2>/dev/null || { echo "No process $ATTACH"; exit 1; }
+else
+  [[ -x "$TARGET" ]] || { echo "Target $TARGET not executable"; exit 1; }
+fi
 [[ -f "$TOOL_SO" ]]  || { echo "Int64Profiler.so missing";    exit 1; }
 
 ###############################################################################
 # 3. resolve symbol → address  (only if a function was given)
 ###############################################################################
 PIN_ARGS=()
-if [[ -n "$FUNC" ]]; then
+if (( REGIONS )); then
+  [[ -z "$FUNC" ]] || { echo "--regions cannot be combined with a function"; exit 1; }
+  echo "📍  Profiling client-API regions${ATTACH:+ in process $ATTACH}" >&3
+  PIN_ARGS+=( -regions 1 )
+elif [[ -n "$FUNC" ]]; then
   # Check if this is a marker function
   if [[ "$FUNC" == start_* ]] || [[ "$FUNC" == begin_* ]]; then
-    echo "📍  Using marker mode for $FUNC()"
+    echo "📍  Using marker mode for $FUNC()" >&3
     PIN_ARGS+=( -start "$FUNC" )
     # Tool will auto-derive stop marker name
   else
     # Original address-based logic
     ADDR=$(nm "$TARGET" | grep -E '[[:space:]](T|t)[[:space:]]'"$FUNC"'$' | awk '{print $1; exit}')
     [[ -n "$ADDR" ]] || { echo "Function '$FUNC' not found"; exit 1; }
-    echo "📍  Profiling only $FUNC() @ 0x$ADDR"
+    echo "📍  Profiling only $FUNC() @ 0x$ADDR" >&3
     PIN_ARGS+=( -addr "0x$ADDR" )
   fi
 else
-  echo "📍  Profiling entire process"
+  echo "📍  Profiling entire process${ATTACH:+ $ATTACH}" >&3
 fi
 (( VERBOSE )) && PIN_ARGS+=( -dbg 2 )
+(( FUNCS ))   && PIN_ARGS+=( -funcs 1 )
+(( CALLGRAPH )) && PIN_ARGS+=( -callgraph 1 )
+[[ -n $FOLDED ]] && PIN_ARGS+=( -folded "$(realpath -m "$FOLDED")" )
+[[ -n $WEIGHT ]] && PIN_ARGS+=( -folded_weight "$WEIGHT" )
+(( LINES ))   && PIN_ARGS+=( -lines 1 )
+(( LOOPS ))   && PIN_ARGS+=( -loops 1 )
+[[ -n $BLOCKS ]] && PIN_ARGS+=( -blocks "$BLOCKS" )
+(( ${#ANNOTATE[@]} )) && PIN_ARGS+=( "${ANNOTATE[@]}" )
+(( DFG ))     && PIN_ARGS+=( -dfg 1 )
+(( MODULES )) && PIN_ARGS+=( -modules 1 )
+(( FOLLOW ))  && PIN_ARGS+=( -children 1 )
+(( THREADS )) && PIN_ARGS+=( -threads 1 )
+(( SYSTIME )) && PIN_ARGS+=( -systime 1 )
+(( FP ))      && PIN_ARGS+=( -fp 1 )
+(( WIDE ))    && PIN_ARGS+=( -wide 1 )
+[[ -n $WIDTHS ]] && PIN_ARGS+=( -widths "$WIDTHS" )
+(( SIGNEDNESS )) && PIN_ARGS+=( -signedness 1 )
+[[ -n $COMPOUND ]] && PIN_ARGS+=( -compound "$COMPOUND" )
+[[ -n $AGEN ]] && PIN_ARGS+=( -agen "$AGEN" )
+(( MEM ))     && PIN_ARGS+=( -mem 1 )
+[[ -n $CACHE ]] && PIN_ARGS+=( -cache "$CACHE" )
+(( MIX ))     && PIN_ARGS+=( -mix 1 )
+(( MODARITH )) && PIN_ARGS+=( -modarith 1 )
+(( BUTTERFLIES )) && PIN_ARGS+=( -butterflies 1 )
+(( DIVS ))    && PIN_ARGS+=( -divs 1 )
+[[ -n $BRANCHES ]] && PIN_ARGS+=( -branches "$BRANCHES" )
+[[ -n $STRIDES ]] && PIN_ARGS+=( -strides "$STRIDES" )
+[[ -n $FOOTPRINT ]] && PIN_ARGS+=( -footprint "$FOOTPRINT" )
+[[ -n $MULVALS ]] && PIN_ARGS+=( -mulvals "$MULVALS" )
+(( VEC ))     && PIN_ARGS+=( -vec 1 )
+[[ -n $OPS ]]    && PIN_ARGS+=( -ops "$OPS" )
+(( ${#CLASSES[@]} )) && PIN_ARGS+=( "${CLASSES[@]}" )
+(( ${#FILTERS[@]} )) && PIN_ARGS+=( "${FILTERS[@]}" )
+(( ${#DEBUG_DIRS[@]} )) && PIN_ARGS+=( "${DEBUG_DIRS[@]}" )
+(( GO ))      && PIN_ARGS+=( -go 1 )
+(( JIT ))     && PIN_ARGS+=( -jit 1 )
+(( PYTHON ))  && PIN_ARGS+=( -python 1 )
+[[ -n $SAMPLE ]] && PIN_ARGS+=( -sample "$SAMPLE" )
+[[ -n $WINDOW ]] && PIN_ARGS+=( -window "$WINDOW" )
+[[ -n $SEED ]]   && PIN_ARGS+=( -seed "$SEED" )
+[[ -n $STREAM ]] && PIN_ARGS+=( -stream "$STREAM" )
+[[ -n $STREAM_FUNCS ]] && PIN_ARGS+=( -stream_funcs "$STREAM_FUNCS" )
+[[ -n $SYSCALLS ]] && PIN_ARGS+=( -syscalls "$(realpath -m "$SYSCALLS")" )
+[[ -n $TIMEOUT ]] && PIN_ARGS+=( -timeout "$TIMEOUT" )
+[[ -n $MAX_OPS ]] && PIN_ARGS+=( -max_ops "$MAX_OPS" )
+[[ -n $WARMUP ]] && PIN_ARGS+=( -warmup "$WARMUP" )
+[[ -n $WARMUP_OPS ]] && PIN_ARGS+=( -warmup_ops "$WARMUP_OPS" )
+[[ -n $STEADY_TOL ]] && PIN_ARGS+=( -steady_tol "$STEADY_TOL" )
+[[ -n $PHASES ]] && PIN_ARGS+=( -phases "$PHASES" )
+[[ -n $PHASE_INTERVAL ]] && PIN_ARGS+=( -phase_interval "$PHASE_INTERVAL" )
+[[ -n $PHASE_SHIFT ]] && PIN_ARGS+=( -phase_shift "$PHASE_SHIFT" )
+[[ -n $CHECKPOINT ]] && PIN_ARGS+=( -checkpoint "$CHECKPOINT" )
+[[ -n $CHECKPOINT_INTERVAL ]] && PIN_ARGS+=( -checkpoint_interval "$CHECKPOINT_INTERVAL" )
+[[ -n $RESUME ]] && PIN_ARGS+=( -resume "$RESUME" )
+if [[ -n $TIMESERIES ]]; then
+  [[ -n $TS_FORMAT ]] || { [[ ${TIMESERIES,,} == *.csv ]] && TS_FORMAT=csv || TS_FORMAT=json; }
+  PIN_ARGS+=( -timeseries "$(realpath -m "$TIMESERIES")" -timeseries_format "$TS_FORMAT" )
+  [[ -n $TS_INTERVAL ]] && PIN_ARGS+=( -timeseries_interval "$TS_INTERVAL" )
+fi
+# HTML pages, pprof profiles, demangled and top-N reports, repeated-run
+# statistics and sweeps are rendered by iccad from the JSON reports
+if (( REPEAT > 1 )) || [[ -n $SWEEP ]]; then
+  PIN_ARGS+=( -format json )
+elif [[ -n $RENDER ]]; then
+  PIN_ARGS+=( -format json -layout "$LAYOUT" )
+else
+  PIN_ARGS+=( -format "$FORMAT" -layout "$LAYOUT" )
+fi
+
+REPORT=$(mktemp)
+STOP="$REPORT.stop"
+trap 'rm -f "$REPORT" "$REPORT".* "$STOP"' EXIT
+(( REPEAT > 1 )) || [[ -n $SWEEP ]] || PIN_ARGS+=( -o "$REPORT" )   # each run writes $REPORT.N
+if [[ -n $ATTACH ]]; then
+  if [[ -n $DURATION ]]; then PIN_ARGS+=( -duration "$DURATION" )
+  else                        PIN_ARGS+=( -detach_file "$STOP" )
+  fi
+else
+  # Ctrl-C and --max-output-bytes stop the target through it
+  PIN_ARGS+=( -stop_file "$STOP" )
+  # totals that outlive a run killed before its report
+  (( REPEAT > 1 || FOLLOW )) || [[ -n $SAMPLE$SWEEP ]] || PIN_ARGS+=( -partial "$REPORT.partial" )
+fi
+
+# the target's stdout, up to --max-output-bytes: one byte more asks the tool
+# to stop it, and the rest is drained so the target never sees a broken pipe
+target_output() {
+  head -c "$MAX_OUTPUT"
+  if IFS= read -r -d '' -n 1 _; then
+    printf 'max_output_bytes\n' > "$STOP.part" && mv "$STOP.part" "$STOP"
+    cat >/dev/null
+  fi
+}
+run_pin() {
+  if [[ -n $MAX_OUTPUT ]]; then "$@" | target_output; else "$@"; fi
+}
+# how the target of a run ended, when it failed: $1 its exit status, $2 what
+# leads the message
+target_status() {
+  if (( $1 > 128 )); then
+    echo "${2}Target killed by SIG$(kill -l $(($1 - 128)))" >&2
+  elif (( $1 )); then
+    echo "${2}Target exited with status $1" >&2
+  fi
+}
+
+# Pin's own options, ahead of -t
+PIN_OPTS=()
+(( FOLLOW )) && PIN_OPTS+=( -follow_execv )
+
+# debug info of stripped images, into the debuginfod cache the tool reads
+if [[ -n $DEBUGINFOD ]] && command -v iccad >/dev/null; then
+  echo "🔷  Fetching debug info…" >&3
+  iccad debuginfo "${DEBUG_FETCH[@]}" -debuginfod "$DEBUGINFOD" "$TARGET" >/dev/null 2>&1 || true
+fi
 
 ###############################################################################
 # 4. run Pin
 ###############################################################################
-echo "🔷  Running Pin…"
-if (( VERBOSE )); then
-  "$PIN_HOME/pin" -t "$TOOL_SO" "${PIN_ARGS[@]}" -- "$TARGET" "$@"
+if [[ -n $ATTACH ]]; then
+  echo "🔷  Attaching Pin to $ATTACH…" >&3
+  "$PIN_HOME/pin" "${PIN_OPTS[@]}" -pid "$ATTACH" -t "$TOOL_SO" "${PIN_ARGS[@]}" >&3
+  [[ -n $DURATION ]] || { echo "    counting; press Ctrl-C to detach" >&3; trap 'touch "$STOP"' INT; }
+  # the tool renames the finished report over $REPORT at detach or exit
+  while [[ ! -s $REPORT ]] && kill -0 "$ATTACH" 2>/dev/null; do sleep 0.2; done
+  [[ -s $REPORT ]] || { echo "Process $ATTACH exited without a report"; exit 1; }
+elif (( REPEAT > 1 )); then
+  status=0 failed=0
+  for (( i = 1; i <= REPEAT; i++ )); do
+    echo "🔷  Run $i/$REPEAT…" >&3
+    rm -f "$STOP"
+    run=0
+    if (( VERBOSE )); then
+      run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -o "$REPORT.$i" -- "$TARGET" "$@" >&3 || run=$?
+    else
+      run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -o "$REPORT.$i" -- "$TARGET" "$@" >/dev/null || run=$?
+    fi
+    target_status "$run" "Run $i: "
+    (( run == 0 )) || { status=$run; failed=$((failed + 1)); }
+    [[ -s $REPORT.$i ]] || { echo "Run $i left no report"; exit $(( status ? status : 1 )); }
+    ! grep -q '"truncated":' "$REPORT.$i" || { echo "Run $i stopped at a limit; not using partial counts"; exit 3; }
+  done
+  iccad stats ${CV:+-cv "$CV"} -format "$FORMAT" "$REPORT".[0-9]* || exit
+  (( failed == 0 )) || echo "The target failed in $failed of the $REPEAT runs" >&2
+  exit "$status"
+elif [[ -n $SWEEP ]]; then
+  # the grid's points, each name=value;…, the last parameter varying fastest
+  POINTS=( "" )
+  for p in "${SWEEP_PARAMS[@]}"; do
+    IFS=',' read -ra vals <<< "${p#*=}"
+    next=()
+    for pt in "${POINTS[@]}"; do
+      for v in "${vals[@]}"; do next+=( "$pt${p%%=*}=$v;" ); done
+    done
+    POINTS=( "${next[@]}" )
+  done
+  SWEEP_REPORTS=()
+  status=0 failed=0
+  for (( i = 1; i <= ${#POINTS[@]}; i++ )); do
+    pt=${POINTS[i-1]%;}
+    args=( "$@" )
+    IFS=';' read -ra pairs <<< "$pt"
+    for pair in "${pairs[@]}"; do args=( "${args[@]//"{${pair%%=*}}"/${pair#*=}}" ); done
+    echo "🔷  Point $i/${#POINTS[@]}: ${pt//;/ }…" >&3
+    rm -f "$STOP"
+    run=0
+    if (( VERBOSE )); then
+      run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -o "$REPORT.$i" -- "$TARGET" "${args[@]}" >&3 || run=$?
+    else
+      run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -o "$REPORT.$i" -- "$TARGET" "${args[@]}" >/dev/null || run=$?
+    fi
+    target_status "$run" "Point $i: "
+    (( run == 0 )) || { status=$run; failed=$((failed + 1)); }
+    [[ -s $REPORT.$i ]] || { echo "Point $i left no report"; exit $(( status ? status : 1 )); }
+    ! grep -q '"truncated":' "$REPORT.$i" || { echo "Point $i stopped at a limit; not using partial counts"; exit 3; }
+    SWEEP_REPORTS+=( "$REPORT.$i" )
+  done
+  iccad sweep -format "$FORMAT" "$SWEEP" "${SWEEP_REPORTS[@]}" || exit
+  (( failed == 0 )) || echo "The target failed at $failed of the ${#POINTS[@]} points" >&2
+  exit "$status"
 else
-  RAW=$( "$PIN_HOME/pin" -t "$TOOL_SO" "${PIN_ARGS[@]}" -- "$TARGET" "$@" )
-  echo "$RAW" | grep -E '^(ADD:|SUB:|MUL:|DIV:)'
-fi
\ No newline at end of file
+  trap 'printf "interrupt\n" > "$STOP.part" && mv "$STOP.part" "$STOP"' INT TERM
+  status=0
+  if (( VERBOSE )); then
+    run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -- "$TARGET" "$@" >&3 || status=$?
+  else
+    run_pin "$PIN_HOME/pin" "${PIN_OPTS[@]}" -t "$TOOL_SO" "${PIN_ARGS[@]}" -- "$TARGET" "$@" >/dev/null || status=$?
+  fi
+  trap - INT TERM
+  target_status "$status"
+  if [[ ! -s $REPORT ]]; then
+    [[ -s $REPORT.partial ]] && command -v iccad >/dev/null || { echo "No report"; exit 1; }
+    [[ $FORMAT == text || $FORMAT == json ]] || FORMAT=text
+    iccad report -format "$FORMAT" -names "$NAMES" "$REPORT.partial"
+    echo "Killed before its report: totals of the last update only" >&2
+    exit 3
+  fi
+fi
+if [[ -n $RENDER ]]; then
+  iccad report -format "$FORMAT" -layout "$LAYOUT" -names "$NAMES" -top "$TOP" "$REPORT"
+else
+  cat "$REPORT"
+fi
+if grep -q -e '^Truncated:' -e '"truncated":' "$REPORT"; then
+  echo "Stopped at a limit or by Ctrl-C: the counts are partial" >&2
+  exit 3
+fi
+if grep -q -e '^Over budget:' -e '"over": [1-9]' "$REPORT"; then
+  echo "A region broke its operation budget" >&2
+  exit 4
+fi
+exit "${status:-0}"
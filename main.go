@@ -0,0 +1,3 @@
+package main
+import ("bufio";"fmt";"os";"github.com/abe5240/iccad/profiler")
+func main(){s:=bufio.NewScanner(os.Stdin);for s.Scan(){fmt.Println(profiler.Demangle(s.Text()))}}
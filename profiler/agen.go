@@ -0,0 +1,71 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Address-generation modes.
+const (
+	AgenOff      = "off"      // LEAs and addressing modes are not arithmetic
+	AgenCategory = "category" // their adds and shifts in Result.Agen only
+	AgenFold     = "fold"     // counted as add and shl besides, variant "agen"
+)
+
+// Agen counts the arithmetic x86 compilers hide in address generation
+// (Options.Agen): an address base + index*scale + disp takes an add per
+// term past the first and a shift when the index is scaled. LEA is that of
+// the LEAs of 64-bit results, Memory that of the explicit memory operands;
+// RIP-relative and absolute addresses take none. Folded, the adds are also
+// in the add total, as Categories["add"]["agen"] (RR for LEAs, RM for
+// memory operands), and the shifts likewise in shl when it is selected.
+type Agen struct {
+	Mode   string     `json:"mode"`
+	LEA    AgenCounts `json:"lea"`
+	Memory AgenCounts `json:"memory"`
+}
+
+// AgenCounts is the address arithmetic of one source.
+type AgenCounts struct {
+	Adds   uint64 `json:"adds"`
+	Shifts uint64 `json:"shifts"`
+}
+
+// AgenMode returns the mode r was counted with.
+func (r *Result) AgenMode() string {
+	if r.Agen == nil {
+		return AgenOff
+	}
+	return r.Agen.Mode
+}
+
+// checkAgen validates Options.Agen.
+func (o *Options) checkAgen() error {
+	switch o.Agen {
+	case "", AgenOff:
+		return nil
+	case AgenCategory, AgenFold:
+	default:
+		return fmt.Errorf("profiler: Agen %q is not %s, %s or %s", o.Agen, AgenOff, AgenCategory, AgenFold)
+	}
+	if o.Backend != BackendPin {
+		return fmt.Errorf("%w: %s backend does not decode addressing modes", ErrUnsupported, o.Backend)
+	}
+	if o.Compound == CompoundSplit {
+		return fmt.Errorf("profiler: Agen counts LEAs itself; use it with Compound %s or %s",
+			CompoundFused, CompoundBoth)
+	}
+	return nil
+}
+
+// writeAgen renders the address arithmetic of a run by source.
+func writeAgen(w io.Writer, a *Agen) {
+	fmt.Fprintf(w, "\n----- Address generation (%s) -----\n", a.Mode)
+	fmt.Fprintf(w, "%-12s%14s%14s\n", "SOURCE", "ADDS", "SHIFTS")
+	row := func(src string, adds, shifts uint64) {
+		fmt.Fprintf(w, "%-12s%14d%14d\n", src, adds, shifts)
+	}
+	row("lea", a.LEA.Adds, a.LEA.Shifts)
+	row("memory", a.Memory.Adds, a.Memory.Shifts)
+	row("total", a.LEA.Adds+a.Memory.Adds, a.LEA.Shifts+a.Memory.Shifts)
+}
@@ -0,0 +1,168 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// SourceFiles returns the distinct source files in the per-line
+// breakdown, sorted, excluding the "??" bucket.
+func (r *Result) SourceFiles() []string {
+	seen := map[string]bool{}
+	var files []string
+	for _, l := range r.Lines {
+		if l.File == "??" || seen[l.File] {
+			continue
+		}
+		seen[l.File] = true
+		files = append(files, l.File)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// lineCounts returns the per-line counts recorded for file, keyed by line
+// number. Files match by exact path or, failing that, by base name so a
+// report can be annotated against a checkout in a different directory.
+func (r *Result) lineCounts(file string) map[int]Line {
+	exact, base := map[int]Line{}, map[int]Line{}
+	for _, l := range r.Lines {
+		switch {
+		case l.File == file:
+			exact[l.Line] = l
+		case filepath.Base(l.File) == filepath.Base(file):
+			base[l.Line] = l
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return base
+}
+
+// Annotate writes src (the contents of source file name) with the
+// operation counts of each line in a left-hand gutter, in the spirit of
+// `go tool cover`. With context >= 0 only counted lines and up to context
+// lines around them are printed; skipped stretches are shown as "⋮".
+func (r *Result) Annotate(w io.Writer, name string, src io.Reader, context int) error {
+	counts := r.lineCounts(name)
+	var text []string
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		text = append(text, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("profiler: read %s: %w", name, err)
+	}
+
+	show := make([]bool, len(text)+1)
+	for i := range show {
+		show[i] = context < 0
+	}
+	if context >= 0 {
+		for n := range counts {
+			for k := n - context; k <= n+context; k++ {
+				if k >= 1 && k <= len(text) {
+					show[k] = true
+				}
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "===== %s =====\n", name)
+	fmt.Fprintf(bw, "%10s%10s%10s%10s%10s  %5s\n", "ADD", "SUB", "MUL", "DIV", "FP", "LINE")
+	skipped := false
+	for i, line := range text {
+		n := i + 1
+		if !show[n] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			fmt.Fprintln(bw, "⋮")
+			skipped = false
+		}
+		if c, ok := counts[n]; ok {
+			fmt.Fprintf(bw, "%10s%10s%10s%10s%10s  %5d| %s\n",
+				blankZero(c.Add), blankZero(c.Sub), blankZero(c.Mul), blankZero(c.Div),
+				blankZero(fpSum(c.FP64)+fpSum(c.FP32)), n, line)
+		} else {
+			fmt.Fprintf(bw, "%50s  %5d| %s\n", "", n, line)
+		}
+	}
+	if skipped {
+		fmt.Fprintln(bw, "⋮")
+	}
+	return bw.Flush()
+}
+
+// AnnotateFile reads the source file at path and annotates it.
+func (r *Result) AnnotateFile(w io.Writer, path string, context int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	return r.Annotate(w, path, f, context)
+}
+
+func blankZero(v uint64) string {
+	if v == 0 {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// AnnotateFunc writes the annotated disassembly, as in the text report, of
+// the functions recorded with Options.Annotate whose name matches the glob
+// name. It fails if none does.
+func (r *Result) AnnotateFunc(w io.Writer, name string) error {
+	var fns []AnnotatedFunction
+	for _, f := range r.Annotated {
+		if ok, _ := path.Match(name, f.Function); ok || f.Function == name {
+			fns = append(fns, f)
+		}
+	}
+	if len(fns) == 0 {
+		return fmt.Errorf("profiler: no annotated function matches %q", name)
+	}
+	bw := bufio.NewWriter(w)
+	writeAnnotated(bw, fns)
+	return bw.Flush()
+}
+
+// writeAnnotated renders each function instruction by instruction with its
+// executions, their share of the function's and the op it counts as, in
+// the style of perf annotate. The file:line above an instruction marks
+// where the source line changes.
+func writeAnnotated(w io.Writer, fns []AnnotatedFunction) {
+	for _, f := range fns {
+		fmt.Fprintf(w, "\n----- Annotated disassembly: %s (%s) -----\n", f.Function, f.Image)
+		fmt.Fprintf(w, "%14s%8s  %-12s%10s  INSTRUCTION\n", "EXECUTIONS", "PCT", "OP", "OFFSET")
+		var file string
+		line := 0
+		for _, in := range f.Instructions {
+			if in.Line > 0 && (in.Line != line || in.File != file) {
+				fmt.Fprintf(w, "%48s%s:%d\n", "", in.File, in.Line)
+			}
+			file, line = in.File, in.Line
+			op := in.Op
+			if in.Lanes > 1 {
+				op += fmt.Sprintf(" x%d", in.Lanes)
+			}
+			pct := ""
+			if in.Executions != 0 {
+				pct = fmt.Sprintf("%.1f%%", 100*float64(in.Executions)/float64(f.Executions))
+			}
+			fmt.Fprintf(w, "%14s%8s  %-12s%10s  %s\n", blankZero(in.Executions), pct, op,
+				fmt.Sprintf("+%#x", in.Offset), in.Disasm)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package profiler
+
+import "encoding/binary"
+
+// a64Insns lists the instruction names classifyA64 reports under each
+// arithmetic category.
+var a64Insns = map[string][]string{
+	"add": {"add", "adc"},
+	"sub": {"sub", "sbc"},
+	"mul": {"mul", "madd", "mull", "maddl", "mulh"},
+	"div": {"udiv", "sdiv"},
+}
+
+// classifyA64 decodes one little-endian A64 instruction word. Like the
+// x86 pintool it counts 64-bit register forms only: immediate arithmetic
+// and logic, compares and tests (writes to XZR), register moves and
+// stack-pointer arithmetic are left out; shifts and rotates by an
+// immediate are counted. NEON integer add/sub count per 64-bit lane and
+// FP arithmetic per lane of either precision.
+func classifyA64(w uint32) (staticOp, bool) {
+	sf := w>>31 == 1
+	rd, rn, rm := w&31, w>>5&31, w>>16&31
+	ra := w >> 10 & 31
+
+	switch {
+	case w&0x1F200000 == 0x0B000000: // add/sub (shifted register)
+		if !sf || rd == 31 || rn == 31 {
+			return staticOp{}, false
+		}
+		if w>>30&1 == 0 {
+			return staticOp{"add", "add", 1}, true
+		}
+		return staticOp{"sub", "sub", 1}, true
+
+	case w&0x1FE00000 == 0x0B200000: // add/sub (extended register)
+		if !sf || rd == 31 || rn == 31 {
+			return staticOp{}, false
+		}
+		if w>>30&1 == 0 {
+			return staticOp{"add", "add", 1}, true
+		}
+		return staticOp{"sub", "sub", 1}, true
+
+	case w&0x1FE0FC00 == 0x1A000000: // adc / sbc
+		if !sf || rd == 31 {
+			return staticOp{}, false
+		}
+		if w>>30&1 == 0 {
+			return staticOp{"add", "adc", 1}, true
+		}
+		return staticOp{"sub", "sbc", 1}, true
+
+	case w&0x7F000000 == 0x1B000000: // data-processing (3 source)
+		if !sf {
+			return staticOp{}, false
+		}
+		switch w >> 21 & 7 {
+		case 0: // MADD / MSUB; MUL / MNEG when Ra is XZR
+			if ra == 31 {
+				return staticOp{"mul", "mul", 1}, true
+			}
+			return staticOp{"mul", "madd", 1}, true
+		case 1, 5: // [SU]MADDL / [SU]MSUBL; [SU]MULL when Ra is XZR
+			if ra == 31 {
+				return staticOp{"mul", "mull", 1}, true
+			}
+			return staticOp{"mul", "maddl", 1}, true
+		case 2, 6: // [SU]MULH
+			return staticOp{"mul", "mulh", 1}, true
+		}
+
+	case w&0x7FE00000 == 0x1AC00000: // data-processing (2 source)
+		if !sf {
+			return staticOp{}, false
+		}
+		switch w >> 10 & 63 {
+		case 2:
+			return staticOp{"div", "udiv", 1}, true
+		case 3:
+			return staticOp{"div", "sdiv", 1}, true
+		case 8:
+			return staticOp{"shl", "shl", 1}, true
+		case 9, 10:
+			return staticOp{"shr", "shr", 1}, true
+		case 11:
+			return staticOp{"rol", "rol", 1}, true
+		}
+
+	case w&0x1F000000 == 0x0A000000: // logical (shifted register)
+		if !sf || rd == 31 {
+			return staticOp{}, false
+		}
+		neg := w>>21&1 == 1
+		switch w >> 29 & 3 {
+		case 0, 3: // AND / BIC / ANDS / BICS
+			return staticOp{"and", "and", 1}, true
+		case 1: // ORR / ORN; MOV and MVN when Rn is XZR
+			if rn == 31 && neg {
+				return staticOp{"not", "not", 1}, true
+			}
+			if rn == 31 {
+				return staticOp{}, false
+			}
+			return staticOp{"or", "or", 1}, true
+		case 2: // EOR / EON
+			return staticOp{"xor", "xor", 1}, true
+		}
+
+	case w&0x7F800000 == 0x53000000 || w&0x7F800000 == 0x13000000: // [SU]BFM
+		if !sf || w>>22&1 != 1 {
+			return staticOp{}, false
+		}
+		immr, imms := w>>16&63, w>>10&63
+		unsigned := w>>29&3 == 2
+		switch {
+		case unsigned && imms != 63 && imms+1 == immr: // LSL #n
+			return staticOp{"shl", "shl", 1}, true
+		case imms == 63 && w>>29&3 != 1: // LSR / ASR #n
+			return staticOp{"shr", "shr", 1}, true
+		}
+
+	case w&0xFFE00000 == 0x93C00000: // EXTR; ROR #n when Rn == Rm
+		if rn == rm {
+			return staticOp{"rol", "rol", 1}, true
+		}
+
+	case w&0x9F200400 == 0x0E200400: // Advanced SIMD three same
+		q := w>>30&1 == 1
+		u := w>>29&1 == 1
+		size := w >> 22 & 3
+		opc := w >> 11 & 31
+		if opc == 16 && size == 3 && q { // ADD / SUB .2D
+			if u {
+				return staticOp{"vec_sub", "sub", 2}, true
+			}
+			return staticOp{"vec_add", "add", 2}, true
+		}
+		prec, lanes := "fp32", uint64(2)
+		if size&1 == 1 {
+			prec, lanes = "fp64", 1
+		}
+		if q {
+			lanes *= 2
+		}
+		a := size>>1 == 1
+		switch {
+		case !u && opc == 26: // FADD / FSUB
+			if a {
+				return staticOp{prec + "_sub", "fsub", lanes}, true
+			}
+			return staticOp{prec + "_add", "fadd", lanes}, true
+		case u && opc == 27 && !a:
+			return staticOp{prec + "_mul", "fmul", lanes}, true
+		case u && opc == 31 && !a:
+			return staticOp{prec + "_div", "fdiv", lanes}, true
+		case !u && opc == 25: // FMLA / FMLS
+			return staticOp{prec + "_fma", "fmla", lanes}, true
+		}
+
+	case w&0xDF200400 == 0x5E200400: // Advanced SIMD scalar three same
+		if w>>11&31 == 16 && w>>22&3 == 3 { // ADD / SUB Dd
+			if w>>29&1 == 1 {
+				return staticOp{"vec_sub", "sub", 1}, true
+			}
+			return staticOp{"vec_add", "add", 1}, true
+		}
+
+	case w&0xFF200C00 == 0x1E200800: // FP data-processing (2 source)
+		prec, ok := a64FPType(w)
+		if !ok {
+			return staticOp{}, false
+		}
+		switch w >> 12 & 15 {
+		case 0, 8: // FMUL, FNMUL
+			return staticOp{prec + "_mul", "fmul", 1}, true
+		case 1:
+			return staticOp{prec + "_div", "fdiv", 1}, true
+		case 2:
+			return staticOp{prec + "_add", "fadd", 1}, true
+		case 3:
+			return staticOp{prec + "_sub", "fsub", 1}, true
+		}
+
+	case w&0xFF000000 == 0x1F000000: // FP data-processing (3 source)
+		if prec, ok := a64FPType(w); ok {
+			return staticOp{prec + "_fma", "fmadd", 1}, true
+		}
+	}
+	return staticOp{}, false
+}
+
+// decodeA64 classifies the instruction at the start of code; A64
+// instructions are always 4 bytes.
+func decodeA64(code []byte) (staticOp, int, bool) {
+	if len(code) < 4 {
+		return staticOp{}, len(code), false
+	}
+	op, ok := classifyA64(binary.LittleEndian.Uint32(code))
+	return op, 4, ok
+}
+
+// a64FPType returns the precision of a scalar FP instruction's ftype field.
+func a64FPType(w uint32) (string, bool) {
+	switch w >> 22 & 3 {
+	case 0:
+		return "fp32", true
+	case 1:
+		return "fp64", true
+	}
+	return "", false
+}
+
+// branchA64 returns the target of the A64 B, B.cond, CB[N]Z or TB[N]Z at
+// the start of code, at addr.
+func branchA64(code []byte, addr uint64) (uint64, bool) {
+	if len(code) < 4 {
+		return 0, false
+	}
+	w := binary.LittleEndian.Uint32(code)
+	var off int64
+	switch {
+	case w&0xFC000000 == 0x14000000: // B
+		off = int64(int32(w<<6)) >> 4
+	case w&0xFF000010 == 0x54000000, w&0x7E000000 == 0x34000000: // B.cond, CB[N]Z
+		off = int64(int32(w<<8)) >> 13 << 2
+	case w&0x7E000000 == 0x36000000: // TB[N]Z
+		off = int64(int32(w<<13)) >> 18 << 2
+	default:
+		return 0, false
+	}
+	return addr + uint64(off), true
+}
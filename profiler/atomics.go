@@ -0,0 +1,122 @@
+package profiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Atomics counts the synchronization a run did (Options.Atomics), to
+// tell how much of a multi-threaded kernel's integer work is locking
+// rather than arithmetic. RMW is the atomic read-modify-writes: x86
+// LOCK-prefixed instructions and XCHG with memory, A64 LSE atomics (LDADD,
+// SWP, CAS and their forms) and RISC-V AMOs, whatever their operand
+// size. Exclusive is the store-exclusives ending an A64 LDXR/STXR or
+// RISC-V LR/SC pair, one per attempt, so a failed one counts again when
+// the loop retries. Futex is the futex and futex_waitv system calls, in
+// the function issuing them; the static backend, which runs nothing, has
+// none, and the qemu backend does not attribute them to functions.
+type Atomics struct {
+	RMW       uint64 `json:"rmw"`
+	Exclusive uint64 `json:"exclusive,omitempty"`
+	Futex     uint64 `json:"futex"`
+}
+
+// Sum returns the synchronization operations of all kinds.
+func (a Atomics) Sum() uint64 { return a.RMW + a.Exclusive + a.Futex }
+
+// Atomic classes of an instruction.
+type atomicKind int
+
+const (
+	atomicNone atomicKind = iota
+	atomicRMW
+	atomicExclusive
+)
+
+// add counts n instructions of kind k.
+func (a *Atomics) add(k atomicKind, n uint64) {
+	switch k {
+	case atomicRMW:
+		a.RMW += n
+	case atomicExclusive:
+		a.Exclusive += n
+	}
+}
+
+// atomicX86 classes the x86 insn: a LOCK prefix, or XCHG with memory.
+func atomicX86(insn []byte) atomicKind {
+	for _, b := range insn {
+		switch {
+		case b == 0xF0:
+			return atomicRMW
+		case b == 0x66 || b == 0x67 || b == 0xF2 || b == 0xF3 ||
+			b == 0x2E || b == 0x36 || b == 0x3E || b == 0x26 || b == 0x64 || b == 0x65 || b&0xF0 == 0x40:
+			continue
+		}
+		break // the opcode
+	}
+	in, ok := decodeX86Insn(insn)
+	if ok && in.opmap == 0 && (in.op == 0x86 || in.op == 0x87) && in.mem {
+		return atomicRMW
+	}
+	return atomicNone
+}
+
+// atomicA64 classes the A64 insn.
+func atomicA64(insn []byte) atomicKind {
+	if len(insn) < 4 {
+		return atomicNone
+	}
+	w := binary.LittleEndian.Uint32(insn)
+	switch {
+	case w&0x3F000000 == 0x08000000: // load/store exclusive
+		size, o2, l, o1 := w>>30, w>>23&1, w>>22&1, w>>21&1
+		switch {
+		case o2 == 1 && o1 == 1, o2 == 0 && o1 == 1 && size < 2: // CAS, CASP
+			return atomicRMW
+		case o2 == 0 && l == 0: // STXR, STLXR, STXP, STLXP
+			return atomicExclusive
+		}
+	case w&0x3F200C00 == 0x38200000: // atomic memory operations
+		if o3, opc := w>>15&1, w>>12&7; o3 == 0 || opc == 0 { // LDADD…LDUMIN, SWP; not LDAPR
+			return atomicRMW
+		}
+	}
+	return atomicNone
+}
+
+// atomicRV64 classes the RV64 insn: the A extension's AMOs and SC.
+func atomicRV64(insn []byte) atomicKind {
+	if len(insn) < 4 || insn[0]&3 != 3 {
+		return atomicNone
+	}
+	w := binary.LittleEndian.Uint32(insn)
+	if w&0x7F != 0x2F {
+		return atomicNone
+	}
+	switch w >> 27 {
+	case 2: // LR
+		return atomicNone
+	case 3:
+		return atomicExclusive
+	}
+	return atomicRMW
+}
+
+// writeAtomics renders a and its operations per op of ints, the run's
+// add..div and -ops counts; exclusive adds the row of the ISAs with
+// store-exclusives and futex that of the backends that run the code.
+func writeAtomics(w io.Writer, a *Atomics, ints uint64, exclusive, futex bool) {
+	fmt.Fprintf(w, "\n----- Synchronization -----\n")
+	fmt.Fprintf(w, "Atomic RMW:    %d\n", a.RMW)
+	if exclusive {
+		fmt.Fprintf(w, "Exclusive:     %d\n", a.Exclusive)
+	}
+	if futex {
+		fmt.Fprintf(w, "Futex calls:   %d\n", a.Futex)
+	}
+	if ints > 0 {
+		fmt.Fprintf(w, "Per int op:    %.4f\n", float64(a.Sum())/float64(ints))
+	}
+}
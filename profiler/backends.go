@@ -0,0 +1,102 @@
+package profiler
+
+import (
+	"fmt"
+	"slices"
+)
+
+// backendScope is what each backend other than pin counts, for the error
+// of an option it does not support.
+var backendScope = map[string]string{
+	BackendPerf:   "counts whole programs only",
+	BackendGPU:    "counts whole kernels only",
+	BackendStatic: "counts functions, loops and op types only",
+	BackendQEMU:   "counts functions and op types only",
+	BackendWASM:   "counts functions and op types only",
+	BackendEBPF:   "counts PMU events in functions only",
+	BackendHybrid: "counts functions and op types only",
+}
+
+// backendOption is an option not every backend supports: name is its
+// Options field (or fields), set reports whether opts uses it and
+// backends lists those besides pin that do. Every option of the pin
+// backend that another one lacks has a row, so a new option only needs
+// its own.
+type backendOption struct {
+	name     string
+	set      func(o *Options) bool
+	backends []string
+}
+
+var backendOptions = []backendOption{
+	{"Func", func(o *Options) bool { return o.Func != "" }, []string{BackendStatic, BackendQEMU, BackendWASM, BackendEBPF, BackendHybrid}},
+	{"StartMarker", func(o *Options) bool { return o.StartMarker != "" }, nil},
+	{"Regions", func(o *Options) bool { return o.Regions }, nil},
+	{"Funcs", func(o *Options) bool { return o.Funcs }, []string{BackendGPU, BackendStatic, BackendQEMU, BackendWASM, BackendEBPF, BackendHybrid}},
+	{"CallGraph", func(o *Options) bool { return o.CallGraph }, nil},
+	{"Lines", func(o *Options) bool { return o.Lines }, nil},
+	{"Loops", func(o *Options) bool { return o.Loops }, []string{BackendStatic}},
+	{"Blocks", func(o *Options) bool { return o.Blocks != 0 }, nil},
+	{"Annotate", func(o *Options) bool { return len(o.Annotate) > 0 }, nil},
+	{"Dataflow", func(o *Options) bool { return o.Dataflow }, nil},
+	{"DeadWork", func(o *Options) bool { return o.DeadWork }, nil},
+	{"Modules", func(o *Options) bool { return o.Modules }, nil},
+	{"Threads", func(o *Options) bool { return o.Threads }, nil},
+	{"PerCPU", func(o *Options) bool { return o.PerCPU }, nil},
+	{"SysTime", func(o *Options) bool { return o.SysTime }, nil},
+	{"FP", func(o *Options) bool { return o.FP }, []string{BackendGPU, BackendStatic, BackendQEMU, BackendWASM, BackendHybrid}},
+	{"Vec", func(o *Options) bool { return o.Vec }, []string{BackendStatic, BackendQEMU, BackendWASM, BackendHybrid}},
+	{"Wide", func(o *Options) bool { return o.Wide }, nil},
+	{"Signedness", func(o *Options) bool { return o.Signedness }, []string{BackendStatic, BackendQEMU, BackendWASM, BackendHybrid}},
+	{"Atomics", func(o *Options) bool { return o.Atomics }, []string{BackendStatic, BackendQEMU}},
+	{"Mem", func(o *Options) bool { return o.Mem }, nil},
+	{"Mix", func(o *Options) bool { return o.Mix }, nil},
+	{"Compound", func(o *Options) bool { return o.Compound != "" }, []string{BackendPerf, BackendStatic, BackendQEMU, BackendWASM, BackendEBPF, BackendHybrid}},
+	{"ModArith", func(o *Options) bool { return o.ModArith }, nil},
+	{"Butterflies", func(o *Options) bool { return o.Butterflies }, nil},
+	{"Divs", func(o *Options) bool { return o.Divs }, nil},
+	{"MulVals", func(o *Options) bool { return o.MulVals != 0 }, nil},
+	{"Branches", func(o *Options) bool { return o.Branches != 0 }, nil},
+	{"Strides", func(o *Options) bool { return o.Strides != 0 }, nil},
+	{"Reuse", func(o *Options) bool { return o.Reuse != 0 }, nil},
+	{"Footprint", func(o *Options) bool { return o.Footprint != 0 }, nil},
+	{"Ops", func(o *Options) bool { return len(o.Ops) > 0 }, []string{BackendStatic, BackendQEMU, BackendWASM, BackendHybrid}},
+	{"Classes", func(o *Options) bool { return len(o.Classes) > 0 }, []string{BackendStatic, BackendQEMU, BackendWASM, BackendHybrid}},
+	{"Include", func(o *Options) bool { return len(o.Include) > 0 }, []string{BackendEBPF}},
+	{"Exclude", func(o *Options) bool { return len(o.Exclude) > 0 }, nil},
+	{"IncludeFunc", func(o *Options) bool { return len(o.IncludeFunc) > 0 }, nil},
+	{"ExcludeFunc", func(o *Options) bool { return len(o.ExcludeFunc) > 0 }, nil},
+	{"IncludeModule", func(o *Options) bool { return len(o.IncludeModule) > 0 }, nil},
+	{"ExcludeModule", func(o *Options) bool { return len(o.ExcludeModule) > 0 }, nil},
+	{"Go", func(o *Options) bool { return o.Go }, nil},
+	{"JIT", func(o *Options) bool { return o.JIT }, nil},
+	{"Python", func(o *Options) bool { return o.Python }, nil},
+	{"Sample", func(o *Options) bool { return o.Sample != 0 }, nil},
+	{"FollowChildren", func(o *Options) bool { return o.FollowChildren }, nil},
+	{"Stream", func(o *Options) bool { return o.Stream != 0 }, nil},
+	{"StreamFuncs", func(o *Options) bool { return o.StreamFuncs != 0 }, nil},
+	{"SyscallTrace", func(o *Options) bool { return o.SyscallTrace != "" }, nil},
+	{"Warmup, WarmupOps or SteadyState", (*Options).warming, nil},
+	{"Phases", func(o *Options) bool { return o.Phases != "" }, nil},
+	{"TimeSeries", func(o *Options) bool { return o.TimeSeries != "" }, nil},
+	{"Checkpoint or Resume", (*Options).checkpointing, nil},
+	{"Calibration", func(o *Options) bool { return o.Calibration != nil }, nil},
+	{"CaptureOutput", func(o *Options) bool { return o.CaptureOutput != 0 }, []string{BackendPerf, BackendGPU, BackendQEMU, BackendWASM, BackendEBPF, BackendHybrid}},
+}
+
+// checkBackend rejects the first option the backend does not support,
+// naming it. Pin supports them all, and an unknown backend is New's to
+// report.
+func (o *Options) checkBackend() error {
+	scope, ok := backendScope[o.Backend]
+	if !ok {
+		return nil
+	}
+	for _, b := range backendOptions {
+		if !b.set(o) || slices.Contains(b.backends, o.Backend) {
+			continue
+		}
+		return fmt.Errorf("%w: %s: %s backend %s", ErrUnsupported, b.name, o.Backend, scope)
+	}
+	return nil
+}
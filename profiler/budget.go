@@ -0,0 +1,54 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOverBudget means a region broke an operation budget the workload
+// declared for it (Int64ProfilerAssertBudget, roi.AssertBudget); the
+// Result is returned with it.
+var ErrOverBudget = errors.New("profiler: over budget")
+
+// Budget is an operation budget a workload declared for a region, checked
+// at every exit of the region: of Exits, Over counted more than Limit of
+// Op, and the most any counted is Worst. Op is add, sub, mul, div, int, a
+// bitwise op or bitwise, vec, wide, fp64, fp32, fp, loads, stores, bytes
+// or ops (all counted operations). Options.Regions only.
+type Budget struct {
+	Region string `json:"region"`
+	Op     string `json:"op"`
+	Limit  uint64 `json:"limit"`
+	Worst  uint64 `json:"worst"`
+	Exits  uint64 `json:"exits"`
+	Over   uint64 `json:"over"`
+}
+
+func (b Budget) String() string {
+	return fmt.Sprintf("%s %s %d > %d in %d of %d exits", b.Region, b.Op, b.Worst, b.Limit, b.Over, b.Exits)
+}
+
+// OverBudget returns the budgets that some exit of their region broke.
+func (r *Result) OverBudget() []Budget {
+	var over []Budget
+	for _, b := range r.Budgets {
+		if b.Over > 0 {
+			over = append(over, b)
+		}
+	}
+	return over
+}
+
+// budgetErr returns ErrOverBudget with the budgets res broke, or nil.
+func budgetErr(res *Result) error {
+	over := res.OverBudget()
+	if len(over) == 0 {
+		return nil
+	}
+	s := make([]string, len(over))
+	for i, b := range over {
+		s[i] = b.String()
+	}
+	return fmt.Errorf("%w: %s", ErrOverBudget, strings.Join(s, "; "))
+}
@@ -0,0 +1,412 @@
+package profiler
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BundleVersion is the layout version of the bundles this package writes.
+const BundleVersion = 1
+
+// A Bundle is a Result packed with what reading it later, on another
+// machine, takes: where and on what it was recorded, the target's symbol
+// table and the source around its hottest lines. WriteBundle stores one
+// as a zip archive (conventionally *.iccad) of report.json, meta.json,
+// symbols.json and sources.json.
+type Bundle struct {
+	Result  *Result
+	Meta    BundleMeta
+	Symbols []Symbol  // the target's functions, in address order
+	Sources []Snippet // by file, then line
+}
+
+// BundleMeta describes the machine and the binary a bundled Result was
+// recorded on. Fields that could not be detected are empty.
+type BundleMeta struct {
+	Version int        `json:"version"` // BundleVersion
+	Created time.Time  `json:"created"`
+	Host    Host       `json:"host"`
+	Target  TargetInfo `json:"target"`
+}
+
+// Host is the machine a bundle was written on.
+type Host struct {
+	Hostname string `json:"hostname,omitempty"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Kernel   string `json:"kernel,omitempty"`    // release, as uname -r
+	CPUModel string `json:"cpu_model,omitempty"` // as /proc/cpuinfo
+	CPUs     int    `json:"cpus"`
+}
+
+// TargetInfo identifies the profiled binary and, when its build recorded
+// them, the compiler and flags it was built with: the DWARF producer of
+// C and C++ code built with -g (GCC records its flags there), the ELF
+// .comment section, or a Go binary's build information.
+type TargetInfo struct {
+	Path     string   `json:"path"`
+	Size     int64    `json:"size,omitempty"`
+	SHA256   string   `json:"sha256,omitempty"`
+	BuildID  string   `json:"build_id,omitempty"` // GNU build ID
+	Compiler []string `json:"compiler,omitempty"`
+	Go       string   `json:"go,omitempty"`    // Go version
+	Build    []string `json:"build,omitempty"` // Go build settings, key=value
+}
+
+// Symbol is a function of the target, to symbolize the addresses in a
+// report with. Mach-O symbols have no size: Size runs to the next one.
+type Symbol struct {
+	Name string `json:"name"`
+	Addr uint64 `json:"addr"`
+	Size uint64 `json:"size"`
+}
+
+// Snippet is the source of lines Start to Start+len(Text)-1 of File.
+type Snippet struct {
+	File  string   `json:"file"`
+	Start int      `json:"start"`
+	Text  []string `json:"text"`
+}
+
+// A bundle keeps the source of its BundleHotLines hottest lines and of
+// BundleContext lines around each.
+const (
+	BundleHotLines = 50
+	BundleContext  = 5
+)
+
+// NewBundle collects a Bundle of r on this machine: the target is read
+// from binary (default r.Binary.Path) and the sources from the paths in
+// r.Lines, when they are still there.
+func NewBundle(r *Result, binary string) *Bundle {
+	b := &Bundle{Result: r, Meta: BundleMeta{Version: BundleVersion, Created: time.Now().UTC(), Host: hostInfo()}}
+	if binary == "" {
+		binary = r.Binary.Path
+	}
+	b.Meta.Target.Path = binary
+	if binary != "" && r.Backend != BackendGPU {
+		b.Meta.Target, b.Symbols = targetInfo(binary, r.debugFile(binary))
+	}
+	b.Sources = hotSnippets(r, BundleHotLines, BundleContext)
+	return b
+}
+
+// hostInfo describes this machine.
+func hostInfo() Host {
+	h := Host{OS: runtime.GOOS, Arch: runtime.GOARCH, CPUs: runtime.NumCPU()}
+	h.Hostname, _ = os.Hostname()
+	if b, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		h.Kernel = strings.TrimSpace(string(b))
+	}
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			k, v, ok := strings.Cut(sc.Text(), ":")
+			if k = strings.TrimSpace(k); ok && (k == "model name" || k == "Model" || k == "uarch") {
+				h.CPUModel = strings.TrimSpace(v)
+				break
+			}
+		}
+	}
+	return h
+}
+
+// targetInfo identifies the binary at path and returns its functions,
+// those of a stripped ELF file from its debug file debug or one found in
+// the default directories.
+func targetInfo(path, debug string) (TargetInfo, []Symbol) {
+	t := TargetInfo{Path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, nil
+	}
+	sum := sha256.Sum256(data)
+	t.Size, t.SHA256 = int64(len(data)), hex.EncodeToString(sum[:])
+	if bi, err := buildinfo.Read(bytes.NewReader(data)); err == nil {
+		t.Go = bi.GoVersion
+		for _, s := range bi.Settings {
+			t.Build = append(t.Build, s.Key+"="+s.Value)
+		}
+	}
+
+	var syms []Symbol
+	var dw *dwarf.Data
+	if f, err := elf.NewFile(bytes.NewReader(data)); err == nil {
+		t.BuildID = elfBuildID(f)
+		if s := f.Section(".comment"); s != nil {
+			if c, err := s.Data(); err == nil {
+				for _, v := range bytes.Split(c, []byte{0}) {
+					t.Compiler = appendUnique(t.Compiler, strings.TrimSpace(string(v)))
+				}
+			}
+		}
+		sf, _ := withDebug(f, path, debug, nil)
+		if sf != f {
+			defer sf.Close()
+		}
+		all, _ := sf.Symbols()
+		dyn, _ := f.DynamicSymbols()
+		seen := map[uint64]bool{}
+		for _, s := range append(all, dyn...) {
+			if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 && !seen[s.Value] {
+				seen[s.Value] = true
+				syms = append(syms, Symbol{Name: s.Name, Addr: s.Value, Size: s.Size})
+			}
+		}
+		dw, _ = sf.DWARF()
+	} else if f, c, err := openMachO(path, machoCPU()); err == nil {
+		defer c.Close()
+		for _, fn := range machoFuncs(f) {
+			syms = append(syms, Symbol{Name: fn.name, Addr: fn.start, Size: fn.end - fn.start})
+		}
+		dw, _ = f.DWARF()
+	}
+	if dw != nil {
+		for _, p := range dwarfProducers(dw) {
+			t.Compiler = appendUnique(t.Compiler, p)
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	return t, syms
+}
+
+// machoCPU is the Mach-O slice to read on this machine.
+func machoCPU() macho.Cpu {
+	if runtime.GOARCH == "arm64" {
+		return macho.CpuArm64
+	}
+	return macho.CpuAmd64
+}
+
+// dwarfProducers returns the distinct DW_AT_producer strings of d's
+// compile units, the compiler and, for GCC, its flags.
+func dwarfProducers(d *dwarf.Data) []string {
+	var ps []string
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			return ps
+		}
+		if e.Tag == dwarf.TagCompileUnit {
+			if p, ok := e.Val(dwarf.AttrProducer).(string); ok {
+				ps = appendUnique(ps, p)
+			}
+		}
+		r.SkipChildren()
+	}
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// hotSnippets returns the source of the n lines of r with the most
+// operations, with context lines around each, merged per file; files that
+// cannot be read are left out.
+func hotSnippets(r *Result, n, context int) []Snippet {
+	lines := make([]Line, 0, len(r.Lines))
+	for _, l := range r.Lines {
+		if l.File != "??" && l.Line > 0 {
+			lines = append(lines, l)
+		}
+	}
+	ops := func(l Line) uint64 { return l.Sum() + l.BitSum() + fpSum(l.FP64) + fpSum(l.FP32) }
+	sort.SliceStable(lines, func(i, j int) bool { return ops(lines[i]) > ops(lines[j]) })
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	want := map[string][]int{}
+	for _, l := range lines {
+		want[l.File] = append(want[l.File], l.Line)
+	}
+	files := make([]string, 0, len(want))
+	for f := range want {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var out []Snippet
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		text := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+		show := make([]bool, len(text)+2)
+		for _, ln := range want[file] {
+			for k := ln - context; k <= ln+context; k++ {
+				if k >= 1 && k <= len(text) {
+					show[k] = true
+				}
+			}
+		}
+		for k := 1; k <= len(text); k++ {
+			if !show[k] {
+				continue
+			}
+			s := Snippet{File: file, Start: k}
+			for ; k <= len(text) && show[k]; k++ {
+				s.Text = append(s.Text, text[k-1])
+			}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+const bundleMagic = "PK\x03\x04" // a zip archive
+
+// WriteBundle writes b to w as a zip archive.
+func (b *Bundle) WriteBundle(w io.Writer) error {
+	z := zip.NewWriter(w)
+	for _, e := range []struct {
+		name string
+		v    any
+	}{{"meta.json", b.Meta}, {"report.json", b.Result}, {"symbols.json", b.Symbols}, {"sources.json", b.Sources}} {
+		f, err := z.Create(e.name)
+		if err != nil {
+			return fmt.Errorf("profiler: bundle: %w", err)
+		}
+		enc := json.NewEncoder(f)
+		if e.name != "symbols.json" {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(e.v); err != nil {
+			return fmt.Errorf("profiler: bundle: %s: %w", e.name, err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return fmt.Errorf("profiler: bundle: %w", err)
+	}
+	return nil
+}
+
+// ReadBundle reads the bundle at path.
+func ReadBundle(path string) (*Bundle, error) {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: bundle %s: %w", path, err)
+	}
+	defer z.Close()
+	b := &Bundle{}
+	f, err := z.Open("report.json")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: bundle %s: %w", path, err)
+	}
+	b.Result, err = Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("profiler: bundle %s: %w", path, err)
+	}
+	for _, e := range []struct {
+		name string
+		v    any
+	}{{"meta.json", &b.Meta}, {"symbols.json", &b.Symbols}, {"sources.json", &b.Sources}} {
+		f, err := z.Open(e.name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profiler: bundle %s: %w", path, err)
+		}
+		err = json.NewDecoder(f).Decode(e.v)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("profiler: bundle %s: %s: %w", path, e.name, err)
+		}
+	}
+	if b.Meta.Version > BundleVersion {
+		return nil, fmt.Errorf("%w: bundle version %d (newest known %d)", ErrUnsupportedSchema, b.Meta.Version, BundleVersion)
+	}
+	return b, nil
+}
+
+// Source returns the text of file as far as the bundle keeps it, the
+// lines it lacks left blank, or false when it keeps none of it. Files
+// match as in Result.Annotate: by path, else by base name.
+func (b *Bundle) Source(file string) (io.Reader, bool) {
+	var snips []Snippet
+	for _, s := range b.Sources {
+		if s.File == file {
+			snips = append(snips, s)
+		}
+	}
+	if len(snips) == 0 {
+		for _, s := range b.Sources {
+			if filepath.Base(s.File) == filepath.Base(file) {
+				snips = append(snips, s)
+			}
+		}
+	}
+	if len(snips) == 0 {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	n := 1
+	for _, s := range snips {
+		for ; n < s.Start; n++ {
+			buf.WriteByte('\n')
+		}
+		for _, t := range s.Text {
+			buf.WriteString(t)
+			buf.WriteByte('\n')
+			n++
+		}
+	}
+	return &buf, true
+}
+
+// Symbolize returns the function of the bundled target containing addr,
+// as name+0xoffset, or "" when none does. addr is an address of the
+// report's run: with Images it is relocated by the target's load bias,
+// without them it is taken as a link-time address.
+func (b *Bundle) Symbolize(addr uint64) string {
+	if b.Result != nil && len(b.Result.Images) > 0 {
+		m := b.Result.ImageAt(addr)
+		if m == nil || m.Path != b.Result.Binary.Path {
+			return ""
+		}
+		_, _, bias := m.bounds()
+		addr -= bias
+	}
+	i := sort.Search(len(b.Symbols), func(i int) bool { return b.Symbols[i].Addr > addr }) - 1
+	if i < 0 {
+		return ""
+	}
+	s := b.Symbols[i]
+	if s.Size != 0 && addr >= s.Addr+s.Size {
+		return ""
+	}
+	if addr == s.Addr {
+		return s.Name
+	}
+	return s.Name + "+0x" + strconv.FormatUint(addr-s.Addr, 16)
+}
@@ -0,0 +1,160 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultCache is the hierarchy Options.Cache "default" simulates.
+const DefaultCache = "l1=32k:8,l2=1m:16,llc=32m:16,line=64"
+
+// Cache is the simulated cache hierarchy of a run (Options.Cache), nearest
+// level first. Each thread runs its data accesses through its own
+// inclusive LRU hierarchy; a line missing from every level is read from
+// DRAM, and a dirty line the last level evicts is written back. The DRAM
+// bytes are Memory.DRAMBytesRead and DRAMBytesWritten, per function and
+// line as well; lines still dirty at exit are not counted.
+type Cache struct {
+	Line   uint64       `json:"line"`
+	Levels []CacheLevel `json:"levels"`
+}
+
+// CacheLevel is one simulated level: its geometry, and the accesses that
+// reached it and missed it.
+type CacheLevel struct {
+	Name     string `json:"name"`
+	Size     uint64 `json:"size"`
+	Ways     uint64 `json:"ways"`
+	Accesses uint64 `json:"accesses"`
+	Misses   uint64 `json:"misses"`
+}
+
+// checkCache validates Options.Cache, "default" or a comma list of
+// name=SIZE[:WAYS] levels, sizes taking a k, m or g suffix and ways
+// defaulting to 8, and line=N.
+func (o *Options) checkCache() error {
+	if o.Cache == "" {
+		return nil
+	}
+	if o.Backend != BackendPin {
+		return fmt.Errorf("%w: %s backend does not trace memory addresses", ErrUnsupported, o.Backend)
+	}
+	spec := o.Cache
+	if spec == "default" {
+		spec = DefaultCache
+	}
+	type level struct {
+		name       string
+		size, ways uint64
+	}
+	var levels []level
+	line := uint64(64)
+	for _, tok := range strings.Split(spec, ",") {
+		name, val, ok := strings.Cut(tok, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("profiler: Cache %q: %q is not name=SIZE[:WAYS] or line=N", o.Cache, tok)
+		}
+		sz, ways, hasWays := strings.Cut(val, ":")
+		size, err := parseCacheSize(sz)
+		if name == "line" {
+			if err != nil || hasWays || size == 0 || size&(size-1) != 0 {
+				return fmt.Errorf("profiler: Cache %q: line must be a power of two", o.Cache)
+			}
+			line = size
+			continue
+		}
+		w := uint64(8)
+		if hasWays && err == nil {
+			w, err = strconv.ParseUint(ways, 10, 32)
+		}
+		if err != nil || size == 0 || w == 0 {
+			return fmt.Errorf("profiler: Cache %q: bad level %q", o.Cache, tok)
+		}
+		levels = append(levels, level{name, size, w})
+	}
+	if len(levels) == 0 {
+		return fmt.Errorf("profiler: Cache %q has no levels", o.Cache)
+	}
+	for i, l := range levels {
+		if l.size%(line*l.ways) != 0 {
+			return fmt.Errorf("profiler: Cache %q: %s size is not a multiple of line × ways (%d bytes)",
+				o.Cache, l.name, line*l.ways)
+		}
+		if i > 0 && l.size < levels[i-1].size {
+			return fmt.Errorf("profiler: Cache %q: %s is smaller than %s", o.Cache, l.name, levels[i-1].name)
+		}
+	}
+	return nil
+}
+
+// parseCacheSize parses a byte count with an optional k, m or g suffix.
+func parseCacheSize(s string) (uint64, error) {
+	shift := 0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+		if shift > 0 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	return v << shift, err
+}
+
+// cacheSizeName formats a size in the largest unit dividing it: 32K, 1M.
+func cacheSizeName(size uint64) string {
+	u := 0
+	for u < 3 && size >= 1024 && size%1024 == 0 {
+		size /= 1024
+		u++
+	}
+	return strconv.FormatUint(size, 10) + strings.TrimSpace(" KMG"[u:u+1])
+}
+
+// memoryBytes returns the bytes m's intensities are counted against: those
+// from DRAM when r simulated caches, else all moved.
+func (r *Result) memoryBytes(m *Memory) uint64 {
+	if r.Cache != nil {
+		return m.DRAMBytes()
+	}
+	return m.Bytes()
+}
+
+// writeCache renders the simulated levels with their hit rates, then the
+// DRAM traffic and the intensities over it.
+func writeCache(w io.Writer, r *Result) {
+	c, m := r.Cache, r.Memory
+	if m == nil {
+		m = &Memory{}
+	}
+	fmt.Fprintf(w, "\n----- Cache simulation (%d-byte lines) -----\n", c.Line)
+	fmt.Fprintf(w, "%-8s%8s%6s%14s%14s%8s\n", "LEVEL", "SIZE", "WAYS", "ACCESSES", "MISSES", "HIT")
+	for _, l := range c.Levels {
+		hit := 0.0
+		if l.Accesses > 0 {
+			hit = 100 * float64(l.Accesses-l.Misses) / float64(l.Accesses)
+		}
+		fmt.Fprintf(w, "%-8s%8s%6d%14d%14d%8s\n", l.Name, cacheSizeName(l.Size), l.Ways,
+			l.Accesses, l.Misses, fmt.Sprintf("%.1f%%", hit))
+	}
+	fmt.Fprintf(w, "DRAM read:     %d bytes\n", m.DRAMBytesRead)
+	fmt.Fprintf(w, "DRAM written:  %d bytes\n", m.DRAMBytesWritten)
+	perDRAMByte := func(v float64) string {
+		if m.DRAMBytes() == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.4f", v)
+	}
+	fmt.Fprintf(w, "INT ops/DRAM byte: %s\n", perDRAMByte(m.IntOpsPerDRAMByte))
+	if r.FP != nil {
+		fmt.Fprintf(w, "FP ops/DRAM byte:  %s\n", perDRAMByte(m.FPOpsPerDRAMByte))
+	}
+}
@@ -0,0 +1,49 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Resumed describes the checkpoint a run resumed from (Options.Resume):
+// the counts of the runs before it, over ElapsedSec, are in the report.
+type Resumed struct {
+	From       string  `json:"from"`
+	Checkpoint uint64  `json:"checkpoint"` // checkpoints written before
+	ElapsedSec float64 `json:"elapsed_sec"`
+}
+
+func (r *Resumed) String() string {
+	return fmt.Sprintf("from %s (checkpoint %d), %.1f s carried over", r.From, r.Checkpoint, r.ElapsedSec)
+}
+
+// checkpointing reports whether counts are checkpointed or resumed.
+func (o *Options) checkpointing() bool { return o.Checkpoint != "" || o.Resume != "" }
+
+// checkCheckpoint validates the checkpoint options of a pin backend run.
+func (o *Options) checkCheckpoint() error {
+	if o.CheckpointInterval < 0 || o.CheckpointInterval != 0 && o.Checkpoint == "" {
+		return errors.New("profiler: CheckpointInterval needs Checkpoint and must not be negative")
+	}
+	if !o.checkpointing() {
+		return nil
+	}
+	if o.Sample > 0 && o.Sample < 1 || o.FollowChildren || o.warming() || o.Phases != "" {
+		return errors.New("profiler: Checkpoint and Resume exclude Sample, FollowChildren, Phases and warm-up exclusion")
+	}
+	return nil
+}
+
+// checkpointWritable reports whether the checkpoint file can be written
+// next to path, as the pintool does at start: failing there would leave
+// only Pin's output, which a run drops unless Options.Stderr is set.
+func checkpointWritable(path string) error {
+	f, err := os.OpenFile(path+".part", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("profiler: Checkpoint: %w", err)
+	}
+	f.Close()
+	os.Remove(path + ".part")
+	return nil
+}
@@ -0,0 +1,234 @@
+package profiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Classifier counts the instructions of a custom category, such as
+// CRC32 or AES rounds, next to the built-in ones; see Options.Classes and
+// Result.Custom. Each backend uses its own half: the pin backend counts
+// x86 instructions by Mnemonics, the static, qemu and hybrid backends ask
+// Match about every instruction they decode. A category an instruction
+// belongs to does not change how the built-in categories count it. The wasm
+// backend matches wasm instructions, and always counts the i32_add,
+// i32_sub, i32_mul and i32_div classes of the i32 arithmetic.
+type Classifier struct {
+	// Name is the category's name in reports: a lowercase letter
+	// followed by lowercase letters, digits and '_', and not the name of
+	// a built-in op type.
+	Name string
+	// Mnemonics are globs over Pin's x86 instruction mnemonics, matched
+	// case-insensitively: "CRC32", "AES*", "PDEP".
+	Mnemonics []string
+	// Match reports whether insn, the little-endian encoding of one
+	// instruction of arch ("amd64", "arm64", "riscv64" or "wasm", whose
+	// encoding is the opcode with its immediates), is in the category.
+	Match func(arch string, insn []byte) bool
+}
+
+var (
+	classMu     sync.RWMutex
+	classifiers = map[string]Classifier{}
+)
+
+func init() {
+	for _, c := range builtinClassifiers {
+		if err := RegisterClassifier(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterClassifier makes c available to Options.Classes under c.Name.
+// It is safe for concurrent use; a Profiler resolves its classes in New.
+func RegisterClassifier(c Classifier) error {
+	if err := checkClassifier(c); err != nil {
+		return err
+	}
+	if len(c.Mnemonics) == 0 && c.Match == nil {
+		return fmt.Errorf("profiler: classifier %s has neither Mnemonics nor Match", c.Name)
+	}
+	classMu.Lock()
+	defer classMu.Unlock()
+	if _, dup := classifiers[c.Name]; dup {
+		return fmt.Errorf("profiler: classifier %s registered twice", c.Name)
+	}
+	c.Mnemonics = append([]string(nil), c.Mnemonics...)
+	classifiers[c.Name] = c
+	return nil
+}
+
+// Classifiers returns the registered classifiers by name, the built-in
+// crc32, aes, clmul and pdep_pext included.
+func Classifiers() []Classifier {
+	classMu.RLock()
+	defer classMu.RUnlock()
+	out := make([]Classifier, 0, len(classifiers))
+	for _, c := range classifiers {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// checkClassifier rejects names that are not identifiers or that clash
+// with an op type of the CSV export, and malformed mnemonic globs.
+func checkClassifier(c Classifier) error {
+	name := c.Name
+	ok := name != "" && name[0] >= 'a' && name[0] <= 'z'
+	for _, r := range name {
+		ok = ok && (r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	}
+	if !ok {
+		return fmt.Errorf("profiler: classifier name %q: want lowercase letters, digits and '_'", name)
+	}
+	for _, c := range append(append([]string{"bitwise"}, CategoryNames...), BitCategoryNames...) {
+		if name == c {
+			return fmt.Errorf("profiler: classifier name %q is a built-in category", name)
+		}
+	}
+	for _, p := range []string{"vec_", "wide_", "fp64_", "fp32_", "mem_"} {
+		if strings.HasPrefix(name, p) {
+			return fmt.Errorf("profiler: classifier name %q: prefix %s is reserved", name, p)
+		}
+	}
+	for _, m := range c.Mnemonics {
+		if _, err := path.Match(m, ""); err != nil || m == "" {
+			return fmt.Errorf("profiler: classifier %s: bad mnemonic glob %q", name, m)
+		}
+	}
+	return nil
+}
+
+// resolveClasses looks up Options.Classes for backend: each entry names a
+// registered classifier or, as name=glob,…, defines a Mnemonics-only one.
+func resolveClasses(names []string, backend string) ([]Classifier, error) {
+	var out []Classifier
+	seen := map[string]bool{}
+	for _, n := range names {
+		var c Classifier
+		if name, globs, ok := strings.Cut(n, "="); ok {
+			c = Classifier{Name: name, Mnemonics: strings.Split(globs, ",")}
+			if err := checkClassifier(c); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := checkClassifier(Classifier{Name: n}); err != nil {
+				return nil, err
+			}
+			classMu.RLock()
+			c, ok = classifiers[n]
+			classMu.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("profiler: unknown class %q (not registered, and not name=glob,…)", n)
+			}
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("profiler: class %s given twice", c.Name)
+		}
+		seen[c.Name] = true
+		switch {
+		case backend == BackendPin && len(c.Mnemonics) == 0:
+			return nil, fmt.Errorf("%w: class %s has no x86 mnemonics for the pin backend", ErrUnsupported, c.Name)
+		case (backend == BackendStatic || backend == BackendQEMU || backend == BackendWASM || backend == BackendHybrid) && c.Match == nil:
+			return nil, fmt.Errorf("%w: class %s matches x86 mnemonics only, not %s backend instructions", ErrUnsupported, c.Name, backend)
+		}
+		out = append(out, c)
+	}
+	if backend == BackendPin && len(out) > maxPinClasses {
+		return nil, fmt.Errorf("profiler: the pintool counts at most %d classes", maxPinClasses)
+	}
+	return out, nil
+}
+
+// maxPinClasses is the pintool's MAX_CLASSES.
+const maxPinClasses = 16
+
+// classArgs returns the pintool knobs of classes.
+func classArgs(classes []Classifier) []string {
+	var args []string
+	for _, c := range classes {
+		args = append(args, "-class", c.Name+"="+strings.Join(c.Mnemonics, ","))
+	}
+	return args
+}
+
+// matchWord returns a Match for fixed 32-bit encodings: insn is in the
+// category when insn&mask equals one of its values for arch.
+func matchWord(enc map[string][][2]uint32) func(string, []byte) bool {
+	return func(arch string, insn []byte) bool {
+		if len(insn) != 4 {
+			return false
+		}
+		w := binary.LittleEndian.Uint32(insn)
+		for _, e := range enc[arch] {
+			if w&e[0] == e[1] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchX86 returns a Match for fixed 32-bit encodings, as matchWord, and
+// the x86-64 instructions x86 accepts.
+func matchX86(enc map[string][][2]uint32, x86 func(in *x86Insn) bool) func(string, []byte) bool {
+	word := matchWord(enc)
+	return func(arch string, insn []byte) bool {
+		if arch == "amd64" {
+			in, ok := decodeX86Insn(insn)
+			return ok && x86(&in)
+		}
+		return word(arch, insn)
+	}
+}
+
+// builtinClassifiers are registered at start-up.
+var builtinClassifiers = []Classifier{
+	{
+		// CRC32 and CRC32C, every operand width
+		Name:      "crc32",
+		Mnemonics: []string{"CRC32"},
+		Match: matchX86(map[string][][2]uint32{
+			"arm64": {{0x7fe0e000, 0x1ac04000}}, // CRC32{B,H,W,X}, CRC32C*
+		}, func(in *x86Insn) bool { return in.opmap == 2 && in.pp == 3 && !in.vex && in.op&0xFE == 0xF0 }),
+	},
+	{
+		// AES rounds and key schedule
+		Name:      "aes",
+		Mnemonics: []string{"AES*", "VAES*"},
+		Match: matchX86(map[string][][2]uint32{
+			"arm64": {{0xffffcc00, 0x4e284800}}, // AESE, AESD, AESMC, AESIMC
+			"riscv64": {
+				{0xfe00707f, 0x32000033}, {0xfe00707f, 0x36000033}, // aes64es, aes64esm
+				{0xfe00707f, 0x3a000033}, {0xfe00707f, 0x3e000033}, // aes64ds, aes64dsm
+				{0xfe00707f, 0x7e000033}, {0xff00707f, 0x31001013}, // aes64ks2, aes64ks1i
+				{0xfff0707f, 0x30001013}, // aes64im
+			},
+		}, func(in *x86Insn) bool {
+			return in.pp == 1 && (in.opmap == 2 && in.op >= 0xDB && in.op <= 0xDF || in.opmap == 3 && in.op == 0xDF) // AESKEYGENASSIST
+		}),
+	},
+	{
+		// carry-less multiplies
+		Name:      "clmul",
+		Mnemonics: []string{"PCLMULQDQ", "VPCLMULQDQ"},
+		Match: matchX86(map[string][][2]uint32{
+			"arm64": {{0xbf20fc00, 0x0e20e000}}, // PMULL, PMULL2
+			"riscv64": {
+				{0xfe00707f, 0x0a001033}, {0xfe00707f, 0x0a002033}, {0xfe00707f, 0x0a003033}, // clmul, clmulr, clmulh
+			},
+		}, func(in *x86Insn) bool { return in.opmap == 3 && in.pp == 1 && in.op == 0x44 }),
+	},
+	{
+		// BMI2 bit deposit and extract; there are no A64 or RV64 equivalents
+		Name:      "pdep_pext",
+		Mnemonics: []string{"PDEP", "PEXT"},
+		Match:     matchX86(nil, func(in *x86Insn) bool { return in.vex && in.opmap == 2 && in.pp >= 2 && in.op == 0xF5 }),
+	},
+}
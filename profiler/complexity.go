@@ -0,0 +1,223 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ComplexityModels are the f(n) of SweepFit, simplest first.
+var ComplexityModels = []string{"1", "log n", "n", "n log n", "n^2", "n^3"}
+
+var complexityFuncs = map[string]func(float64) float64{
+	"log n":   math.Log,
+	"n":       func(n float64) float64 { return n },
+	"n log n": func(n float64) float64 { return n * math.Log(n) },
+	"n^2":     func(n float64) float64 { return n * n },
+	"n^3":     func(n float64) float64 { return n * n * n },
+}
+
+// complexityMinShare is the share of a point's int ops a function needs,
+// at one point at least, to be fitted: below it the counts are noise.
+const complexityMinShare = 0.01
+
+// SweepFit is the complexity model that best fits how the int ops of a
+// run, or of one of its functions, grow with Param over one series of
+// points, those with the other parameters at At (name=value). A and B
+// are the least-squares a and b of count ≈ a·f(n) + b, f one of
+// ComplexityModels, and R2 the coefficient of determination; R2ByModel
+// has every candidate's but the ones fitting with a negative a. Model
+// is "1" when the counts vary by under 1%.
+type SweepFit struct {
+	Param     string             `json:"param"`
+	At        []string           `json:"at,omitempty"`
+	Function  string             `json:"function,omitempty"` // empty for the whole run
+	Image     string             `json:"image,omitempty"`
+	Model     string             `json:"model"`
+	A         float64            `json:"a"`
+	B         float64            `json:"b"`
+	R2        float64            `json:"r2"`
+	R2ByModel map[string]float64 `json:"r2_by_model,omitempty"`
+}
+
+// fitModels fills in s.Fits from the results of its points: for each
+// numeric parameter and each series of three points or more, the whole
+// run and every function with complexityMinShare of a point's int ops.
+func (s *Sweep) fitModels(results []*Result) {
+	type key struct{ name, image string }
+	var funcs []key
+	weight := map[key]uint64{}
+	fitted := map[key]bool{}
+	counts := make([]map[key]uint64, len(results))
+	for i, r := range results {
+		if r == nil {
+			continue
+		}
+		counts[i] = map[key]uint64{}
+		for _, f := range r.Functions {
+			k := key{f.Name, f.Image}
+			if _, ok := weight[k]; !ok {
+				funcs = append(funcs, k)
+			}
+			counts[i][k] += f.Sum()
+			weight[k] += f.Sum()
+			if t := r.Totals.Sum(); t > 0 && float64(f.Sum()) >= complexityMinShare*float64(t) {
+				fitted[k] = true
+			}
+		}
+	}
+	sort.SliceStable(funcs, func(i, j int) bool { return weight[funcs[i]] > weight[funcs[j]] })
+	subjects := []*key{nil} // nil: the whole run
+	for i := range funcs {
+		if fitted[funcs[i]] {
+			subjects = append(subjects, &funcs[i])
+		}
+	}
+	count := func(i int, k *key) float64 {
+		if k == nil {
+			return float64(results[i].Totals.Sum())
+		}
+		return float64(counts[i][*k])
+	}
+
+	for p, param := range s.Params {
+		x, ok := s.numeric(p)
+		if !ok {
+			continue
+		}
+		for _, series := range s.series(p) {
+			var pts []int
+			for _, i := range series {
+				if results[i] != nil {
+					pts = append(pts, i)
+				}
+			}
+			if len(pts) < 3 {
+				continue
+			}
+			var at []string
+			names := s.others(p, sweepNames(s.Params))
+			for j, v := range s.others(p, s.Points[pts[0]].Values) {
+				at = append(at, names[j]+"="+v)
+			}
+			for _, k := range subjects {
+				xs := make([]float64, len(pts))
+				ys := make([]float64, len(pts))
+				for j, i := range pts {
+					xs[j], ys[j] = x[i], count(i, k)
+				}
+				fit, ok := fitComplexity(xs, ys)
+				if !ok {
+					continue
+				}
+				fit.Param, fit.At = param.Name, at
+				if k != nil {
+					fit.Function, fit.Image = k.name, k.image
+				}
+				s.Fits = append(s.Fits, fit)
+			}
+		}
+	}
+}
+
+// sweepNames returns the names of params.
+func sweepNames(params []SweepParam) []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// fitComplexity fits ys over xs to each of ComplexityModels and keeps
+// the best; false when ys are all zero.
+func fitComplexity(xs, ys []float64) (SweepFit, bool) {
+	lo, hi, mean := math.Inf(1), math.Inf(-1), 0.0
+	for _, y := range ys {
+		lo, hi = math.Min(lo, y), math.Max(hi, y)
+		mean += y
+	}
+	mean /= float64(len(ys))
+	if mean == 0 {
+		return SweepFit{}, false
+	}
+	if (hi-lo)/mean < 0.01 {
+		return SweepFit{Model: "1", B: mean, R2: 1}, true
+	}
+	best := SweepFit{Model: "1", B: mean, R2ByModel: map[string]float64{"1": 0}}
+	for _, m := range ComplexityModels[1:] {
+		gs := make([]float64, len(xs))
+		for i, x := range xs {
+			gs[i] = complexityFuncs[m](x)
+		}
+		a, b, r2, ok := linearFit(gs, ys)
+		if !ok || a < 0 {
+			continue
+		}
+		best.R2ByModel[m] = r2
+		if r2 > best.R2 {
+			best.Model, best.A, best.B, best.R2 = m, a, b, r2
+		}
+	}
+	return best, true
+}
+
+// linearFit returns the least-squares a and b of y ≈ a·x + b and its
+// R²; false when the xs are all the same.
+func linearFit(xs, ys []float64) (a, b, r2 float64, ok bool) {
+	n := float64(len(xs))
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	var sxy, sxx, syy float64
+	for i := range xs {
+		sxy += (xs[i] - mx) * (ys[i] - my)
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+		syy += (ys[i] - my) * (ys[i] - my)
+	}
+	if sxx == 0 || syy == 0 {
+		return 0, 0, 0, false
+	}
+	a = sxy / sxx
+	b = my - a*mx
+	var res float64
+	for i := range xs {
+		d := ys[i] - (a*xs[i] + b)
+		res += d * d
+	}
+	return a, b, 1 - res/syy, true
+}
+
+// writeFits renders a row per fit with the runner-up model.
+func (s *Sweep) writeFits(w io.Writer) {
+	fmt.Fprintf(w, "\n----- Complexity of the int ops (count ≈ a·f(param) + b) -----\n")
+	fmt.Fprintf(w, "  %-10s %-14s %-8s %8s %14s %14s  %-16s %s\n", "PARAM", "AT", "MODEL", "R2", "A", "B", "RUNNER-UP", "FUNCTION")
+	for _, f := range s.Fits {
+		at := strings.Join(f.At, ",")
+		if at == "" {
+			at = "-"
+		}
+		next, nextR2 := "", math.Inf(-1)
+		for _, m := range ComplexityModels {
+			if r2, ok := f.R2ByModel[m]; ok && m != f.Model && r2 > nextR2 {
+				next, nextR2 = m, r2
+			}
+		}
+		if next != "" {
+			next = fmt.Sprintf("%s %.4f", next, nextR2)
+		} else {
+			next = "-"
+		}
+		name := f.Function
+		if name == "" {
+			name = "(whole run)"
+		}
+		fmt.Fprintf(w, "  %-10s %-14s %-8s %8.4f %14.4g %14.4g  %-16s %s\n", f.Param, at, f.Model, f.R2, f.A, f.B, next, name)
+	}
+}
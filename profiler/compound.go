@@ -0,0 +1,126 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compound-instruction policies.
+const (
+	CompoundFused = "fused" // one op of its kind: an FMA is an fma, MADD a mul
+	CompoundSplit = "split" // its constituent ops: an FMA is a mul and an add
+	CompoundBoth  = "both"  // fused, with the constituent ops in Result.Compound
+)
+
+// Compound records how a run counted compound instructions
+// (Options.Compound) and, unless fused, how many of each kind ran: lanes
+// of the fused multiply-adds and AVX-512 IFMA multiply-adds, the A64
+// integer multiply-adds (MADD, [SU]MADDL) and the x86 LEAs that add two
+// registers, LEAScaled of them also shifting the index. Fused, a LEA is
+// address arithmetic and not counted; split, it is an add (and a shl).
+// Reports without it, and those of older tools, are fused.
+type Compound struct {
+	Policy    string `json:"policy"`
+	FP64FMA   uint64 `json:"fp64_fma,omitempty"`
+	FP32FMA   uint64 `json:"fp32_fma,omitempty"`
+	VecMulAdd uint64 `json:"vec_muladd,omitempty"`
+	MulAdd    uint64 `json:"muladd,omitempty"`
+	LEA       uint64 `json:"lea,omitempty"`
+	LEAScaled uint64 `json:"lea_scaled,omitempty"`
+}
+
+// CompoundPolicy returns the policy r was counted with.
+func (r *Result) CompoundPolicy() string {
+	if r.Compound == nil || r.Compound.Policy == "" {
+		return CompoundFused
+	}
+	return r.Compound.Policy
+}
+
+// compoundPolicy returns the policy the options select.
+func (o *Options) compoundPolicy() string {
+	if o.Compound == "" {
+		return CompoundFused
+	}
+	return o.Compound
+}
+
+// checkCompound validates Options.Compound.
+func (o *Options) checkCompound() error {
+	switch o.Compound {
+	case "", CompoundFused, CompoundSplit, CompoundBoth:
+	default:
+		return fmt.Errorf("profiler: Compound %q is not %s, %s or %s", o.Compound,
+			CompoundFused, CompoundSplit, CompoundBoth)
+	}
+	if o.Compound != "" && (o.Backend == BackendPerf || o.Backend == BackendEBPF) {
+		return fmt.Errorf("%w: %s backend counts come from the hardware; an FMA is two operations",
+			ErrUnsupported, o.Backend)
+	}
+	return nil
+}
+
+// tally adds n occurrences of the decoded op to c's kinds, unless fused,
+// and returns its constituent ops if op is compound.
+func (c *Compound) tally(op staticOp, n uint64) (split []staticOp) {
+	var kind *uint64
+	switch {
+	case strings.HasSuffix(op.category, "_fma"):
+		prec := strings.TrimSuffix(op.category, "_fma")
+		kind = &c.FP32FMA
+		if prec == "fp64" {
+			kind = &c.FP64FMA
+		}
+		split = []staticOp{{prec + "_mul", op.insn, op.lanes}, {prec + "_add", op.insn, op.lanes}}
+	case op.insn == "madd" || op.insn == "maddl":
+		kind = &c.MulAdd
+		split = []staticOp{op, {"add", op.insn, 1}}
+	case op.insn == "pmadd52":
+		kind = &c.VecMulAdd
+		split = []staticOp{op, {"vec_add", op.insn, op.lanes}}
+	case isLEA(op):
+		if op.insn == "lea_scaled" && c.Policy != CompoundFused {
+			c.LEAScaled += n
+		}
+		kind = &c.LEA
+		split = []staticOp{{"add", "lea", 1}}
+		if op.insn == "lea_scaled" {
+			split = append(split, staticOp{"shl", "shl", 1})
+		}
+	default:
+		return nil
+	}
+	if c.Policy != CompoundFused {
+		*kind += op.lanes * n
+	}
+	return split
+}
+
+// isLEA reports whether op is an x86 LEA adding two registers.
+func isLEA(op staticOp) bool { return op.insn == "lea" || op.insn == "lea_scaled" }
+
+// writeCompound renders the compound instructions of a run not counted
+// fused, with the ops each kind splits into.
+func writeCompound(w io.Writer, r *Result) {
+	c := r.Compound
+	fmt.Fprintf(w, "\n----- Compound instructions (%s) -----\n", c.Policy)
+	fmt.Fprintf(w, "%-12s%14s  SPLIT INTO\n", "KIND", "COUNT")
+	row := func(kind string, n uint64, as string) {
+		fmt.Fprintf(w, "%-12s%14d  %s\n", kind, n, as)
+	}
+	if r.FP != nil {
+		row("fp64_fma", c.FP64FMA, "fp64 mul + fp64 add")
+		row("fp32_fma", c.FP32FMA, "fp32 mul + fp32 add")
+	}
+	switch {
+	case r.Backend == "" || r.Backend == BackendPin || r.Arch == "amd64":
+		if r.Vector != nil {
+			row("vec_muladd", c.VecMulAdd, "vec mul + vec add")
+		}
+		row("lea", c.LEA, "add")
+		row("lea_scaled", c.LEAScaled, "shl (also in lea)")
+	case r.Arch == "arm64":
+		row("muladd", c.MulAdd, "mul + add")
+	}
+}
@@ -0,0 +1,357 @@
+package profiler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrContainerNotFound means no running container matches a reference.
+var ErrContainerNotFound = errors.New("profiler: container not found")
+
+// Container describes the Docker, Podman or Kubernetes container of an
+// attached process. Only Runtime and ID are always known; the others are
+// read from the container runtime's state and the container's filesystem
+// when they are readable.
+type Container struct {
+	Runtime   string `json:"runtime"` // docker, podman, containerd, cri-o or cri (unnamed Kubernetes runtime)
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	PodUID    string `json:"pod_uid,omitempty"`
+}
+
+// String names c for report headers: runtime, short ID and name or pod.
+func (c *Container) String() string {
+	s := c.Runtime + " " + shortID(c.ID)
+	switch {
+	case c.Pod != "" && c.Namespace != "":
+		s += fmt.Sprintf(" (pod %s/%s)", c.Namespace, c.Pod)
+	case c.Pod != "":
+		s += fmt.Sprintf(" (pod %s)", c.Pod)
+	case c.Name != "":
+		s += fmt.Sprintf(" (%s)", c.Name)
+	}
+	if c.Image != "" {
+		s += ", image " + c.Image
+	}
+	return s
+}
+
+func shortID(id string) string { return id[:min(len(id), 12)] }
+
+// dockerState is where dockerd keeps each container's config.v2.json.
+var dockerState = "/var/lib/docker/containers"
+
+// cgroupRuntimes maps the prefixes of systemd scope names, and the parent
+// directories of cgroupfs paths, to the runtime that names them.
+var cgroupRuntimes = map[string]string{
+	"docker":         "docker",
+	"libpod":         "podman",
+	"cri-containerd": "containerd",
+	"crio":           "cri-o",
+}
+
+// parseCgroup returns the container of a process from the contents of its
+// /proc/<pid>/cgroup, seen from the host: the innermost 64-hex-digit
+// container ID on any hierarchy's path, named as
+// docker-<id>.scope (systemd) or /docker/<id> (cgroupfs), and likewise for
+// the other runtimes. A pod<uid> component marks a Kubernetes pod.
+func parseCgroup(data string) *Container {
+	var c *Container
+	for _, line := range strings.Split(data, "\n") {
+		_, path, ok := strings.Cut(line, "::")
+		if !ok {
+			parts := strings.SplitN(line, ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			path = parts[2]
+		}
+		var ctr Container
+		parent := ""
+		for _, seg := range strings.Split(path, "/") {
+			seg = strings.TrimSuffix(strings.TrimSuffix(seg, ".scope"), ".slice")
+			// kubepods-burstable-pod<uid with _> (systemd) or pod<uid>
+			if i := strings.LastIndex(seg, "pod"); i >= 0 && (i == 0 || seg[i-1] == '-') && len(seg)-i-3 == 36 {
+				ctr.PodUID = strings.ReplaceAll(seg[i+3:], "_", "-")
+			}
+			id, rt := seg, cgroupRuntimes[parent]
+			for pre, name := range cgroupRuntimes {
+				if rest, ok := strings.CutPrefix(seg, pre+"-"); ok {
+					id, rt = rest, name
+				}
+			}
+			if isContainerID(id) {
+				if rt == "" {
+					rt = "cri" // a bare ID under kubepods
+				}
+				ctr.Runtime, ctr.ID = rt, id
+			}
+			parent = seg
+		}
+		if ctr.ID != "" {
+			c = &ctr
+		}
+	}
+	return c
+}
+
+func isContainerID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// containerOf returns the container process pid runs in, with whatever
+// metadata is readable, or nil if it runs in none.
+func containerOf(pid int) *Container {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil
+	}
+	c := parseCgroup(string(data))
+	if c == nil {
+		return nil
+	}
+	c.describe(pid)
+	return c
+}
+
+// describe fills in c's name and image from dockerd's state, and a pod's
+// name and namespace from the files the kubelet mounts into each of its
+// containers: the pod name is the container's hostname.
+func (c *Container) describe(pid int) {
+	if c.Runtime == "docker" {
+		var cfg struct {
+			Name   string
+			Config struct{ Image string }
+		}
+		if data, err := os.ReadFile(filepath.Join(dockerState, c.ID, "config.v2.json")); err == nil && json.Unmarshal(data, &cfg) == nil {
+			c.Name, c.Image = strings.TrimPrefix(cfg.Name, "/"), cfg.Config.Image
+		}
+	}
+	if c.PodUID == "" {
+		return
+	}
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	if b, err := os.ReadFile(root + "/etc/hostname"); err == nil {
+		c.Pod = strings.TrimSpace(string(b))
+	}
+	// the token mount is under /var/run, usually a symlink to /run that
+	// would resolve against this process's root, not the container's
+	for _, dir := range []string{"/run", "/var/run"} {
+		if b, err := os.ReadFile(root + dir + "/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+			c.Namespace = strings.TrimSpace(string(b))
+			break
+		}
+	}
+}
+
+// FindContainer returns the first process of the running container ref
+// names, the one whose parent is outside it, and the container. ref is a
+// container ID or a unique prefix of one, a Docker container name, or a
+// Kubernetes pod as pod/NAME or pod/NAMESPACE/NAME; a pod must have a
+// single container besides its pause container. Linux only.
+func FindContainer(ref string) (int, *Container, error) {
+	if runtime.GOOS != "linux" {
+		return 0, nil, fmt.Errorf("%w: containers are Linux-only", ErrUnsupported)
+	}
+	if ref == "" || ref == "pod/" {
+		return 0, nil, fmt.Errorf("%w: empty reference", ErrContainerNotFound)
+	}
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, nil, fmt.Errorf("profiler: %w", err)
+	}
+	type member struct{ pid, ppid int }
+	members := map[string][]member{}
+	ctrs := map[string]*Container{}
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			continue
+		}
+		c := parseCgroup(string(data))
+		if c == nil {
+			continue
+		}
+		if ctrs[c.ID] == nil {
+			ctrs[c.ID] = c
+		}
+		members[c.ID] = append(members[c.ID], member{pid, parentPID(pid)})
+	}
+	// a container's first process: the lowest PID whose parent is not in it
+	first := func(id string) int {
+		in := map[int]bool{}
+		for _, m := range members[id] {
+			in[m.pid] = true
+		}
+		best := 0
+		for _, m := range members[id] {
+			if !in[m.ppid] && (best == 0 || m.pid < best) {
+				best = m.pid
+			}
+		}
+		return best
+	}
+
+	var ids []string
+	pod, isPod := strings.CutPrefix(ref, "pod/")
+	ns, name, hasNS := strings.Cut(pod, "/")
+	if !hasNS {
+		ns, name = "", pod
+	}
+	for id, c := range ctrs {
+		pid := first(id)
+		if pid == 0 {
+			continue
+		}
+		switch {
+		case isPod:
+			if c.PodUID == "" {
+				continue
+			}
+			if comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); strings.TrimSpace(string(comm)) == "pause" {
+				continue
+			}
+			c.describe(pid)
+			if c.Pod != name || hasNS && c.Namespace != ns {
+				continue
+			}
+		case strings.HasPrefix(id, ref):
+			c.describe(pid)
+		default:
+			c.describe(pid)
+			if c.Name != ref {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	switch len(ids) {
+	case 0:
+		return 0, nil, fmt.Errorf("%w: %s", ErrContainerNotFound, ref)
+	case 1:
+		return first(ids[0]), ctrs[ids[0]], nil
+	}
+	sort.Strings(ids)
+	for i, id := range ids {
+		ids[i] = shortID(id)
+	}
+	return 0, nil, fmt.Errorf("profiler: %s matches containers %s; name one by ID", ref, strings.Join(ids, ", "))
+}
+
+// parentPID returns the parent of process pid from /proc/<pid>/stat, or 0.
+func parentPID(pid int) int {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// pid (comm) state ppid …; comm may contain spaces and parentheses
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// foreignMounts reports whether process pid sees another filesystem than
+// this one: it runs in a different mount namespace, as container
+// processes do. Paths it uses are then reachable as /proc/<pid>/root/….
+func foreignMounts(pid int) bool {
+	self, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return false
+	}
+	other, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	return err == nil && other != self
+}
+
+// hostPath returns the path name, as process pid sees it, through which
+// this process reaches the same file.
+func hostPath(pid int, name string) string {
+	if !foreignMounts(pid) {
+		return name
+	}
+	return filepath.Join(fmt.Sprintf("/proc/%d/root", pid), name)
+}
+
+// attachDir creates the directory an attached tool writes its files to.
+// The tool runs inside the target and opens paths in its mount namespace,
+// so for a process in a container the directory is made in the
+// container's /tmp. It returns the directory as this process reaches it,
+// through a descriptor that outlives the container, and as the tool names
+// it, and a cleanup function.
+func attachDir(pid int) (local, target string, cleanup func(), err error) {
+	if !foreignMounts(pid) {
+		dir, err := os.MkdirTemp("", "int64profiler-")
+		if err != nil {
+			return "", "", nil, fmt.Errorf("profiler: %w", err)
+		}
+		return dir, dir, func() { os.RemoveAll(dir) }, nil
+	}
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	dir, err := os.MkdirTemp(root+"/tmp", "int64profiler-")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("profiler: in the container of %d: %w", pid, err)
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return "", "", nil, fmt.Errorf("profiler: %w", err)
+	}
+	local = fmt.Sprintf("/proc/self/fd/%d", d.Fd())
+	cleanup = func() {
+		if ents, err := os.ReadDir(local); err == nil {
+			for _, e := range ents {
+				os.Remove(filepath.Join(local, e.Name()))
+			}
+		}
+		os.Remove(dir)
+		d.Close()
+	}
+	return local, strings.TrimPrefix(dir, root), cleanup, nil
+}
+
+// checkPinVisible returns an error unless the Pin kit files the attached
+// tool loads exist at the same paths inside the container of pid.
+func (p *Profiler) checkPinVisible(pid int, c *Container) error {
+	if !foreignMounts(pid) {
+		return nil
+	}
+	for _, f := range []string{p.opts.PinHome, p.opts.Tool} {
+		if _, err := os.Stat(hostPath(pid, f)); err != nil {
+			name := "the process"
+			if c != nil {
+				name = "container " + shortID(c.ID)
+			}
+			return fmt.Errorf("%w: %s is not visible inside %s; mount the kit at the same path (docker run -v %s:%s:ro …)",
+				ErrPinNotFound, f, name, p.opts.PinHome, p.opts.PinHome)
+		}
+	}
+	return nil
+}
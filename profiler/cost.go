@@ -0,0 +1,301 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CostModel prices operations to estimate what a workload costs on some
+// target, in one or more metrics (cycles, energy, area-weighted work, …).
+// Costs maps an op type, named as in the CSV columns ("mul", "vec_add",
+// "fp64_fma", "mem_bytes_read", …), to its cost per operation in each
+// metric. Wide ops may be priced per operand width with a ":bits" suffix
+// ("wide_mul:256"), falling back to the unsuffixed key. A metric a cost
+// leaves out counts as zero.
+type CostModel struct {
+	Name    string                        `json:"name,omitempty"`
+	Metrics []string                      `json:"metrics,omitempty"` // report order; default: sorted
+	Costs   map[string]map[string]float64 `json:"costs"`
+}
+
+// costOpTypes lists the op types a cost model may price: the built-in
+// ones and the registered classifiers.
+func costOpTypes() []string {
+	ops := append(append([]string{}, CategoryNames...), BitCategoryNames...)
+	ops = append(ops, "vec_add", "vec_sub", "vec_mul", "wide_add", "wide_sub", "wide_mul")
+	for _, p := range []string{"fp64", "fp32"} {
+		ops = append(ops, p+"_add", p+"_sub", p+"_mul", p+"_div", p+"_fma")
+	}
+	ops = append(ops, "mem_loads", "mem_stores", "mem_bytes_read", "mem_bytes_written",
+		"mem_dram_bytes_read", "mem_dram_bytes_written")
+	for _, c := range Classifiers() {
+		ops = append(ops, c.Name)
+	}
+	return ops
+}
+
+// LoadCostModel reads a JSON cost model.
+func LoadCostModel(path string) (*CostModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	m, err := decodeCostModel(f)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// DecodeCostModel reads a JSON cost model from r.
+func DecodeCostModel(r io.Reader) (*CostModel, error) {
+	m, err := decodeCostModel(r)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: cost model: %w", err)
+	}
+	return m, nil
+}
+
+func decodeCostModel(r io.Reader) (*CostModel, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var m CostModel
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks that every key names a known op type and metric and
+// every cost is finite and non-negative. Without Metrics it sets them to
+// the metric names used, sorted.
+func (m *CostModel) Validate() error {
+	known := map[string]bool{}
+	for _, op := range costOpTypes() {
+		known[op] = true
+	}
+	metrics := map[string]bool{}
+	for _, name := range m.Metrics {
+		metrics[name] = true
+	}
+	var used []string
+	for op, costs := range m.Costs {
+		base, bits, wide := strings.Cut(op, ":")
+		if !known[base] {
+			return fmt.Errorf("unknown op type %q", op)
+		}
+		if wide {
+			n, err := strconv.Atoi(bits)
+			if !strings.HasPrefix(base, "wide_") || err != nil || n < 128 || n > 512 || n%64 != 0 {
+				return fmt.Errorf("invalid width in %q (wide_add…wide_mul take :128 to :512 in steps of 64)", op)
+			}
+		}
+		for name, c := range costs {
+			if len(m.Metrics) > 0 && !metrics[name] {
+				return fmt.Errorf("%s: metric %q not in metrics", op, name)
+			}
+			if math.IsNaN(c) || math.IsInf(c, 0) || c < 0 {
+				return fmt.Errorf("%s: invalid %s cost %g", op, name, c)
+			}
+			if !metrics[name] {
+				metrics[name] = true
+				used = append(used, name)
+			}
+		}
+	}
+	if len(m.Metrics) == 0 {
+		sort.Strings(used)
+		m.Metrics = used
+	}
+	if len(m.Metrics) == 0 {
+		return errors.New("cost model prices nothing")
+	}
+	return nil
+}
+
+// Costs holds one scope's estimate, keyed by metric.
+type Costs map[string]float64
+
+// OpCost is the estimate for one op type over the whole run.
+type OpCost struct {
+	Op    string `json:"op"`
+	Count uint64 `json:"count"`
+	Costs Costs  `json:"costs"`
+}
+
+// FuncCost is the estimate for one function.
+type FuncCost struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Costs Costs  `json:"costs"`
+}
+
+// CostReport is a run priced by a CostModel.
+type CostReport struct {
+	Model     string     `json:"model,omitempty"`
+	Binary    string     `json:"binary"`
+	Metrics   []string   `json:"metrics"`
+	Total     Costs      `json:"total"`
+	Ops       []OpCost   `json:"ops"`                 // priced op types the run counted
+	Functions []FuncCost `json:"functions,omitempty"` // present with Options.Funcs
+	Unpriced  []string   `json:"unpriced,omitempty"`  // op types counted but not in the model
+}
+
+// Cost prices r with m. Breakdown rows carry no width split, so their
+// wide ops are priced at the run's average cost for that kind.
+func (r *Result) Cost(m *CostModel) (*CostReport, error) {
+	if r.Perf != nil {
+		return nil, fmt.Errorf("%w: cost models price pintool or static counts, not perf events", ErrUnsupported)
+	}
+	rep := &CostReport{Model: m.Name, Binary: r.Binary.Path, Metrics: m.Metrics, Total: Costs{}}
+	ops, tv := r.csvOps(), r.totalValues()
+
+	// unit[i] is op i's cost per operation, nil when unpriced
+	unit := make([]Costs, len(ops))
+	for i, op := range ops {
+		unit[i] = r.unitCost(m, op)
+		if unit[i] == nil {
+			if tv[i] > 0 {
+				rep.Unpriced = append(rep.Unpriced, op)
+			}
+			continue
+		}
+		oc := OpCost{Op: op, Count: tv[i], Costs: Costs{}}
+		for _, name := range m.Metrics {
+			oc.Costs[name] = float64(tv[i]) * unit[i][name]
+			rep.Total[name] += oc.Costs[name]
+		}
+		rep.Ops = append(rep.Ops, oc)
+	}
+
+	for _, f := range r.Functions {
+		fv := r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom)
+		fc := FuncCost{Name: f.Name, Image: f.Image, Costs: Costs{}}
+		var sum float64
+		for i := range ops {
+			for _, name := range m.Metrics {
+				if unit[i] != nil {
+					fc.Costs[name] += float64(fv[i]) * unit[i][name]
+					sum += fc.Costs[name]
+				}
+			}
+		}
+		if sum > 0 {
+			rep.Functions = append(rep.Functions, fc)
+		}
+	}
+	first := m.Metrics[0]
+	sort.SliceStable(rep.Functions, func(i, j int) bool {
+		return rep.Functions[i].Costs[first] > rep.Functions[j].Costs[first]
+	})
+	return rep, nil
+}
+
+// unitCost returns the cost of one operation of type op in every metric,
+// or nil when m does not price it. Wide ops average their per-width costs
+// over the run's width mix.
+func (r *Result) unitCost(m *CostModel, op string) Costs {
+	if kind, ok := strings.CutPrefix(op, "wide_"); ok && r.Wide != nil {
+		widths := map[string]map[int]uint64{"add": r.Wide.Add, "sub": r.Wide.Sub, "mul": r.Wide.Mul}[kind]
+		sum, priced := Costs{}, false
+		var n uint64
+		for bits, count := range widths {
+			c, ok := m.Costs[fmt.Sprintf("%s:%d", op, bits)]
+			if !ok {
+				c, ok = m.Costs[op]
+			}
+			if !ok {
+				if count > 0 {
+					return nil // some widths unpriced: report the kind as unpriced
+				}
+				continue
+			}
+			priced = true
+			n += count
+			for _, name := range m.Metrics {
+				sum[name] += float64(count) * c[name]
+			}
+		}
+		if !priced {
+			return nil
+		}
+		if n == 0 {
+			return Costs(m.Costs[op])
+		}
+		for name := range sum {
+			sum[name] /= float64(n)
+		}
+		return sum
+	}
+	if c, ok := m.Costs[op]; ok {
+		return Costs(c)
+	}
+	return nil
+}
+
+// WriteText renders the estimate per op type and per function.
+func (c *CostReport) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	model := c.Model
+	if model == "" {
+		model = "(unnamed)"
+	}
+	fmt.Fprintf(bw, "Cost model %s: %s\n%-20s%16s", model, c.Binary, "OP", "COUNT")
+	writeMetricHeaders(bw, c.Metrics)
+	fmt.Fprintln(bw)
+	for _, o := range c.Ops {
+		fmt.Fprintf(bw, "%-20s%16d", o.Op, o.Count)
+		writeCosts(bw, c.Metrics, o.Costs)
+		fmt.Fprintln(bw)
+	}
+	fmt.Fprintf(bw, "%-20s%16s", "TOTAL", "")
+	writeCosts(bw, c.Metrics, c.Total)
+	fmt.Fprintln(bw)
+	if len(c.Unpriced) > 0 {
+		fmt.Fprintf(bw, "not priced: %s\n", strings.Join(c.Unpriced, ", "))
+	}
+
+	if len(c.Functions) > 0 {
+		fmt.Fprintf(bw, "\n----- Per-function cost -----\n")
+		writeMetricHeaders(bw, c.Metrics)
+		fmt.Fprintf(bw, "%8s  FUNCTION\n", "SHARE")
+		first := c.Metrics[0]
+		for _, f := range c.Functions {
+			writeCosts(bw, c.Metrics, f.Costs)
+			share := "-"
+			if t := c.Total[first]; t > 0 {
+				share = fmt.Sprintf("%.1f%%", 100*f.Costs[first]/t)
+			}
+			fmt.Fprintf(bw, "%8s  %s", share, f.Name)
+			if f.Image != "" {
+				fmt.Fprintf(bw, "  [%s]", f.Image)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	return bw.Flush()
+}
+
+func writeMetricHeaders(w io.Writer, metrics []string) {
+	for _, name := range metrics {
+		fmt.Fprintf(w, "%18s", strings.ToUpper(name))
+	}
+}
+
+func writeCosts(w io.Writer, metrics []string, c Costs) {
+	for _, name := range metrics {
+		fmt.Fprintf(w, "%18.1f", c[name])
+	}
+}
@@ -0,0 +1,280 @@
+package profiler
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Layouts accepted by WriteCSV.
+const (
+	LayoutLong = "long" // one row per count
+	LayoutWide = "wide" // one row per function, one column per op type
+)
+
+// csvInstructions lists the categories' instructions in report order.
+var csvInstructions = [][2]string{
+	{"add", "add"}, {"add", "adc"}, {"add", "adcx"}, {"add", "adox"},
+	{"sub", "sub"}, {"sub", "sbb"},
+	{"mul", "mul"}, {"mul", "mulx"},
+	{"div", "div"},
+	// LEAs split out by CompoundSplit, and address arithmetic by AgenFold
+	{"add", "lea"}, {"add", "agen"},
+	// A64 and RV64 names, see a64Insns and rv64Insns
+	{"sub", "sbc"}, {"mul", "madd"}, {"mul", "maddl"}, {"mul", "mull"}, {"mul", "mulh"},
+	{"div", "udiv"}, {"div", "sdiv"}, {"div", "rem"},
+}
+
+// WriteCSV renders r in the pintool's CSV layout; comma is ',' for CSV
+// or '\t' for TSV. The long layout has the columns
+//
+//	scope,function,image,file,line,category,instruction,form,count
+//
+// with scope "total" (one row per op type), "instruction" (one row per
+// instruction and operand form) and "function" (one row per function and
+// op type). The wide layout has function,image,file,line followed by one
+// column per op type and one row per function. With names NamesBoth
+// (Result.SetNames) a mangled column, the function's symbol, follows
+// function in both layouts. With a Top (Result.SetTop) the long layout
+// has scope "top" rows instead of "function" ones, one per row of each
+// of its tables, and share,cumulative columns after count; the wide
+// layout has a row per function in any of its tables, the most int ops
+// first, then one TopOther row summing the rest, with the int ops'
+// share,cumulative after the op types. Op types are add..div, the
+// selected Ops, then vec_*, wide_*, fp64_*, fp32_* and mem_* when
+// present, then the custom categories by name; the columns depend only on
+// the options the run used.
+func (r *Result) WriteCSV(w io.Writer, comma rune, layout string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	ops := r.csvOps()
+
+	if layout == LayoutWide {
+		if r.Top != nil {
+			return r.writeTopWide(cw, ops)
+		}
+		cw.Write(append(r.csvFuncHeader(), ops...))
+		for _, f := range r.Functions {
+			row := r.csvFunc(f)
+			for _, v := range r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom) {
+				row = append(row, strconv.FormatUint(v, 10))
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	// write pads the rows to the top rows' share,cumulative
+	write := func(row []string) {
+		if r.Top != nil {
+			row = append(row, "", "")
+		}
+		cw.Write(row)
+	}
+	header := append(append([]string{"scope"}, r.csvFuncHeader()...), "category", "instruction", "form", "count")
+	if r.Top != nil {
+		header = append(header, "share", "cumulative")
+	}
+	cw.Write(header)
+	blank := make([]string, len(r.csvFuncHeader()))
+	tv := r.totalValues()
+	for i, op := range ops {
+		write(append(append([]string{"total"}, blank...), op, "", "", strconv.FormatUint(tv[i], 10)))
+	}
+
+	insns := append([][2]string{}, csvInstructions...)
+	for _, c := range r.Ops() {
+		insns = append(insns, [2]string{c, c}, [2]string{c, "agen"})
+	}
+	for _, in := range insns {
+		v, ok := r.Categories[in[0]][in[1]]
+		if !ok {
+			continue
+		}
+		write(append(append([]string{"instruction"}, blank...), in[0], in[1], "rr", strconv.FormatUint(v.RR, 10)))
+		write(append(append([]string{"instruction"}, blank...), in[0], in[1], "rm", strconv.FormatUint(v.RM, 10)))
+	}
+
+	if t := r.Top; t != nil {
+		for _, c := range t.Categories {
+			for _, tr := range c.Rows {
+				row := append([]string{"top"}, r.csvFunc(Function{Name: tr.Function, Image: tr.Image, File: tr.File, Line: tr.Line})...)
+				cw.Write(append(row, c.Category, "", "", strconv.FormatUint(tr.Count, 10), csvShare(tr.Share), csvShare(tr.Cumulative)))
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	for _, f := range r.Functions {
+		fv := r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom)
+		for i, op := range ops {
+			row := append([]string{"function"}, r.csvFunc(f)...)
+			cw.Write(append(row, op, "", "", strconv.FormatUint(fv[i], 10)))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTopWide writes the wide layout of a report with a Top.
+func (r *Result) writeTopWide(cw *csv.Writer, ops []string) error {
+	in := r.Top.names()
+	var top []Function
+	other := make([]uint64, len(ops))
+	var all uint64
+	for _, f := range r.Functions {
+		all += f.Sum()
+		if in[f.Name] {
+			top = append(top, f)
+			continue
+		}
+		for i, v := range r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom) {
+			other[i] += v
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Sum() > top[j].Sum() })
+
+	cw.Write(append(append(r.csvFuncHeader(), ops...), "share", "cumulative"))
+	var sum uint64
+	row := func(f Function, values []uint64) {
+		row := r.csvFunc(f)
+		for _, v := range values {
+			row = append(row, strconv.FormatUint(v, 10))
+		}
+		sum += values[0] + values[1] + values[2] + values[3]
+		share, cum := "", ""
+		if all > 0 {
+			share = csvShare(float64(values[0]+values[1]+values[2]+values[3]) / float64(all))
+			cum = csvShare(float64(sum) / float64(all))
+		}
+		cw.Write(append(row, share, cum))
+	}
+	for _, f := range top {
+		row(f, r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom))
+	}
+	if len(top) < len(r.Functions) {
+		row(Function{Name: TopOther}, other)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvShare formats a fraction for the share and cumulative columns.
+func csvShare(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+// csvOps returns the op-type column names.
+func (r *Result) csvOps() []string {
+	ops := append(append([]string{}, CategoryNames...), r.Ops()...)
+	if r.Vector != nil {
+		ops = append(ops, "vec_add", "vec_sub", "vec_mul")
+	}
+	if r.Wide != nil {
+		ops = append(ops, "wide_add", "wide_sub", "wide_mul")
+	}
+	if r.FP != nil {
+		for _, p := range []string{"fp64", "fp32"} {
+			ops = append(ops, p+"_add", p+"_sub", p+"_mul", p+"_div", p+"_fma")
+		}
+	}
+	if r.Memory != nil {
+		ops = append(ops, "mem_loads", "mem_stores", "mem_bytes_read", "mem_bytes_written")
+		if r.Cache != nil {
+			ops = append(ops, "mem_dram_bytes_read", "mem_dram_bytes_written")
+		}
+	}
+	return append(ops, r.Custom.Names()...)
+}
+
+// csvValues returns one row's values in csvOps order; missing breakdowns
+// count as zero.
+func (r *Result) csvValues(c Counts, vec *Vector, wide *WideCounts, fp64, fp32 *FPOps, mem *Memory, custom Custom) []uint64 {
+	v := []uint64{c.Add, c.Sub, c.Mul, c.Div}
+	for _, op := range r.Ops() {
+		v = append(v, c.Get(op))
+	}
+	if r.Vector != nil {
+		if vec == nil {
+			vec = &Vector{}
+		}
+		v = append(v, vec.Add, vec.Sub, vec.Mul)
+	}
+	if r.Wide != nil {
+		if wide == nil {
+			wide = &WideCounts{}
+		}
+		v = append(v, wide.Add, wide.Sub, wide.Mul)
+	}
+	if r.FP != nil {
+		for _, f := range []*FPOps{fp64, fp32} {
+			if f == nil {
+				f = &FPOps{}
+			}
+			v = append(v, f.Add, f.Sub, f.Mul, f.Div, f.FMA)
+		}
+	}
+	if r.Memory != nil {
+		if mem == nil {
+			mem = &Memory{}
+		}
+		v = append(v, mem.Loads, mem.Stores, mem.BytesRead, mem.BytesWritten)
+		if r.Cache != nil {
+			v = append(v, mem.DRAMBytesRead, mem.DRAMBytesWritten)
+		}
+	}
+	for _, n := range r.Custom.Names() {
+		v = append(v, custom[n])
+	}
+	return v
+}
+
+// totalValues returns the run totals in csvOps order.
+func (r *Result) totalValues() []uint64 {
+	var fp64, fp32 *FPOps
+	if r.FP != nil {
+		fp64, fp32 = &r.FP.FP64, &r.FP.FP32
+	}
+	return r.csvValues(r.Totals, r.Vector, r.Wide.counts(), fp64, fp32, r.Memory, r.Custom)
+}
+
+// counts sums each kind over all widths; nil when w is nil.
+func (w *Wide) counts() *WideCounts {
+	if w == nil {
+		return nil
+	}
+	var c WideCounts
+	for _, n := range w.Add {
+		c.Add += n
+	}
+	for _, n := range w.Sub {
+		c.Sub += n
+	}
+	for _, n := range w.Mul {
+		c.Mul += n
+	}
+	return &c
+}
+
+// csvFuncHeader returns the names of the csvFunc columns.
+func (r *Result) csvFuncHeader() []string {
+	if r.names == NamesBoth {
+		return []string{"function", "mangled", "image", "file", "line"}
+	}
+	return []string{"function", "image", "file", "line"}
+}
+
+// csvFunc returns a function's function,image,file,line fields, with its
+// symbol after function when r names both.
+func (r *Result) csvFunc(f Function) []string {
+	line := ""
+	if f.File != "" {
+		line = strconv.Itoa(f.Line)
+	}
+	if r.names == NamesBoth {
+		return []string{f.Name, r.rawName(f.Name), f.Image, f.File, line}
+	}
+	return []string{f.Name, f.Image, f.File, line}
+}
@@ -0,0 +1,467 @@
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoDebugInfo means no separate debug info was found for an image.
+var ErrNoDebugInfo = errors.New("profiler: no separate debug info")
+
+// Ways a separate debug file is found (DebugFile.Via).
+const (
+	DebugViaBuildID    = "build-id"   // DIR/.build-id/xx/yyyy.debug
+	DebugViaDebuglink  = "debuglink"  // the .gnu_debuglink name, CRC checked
+	DebugViaDebuginfod = "debuginfod" // the debuginfod client cache
+)
+
+// DefaultDebugDir is searched for separate debug info after
+// Options.DebugDirs, as GDB does.
+const DefaultDebugDir = "/usr/lib/debug"
+
+// DebugFile is the separate debug info of a stripped image
+// (Result.DebugInfo): the file its function symbols, and their source
+// lines, were read from.
+type DebugFile struct {
+	Image   string `json:"image"`
+	Path    string `json:"path"`
+	BuildID string `json:"build_id,omitempty"`
+	Via     string `json:"via"`
+}
+
+// FindDebugFile returns the separate debug info of the ELF image at path,
+// looked for as GDB does: by GNU build ID under DIR/.build-id/xx/yyyy.debug
+// of each of dirs and DefaultDebugDir, then in the debuginfod client cache
+// ($DEBUGINFOD_CACHE_PATH, or debuginfod_client in the XDG cache
+// directory), then by the image's .gnu_debuglink name next to it, in its
+// .debug directory and under each debug directory, where the file's CRC
+// must match. It does not look at whether the image was stripped.
+func FindDebugFile(path string, dirs []string) (DebugFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return DebugFile{}, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	return findDebugFile(f, path, dirs)
+}
+
+func findDebugFile(f *elf.File, path string, dirs []string) (DebugFile, error) {
+	dirs = append(append([]string(nil), dirs...), DefaultDebugDir)
+	d := DebugFile{Image: path, BuildID: elfBuildID(f)}
+	if id := d.BuildID; len(id) > 2 {
+		for _, dir := range dirs {
+			if p := filepath.Join(dir, ".build-id", id[:2], id[2:]+".debug"); isFile(p) {
+				d.Path, d.Via = p, DebugViaBuildID
+				return d, nil
+			}
+		}
+		if c := debuginfodCache(); c != "" {
+			if p := filepath.Join(c, id, "debuginfo"); isFile(p) {
+				d.Path, d.Via = p, DebugViaDebuginfod
+				return d, nil
+			}
+		}
+	}
+	if name, crc, ok := elfDebuglink(f); ok {
+		abs, _ := filepath.Abs(path)
+		dir := filepath.Dir(abs)
+		cands := []string{filepath.Join(dir, name), filepath.Join(dir, ".debug", name)}
+		for _, dd := range dirs {
+			cands = append(cands, filepath.Join(dd, dir, name))
+		}
+		for _, p := range cands {
+			if p != abs && isFile(p) && fileCRC(p) == crc {
+				d.Path, d.Via = p, DebugViaDebuglink
+				return d, nil
+			}
+		}
+	}
+	return DebugFile{}, fmt.Errorf("%w: %s", ErrNoDebugInfo, path)
+}
+
+// FetchDebugFile is FindDebugFile, then asks each of the debuginfod
+// servers (base URLs, as in $DEBUGINFOD_URLS) for the debug info of the
+// image's build ID and keeps the first found in the debuginfod client
+// cache, where debuginfod-find, GDB and the pintool look for it too.
+func FetchDebugFile(ctx context.Context, path string, dirs, servers []string) (DebugFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return DebugFile{}, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	d, err := findDebugFile(f, path, dirs)
+	id, cache := elfBuildID(f), debuginfodCache()
+	if err == nil || id == "" || cache == "" {
+		return d, err
+	}
+	for _, s := range servers {
+		dst := filepath.Join(cache, id, "debuginfo")
+		ferr := fetchURL(ctx, strings.TrimRight(s, "/")+"/buildid/"+id+"/debuginfo", dst)
+		if ferr == nil {
+			return DebugFile{Image: path, Path: dst, BuildID: id, Via: DebugViaDebuginfod}, nil
+		}
+		if ctx.Err() != nil {
+			return DebugFile{}, fmt.Errorf("profiler: %w", ctx.Err())
+		}
+		err = fmt.Errorf("%w (%v)", err, ferr)
+	}
+	return DebugFile{}, err
+}
+
+// DebuginfodURLs returns the servers of $DEBUGINFOD_URLS.
+func DebuginfodURLs() []string { return strings.Fields(os.Getenv("DEBUGINFOD_URLS")) }
+
+// fetchURL downloads url to dst, which it creates only whole.
+func fetchURL(ctx context.Context, url, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".debuginfo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// debuginfodCache is the debuginfod client cache directory, as elfutils
+// names it.
+func debuginfodCache() string {
+	if c := os.Getenv("DEBUGINFOD_CACHE_PATH"); c != "" {
+		return c
+	}
+	if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
+		return filepath.Join(x, "debuginfod_client")
+	}
+	if h, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(h, ".cache", "debuginfod_client")
+	}
+	return ""
+}
+
+// fetchDebug fetches, for Options.Debuginfod, the debug info of the
+// executable, or command name, exe and of the shared libraries it needs
+// that are stripped and have none at hand. Failures leave the images as
+// they are, and are returned as diagnostics.
+func (p *Profiler) fetchDebug(ctx context.Context, exe string) []Diagnostic {
+	if len(p.opts.Debuginfod) == 0 {
+		return nil
+	}
+	if lp, err := exec.LookPath(exe); err == nil {
+		exe = lp
+	}
+	var found []Diagnostic
+	for _, path := range append([]string{exe}, Dependencies(exe)...) {
+		if !Stripped(path) {
+			continue
+		}
+		d, err := FetchDebugFile(ctx, path, p.opts.DebugDirs, p.opts.Debuginfod)
+		if err != nil {
+			found = append(found, Diagnostic{Level: DiagInfo, Kind: DiagUnresolvedSymbols, Image: path,
+				Message: "stripped, and its debug info could not be fetched from the debuginfod servers"})
+			continue
+		}
+		p.log(slog.LevelDebug, "debug info fetched", "image", path, "path", d.Path, "via", d.Via)
+	}
+	return found
+}
+
+// Default directories of the shared libraries, after RUNPATH and
+// LD_LIBRARY_PATH.
+var libDirs = []string{
+	"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu",
+	"/lib/aarch64-linux-gnu", "/usr/lib/aarch64-linux-gnu",
+	"/lib64", "/usr/lib64", "/lib", "/usr/lib", "/usr/local/lib",
+}
+
+// Dependencies returns the shared libraries the ELF executable at path
+// loads at startup, its interpreter first, found as the dynamic linker
+// finds them: in the DT_RUNPATH (or DT_RPATH) directories, those of
+// $LD_LIBRARY_PATH and the default ones. Libraries it cannot find are
+// left out, as are those opened with dlopen().
+func Dependencies(path string) []string {
+	var deps []string
+	seen := map[string]bool{} // by real path: /lib64 links to /lib
+	first := func(p string) bool {
+		if r, err := filepath.EvalSymlinks(p); err == nil {
+			p = r
+		}
+		if seen[p] {
+			return false
+		}
+		seen[p] = true
+		return true
+	}
+	first(path)
+	var walk func(string)
+	walk = func(path string) {
+		f, err := elf.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for _, pr := range f.Progs {
+			if pr.Type == elf.PT_INTERP {
+				if b, err := io.ReadAll(pr.Open()); err == nil {
+					if interp := string(bytes.TrimRight(b, "\x00")); isFile(interp) && first(interp) {
+						deps = append(deps, interp)
+					}
+				}
+			}
+		}
+		needed, _ := f.DynString(elf.DT_NEEDED)
+		run, _ := f.DynString(elf.DT_RUNPATH)
+		if len(run) == 0 {
+			run, _ = f.DynString(elf.DT_RPATH)
+		}
+		var dirs []string
+		origin := filepath.Dir(path)
+		for _, r := range run {
+			for _, d := range filepath.SplitList(r) {
+				dirs = append(dirs, strings.ReplaceAll(strings.ReplaceAll(d, "${ORIGIN}", origin), "$ORIGIN", origin))
+			}
+		}
+		dirs = append(append(dirs, filepath.SplitList(os.Getenv("LD_LIBRARY_PATH"))...), libDirs...)
+		for _, lib := range needed {
+			for _, d := range dirs {
+				if p := filepath.Join(d, lib); d != "" && isFile(p) {
+					if first(p) {
+						deps = append(deps, p)
+						walk(p)
+					}
+					break
+				}
+			}
+		}
+	}
+	walk(path)
+	return deps
+}
+
+// withDebug returns the ELF file to read the symbols and DWARF of the
+// image f at path from: f, unless it was stripped and its separate debug
+// file is found, at debug when that is set or else by FindDebugFile with
+// dirs. The caller closes the file returned when it is not f.
+func withDebug(f *elf.File, path, debug string, dirs []string) (*elf.File, DebugFile) {
+	if !elfStripped(f) {
+		return f, DebugFile{}
+	}
+	d := DebugFile{Image: path, Path: debug}
+	if debug == "" {
+		var err error
+		if d, err = findDebugFile(f, path, dirs); err != nil {
+			return f, DebugFile{}
+		}
+	}
+	df, err := elf.Open(d.Path)
+	if err != nil {
+		return f, DebugFile{}
+	}
+	if s := df.SectionByType(elf.SHT_SYMTAB); s == nil || df.Machine != f.Machine {
+		df.Close()
+		return f, DebugFile{}
+	}
+	return df, d
+}
+
+// elfStripped reports whether f lacks a symbol table or DWARF.
+func elfStripped(f *elf.File) bool {
+	return f.SectionByType(elf.SHT_SYMTAB) == nil ||
+		f.Section(".debug_info") == nil && f.Section(".zdebug_info") == nil
+}
+
+// Stripped reports whether the ELF file at path lacks a symbol table or
+// DWARF, and so needs separate debug info; false for a file that is not
+// ELF.
+func Stripped(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return elfStripped(f)
+}
+
+// elfBuildID returns the GNU build ID of f in hex, "" when it has none.
+func elfBuildID(f *elf.File) string {
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		n, err := s.Data()
+		if err != nil {
+			continue
+		}
+		// namesz, descsz, type, then the name and the descriptor, each
+		// padded to four bytes
+		for len(n) >= 12 {
+			nsz, dsz := f.ByteOrder.Uint32(n), f.ByteOrder.Uint32(n[4:])
+			typ := f.ByteOrder.Uint32(n[8:])
+			name, desc := 12+(nsz+3)&^3, 12+(nsz+3)&^3+(dsz+3)&^3
+			if uint64(desc) > uint64(len(n)) {
+				break
+			}
+			if typ == 3 && nsz == 4 && string(n[12:15]) == "GNU" { // NT_GNU_BUILD_ID
+				return hex.EncodeToString(n[name : name+dsz])
+			}
+			n = n[desc:]
+		}
+	}
+	return ""
+}
+
+// elfDebuglink returns the file name and CRC of f's .gnu_debuglink.
+func elfDebuglink(f *elf.File) (string, uint32, bool) {
+	s := f.Section(".gnu_debuglink")
+	if s == nil {
+		return "", 0, false
+	}
+	b, err := s.Data()
+	if err != nil {
+		return "", 0, false
+	}
+	i := bytes.IndexByte(b, 0)
+	at := (i + 4) &^ 3
+	if i <= 0 || at+4 > len(b) {
+		return "", 0, false
+	}
+	return string(b[:i]), f.ByteOrder.Uint32(b[at:]), true
+}
+
+// fileCRC returns the CRC-32 of the file at path, as .gnu_debuglink
+// records it.
+func fileCRC(path string) uint32 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	io.Copy(h, f)
+	return h.Sum32()
+}
+
+func isFile(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// debugFile returns the debug file of image recorded in r.DebugInfo, ""
+// when there is none.
+func (r *Result) debugFile(image string) string {
+	for _, d := range r.DebugInfo {
+		if d.Image == image {
+			return d.Path
+		}
+	}
+	return ""
+}
+
+// debugLines gives the functions of the images in r.DebugInfo that have
+// no source location the one of their entry in the debug file: Pin reads
+// DWARF only from the image itself.
+func (r *Result) debugLines() {
+	for _, d := range r.DebugInfo {
+		var fns []*Function
+		for i := range r.Functions {
+			if f := &r.Functions[i]; f.Image == d.Image && f.File == "" {
+				fns = append(fns, f)
+			}
+		}
+		if len(fns) == 0 {
+			continue
+		}
+		f, err := elf.Open(d.Path)
+		if err != nil {
+			continue
+		}
+		syms, _ := f.Symbols()
+		dw, _ := f.DWARF()
+		f.Close()
+		if dw == nil {
+			continue
+		}
+		addrs := map[string]uint64{}
+		for _, s := range syms {
+			if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 {
+				if _, ok := addrs[s.Name]; !ok {
+					addrs[s.Name] = s.Value
+				}
+			}
+		}
+		for _, fn := range fns {
+			name := fn.Name
+			if fn.Mangled != "" {
+				name = fn.Mangled
+			}
+			if addr, ok := addrs[name]; ok {
+				fn.File, fn.Line = dwarfLineAt(dw, addr)
+			}
+		}
+	}
+}
+
+// dwarfLineAt returns the source location of the instruction at addr, ""
+// when it has none.
+func dwarfLineAt(dw *dwarf.Data, addr uint64) (string, int) {
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			return "", 0
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		ranges, _ := dw.Ranges(e)
+		r.SkipChildren()
+		in := false
+		for _, rg := range ranges {
+			in = in || addr >= rg[0] && addr < rg[1]
+		}
+		if !in {
+			continue
+		}
+		lr, err := dw.LineReader(e)
+		if err != nil || lr == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		if lr.SeekPC(addr, &le) == nil && le.File != nil {
+			return le.File.Name, le.Line
+		}
+	}
+}
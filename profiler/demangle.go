@@ -0,0 +1,1646 @@
+package profiler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Demangle returns the readable form of the C++ (Itanium ABI) or Rust
+// (legacy or v0) symbol name, or name itself when it is neither or does
+// not parse: _ZN3fhe6ModMulExx is fhe::ModMul(long long, long long).
+// Rust paths are printed without their hash, so that a function keeps
+// its name across builds. A symbol version (@GLIBCXX_3.4) is kept.
+func Demangle(name string) string {
+	sym, ver := name, ""
+	if i := strings.IndexByte(name, '@'); i > 0 {
+		sym, ver = name[:i], name[i:]
+	}
+	if s, ok := demangleRust(sym); ok {
+		return s + ver
+	}
+	if s, ok := demangleItanium(sym); ok {
+		return s + ver
+	}
+	return name
+}
+
+// The Itanium demangler parses the mangling into a tree of dnodes and
+// prints that: a type prints in two parts around what it declares, as
+// "void (*" and ")(int)" around a pointer to a function, so each node has
+// a left and a right half. Substitutions and template arguments refer back
+// to nodes already parsed.
+
+// badMangling is panicked by the parsers on input that does not parse.
+type badMangling struct{}
+
+// dshape says what a node prints after the name it declares.
+type dshape uint8
+
+const (
+	shapeRight dshape = 1 << iota // anything: the parameters, a bound
+	shapeArray
+	shapeFunc
+)
+
+type dnode interface {
+	left(p *dprinter)
+	right(p *dprinter)
+	shape() dshape
+}
+
+// dprinter collects the output; packIdx and packMax track the element of
+// a template parameter pack being printed by an expansion, packMax -1
+// outside one.
+type dprinter struct {
+	b                []byte
+	packIdx, packMax int
+	// dropped is set when list has just taken back the ", " before an
+	// empty pack; c++filt then takes the last character to be the space,
+	// and prints A<B<int>, > as A<B<int>>.
+	dropped bool
+}
+
+func (p *dprinter) str(s string) {
+	if s != "" {
+		p.dropped = false
+	}
+	p.b = append(p.b, s...)
+}
+
+func (p *dprinter) last() byte {
+	if p.dropped {
+		return ' '
+	}
+	if len(p.b) == 0 {
+		return 0
+	}
+	return p.b[len(p.b)-1]
+}
+
+func (p *dprinter) print(n dnode) {
+	n.left(p)
+	n.right(p)
+}
+
+// list prints ns separated by commas, leaving out those that print
+// nothing (empty packs).
+func (p *dprinter) list(ns []dnode) {
+	first := true
+	for _, n := range ns {
+		mark := len(p.b)
+		if !first {
+			p.str(", ")
+		}
+		after := len(p.b)
+		p.print(n)
+		if len(p.b) == after {
+			p.b = p.b[:mark]
+			p.dropped = !first
+			continue
+		}
+		first = false
+	}
+}
+
+// dplain is embedded by the nodes that print entirely on the left.
+type dplain struct{}
+
+func (dplain) right(*dprinter) {}
+func (dplain) shape() dshape   { return 0 }
+
+// dname is text printed as it is: a name, a builtin type.
+type dname string
+
+func (n dname) left(p *dprinter) { p.str(string(n)) }
+func (dname) right(*dprinter)    {}
+func (dname) shape() dshape      { return 0 }
+
+// dseq prints its parts one after the other, an expression.
+type dseq struct {
+	dplain
+	parts []dnode
+}
+
+func (n *dseq) left(p *dprinter) {
+	for _, c := range n.parts {
+		p.print(c)
+	}
+}
+
+func seq(parts ...dnode) dnode { return &dseq{parts: parts} }
+
+type dnested struct {
+	dplain
+	scope, name dnode
+}
+
+func (n *dnested) left(p *dprinter) {
+	p.print(n.scope)
+	p.str("::")
+	p.print(n.name)
+}
+
+type dtemplate struct {
+	dplain
+	name dnode
+	args []dnode
+}
+
+func (n *dtemplate) left(p *dprinter) {
+	p.print(n.name)
+	if p.last() == '<' {
+		p.str(" ") // operator< <int>
+	}
+	p.str("<")
+	p.list(n.args)
+	if p.last() == '>' {
+		p.str(" ")
+	}
+	p.str(">")
+}
+
+// dargpack is a template argument pack: all its elements.
+type dargpack struct {
+	dplain
+	elems []dnode
+}
+
+func (n *dargpack) left(p *dprinter) { p.list(n.elems) }
+
+// dpack is a reference to a pack, which prints the element an enclosing
+// expansion is at.
+type dpack struct{ elems []dnode }
+
+func (n *dpack) at(p *dprinter) dnode {
+	if p.packMax == -1 {
+		p.packIdx, p.packMax = 0, len(n.elems)
+	}
+	if p.packIdx < len(n.elems) {
+		return n.elems[p.packIdx]
+	}
+	return nil
+}
+
+func (n *dpack) left(p *dprinter) {
+	if e := n.at(p); e != nil {
+		e.left(p)
+	}
+}
+
+func (n *dpack) right(p *dprinter) {
+	if e := n.at(p); e != nil {
+		e.right(p)
+	}
+}
+
+func (n *dpack) shape() dshape {
+	if len(n.elems) == 1 {
+		return n.elems[0].shape()
+	}
+	return 0
+}
+
+// dexpansion prints its child once per element of the pack it refers to.
+type dexpansion struct {
+	dplain
+	child dnode
+}
+
+func (n *dexpansion) left(p *dprinter) {
+	idx, max := p.packIdx, p.packMax
+	defer func() { p.packIdx, p.packMax = idx, max }()
+	p.packIdx, p.packMax = 0, -1
+	start := len(p.b)
+	p.print(n.child)
+	switch {
+	case p.packMax == -1:
+		p.str("...")
+	case p.packMax == 0:
+		p.b = p.b[:start]
+	default:
+		for i := 1; i < p.packMax; i++ {
+			p.str(", ")
+			p.packIdx = i
+			p.print(n.child)
+		}
+	}
+}
+
+// dtparam is a template parameter referred to before its argument was
+// parsed, as in the type of a templated conversion operator.
+type dtparam struct{ arg dnode }
+
+func (n *dtparam) left(p *dprinter)  { n.arg.left(p) }
+func (n *dtparam) right(p *dprinter) { n.arg.right(p) }
+func (n *dtparam) shape() dshape     { return n.arg.shape() }
+
+// dcv is a cv-qualified type; quals is " const" and the like.
+type dcv struct {
+	child dnode
+	quals string
+}
+
+func (n *dcv) left(p *dprinter) {
+	n.child.left(p)
+	// T const where T is already const prints one const.
+	var inner dnode = n.child
+	for {
+		switch t := inner.(type) {
+		case *dpack:
+			if e := t.at(p); e != nil {
+				inner = e
+				continue
+			}
+		case *dtparam:
+			inner = t.arg
+			continue
+		}
+		break
+	}
+	if c, ok := inner.(*dcv); ok && strings.Contains(c.quals, n.quals) {
+		return
+	}
+	p.str(n.quals)
+}
+func (n *dcv) right(p *dprinter) { n.child.right(p) }
+func (n *dcv) shape() dshape     { return n.child.shape() }
+
+// dptr is a pointer or reference; sym is "*", "&" or "&&".
+type dptr struct {
+	pointee dnode
+	sym     string
+}
+
+// collapsed returns the pointee and symbol of n after reference
+// collapsing: a reference to a reference, which T&& is when T is U&, is
+// a & unless both are &&.
+func (n *dptr) collapsed(p *dprinter) (dnode, string) {
+	pointee, sym := n.pointee, n.sym
+	if sym == "*" {
+		return pointee, sym
+	}
+	for {
+		switch t := pointee.(type) {
+		case *dpack:
+			if e := t.at(p); e != nil {
+				pointee = e
+				continue
+			}
+		case *dtparam:
+			pointee = t.arg
+			continue
+		case *dptr:
+			if t.sym != "*" {
+				if t.sym == "&" {
+					sym = "&"
+				}
+				pointee = t.pointee
+				continue
+			}
+		}
+		return pointee, sym
+	}
+}
+
+func (n *dptr) left(p *dprinter) {
+	pointee, sym := n.collapsed(p)
+	pointee.left(p)
+	s := pointee.shape()
+	if s&shapeArray != 0 {
+		p.str(" ")
+	}
+	if s&(shapeArray|shapeFunc) != 0 {
+		p.str("(")
+	}
+	p.str(sym)
+}
+
+func (n *dptr) right(p *dprinter) {
+	pointee, _ := n.collapsed(p)
+	if pointee.shape()&(shapeArray|shapeFunc) != 0 {
+		p.str(")")
+	}
+	pointee.right(p)
+}
+
+func (n *dptr) shape() dshape { return n.pointee.shape() & shapeRight }
+
+// dptrmem is a pointer to a member of class.
+type dptrmem struct {
+	class, member dnode
+}
+
+func (n *dptrmem) left(p *dprinter) {
+	n.member.left(p)
+	if n.member.shape()&(shapeArray|shapeFunc) != 0 {
+		p.str("(")
+	} else {
+		p.str(" ")
+	}
+	p.print(n.class)
+	p.str("::*")
+}
+
+func (n *dptrmem) right(p *dprinter) {
+	if n.member.shape()&(shapeArray|shapeFunc) != 0 {
+		p.str(")")
+	}
+	n.member.right(p)
+}
+
+func (n *dptrmem) shape() dshape { return n.member.shape() & shapeRight }
+
+// dfunc is a function type.
+type dfunc struct {
+	ret           dnode
+	params        []dnode
+	cv, ref, spec string
+}
+
+func (n *dfunc) left(p *dprinter) {
+	n.ret.left(p)
+	p.str(" ")
+}
+
+func (n *dfunc) right(p *dprinter) {
+	p.str("(")
+	p.list(n.params)
+	p.str(")")
+	n.ret.right(p)
+	p.str(n.cv)
+	p.str(n.ref)
+	p.str(n.spec)
+}
+
+func (n *dfunc) shape() dshape { return shapeRight | shapeFunc }
+
+type darray struct {
+	elem dnode
+	dim  dnode // nil when unknown
+}
+
+func (n *darray) left(p *dprinter) { n.elem.left(p) }
+
+func (n *darray) right(p *dprinter) {
+	if p.last() != ']' {
+		p.str(" ")
+	}
+	p.str("[")
+	if n.dim != nil {
+		p.print(n.dim)
+	}
+	p.str("]")
+	n.elem.right(p)
+}
+
+func (n *darray) shape() dshape { return shapeRight | shapeArray }
+
+// dencoding is a function: its return type when mangled, name and
+// parameters.
+type dencoding struct {
+	ret     dnode
+	name    dnode
+	params  []dnode
+	cv, ref string
+}
+
+func (n *dencoding) left(p *dprinter) {
+	if n.ret != nil {
+		n.ret.left(p)
+		if n.ret.shape()&shapeRight == 0 {
+			p.str(" ")
+		}
+	}
+	p.print(n.name)
+}
+
+func (n *dencoding) right(p *dprinter) {
+	p.str("(")
+	p.list(n.params)
+	p.str(")")
+	if n.ret != nil {
+		n.ret.right(p)
+	}
+	p.str(n.cv)
+	p.str(n.ref)
+}
+
+func (n *dencoding) shape() dshape { return shapeRight | shapeFunc }
+
+// dprefixed is text followed by a node: "vtable for X", "-x".
+type dprefixed struct {
+	dplain
+	prefix string
+	child  dnode
+}
+
+func (n *dprefixed) left(p *dprinter) {
+	p.str(n.prefix)
+	p.print(n.child)
+}
+
+// dsuffixed is a node followed by text, with the child's shape: an ABI
+// tag, a clone suffix, a vendor qualifier.
+type dsuffixed struct {
+	child  dnode
+	suffix string
+}
+
+func (n *dsuffixed) left(p *dprinter) {
+	n.child.left(p)
+	if n.child.shape()&shapeRight == 0 {
+		p.str(n.suffix)
+	}
+}
+
+func (n *dsuffixed) right(p *dprinter) {
+	n.child.right(p)
+	if n.child.shape()&shapeRight != 0 {
+		p.str(n.suffix)
+	}
+}
+
+func (n *dsuffixed) shape() dshape { return n.child.shape() }
+
+type dlambda struct {
+	dplain
+	params []dnode
+	n      string
+}
+
+func (n *dlambda) left(p *dprinter) {
+	p.str("{lambda(")
+	p.list(n.params)
+	p.str(")#" + n.n + "}")
+}
+
+// dsub is one of the abbreviations Sa, Sb, Ss, Si, So and Sd, printed in
+// full as c++filt does; ctor is the name of its constructors.
+type dsub struct {
+	dplain
+	full, ctor string
+}
+
+func (n *dsub) left(p *dprinter) { p.str(n.full) }
+
+var itaniumSubs = map[byte]dsub{
+	'a': {full: "std::allocator", ctor: "allocator"},
+	'b': {full: "std::basic_string", ctor: "basic_string"},
+	's': {full: "std::basic_string<char, std::char_traits<char>, std::allocator<char> >", ctor: "basic_string"},
+	'i': {full: "std::basic_istream<char, std::char_traits<char> >", ctor: "basic_istream"},
+	'o': {full: "std::basic_ostream<char, std::char_traits<char> >", ctor: "basic_ostream"},
+	'd': {full: "std::basic_iostream<char, std::char_traits<char> >", ctor: "basic_iostream"},
+}
+
+var itaniumBuiltins = map[byte]string{
+	'v': "void", 'w': "wchar_t", 'b': "bool", 'c': "char", 'a': "signed char",
+	'h': "unsigned char", 's': "short", 't': "unsigned short", 'i': "int",
+	'j': "unsigned int", 'l': "long", 'm': "unsigned long", 'x': "long long",
+	'y': "unsigned long long", 'n': "__int128", 'o': "unsigned __int128",
+	'f': "float", 'd': "double", 'e': "long double", 'g': "__float128", 'z': "...",
+}
+
+var itaniumDBuiltins = map[byte]string{
+	'd': "decimal64", 'e': "decimal128", 'f': "decimal32", 'h': "half",
+	'i': "char32_t", 's': "char16_t", 'u': "char8_t", 'a': "auto",
+	'c': "decltype(auto)", 'n': "decltype(nullptr)",
+}
+
+// itaniumOps are the operator names: the name printed after "operator"
+// and the operands the operator takes in an expression.
+var itaniumOps = map[string]struct {
+	name  string
+	arity int
+}{
+	"nw": {"new", 3}, "na": {"new[]", 3}, "dl": {"delete", 1}, "da": {"delete[]", 1},
+	"ps": {"+", 1}, "ng": {"-", 1}, "ad": {"&", 1}, "de": {"*", 1}, "co": {"~", 1},
+	"pl": {"+", 2}, "mi": {"-", 2}, "ml": {"*", 2}, "dv": {"/", 2}, "rm": {"%", 2},
+	"an": {"&", 2}, "or": {"|", 2}, "eo": {"^", 2}, "aS": {"=", 2}, "pL": {"+=", 2},
+	"mI": {"-=", 2}, "mL": {"*=", 2}, "dV": {"/=", 2}, "rM": {"%=", 2}, "aN": {"&=", 2},
+	"oR": {"|=", 2}, "eO": {"^=", 2}, "ls": {"<<", 2}, "rs": {">>", 2}, "lS": {"<<=", 2},
+	"rS": {">>=", 2}, "eq": {"==", 2}, "ne": {"!=", 2}, "lt": {"<", 2}, "gt": {">", 2},
+	"le": {"<=", 2}, "ge": {">=", 2}, "ss": {"<=>", 2}, "nt": {"!", 1}, "aa": {"&&", 2},
+	"oo": {"||", 2}, "pp": {"++", 1}, "mm": {"--", 1}, "cm": {",", 2}, "pm": {"->*", 2},
+	"pt": {"->", 2}, "cl": {"()", 2}, "ix": {"[]", 2}, "qu": {"?", 3}, "aw": {"co_await", 1},
+}
+
+// itanium is the state of one demangling.
+type itanium struct {
+	s        string
+	pos      int
+	depth    int
+	subs     []dnode
+	targs    []dnode    // the template arguments T_ refers to
+	forwards []*dtparam // T_ seen before its arguments
+	forwardN []int
+	// allowForward is set while parsing the type of a conversion
+	// operator, notargs there too: its template arguments, if any, are
+	// the function's.
+	allowForward, noTargs bool
+}
+
+// nameState collects what the name of a function encoding says of the
+// function.
+type nameState struct {
+	cv, ref          string
+	endsWithTargs    bool
+	ctorDtorConvName bool
+}
+
+// demangleItanium demangles the C++ symbol s.
+func demangleItanium(s string) (out string, ok bool) {
+	if !strings.HasPrefix(s, "_Z") {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, bad := r.(badMangling); !bad {
+				panic(r)
+			}
+			out, ok = "", false
+		}
+	}()
+	d := &itanium{s: s, pos: 2}
+	n := d.encoding()
+	for d.pos < len(d.s) && d.s[d.pos] == '.' {
+		n = d.cloneSuffix(n)
+	}
+	if d.pos != len(d.s) {
+		return "", false
+	}
+	p := &dprinter{packMax: -1}
+	p.print(n)
+	return string(p.b), true
+}
+
+func (d *itanium) fail() { panic(badMangling{}) }
+
+func (d *itanium) peek(i int) byte {
+	if d.pos+i < len(d.s) {
+		return d.s[d.pos+i]
+	}
+	return 0
+}
+
+func (d *itanium) eat(prefix string) bool {
+	if strings.HasPrefix(d.s[d.pos:], prefix) {
+		d.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (d *itanium) expect(prefix string) {
+	if !d.eat(prefix) {
+		d.fail()
+	}
+}
+
+func (d *itanium) next() byte {
+	if d.pos >= len(d.s) {
+		d.fail()
+	}
+	d.pos++
+	return d.s[d.pos-1]
+}
+
+// enter guards the recursion against pathological input.
+func (d *itanium) enter() func() {
+	d.depth++
+	if d.depth > 256 {
+		d.fail()
+	}
+	return func() { d.depth-- }
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// number parses <number> ::= [n] <decimal>, as text.
+func (d *itanium) number() string {
+	start := d.pos
+	d.eat("n")
+	if !isDigit(d.peek(0)) {
+		d.fail()
+	}
+	for isDigit(d.peek(0)) {
+		d.pos++
+	}
+	if d.s[start] == 'n' {
+		return "-" + d.s[start+1:d.pos]
+	}
+	return d.s[start:d.pos]
+}
+
+// seqID parses a base-36 <seq-id> and its _, returning 0 for a bare _
+// and the number plus one otherwise.
+func (d *itanium) seqID() int {
+	if d.eat("_") {
+		return 0
+	}
+	n := 0
+	for {
+		c := d.next()
+		switch {
+		case c == '_':
+			return n + 1
+		case isDigit(c):
+			n = n*36 + int(c-'0')
+		case c >= 'A' && c <= 'Z':
+			n = n*36 + int(c-'A') + 10
+		default:
+			d.fail()
+		}
+		if n > 1<<20 {
+			d.fail()
+		}
+	}
+}
+
+// index parses the [<number>] _ of the Ut, Ul and T forms: 0 for _.
+func (d *itanium) index() int {
+	if d.eat("_") {
+		return 0
+	}
+	n, err := strconv.Atoi(d.number())
+	if err != nil || n < 0 {
+		d.fail()
+	}
+	d.expect("_")
+	return n + 1
+}
+
+func (d *itanium) discriminator() {
+	if d.eat("__") {
+		d.number()
+		d.expect("_")
+	} else if d.peek(0) == '_' && isDigit(d.peek(1)) {
+		d.pos += 2
+	}
+}
+
+func (d *itanium) cloneSuffix(n dnode) dnode {
+	start := d.pos
+	d.pos++ // .
+	for c := d.peek(0); c >= 'a' && c <= 'z' || c == '_' || isDigit(c); c = d.peek(0) {
+		d.pos++
+	}
+	for d.peek(0) == '.' && isDigit(d.peek(1)) {
+		d.pos++
+		for isDigit(d.peek(0)) {
+			d.pos++
+		}
+	}
+	if d.pos == start+1 {
+		d.fail()
+	}
+	return &dsuffixed{child: n, suffix: " [clone " + d.s[start:d.pos] + "]"}
+}
+
+// encoding parses <encoding> ::= <name> [<bare-function-type>] | <special-name>.
+func (d *itanium) encoding() dnode {
+	defer d.enter()()
+	if c := d.peek(0); c == 'G' || c == 'T' {
+		return d.specialName()
+	}
+	// The template parameters of a local name's function are its own.
+	targs := d.targs
+	defer func() { d.targs = targs }()
+	var st nameState
+	saved := d.forwards
+	d.forwards, d.forwardN = nil, nil
+	name := d.name(&st)
+	for i, f := range d.forwards {
+		if d.forwardN[i] >= len(d.targs) {
+			d.fail()
+		}
+		f.arg = d.targs[d.forwardN[i]]
+	}
+	d.forwards = saved
+	if d.pos == len(d.s) || d.peek(0) == 'E' || d.peek(0) == '.' {
+		return name
+	}
+	var ret dnode
+	if !st.ctorDtorConvName && st.endsWithTargs {
+		ret = d.typ()
+	}
+	enc := &dencoding{ret: ret, name: name, cv: st.cv, ref: st.ref}
+	if d.eat("v") {
+		return enc
+	}
+	for d.pos < len(d.s) && d.peek(0) != 'E' && d.peek(0) != '.' {
+		enc.params = append(enc.params, d.typ())
+	}
+	return enc
+}
+
+func (d *itanium) specialName() dnode {
+	switch {
+	case d.eat("TV"):
+		return &dprefixed{prefix: "vtable for ", child: d.typ()}
+	case d.eat("TT"):
+		return &dprefixed{prefix: "VTT for ", child: d.typ()}
+	case d.eat("TI"):
+		return &dprefixed{prefix: "typeinfo for ", child: d.typ()}
+	case d.eat("TS"):
+		return &dprefixed{prefix: "typeinfo name for ", child: d.typ()}
+	case d.eat("Tc"):
+		d.callOffset()
+		d.callOffset()
+		return &dprefixed{prefix: "covariant return thunk to ", child: d.encoding()}
+	case d.eat("TC"):
+		outer := d.typ()
+		d.number()
+		d.expect("_")
+		inner := d.typ()
+		return &dprefixed{prefix: "construction vtable for ", child: seq(inner, dname("-in-"), outer)}
+	case d.eat("TW"):
+		return &dprefixed{prefix: "TLS wrapper function for ", child: d.name(nil)}
+	case d.eat("TH"):
+		return &dprefixed{prefix: "TLS init function for ", child: d.name(nil)}
+	case d.eat("TA"):
+		return &dprefixed{prefix: "template parameter object for ", child: d.templateArg()}
+	case d.eat("T"):
+		virtual := d.peek(0) == 'v'
+		d.callOffset()
+		if virtual {
+			return &dprefixed{prefix: "virtual thunk to ", child: d.encoding()}
+		}
+		return &dprefixed{prefix: "non-virtual thunk to ", child: d.encoding()}
+	case d.eat("GV"):
+		return &dprefixed{prefix: "guard variable for ", child: d.name(nil)}
+	case d.eat("GR"):
+		n := d.name(nil)
+		i := d.seqID()
+		return &dprefixed{prefix: "reference temporary #" + strconv.Itoa(i) + " for ", child: n}
+	case d.eat("GA"):
+		return &dprefixed{prefix: "hidden alias for ", child: d.encoding()}
+	case d.eat("GTt"):
+		return &dprefixed{prefix: "transaction clone for ", child: d.encoding()}
+	case d.eat("GTn"):
+		return &dprefixed{prefix: "non-transaction clone for ", child: d.encoding()}
+	}
+	d.fail()
+	return nil
+}
+
+// callOffset parses h <number> _ or v <number> _ <number> _.
+func (d *itanium) callOffset() {
+	switch d.next() {
+	case 'h':
+		d.number()
+		d.expect("_")
+	case 'v':
+		d.number()
+		d.expect("_")
+		d.number()
+		d.expect("_")
+	default:
+		d.fail()
+	}
+}
+
+func (d *itanium) name(st *nameState) dnode {
+	defer d.enter()()
+	d.eat("L")
+	switch d.peek(0) {
+	case 'N':
+		return d.nestedName(st)
+	case 'Z':
+		return d.localName(st)
+	}
+	var n dnode
+	isSub := false
+	switch {
+	case d.peek(0) == 'S' && d.peek(1) != 't':
+		n, isSub = d.substitution(), true
+	case d.eat("St"):
+		d.eat("L")
+		n = &dnested{scope: dname("std"), name: d.unqualifiedName(st, nil)}
+	default:
+		n = d.unqualifiedName(st, nil)
+	}
+	if d.peek(0) == 'I' {
+		if !isSub {
+			d.subs = append(d.subs, n)
+		}
+		n = &dtemplate{name: n, args: d.templateArgs(st != nil)}
+		if st != nil {
+			st.endsWithTargs = true
+		}
+	} else if isSub {
+		d.fail()
+	}
+	return n
+}
+
+func (d *itanium) nestedName(st *nameState) dnode {
+	d.expect("N")
+	quals := d.cvQuals()
+	ref := ""
+	if d.eat("R") {
+		ref = " &"
+	} else if d.eat("O") {
+		ref = " &&"
+	}
+	if st != nil {
+		st.cv, st.ref = quals, ref
+	}
+	var soFar dnode
+	push := func(c dnode) {
+		if soFar == nil {
+			soFar = c
+		} else {
+			soFar = &dnested{scope: soFar, name: c}
+		}
+		if st != nil {
+			st.endsWithTargs = false
+		}
+	}
+	if d.eat("St") {
+		soFar = dname("std")
+	}
+	for !d.eat("E") {
+		d.eat("L")
+		switch c := d.peek(0); {
+		case c == 'M':
+			// the closure type of a lambda in a data member initializer
+			if soFar == nil {
+				d.fail()
+			}
+			d.pos++
+			continue
+		case c == 'T':
+			push(d.templateParam())
+		case c == 'I':
+			if soFar == nil {
+				d.fail()
+			}
+			soFar = &dtemplate{name: soFar, args: d.templateArgs(st != nil)}
+			if st != nil {
+				st.endsWithTargs = true
+			}
+		case c == 'D' && (d.peek(1) == 't' || d.peek(1) == 'T'):
+			push(d.decltype())
+		case c == 'S' && d.peek(1) != 't':
+			s := d.substitution()
+			push(s)
+			if soFar != s {
+				d.subs = append(d.subs, soFar)
+			}
+			continue
+		case c == 'C' || c == 'D' && d.peek(1) != 'C':
+			if soFar == nil {
+				d.fail()
+			}
+			push(d.ctorDtorName(soFar, st))
+			soFar = d.abiTags(soFar)
+		default:
+			push(d.unqualifiedName(st, soFar))
+		}
+		d.subs = append(d.subs, soFar)
+	}
+	if soFar == nil || len(d.subs) == 0 {
+		d.fail()
+	}
+	d.subs = d.subs[:len(d.subs)-1]
+	return soFar
+}
+
+// ctorDtorName parses a constructor or destructor of the class soFar
+// names.
+func (d *itanium) ctorDtorName(soFar dnode, st *nameState) dnode {
+	for {
+		switch n := soFar.(type) {
+		case *dnested:
+			soFar = n.name
+			continue
+		case *dtemplate:
+			soFar = n.name
+			continue
+		case *dsuffixed:
+			soFar = n.child
+			continue
+		}
+		break
+	}
+	var base dnode = soFar
+	if s, ok := soFar.(*dsub); ok {
+		base = dname(s.ctor)
+	}
+	if st != nil {
+		st.ctorDtorConvName = true
+	}
+	if d.eat("C") {
+		inheriting := d.eat("I")
+		if c := d.next(); c < '1' || c > '5' {
+			d.fail()
+		}
+		if inheriting {
+			d.name(nil)
+		}
+		return base
+	}
+	d.expect("D")
+	if c := d.next(); c != '0' && c != '1' && c != '2' && c != '4' && c != '5' {
+		d.fail()
+	}
+	return &dprefixed{prefix: "~", child: base}
+}
+
+func (d *itanium) localName(st *nameState) dnode {
+	d.expect("Z")
+	enc := d.encoding()
+	d.expect("E")
+	if e, ok := enc.(*dencoding); ok {
+		// The scope of a local name is printed without its return type.
+		e.ret = nil
+	}
+	if d.eat("s") {
+		d.discriminator()
+		return &dnested{scope: enc, name: dname("string literal")}
+	}
+	if d.eat("d") {
+		n := d.index()
+		entity := d.name(st)
+		return &dnested{scope: enc, name: &dnested{scope: dname("{default arg#" + strconv.Itoa(n+1) + "}"), name: entity}}
+	}
+	entity := d.name(st)
+	d.discriminator()
+	return &dnested{scope: enc, name: entity}
+}
+
+// unqualifiedName parses a source, operator or unnamed type name, with
+// its ABI tags; scope is the name's, nil outside a nested name.
+func (d *itanium) unqualifiedName(st *nameState, scope dnode) dnode {
+	var n dnode
+	switch c := d.peek(0); {
+	case c == 'U':
+		n = d.unnamedTypeName()
+	case c == 'D' && d.peek(1) == 'C':
+		d.pos += 2
+		var names []dnode
+		for !d.eat("E") {
+			names = append(names, d.sourceName())
+		}
+		n = seq(dname("["), &dargpack{elems: names}, dname("]"))
+	case isDigit(c):
+		n = d.sourceName()
+	default:
+		n = d.operatorName(st)
+	}
+	return d.abiTags(n)
+}
+
+func (d *itanium) abiTags(n dnode) dnode {
+	for d.eat("B") {
+		n = &dsuffixed{child: n, suffix: "[abi:" + string(d.sourceName().(dname)) + "]"}
+	}
+	return n
+}
+
+func (d *itanium) sourceName() dnode {
+	start := d.pos
+	for isDigit(d.peek(0)) {
+		d.pos++
+	}
+	n, err := strconv.Atoi(d.s[start:d.pos])
+	if err != nil || n <= 0 || d.pos+n > len(d.s) {
+		d.fail()
+	}
+	id := d.s[d.pos : d.pos+n]
+	d.pos += n
+	if strings.HasPrefix(id, "_GLOBAL_") && len(id) > 9 && (id[8] == '.' || id[8] == '_' || id[8] == '$') && id[9] == 'N' {
+		return dname("(anonymous namespace)")
+	}
+	return dname(id)
+}
+
+func (d *itanium) unnamedTypeName() dnode {
+	switch {
+	case d.eat("Ut"):
+		return dname("{unnamed type#" + strconv.Itoa(d.index()+1) + "}")
+	case d.eat("Ul"):
+		var params []dnode
+		if !d.eat("vE") {
+			for !d.eat("E") {
+				params = append(params, d.typ())
+			}
+		}
+		return &dlambda{params: params, n: strconv.Itoa(d.index() + 1)}
+	}
+	d.fail()
+	return nil
+}
+
+func (d *itanium) operatorName(st *nameState) dnode {
+	switch {
+	case d.eat("cv"):
+		fwd, notargs := d.allowForward, d.noTargs
+		d.allowForward, d.noTargs = st != nil, true
+		t := d.typ()
+		d.allowForward, d.noTargs = fwd, notargs
+		if st != nil {
+			st.ctorDtorConvName = true
+		}
+		return &dprefixed{prefix: "operator ", child: t}
+	case d.eat("li"):
+		return &dprefixed{prefix: `operator"" `, child: d.sourceName()}
+	case d.peek(0) == 'v' && isDigit(d.peek(1)):
+		d.pos += 2
+		return &dprefixed{prefix: "operator ", child: d.sourceName()}
+	}
+	if d.pos+2 > len(d.s) {
+		d.fail()
+	}
+	op, ok := itaniumOps[d.s[d.pos:d.pos+2]]
+	if !ok {
+		d.fail()
+	}
+	d.pos += 2
+	if op.name[0] >= 'a' && op.name[0] <= 'z' {
+		return dname("operator " + op.name)
+	}
+	return dname("operator" + op.name)
+}
+
+func (d *itanium) substitution() dnode {
+	d.expect("S")
+	if sub, ok := itaniumSubs[d.peek(0)]; ok {
+		d.pos++
+		s := sub
+		return &s
+	}
+	i := d.seqID()
+	if i >= len(d.subs) {
+		d.fail()
+	}
+	return d.subs[i]
+}
+
+// templateArgs parses I <template-arg>* E; tag makes them the arguments
+// T_ refers to from now on.
+func (d *itanium) templateArgs(tag bool) []dnode {
+	d.expect("I")
+	notargs := d.noTargs
+	d.noTargs = false
+	defer func() { d.noTargs = notargs }()
+	var args, params []dnode
+	for !d.eat("E") {
+		a := d.templateArg()
+		args = append(args, a)
+		if p, ok := a.(*dargpack); ok {
+			params = append(params, &dpack{elems: p.elems})
+		} else {
+			params = append(params, a)
+		}
+	}
+	if tag {
+		d.targs = params
+	}
+	return args
+}
+
+func (d *itanium) templateArg() dnode {
+	switch d.peek(0) {
+	case 'X':
+		d.pos++
+		e := d.expression()
+		d.expect("E")
+		return e
+	case 'L':
+		return d.exprPrimary()
+	case 'J':
+		d.pos++
+		var elems []dnode
+		for !d.eat("E") {
+			elems = append(elems, d.templateArg())
+		}
+		return &dargpack{elems: elems}
+	}
+	return d.typ()
+}
+
+func (d *itanium) templateParam() dnode {
+	d.expect("T")
+	i := d.index()
+	if i < len(d.targs) && !d.allowForward {
+		return d.targs[i]
+	}
+	if !d.allowForward {
+		d.fail()
+	}
+	f := &dtparam{}
+	d.forwards = append(d.forwards, f)
+	d.forwardN = append(d.forwardN, i)
+	return f
+}
+
+func (d *itanium) decltype() dnode {
+	d.expect("D")
+	if c := d.next(); c != 't' && c != 'T' {
+		d.fail()
+	}
+	e := d.expression()
+	d.expect("E")
+	return seq(dname("decltype ("), e, dname(")"))
+}
+
+func (d *itanium) cvQuals() string {
+	q := ""
+	r := d.eat("r")
+	v := d.eat("V")
+	if d.eat("K") {
+		q += " const"
+	}
+	if v {
+		q += " volatile"
+	}
+	if r {
+		q += " restrict"
+	}
+	return q
+}
+
+// typ parses a <type>, adding it to the substitutions unless it is a
+// builtin one or a substitution.
+func (d *itanium) typ() dnode {
+	defer d.enter()()
+	var t dnode
+	switch c := d.peek(0); c {
+	case 'r', 'V', 'K':
+		i := 0
+		for strings.IndexByte("rVK", d.peek(i)) >= 0 {
+			i++
+		}
+		if d.peek(i) == 'F' || d.peek(i) == 'D' && strings.IndexByte("oOwx", d.peek(i+1)) >= 0 {
+			t = d.functionType()
+			break
+		}
+		q := d.cvQuals()
+		t = &dcv{child: d.typ(), quals: q}
+	case 'U':
+		d.pos++
+		ext := string(d.sourceName().(dname))
+		if d.peek(0) == 'I' {
+			p := &dprinter{packMax: -1}
+			p.print(&dtemplate{name: dname(ext), args: d.templateArgs(false)})
+			ext = string(p.b)
+		}
+		t = &dsuffixed{child: d.typ(), suffix: " " + ext}
+	case 'u':
+		d.pos++
+		t = d.sourceName()
+		if d.peek(0) == 'I' {
+			t = &dtemplate{name: t, args: d.templateArgs(false)}
+		}
+	case 'D':
+		c1 := d.peek(1)
+		if name, ok := itaniumDBuiltins[c1]; ok {
+			d.pos += 2
+			return dname(name)
+		}
+		switch c1 {
+		case 'F':
+			d.pos += 2
+			n := d.number()
+			if d.eat("b") {
+				return dname("std::bfloat16_t")
+			}
+			if d.eat("x") {
+				return dname("_Float" + n + "x")
+			}
+			d.expect("_")
+			return dname("_Float" + n)
+		case 'B', 'U':
+			d.pos += 2
+			kind := "signed"
+			if c1 == 'U' {
+				kind = "unsigned"
+			}
+			var bits dnode
+			if isDigit(d.peek(0)) {
+				bits = dname(d.number())
+			} else {
+				bits = d.expression()
+			}
+			d.expect("_")
+			return seq(dname(kind+" _BitInt("), bits, dname(")"))
+		case 't', 'T':
+			t = d.decltype()
+		case 'p':
+			d.pos += 2
+			t = &dexpansion{child: d.typ()}
+		case 'v':
+			d.pos += 2
+			var dim dnode
+			if isDigit(d.peek(0)) {
+				dim = dname(d.number())
+			} else {
+				d.expect("_")
+				dim = d.expression()
+			}
+			d.expect("_")
+			t = seq(d.typ(), dname(" __vector("), dim, dname(")"))
+		case 'o', 'O', 'w', 'x':
+			t = d.functionType()
+		default:
+			d.fail()
+		}
+	case 'F':
+		t = d.functionType()
+	case 'A':
+		d.pos++
+		var dim dnode
+		switch {
+		case d.eat("_"):
+		case isDigit(d.peek(0)):
+			dim = dname(d.number())
+			d.expect("_")
+		default:
+			dim = d.expression()
+			d.expect("_")
+		}
+		t = &darray{elem: d.typ(), dim: dim}
+	case 'M':
+		d.pos++
+		class := d.typ()
+		t = &dptrmem{class: class, member: d.typ()}
+	case 'T':
+		if c1 := d.peek(1); c1 == 's' || c1 == 'u' || c1 == 'e' {
+			d.pos += 2
+			t = d.name(nil)
+			break
+		}
+		t = d.templateParam()
+		if d.peek(0) == 'I' && !d.noTargs {
+			d.subs = append(d.subs, t)
+			t = &dtemplate{name: t, args: d.templateArgs(false)}
+		}
+	case 'P':
+		d.pos++
+		t = &dptr{pointee: d.typ(), sym: "*"}
+	case 'R', 'O':
+		d.pos++
+		sym := "&"
+		if c == 'O' {
+			sym = "&&"
+		}
+		t = &dptr{pointee: d.typ(), sym: sym}
+	case 'C':
+		d.pos++
+		t = &dsuffixed{child: d.typ(), suffix: " _Complex"}
+	case 'G':
+		d.pos++
+		t = &dsuffixed{child: d.typ(), suffix: " _Imaginary"}
+	case 'S':
+		if d.peek(1) != 't' {
+			s := d.substitution()
+			if d.peek(0) != 'I' || d.noTargs {
+				return s
+			}
+			t = &dtemplate{name: s, args: d.templateArgs(false)}
+			break
+		}
+		t = d.name(nil)
+	default:
+		if name, ok := itaniumBuiltins[c]; ok {
+			d.pos++
+			return dname(name)
+		}
+		t = d.name(nil)
+	}
+	d.subs = append(d.subs, t)
+	return t
+}
+
+// functionType parses [<CV-qualifiers>] [<exception-spec>] [Dx] F [Y]
+// <bare-function-type> [<ref-qualifier>] E.
+func (d *itanium) functionType() dnode {
+	f := &dfunc{cv: d.cvQuals()}
+	switch {
+	case d.eat("Do"):
+		f.spec = " noexcept"
+	case d.eat("DO"):
+		e := d.expression()
+		d.expect("E")
+		p := &dprinter{packMax: -1}
+		p.print(e)
+		f.spec = " noexcept(" + string(p.b) + ")"
+	case d.eat("Dw"):
+		var ts []dnode
+		for !d.eat("E") {
+			ts = append(ts, d.typ())
+		}
+		p := &dprinter{packMax: -1}
+		p.list(ts)
+		f.spec = " throw(" + string(p.b) + ")"
+	}
+	d.eat("Dx")
+	d.expect("F")
+	d.eat("Y")
+	f.ret = d.typ()
+	for {
+		switch {
+		case d.eat("E"):
+			return f
+		case d.eat("v"):
+			continue
+		case d.eat("RE"):
+			f.ref = " &"
+			return f
+		case d.eat("OE"):
+			f.ref = " &&"
+			return f
+		}
+		f.params = append(f.params, d.typ())
+	}
+}
+
+// exprPrimary parses L <type> <value> E, L _Z <encoding> E and the like.
+func (d *itanium) exprPrimary() dnode {
+	d.expect("L")
+	if d.eat("_Z") || d.eat("Z") {
+		e := d.encoding()
+		d.expect("E")
+		return e
+	}
+	if d.eat("DnE") || d.eat("Dn0E") {
+		return dname("nullptr")
+	}
+	if d.eat("b0E") {
+		return dname("false")
+	}
+	if d.eat("b1E") {
+		return dname("true")
+	}
+	t := d.typ()
+	start := d.pos
+	for d.peek(0) != 'E' {
+		d.next()
+	}
+	v := d.s[start:d.pos]
+	d.pos++
+	if strings.HasPrefix(v, "n") {
+		v = "-" + v[1:]
+	}
+	if name, ok := t.(dname); ok {
+		if suffix, ok := map[dname]string{"int": "", "unsigned int": "u", "long": "l",
+			"unsigned long": "ul", "long long": "ll", "unsigned long long": "ull"}[name]; ok {
+			return dname(v + suffix)
+		}
+	}
+	return seq(dname("("), t, dname(")"), dname(v))
+}
+
+// expression parses an <expression>, printed the way it was written.
+func (d *itanium) expression() dnode {
+	defer d.enter()()
+	global := d.eat("gs")
+	switch c := d.peek(0); {
+	case c == 'L':
+		return d.exprPrimary()
+	case c == 'T':
+		return d.templateParam()
+	case c == 'f' && d.peek(1) == 'p':
+		d.pos += 2
+		d.cvQuals()
+		return dname("{parm#" + strconv.Itoa(d.index()+1) + "}")
+	case c == 'f' && d.peek(1) == 'L':
+		d.pos += 2
+		d.number()
+		d.expect("p")
+		d.cvQuals()
+		return dname("{parm#" + strconv.Itoa(d.index()+1) + "}")
+	}
+	two := ""
+	if d.pos+2 <= len(d.s) {
+		two = d.s[d.pos : d.pos+2]
+	}
+	switch two {
+	case "cl":
+		d.pos += 2
+		callee := d.expression()
+		var args []dnode
+		for !d.eat("E") {
+			args = append(args, d.expression())
+		}
+		switch c := callee.(type) {
+		case dname:
+		case *dnested:
+			if _, ok := c.name.(*dtemplate); ok {
+				callee = seq(dname("("), callee, dname(")"))
+			}
+		default:
+			callee = seq(dname("("), callee, dname(")"))
+		}
+		return seq(callee, dname("("), &dargpack{elems: args}, dname(")"))
+	case "cv":
+		d.pos += 2
+		t := d.typ()
+		if d.eat("_") {
+			var args []dnode
+			for !d.eat("E") {
+				args = append(args, d.expression())
+			}
+			return seq(dname("("), t, dname(")("), &dargpack{elems: args}, dname(")"))
+		}
+		return seq(dname("("), t, dname(")("), d.expression(), dname(")"))
+	case "tl":
+		d.pos += 2
+		t := d.typ()
+		var args []dnode
+		for !d.eat("E") {
+			args = append(args, d.expression())
+		}
+		return seq(t, dname("{"), &dargpack{elems: args}, dname("}"))
+	case "il":
+		d.pos += 2
+		var args []dnode
+		for !d.eat("E") {
+			args = append(args, d.expression())
+		}
+		return seq(dname("{"), &dargpack{elems: args}, dname("}"))
+	case "st", "at":
+		d.pos += 2
+		op := map[string]string{"st": "sizeof (", "at": "alignof ("}[two]
+		return seq(dname(op), d.typ(), dname(")"))
+	case "sz", "az", "nx":
+		d.pos += 2
+		op := map[string]string{"sz": "sizeof (", "az": "alignof (", "nx": "noexcept ("}[two]
+		return seq(dname(op), d.expression(), dname(")"))
+	case "sZ":
+		d.pos += 2
+		var e dnode
+		if d.peek(0) == 'T' {
+			e = d.templateParam()
+		} else {
+			e = d.expression()
+		}
+		return seq(dname("sizeof...("), e, dname(")"))
+	case "sP":
+		d.pos += 2
+		var args []dnode
+		for !d.eat("E") {
+			args = append(args, d.templateArg())
+		}
+		return seq(dname("sizeof...("), &dargpack{elems: args}, dname(")"))
+	case "sp":
+		d.pos += 2
+		return &dexpansion{child: d.expression()}
+	case "ti":
+		d.pos += 2
+		return seq(dname("typeid ("), d.typ(), dname(")"))
+	case "te":
+		d.pos += 2
+		return seq(dname("typeid ("), d.expression(), dname(")"))
+	case "tw":
+		d.pos += 2
+		return &dprefixed{prefix: "throw ", child: d.expression()}
+	case "tr":
+		d.pos += 2
+		return dname("throw")
+	case "dc", "sc", "cc", "rc":
+		d.pos += 2
+		cast := map[string]string{"dc": "dynamic_cast<", "sc": "static_cast<", "cc": "const_cast<", "rc": "reinterpret_cast<"}[two]
+		t := d.typ()
+		return seq(dname(cast), t, dname(">("), d.expression(), dname(")"))
+	case "dt", "pt":
+		d.pos += 2
+		obj := d.expression()
+		op := map[string]string{"dt": ".", "pt": "->"}[two]
+		return seq(obj, dname(op), d.unresolvedName())
+	case "ds":
+		d.pos += 2
+		obj := d.expression()
+		return seq(obj, dname(".*"), d.expression())
+	case "qu":
+		d.pos += 2
+		a, b := d.expression(), d.expression()
+		return seq(dname("("), a, dname(") ? ("), b, dname(") : ("), d.expression(), dname(")"))
+	case "ix":
+		d.pos += 2
+		a := d.expression()
+		return seq(dname("("), a, dname(")["), d.expression(), dname("]"))
+	case "nw", "na":
+		d.pos += 2
+		var place []dnode
+		for !d.eat("_") {
+			place = append(place, d.expression())
+		}
+		t := d.typ()
+		var init dnode = dname("")
+		if d.eat("pi") {
+			var args []dnode
+			for !d.eat("E") {
+				args = append(args, d.expression())
+			}
+			init = seq(dname("("), &dargpack{elems: args}, dname(")"))
+		} else {
+			d.expect("E")
+		}
+		op := map[string]string{"nw": "new ", "na": "new[] "}[two]
+		if len(place) > 0 {
+			return seq(dname(op+"("), &dargpack{elems: place}, dname(") "), t, init)
+		}
+		return seq(dname(op), t, init)
+	case "dl", "da":
+		d.pos += 2
+		op := map[string]string{"dl": "delete ", "da": "delete[] "}[two]
+		if global {
+			op = "::" + op
+		}
+		return &dprefixed{prefix: op, child: d.expression()}
+	case "sr":
+		return d.unresolvedName()
+	}
+	if op, ok := itaniumOps[two]; ok && op.arity <= 2 {
+		d.pos += 2
+		if op.arity == 1 {
+			operand := d.expression()
+			if two == "pp" || two == "mm" {
+				// the _ form is the prefix operator
+				return seq(dname("("), operand, dname(")"+op.name))
+			}
+			return seq(dname(op.name+"("), operand, dname(")"))
+		}
+		a := d.expression()
+		b := d.expression()
+		parts := []dnode{dname("("), a, dname(")" + op.name + "("), b, dname(")")}
+		if op.name == ">" || op.name == ">>" || op.name == ">=" || op.name == ">>=" {
+			// keep the expression from closing a template argument list
+			parts = append([]dnode{dname("(")}, append(parts, dname(")"))...)
+		}
+		return seq(parts...)
+	}
+	n := d.unresolvedName()
+	if global {
+		return &dprefixed{prefix: "::", child: n}
+	}
+	return n
+}
+
+// unresolvedName parses an <unresolved-name>, the names of dependent
+// expressions.
+func (d *itanium) unresolvedName() dnode {
+	if d.eat("sr") {
+		var q dnode
+		nested := d.eat("N")
+		switch {
+		case d.peek(0) == 'T':
+			q = d.templateParam()
+			if d.peek(0) == 'I' {
+				q = &dtemplate{name: q, args: d.templateArgs(false)}
+			}
+		case d.peek(0) == 'D':
+			q = d.decltype()
+		case d.peek(0) == 'S':
+			q = d.substitution()
+			if d.peek(0) == 'I' {
+				q = &dtemplate{name: q, args: d.templateArgs(false)}
+			}
+		case isDigit(d.peek(0)):
+			q = d.simpleID()
+			for !nested && isDigit(d.peek(0)) {
+				q = &dnested{scope: q, name: d.simpleID()}
+			}
+			if !nested {
+				d.expect("E")
+			}
+		default:
+			d.fail()
+		}
+		if nested {
+			for !d.eat("E") {
+				q = &dnested{scope: q, name: d.simpleID()}
+			}
+		}
+		return &dnested{scope: q, name: d.baseUnresolvedName()}
+	}
+	return d.baseUnresolvedName()
+}
+
+func (d *itanium) simpleID() dnode {
+	n := d.sourceName()
+	if d.peek(0) == 'I' {
+		return &dtemplate{name: n, args: d.templateArgs(false)}
+	}
+	return n
+}
+
+func (d *itanium) baseUnresolvedName() dnode {
+	switch {
+	case isDigit(d.peek(0)):
+		return d.simpleID()
+	case d.eat("dn"):
+		if isDigit(d.peek(0)) {
+			return &dprefixed{prefix: "~", child: d.simpleID()}
+		}
+		return &dprefixed{prefix: "~", child: d.typ()}
+	}
+	d.eat("on")
+	n := d.operatorName(nil)
+	if d.peek(0) == 'I' {
+		return &dtemplate{name: n, args: d.templateArgs(false)}
+	}
+	return n
+}
@@ -0,0 +1,739 @@
+package profiler
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// demangleRust demangles the Rust symbol s, of the legacy mangling (an
+// Itanium nested name ending in a hash) or of v0 (_R…).
+// Suffixes the compilers add after the mangling, as in foo.0 or
+// foo.cold, are kept; LLVM's .llvm.<hash> is dropped.
+func demangleRust(s string) (string, bool) {
+	if i := strings.Index(s, ".llvm."); i > 0 {
+		s = s[:i]
+	}
+	if strings.HasPrefix(s, "_R") {
+		return demangleRustV0(s[2:])
+	}
+	return demangleRustLegacy(s)
+}
+
+// demangleRustLegacy demangles _ZN <length> <ident>… 17h<hash> E, joining
+// the idents with :: less the hash and undoing their $ escapes.
+func demangleRustLegacy(s string) (string, bool) {
+	if !strings.HasPrefix(s, "_ZN") {
+		return "", false
+	}
+	rest := s[3:]
+	var parts []string
+	for !strings.HasPrefix(rest, "E") {
+		i := 0
+		for i < len(rest) && isDigit(rest[i]) {
+			i++
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil || n == 0 || i+n > len(rest) {
+			return "", false
+		}
+		parts = append(parts, rest[i:i+n])
+		rest = rest[i+n:]
+	}
+	if rest != "E" && !strings.HasPrefix(rest, "E.") || len(parts) < 2 {
+		return "", false
+	}
+	hash := parts[len(parts)-1]
+	if len(hash) != 17 || hash[0] != 'h' || strings.Trim(hash[1:], "0123456789abcdef") != "" {
+		return "", false
+	}
+	var b strings.Builder
+	for i, p := range parts[:len(parts)-1] {
+		if i > 0 {
+			b.WriteString("::")
+		}
+		if !rustUnescape(&b, p) {
+			return "", false
+		}
+	}
+	return b.String() + rest[1:], true
+}
+
+var rustEscapes = map[string]string{
+	"SP": "@", "BP": "*", "RF": "&", "LT": "<", "GT": ">", "LP": "(", "RP": ")", "C": ",",
+}
+
+// rustUnescape writes the legacy ident p with its escapes undone.
+func rustUnescape(b *strings.Builder, p string) bool {
+	if strings.HasPrefix(p, "_$") {
+		p = p[1:] // an ident cannot start with $
+	}
+	for p != "" {
+		switch {
+		case strings.HasPrefix(p, ".."):
+			b.WriteString("::")
+			p = p[2:]
+		case p[0] == '$':
+			end := strings.IndexByte(p[1:], '$')
+			if end < 0 {
+				return false
+			}
+			esc := p[1 : 1+end]
+			p = p[2+end:]
+			if r, ok := rustEscapes[esc]; ok {
+				b.WriteString(r)
+				continue
+			}
+			if !strings.HasPrefix(esc, "u") {
+				return false
+			}
+			c, err := strconv.ParseUint(esc[1:], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(c)) {
+				return false
+			}
+			b.WriteRune(rune(c))
+		default:
+			b.WriteByte(p[0])
+			p = p[1:]
+		}
+	}
+	return true
+}
+
+// rustV0 demangles the v0 mangling, printing as it parses; a backref
+// parses again, from the position it refers to. The output follows
+// rustc-demangle's alternate form, which leaves out the crates'
+// disambiguators and the types of integer constants.
+type rustV0 struct {
+	s     string
+	pos   int
+	depth int
+	quiet int // > 0 while parsing what is not printed, an impl's path
+	out   strings.Builder
+	// boundLifetimes is the depth of the for<'a> binders in scope.
+	boundLifetimes uint64
+}
+
+func demangleRustV0(s string) (out string, ok bool) {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, bad := r.(badMangling); !bad {
+				panic(r)
+			}
+			out, ok = "", false
+		}
+	}()
+	d := &rustV0{s: s}
+	d.path(true)
+	if c := d.peek(); c >= 'A' && c <= 'Z' {
+		// the instantiating crate
+		d.quiet++
+		d.path(false)
+		d.quiet--
+	}
+	if d.pos < len(d.s) && d.s[d.pos] != '.' && d.s[d.pos] != '$' {
+		return "", false
+	}
+	return d.out.String() + d.s[d.pos:], true
+}
+
+func (d *rustV0) fail() { panic(badMangling{}) }
+
+func (d *rustV0) print(s string) {
+	if d.quiet == 0 {
+		d.out.WriteString(s)
+	}
+}
+
+func (d *rustV0) peek() byte {
+	if d.pos < len(d.s) {
+		return d.s[d.pos]
+	}
+	return 0
+}
+
+func (d *rustV0) eat(c byte) bool {
+	if d.peek() == c && d.pos < len(d.s) {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *rustV0) next() byte {
+	if d.pos >= len(d.s) {
+		d.fail()
+	}
+	d.pos++
+	return d.s[d.pos-1]
+}
+
+func (d *rustV0) enter() func() {
+	d.depth++
+	if d.depth > 500 {
+		d.fail()
+	}
+	return func() { d.depth-- }
+}
+
+// integer62 parses a <base-62-number>: _ is 0, digits then _ one more
+// than their value.
+func (d *rustV0) integer62() uint64 {
+	if d.eat('_') {
+		return 0
+	}
+	var x uint64
+	for !d.eat('_') {
+		c := d.next()
+		var v uint64
+		switch {
+		case isDigit(c):
+			v = uint64(c - '0')
+		case c >= 'a' && c <= 'z':
+			v = 10 + uint64(c-'a')
+		case c >= 'A' && c <= 'Z':
+			v = 36 + uint64(c-'A')
+		default:
+			d.fail()
+		}
+		hi, lo := bits.Mul64(x, 62)
+		if hi != 0 || lo+v < lo {
+			d.fail()
+		}
+		x = lo + v
+	}
+	if x+1 == 0 {
+		d.fail()
+	}
+	return x + 1
+}
+
+// optInteger62 parses tag <base-62-number>, 0 without the tag.
+func (d *rustV0) optInteger62(tag byte) uint64 {
+	if !d.eat(tag) {
+		return 0
+	}
+	return d.integer62() + 1
+}
+
+func (d *rustV0) disambiguator() uint64 { return d.optInteger62('s') }
+
+// ident parses an <identifier> less its disambiguator, decoding punycode.
+func (d *rustV0) ident() string {
+	puny := d.eat('u')
+	start := d.pos
+	if d.eat('0') {
+		// 0 is the only number with a leading 0
+	} else {
+		for isDigit(d.peek()) {
+			d.pos++
+		}
+	}
+	if d.pos == start {
+		d.fail()
+	}
+	n, err := strconv.Atoi(d.s[start:d.pos])
+	if err != nil {
+		d.fail()
+	}
+	d.eat('_')
+	if n > len(d.s)-d.pos {
+		d.fail()
+	}
+	id := d.s[d.pos : d.pos+n]
+	d.pos += n
+	if !puny {
+		return id
+	}
+	ascii, code := "", id
+	if i := strings.LastIndexByte(id, '_'); i >= 0 {
+		ascii, code = id[:i], id[i+1:]
+	}
+	s, ok := punycodeDecode(ascii, code)
+	if !ok {
+		d.fail()
+	}
+	return s
+}
+
+// punycodeDecode decodes RFC 3492 punycode, the delta string code
+// inserted into the basic characters ascii.
+func punycodeDecode(ascii, code string) (string, bool) {
+	if code == "" {
+		return "", false
+	}
+	out := []rune(ascii)
+	const (
+		base, tMin, tMax, skew = 36, 1, 26, 38
+	)
+	damp, bias, i, n := 700, 72, 0, 0x80
+	for p := 0; ; {
+		delta, w := 0, 1
+		for k := base; ; k += base {
+			t := k - bias
+			if t < tMin {
+				t = tMin
+			} else if t > tMax {
+				t = tMax
+			}
+			if p >= len(code) {
+				return "", false
+			}
+			c := code[p]
+			p++
+			var v int
+			switch {
+			case c >= 'a' && c <= 'z':
+				v = int(c - 'a')
+			case isDigit(c):
+				v = 26 + int(c-'0')
+			default:
+				return "", false
+			}
+			delta += v * w
+			if delta > 1<<30 {
+				return "", false
+			}
+			if v < t {
+				break
+			}
+			w *= base - t
+		}
+		length := len(out) + 1
+		i += delta
+		n += i / length
+		i %= length
+		if n > utf8.MaxRune {
+			return "", false
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = rune(n)
+		i++
+		if p == len(code) {
+			return string(out), true
+		}
+		delta /= damp
+		damp = 2
+		delta += delta / length
+		k := 0
+		for delta > (base-tMin)*tMax/2 {
+			delta /= base - tMin
+			k += base
+		}
+		bias = k + (base-tMin+1)*delta/(delta+skew)
+	}
+}
+
+// backref parses B <base-62-number> and runs f at the position it refers
+// to.
+func (d *rustV0) backref(f func()) {
+	start := d.pos - 1
+	i := d.integer62()
+	if i >= uint64(start) {
+		d.fail()
+	}
+	if d.quiet > 0 {
+		return
+	}
+	saved := d.pos
+	d.pos = int(i)
+	f()
+	d.pos = saved
+}
+
+// sepList runs f until E, printing sep between, and returns how many.
+func (d *rustV0) sepList(f func(), sep string) int {
+	n := 0
+	for !d.eat('E') {
+		if n > 0 {
+			d.print(sep)
+		}
+		f()
+		n++
+	}
+	return n
+}
+
+// path prints a <path>; inValue paths print generic arguments as ::<…>.
+func (d *rustV0) path(inValue bool) {
+	defer d.enter()()
+	switch tag := d.next(); tag {
+	case 'C':
+		d.disambiguator()
+		d.print(d.ident())
+	case 'N':
+		ns := d.next()
+		if !(ns >= 'A' && ns <= 'Z' || ns >= 'a' && ns <= 'z') {
+			d.fail()
+		}
+		d.path(inValue)
+		dis := d.disambiguator()
+		name := d.ident()
+		if ns >= 'a' && ns <= 'z' {
+			if name != "" {
+				d.print("::" + name)
+			}
+			return
+		}
+		d.print("::{")
+		switch ns {
+		case 'C':
+			d.print("closure")
+		case 'S':
+			d.print("shim")
+		default:
+			d.print(string(ns))
+		}
+		if name != "" {
+			d.print(":" + name)
+		}
+		d.print("#" + strconv.FormatUint(dis, 10) + "}")
+	case 'M', 'X', 'Y':
+		if tag != 'Y' {
+			d.disambiguator()
+			d.quiet++
+			d.path(false)
+			d.quiet--
+		}
+		d.print("<")
+		d.typ()
+		if tag != 'M' {
+			d.print(" as ")
+			d.path(false)
+		}
+		d.print(">")
+	case 'I':
+		d.path(inValue)
+		if inValue {
+			d.print("::")
+		}
+		d.print("<")
+		d.sepList(d.genericArg, ", ")
+		d.print(">")
+	case 'B':
+		d.backref(func() { d.path(inValue) })
+	default:
+		d.fail()
+	}
+}
+
+func (d *rustV0) genericArg() {
+	switch {
+	case d.eat('L'):
+		d.lifetime(d.integer62())
+	case d.eat('K'):
+		d.constant(false)
+	default:
+		d.typ()
+	}
+}
+
+func (d *rustV0) lifetime(lt uint64) {
+	d.print("'")
+	if lt == 0 {
+		d.print("_")
+		return
+	}
+	if lt > d.boundLifetimes {
+		d.fail()
+	}
+	depth := d.boundLifetimes - lt
+	if depth < 26 {
+		d.print(string(rune('a' + depth)))
+	} else {
+		d.print("_" + strconv.FormatUint(depth, 10))
+	}
+}
+
+// binder prints the for<'a, …> of an optional binder, then runs f with
+// its lifetimes in scope.
+func (d *rustV0) binder(f func()) {
+	n := d.optInteger62('G')
+	if n > 0 {
+		d.print("for<")
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				d.print(", ")
+			}
+			d.boundLifetimes++
+			d.lifetime(1)
+		}
+		d.print("> ")
+	}
+	f()
+	d.boundLifetimes -= n
+}
+
+var rustBasicTypes = map[byte]string{
+	'a': "i8", 'b': "bool", 'c': "char", 'd': "f64", 'e': "str", 'f': "f32",
+	'h': "u8", 'i': "isize", 'j': "usize", 'l': "i32", 'm': "u32", 'n': "i128",
+	'o': "u128", 's': "i16", 't': "u16", 'u': "()", 'v': "...", 'x': "i64",
+	'y': "u64", 'z': "!", 'p': "_",
+}
+
+func (d *rustV0) typ() {
+	defer d.enter()()
+	tag := d.next()
+	if name, ok := rustBasicTypes[tag]; ok {
+		d.print(name)
+		return
+	}
+	switch tag {
+	case 'R', 'Q':
+		d.print("&")
+		if d.eat('L') {
+			if lt := d.integer62(); lt != 0 {
+				d.lifetime(lt)
+				d.print(" ")
+			}
+		}
+		if tag == 'Q' {
+			d.print("mut ")
+		}
+		d.typ()
+	case 'P':
+		d.print("*const ")
+		d.typ()
+	case 'O':
+		d.print("*mut ")
+		d.typ()
+	case 'A', 'S':
+		d.print("[")
+		d.typ()
+		if tag == 'A' {
+			d.print("; ")
+			d.constant(true)
+		}
+		d.print("]")
+	case 'T':
+		d.print("(")
+		if d.sepList(d.typ, ", ") == 1 {
+			d.print(",")
+		}
+		d.print(")")
+	case 'F':
+		d.binder(func() {
+			unsafe := d.eat('U')
+			abi := ""
+			if d.eat('K') {
+				if d.eat('C') {
+					abi = "C"
+				} else if abi = d.ident(); abi == "" {
+					d.fail()
+				}
+			}
+			if unsafe {
+				d.print("unsafe ")
+			}
+			if abi != "" {
+				d.print(`extern "` + strings.ReplaceAll(abi, "_", "-") + `" `)
+			}
+			d.print("fn(")
+			d.sepList(d.typ, ", ")
+			d.print(")")
+			if !d.eat('u') {
+				d.print(" -> ")
+				d.typ()
+			}
+		})
+	case 'D':
+		d.print("dyn ")
+		d.binder(func() { d.sepList(d.dynTrait, " + ") })
+		if !d.eat('L') {
+			d.fail()
+		}
+		if lt := d.integer62(); lt != 0 {
+			d.print(" + ")
+			d.lifetime(lt)
+		}
+	case 'B':
+		d.backref(d.typ)
+	default:
+		d.pos--
+		d.path(false)
+	}
+}
+
+func (d *rustV0) dynTrait() {
+	open := d.pathMaybeOpenGenerics()
+	for d.eat('p') {
+		if !open {
+			d.print("<")
+			open = true
+		} else {
+			d.print(", ")
+		}
+		d.print(d.ident() + " = ")
+		d.typ()
+	}
+	if open {
+		d.print(">")
+	}
+}
+
+// pathMaybeOpenGenerics prints a path, leaving the generic arguments
+// open when it has some so dyn-trait bindings can follow.
+func (d *rustV0) pathMaybeOpenGenerics() bool {
+	switch {
+	case d.eat('B'):
+		open := false
+		d.backref(func() { open = d.pathMaybeOpenGenerics() })
+		return open
+	case d.eat('I'):
+		d.path(false)
+		d.print("<")
+		d.sepList(d.genericArg, ", ")
+		return true
+	}
+	d.path(false)
+	return false
+}
+
+// hexNibbles parses the hex digits of a constant up to their _.
+func (d *rustV0) hexNibbles() string {
+	start := d.pos
+	for {
+		c := d.next()
+		if c == '_' {
+			return d.s[start : d.pos-1]
+		}
+		if !isDigit(c) && (c < 'a' || c > 'f') {
+			d.fail()
+		}
+	}
+}
+
+// uint prints the hex digits of an integer constant in decimal when it
+// fits 64 bits, as 0x… otherwise.
+func (d *rustV0) uint() {
+	hex := strings.TrimLeft(d.hexNibbles(), "0")
+	if hex == "" {
+		d.print("0")
+		return
+	}
+	if len(hex) > 16 {
+		d.print("0x" + hex)
+		return
+	}
+	v, _ := strconv.ParseUint(hex, 16, 64)
+	d.print(strconv.FormatUint(v, 10))
+}
+
+// constant prints a <const>; one in generic argument position, not
+// inValue, is braced unless it is a literal.
+func (d *rustV0) constant(inValue bool) {
+	defer d.enter()()
+	tag := d.next()
+	braced := false
+	brace := func() {
+		if !inValue {
+			braced = true
+			d.print("{")
+		}
+	}
+	switch tag {
+	case 'p':
+		d.print("_")
+	case 'h', 't', 'm', 'y', 'o', 'j':
+		d.uint()
+	case 'a', 's', 'l', 'x', 'n', 'i':
+		if d.eat('n') {
+			d.print("-")
+		}
+		d.uint()
+	case 'b':
+		switch d.hexNibbles() {
+		case "0":
+			d.print("false")
+		case "1":
+			d.print("true")
+		default:
+			d.fail()
+		}
+	case 'c':
+		v, err := strconv.ParseUint(d.hexNibbles(), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(v)) {
+			d.fail()
+		}
+		d.print(strconv.QuoteRune(rune(v)))
+	case 'e':
+		brace()
+		d.print("*")
+		d.str()
+	case 'R', 'Q':
+		if tag == 'R' && d.eat('e') {
+			d.str()
+			break
+		}
+		brace()
+		d.print("&")
+		if tag == 'Q' {
+			d.print("mut ")
+		}
+		d.constant(true)
+	case 'A':
+		brace()
+		d.print("[")
+		d.sepList(func() { d.constant(true) }, ", ")
+		d.print("]")
+	case 'T':
+		brace()
+		d.print("(")
+		if d.sepList(func() { d.constant(true) }, ", ") == 1 {
+			d.print(",")
+		}
+		d.print(")")
+	case 'V':
+		brace()
+		d.path(true)
+		switch d.next() {
+		case 'U':
+		case 'T':
+			d.print("(")
+			d.sepList(func() { d.constant(true) }, ", ")
+			d.print(")")
+		case 'S':
+			d.print(" { ")
+			d.sepList(func() {
+				d.disambiguator()
+				d.print(d.ident() + ": ")
+				d.constant(true)
+			}, ", ")
+			d.print(" }")
+		default:
+			d.fail()
+		}
+	case 'B':
+		d.backref(func() { d.constant(inValue) })
+	default:
+		d.fail()
+	}
+	if braced {
+		d.print("}")
+	}
+}
+
+// str prints the hex-encoded UTF-8 of a string constant, quoted.
+func (d *rustV0) str() {
+	hex := d.hexNibbles()
+	if len(hex)%2 != 0 {
+		d.fail()
+	}
+	b := make([]byte, len(hex)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		if err != nil {
+			d.fail()
+		}
+		b[i] = byte(v)
+	}
+	if !utf8.Valid(b) {
+		d.fail()
+	}
+	d.print(strconv.Quote(string(b)))
+}
@@ -0,0 +1,163 @@
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Diagnostic kinds.
+const (
+	DiagSkippedModule     = "skipped_module"     // an image the counts leave out
+	DiagUnresolvedSymbols = "unresolved_symbols" // code counted or named without its symbols
+	DiagDecodeFailure     = "decode_failure"     // bytes that do not decode as instructions
+	DiagDroppedSamples    = "dropped_samples"    // counts that could not be attributed
+	DiagUnavailableEvent  = "unavailable_event"  // a PMU event that could not be opened
+	DiagMultiplexed       = "multiplexed"        // a PMU event scaled from part of the run
+	DiagCheckpointFailed  = "checkpoint_failed"  // checkpoints (Options.Checkpoint) not written
+)
+
+// Diagnostic levels, as slog names them.
+const (
+	DiagInfo = "info"
+	DiagWarn = "warn"
+)
+
+// Diagnostics is what a run could not count or attribute as asked, so
+// that numbers that look wrong have a reason in the report. Events are in
+// the order found; Warnings is the number at level DiagWarn, those that
+// leave counts short or misattributed. The DiagInfo ones follow from the
+// options or the binaries, such as the images ExcludeModule leaves out
+// and the stripped libraries named from their dynamic symbols only.
+// Every report of a run has it, with no events when nothing was amiss.
+type Diagnostics struct {
+	Warnings int          `json:"warnings"`
+	Events   []Diagnostic `json:"events"`
+}
+
+// Diagnostic is one event of a Diagnostics: a Diag kind, the image or
+// function it concerns and Count, the ops, executions, bytes or calls
+// involved when it has one. Message names neither, so that Redact covers
+// it.
+type Diagnostic struct {
+	Level    string `json:"level"`
+	Kind     string `json:"kind"`
+	Image    string `json:"image,omitempty"`
+	Function string `json:"function,omitempty"`
+	Count    uint64 `json:"count,omitempty"`
+	Message  string `json:"message"`
+}
+
+// diagnose records d in r.
+func (r *Result) diagnose(d Diagnostic) {
+	if r.Diagnostics == nil {
+		r.Diagnostics = &Diagnostics{}
+	}
+	r.Diagnostics.Events = append(r.Diagnostics.Events, d)
+}
+
+// unnamedOps is the Diagnostic of n of all the ops counted in image being
+// in code outside any function: a warning from 1% of them, below which
+// (PLT stubs and the like) the functions miss no share that matters.
+func unnamedOps(image string, n, all uint64, msg string) Diagnostic {
+	level := DiagInfo
+	if 100*n >= all {
+		level = DiagWarn
+	}
+	return Diagnostic{Level: level, Kind: DiagUnresolvedSymbols, Image: image, Count: n, Message: msg}
+}
+
+// diagnose completes the Diagnostics of res, once its run is over, and
+// logs them to Options.Logger: found, the events of the steps before the
+// run, go first, then those the backend recorded and those res shows.
+func (p *Profiler) diagnose(res *Result, found []Diagnostic) {
+	if res == nil {
+		for _, d := range found {
+			p.logDiag(d)
+		}
+		return
+	}
+	ds := res.Diagnostics
+	if ds == nil {
+		ds = &Diagnostics{}
+		res.Diagnostics = ds
+	}
+	ds.Events = append(found, ds.Events...)
+	if pf := res.Perf; pf != nil {
+		for _, e := range pf.Events {
+			switch {
+			case !e.Supported:
+				ds.Events = append(ds.Events, Diagnostic{Level: DiagWarn, Kind: DiagUnavailableEvent,
+					Message: fmt.Sprintf("%s could not be opened: %s", e.Name, e.Error)})
+			case e.Scaled:
+				ds.Events = append(ds.Events, Diagnostic{Level: DiagWarn, Kind: DiagMultiplexed,
+					Message: fmt.Sprintf("%s was multiplexed with other counters and scaled from part of the run", e.Name)})
+			}
+		}
+		for _, f := range pf.Functions {
+			if f.Dropped > 0 {
+				ds.Events = append(ds.Events, Diagnostic{Level: DiagWarn, Kind: DiagDroppedSamples, Function: f.Name, Count: f.Dropped,
+					Message: "calls returned on another CPU than they entered on; their counts are left out"})
+			}
+		}
+	}
+	ds.Warnings = 0
+	for _, d := range ds.Events {
+		if d.Level == DiagWarn {
+			ds.Warnings++
+		}
+		p.logDiag(d)
+	}
+	if ds.Events == nil {
+		ds.Events = []Diagnostic{}
+	}
+}
+
+// writeDiagnostics renders d as a section of the text report, an event a
+// line, the count (when one) leading its message.
+func writeDiagnostics(w io.Writer, d *Diagnostics) {
+	fmt.Fprintf(w, "\n----- Diagnostics -----\nWarnings: %d\n", d.Warnings)
+	if len(d.Events) == 0 {
+		fmt.Fprintln(w, "None")
+	}
+	for _, e := range d.Events {
+		fmt.Fprintf(w, "%-6s%-20s", strings.ToUpper(e.Level), e.Kind)
+		for _, s := range []string{e.Image, e.Function} {
+			if s != "" {
+				fmt.Fprintf(w, "%s: ", s)
+			}
+		}
+		if e.Count != 0 {
+			fmt.Fprintf(w, "%d ", e.Count)
+		}
+		fmt.Fprintln(w, e.Message)
+	}
+}
+
+// logDiag logs d to Options.Logger at its level.
+func (p *Profiler) logDiag(d Diagnostic) {
+	level := slog.LevelInfo
+	if d.Level == DiagWarn {
+		level = slog.LevelWarn
+	}
+	args := []any{"kind", d.Kind}
+	if d.Image != "" {
+		args = append(args, "image", d.Image)
+	}
+	if d.Function != "" {
+		args = append(args, "function", d.Function)
+	}
+	if d.Count != 0 {
+		args = append(args, "count", d.Count)
+	}
+	p.log(level, d.Message, args...)
+}
+
+// log logs msg with the key–value pairs args to Options.Logger, if any.
+func (p *Profiler) log(level slog.Level, msg string, args ...any) {
+	if l := p.opts.Logger; l != nil {
+		l.Log(context.Background(), level, msg, args...)
+	}
+}
@@ -0,0 +1,269 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Delta compares one counter between two runs.
+type Delta struct {
+	A, B uint64
+}
+
+// Abs returns B−A.
+func (d Delta) Abs() int64 { return int64(d.B) - int64(d.A) }
+
+// Pct returns the change relative to A in percent. It is +Inf when a
+// counter appears from zero and 0 when both are zero.
+func (d Delta) Pct() float64 {
+	if d.A == 0 {
+		if d.B == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return 100 * float64(d.Abs()) / float64(d.A)
+}
+
+// Thresholds decides which deltas are highlighted as regressions: a
+// counter regresses when it grows by more than Pct percent and by more
+// than MinAbs operations.
+type Thresholds struct {
+	Pct    float64
+	MinAbs uint64
+}
+
+// Regressed reports whether d exceeds t.
+func (t Thresholds) Regressed(d Delta) bool {
+	return d.B > d.A && d.B-d.A > t.MinAbs && d.Pct() > t.Pct
+}
+
+// FuncDiff is the per-category comparison of one function.
+type FuncDiff struct {
+	Name   string
+	Deltas map[string]Delta // keyed by category
+	OnlyA  bool             // function absent from run B
+	OnlyB  bool             // function absent from run A
+}
+
+// Total returns the combined delta over all categories.
+func (f FuncDiff) Total() Delta {
+	var t Delta
+	for _, d := range f.Deltas {
+		t.A += d.A
+		t.B += d.B
+	}
+	return t
+}
+
+// Diff is the comparison of two runs.
+type Diff struct {
+	Categories []string         // CategoryNames plus optional ones in either run
+	Totals     map[string]Delta // keyed by category
+	Functions  []FuncDiff       // changed functions, largest |Δ| first
+	// Policies holds the runs' CompoundPolicy when they differ: their
+	// counts of FMAs and the like are not comparable.
+	Policies *[2]string
+	// AgenModes holds the runs' AgenMode when they differ: folded
+	// address arithmetic is in one run's add and shl only.
+	AgenModes *[2]string
+}
+
+// Compare diffs run a (baseline) against run b. Functions are matched by
+// name; both runs need --funcs for the per-function section.
+func Compare(a, b *Result) *Diff {
+	cats := append([]string(nil), CategoryNames...)
+	for _, c := range BitCategoryNames {
+		_, inA := a.Categories[c]
+		_, inB := b.Categories[c]
+		if inA || inB {
+			cats = append(cats, c)
+		}
+	}
+	d := &Diff{Categories: cats, Totals: map[string]Delta{}}
+	if pa, pb := a.CompoundPolicy(), b.CompoundPolicy(); pa != pb {
+		d.Policies = &[2]string{pa, pb}
+	}
+	if ma, mb := a.AgenMode(), b.AgenMode(); ma != mb {
+		d.AgenModes = &[2]string{ma, mb}
+	}
+	for _, c := range d.Categories {
+		d.Totals[c] = Delta{A: a.Totals.Get(c), B: b.Totals.Get(c)}
+	}
+
+	byName := map[string]*FuncDiff{}
+	var order []string
+	add := func(fs []Function, fromA bool) {
+		for _, f := range fs {
+			fd, ok := byName[f.Name]
+			if !ok {
+				fd = &FuncDiff{Name: f.Name, Deltas: map[string]Delta{}, OnlyA: fromA, OnlyB: !fromA}
+				byName[f.Name] = fd
+				order = append(order, f.Name)
+			} else if !fromA {
+				fd.OnlyA = false
+			}
+			for _, c := range d.Categories {
+				dl := fd.Deltas[c]
+				if fromA {
+					dl.A += f.Get(c)
+				} else {
+					dl.B += f.Get(c)
+				}
+				fd.Deltas[c] = dl
+			}
+		}
+	}
+	add(a.Functions, true)
+	add(b.Functions, false)
+
+	for _, name := range order {
+		fd := byName[name]
+		if fd.Total().Abs() != 0 || fd.OnlyA || fd.OnlyB {
+			d.Functions = append(d.Functions, *fd)
+		}
+	}
+	sort.SliceStable(d.Functions, func(i, j int) bool {
+		return absInt(d.Functions[i].Total().Abs()) > absInt(d.Functions[j].Total().Abs())
+	})
+	return d
+}
+
+// Regressions returns the number of highlighted category totals and
+// function categories.
+func (d *Diff) Regressions(t Thresholds) int {
+	n := 0
+	for _, dl := range d.Totals {
+		if t.Regressed(dl) {
+			n++
+		}
+	}
+	for _, f := range d.Functions {
+		for _, dl := range f.Deltas {
+			if t.Regressed(dl) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// WriteText renders d as two tables (totals, per function). Rows that
+// regress beyond t are marked with '!'.
+func (d *Diff) WriteText(w io.Writer, t Thresholds) error {
+	bw := bufio.NewWriter(w)
+	if p := d.Policies; p != nil {
+		fmt.Fprintf(bw, "Note: compound instructions counted %s in A, %s in B\n\n", p[0], p[1])
+	}
+	if m := d.AgenModes; m != nil {
+		fmt.Fprintf(bw, "Note: address arithmetic counted %s in A, %s in B\n\n", m[0], m[1])
+	}
+	fmt.Fprintf(bw, "----- Totals -----\n")
+	fmt.Fprintf(bw, "  %-8s%14s%14s%14s%10s\n", "CATEGORY", "A", "B", "DELTA", "DELTA%")
+	for _, c := range d.Categories {
+		writeDeltaRow(bw, strings.ToUpper(c), d.Totals[c], t)
+	}
+
+	if len(d.Functions) > 0 {
+		fmt.Fprintf(bw, "\n----- Per-function changes -----\n")
+		for _, f := range d.Functions {
+			tag := ""
+			switch {
+			case f.OnlyA:
+				tag = "  (removed)"
+			case f.OnlyB:
+				tag = "  (new)"
+			}
+			fmt.Fprintf(bw, "%s%s\n", f.Name, tag)
+			for _, c := range d.Categories {
+				if dl := f.Deltas[c]; dl.A != 0 || dl.B != 0 {
+					writeDeltaRow(bw, strings.ToUpper(c), dl, t)
+				}
+			}
+		}
+	}
+
+	if n := d.Regressions(t); n > 0 {
+		fmt.Fprintf(bw, "\n! %d counter(s) grew by more than %.4g%%\n", n, t.Pct)
+	}
+	return bw.Flush()
+}
+
+// WriteJSON renders d as JSON: each delta with its change, its change in
+// percent (null when a counter appears from zero) and whether it regresses
+// beyond t.
+func (d *Diff) WriteJSON(w io.Writer, t Thresholds) error {
+	type delta struct {
+		A         uint64   `json:"a"`
+		B         uint64   `json:"b"`
+		Delta     int64    `json:"delta"`
+		Pct       *float64 `json:"pct"`
+		Regressed bool     `json:"regressed,omitempty"`
+	}
+	type function struct {
+		Name   string           `json:"name"`
+		OnlyA  bool             `json:"only_a,omitempty"`
+		OnlyB  bool             `json:"only_b,omitempty"`
+		Deltas map[string]delta `json:"deltas"`
+	}
+	conv := func(dl Delta) delta {
+		out := delta{A: dl.A, B: dl.B, Delta: dl.Abs(), Regressed: t.Regressed(dl)}
+		if p := dl.Pct(); !math.IsInf(p, 1) {
+			out.Pct = &p
+		}
+		return out
+	}
+	out := struct {
+		Categories  []string          `json:"categories"`
+		Policies    map[string]string `json:"compound_policies,omitempty"`
+		AgenModes   map[string]string `json:"agen_modes,omitempty"`
+		Totals      map[string]delta  `json:"totals"`
+		Functions   []function        `json:"functions,omitempty"`
+		Regressions int               `json:"regressions"`
+	}{Categories: d.Categories, Totals: map[string]delta{}, Regressions: d.Regressions(t)}
+	if p := d.Policies; p != nil {
+		out.Policies = map[string]string{"a": p[0], "b": p[1]}
+	}
+	if m := d.AgenModes; m != nil {
+		out.AgenModes = map[string]string{"a": m[0], "b": m[1]}
+	}
+	for _, c := range d.Categories {
+		out.Totals[c] = conv(d.Totals[c])
+	}
+	for _, f := range d.Functions {
+		fn := function{Name: f.Name, OnlyA: f.OnlyA, OnlyB: f.OnlyB, Deltas: map[string]delta{}}
+		for _, c := range d.Categories {
+			if dl := f.Deltas[c]; dl.A != 0 || dl.B != 0 {
+				fn.Deltas[c] = conv(dl)
+			}
+		}
+		out.Functions = append(out.Functions, fn)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func writeDeltaRow(w io.Writer, label string, d Delta, t Thresholds) {
+	mark := ' '
+	if t.Regressed(d) {
+		mark = '!'
+	}
+	pct := "new"
+	if !math.IsInf(d.Pct(), 1) {
+		pct = fmt.Sprintf("%+.2f%%", d.Pct())
+	}
+	fmt.Fprintf(w, "%c %-8s%14d%14d%+14d%10s\n", mark, label, d.A, d.B, d.Abs(), pct)
+}
+
+func absInt(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// WriteDOT renders r.Dataflow as a Graphviz digraph, for dot, xdot and the
+// scheduling tools that read DOT. Each function is a cluster; op nodes are
+// ellipses and loads, the graph's inputs, dashed boxes, labelled with
+// their op, location and executions; a cluster's label adds the symbol
+// when r names both (Result.SetNames). With a Top (Result.SetTop) the
+// functions in none of its tables are one cluster, TopOther. Edges are
+// labelled with their count, the most frequent drawn thickest.
+func (r *Result) WriteDOT(w io.Writer) error {
+	d := r.Dataflow
+	if d == nil {
+		return fmt.Errorf("%w: DOT output needs a dataflow graph (Options.Dataflow)", ErrUnsupported)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph dataflow {\n")
+	fmt.Fprintf(bw, "  node [fontname=\"monospace\" fontsize=10];\n  edge [fontname=\"monospace\" fontsize=9];\n")
+
+	// clusters in the order of their first node
+	var funcs []string
+	byFunc := map[string][]DataflowNode{}
+	var inTop map[string]bool
+	if r.Top != nil {
+		inTop = r.Top.names()
+	}
+	for _, n := range d.Nodes {
+		name := r.topFrame(inTop, n.Function)
+		if _, ok := byFunc[name]; !ok {
+			funcs = append(funcs, name)
+		}
+		byFunc[name] = append(byFunc[name], n)
+	}
+	for i, f := range funcs {
+		label := f
+		if raw := r.rawName(f); r.names == NamesBoth && raw != f {
+			label += "\n" + raw
+		}
+		fmt.Fprintf(bw, "  subgraph cluster_%d {\n    label=%s;\n", i, dotQuote(label))
+		for _, n := range byFunc[f] {
+			lines := []string{n.Op, "+" + n.Offset}
+			shape := "ellipse"
+			style := "solid"
+			if n.Kind == "load" {
+				lines[0], shape, style = "load", "box", "dashed"
+			}
+			if n.Lanes > 1 {
+				lines[0] += fmt.Sprintf(" x%d", n.Lanes)
+			}
+			if n.Line > 0 {
+				lines = append(lines, fmt.Sprintf("%s:%d", filepath.Base(n.File), n.Line))
+			}
+			lines = append(lines, fmt.Sprintf("%d execs", n.Executions))
+			fmt.Fprintf(bw, "    n%d [label=%s shape=%s style=%s tooltip=%s];\n",
+				n.ID, dotQuote(strings.Join(lines, "\n")), shape, style, dotQuote(n.Disasm))
+		}
+		fmt.Fprintf(bw, "  }\n")
+	}
+
+	var most uint64 = 1
+	for _, e := range d.Edges {
+		most = max(most, e.Count)
+	}
+	for _, e := range d.Edges {
+		fmt.Fprintf(bw, "  n%d -> n%d [label=\"%d\" penwidth=%.2f];\n", e.From, e.To, e.Count,
+			1+4*float64(e.Count)/float64(most))
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// dotQuote returns s as a DOT string, its newlines as centred line breaks.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,261 @@
+package profiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dyldCacheDirs hold the dyld shared cache on macOS 13 and later, and on
+// macOS 11 and 12. Since macOS 11 the system libraries exist only inside
+// the cache, not as files under /usr/lib or /System/Library.
+var dyldCacheDirs = []string{
+	"/System/Volumes/Preboot/Cryptexes/OS/System/Library/dyld",
+	"/System/Library/dyld",
+}
+
+// dyldCache is a dyld shared cache: a main file and the subcaches it
+// lists, mapped at fixed addresses, and the install paths of its images.
+type dyldCache struct {
+	path   string
+	maps   []dyldMapping
+	images map[string]uint64 // install path → address of its Mach-O header
+	files  []*os.File
+}
+
+type dyldMapping struct {
+	addr, size, off uint64
+	f               *os.File
+}
+
+// openDyldCache opens the arm64 (arm64e) shared cache of this system.
+func openDyldCache() (*dyldCache, error) {
+	for _, dir := range dyldCacheDirs {
+		for _, name := range []string{"dyld_shared_cache_arm64e", "dyld_shared_cache_arm64"} {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return loadDyldCache(path)
+			}
+		}
+	}
+	return nil, errors.New("no arm64 dyld shared cache")
+}
+
+// loadDyldCache reads the mappings and image list of the cache at path.
+func loadDyldCache(path string) (*dyldCache, error) {
+	c := &dyldCache{path: path, images: map[string]uint64{}}
+	main, hdr, err := c.addFile(path)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	le := binary.LittleEndian
+	mappingOff := le.Uint32(hdr[0x10:])
+
+	// the image list moved in dyld-940 (macOS 12), which also split the
+	// cache into subcaches
+	imagesOff, imagesCount := le.Uint32(hdr[0x18:]), le.Uint32(hdr[0x1c:])
+	if imagesOff == 0 && mappingOff >= 0x1c8 {
+		imagesOff, imagesCount = le.Uint32(hdr[0x1c0:]), le.Uint32(hdr[0x1c4:])
+	}
+	if mappingOff >= 0x190 {
+		subOff, subCount := le.Uint32(hdr[0x188:]), le.Uint32(hdr[0x18c:])
+		// entries are uuid and VM offset; dyld-1042 (macOS 13) adds a
+		// 32-byte file suffix
+		size := uint32(24)
+		if mappingOff > 0x1c8 {
+			size = 56
+		}
+		subs := make([]byte, subCount*size)
+		if _, err := main.ReadAt(subs, int64(subOff)); err != nil {
+			c.close()
+			return nil, fmt.Errorf("profiler: %s: subcaches: %w", path, err)
+		}
+		for i := uint32(0); i < subCount; i++ {
+			suffix := fmt.Sprintf(".%d", i+1)
+			if size == 56 {
+				s, _, _ := bytes.Cut(subs[i*size+24:(i+1)*size], []byte{0})
+				suffix = string(s)
+			}
+			if _, _, err := c.addFile(path + suffix); err != nil {
+				c.close()
+				return nil, err
+			}
+		}
+	}
+
+	infos := make([]byte, imagesCount*32)
+	if _, err := main.ReadAt(infos, int64(imagesOff)); err != nil {
+		c.close()
+		return nil, fmt.Errorf("profiler: %s: images: %w", path, err)
+	}
+	for i := uint32(0); i < imagesCount; i++ {
+		// dyld_cache_image_info: address, mtime, inode, path offset
+		e := infos[i*32:]
+		name := make([]byte, 1024)
+		n, _ := main.ReadAt(name, int64(le.Uint32(e[24:])))
+		s, _, _ := bytes.Cut(name[:n], []byte{0})
+		c.images[string(s)] = le.Uint64(e)
+	}
+	return c, nil
+}
+
+// addFile opens one cache file and records its mappings; it returns the
+// file and the first 0x200 bytes of its header.
+func (c *dyldCache) addFile(path string) (*os.File, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("profiler: %w", err)
+	}
+	c.files = append(c.files, f)
+	hdr := make([]byte, 0x200)
+	if _, err := f.ReadAt(hdr, 0); err != nil || !bytes.HasPrefix(hdr, []byte("dyld_v1")) {
+		return nil, nil, fmt.Errorf("profiler: %s: not a dyld shared cache", path)
+	}
+	le := binary.LittleEndian
+	off, count := le.Uint32(hdr[0x10:]), le.Uint32(hdr[0x14:])
+	maps := make([]byte, count*32)
+	if _, err := f.ReadAt(maps, int64(off)); err != nil {
+		return nil, nil, fmt.Errorf("profiler: %s: mappings: %w", path, err)
+	}
+	for i := uint32(0); i < count; i++ {
+		// dyld_cache_mapping_info: address, size, file offset, protections
+		m := maps[i*32:]
+		c.maps = append(c.maps, dyldMapping{le.Uint64(m), le.Uint64(m[8:]), le.Uint64(m[16:]), f})
+	}
+	return f, hdr, nil
+}
+
+func (c *dyldCache) close() {
+	for _, f := range c.files {
+		f.Close()
+	}
+}
+
+// read returns n bytes at VM address addr.
+func (c *dyldCache) read(addr, n uint64) ([]byte, error) {
+	for _, m := range c.maps {
+		if addr >= m.addr && addr+n <= m.addr+m.size {
+			b := make([]byte, n)
+			if _, err := m.f.ReadAt(b, int64(m.off+addr-m.addr)); err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("address %#x not mapped", addr)
+}
+
+// dyldSegment is an LC_SEGMENT_64 of a cache image; its file offset is
+// relative to whichever cache file holds it, so content is read by address.
+type dyldSegment struct {
+	name            string
+	addr, off, size uint64
+}
+
+// image returns the code sections and functions of the image installed at
+// path: its __TEXT,__text and the symbols of its symbol table, each sized
+// to the next one. Symbols dyld moved out of the image (local, non-exported
+// functions) are not available and their code counts in no function.
+func (c *dyldCache) image(path string) ([]codeSection, []staticFunc, error) {
+	at, ok := c.images[path]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s not in %s", os.ErrNotExist, path, c.path)
+	}
+	le := binary.LittleEndian
+	hdr, err := c.read(at, 32)
+	if err != nil || le.Uint32(hdr) != 0xfeedfacf {
+		return nil, nil, fmt.Errorf("profiler: %s: bad Mach-O header in the shared cache", path)
+	}
+	cmds, err := c.read(at+32, uint64(le.Uint32(hdr[20:])))
+	if err != nil {
+		return nil, nil, fmt.Errorf("profiler: %s: %w", path, err)
+	}
+
+	var segs []dyldSegment
+	var secs []codeSection
+	var symOff, nsyms, strOff, strSize uint32
+	for n, rest := le.Uint32(hdr[16:]), cmds; n > 0 && len(rest) >= 8; n-- {
+		cmd, size := le.Uint32(rest), le.Uint32(rest[4:])
+		if size < 8 || int(size) > len(rest) {
+			break
+		}
+		lc := rest[:size]
+		switch {
+		case cmd == 0x19 && len(lc) >= 72: // LC_SEGMENT_64
+			segs = append(segs, dyldSegment{cstring(lc[8:24]), le.Uint64(lc[24:]), le.Uint64(lc[40:]), le.Uint64(lc[48:])})
+			for i, nsects := 0, int(le.Uint32(lc[64:])); i < nsects && 72+80*(i+1) <= len(lc); i++ {
+				s := lc[72+80*i:]
+				if le.Uint32(s[64:])&machoPureInstructions == 0 {
+					continue
+				}
+				addr, size := le.Uint64(s[32:]), le.Uint64(s[40:])
+				code, err := c.read(addr, size)
+				if err != nil {
+					return nil, nil, fmt.Errorf("profiler: %s %s: %w", path, cstring(s[:16]), err)
+				}
+				secs = append(secs, codeSection{addr, code})
+			}
+		case cmd == 0x2 && len(lc) >= 24: // LC_SYMTAB
+			symOff, nsyms, strOff, strSize = le.Uint32(lc[8:]), le.Uint32(lc[12:]), le.Uint32(lc[16:]), le.Uint32(lc[20:])
+		}
+		rest = rest[size:]
+	}
+
+	// symbol and string table offsets are file offsets into __LINKEDIT
+	var funcs []staticFunc
+	for _, seg := range segs {
+		if seg.name != "__LINKEDIT" || uint64(symOff) < seg.off || uint64(strOff) < seg.off {
+			continue
+		}
+		syms, err := c.read(seg.addr+uint64(symOff)-seg.off, 16*uint64(nsyms))
+		if err != nil {
+			return nil, nil, fmt.Errorf("profiler: %s symbols: %w", path, err)
+		}
+		strs, err := c.read(seg.addr+uint64(strOff)-seg.off, uint64(strSize))
+		if err != nil {
+			return nil, nil, fmt.Errorf("profiler: %s symbol names: %w", path, err)
+		}
+		for i := uint32(0); i < nsyms; i++ {
+			// nlist_64: name offset, type, section, desc, value
+			e := syms[16*i:]
+			if typ := e[4]; typ&0xe0 != 0 || typ&0x0e != 0x0e || e[5] == 0 {
+				continue
+			}
+			addr, name := le.Uint64(e[8:]), le.Uint32(e)
+			if int(name) >= len(strs) {
+				continue
+			}
+			for _, s := range secs {
+				if addr >= s.addr && addr < s.addr+uint64(len(s.code)) {
+					funcs = append(funcs, staticFunc{machoName(cstring(strs[name:])), addr, s.addr + uint64(len(s.code))})
+					break
+				}
+			}
+		}
+	}
+	sort.SliceStable(funcs, func(i, j int) bool { return funcs[i].start < funcs[j].start })
+	for i := range funcs {
+		if i+1 < len(funcs) && funcs[i+1].start < funcs[i].end {
+			funcs[i].end = funcs[i+1].start
+		}
+	}
+	out := funcs[:0]
+	for _, fn := range funcs {
+		if fn.end > fn.start {
+			out = append(out, fn)
+		}
+	}
+	return secs, out, nil
+}
+
+// cstring returns b up to its first NUL.
+func cstring(b []byte) string {
+	s, _, _ := strings.Cut(string(b), "\x00")
+	return s
+}
@@ -0,0 +1,95 @@
+package profiler
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// ebpfFunc is a function the eBPF backend probes: its symbol address and
+// the file offset of its first instruction, where the uprobe goes.
+type ebpfFunc struct {
+	name   string
+	addr   uint64
+	offset uint64
+}
+
+// ebpfFuncs resolves Options.Func and the Options.Include globs against
+// the symbol tables of the executable at exe.
+func (p *Profiler) ebpfFuncs(exe string) ([]ebpfFunc, error) {
+	f, err := elf.Open(exe)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	sf := f
+	if df, _ := withDebug(f, exe, "", p.opts.DebugDirs); df != f {
+		defer df.Close()
+		sf = df
+	}
+	syms, err := sf.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("profiler: %s: %w", exe, err)
+	}
+	dyn, _ := f.DynamicSymbols()
+	syms = append(syms, dyn...)
+
+	pats := p.opts.Include
+	if p.opts.Func != "" {
+		pats = append([]string{p.opts.Func}, pats...)
+	}
+	seen := map[string]bool{}
+	var funcs []ebpfFunc
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Value == 0 || seen[s.Name] {
+			continue
+		}
+		match := false
+		for _, pat := range pats {
+			if ok, _ := path.Match(pat, s.Name); ok {
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		off, ok := fileOffset(f, s.Value)
+		if !ok {
+			continue
+		}
+		seen[s.Name] = true
+		funcs = append(funcs, ebpfFunc{s.Name, s.Value, off})
+	}
+	if len(funcs) == 0 {
+		return nil, fmt.Errorf("%w: no function of %s matches %q", ErrSymbolNotFound, exe, pats)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].addr < funcs[j].addr })
+	return funcs, nil
+}
+
+// fileOffset maps the virtual address addr to its offset in f through the
+// executable PT_LOAD segment that holds it.
+func fileOffset(f *elf.File, addr uint64) (uint64, bool) {
+	for _, pr := range f.Progs {
+		if pr.Type == elf.PT_LOAD && pr.Flags&elf.PF_X != 0 && addr >= pr.Vaddr && addr < pr.Vaddr+pr.Filesz {
+			return addr - pr.Vaddr + pr.Off, true
+		}
+	}
+	return 0, false
+}
+
+// ebpfEvents returns the perf backend's events for the eBPF backend, which
+// reads per-CPU counters: the per-task task-clock becomes cpu-clock.
+func ebpfEvents(evs []perfEvent) []perfEvent {
+	out := make([]perfEvent, 0, len(evs))
+	for _, e := range evs {
+		if e.typ == perfTypeSoftware && e.config == 1 {
+			e.name, e.config = "cpu-clock", 0
+		}
+		out = append(out, e)
+	}
+	return out
+}
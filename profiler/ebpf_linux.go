@@ -0,0 +1,596 @@
+//go:build linux
+
+package profiler
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// bpf(2) is not in package syscall on every architecture
+var sysBPF = map[string]uintptr{"amd64": 321, "arm64": 280, "riscv64": 280}[runtime.GOARCH]
+
+const (
+	bpfMapCreate     = 0
+	bpfMapLookupElem = 1
+	bpfMapUpdateElem = 2
+	bpfProgLoad      = 5
+
+	bpfMapTypeHash           = 1
+	bpfMapTypeArray          = 2
+	bpfMapTypePerfEventArray = 4
+	bpfProgTypeKprobe        = 2 // uprobes run kprobe programs
+
+	bpfFuncMapLookupElem      = 1
+	bpfFuncMapUpdateElem      = 2
+	bpfFuncMapDeleteElem      = 3
+	bpfFuncGetSmpProcessorID  = 8
+	bpfFuncGetCurrentPidTgid  = 14
+	bpfFuncPerfEventReadValue = 55
+
+	perfEventIocEnable = 0x2400
+	perfEventIocSetBPF = 0x40042408
+
+	ebpfMaxEvents = 16    // keeps a program's stack under 512 bytes
+	ebpfMaxCalls  = 65536 // calls in flight, over all threads
+)
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	if sysBPF == 0 {
+		return -1, fmt.Errorf("%w: bpf(2) on %s", ErrUnsupported, runtime.GOARCH)
+	}
+	r, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+func bpfMap(typ, key, value, entries uint32) (int, error) {
+	attr := [5]uint32{typ, key, value, entries, 0}
+	fd, err := bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("profiler: bpf map: %w", err)
+	}
+	return fd, nil
+}
+
+// bpfMapElem looks up (bpfMapLookupElem) or stores (bpfMapUpdateElem)
+// the value of key in map fd.
+func bpfMapElem(cmd, fd int, key, value unsafe.Pointer) error {
+	attr := struct {
+		fd         uint32
+		_          uint32
+		key, value uint64
+		flags      uint64
+	}{fd: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(value))}
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// bpfInsn is one eBPF instruction.
+type bpfInsn struct {
+	code uint8
+	regs uint8 // dst | src<<4
+	off  int16
+	imm  int32
+}
+
+// bpfAsm assembles a program; jumps name their target label.
+type bpfAsm struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func (a *bpfAsm) emit(code uint8, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{code, dst | src<<4, off, imm})
+}
+
+func (a *bpfAsm) label(name string) { a.labels[name] = len(a.insns) }
+
+func (a *bpfAsm) jump(code uint8, dst, src uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(code, dst, src, 0, imm)
+}
+
+// ldMap loads map fd into dst (a two-slot ld_imm64 with the map-fd pseudo
+// source).
+func (a *bpfAsm) ldMap(dst uint8, fd int) {
+	a.emit(0x18, dst, 1, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *bpfAsm) call(helper int32) { a.emit(0x85, 0, 0, 0, helper) }
+
+func (a *bpfAsm) code() []bpfInsn {
+	for at, l := range a.jumps {
+		a.insns[at].off = int16(a.labels[l] - at - 1)
+	}
+	return a.insns
+}
+
+// instruction opcodes used below
+const (
+	bpfMov64Imm = 0xb7
+	bpfMov32Imm = 0xb4
+	bpfMov64Reg = 0xbf
+	bpfAdd64Imm = 0x07
+	bpfSub64Reg = 0x1f
+	bpfLdxDW    = 0x79
+	bpfStxDW    = 0x7b
+	bpfStxW     = 0x63
+	bpfStW      = 0x62
+	bpfAtomicDW = 0xdb // imm 0: lock add
+	bpfJeqImm   = 0x15
+	bpfJneReg   = 0x5d
+	bpfJa       = 0x05
+	bpfExit     = 0x95
+)
+
+// ebpf stack layout, from r10: the (tid, function) key of the start map,
+// the function index as the totals key, the start record (CPU and one
+// value per event) and perf_event_read_value's 24-byte buffer.
+const (
+	ebpfKey     = -8
+	ebpfFuncKey = -12
+	ebpfStart   = -16 // minus 8 per slot
+)
+
+func ebpfBuf(n int) int16 { return int16(ebpfStart - 8*(n+1) - 24) }
+
+// ebpfReadEvent emits the read of event map fd on this CPU into the buffer
+// and leaves the counter in r1.
+func ebpfReadEvent(a *bpfAsm, fd, n int) {
+	a.ldMap(1, fd)
+	a.emit(bpfMov32Imm, 2, 0, 0, -1) // BPF_F_CURRENT_CPU
+	a.emit(bpfMov64Reg, 3, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 3, 0, 0, int32(ebpfBuf(n)))
+	a.emit(bpfMov64Imm, 4, 0, 0, 24)
+	a.call(bpfFuncPerfEventReadValue) // zeroes the buffer on failure
+	a.emit(bpfLdxDW, 1, 10, ebpfBuf(n), 0)
+}
+
+// ebpfKeyOf emits the start-map key of function fn for the current thread.
+func ebpfKeyOf(a *bpfAsm, fn int) {
+	a.call(bpfFuncGetCurrentPidTgid)
+	a.emit(bpfStxW, 10, 0, ebpfKey, 0)
+	a.emit(bpfStW, 10, 0, ebpfKey+4, int32(fn))
+}
+
+// ebpfEntry returns the uprobe program of function fn: it records the CPU
+// and the counters of events in starts under (thread, fn).
+func ebpfEntry(fn, starts int, events []int) []bpfInsn {
+	a := &bpfAsm{labels: map[string]int{}, jumps: map[int]string{}}
+	n := len(events)
+	ebpfKeyOf(a, fn)
+	a.call(bpfFuncGetSmpProcessorID)
+	a.emit(bpfStxDW, 10, 0, ebpfStart-8*int16(n), 0)
+	for i, fd := range events {
+		ebpfReadEvent(a, fd, n)
+		a.emit(bpfStxDW, 10, 1, ebpfStart-8*int16(n)+8*int16(i+1), 0)
+	}
+	a.ldMap(1, starts)
+	a.emit(bpfMov64Reg, 2, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 2, 0, 0, ebpfKey)
+	a.emit(bpfMov64Reg, 3, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 3, 0, 0, int32(ebpfStart-8*n))
+	a.emit(bpfMov64Imm, 4, 0, 0, 0) // BPF_ANY
+	a.call(bpfFuncMapUpdateElem)
+	a.emit(bpfMov64Imm, 0, 0, 0, 0)
+	a.emit(bpfExit, 0, 0, 0, 0)
+	return a.code()
+}
+
+// ebpfReturn returns the uretprobe program of function fn: it adds the
+// counter deltas since the entry to fn's totals (calls, dropped, values),
+// or counts the call as dropped if it returns on another CPU.
+func ebpfReturn(fn, starts, totals int, events []int) []bpfInsn {
+	a := &bpfAsm{labels: map[string]int{}, jumps: map[int]string{}}
+	n := len(events)
+	ebpfKeyOf(a, fn)
+	a.ldMap(1, starts)
+	a.emit(bpfMov64Reg, 2, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 2, 0, 0, ebpfKey)
+	a.call(bpfFuncMapLookupElem)
+	a.jump(bpfJeqImm, 0, 0, 0, "out")
+	a.emit(bpfMov64Reg, 7, 0, 0, 0)
+
+	a.emit(bpfStW, 10, 0, ebpfFuncKey, int32(fn))
+	a.ldMap(1, totals)
+	a.emit(bpfMov64Reg, 2, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 2, 0, 0, ebpfFuncKey)
+	a.call(bpfFuncMapLookupElem)
+	a.jump(bpfJeqImm, 0, 0, 0, "delete")
+	a.emit(bpfMov64Reg, 8, 0, 0, 0)
+
+	a.call(bpfFuncGetSmpProcessorID)
+	a.emit(bpfLdxDW, 1, 7, 0, 0)
+	a.jump(bpfJneReg, 0, 1, 0, "dropped")
+	for i, fd := range events {
+		ebpfReadEvent(a, fd, n)
+		a.emit(bpfLdxDW, 2, 7, 8*int16(i+1), 0)
+		a.emit(bpfSub64Reg, 1, 2, 0, 0)
+		a.emit(bpfAtomicDW, 8, 1, 16+8*int16(i), 0)
+	}
+	a.emit(bpfMov64Imm, 1, 0, 0, 1)
+	a.emit(bpfAtomicDW, 8, 1, 0, 0)
+	a.jump(bpfJa, 0, 0, 0, "delete")
+
+	a.label("dropped")
+	a.emit(bpfMov64Imm, 1, 0, 0, 1)
+	a.emit(bpfAtomicDW, 8, 1, 8, 0)
+
+	a.label("delete")
+	a.ldMap(1, starts)
+	a.emit(bpfMov64Reg, 2, 10, 0, 0)
+	a.emit(bpfAdd64Imm, 2, 0, 0, ebpfKey)
+	a.call(bpfFuncMapDeleteElem)
+
+	a.label("out")
+	a.emit(bpfMov64Imm, 0, 0, 0, 0)
+	a.emit(bpfExit, 0, 0, 0, 0)
+	return a.code()
+}
+
+// bpfLoad loads a kprobe-type program, returning the verifier log on
+// failure.
+func bpfLoad(insns []bpfInsn) (int, error) {
+	license := []byte("GPL\x00") // perf_event_read_value is GPL-only
+	log := make([]byte, 1<<16)
+	attr := struct {
+		progType, insnCnt      uint32
+		insns, license         uint64
+		logLevel, logSize      uint32
+		logBuf                 uint64
+		kernVersion, progFlags uint32
+	}{
+		progType: bpfProgTypeKprobe, insnCnt: uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1, logSize: uint32(len(log)), logBuf: uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		if msg := strings.TrimRight(string(log), "\x00\n"); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return -1, fmt.Errorf("profiler: bpf program: %w: %s", err, lines[len(lines)-1])
+		}
+		return -1, fmt.Errorf("profiler: bpf program: %w", err)
+	}
+	return fd, nil
+}
+
+// possibleCPUs returns the number of CPU ids the kernel may use, which
+// bounds smp_processor_id().
+func possibleCPUs() int {
+	b, err := os.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	n := 0
+	for _, r := range strings.Split(strings.TrimSpace(string(b)), ",") {
+		_, hi, _ := strings.Cut(r, "-")
+		if hi == "" {
+			hi = r
+		}
+		if v, err := strconv.Atoi(hi); err == nil && v+1 > n {
+			n = v + 1
+		}
+	}
+	return max(n, 1)
+}
+
+// uprobePMU returns the uprobe PMU's type and the config bit of a
+// uretprobe.
+func uprobePMU() (uint32, uint64, error) {
+	dir := "/sys/bus/event_source/devices/uprobe"
+	b, err := os.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: no uprobe PMU: %v", ErrUnsupported, err)
+	}
+	typ, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("profiler: uprobe PMU type: %w", err)
+	}
+	var ret uint64 = 1
+	if b, err := os.ReadFile(filepath.Join(dir, "format", "retprobe")); err == nil {
+		if _, bit, ok := strings.Cut(strings.TrimSpace(string(b)), "config:"); ok {
+			if v, err := strconv.Atoi(bit); err == nil {
+				ret = 1 << v
+			}
+		}
+	}
+	return uint32(typ), ret, nil
+}
+
+// ebpfSession holds the probes, programs and maps of one eBPF-backend run.
+type ebpfSession struct {
+	evs    []perfEvent
+	perf   *Perf
+	open   []int // indices into evs of the events being read
+	funcs  []ebpfFunc
+	totals int
+	fds    []int
+}
+
+func (s *ebpfSession) close() {
+	for _, fd := range s.fds {
+		syscall.Close(fd)
+	}
+}
+
+func (s *ebpfSession) keep(fd int) int {
+	s.fds = append(s.fds, fd)
+	return fd
+}
+
+// attach opens the per-CPU counters and puts the entry and return probes
+// on every function of exe in process pid.
+func (p *Profiler) ebpfAttach(exe string, pid int) (*ebpfSession, error) {
+	funcs, err := p.ebpfFuncs(exe)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := applyPerfOverrides(perfEvents(cpuVendor()), p.opts.PerfEvents)
+	if err != nil {
+		return nil, err
+	}
+	s := &ebpfSession{evs: ebpfEvents(evs), perf: &Perf{}, funcs: funcs}
+	ok := false
+	defer func() {
+		if !ok {
+			s.close()
+		}
+	}()
+
+	// one perf-event array per event, holding its counter on every CPU
+	cpus := possibleCPUs()
+	var maps []int
+	for i, e := range s.evs {
+		pe := PerfEvent{Name: e.name, Category: e.category, Config: fmt.Sprintf("%d:%#x", e.typ, e.config)}
+		m, err := bpfMap(bpfMapTypePerfEventArray, 4, 4, uint32(cpus))
+		if err != nil {
+			return nil, err
+		}
+		s.keep(m)
+		opened := 0
+		for cpu := 0; cpu < cpus; cpu++ {
+			attr := perfEventAttr{Type: e.typ, Config: e.config, Flags: perfFlagExcludeKernel | perfFlagExcludeHV}
+			attr.Size = uint32(unsafe.Sizeof(attr))
+			fd, err := perfEventOpen(&attr, -1, cpu)
+			if err != nil {
+				if pe.Error == "" {
+					pe.Error = err.Error()
+				}
+				continue
+			}
+			s.keep(fd)
+			key, val := uint32(cpu), uint32(fd)
+			if err := bpfMapElem(bpfMapUpdateElem, m, unsafe.Pointer(&key), unsafe.Pointer(&val)); err != nil {
+				return nil, fmt.Errorf("profiler: bpf map: %w", err)
+			}
+			opened++
+		}
+		pe.Supported = opened > 0 && len(s.open) < ebpfMaxEvents
+		if pe.Supported {
+			pe.Error = ""
+			s.open = append(s.open, i)
+			maps = append(maps, m)
+		} else if opened > 0 {
+			pe.Error = fmt.Sprintf("more than %d events", ebpfMaxEvents)
+		}
+		s.perf.Events = append(s.perf.Events, pe)
+	}
+	if len(s.open) == 0 {
+		return nil, fmt.Errorf("%w: no perf events could be opened (check perf_event_paranoid)", ErrUnsupported)
+	}
+
+	n := len(s.open)
+	starts, err := bpfMap(bpfMapTypeHash, 8, uint32(8*(n+1)), ebpfMaxCalls)
+	if err != nil {
+		return nil, err
+	}
+	s.keep(starts)
+	s.totals, err = bpfMap(bpfMapTypeArray, 4, uint32(8*(n+2)), uint32(len(funcs)))
+	if err != nil {
+		return nil, err
+	}
+	s.keep(s.totals)
+
+	typ, ret, err := uprobePMU()
+	if err != nil {
+		return nil, err
+	}
+	path := append([]byte(exe), 0)
+	for i, f := range funcs {
+		for _, retprobe := range []bool{false, true} {
+			insns := ebpfEntry(i, starts, maps)
+			if retprobe {
+				insns = ebpfReturn(i, starts, s.totals, maps)
+			}
+			prog, err := bpfLoad(insns)
+			if err != nil {
+				return nil, err
+			}
+			s.keep(prog)
+			attr := perfEventAttr{Type: typ, SamplePeriod: 1, Config1: uint64(uintptr(unsafe.Pointer(&path[0]))), Config2: f.offset}
+			if retprobe {
+				attr.Config = ret
+			}
+			attr.Size = uint32(unsafe.Sizeof(attr))
+			fd, err := perfEventOpen(&attr, pid, -1)
+			runtime.KeepAlive(path)
+			if err != nil {
+				return nil, fmt.Errorf("profiler: uprobe %s: %w", f.name, err)
+			}
+			s.keep(fd)
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), perfEventIocSetBPF, uintptr(prog)); errno != 0 {
+				return nil, fmt.Errorf("profiler: uprobe %s: %w", f.name, errno)
+			}
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), perfEventIocEnable, 0); errno != 0 {
+				return nil, fmt.Errorf("profiler: uprobe %s: %w", f.name, errno)
+			}
+		}
+	}
+	ok = true
+	return s, nil
+}
+
+// result reads the per-function totals into a Result.
+func (s *ebpfSession) result(bin Binary, wall time.Duration) *Result {
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "ebpf",
+		Backend:       BackendEBPF,
+		Approximate:   true,
+		Binary:        bin,
+		Mode:          "functions",
+		WallTimeSec:   wall.Seconds(),
+		Perf:          s.perf,
+	}
+	n := len(s.open)
+	buf := make([]byte, 8*(n+2))
+	for i, f := range s.funcs {
+		key := uint32(i)
+		pf := PerfFunction{Name: f.name, Address: fmt.Sprintf("%#x", f.addr), Events: map[string]uint64{}}
+		if bpfMapElem(bpfMapLookupElem, s.totals, unsafe.Pointer(&key), unsafe.Pointer(&buf[0])) == nil {
+			pf.Calls = binary.LittleEndian.Uint64(buf[0:])
+			pf.Dropped = binary.LittleEndian.Uint64(buf[8:])
+			for j, ev := range s.open {
+				pf.Events[s.evs[ev].name] = binary.LittleEndian.Uint64(buf[16+8*j:])
+			}
+		}
+		if pf.Calls+pf.Dropped == 0 {
+			continue
+		}
+		s.perf.Functions = append(s.perf.Functions, pf)
+		for _, ev := range s.open {
+			e := s.evs[ev]
+			val := pf.Events[e.name]
+			s.perf.Events[ev].Value += val
+			lanes := max(e.lanes, 1)
+			switch e.category {
+			case "mul":
+				res.Totals.Mul += val
+			case "div":
+				res.Totals.Div += val
+			case "fp64":
+				s.perf.FP64Ops += val * lanes
+			case "fp32":
+				s.perf.FP32Ops += val * lanes
+			}
+		}
+	}
+	return res
+}
+
+// runEBPF launches cmd stopped at its first instruction, as the perf
+// backend does, probes it and lets it run to completion.
+func (p *Profiler) runEBPF(ctx context.Context, cmd []string) (*Result, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d, capt := newWatchdog(&p.opts), newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, d.writer(stdout), d.writer(stderr)
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	c.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	start := time.Now()
+	if err := p.opts.start(c); err != nil {
+		return nil, fmt.Errorf("profiler: start %s: %w", cmd[0], err)
+	}
+	pid := c.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil || !ws.Stopped() {
+		c.Process.Kill()
+		c.Wait()
+		return nil, fmt.Errorf("profiler: %s did not stop at exec", cmd[0])
+	}
+	exe, err := filepath.Abs(c.Path)
+	if err != nil {
+		exe = c.Path
+	}
+	s, err := p.ebpfAttach(hostPath(pid, exe), pid)
+	if err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, err
+	}
+	defer s.close()
+	if err := syscall.PtraceDetach(pid); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, fmt.Errorf("profiler: detach %s: %w", cmd[0], err)
+	}
+	trunc, runErr := d.wait(c, killStop(c), 0)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	res := s.result(Binary{Path: exe, Args: cmd, Pid: pid}, time.Since(start))
+	res.Output = capt.output()
+	if trunc != nil {
+		res.Truncated = trunc
+		return res, fmt.Errorf("%w: %s", ErrTruncated, trunc)
+	}
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
+
+// attachEBPF probes the running process pid until it exits,
+// Options.Duration elapses or ctx is cancelled; the process never stops.
+func (p *Profiler) attachEBPF(ctx context.Context, pid int) (*Result, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil, fmt.Errorf("profiler: attach %d: %w", pid, err)
+	}
+	args, _ := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	start := time.Now()
+	s, err := p.ebpfAttach(hostPath(pid, exe), pid)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	var timeout <-chan time.Time
+	if d := p.opts.Duration; d > 0 {
+		timeout = time.After(d)
+	}
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	detached := true
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-timeout:
+			break wait
+		case <-tick.C:
+			if syscall.Kill(pid, 0) != nil {
+				detached = false
+				break wait
+			}
+		}
+	}
+	res := s.result(Binary{Path: exe, Args: strings.Split(strings.TrimRight(string(args), "\x00"), "\x00"), Pid: pid},
+		time.Since(start))
+	res.Attached, res.Detached = true, detached
+	res.Container = containerOf(pid)
+	return res, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package profiler
+
+import (
+	"context"
+	"fmt"
+)
+
+func (p *Profiler) runEBPF(ctx context.Context, cmd []string) (*Result, error) {
+	return nil, fmt.Errorf("%w: ebpf backend requires Linux", ErrUnsupported)
+}
+
+func (p *Profiler) attachEBPF(ctx context.Context, pid int) (*Result, error) {
+	return nil, fmt.Errorf("%w: ebpf backend requires Linux", ErrUnsupported)
+}
@@ -0,0 +1,234 @@
+package profiler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// EnergyMetric is the metric energy models price, in picojoules per
+// operation (per byte for mem_bytes_read and mem_bytes_written).
+const EnergyMetric = "energy_pj"
+
+// EnergyNodes lists the process nodes with a built-in energy model.
+var EnergyNodes = []string{"7nm", "16nm"}
+
+// energyCoefficients are the built-in per-op energies in pJ: 64-bit
+// integer and FP64/FP32 arithmetic from the 45nm figures of Horowitz,
+// "Computing's energy problem" (ISSCC 2014), scaled by about 0.3 to 16nm
+// and 0.12 to 7nm; one L1 access per load or store; DRAM per byte for
+// DDR4-class (16nm) and DDR5/LPDDR5-class (7nm) memory. Wide ops cost
+// nothing extra, their limb instructions are already counted. They are
+// rough defaults for comparing kernels, not sign-off numbers.
+var energyCoefficients = map[string]map[string]float64{
+	"16nm": {
+		"add": 0.06, "sub": 0.06, "mul": 3.6, "div": 18,
+		"shl": 0.05, "shr": 0.05, "rol": 0.05, "and": 0.03, "or": 0.03, "xor": 0.03, "not": 0.03,
+		"vec_add": 0.06, "vec_sub": 0.06, "vec_mul": 3.6,
+		"wide_add": 0, "wide_sub": 0, "wide_mul": 0,
+		"fp64_add": 0.55, "fp64_sub": 0.55, "fp64_mul": 4.5, "fp64_div": 18, "fp64_fma": 5,
+		"fp32_add": 0.27, "fp32_sub": 0.27, "fp32_mul": 1.1, "fp32_div": 6, "fp32_fma": 1.4,
+		"mem_loads": 3, "mem_stores": 3, "mem_bytes_read": 120, "mem_bytes_written": 120,
+	},
+	"7nm": {
+		"add": 0.025, "sub": 0.025, "mul": 1.4, "div": 7,
+		"shl": 0.02, "shr": 0.02, "rol": 0.02, "and": 0.01, "or": 0.01, "xor": 0.01, "not": 0.01,
+		"vec_add": 0.025, "vec_sub": 0.025, "vec_mul": 1.4,
+		"wide_add": 0, "wide_sub": 0, "wide_mul": 0,
+		"fp64_add": 0.22, "fp64_sub": 0.22, "fp64_mul": 1.8, "fp64_div": 7, "fp64_fma": 2,
+		"fp32_add": 0.11, "fp32_sub": 0.11, "fp32_mul": 0.45, "fp32_div": 2.4, "fp32_fma": 0.55,
+		"mem_loads": 1.2, "mem_stores": 1.2, "mem_bytes_read": 60, "mem_bytes_written": 60,
+	},
+}
+
+// EnergyModel returns the built-in energy model of a process node, one
+// of EnergyNodes.
+func EnergyModel(node string) (*CostModel, error) {
+	coeff, ok := energyCoefficients[node]
+	if !ok {
+		return nil, fmt.Errorf("profiler: no energy model for %q (have %s)", node, strings.Join(EnergyNodes, ", "))
+	}
+	m := &CostModel{Name: node, Metrics: []string{EnergyMetric}, Costs: map[string]map[string]float64{}}
+	for op, pj := range coeff {
+		m.Costs[op] = map[string]float64{EnergyMetric: pj}
+	}
+	return m, nil
+}
+
+// Energy is an energy estimate in picojoules by component: ALU is
+// add/sub and the --ops categories, Mul and Div the integer multiplies
+// and divides, Vec the packed integer lanes, FP the FP lane ops, Access
+// the per-load/store cost and DRAM the per-byte cost.
+type Energy struct {
+	ALU    float64 `json:"alu_pj"`
+	Mul    float64 `json:"mul_pj"`
+	Div    float64 `json:"div_pj"`
+	Vec    float64 `json:"vec_pj"`
+	FP     float64 `json:"fp_pj"`
+	Access float64 `json:"access_pj"`
+	DRAM   float64 `json:"dram_pj"`
+}
+
+// Total returns the sum over all components.
+func (e Energy) Total() float64 {
+	return e.ALU + e.Mul + e.Div + e.Vec + e.FP + e.Access + e.DRAM
+}
+
+// component returns the field of e that op type op is charged to.
+func (e *Energy) component(op string) *float64 {
+	switch {
+	case op == "mul" || op == "wide_mul":
+		return &e.Mul
+	case op == "div":
+		return &e.Div
+	case strings.HasPrefix(op, "vec_"):
+		return &e.Vec
+	case strings.HasPrefix(op, "fp"):
+		return &e.FP
+	case op == "mem_loads" || op == "mem_stores":
+		return &e.Access
+	case strings.HasPrefix(op, "mem_bytes_") || strings.HasPrefix(op, "mem_dram_bytes_"):
+		return &e.DRAM
+	}
+	return &e.ALU
+}
+
+// KernelEnergy is the estimate for one function.
+type KernelEnergy struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Energy
+}
+
+// EnergyReport is a run's estimated energy.
+type EnergyReport struct {
+	Model        string         `json:"model"`
+	Binary       string         `json:"binary"`
+	DRAMFraction float64        `json:"dram_fraction"` // share of the bytes moved charged as DRAM traffic
+	Total        Energy         `json:"total"`
+	Functions    []KernelEnergy `json:"functions,omitempty"` // present with Options.Funcs
+	Unpriced     []string       `json:"unpriced,omitempty"`  // op types counted but not in the model
+}
+
+// Energy estimates the energy of r with m, which prices EnergyMetric, for
+// example a model from EnergyModel. Bytes moved are the program's view of
+// memory, so only dramFraction of them is charged as DRAM traffic: 1 is
+// a run that never hits in cache, and measured DRAM traffic over bytes
+// moved may exceed 1 (prefetch, write-backs). Without a memory profile
+// (Options.Mem) DRAM and access energy are zero.
+func (r *Result) Energy(m *CostModel, dramFraction float64) (*EnergyReport, error) {
+	if r.Perf != nil {
+		return nil, fmt.Errorf("%w: energy models price pintool or static counts, not perf events", ErrUnsupported)
+	}
+	if dramFraction < 0 {
+		return nil, fmt.Errorf("profiler: negative DRAM fraction %g", dramFraction)
+	}
+	priced := false
+	for _, name := range m.Metrics {
+		priced = priced || name == EnergyMetric
+	}
+	if !priced {
+		return nil, errors.New("profiler: energy model has no " + EnergyMetric + " metric")
+	}
+	rep := &EnergyReport{Model: m.Name, Binary: r.Binary.Path, DRAMFraction: dramFraction}
+	ops, tv := r.csvOps(), r.totalValues()
+
+	// pj[i] is the energy of one op i, negative when unpriced
+	pj := make([]float64, len(ops))
+	for i, op := range ops {
+		c := r.unitCost(m, op)
+		if c == nil {
+			pj[i] = -1
+			// the --cache DRAM bytes are priced through dramFraction
+			if tv[i] > 0 && !strings.HasPrefix(op, "mem_dram_bytes_") {
+				rep.Unpriced = append(rep.Unpriced, op)
+			}
+			continue
+		}
+		pj[i] = c[EnergyMetric]
+		if strings.HasPrefix(op, "mem_bytes_") {
+			pj[i] *= dramFraction
+		}
+	}
+	charge := func(e *Energy, v []uint64) {
+		for i, op := range ops {
+			if pj[i] >= 0 {
+				*e.component(op) += float64(v[i]) * pj[i]
+			}
+		}
+	}
+	charge(&rep.Total, tv)
+	for _, f := range r.Functions {
+		k := KernelEnergy{Name: f.Name, Image: f.Image}
+		charge(&k.Energy, r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom))
+		if k.Total() > 0 {
+			rep.Functions = append(rep.Functions, k)
+		}
+	}
+	sort.SliceStable(rep.Functions, func(i, j int) bool {
+		return rep.Functions[i].Total() > rep.Functions[j].Total()
+	})
+	return rep, nil
+}
+
+// WriteText renders the whole-run and per-function energy breakdowns.
+func (e *EnergyReport) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Energy estimate (%s model): %s, DRAM charged for %.4g%% of bytes moved\n",
+		e.Model, e.Binary, 100*e.DRAMFraction)
+	header := func() {
+		for _, h := range []string{"ALU", "MUL", "DIV", "VEC", "FP", "ACCESS", "DRAM", "TOTAL"} {
+			fmt.Fprintf(bw, "%11s", h)
+		}
+	}
+	row := func(en Energy) {
+		for _, v := range []float64{en.ALU, en.Mul, en.Div, en.Vec, en.FP, en.Access, en.DRAM, en.Total()} {
+			s := siEnergy(v)
+			fmt.Fprintf(bw, "%*s", 11+len(s)-utf8.RuneCountInString(s), s)
+		}
+	}
+	header()
+	fmt.Fprintln(bw)
+	row(e.Total)
+	fmt.Fprintln(bw)
+	if len(e.Unpriced) > 0 {
+		fmt.Fprintf(bw, "not priced: %s\n", strings.Join(e.Unpriced, ", "))
+	}
+	if len(e.Functions) > 0 {
+		fmt.Fprintf(bw, "\n----- Per-function energy -----\n")
+		header()
+		fmt.Fprintf(bw, "%8s  FUNCTION\n", "SHARE")
+		for _, f := range e.Functions {
+			row(f.Energy)
+			share := "-"
+			if t := e.Total.Total(); t > 0 {
+				share = fmt.Sprintf("%.1f%%", 100*f.Total()/t)
+			}
+			fmt.Fprintf(bw, "%8s  %s", share, f.Name)
+			if f.Image != "" {
+				fmt.Fprintf(bw, "  [%s]", f.Image)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	return bw.Flush()
+}
+
+// siEnergy formats an energy in picojoules with the largest fitting unit,
+// e.g. "12.3 nJ".
+func siEnergy(pj float64) string {
+	if pj == 0 {
+		return "0"
+	}
+	units := []string{"pJ", "nJ", "µJ", "mJ", "J"}
+	i := 0
+	for pj >= 1000 && i < len(units)-1 {
+		pj /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g %s", pj, units[i])
+}
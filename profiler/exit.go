@@ -0,0 +1,160 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrTargetFailed means the target, not the tool, failed: it exited with
+// a non-zero status or was killed by a signal after the tool counted it.
+// The Result returned with it is complete; see Result.Exit.
+var ErrTargetFailed = errors.New("profiler: target failed")
+
+// Exit is how a launched target ended when it did not exit with status 0:
+// its exit status, or -1 and the signal that killed it.
+type Exit struct {
+	Code   int    `json:"code"`
+	Signal string `json:"signal,omitempty"` // SIGSEGV, …
+	Signum int    `json:"signum,omitempty"`
+}
+
+func (e *Exit) String() string {
+	if e.Signal != "" {
+		return "killed by " + e.Signal
+	}
+	return fmt.Sprintf("status %d", e.Code)
+}
+
+// Status is the exit status a shell would report for e: the code, or 128
+// plus the signal number.
+func (e *Exit) Status() int {
+	if e.Signum > 0 {
+		return 128 + e.Signum
+	}
+	return e.Code
+}
+
+// exitOf returns the Exit a run's wait error err describes, or nil.
+func exitOf(err error) *Exit {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return nil
+	}
+	e := &Exit{Code: ee.ExitCode()}
+	e.Signal, e.Signum = exitSignal(ee.ProcessState)
+	return e
+}
+
+// targetErr is the error of a launched run of cmd that produced res but
+// whose wait returned runErr: ErrTargetFailed when the target's exit
+// status is the cause, and sets res.Exit.
+func targetErr(res *Result, cmd string, runErr error) error {
+	if res.Exit = exitOf(runErr); res.Exit != nil {
+		return fmt.Errorf("%w: %s: %w", ErrTargetFailed, cmd, runErr)
+	}
+	return fmt.Errorf("profiler: run %s: %w", cmd, runErr)
+}
+
+// Output is the end of a launched target's output (Options.CaptureOutput):
+// the last bytes of each stream and how many it wrote in all.
+type Output struct {
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	StdoutBytes int64  `json:"stdout_bytes"`
+	StderrBytes int64  `json:"stderr_bytes"`
+}
+
+// writeOutput renders o in the text report layout.
+func writeOutput(w io.Writer, o *Output) {
+	for _, s := range []struct {
+		name string
+		text string
+		n    int64
+	}{{"stdout", o.Stdout, o.StdoutBytes}, {"stderr", o.Stderr, o.StderrBytes}} {
+		if s.n == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n----- Target %s (last %d of %d bytes) -----\n", s.name, len(s.text), s.n)
+		fmt.Fprint(w, s.text)
+		if s.text != "" && s.text[len(s.text)-1] != '\n' {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// A capture keeps the end of a launched target's stdout and stderr for
+// Options.CaptureOutput; nil keeps nothing.
+type capture struct {
+	stdout, stderr tail
+}
+
+func newCapture(o *Options) *capture {
+	if o.CaptureOutput <= 0 {
+		return nil
+	}
+	return &capture{stdout: tail{max: o.CaptureOutput}, stderr: tail{max: o.CaptureOutput}}
+}
+
+// writers return w (nil: discarded) and, if c is set, the stream's tail.
+func (c *capture) writers(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if c == nil {
+		return stdout, stderr
+	}
+	return tee(stdout, &c.stdout), tee(stderr, &c.stderr)
+}
+
+func tee(w io.Writer, t *tail) io.Writer {
+	if w == nil {
+		return t
+	}
+	return io.MultiWriter(w, t)
+}
+
+// output returns what c kept, or nil.
+func (c *capture) output() *Output {
+	if c == nil {
+		return nil
+	}
+	o := &Output{}
+	o.Stdout, o.StdoutBytes = c.stdout.text()
+	o.Stderr, o.StderrBytes = c.stderr.text()
+	return o
+}
+
+// A tail is an io.Writer that keeps the last max bytes written to it.
+type tail struct {
+	max int
+	mu  sync.Mutex
+	buf []byte
+	n   int64
+}
+
+func (t *tail) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n += int64(len(b))
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > 2*t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+	}
+	return len(b), nil
+}
+
+// text returns the kept bytes, from the first whole UTF-8 sequence, and
+// the count written.
+func (t *tail) text() (string, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.buf
+	if len(b) > t.max {
+		b = b[len(b)-t.max:]
+	}
+	for i := 0; i < utf8.UTFMax && len(b) > 0 && !utf8.RuneStart(b[0]); i++ {
+		b = b[1:]
+	}
+	return string(b), t.n
+}
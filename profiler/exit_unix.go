@@ -0,0 +1,31 @@
+//go:build !windows
+
+package profiler
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP", syscall.SIGINT: "SIGINT", syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL: "SIGILL", syscall.SIGTRAP: "SIGTRAP", syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS: "SIGBUS", syscall.SIGFPE: "SIGFPE", syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1", syscall.SIGSEGV: "SIGSEGV", syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE", syscall.SIGALRM: "SIGALRM", syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU", syscall.SIGXFSZ: "SIGXFSZ", syscall.SIGSYS: "SIGSYS",
+}
+
+// exitSignal names and numbers the signal that killed the process of ps,
+// or returns "" and 0.
+func exitSignal(ps *os.ProcessState) (string, int) {
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return "", 0
+	}
+	if name, ok := signalNames[ws.Signal()]; ok {
+		return name, int(ws.Signal())
+	}
+	return fmt.Sprintf("signal %d", int(ws.Signal())), int(ws.Signal())
+}
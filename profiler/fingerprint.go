@@ -0,0 +1,217 @@
+package profiler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FingerprintGroups names the parts of a Fingerprint in signature order.
+var FingerprintGroups = []string{"ops", "bitwise", "kinds", "widths", "mix", "intensity"}
+
+// Fingerprint is a compact signature of a run's operation mix, for
+// finding the stored workloads most like a new one (iccad similar). Each
+// group but Intensity is a vector of shares summing to 1, independent of
+// how long the run was: Ops the add, sub, mul and div of the four,
+// Bitwise the BitCategoryNames of the bitwise ops (with Options.Ops),
+// Kinds the scalar integer, vector lane, FP64 and FP32 ops (with
+// Options.FP or Vec), Widths the OperandWidths of the ops (with
+// Options.Widths) and Mix the control transfers, moves and other
+// instructions (with Options.Mix). Intensity is the integer and FP ops
+// per byte moved (with Options.Mem). A group the run did not count is
+// absent, so runs are best compared with the same counting options.
+type Fingerprint struct {
+	Ops       []float64 `json:"ops"`
+	Bitwise   []float64 `json:"bitwise,omitempty"`
+	Kinds     []float64 `json:"kinds,omitempty"`
+	Widths    []float64 `json:"widths,omitempty"`
+	Mix       []float64 `json:"mix,omitempty"`
+	Intensity []float64 `json:"intensity,omitempty"`
+}
+
+// Fingerprint returns r's signature.
+func (r *Result) Fingerprint() *Fingerprint {
+	t := r.Totals
+	f := &Fingerprint{Ops: shares(t.Add, t.Sub, t.Mul, t.Div)}
+	if len(r.Ops()) > 0 {
+		f.Bitwise = shares(t.Shl, t.Shr, t.Rol, t.And, t.Or, t.Xor, t.Not)
+	}
+	if r.FP != nil || r.Vector != nil {
+		var vec, fp64, fp32 uint64
+		if r.Vector != nil {
+			vec = r.Vector.Sum()
+		}
+		if r.FP != nil {
+			fp64, fp32 = r.FP.FP64.Sum(), r.FP.FP32.Sum()
+		}
+		f.Kinds = shares(t.Sum()+t.BitSum(), vec, fp64, fp32)
+	}
+	if len(r.OpWidths) > 0 {
+		by := r.OpWidths.ByWidth()
+		n := make([]uint64, len(OperandWidths))
+		for i, bits := range OperandWidths {
+			n[i] = by[bits]
+		}
+		f.Widths = shares(n...)
+	}
+	if m := r.Mix; m != nil {
+		var other uint64
+		if n := m.Control() + m.Moves(); m.Instructions > n {
+			other = m.Instructions - n
+		}
+		f.Mix = shares(m.Control(), m.Moves(), other)
+	}
+	if m := r.Memory; m != nil && m.Bytes() > 0 {
+		f.Intensity = []float64{round4(m.IntOpsPerByte), round4(m.FPOpsPerByte)}
+	}
+	return f
+}
+
+// shares returns each of n's share of their sum, all 0 for none.
+func shares(n ...uint64) []float64 {
+	var sum uint64
+	for _, v := range n {
+		sum += v
+	}
+	s := make([]float64, len(n))
+	for i, v := range n {
+		if sum > 0 {
+			s[i] = round4(float64(v) / float64(sum))
+		}
+	}
+	return s
+}
+
+// round4 rounds v to 4 decimals, which keep a stored share compact.
+func round4(v float64) float64 { return math.Round(v*1e4) / 1e4 }
+
+// group returns f's group name, nil when absent.
+func (f *Fingerprint) group(name string) []float64 {
+	switch name {
+	case "ops":
+		return f.Ops
+	case "bitwise":
+		return f.Bitwise
+	case "kinds":
+		return f.Kinds
+	case "widths":
+		return f.Widths
+	case "mix":
+		return f.Mix
+	case "intensity":
+		return f.Intensity
+	}
+	return nil
+}
+
+// String renders f as one word per group: the shares in percent, in hex
+// (00 to 64), and the intensities in ops per byte.
+func (f *Fingerprint) String() string {
+	var words []string
+	for _, g := range FingerprintGroups {
+		v := f.group(g)
+		if v == nil {
+			continue
+		}
+		var b strings.Builder
+		for i, x := range v {
+			if g == "intensity" {
+				if i > 0 {
+					b.WriteByte('/')
+				}
+				fmt.Fprintf(&b, "%.3g", x)
+				continue
+			}
+			fmt.Fprintf(&b, "%02x", int(math.Round(100*x)))
+		}
+		words = append(words, g+":"+b.String())
+	}
+	return strings.Join(words, " ")
+}
+
+// FingerprintMatch is how alike two fingerprints are. Groups holds the
+// similarity, from 0 to 1, of each group both have: for shares the part
+// of the mix they have in common (1 less half the L1 distance), for
+// intensities the lower over the higher, averaged. Score is the mean of
+// Groups.
+type FingerprintMatch struct {
+	Score  float64            `json:"score"`
+	Groups map[string]float64 `json:"groups"`
+}
+
+// Compare returns how alike f and g are.
+func (f *Fingerprint) Compare(g *Fingerprint) FingerprintMatch {
+	m := FingerprintMatch{Groups: map[string]float64{}}
+	for _, name := range FingerprintGroups {
+		a, b := f.group(name), g.group(name)
+		if a == nil || b == nil || len(a) != len(b) {
+			continue
+		}
+		var s float64
+		if name == "intensity" {
+			for i := range a {
+				lo, hi := math.Min(a[i], b[i]), math.Max(a[i], b[i])
+				if hi == 0 {
+					s++
+				} else {
+					s += lo / hi
+				}
+			}
+			s /= float64(len(a))
+		} else {
+			for i := range a {
+				s += math.Abs(a[i] - b[i])
+			}
+			s = 1 - s/2
+		}
+		m.Groups[name] = s
+		m.Score += s
+	}
+	if len(m.Groups) > 0 {
+		m.Score /= float64(len(m.Groups))
+	}
+	return m
+}
+
+// SimilarWorkload is a stored workload as ranked by Similar: its latest
+// run's commit, time and fingerprint, and their match.
+type SimilarWorkload struct {
+	Workload    string       `json:"workload"`
+	Commit      string       `json:"commit,omitempty"`
+	Time        time.Time    `json:"time"`
+	Fingerprint *Fingerprint `json:"fingerprint"`
+	FingerprintMatch
+}
+
+// Similar ranks the workloads of entries by how alike their latest
+// run's fingerprint is to f, the most similar first, and returns at most
+// top of them (0: all). Entries without a fingerprint are skipped; see
+// HistoryQuery.Fingerprints.
+func Similar(f *Fingerprint, entries []HistoryEntry, top int) []SimilarWorkload {
+	latest := map[string]HistoryEntry{}
+	for _, e := range entries {
+		if e.Fingerprint == nil {
+			continue
+		}
+		if l, ok := latest[e.Workload]; !ok || !e.Time.Before(l.Time) {
+			latest[e.Workload] = e
+		}
+	}
+	out := make([]SimilarWorkload, 0, len(latest))
+	for _, e := range latest {
+		out = append(out, SimilarWorkload{Workload: e.Workload, Commit: e.Commit, Time: e.Time,
+			Fingerprint: e.Fingerprint, FingerprintMatch: f.Compare(e.Fingerprint)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Workload < out[j].Workload
+	})
+	if top > 0 && len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Footprint is the working set of a run (Options.Footprint): the 64-byte
+// lines and 4 KiB pages its data accesses touched. Each thread's accesses
+// fall into windows of Window; a window's working set is the distinct
+// lines and pages it touched, and Curve lists them in order, the last
+// window partial. The threads' windows of the same index add up (a line
+// two threads touch counts twice) while the footprints are of their union.
+// Functions and Phases, the latter only with Options.Phases, break the
+// footprint down; their peaks are the fullest window of any thread.
+type Footprint struct {
+	Line   uint64 `json:"line"`
+	Page   uint64 `json:"page"`
+	Window uint64 `json:"window"`
+	FootprintCounts
+	Curve     []FootprintWindow   `json:"curve"`
+	Functions []FootprintFunction `json:"functions"`
+	Phases    []FootprintPhase    `json:"phases,omitempty"`
+}
+
+// FootprintCounts is the footprint of one scope and its fullest window.
+type FootprintCounts struct {
+	Lines     uint64 `json:"lines"`
+	Pages     uint64 `json:"pages"`
+	PeakLines uint64 `json:"peak_lines"`
+	PeakPages uint64 `json:"peak_pages"`
+}
+
+// FootprintWindow is the working set of one window.
+type FootprintWindow struct {
+	Lines uint64 `json:"lines"`
+	Pages uint64 `json:"pages"`
+}
+
+// FootprintFunction is the footprint of one function. Functions are in
+// descending order of lines.
+type FootprintFunction struct {
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	FootprintCounts
+}
+
+// FootprintPhase is the footprint of one phase, in start order; phases
+// that touched no memory are left out.
+type FootprintPhase struct {
+	Name string `json:"name"`
+	FootprintCounts
+}
+
+// footprintTextRows is how many groups of the curve, and functions, the
+// text report shows.
+const footprintTextRows = 20
+
+// writeFootprint renders the curve in at most footprintTextRows groups of
+// windows, each with its fullest window; then the phases, and the
+// functions touching most.
+func writeFootprint(w io.Writer, f *Footprint) {
+	var sum, mean uint64
+	for _, c := range f.Curve {
+		sum += c.Lines
+	}
+	if len(f.Curve) > 0 {
+		mean = sum / uint64(len(f.Curve))
+	}
+	fmt.Fprintf(w, "\n----- Working set (%d-byte lines, %dK pages, %d-access windows) -----\n",
+		f.Line, f.Page/1024, f.Window)
+	fmt.Fprintf(w, "Footprint:   %d lines (%d bytes), %d pages (%d bytes)\n",
+		f.Lines, f.Lines*f.Line, f.Pages, f.Pages*f.Page)
+	fmt.Fprintf(w, "Windows:     %d, mean %d lines, peak %d lines (%d bytes), %d pages\n",
+		len(f.Curve), mean, f.PeakLines, f.PeakLines*f.Line, f.PeakPages)
+	if len(f.Curve) > 0 {
+		fmt.Fprintf(w, "%-16s%12s%10s\n", "WINDOWS", "LINES", "PAGES")
+	}
+	group := (len(f.Curve) + footprintTextRows - 1) / footprintTextRows
+	for i := 0; i < len(f.Curve); i += group {
+		end := min(len(f.Curve), i+group)
+		var lines, pages uint64
+		for _, c := range f.Curve[i:end] {
+			lines, pages = max(lines, c.Lines), max(pages, c.Pages)
+		}
+		span := strconv.Itoa(i + 1)
+		if end > i+1 {
+			span += "-" + strconv.Itoa(end)
+		}
+		fmt.Fprintf(w, "%-16s%12d%10d\n", span, lines, pages)
+	}
+	row := func(c FootprintCounts, name string) {
+		fmt.Fprintf(w, "%12d%10d%12d%10d  %s\n", c.Lines, c.Pages, c.PeakLines, c.PeakPages, name)
+	}
+	header := func(scope string) {
+		fmt.Fprintf(w, "%12s%10s%12s%10s  %s\n", "LINES", "PAGES", "PEAK LINES", "PAGES", scope)
+	}
+	if len(f.Phases) > 0 {
+		header("PHASE")
+		for _, p := range f.Phases {
+			row(p.FootprintCounts, p.Name)
+		}
+	}
+	if len(f.Functions) > 0 {
+		header("FUNCTION")
+	}
+	for i, fn := range f.Functions {
+		if i == footprintTextRows {
+			break
+		}
+		row(fn.FootprintCounts, fn.Function)
+	}
+}
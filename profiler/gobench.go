@@ -0,0 +1,223 @@
+package profiler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GoBench is the counts per iteration of Go benchmarks, each from two
+// runs of its test binary with -test.benchtime=Nx and 2Nx (GoBench.Add).
+// The difference between the runs is N iterations of the benchmark loop
+// and nothing else: process start-up, the runtime's own work and the
+// benchmark's setup before its loop run as often in both. Only the code
+// running under the benchmark function, its closures and their callees
+// in the call graph counts, so the allocator and the garbage collection
+// work it triggers is included, background GC workers are not.
+type GoBench struct {
+	GOOS       string        `json:"goos"`
+	GOARCH     string        `json:"goarch"`
+	Counters   []string      `json:"counters"`
+	Benchmarks []GoBenchmark `json:"benchmarks"`
+}
+
+// GoBenchmark is one benchmark's counts per iteration, by
+// GoBench.Counters. A benchmark with sub-benchmarks (b.Run) is measured
+// as a whole: one iteration is one of each of them. Functions has the
+// same counts by function, the most int ops first. Per-iteration counts
+// can come out slightly negative when the runs' allocations differ.
+type GoBenchmark struct {
+	Package    string            `json:"package"`
+	Name       string            `json:"name"`       // as go test prints it, BenchmarkFoo-8
+	Iterations uint64            `json:"iterations"` // N, between the two runs
+	PerOp      []float64         `json:"per_op"`
+	Functions  []GoBenchFunction `json:"functions,omitempty"`
+	Error      string            `json:"error,omitempty"` // the benchmark failed
+}
+
+// GoBenchFunction is a function's counts per iteration of a benchmark.
+type GoBenchFunction struct {
+	Name  string    `json:"name"`
+	PerOp []float64 `json:"per_op"`
+}
+
+// Add adds the benchmark name (BenchmarkFoo, with the -P suffix go test
+// gives it when GOMAXPROCS is not 1) of the package with import path pkg
+// from lo and hi, call-graph runs (Options.CallGraph) of its test binary
+// with n and 2n iterations. The first benchmark added sets the counters.
+func (g *GoBench) Add(pkg, name string, n uint64, lo, hi *Result) error {
+	if lo.CallGraph == nil || hi.CallGraph == nil {
+		return errors.New("profiler: go benchmarks need call-graph runs")
+	}
+	if n == 0 {
+		return errors.New("profiler: go benchmark runs of no iterations")
+	}
+	if g.Counters == nil {
+		g.Counters = append(g.Counters, CategoryNames...)
+		for _, c := range BitCategoryNames {
+			if _, ok := hi.Categories[c]; ok {
+				g.Counters = append(g.Counters, c)
+			}
+		}
+		if hi.Vector != nil {
+			g.Counters = append(g.Counters, "vec")
+		}
+		if hi.FP != nil {
+			g.Counters = append(g.Counters, "fp64", "fp32")
+		}
+	}
+	fn, _, _ := strings.Cut(name, "-")
+	loSum, loFuncs := g.benchStacks(lo, pkg, fn)
+	hiSum, hiFuncs := g.benchStacks(hi, pkg, fn)
+	if hiSum == nil {
+		return fmt.Errorf("profiler: no counts under %s.%s", pkg, fn)
+	}
+	perOp := func(a, b []int64) []float64 {
+		v := make([]float64, len(g.Counters))
+		for i := range v {
+			var x, y int64
+			if a != nil {
+				x = a[i]
+			}
+			if b != nil {
+				y = b[i]
+			}
+			v[i] = float64(y-x) / float64(n)
+		}
+		return v
+	}
+	b := GoBenchmark{Package: pkg, Name: name, Iterations: n, PerOp: perOp(loSum, hiSum)}
+	ints := map[string]float64{}
+	for f, c := range hiFuncs {
+		if p := perOp(loFuncs[f], c); notZero(p) {
+			b.Functions = append(b.Functions, GoBenchFunction{Name: f, PerOp: p})
+			for i := range CategoryNames {
+				ints[f] += p[i]
+			}
+		}
+	}
+	for f, c := range loFuncs {
+		if _, ok := hiFuncs[f]; !ok {
+			if p := perOp(c, nil); notZero(p) {
+				b.Functions = append(b.Functions, GoBenchFunction{Name: f, PerOp: p})
+			}
+		}
+	}
+	sort.Slice(b.Functions, func(i, j int) bool {
+		fi, fj := b.Functions[i].Name, b.Functions[j].Name
+		if ints[fi] != ints[fj] {
+			return ints[fi] > ints[fj]
+		}
+		return fi < fj
+	})
+	g.Benchmarks = append(g.Benchmarks, b)
+	return nil
+}
+
+// AddError adds benchmark name of package pkg as failed with err.
+func (g *GoBench) AddError(pkg, name string, err error) {
+	g.Benchmarks = append(g.Benchmarks, GoBenchmark{Package: pkg, Name: name, Error: err.Error()})
+}
+
+// Failed returns how many benchmarks failed.
+func (g *GoBench) Failed() int {
+	n := 0
+	for _, b := range g.Benchmarks {
+		if b.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// benchStacks sums the counters of r's call stacks under benchmark fn
+// of package pkg, in total and by innermost function; nil when there are
+// none.
+func (g *GoBench) benchStacks(r *Result, pkg, fn string) ([]int64, map[string][]int64) {
+	var sum []int64
+	funcs := map[string][]int64{}
+	for _, s := range r.CallGraph.Stacks {
+		under := false
+		for _, f := range s.Frames {
+			under = under || goBenchFrame(f, pkg, fn)
+		}
+		if !under || len(s.Frames) == 0 {
+			continue
+		}
+		if sum == nil {
+			sum = make([]int64, len(g.Counters))
+		}
+		inner := s.Frames[len(s.Frames)-1]
+		if funcs[inner] == nil {
+			funcs[inner] = make([]int64, len(g.Counters))
+		}
+		for i, c := range g.Counters {
+			v := int64(stackCounter(s, c))
+			sum[i] += v
+			funcs[inner][i] += v
+		}
+	}
+	return sum, funcs
+}
+
+// goBenchFrame reports whether frame is benchmark fn of the package with
+// import path pkg, of its external test package, or one of their
+// closures.
+func goBenchFrame(frame, pkg, fn string) bool {
+	for _, p := range []string{pkg + ".", pkg + "_test."} {
+		if rest, ok := strings.CutPrefix(frame, p); ok && (rest == fn || strings.HasPrefix(rest, fn+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// stackCounter returns counter c, one of GoBench.Counters, of s.
+func stackCounter(s Stack, c string) uint64 {
+	switch c {
+	case "vec":
+		return vecSum(s.Vector)
+	case "fp64":
+		return fpSum(s.FP64)
+	case "fp32":
+		return fpSum(s.FP32)
+	}
+	return s.Counts.Get(c)
+}
+
+func notZero(v []float64) bool {
+	for _, x := range v {
+		if x != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText renders the benchmarks in the format of go test -bench, a
+// line per benchmark run with a <counter>/op value for every counter,
+// which benchstat compares; failed benchmarks are left out.
+func (g *GoBench) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "goos: %s\ngoarch: %s\n", g.GOOS, g.GOARCH)
+	pkg := ""
+	for _, b := range g.Benchmarks {
+		if b.Error != "" {
+			continue
+		}
+		if b.Package != pkg {
+			pkg = b.Package
+			fmt.Fprintf(bw, "pkg: %s\n", pkg)
+		}
+		fmt.Fprintf(bw, "%s\t%8d", b.Name, b.Iterations)
+		for i, c := range g.Counters {
+			fmt.Fprintf(bw, "\t%12s %s/op", strconv.FormatFloat(b.PerOp[i], 'f', 2, 64), c)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
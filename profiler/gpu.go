@@ -0,0 +1,433 @@
+package profiler
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GPU vendors of Result.GPU.
+const (
+	GPUNVIDIA = "nvidia"
+	GPUAMD    = "amd"
+)
+
+// GPUImage is the Image of the Functions rows of GPU kernels.
+const GPUImage = "gpu"
+
+// GPU holds the per-kernel instruction counts of a GPU-backend run, read
+// from NVIDIA Nsight Compute (ncu, the CUPTI profiler) or AMD rocprof. All
+// counts are thread (lane) instructions. Nsight Compute counts each
+// predicated-on thread exactly; rocprof counts wave instructions, scaled
+// by the wave size here whatever the execution mask, so AMD counts are
+// upper bounds. Int is every integer instruction, not split by operation;
+// Int64 its 64-bit share, which only rocprof reports.
+type GPU struct {
+	Vendor   string      `json:"vendor"`
+	Profiler string      `json:"profiler"`
+	Kernels  []GPUKernel `json:"kernels"`
+}
+
+// GPUKernel is the sum over the launches of one kernel.
+type GPUKernel struct {
+	Name     string `json:"name"`
+	Launches uint64 `json:"launches"`
+	Int      uint64 `json:"int"`
+	Int64    uint64 `json:"int64,omitempty"`
+	FP64     FPOps  `json:"fp64"`
+	FP32     FPOps  `json:"fp32"`
+	FP16     FPOps  `json:"fp16"`
+}
+
+// Totals returns the sums over the kernels.
+func (g *GPU) Totals() GPUKernel {
+	var t GPUKernel
+	for _, k := range g.Kernels {
+		t.Launches += k.Launches
+		t.Int += k.Int
+		t.Int64 += k.Int64
+		t.FP64 = addFPOps(t.FP64, k.FP64)
+		t.FP32 = addFPOps(t.FP32, k.FP32)
+		t.FP16 = addFPOps(t.FP16, k.FP16)
+	}
+	return t
+}
+
+func addFPOps(a, b FPOps) FPOps {
+	return FPOps{a.Add + b.Add, a.Sub + b.Sub, a.Mul + b.Mul, a.Div + b.Div, a.FMA + b.FMA}
+}
+
+// ncuMetrics maps the Nsight Compute metrics the GPU backend collects to
+// the kernel count each feeds.
+var ncuMetrics = map[string]func(k *GPUKernel) *uint64{
+	"smsp__sass_thread_inst_executed_op_integer_pred_on.sum": func(k *GPUKernel) *uint64 { return &k.Int },
+	"smsp__sass_thread_inst_executed_op_dadd_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP64.Add },
+	"smsp__sass_thread_inst_executed_op_dmul_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP64.Mul },
+	"smsp__sass_thread_inst_executed_op_dfma_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP64.FMA },
+	"smsp__sass_thread_inst_executed_op_fadd_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP32.Add },
+	"smsp__sass_thread_inst_executed_op_fmul_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP32.Mul },
+	"smsp__sass_thread_inst_executed_op_ffma_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP32.FMA },
+	"smsp__sass_thread_inst_executed_op_hadd_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP16.Add },
+	"smsp__sass_thread_inst_executed_op_hmul_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP16.Mul },
+	"smsp__sass_thread_inst_executed_op_hfma_pred_on.sum":    func(k *GPUKernel) *uint64 { return &k.FP16.FMA },
+}
+
+// rocprofCounters maps the rocprof SQ counters the GPU backend collects,
+// one input line each (a pass of the workload), to the kernel count each
+// feeds.
+var rocprofCounters = [][]struct {
+	name  string
+	count func(k *GPUKernel) *uint64
+}{
+	{
+		{"SQ_INSTS_VALU_INT32", func(k *GPUKernel) *uint64 { return &k.Int }},
+		{"SQ_INSTS_VALU_INT64", func(k *GPUKernel) *uint64 { return &k.Int64 }},
+		{"SQ_INSTS_VALU_ADD_F64", func(k *GPUKernel) *uint64 { return &k.FP64.Add }},
+		{"SQ_INSTS_VALU_MUL_F64", func(k *GPUKernel) *uint64 { return &k.FP64.Mul }},
+		{"SQ_INSTS_VALU_FMA_F64", func(k *GPUKernel) *uint64 { return &k.FP64.FMA }},
+		{"SQ_INSTS_VALU_ADD_F32", func(k *GPUKernel) *uint64 { return &k.FP32.Add }},
+		{"SQ_INSTS_VALU_MUL_F32", func(k *GPUKernel) *uint64 { return &k.FP32.Mul }},
+		{"SQ_INSTS_VALU_FMA_F32", func(k *GPUKernel) *uint64 { return &k.FP32.FMA }},
+	},
+	{
+		{"SQ_INSTS_VALU_ADD_F16", func(k *GPUKernel) *uint64 { return &k.FP16.Add }},
+		{"SQ_INSTS_VALU_MUL_F16", func(k *GPUKernel) *uint64 { return &k.FP16.Mul }},
+		{"SQ_INSTS_VALU_FMA_F16", func(k *GPUKernel) *uint64 { return &k.FP16.FMA }},
+	},
+}
+
+// gpuProfiler resolves Options.GPUProfiler, by default ncu and else rocprof
+// from PATH, and returns its path and vendor.
+func (p *Profiler) gpuProfiler() (path, vendor string, err error) {
+	name := p.opts.GPUProfiler
+	if name == "" {
+		for _, n := range []string{"ncu", "rocprof"} {
+			if path, err = exec.LookPath(n); err == nil {
+				name = n
+				break
+			}
+		}
+		if name == "" {
+			return "", "", fmt.Errorf("%w: gpu backend needs ncu (Nsight Compute) or rocprof in PATH",
+				ErrUnsupported)
+		}
+	} else if path, err = exec.LookPath(name); err != nil {
+		return "", "", fmt.Errorf("profiler: gpu backend: %w", err)
+	}
+	vendor = GPUNVIDIA
+	if strings.HasPrefix(filepath.Base(name), "rocprof") {
+		vendor = GPUAMD
+	}
+	return path, vendor, nil
+}
+
+// runGPU runs cmd under Nsight Compute or rocprof, which replay or rerun
+// every kernel launch to collect its instruction counters, and reports
+// each kernel as a function of image GPUImage with its FP64 and FP32 ops.
+func (p *Profiler) runGPU(ctx context.Context, cmd []string) (*Result, error) {
+	start := time.Now()
+	prof, vendor, err := p.gpuProfiler()
+	if err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath(cmd[0])
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "int64gpu-")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out.csv")
+	var args []string
+	if vendor == GPUNVIDIA {
+		metrics := make([]string, 0, len(ncuMetrics))
+		for m := range ncuMetrics {
+			metrics = append(metrics, m)
+		}
+		sort.Strings(metrics)
+		args = []string{"--csv", "--page", "details", "--print-units", "base", "--target-processes", "all",
+			"--log-file", out, "--metrics", strings.Join(metrics, ",")}
+	} else {
+		var in strings.Builder
+		for _, pass := range rocprofCounters {
+			in.WriteString("pmc:")
+			for _, c := range pass {
+				in.WriteString(" " + c.name)
+			}
+			in.WriteString("\n")
+		}
+		input := filepath.Join(dir, "input.txt")
+		if err := os.WriteFile(input, []byte(in.String()), 0o644); err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		args = []string{"-i", input, "-o", out}
+	}
+	capt := newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, prof, append(append(args, path), cmd[1:]...)...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, stdout, stderr
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	runErr := p.opts.run(c)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "iccad-gpu",
+		Backend:       BackendGPU,
+		Arch:          vendor,
+		Approximate:   vendor == GPUAMD,
+		Binary:        Binary{Path: path, Args: cmd},
+		Mode:          "whole",
+		Categories:    Categories{},
+	}
+	if c.Process != nil {
+		res.Binary.Pid = c.Process.Pid
+	}
+	g := &GPU{Vendor: vendor, Profiler: filepath.Base(prof)}
+	if f, err := os.Open(out); err == nil {
+		if vendor == GPUNVIDIA {
+			err = g.readNCU(f)
+		} else {
+			err = g.readRocprof(f)
+		}
+		f.Close()
+		if err != nil {
+			if runErr != nil {
+				return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+			}
+			return nil, err
+		}
+	} else if runErr == nil {
+		return nil, fmt.Errorf("%w: %s wrote no counters", ErrNoReport, g.Profiler)
+	}
+	if runErr != nil && len(g.Kernels) == 0 {
+		return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+	}
+	res.setGPU(g)
+	res.WallTimeSec = time.Since(start).Seconds()
+	res.Output = capt.output()
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
+
+// readNCU parses an Nsight Compute CSV log, one row per metric of each
+// kernel launch, into g.Kernels. Lines before the header are the tool's
+// own messages.
+func (g *GPU) readNCU(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.Peek(5)
+		if err != nil {
+			return fmt.Errorf("%w: no ncu metrics (did the workload launch kernels?)", ErrNoReport)
+		}
+		if string(line) == `"ID",` {
+			break
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			return fmt.Errorf("%w: no ncu metrics", ErrNoReport)
+		}
+	}
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	head, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("profiler: ncu log: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range head {
+		col[h] = i
+	}
+	for _, h := range []string{"ID", "Kernel Name", "Metric Name", "Metric Value"} {
+		if _, ok := col[h]; !ok {
+			return fmt.Errorf("profiler: ncu log has no %q column", h)
+		}
+	}
+	kernels := map[string]*GPUKernel{}
+	launches := map[string]bool{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("profiler: ncu log: %w", err)
+		}
+		if len(rec) != len(head) {
+			continue
+		}
+		count, ok := ncuMetrics[rec[col["Metric Name"]]]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.ReplaceAll(rec[col["Metric Value"]], ",", ""), 10, 64)
+		if err != nil {
+			// "n/a" on architectures without the metric
+			continue
+		}
+		name := rec[col["Kernel Name"]]
+		k := kernels[name]
+		if k == nil {
+			k = &GPUKernel{Name: name}
+			kernels[name] = k
+		}
+		if id := rec[col["ID"]]; !launches[id] {
+			launches[id] = true
+			k.Launches++
+		}
+		*count(k) += v
+	}
+	g.setKernels(kernels)
+	return nil
+}
+
+// readRocprof parses a rocprof results CSV, one row per kernel dispatch
+// with a column per counter, into g.Kernels. Counters are per wave and
+// scaled by the dispatch's wave_size (64 when absent).
+func (g *GPU) readRocprof(r io.Reader) error {
+	cr := csv.NewReader(r)
+	head, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("%w: no rocprof counters (did the workload launch kernels?)", ErrNoReport)
+	}
+	col := map[string]int{}
+	for i, h := range head {
+		col[h] = i
+	}
+	kcol, ok := col["KernelName"]
+	if !ok {
+		return errors.New(`profiler: rocprof results have no "KernelName" column`)
+	}
+	kernels := map[string]*GPUKernel{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("profiler: rocprof results: %w", err)
+		}
+		wave := uint64(64)
+		if i, ok := col["wave_size"]; ok {
+			if n, err := strconv.ParseUint(rec[i], 10, 64); err == nil && n > 0 {
+				wave = n
+			}
+		}
+		name := rec[kcol]
+		k := kernels[name]
+		if k == nil {
+			k = &GPUKernel{Name: name}
+			kernels[name] = k
+		}
+		k.Launches++
+		for _, pass := range rocprofCounters {
+			for _, c := range pass {
+				i, ok := col[c.name]
+				if !ok {
+					continue
+				}
+				if v, err := strconv.ParseFloat(rec[i], 64); err == nil {
+					*c.count(k) += uint64(v) * wave
+				}
+			}
+		}
+	}
+	// SQ_INSTS_VALU_INT32 and _INT64 are disjoint; Int is both.
+	for _, k := range kernels {
+		k.Int += k.Int64
+	}
+	g.setKernels(kernels)
+	return nil
+}
+
+// setKernels lists kernels by decreasing arithmetic, then name.
+func (g *GPU) setKernels(kernels map[string]*GPUKernel) {
+	for _, k := range kernels {
+		g.Kernels = append(g.Kernels, *k)
+	}
+	sort.Slice(g.Kernels, func(i, j int) bool {
+		a, b := g.Kernels[i].ops(), g.Kernels[j].ops()
+		if a != b {
+			return a > b
+		}
+		return g.Kernels[i].Name < g.Kernels[j].Name
+	})
+}
+
+// ops returns the kernel's integer and FP instructions.
+func (k GPUKernel) ops() uint64 { return k.Int + k.FP64.Sum() + k.FP32.Sum() + k.FP16.Sum() }
+
+// setGPU records g in r: its kernels as Functions of image GPUImage and
+// their FP64 and FP32 ops in r.FP. FP16 and the integer counts, which are
+// not split by operation, are only in r.GPU, which has its own INT/FP
+// ratio.
+func (r *Result) setGPU(g *GPU) {
+	r.GPU = g
+	if r.FP == nil {
+		r.FP = &FP{}
+	}
+	for _, k := range g.Kernels {
+		fp64, fp32 := k.FP64, k.FP32
+		r.FP.FP64 = addFPOps(r.FP.FP64, fp64)
+		r.FP.FP32 = addFPOps(r.FP.FP32, fp32)
+		r.Functions = append(r.Functions, Function{Name: k.Name, Image: GPUImage, FP64: &fp64, FP32: &fp32})
+	}
+	r.FP.IntFPRatio = 0
+	if n := r.FP.FP64.Sum() + r.FP.FP32.Sum(); n > 0 && r.Backend != BackendGPU {
+		r.FP.IntFPRatio = float64(r.Totals.Sum()) / float64(n)
+	}
+}
+
+// MergeGPU adds the kernels of gpu, a GPU-backend result of the same
+// workload, to r, a CPU result: to its Functions, under image GPUImage,
+// and FP totals, with the GPU section itself. CPU functions and counts are
+// unchanged; the INT/FP ratio becomes that of the CPU integer ops to the
+// CPU and GPU FP ops.
+func (r *Result) MergeGPU(gpu *Result) error {
+	if gpu.GPU == nil {
+		return errors.New("profiler: MergeGPU: not a GPU-backend result")
+	}
+	if r.GPU != nil {
+		return errors.New("profiler: MergeGPU: result already has GPU kernels")
+	}
+	r.setGPU(gpu.GPU)
+	return nil
+}
+
+// writeGPU renders the kernels of a run with their integer and FP
+// instructions.
+func writeGPU(w io.Writer, g *GPU) {
+	t := g.Totals()
+	fmt.Fprintf(w, "\n----- GPU kernels (%s, %s; thread instructions) -----\n", g.Vendor, g.Profiler)
+	fmt.Fprintf(w, "Kernels:   %d (%d launches)\n", len(g.Kernels), t.Launches)
+	fmt.Fprintf(w, "INT:       %d", t.Int)
+	if g.Vendor == GPUAMD {
+		fmt.Fprintf(w, " (%d 64-bit)", t.Int64)
+	}
+	fp := t.FP64.Sum() + t.FP32.Sum() + t.FP16.Sum()
+	fmt.Fprintf(w, "\nFP64:      %d\nFP32:      %d\nFP16:      %d\n", t.FP64.Sum(), t.FP32.Sum(), t.FP16.Sum())
+	fmt.Fprintf(w, "INT/FP:    %s\n", intFPRatio(Counts{Add: t.Int}, fp))
+	fmt.Fprintf(w, "%10s%16s%16s%16s%16s  KERNEL\n", "LAUNCHES", "INT", "FP64", "FP32", "FP16")
+	for _, k := range g.Kernels {
+		fmt.Fprintf(w, "%10d%16d%16d%16d%16d  %s\n", k.Launches, k.Int, k.FP64.Sum(), k.FP32.Sum(), k.FP16.Sum(), k.Name)
+	}
+}
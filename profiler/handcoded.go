@@ -0,0 +1,309 @@
+package profiler
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of hand-coded function, strongest evidence first.
+const (
+	HandAsm        = "asm"        // assembled from a .s or .S file
+	HandInlineAsm  = "inline_asm" // asm statements in compiled source
+	HandIntrinsics = "intrinsics" // compiler intrinsics (_mm256_*, vaddq_u64, _addcarry_u64…)
+)
+
+// HandCoded is the part of a run spent in hand-written assembly and in
+// intrinsics (Result.HandCoded), the code already tuned by hand for an
+// instruction set and so the first candidate for an accelerator.
+type HandCoded struct {
+	Ops       uint64              `json:"ops"`       // of Functions
+	TotalOps  uint64              `json:"total_ops"` // of the run
+	Functions []HandCodedFunction `json:"functions"` // the most operations first
+	// Unread lists the images whose functions could not be looked at:
+	// no symbols or no DWARF, or the file is gone.
+	Unread []string `json:"unread,omitempty"`
+}
+
+// HandCodedFunction is one function found hand-coded. Evidence says
+// why: the assembler that built it, and the asm statements and
+// intrinsics by source location.
+type HandCodedFunction struct {
+	Name     string   `json:"name"`
+	Image    string   `json:"image"`
+	Kind     string   `json:"kind"`
+	Evidence []string `json:"evidence"`
+	Ops      uint64   `json:"ops"`
+}
+
+// Share returns the fraction of the run's operations in hand-coded
+// functions.
+func (h *HandCoded) Share() float64 {
+	if h.TotalOps == 0 {
+		return 0
+	}
+	return float64(h.Ops) / float64(h.TotalOps)
+}
+
+var (
+	// asmStmt matches a GNU or MSVC asm statement, or Rust's asm! macros.
+	asmStmt = regexp.MustCompile(`\b(?:__asm__|__asm|asm)\b(?:\s+(?:volatile|__volatile__|goto|inline))*\s*[({]|\b(?:global_)?asm!\s*\(`)
+	// intrinsicCall matches a call of an x86, A64 or RISC-V intrinsic.
+	intrinsicCall = regexp.MustCompile(`\b(_mm(?:256|512)?_\w+|__builtin_ia32_\w+|_(?:addcarryx?|subborrow|mulx|mul128|umul128)_u(?:32|64)|_?umul128|v[a-z]+q?_[supf](?:8|16|32|64)|__riscv_v\w+)\s*\(`)
+	// intrinsicHeader matches the compilers' intrinsic headers.
+	intrinsicHeader = regexp.MustCompile(`(?:intrin|arm_neon|arm_sve|arm_acle|riscv_vector)\.h$`)
+)
+
+// DW_LANG_Mips_Assembler, which GNU as and LLVM's integrated assembler
+// give the compile units of assembly files they emit DWARF for.
+const dwarfLangAsm = 0x8001
+
+// HandCoded finds the functions of r, recorded with Options.Funcs, that
+// are hand-written assembly or use asm statements or intrinsics. It reads
+// each function's image: a function is assembly when its compile unit was
+// assembled (or its lines are in a .s file, as with Go's assembler); it
+// has inline asm or intrinsics when the source lines its instructions
+// came from contain them, or when they were inlined from an intrinsic
+// header. That needs the images and their DWARF lines, and for inline
+// asm the sources, where they were when the run was recorded.
+func (r *Result) HandCoded() (*HandCoded, error) {
+	if r.Functions == nil {
+		return nil, errors.New("profiler: report has no per-function breakdown (record with --funcs)")
+	}
+	hc := &HandCoded{TotalOps: r.Totals.Sum() + r.Totals.BitSum() + vecSum(r.Vector)}
+	if r.FP != nil {
+		hc.TotalOps += r.FP.FP64.Sum() + r.FP.FP32.Sum()
+	}
+	images := map[string]*handImage{}
+	src := sourceCache{}
+	for _, f := range r.Functions {
+		if f.Image == "" {
+			continue
+		}
+		im, ok := images[f.Image]
+		if !ok {
+			im = readHandImage(f.Image, r.debugFile(f.Image))
+			images[f.Image] = im
+			if im == nil {
+				hc.Unread = append(hc.Unread, f.Image)
+			}
+		}
+		if im == nil {
+			continue
+		}
+		sym := f.Name
+		if f.Mangled != "" {
+			sym = f.Mangled
+		}
+		kind, ev := im.classify(sym, src)
+		if kind == "" {
+			continue
+		}
+		ops := f.Sum() + f.BitSum() + vecSum(f.Vector) + fpSum(f.FP64) + fpSum(f.FP32)
+		hc.Ops += ops
+		hc.Functions = append(hc.Functions, HandCodedFunction{Name: f.Name, Image: f.Image, Kind: kind, Evidence: ev, Ops: ops})
+	}
+	sort.SliceStable(hc.Functions, func(i, j int) bool { return hc.Functions[i].Ops > hc.Functions[j].Ops })
+	sort.Strings(hc.Unread)
+	return hc, nil
+}
+
+// handImage is what HandCoded needs of one image: its function symbols,
+// the compile units covering them and the line table.
+type handImage struct {
+	syms  map[string]Symbol
+	units []handUnit
+	lines []handLine // ascending addresses
+}
+
+// handUnit is a compile unit's address ranges, labelled with its name
+// and producer when it was assembled.
+type handUnit struct {
+	ranges [][2]uint64
+	asm    string
+}
+
+type handLine struct {
+	addr uint64
+	file string
+	line int
+}
+
+// readHandImage reads the ELF image at path, or the symbols and DWARF of
+// a stripped one from its debug file debug, or from one found in the
+// default directories; nil when it has no symbols or DWARF.
+func readHandImage(path, debug string) *handImage {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if df, _ := withDebug(f, path, debug, nil); df != f {
+		defer df.Close()
+		f = df
+	}
+	dw, err := f.DWARF()
+	if err != nil {
+		return nil
+	}
+	im := &handImage{syms: map[string]Symbol{}}
+	all, _ := f.Symbols()
+	dyn, _ := f.DynamicSymbols()
+	for _, s := range append(all, dyn...) {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 && s.Size > 0 {
+			if _, ok := im.syms[s.Name]; !ok {
+				im.syms[s.Name] = Symbol{Name: s.Name, Addr: s.Value, Size: s.Size}
+			}
+		}
+	}
+	if len(im.syms) == 0 {
+		return nil
+	}
+
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		var u handUnit
+		u.ranges, _ = dw.Ranges(e)
+		name, _ := e.Val(dwarf.AttrName).(string)
+		producer, _ := e.Val(dwarf.AttrProducer).(string)
+		if lang, _ := e.Val(dwarf.AttrLanguage).(int64); lang == dwarfLangAsm || strings.Contains(producer, "GNU AS") {
+			u.asm = "assembled from " + filepath.Base(name)
+			if producer != "" {
+				u.asm += " (" + producer + ")"
+			}
+		}
+		im.units = append(im.units, u)
+		if lr, err := dw.LineReader(e); err == nil && lr != nil {
+			var le dwarf.LineEntry
+			for lr.Next(&le) == nil {
+				if le.File != nil && !le.EndSequence {
+					im.lines = append(im.lines, handLine{le.Address, le.File.Name, le.Line})
+				}
+			}
+		}
+		r.SkipChildren()
+	}
+	sort.SliceStable(im.lines, func(i, j int) bool { return im.lines[i].addr < im.lines[j].addr })
+	return im
+}
+
+// classify returns the kind of the function name and the evidence for
+// it, "" when it is neither assembly nor uses asm or intrinsics.
+func (im *handImage) classify(name string, src sourceCache) (string, []string) {
+	s, ok := im.syms[name]
+	if !ok {
+		return "", nil
+	}
+	lo, hi := s.Addr, s.Addr+s.Size
+	for _, u := range im.units {
+		for _, rg := range u.ranges {
+			if u.asm != "" && lo >= rg[0] && lo < rg[1] {
+				return HandAsm, []string{u.asm}
+			}
+		}
+	}
+
+	var kind string
+	var ev []string
+	rank := map[string]int{HandIntrinsics: 1, HandInlineAsm: 2, HandAsm: 3}
+	note := func(k, e string) {
+		if rank[k] > rank[kind] {
+			kind = k
+		}
+		ev = appendUnique(ev, e)
+	}
+	seen := map[handLine]bool{}
+	i := sort.Search(len(im.lines), func(i int) bool { return im.lines[i].addr >= lo })
+	for ; i < len(im.lines) && im.lines[i].addr < hi; i++ {
+		l := im.lines[i]
+		l.addr = 0
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		base := filepath.Base(l.file)
+		switch ext := filepath.Ext(base); {
+		case ext == ".s" || ext == ".S" || ext == ".asm":
+			note(HandAsm, "source "+base)
+			continue
+		case intrinsicHeader.MatchString(base):
+			note(HandIntrinsics, "inlined from "+base)
+			continue
+		}
+		text := src.line(l.file, l.line)
+		at := fmt.Sprintf(" at %s:%d", base, l.line)
+		if asmStmt.MatchString(text) {
+			note(HandInlineAsm, "asm"+at)
+		}
+		for _, m := range intrinsicCall.FindAllStringSubmatch(text, -1) {
+			note(HandIntrinsics, m[1]+at)
+		}
+	}
+	return kind, ev
+}
+
+// sourceCache holds the lines of the source files read so far, nil for
+// those that cannot be read.
+type sourceCache map[string][]string
+
+// line returns line n of file, "" when there is none.
+func (c sourceCache) line(file string, n int) string {
+	lines, ok := c[file]
+	if !ok {
+		if f, err := os.Open(file); err == nil {
+			sc := bufio.NewScanner(f)
+			sc.Buffer(nil, 1<<20)
+			for sc.Scan() {
+				lines = append(lines, sc.Text())
+			}
+			f.Close()
+		}
+		c[file] = lines
+	}
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1]
+}
+
+// WriteText renders h as a table of the hand-coded functions.
+func (h *HandCoded) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Hand-coded: %d functions, %d of %d operations (%.1f%%)\n",
+		len(h.Functions), h.Ops, h.TotalOps, 100*h.Share())
+	if len(h.Functions) > 0 {
+		fmt.Fprintf(bw, "\n%14s%8s  %-11s %-32s %s\n", "OPS", "SHARE", "KIND", "FUNCTION", "EVIDENCE")
+		for _, f := range h.Functions {
+			share := 0.0
+			if h.TotalOps > 0 {
+				share = 100 * float64(f.Ops) / float64(h.TotalOps)
+			}
+			ev := f.Evidence
+			more := ""
+			if len(ev) > 3 {
+				ev, more = ev[:3], fmt.Sprintf(" (+%d more)", len(f.Evidence)-3)
+			}
+			fmt.Fprintf(bw, "%14d%7.1f%%  %-11s %-32s %s%s\n", f.Ops, share, f.Kind, f.Name, strings.Join(ev, ", "), more)
+		}
+	}
+	if len(h.Unread) > 0 {
+		fmt.Fprintf(bw, "\nNot analysed (no symbols or DWARF): %s\n", strings.Join(h.Unread, ", "))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,388 @@
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is one result in a Store: a run of a workload at a commit.
+// Totals holds the report's totals under their WriteCSV op names and
+// Fingerprint the report's, so trends and similar workloads can be
+// queried without decoding whole reports.
+type HistoryEntry struct {
+	Workload    string            `json:"workload"`
+	Commit      string            `json:"commit,omitempty"`
+	Time        time.Time         `json:"time"`
+	Totals      map[string]uint64 `json:"totals"`
+	WallTimeSec float64           `json:"wall_time_sec"`
+	Fingerprint *Fingerprint      `json:"fingerprint,omitempty"`
+	Result      *Result           `json:"result,omitempty"` // with HistoryQuery.Results
+}
+
+// storeLine is a HistoryEntry as stored, its report left undecoded.
+type storeLine struct {
+	HistoryEntry
+	Result json.RawMessage `json:"result"`
+}
+
+// Store is a local, append-only history of results keyed by workload,
+// commit and time: a file of one JSON HistoryEntry per line. Appends are
+// single writes, so concurrent runs on one machine may share a store.
+type Store struct {
+	Path string
+}
+
+// DefaultStorePath is $ICCAD_STORE, else $HOME/.iccad/history.jsonl.
+func DefaultStorePath() (string, error) {
+	if p := os.Getenv("ICCAD_STORE"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("profiler: store: %w", err)
+	}
+	return filepath.Join(home, ".iccad", "history.jsonl"), nil
+}
+
+// Add appends r to the store as a run of workload at commit (may be
+// empty) taken at t, and returns the entry.
+func (s *Store) Add(workload, commit string, t time.Time, r *Result) (*HistoryEntry, error) {
+	if workload == "" {
+		return nil, errors.New("profiler: store: empty workload name")
+	}
+	e := HistoryEntry{Workload: workload, Commit: commit, Time: t.UTC(), Totals: map[string]uint64{}, WallTimeSec: r.WallTimeSec,
+		Fingerprint: r.Fingerprint()}
+	vals := r.totalValues()
+	for i, op := range r.csvOps() {
+		e.Totals[op] = vals[i]
+	}
+	res, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	line, err := json.Marshal(storeLine{e, res})
+	if err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	return &e, nil
+}
+
+// HistoryQuery selects store entries. Zero fields select everything.
+type HistoryQuery struct {
+	Workload string
+	Commit   string    // a prefix of the commit hash
+	Since    time.Time // entries at or after
+	Until    time.Time // entries before
+	Results  bool      // also decode each entry's report
+	// Fingerprints decodes the reports of entries stored without a
+	// fingerprint, by older versions, to compute theirs.
+	Fingerprints bool
+}
+
+// Query returns the entries matching q, oldest first. A missing store
+// holds no entries.
+func (s *Store) Query(q HistoryQuery) ([]HistoryEntry, error) {
+	f, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profiler: store: %w", err)
+	}
+	defer f.Close()
+
+	var out []HistoryEntry
+	br := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var l storeLine
+			if err := json.Unmarshal(line, &l); err != nil {
+				return nil, fmt.Errorf("profiler: store %s line %d: %w", s.Path, n, err)
+			}
+			e := l.HistoryEntry
+			if (q.Workload == "" || e.Workload == q.Workload) && strings.HasPrefix(e.Commit, q.Commit) &&
+				!e.Time.Before(q.Since) && (q.Until.IsZero() || e.Time.Before(q.Until)) {
+				if q.Results || q.Fingerprints && e.Fingerprint == nil {
+					r, err := Decode(bytes.NewReader(l.Result))
+					if err != nil {
+						return nil, fmt.Errorf("profiler: store %s line %d: %w", s.Path, n, err)
+					}
+					if e.Fingerprint == nil {
+						e.Fingerprint = r.Fingerprint()
+					}
+					if q.Results {
+						e.Result = r
+					}
+				}
+				out = append(out, e)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profiler: store: %w", err)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// WorkloadSummary is one workload of a store.
+type WorkloadSummary struct {
+	Workload   string    `json:"workload"`
+	Runs       int       `json:"runs"`
+	First      time.Time `json:"first"`
+	Last       time.Time `json:"last"`
+	LastCommit string    `json:"last_commit,omitempty"`
+}
+
+// Workloads summarizes entries by workload, by name.
+func Workloads(entries []HistoryEntry) []WorkloadSummary {
+	by := map[string]*WorkloadSummary{}
+	var names []string
+	for _, e := range entries {
+		w := by[e.Workload]
+		if w == nil {
+			w = &WorkloadSummary{Workload: e.Workload, First: e.Time}
+			by[e.Workload] = w
+			names = append(names, e.Workload)
+		}
+		w.Runs++
+		w.Last, w.LastCommit = e.Time, e.Commit
+	}
+	sort.Strings(names)
+	out := make([]WorkloadSummary, len(names))
+	for i, n := range names {
+		out[i] = *by[n]
+	}
+	return out
+}
+
+// Trend is how the chosen op types of one workload evolve over its
+// entries, oldest first.
+type Trend struct {
+	Workload string         `json:"workload"`
+	Ops      []string       `json:"ops"`
+	Entries  []HistoryEntry `json:"entries"`
+}
+
+// NewTrend returns the trend of ops (WriteCSV op names; empty means the
+// four basic categories) over entries, which should be one workload's.
+func NewTrend(entries []HistoryEntry, ops []string) (*Trend, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("profiler: no entries in the store match")
+	}
+	if len(ops) == 0 {
+		ops = CategoryNames
+	}
+	for _, op := range ops {
+		found := false
+		for _, e := range entries {
+			if _, ok := e.Totals[op]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("profiler: no entry counts %q", op)
+		}
+	}
+	t := &Trend{Workload: entries[0].Workload, Ops: ops}
+	for _, e := range entries {
+		e.Result = nil
+		t.Entries = append(t.Entries, e)
+	}
+	return t, nil
+}
+
+// change formats the relative change from a to b, "" for no change.
+func change(a, b uint64) string {
+	switch {
+	case a == b:
+		return ""
+	case a == 0:
+		return "(new)"
+	}
+	return fmt.Sprintf("(%+.1f%%)", (float64(b)-float64(a))/float64(a)*100)
+}
+
+// WriteText prints one row per entry with each op's count and its change
+// from the previous entry.
+func (t *Trend) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "History of %s: %d runs\n", t.Workload, len(t.Entries))
+	fmt.Fprintf(bw, "%-20s  %-12s", "TIME", "COMMIT")
+	for _, op := range t.Ops {
+		fmt.Fprintf(bw, "%16s %9s", strings.ToUpper(op), "")
+	}
+	fmt.Fprintln(bw)
+	for i, e := range t.Entries {
+		commit := e.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if commit == "" {
+			commit = "-"
+		}
+		fmt.Fprintf(bw, "%-20s  %-12s", e.Time.Local().Format(time.DateTime), commit)
+		for _, op := range t.Ops {
+			v, ok := e.Totals[op]
+			if !ok {
+				fmt.Fprintf(bw, "%16s %9s", "-", "")
+				continue
+			}
+			delta := ""
+			if i > 0 {
+				if prev, ok := t.Entries[i-1].Totals[op]; ok {
+					delta = change(prev, v)
+				}
+			}
+			fmt.Fprintf(bw, "%16d %9s", v, delta)
+		}
+		fmt.Fprintln(bw)
+	}
+	if n := len(t.Entries); n > 1 {
+		first, last := t.Entries[0], t.Entries[n-1]
+		fmt.Fprintf(bw, "\n%-34s", "first → last")
+		for _, op := range t.Ops {
+			a, okA := first.Totals[op]
+			b, okB := last.Totals[op]
+			if !okA || !okB || change(a, b) == "" {
+				fmt.Fprintf(bw, "%16s %9s", "=", "")
+				continue
+			}
+			fmt.Fprintf(bw, "%16s %9s", "", change(a, b))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// WriteCSV writes one row per entry: time, commit and each op's count.
+func (t *Trend) WriteCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "time,commit,%s\n", strings.Join(t.Ops, ","))
+	for _, e := range t.Entries {
+		fmt.Fprintf(bw, "%s,%s", e.Time.Format(time.RFC3339), e.Commit)
+		for _, op := range t.Ops {
+			if v, ok := e.Totals[op]; ok {
+				fmt.Fprintf(bw, ",%d", v)
+			} else {
+				fmt.Fprint(bw, ",")
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// WriteJSON writes t as indented JSON.
+func (t *Trend) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+var trendColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#9467bd", "#ff7f0e", "#8c564b", "#e377c2", "#17becf"}
+
+// WriteSVG plots each op's count relative to its first entry, in percent,
+// against time: ops of very different magnitudes share one chart, and
+// each point's tooltip gives the count and commit.
+func (t *Trend) WriteSVG(w io.Writer) error {
+	const (
+		width, height            = 960, 480
+		left, right, top, bottom = 70, 160, 50, 60
+		pw, ph                   = width - left - right, height - top - bottom
+	)
+	rel := func(op string, e HistoryEntry) (float64, bool) {
+		base, ok := t.Entries[0].Totals[op]
+		v, ok2 := e.Totals[op]
+		if !ok || !ok2 || base == 0 {
+			return 0, false
+		}
+		return float64(v) / float64(base) * 100, true
+	}
+	lo, hi := 100.0, 100.0
+	for _, op := range t.Ops {
+		for _, e := range t.Entries {
+			if y, ok := rel(op, e); ok {
+				lo, hi = math.Min(lo, y), math.Max(hi, y)
+			}
+		}
+	}
+	pad := math.Max((hi-lo)*0.1, 1)
+	lo, hi = lo-pad, hi+pad
+	t0, t1 := t.Entries[0].Time, t.Entries[len(t.Entries)-1].Time
+	span := t1.Sub(t0).Seconds()
+	sx := func(tm time.Time) float64 {
+		if span == 0 {
+			return left + pw/2
+		}
+		return left + tm.Sub(t0).Seconds()/span*pw
+	}
+	sy := func(y float64) float64 { return top + ph - (y-lo)/(hi-lo)*ph }
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n", width, height)
+	fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	fmt.Fprintf(bw, "<text x=\"%d\" y=\"30\" font-size=\"16\">History: %s (%d runs)</text>\n", left, html.EscapeString(t.Workload), len(t.Entries))
+	for i := 0; i <= 5; i++ {
+		y := lo + (hi-lo)*float64(i)/5
+		fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", left, sy(y), left+pw, sy(y))
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%.4g%%</text>\n", left-6, sy(y)+4, y)
+	}
+	fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#888\" stroke-dasharray=\"4 3\"/>\n", left, sy(100), left+pw, sy(100))
+	for _, tm := range []time.Time{t0, t1} {
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", sx(tm), top+ph+18, tm.Local().Format(time.DateOnly))
+	}
+	fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"black\"/>\n", left, top, pw, ph)
+	fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">run time</text>\n", left+pw/2, height-15)
+	fmt.Fprintf(bw, "<text transform=\"translate(18,%d) rotate(-90)\" text-anchor=\"middle\">count relative to the first run</text>\n", top+ph/2)
+
+	for i, op := range t.Ops {
+		color := trendColors[i%len(trendColors)]
+		fmt.Fprintf(bw, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"", color)
+		for _, e := range t.Entries {
+			if y, ok := rel(op, e); ok {
+				fmt.Fprintf(bw, "%.1f,%.1f ", sx(e.Time), sy(y))
+			}
+		}
+		fmt.Fprintf(bw, "\"/>\n")
+		for _, e := range t.Entries {
+			if y, ok := rel(op, e); ok {
+				fmt.Fprintf(bw, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"3\" fill=\"%s\"><title>%s %d at %s %s</title></circle>\n",
+					sx(e.Time), sy(y), color, op, e.Totals[op], html.EscapeString(e.Commit), e.Time.Local().Format(time.DateTime))
+			}
+		}
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" fill=\"%s\">%s</text>\n", left+pw+10, top+14+18*i, color, strings.ToUpper(op))
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
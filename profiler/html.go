@@ -0,0 +1,658 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// htmlContext is how many lines around each counted line the source
+// views show.
+const htmlContext = 3
+
+// htmlCell is a table cell; Num cells sort by Value.
+type htmlCell struct {
+	Text  string
+	Value float64
+	Num   bool
+}
+
+type htmlTable struct {
+	Title string
+	Cols  []string
+	Rows  [][]htmlCell
+}
+
+type htmlSlice struct {
+	Label string
+	Value uint64
+	Color string
+}
+
+type htmlPie struct {
+	Title  string
+	SVG    template.HTML
+	Slices []htmlSlice
+	Total  uint64
+}
+
+type htmlSourceLine struct {
+	N      int
+	Text   string
+	Counts []string // one per gutter column, empty when not counted
+	Heat   float64  // 0 … 1, share of the hottest line
+	Gap    bool     // a skipped stretch
+}
+
+type htmlSource struct {
+	File  string
+	Cols  []string
+	Lines []htmlSourceLine
+}
+
+type htmlReport struct {
+	Title   string
+	Meta    [][2]string
+	Pies    []htmlPie
+	Tables  []htmlTable
+	Sources []htmlSource
+}
+
+// htmlColors is the chart palette; slices past its end reuse it.
+var htmlColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// htmlTopFunctions is how many functions the function pie names; the
+// rest are one "other" slice.
+const htmlTopFunctions = 8
+
+// WriteHTML renders the report as one self-contained HTML page: pie
+// charts of the operation mix, a sortable table per breakdown, and the
+// per-line counts over each source file of the report found locally.
+func (r *Result) WriteHTML(w io.Writer) error {
+	if r.Perf != nil {
+		return fmt.Errorf("%w: HTML reports render pintool or static counts, not perf events", ErrUnsupported)
+	}
+	rep := htmlReport{Title: r.Binary.Path}
+	rep.Meta = append(rep.Meta, [2]string{"Command", strings.Join(r.Binary.Args, " ")})
+	if r.Binary.Pid != 0 {
+		rep.Meta = append(rep.Meta, [2]string{"PID", fmt.Sprint(r.Binary.Pid)})
+	}
+	if r.Container != nil {
+		rep.Meta = append(rep.Meta, [2]string{"Container", r.Container.String()})
+	}
+	rep.Meta = append(rep.Meta, [2]string{"Mode", r.Mode}, [2]string{"Wall time", fmt.Sprintf("%.3f s", r.WallTimeSec)})
+	if r.Backend == BackendStatic {
+		rep.Meta = append(rep.Meta, [2]string{"Backend", fmt.Sprintf("static (%s code, instructions in the binary, not executed)", r.Arch)})
+	}
+	if r.Backend == BackendQEMU {
+		rep.Meta = append(rep.Meta, [2]string{"Backend", fmt.Sprintf("qemu (%s code, executed under QEMU user-mode emulation)", r.Arch)})
+	}
+	if r.Backend == BackendHybrid {
+		rep.Meta = append(rep.Meta, [2]string{"Backend", fmt.Sprintf("hybrid (%s basic-block executions under Pin × the ops decoded in each block)", r.Arch)})
+	}
+	if r.Backend == BackendWASM {
+		rep.Meta = append(rep.Meta, [2]string{"Backend", "wasm (wasm instructions, executed under Node.js)"})
+	}
+	if g := r.GPU; g != nil {
+		t := g.Totals()
+		rep.Meta = append(rep.Meta, [2]string{"GPU", fmt.Sprintf("%d %s kernels (%d launches, %s): %d int, %d fp64, %d fp32, %d fp16 thread instructions",
+			len(g.Kernels), g.Vendor, t.Launches, g.Profiler, t.Int, t.FP64.Sum(), t.FP32.Sum(), t.FP16.Sum())})
+	}
+	if r.Sampling != nil {
+		rep.Meta = append(rep.Meta, [2]string{"Sampling", fmt.Sprintf("%g of %d-instruction windows, counts extrapolated", r.Sampling.Fraction, r.Sampling.Window)})
+	}
+	if m := r.Memory; m != nil {
+		rep.Meta = append(rep.Meta, [2]string{"Memory", fmt.Sprintf("%d loads, %d stores, %d bytes moved, %s int ops/byte",
+			m.Loads, m.Stores, m.Bytes(), opsPerByte(m, float64(r.Totals.Sum())))})
+	}
+	if m := r.Mix; m != nil && m.Instructions > 0 {
+		share := func(n uint64) float64 { return 100 * float64(n) / float64(m.Instructions) }
+		rep.Meta = append(rep.Meta, [2]string{"Instruction mix", fmt.Sprintf("%d instructions: %.1f%% control (%.1f%% branches taken), %.1f%% moves, %.1f%% other",
+			m.Instructions, share(m.Control()), share(m.BranchTaken), share(m.Moves()), share(m.Other))})
+	}
+	if d := r.Diagnostics; d != nil {
+		rep.Meta = append(rep.Meta, [2]string{"Diagnostics", fmt.Sprintf("%d warnings in %d events", d.Warnings, len(d.Events))})
+	}
+
+	// operation mix
+	mix := htmlPie{Title: "Operation mix"}
+	add := func(label string, v uint64) {
+		mix.Slices = append(mix.Slices, htmlSlice{Label: label, Value: v})
+	}
+	for _, c := range append(append([]string{}, CategoryNames...), r.Ops()...) {
+		add(strings.ToUpper(c), r.Totals.Get(c))
+	}
+	if v := r.Vector; v != nil {
+		add("VEC", v.Sum())
+	}
+	if wd := r.Wide; wd != nil {
+		add("WIDE", wideTotal(wd))
+	}
+	if fp := r.FP; fp != nil {
+		add("FP64", fp.FP64.Sum())
+		add("FP32", fp.FP32.Sum())
+	}
+	rep.Pies = append(rep.Pies, mix)
+	totals := htmlTable{Title: "Totals", Cols: []string{"CATEGORY", "COUNT", "SHARE"}}
+	var all uint64
+	for _, s := range mix.Slices {
+		all += s.Value
+	}
+	for _, s := range mix.Slices {
+		totals.Rows = append(totals.Rows, []htmlCell{{Text: s.Label}, num(s.Value), share(s.Value, all)})
+	}
+	rep.Tables = append(rep.Tables, totals)
+
+	if len(r.Functions) > 0 {
+		byFunc := htmlPie{Title: "Integer ops by function"}
+		fs := append([]Function{}, r.Functions...)
+		sort.SliceStable(fs, func(i, j int) bool { return fs[i].Sum() > fs[j].Sum() })
+		var rest uint64
+		for i, f := range fs {
+			if i < htmlTopFunctions && f.Sum() > 0 {
+				byFunc.Slices = append(byFunc.Slices, htmlSlice{Label: f.Name, Value: f.Sum()})
+			} else {
+				rest += f.Sum()
+			}
+		}
+		if rest > 0 {
+			byFunc.Slices = append(byFunc.Slices, htmlSlice{Label: "other", Value: rest})
+		}
+		rep.Pies = append(rep.Pies, byFunc)
+	}
+	for i := range rep.Pies {
+		rep.Pies[i].draw()
+	}
+
+	rep.Tables = append(rep.Tables, r.htmlBreakdowns()...)
+	rep.Sources = r.htmlSources()
+
+	bw := bufio.NewWriter(w)
+	if err := htmlTemplate.Execute(bw, rep); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	return bw.Flush()
+}
+
+func num(v uint64) htmlCell {
+	return htmlCell{Text: fmt.Sprint(v), Value: float64(v), Num: true}
+}
+
+func secs(v float64) htmlCell {
+	return htmlCell{Text: fmt.Sprintf("%.1f", v), Value: v, Num: true}
+}
+
+func share(v, total uint64) htmlCell {
+	if total == 0 {
+		return htmlCell{Text: "-", Num: true}
+	}
+	p := 100 * float64(v) / float64(total)
+	return htmlCell{Text: fmt.Sprintf("%.1f%%", p), Value: p, Num: true}
+}
+
+// percent returns a fraction as a percentage cell.
+func percent(v float64) htmlCell {
+	return htmlCell{Text: fmt.Sprintf("%.1f%%", 100*v), Value: 100 * v, Num: true}
+}
+
+func wideTotal(wd *Wide) uint64 {
+	var n uint64
+	for _, by := range []map[int]uint64{wd.Add, wd.Sub, wd.Mul} {
+		for _, v := range by {
+			n += v
+		}
+	}
+	return n
+}
+
+// htmlCols are the count columns of the breakdown tables.
+func (r *Result) htmlCols() []string {
+	cols := []string{"ADD", "SUB", "MUL", "DIV"}
+	for _, c := range r.Ops() {
+		cols = append(cols, strings.ToUpper(c))
+	}
+	if r.Vector != nil {
+		cols = append(cols, "VEC")
+	}
+	if r.Wide != nil {
+		cols = append(cols, "WIDE")
+	}
+	if r.FP != nil {
+		cols = append(cols, "FP64", "FP32")
+	}
+	if r.Memory != nil {
+		cols = append(cols, "BYTES")
+	}
+	return append(cols, "SHARE")
+}
+
+// htmlCells returns one breakdown row's cells in htmlCols order.
+func (r *Result) htmlCells(c Counts, vec *Vector, wide *WideCounts, fp64, fp32 *FPOps, mem *Memory) []htmlCell {
+	cells := []htmlCell{num(c.Add), num(c.Sub), num(c.Mul), num(c.Div)}
+	for _, op := range r.Ops() {
+		cells = append(cells, num(c.Get(op)))
+	}
+	if r.Vector != nil {
+		cells = append(cells, num(vecSum(vec)))
+	}
+	if r.Wide != nil {
+		cells = append(cells, num(wideSum(wide)))
+	}
+	if r.FP != nil {
+		cells = append(cells, num(fpSum(fp64)), num(fpSum(fp32)))
+	}
+	if r.Memory != nil {
+		var b uint64
+		if mem != nil {
+			b = mem.Bytes()
+		}
+		cells = append(cells, num(b))
+	}
+	return append(cells, share(c.Sum(), r.Totals.Sum()))
+}
+
+// htmlBreakdowns returns a table per breakdown the report has.
+func (r *Result) htmlBreakdowns() []htmlTable {
+	var tables []htmlTable
+	if t := r.Top; t != nil {
+		for _, c := range t.Categories {
+			tt := htmlTable{Title: fmt.Sprintf("Top %d functions by %s", t.N, strings.ToUpper(c.Category)),
+				Cols: []string{"COUNT", "SHARE", "CUMULATIVE", "FUNCTION"}}
+			if r.names == NamesBoth {
+				tt.Cols = append(tt.Cols, "SYMBOL")
+			}
+			tt.Cols = append(tt.Cols, "IMAGE")
+			for _, tr := range c.Rows {
+				row := []htmlCell{num(tr.Count), percent(tr.Share), percent(tr.Cumulative), {Text: tr.Function}}
+				if r.names == NamesBoth {
+					row = append(row, htmlCell{Text: r.rawName(tr.Function)})
+				}
+				image := tr.Image
+				if tr.Function == TopOther {
+					image = tr.functions()
+				}
+				tt.Rows = append(tt.Rows, append(row, htmlCell{Text: image}))
+			}
+			tables = append(tables, tt)
+		}
+	} else if len(r.Functions) > 0 {
+		t := htmlTable{Title: "Functions", Cols: append(r.htmlCols(), "FUNCTION")}
+		if r.names == NamesBoth {
+			t.Cols = append(t.Cols, "SYMBOL")
+		}
+		t.Cols = append(t.Cols, "IMAGE")
+		for _, f := range r.Functions {
+			row := append(r.htmlCells(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory), htmlCell{Text: f.Name})
+			if r.names == NamesBoth {
+				row = append(row, htmlCell{Text: r.rawName(f.Name)})
+			}
+			t.Rows = append(t.Rows, append(row, htmlCell{Text: f.Image}))
+		}
+		tables = append(tables, t)
+	}
+	if g := r.CallGraph; g != nil {
+		t := htmlTable{Title: "Call graph (inclusive / exclusive)", Cols: []string{"INCL ADD", "INCL SUB", "INCL MUL", "INCL DIV",
+			"EXCL ADD", "EXCL SUB", "EXCL MUL", "EXCL DIV", "SHARE", "FUNCTION"}}
+		if r.names == NamesBoth {
+			t.Cols = append(t.Cols, "SYMBOL")
+		}
+		t.Cols = append(t.Cols, "IMAGE")
+		for _, f := range g.Functions {
+			in, ex := f.Inclusive, f.Exclusive
+			row := []htmlCell{num(in.Add), num(in.Sub), num(in.Mul), num(in.Div),
+				num(ex.Add), num(ex.Sub), num(ex.Mul), num(ex.Div), share(in.Sum(), r.Totals.Sum()),
+				{Text: f.Name}}
+			if r.names == NamesBoth {
+				row = append(row, htmlCell{Text: r.rawName(f.Name)})
+			}
+			t.Rows = append(t.Rows, append(row, htmlCell{Text: f.Image}))
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Lines) > 0 {
+		t := htmlTable{Title: "Source lines", Cols: append(r.htmlCols(), "FILE", "LINE")}
+		for _, l := range r.Lines {
+			row := r.htmlCells(l.Counts, l.Vector, l.Wide, l.FP64, l.FP32, l.Memory)
+			t.Rows = append(t.Rows, append(row, htmlCell{Text: l.File},
+				htmlCell{Text: fmt.Sprint(l.Line), Value: float64(l.Line), Num: true}))
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Loops) > 0 {
+		t := htmlTable{Title: "Loops", Cols: append(r.htmlCols(), "ENTRIES", "ITERATIONS", "TRIPS", "OPS/ITER", "DEPTH", "LOOP", "LOCATION")}
+		for _, l := range r.Loops {
+			row := r.htmlCells(l.Counts, l.Vector, l.Wide, l.FP64, l.FP32, l.Memory)
+			loc := ""
+			if l.Line > 0 {
+				loc = fmt.Sprintf("%s:%d", l.File, l.Line)
+			}
+			entries, iterations := num(l.Entries), num(l.Iterations)
+			if r.Backend == BackendStatic { // no trip counts without a run
+				entries, iterations = htmlCell{Text: "-", Num: true}, htmlCell{Text: "-", Num: true}
+			}
+			t.Rows = append(t.Rows, append(row, entries, iterations,
+				htmlCell{Text: perText(l.Iterations, l.Entries), Value: l.TripCount(), Num: true},
+				htmlCell{Text: perText(l.Ops(), l.Iterations), Value: l.OpsPerIteration(), Num: true},
+				num(uint64(l.Depth)), htmlCell{Text: l.Function + "+" + l.Offset}, htmlCell{Text: loc}))
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Blocks) > 0 {
+		t := htmlTable{Title: "Hot basic blocks", Cols: []string{"OPS", "EXECUTIONS", "OPS/EXEC", "INSNS", "BLOCK", "LOCATION"}}
+		for _, b := range r.Blocks {
+			loc := ""
+			if b.Line > 0 {
+				loc = fmt.Sprintf("%s:%d", b.File, b.Line)
+			}
+			t.Rows = append(t.Rows, []htmlCell{num(b.Ops), num(b.Executions), num(b.OpsPerExecution),
+				num(uint64(len(b.Instructions))), {Text: b.Function + "+" + b.FunctionOffset}, {Text: loc}})
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Annotated) > 0 {
+		t := htmlTable{Title: "Annotated functions", Cols: []string{"EXECUTIONS", "INSNS", "FUNCTION", "IMAGE"}}
+		for _, f := range r.Annotated {
+			t.Rows = append(t.Rows, []htmlCell{num(f.Executions), num(uint64(len(f.Instructions))),
+				{Text: f.Function}, {Text: f.Image}})
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Modules) > 0 {
+		t := htmlTable{Title: "Modules", Cols: append(r.htmlCols(), "FUNCS", "LOADED", "MODULE")}
+		for _, m := range r.Modules {
+			row := r.htmlCells(m.Counts, m.Vector, m.Wide, m.FP64, m.FP32, m.Memory)
+			loaded := m.Loaded
+			if m.Unloaded {
+				loaded += " (unloaded)"
+			}
+			t.Rows = append(t.Rows, append(row, num(uint64(m.Functions)), htmlCell{Text: loaded}, htmlCell{Text: m.Path}))
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Regions) > 0 {
+		t := htmlTable{Title: "Regions", Cols: append(r.htmlCols(), "ENTRIES", "REGION")}
+		for _, g := range r.Regions {
+			row := r.htmlCells(g.Counts, g.Vector, g.Wide, g.FP64, g.FP32, g.Memory)
+			t.Rows = append(t.Rows, append(row, num(g.Entries), htmlCell{Text: g.Name}))
+		}
+		tables = append(tables, t)
+	}
+	if py := r.Python; py != nil && len(py.Functions) > 0 {
+		t := htmlTable{Title: "Python functions", Cols: append(r.htmlCols(), "CALLS", "FUNCTION", "FILE")}
+		for _, f := range py.Functions {
+			row := r.htmlCells(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory)
+			t.Rows = append(t.Rows, append(row, num(f.Calls), htmlCell{Text: f.Name},
+				htmlCell{Text: fmt.Sprintf("%s:%d", f.File, f.Line)}))
+		}
+		tables = append(tables, t)
+	}
+	if ph := r.Phases; ph != nil && len(ph.List) > 0 {
+		t := htmlTable{Title: "Phases (" + ph.Mode + ")", Cols: append(r.htmlCols(), "START", "END", "PHASE")}
+		for _, p := range ph.List {
+			row := r.htmlCells(p.Counts, p.Vector, p.Wide, p.FP64, p.FP32, p.Memory)
+			t.Rows = append(t.Rows, append(row, secs(p.StartSec), secs(p.EndSec), htmlCell{Text: p.Name}))
+		}
+		tables = append(tables, t)
+	}
+	if len(r.Threads) > 0 {
+		t := htmlTable{Title: "Threads", Cols: []string{"TID", "OS TID", "ADD", "SUB", "MUL", "DIV", "SHARE", "STATUS"}}
+		for _, th := range r.Threads {
+			status := "running"
+			if th.Exited {
+				status = fmt.Sprintf("exited (%d)", th.ExitCode)
+			}
+			t.Rows = append(t.Rows, []htmlCell{num(uint64(th.Tid)), num(uint64(th.OSTid)),
+				num(th.Add), num(th.Sub), num(th.Mul), num(th.Div), share(th.Sum(), r.Totals.Sum()), {Text: status}})
+		}
+		tables = append(tables, t)
+	}
+	if len(r.CPUs) > 0 {
+		t := htmlTable{Title: "CPUs", Cols: []string{"CPU", "NODE", "THREADS", "ADD", "SUB", "MUL", "DIV", "SHARE"}}
+		for _, c := range r.CPUs {
+			cpu, node := htmlCell{Text: "?"}, htmlCell{Text: "?"}
+			if c.CPU >= 0 {
+				cpu, node = num(uint64(c.CPU)), num(uint64(c.Node))
+			}
+			t.Rows = append(t.Rows, []htmlCell{cpu, node, num(uint64(c.Threads)),
+				num(c.Add), num(c.Sub), num(c.Mul), num(c.Div), share(c.Sum(), r.Totals.Sum())})
+		}
+		tables = append(tables, t)
+		t = htmlTable{Title: "NUMA nodes", Cols: []string{"NODE", "CPUS", "ADD", "SUB", "MUL", "DIV", "SHARE"}}
+		for _, n := range r.NUMANodes {
+			t.Rows = append(t.Rows, []htmlCell{num(uint64(n.Node)), num(uint64(n.CPUs)),
+				num(n.Add), num(n.Sub), num(n.Mul), num(n.Div), share(n.Sum(), r.Totals.Sum())})
+		}
+		tables = append(tables, t)
+	}
+	if p := r.Processes; p != nil {
+		t := htmlTable{Title: "Processes", Cols: []string{"PID", "PPID", "START", "ADD", "SUB", "MUL", "DIV", "SHARE", "COMMAND"}}
+		for _, pr := range p.List {
+			cmd := strings.Join(pr.Args, " ")
+			if cmd == "" {
+				cmd = pr.Binary
+			}
+			t.Rows = append(t.Rows, []htmlCell{num(uint64(pr.Pid)), num(uint64(pr.PPid)), {Text: pr.Started},
+				num(pr.Add), num(pr.Sub), num(pr.Mul), num(pr.Div), share(pr.Sum(), p.Total.Sum()), {Text: cmd}})
+		}
+		tables = append(tables, t)
+	}
+	if d := r.Diagnostics; d != nil && len(d.Events) > 0 {
+		t := htmlTable{Title: "Diagnostics", Cols: []string{"LEVEL", "KIND", "IMAGE", "FUNCTION", "COUNT", "MESSAGE"}}
+		for _, e := range d.Events {
+			t.Rows = append(t.Rows, []htmlCell{{Text: e.Level}, {Text: e.Kind}, {Text: e.Image}, {Text: e.Function},
+				num(e.Count), {Text: e.Message}})
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// htmlSources annotates every source file of the per-line breakdown that
+// can be read locally, keeping htmlContext lines around counted ones.
+func (r *Result) htmlSources() []htmlSource {
+	var out []htmlSource
+	for _, file := range r.SourceFiles() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		counts := r.lineCounts(file)
+		text := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		src := htmlSource{File: file, Cols: []string{"ADD", "SUB", "MUL", "DIV"}}
+		if r.FP != nil {
+			src.Cols = append(src.Cols, "FP")
+		}
+		// lines are shaded by their integer and floating-point ops
+		weight := func(c Line) uint64 { return c.Sum() + fpSum(c.FP64) + fpSum(c.FP32) }
+		var hottest uint64
+		show := make([]bool, len(text)+1)
+		for n, c := range counts {
+			hottest = max(hottest, weight(c))
+			for k := n - htmlContext; k <= n+htmlContext; k++ {
+				if k >= 1 && k <= len(text) {
+					show[k] = true
+				}
+			}
+		}
+		gap := false
+		for i, line := range text {
+			n := i + 1
+			if !show[n] {
+				gap = true
+				continue
+			}
+			if gap {
+				src.Lines = append(src.Lines, htmlSourceLine{Gap: true})
+				gap = false
+			}
+			l := htmlSourceLine{N: n, Text: line, Counts: make([]string, len(src.Cols))}
+			if c, ok := counts[n]; ok {
+				l.Counts[0], l.Counts[1], l.Counts[2], l.Counts[3] = blankZero(c.Add), blankZero(c.Sub), blankZero(c.Mul), blankZero(c.Div)
+				if r.FP != nil {
+					l.Counts[4] = blankZero(fpSum(c.FP64) + fpSum(c.FP32))
+				}
+				if hottest > 0 {
+					l.Heat = float64(weight(c)) / float64(hottest)
+				}
+			}
+			src.Lines = append(src.Lines, l)
+		}
+		if gap {
+			src.Lines = append(src.Lines, htmlSourceLine{Gap: true})
+		}
+		if len(src.Lines) > 0 {
+			out = append(out, src)
+		}
+	}
+	return out
+}
+
+// draw renders p's slices as an SVG pie, coloring them in order and
+// dropping empty ones.
+func (p *htmlPie) draw() {
+	const size, rad = 220, 100
+	var kept []htmlSlice
+	for _, s := range p.Slices {
+		if s.Value > 0 {
+			s.Color = htmlColors[len(kept)%len(htmlColors)]
+			kept = append(kept, s)
+			p.Total += s.Value
+		}
+	}
+	p.Slices = kept
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, size, size, size, size)
+	c := float64(size) / 2
+	switch {
+	case p.Total == 0:
+		fmt.Fprintf(&b, `<circle cx="%g" cy="%g" r="%d" fill="#eee"/>`, c, c, rad)
+	case len(kept) == 1:
+		fmt.Fprintf(&b, `<circle cx="%g" cy="%g" r="%d" fill="%s"><title>%s</title></circle>`,
+			c, c, rad, kept[0].Color, template.HTMLEscapeString(kept[0].Label))
+	default:
+		angle := -math.Pi / 2
+		for _, s := range kept {
+			sweep := 2 * math.Pi * float64(s.Value) / float64(p.Total)
+			x0, y0 := c+rad*math.Cos(angle), c+rad*math.Sin(angle)
+			angle += sweep
+			x1, y1 := c+rad*math.Cos(angle), c+rad*math.Sin(angle)
+			large := 0
+			if sweep > math.Pi {
+				large = 1
+			}
+			fmt.Fprintf(&b, `<path d="M%g,%g L%.2f,%.2f A%d,%d 0 %d 1 %.2f,%.2f Z" fill="%s" stroke="white"><title>%s: %d (%.1f%%)</title></path>`,
+				c, c, x0, y0, rad, rad, large, x1, y1, s.Color, template.HTMLEscapeString(s.Label), s.Value,
+				100*float64(s.Value)/float64(p.Total))
+		}
+	}
+	b.WriteString(`</svg>`)
+	p.SVG = template.HTML(b.String())
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v, total uint64) string { return fmt.Sprintf("%.1f%%", 100*float64(v)/float64(total)) },
+	"heat": func(h float64) template.CSS {
+		return template.CSS(fmt.Sprintf("background: rgba(214, 39, 40, %.2f)", 0.6*h))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Int64Profiler: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 2em; color: #222; }
+h1 { font-size: 20px; } h2 { font-size: 16px; margin-top: 2em; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+th, td { padding: 2px 8px; border-bottom: 1px solid #eee; }
+th { background: #f4f4f4; text-align: right; }
+table.sortable th { cursor: pointer; user-select: none; }
+table.sortable th.asc::after { content: " ▲"; } table.sortable th.desc::after { content: " ▼"; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+td.text, th.text { text-align: left; }
+.meta th { text-align: left; background: none; }
+.pies { display: flex; flex-wrap: wrap; gap: 3em; }
+.legend span { display: inline-block; width: 10px; height: 10px; margin-right: 6px; }
+.legend td { border: none; }
+pre, .src td.code { font-family: monospace; white-space: pre; }
+.src td { border: none; padding: 0 6px; }
+.src td.gutter { text-align: right; color: #555; font-family: monospace; }
+.src td.ln { text-align: right; color: #999; font-family: monospace; border-right: 1px solid #ccc; }
+.src tr.gap td { color: #999; }
+</style>
+</head>
+<body>
+<h1>Int64Profiler: {{.Title}}</h1>
+<table class="meta">
+{{- range .Meta}}
+<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{- end}}
+</table>
+<div class="pies">
+{{- range .Pies}}
+<div>
+<h2>{{.Title}}</h2>
+{{.SVG}}
+<table class="legend">
+{{- $total := .Total}}
+{{- range .Slices}}
+<tr><td><span style="background: {{.Color}}"></span>{{.Label}}</td><td class="num">{{.Value}}</td><td class="num">{{pct .Value $total}}</td></tr>
+{{- end}}
+</table>
+</div>
+{{- end}}
+</div>
+{{- range .Tables}}
+<h2>{{.Title}}</h2>
+<table class="sortable">
+<thead><tr>{{range .Cols}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}{{if .Num}}<td class="num" data-v="{{.Value}}">{{.Text}}</td>{{else}}<td class="text">{{.Text}}</td>{{end}}{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+{{- range .Sources}}
+{{- $ncols := len .Cols}}
+<h2>{{.File}}</h2>
+<table class="src">
+<tr>{{range .Cols}}<th>{{.}}</th>{{end}}<th>LINE</th><th class="text"></th></tr>
+{{- range .Lines}}
+{{- if .Gap}}
+<tr class="gap"><td colspan="{{$ncols}}"></td><td class="ln">⋮</td><td></td></tr>
+{{- else}}
+<tr{{if .Heat}} style="{{heat .Heat}}"{{end}}>{{range .Counts}}<td class="gutter">{{.}}</td>{{end}}<td class="ln">{{.N}}</td><td class="code">{{.Text}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- end}}
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  var heads = table.tHead.rows[0].cells;
+  Array.prototype.forEach.call(heads, function (th, col) {
+    th.addEventListener("click", function () {
+      var desc = !th.classList.contains("desc");
+      Array.prototype.forEach.call(heads, function (h) { h.classList.remove("asc", "desc"); });
+      th.classList.add(desc ? "desc" : "asc");
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col], y = b.cells[col], d;
+        if (x.dataset.v !== undefined) d = parseFloat(x.dataset.v) - parseFloat(y.dataset.v);
+        else d = x.textContent.localeCompare(y.textContent);
+        return desc ? -d : d;
+      });
+      rows.forEach(function (r) { body.appendChild(r); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,194 @@
+package profiler
+
+import (
+	"bufio"
+	"context"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runHybrid runs cmd under Pin with the pintool's projection pass, which
+// only counts the executions of every basic block, and projects the full
+// counts from them: each block, decoded and classified like the static
+// backend's x86-64 code, adds its ops once per execution.
+func (p *Profiler) runHybrid(ctx context.Context, cmd []string) (*Result, error) {
+	start := time.Now()
+	if err := checkInstrumentable(cmd[0]); err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath(cmd[0])
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "iccad-hybrid",
+		Backend:       BackendHybrid,
+		Arch:          staticArchs[elf.EM_X86_64].name,
+		Binary:        Binary{Path: path, Args: cmd},
+		Mode:          "whole",
+		Categories:    Categories{},
+	}
+	if p.opts.Func != "" {
+		f, err := elf.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		addr, ok := funcSymbol(f, path, p.opts.Func, p.opts.DebugDirs)
+		f.Close()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, p.opts.Func, path)
+		}
+		res.Mode = "address"
+		res.Region = &Region{Addr: fmt.Sprintf("%#x", addr)}
+	}
+
+	out, err := os.CreateTemp("", "int64hybrid-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	args := append(p.pinArgs("-t", p.opts.Tool, "-projection", out.Name(), "--"), cmd...)
+	capt := newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, p.pin, args...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, stdout, stderr
+	c.Env, c.Dir = p.opts.env(), p.opts.Dir
+	runErr := p.opts.run(c)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if c.Process != nil {
+		res.Binary.Pid = c.Process.Pid
+	}
+
+	if err := p.hybridCounts(out.Name(), res); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+		}
+		return nil, err
+	}
+	res.WallTimeSec = time.Since(start).Seconds()
+	res.Output = capt.output()
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
+
+// hybridCounts projects the pintool's block counts at name, one
+// "<executions> <address> <bytes> <function> <image>" line per executed
+// block, into res.
+func (p *Profiler) hybridCounts(name string, res *Result) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+
+	arch := staticArchs[elf.EM_X86_64]
+	t := p.newStaticTally(arch, res)
+	funcs := map[string]int{}
+	lines := 0
+	// per image, in the order seen: executions of bytes that did not
+	// decode and of ops in no function
+	var images []string
+	var ops uint64
+	bad, unnamed := map[string]uint64{}, map[string]uint64{}
+	miss := func(n map[string]uint64, image string, execs uint64) {
+		if _, seen := bad[image]; !seen {
+			if _, seen := unnamed[image]; !seen {
+				images = append(images, image)
+			}
+		}
+		n[image] += execs
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), " ", 5) // image paths may have spaces
+		if len(fields) != 5 {
+			return fmt.Errorf("%w: projection line %d: %q", ErrNoReport, lines+1, sc.Text())
+		}
+		lines++
+		sym := fields[3]
+		if p.opts.Func != "" && sym != p.opts.Func {
+			continue
+		}
+		execs, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: projection line %d: %v", ErrNoReport, lines, err)
+		}
+		code, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return fmt.Errorf("%w: projection line %d: %v", ErrNoReport, lines, err)
+		}
+		fn := -1
+		if sym != "-" {
+			key := sym + "\x00" + fields[4]
+			id, seen := funcs[key]
+			if !seen {
+				id = t.addFunc(sym)
+				image := fields[4]
+				if image == "-" {
+					image = ""
+				}
+				t.images = append(t.images, image)
+				funcs[key] = id
+			}
+			fn = id
+		}
+		for off, size := 0, 0; off < len(code); off += size {
+			var op staticOp
+			var ok bool
+			op, size, ok = arch.decode(code[off:])
+			insn := code[off:min(off+size, len(code))]
+			if undecodable(arch, code[off:], size, ok) {
+				miss(bad, fields[4], execs)
+			}
+			if ok {
+				t.rm = arch.mem(insn)
+				t.count(op, fn, execs)
+				t.signed(insn, op, fn, execs)
+				ops += execs
+				if fn < 0 {
+					miss(unnamed, fields[4], execs)
+				}
+			}
+			t.classify(insn, fn, execs)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if lines == 0 {
+		return fmt.Errorf("%w: the projection pass counted no blocks", ErrNoReport)
+	}
+	t.finish("")
+	for _, image := range images {
+		name := image
+		if name == "-" {
+			name = ""
+		}
+		if n := bad[image]; n > 0 {
+			res.diagnose(Diagnostic{Level: DiagWarn, Kind: DiagDecodeFailure, Image: name, Count: n,
+				Message: "executions of block bytes that did not decode, stepped over one at a time"})
+		}
+		if n := unnamed[image]; n > 0 {
+			res.diagnose(unnamedOps(name, n, ops, "ops counted in code outside any named function, in the totals only"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+//go:build linux
+
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cgroupRoot is where relative Options.Cgroup paths live.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuMask is a sched_setaffinity CPU set.
+type cpuMask [maxCPUs / 64]uint64
+
+func (m *cpuMask) affinity(trap uintptr) error {
+	_, _, e := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*m), uintptr(unsafe.Pointer(m)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// start starts c on Options.CPUs and in Options.Cgroup. The child
+// inherits the affinity of the thread forking it, so that thread is
+// pinned for the fork and restored after; with a cgroup the child is
+// cloned straight into it, before it can run or fork anything outside.
+func (o *Options) start(c *exec.Cmd) error {
+	if o.Cgroup != "" {
+		fd, err := o.openCgroup()
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(fd)
+		if c.SysProcAttr == nil {
+			c.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		c.SysProcAttr.UseCgroupFD, c.SysProcAttr.CgroupFD = true, fd
+	}
+	if len(o.CPUs) == 0 {
+		return c.Start()
+	}
+
+	runtime.LockOSThread()
+	var old, set cpuMask
+	if err := old.affinity(syscall.SYS_SCHED_GETAFFINITY); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("CPUs: %w", err)
+	}
+	for _, cpu := range o.CPUs {
+		set[cpu/64] |= 1 << (cpu % 64)
+	}
+	if err := set.affinity(syscall.SYS_SCHED_SETAFFINITY); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("CPUs %s: %w", cpuString(o.CPUs), err)
+	}
+	err := c.Start()
+	// a thread left pinned must not go back to the scheduler's pool; it
+	// exits with this goroutine instead
+	if old.affinity(syscall.SYS_SCHED_SETAFFINITY) == nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// openCgroup creates Options.Cgroup when missing, sets its cpuset to
+// Options.CPUs and returns a descriptor of its directory.
+func (o *Options) openCgroup() (int, error) {
+	dir := o.Cgroup
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cgroupRoot, dir)
+	}
+	fail := func(err error) (int, error) {
+		return -1, fmt.Errorf("cgroup %s: %w", dir, err)
+	}
+	// the nearest existing ancestor must be a cgroup v2 directory
+	up := dir
+	for {
+		if _, err := os.Stat(up); err == nil || up == "/" {
+			break
+		}
+		up = filepath.Dir(up)
+	}
+	if _, err := os.Stat(filepath.Join(up, "cgroup.controllers")); err != nil {
+		return fail(errors.New("not in a cgroup v2 hierarchy"))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fail(err)
+	}
+	if len(o.CPUs) > 0 {
+		ctl := filepath.Join(filepath.Dir(dir), "cgroup.subtree_control")
+		if b, err := os.ReadFile(ctl); err != nil {
+			return fail(err)
+		} else if !strings.Contains(" "+string(b)+" ", " cpuset ") {
+			if err := os.WriteFile(ctl, []byte("+cpuset"), 0); err != nil {
+				return fail(fmt.Errorf("enable the cpuset controller: %w", err))
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cpuset.cpus"), []byte(cpuString(o.CPUs)), 0); err != nil {
+			return fail(err)
+		}
+	}
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fail(err)
+	}
+	return fd, nil
+}
+
+// cpuString renders cpus as a cpuset list, e.g. 0,2,3.
+func cpuString(cpus []int) string {
+	s := make([]string, len(cpus))
+	for i, c := range cpus {
+		s[i] = strconv.Itoa(c)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,229 @@
+package profiler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// KernelFormat and KernelVersion identify a kernel descriptor document;
+// the version changes when a field changes meaning or goes away.
+const (
+	KernelFormat  = "iccad-kernels"
+	KernelVersion = 1
+)
+
+// KernelDescriptors hands a report's hot kernels to accelerator and
+// architecture models, such as gem5 configurations, in a versioned JSON
+// document they load as is: for each of the functions that ran the most
+// counted ops, its op mix, dependency depth, memory footprint and loop
+// trip counts. Parts the report was not recorded with are left out:
+// Dependencies needs Options.Dataflow, Memory Options.Mem or
+// Options.Footprint, Loops Options.Loops. Ops is the run's, with the
+// same sum as Loop.Ops.
+type KernelDescriptors struct {
+	Format  string   `json:"format"`
+	Version int      `json:"version"`
+	Binary  string   `json:"binary"`
+	Arch    string   `json:"arch"`
+	Ops     uint64   `json:"ops"`
+	Kernels []Kernel `json:"kernels"`
+}
+
+// Kernel describes one hot function. Share is its part of the run's ops
+// and Mix its counts by WriteCSV op type, those other than zero and mem_*.
+type Kernel struct {
+	Name         string              `json:"name"`
+	Image        string              `json:"image"`
+	File         string              `json:"file,omitempty"`
+	Line         int                 `json:"line,omitempty"`
+	Ops          uint64              `json:"ops"`
+	Share        float64             `json:"share"`
+	Mix          map[string]uint64   `json:"op_mix"`
+	Dependencies *KernelDependencies `json:"dependencies,omitempty"`
+	Memory       *KernelMemory       `json:"memory,omitempty"`
+	Loops        []KernelLoop        `json:"loops,omitempty"`
+}
+
+// KernelDependencies is the shape of a kernel's part of the Dataflow
+// graph. Depth is the most ops on one chain of values flowing forward in
+// address order, the critical path of an iteration; LoopCarried counts
+// the dependencies from one iteration to a later one, edges back to an
+// earlier or the same instruction, and Loads the loads feeding the
+// chains. Edges to or from other functions are not followed.
+type KernelDependencies struct {
+	Nodes       int `json:"nodes"`
+	Edges       int `json:"edges"`
+	Depth       int `json:"depth"`
+	LoopCarried int `json:"loop_carried"`
+	Loads       int `json:"loads"`
+}
+
+// KernelMemory is a kernel's data traffic (Options.Mem) and its
+// footprint in bytes (Options.Footprint): all it touched, and the most
+// in one window.
+type KernelMemory struct {
+	Loads         uint64 `json:"loads,omitempty"`
+	Stores        uint64 `json:"stores,omitempty"`
+	BytesRead     uint64 `json:"bytes_read,omitempty"`
+	BytesWritten  uint64 `json:"bytes_written,omitempty"`
+	Footprint     uint64 `json:"footprint_bytes,omitempty"`
+	PeakFootprint uint64 `json:"peak_footprint_bytes,omitempty"`
+	Pages         uint64 `json:"pages,omitempty"`
+}
+
+// KernelLoop is a loop of a kernel, as in Result.Loops.
+type KernelLoop struct {
+	ID              int     `json:"id"`
+	Parent          *int    `json:"parent,omitempty"`
+	Depth           int     `json:"depth"`
+	Offset          string  `json:"offset"`
+	Line            int     `json:"line,omitempty"`
+	Entries         uint64  `json:"entries"`
+	Iterations      uint64  `json:"iterations"`
+	TripCount       float64 `json:"trip_count"`
+	OpsPerIteration float64 `json:"ops_per_iteration"`
+}
+
+// Kernels describes the top functions by ops (0: all) whose share of the
+// run's ops is at least minShare.
+func (r *Result) Kernels(top int, minShare float64) (*KernelDescriptors, error) {
+	if len(r.Functions) == 0 {
+		return nil, errors.New("profiler: report has no per-function counts (record with --funcs)")
+	}
+	arch := r.Arch
+	if arch == "" {
+		arch = "amd64"
+	}
+	kd := &KernelDescriptors{Format: KernelFormat, Version: KernelVersion, Binary: r.Binary.Path, Arch: arch,
+		Ops: r.Totals.Sum() + r.Totals.BitSum() + vecSum(r.Vector), Kernels: []Kernel{}}
+	if r.FP != nil {
+		kd.Ops += r.FP.FP64.Sum() + r.FP.FP32.Sum()
+	}
+
+	funcs := append([]Function{}, r.Functions...)
+	sort.SliceStable(funcs, func(i, j int) bool { return funcOps(funcs[i]) > funcOps(funcs[j]) })
+	ops := r.csvOps()
+	for _, f := range funcs {
+		if top > 0 && len(kd.Kernels) == top {
+			break
+		}
+		k := Kernel{Name: f.Name, Image: f.Image, File: f.File, Line: f.Line, Ops: funcOps(f), Mix: map[string]uint64{}}
+		if kd.Ops > 0 {
+			k.Share = float64(k.Ops) / float64(kd.Ops)
+		}
+		if k.Ops == 0 || k.Share < minShare {
+			break
+		}
+		for i, v := range r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom) {
+			if v != 0 && !strings.HasPrefix(ops[i], "mem_") {
+				k.Mix[ops[i]] = v
+			}
+		}
+		if r.Dataflow != nil {
+			k.Dependencies = r.Dataflow.kernel(f.Name, f.Image)
+		}
+		k.Memory = r.kernelMemory(f)
+		for _, l := range r.Loops {
+			if l.Function == f.Name && l.Image == f.Image {
+				k.Loops = append(k.Loops, KernelLoop{ID: l.ID, Parent: l.Parent, Depth: l.Depth, Offset: l.Offset,
+					Line: l.Line, Entries: l.Entries, Iterations: l.Iterations,
+					TripCount: l.TripCount(), OpsPerIteration: l.OpsPerIteration()})
+			}
+		}
+		kd.Kernels = append(kd.Kernels, k)
+	}
+	return kd, nil
+}
+
+// funcOps returns f's ops, summed as Loop.Ops.
+func funcOps(f Function) uint64 {
+	return f.Sum() + f.BitSum() + vecSum(f.Vector) + fpSum(f.FP64) + fpSum(f.FP32)
+}
+
+// kernelMemory returns f's memory counts and footprint, nil without
+// either.
+func (r *Result) kernelMemory(f Function) *KernelMemory {
+	var m *KernelMemory
+	if f.Memory != nil {
+		m = &KernelMemory{Loads: f.Memory.Loads, Stores: f.Memory.Stores,
+			BytesRead: f.Memory.BytesRead, BytesWritten: f.Memory.BytesWritten}
+	}
+	if fp := r.Footprint; fp != nil {
+		for _, ff := range fp.Functions {
+			if ff.Function == f.Name && ff.Image == f.Image {
+				if m == nil {
+					m = &KernelMemory{}
+				}
+				m.Footprint, m.PeakFootprint, m.Pages = ff.Lines*fp.Line, ff.PeakLines*fp.Line, ff.Pages
+				break
+			}
+		}
+	}
+	return m
+}
+
+// kernel returns the dependencies among the nodes of function fn of
+// image, nil when it has none in the graph. Node IDs are in address
+// order, so the forward edges form a DAG whose longest path is found in
+// one pass over them sorted by source.
+func (d *Dataflow) kernel(fn, image string) *KernelDependencies {
+	in := map[int]bool{}
+	var kd KernelDependencies
+	for _, n := range d.Nodes {
+		if n.Function == fn && n.Image == image {
+			in[n.ID] = true
+			if n.Kind == "load" {
+				kd.Loads++
+			} else {
+				kd.Nodes++
+			}
+		}
+	}
+	if len(in) == 0 {
+		return nil
+	}
+	depth := map[int]int{}
+	for id := range in {
+		if d.Nodes[id].Kind != "load" {
+			depth[id] = 1
+		}
+	}
+	var forward []DataflowEdge
+	for _, e := range d.Edges {
+		if !in[e.From] || !in[e.To] {
+			continue
+		}
+		kd.Edges++
+		if e.To <= e.From {
+			kd.LoopCarried++
+		} else {
+			forward = append(forward, e)
+		}
+	}
+	sort.Slice(forward, func(i, j int) bool { return forward[i].From < forward[j].From })
+	for _, e := range forward {
+		step := 1
+		if d.Nodes[e.To].Kind == "load" {
+			step = 0
+		}
+		if v := depth[e.From] + step; v > depth[e.To] {
+			depth[e.To] = v
+		}
+	}
+	for _, v := range depth {
+		if v > kd.Depth {
+			kd.Depth = v
+		}
+	}
+	return &kd
+}
+
+// WriteJSON writes the descriptors as indented JSON.
+func (kd *KernelDescriptors) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(kd)
+}
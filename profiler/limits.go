@@ -0,0 +1,172 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrTruncated means a run was stopped at one of the Options limits, or
+// interrupted by cancelling its context; the partial Result is returned
+// with it.
+var ErrTruncated = errors.New("profiler: run stopped early")
+
+// Truncation reasons.
+const (
+	StopTimeout   = "timeout"          // Options.Timeout
+	StopMaxOps    = "max_ops"          // Options.MaxOps
+	StopMaxOutput = "max_output_bytes" // Options.MaxOutputBytes
+	StopInterrupt = "interrupt"        // the context of Profiler.Run was cancelled
+)
+
+// Truncation records which limit, or an interrupt, ended a run early: the
+// report holds the counts up to that point.
+type Truncation struct {
+	Reason     string  `json:"reason"`      // StopTimeout, StopMaxOps, StopMaxOutput or StopInterrupt
+	ElapsedSec float64 `json:"elapsed_sec"` // into the run
+}
+
+func (t *Truncation) String() string {
+	return fmt.Sprintf("%s after %.1fs", t.Reason, t.ElapsedSec)
+}
+
+// killGrace is how long a pintool asked to stop gets to write its report
+// before the watchdog kills it.
+const killGrace = 30 * time.Second
+
+// limited reports whether any run limit is set.
+func (o *Options) limited() bool {
+	return o.Timeout != 0 || o.MaxOps != 0 || o.MaxOutputBytes != 0
+}
+
+// checkLimits validates the limits for backend.
+func (o *Options) checkLimits() error {
+	if o.Timeout < 0 || o.MaxOutputBytes < 0 {
+		return errors.New("profiler: Timeout and MaxOutputBytes must not be negative")
+	}
+	switch o.Backend {
+	case BackendStatic, BackendQEMU, BackendGPU, BackendWASM, BackendHybrid:
+		if o.limited() {
+			return fmt.Errorf("%w: %s backend runs have no limits", ErrUnsupported, o.Backend)
+		}
+	case BackendPerf, BackendEBPF:
+		if o.MaxOps != 0 {
+			return fmt.Errorf("%w: %s backend counts cannot stop a run at MaxOps", ErrUnsupported, o.Backend)
+		}
+	}
+	return nil
+}
+
+// A watchdog enforces Options.Timeout and Options.MaxOutputBytes on a
+// launched target. Past the output limit the target's output is dropped.
+type watchdog struct {
+	start   time.Time
+	timeout time.Duration
+	max     int64
+
+	mu   sync.Mutex
+	n    int64
+	over chan struct{} // closed once the output exceeds max
+}
+
+func newWatchdog(o *Options) *watchdog {
+	d := &watchdog{start: time.Now(), timeout: o.Timeout, max: o.MaxOutputBytes}
+	if d.max > 0 {
+		d.over = make(chan struct{})
+	}
+	return d
+}
+
+// writer returns w (nil: discarded) counted against the output limit.
+func (d *watchdog) writer(w io.Writer) io.Writer {
+	if d.max <= 0 {
+		return w
+	}
+	if w == nil {
+		w = io.Discard
+	}
+	return &limitWriter{d: d, w: w}
+}
+
+type limitWriter struct {
+	d *watchdog
+	w io.Writer
+}
+
+func (l *limitWriter) Write(b []byte) (int, error) {
+	d := l.d
+	d.mu.Lock()
+	room := d.max - d.n
+	d.n += int64(len(b))
+	over := d.n > d.max && room >= 0
+	d.mu.Unlock()
+	if over {
+		close(d.over)
+	}
+	if room <= 0 {
+		return len(b), nil
+	}
+	if int64(len(b)) > room {
+		if _, err := l.w.Write(b[:room]); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return l.w.Write(b)
+}
+
+// wait waits for the started c. At a limit it calls stop with the reason
+// and, if c has not exited grace later, kills it. It returns the
+// truncation, if any, with c's exit error.
+func (d *watchdog) wait(c *exec.Cmd, stop func(reason string), grace time.Duration) (*Truncation, error) {
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	var timeout, kill <-chan time.Time
+	if d.timeout > 0 {
+		t := time.NewTimer(d.timeout - time.Since(d.start))
+		defer t.Stop()
+		timeout = t.C
+	}
+	over := d.over
+	var trunc *Truncation
+	hit := func(reason string) {
+		timeout, over = nil, nil
+		trunc = &Truncation{Reason: reason, ElapsedSec: time.Since(d.start).Seconds()}
+		stop(reason)
+		kill = time.After(grace)
+	}
+	for {
+		select {
+		case err := <-done:
+			return trunc, err
+		case <-timeout:
+			hit(StopTimeout)
+		case <-over:
+			hit(StopMaxOutput)
+		case <-kill:
+			c.Process.Kill()
+			kill = nil
+		}
+	}
+}
+
+// killStop stops a target at a limit by killing it.
+func killStop(c *exec.Cmd) func(string) {
+	return func(string) { c.Process.Kill() }
+}
+
+// fileStop returns a stop that asks the pintool to end the target by
+// writing the reason to its -stop_file.
+func fileStop(path string) func(string) {
+	return func(reason string) {
+		// the tool polls for the file: write it whole, then rename it in
+		tmp := path + ".part"
+		if os.WriteFile(tmp, []byte(reason+"\n"), 0o644) == nil {
+			os.Rename(tmp, path)
+		}
+	}
+}
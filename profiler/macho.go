@@ -0,0 +1,157 @@
+package profiler
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// openMachO opens the Mach-O file at path, or its cpu slice if it is a
+// universal binary. Closing the returned io.Closer releases either.
+func openMachO(path string, cpu macho.Cpu) (*macho.File, io.Closer, error) {
+	if f, err := macho.Open(path); err == nil {
+		return f, f, nil
+	}
+	fat, err := macho.OpenFat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range fat.Arches {
+		if a.Cpu == cpu {
+			return a.File, fat, nil
+		}
+	}
+	fat.Close()
+	return nil, nil, fmt.Errorf("%w: %s has no %v slice", ErrUnsupported, path, cpu)
+}
+
+// machoName strips the leading underscore the C ABI adds to Mach-O
+// symbol names (_main, _main.main).
+func machoName(s string) string { return strings.TrimPrefix(s, "_") }
+
+// machoSymbolAddr returns the link-time address of function name in the
+// x86-64 code of the Mach-O file at path.
+func machoSymbolAddr(path, name string) (uint64, error) {
+	f, c, err := openMachO(path, macho.CpuAmd64)
+	if err != nil {
+		return 0, fmt.Errorf("profiler: %w", err)
+	}
+	defer c.Close()
+	for _, fn := range machoFuncs(f) {
+		if fn.name == name {
+			return fn.start, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, name, path)
+}
+
+// machoFuncs returns the functions of f's code sections, in address
+// order. Mach-O symbols carry no size: each function runs to the next
+// symbol or the end of its section.
+func machoFuncs(f *macho.File) []staticFunc {
+	if f.Symtab == nil {
+		return nil
+	}
+	type sym struct {
+		macho.Symbol
+		end uint64
+	}
+	var syms []sym
+	for _, s := range f.Symtab.Syms {
+		// N_SECT, not a debugging (N_STAB) entry
+		if s.Type&0xe0 != 0 || s.Type&0x0e != 0x0e || s.Sect == 0 || int(s.Sect) > len(f.Sections) {
+			continue
+		}
+		sec := f.Sections[s.Sect-1]
+		if sec.Flags&machoPureInstructions == 0 {
+			continue
+		}
+		syms = append(syms, sym{s, sec.Addr + sec.Size})
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].Value < syms[j].Value })
+	var funcs []staticFunc
+	for i, s := range syms {
+		end := s.end
+		if i+1 < len(syms) && syms[i+1].Value < end {
+			end = syms[i+1].Value
+		}
+		if end > s.Value {
+			funcs = append(funcs, staticFunc{machoName(s.Name), s.Value, end})
+		}
+	}
+	return funcs
+}
+
+// S_ATTR_PURE_INSTRUCTIONS: the section holds only machine code
+const machoPureInstructions = 0x80000000
+
+// machoSigning reports whether f is signed with the hardened runtime and
+// whether its entitlements grant com.apple.security.get-task-allow, which
+// lets a debugger (Pin) take control of it.
+func machoSigning(f *macho.File) (hardened, getTaskAllow bool) {
+	be := binary.BigEndian
+	var dataOff, dataSize uint32
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) >= 16 && f.ByteOrder.Uint32(raw) == 0x1d { // LC_CODE_SIGNATURE
+			dataOff, dataSize = f.ByteOrder.Uint32(raw[8:]), f.ByteOrder.Uint32(raw[12:])
+		}
+	}
+	seg := f.Segment("__LINKEDIT")
+	if dataSize == 0 || seg == nil || uint64(dataOff) < seg.Offset {
+		return false, false
+	}
+	blob := make([]byte, dataSize)
+	if _, err := seg.ReadAt(blob, int64(uint64(dataOff)-seg.Offset)); err != nil || be.Uint32(blob) != 0xfade0cc0 {
+		return false, false
+	}
+	// a SuperBlob: count, then (type, offset) pairs of big-endian words
+	count := be.Uint32(blob[8:])
+	for i := uint32(0); i < count && 12+8*i+8 <= dataSize; i++ {
+		typ, off := be.Uint32(blob[12+8*i:]), be.Uint32(blob[16+8*i:])
+		if off+8 > dataSize {
+			continue
+		}
+		b := blob[off:]
+		switch {
+		case typ == 0 && be.Uint32(b) == 0xfade0c02 && len(b) >= 16: // code directory
+			hardened = be.Uint32(b[12:])&0x10000 != 0 // CS_RUNTIME
+		case typ == 5 && be.Uint32(b) == 0xfade7171: // XML entitlements
+			n := min(be.Uint32(b[4:]), uint32(len(b)))
+			plist := b[8:n]
+			if i := bytes.Index(plist, []byte("<key>com.apple.security.get-task-allow</key>")); i >= 0 {
+				rest := bytes.TrimLeft(plist[i+len("<key>com.apple.security.get-task-allow</key>"):], " \t\r\n")
+				getTaskAllow = bytes.HasPrefix(rest, []byte("<true/>"))
+			}
+		}
+	}
+	return hardened, getTaskAllow
+}
+
+// machoInstrumentable returns an error if macOS would refuse Pin control
+// of the executable at path: a system binary under System Integrity
+// Protection, or one signed with the hardened runtime and without the
+// get-task-allow entitlement. Other files, Mach-O or not, pass.
+func machoInstrumentable(path string) error {
+	for _, dir := range []string{"/System/", "/usr/bin/", "/usr/sbin/", "/usr/libexec/", "/bin/", "/sbin/"} {
+		if strings.HasPrefix(path, dir) {
+			return fmt.Errorf("%w: %s is protected by System Integrity Protection; profile a copy re-signed ad hoc (cp %s /tmp && codesign --force --sign - /tmp/%s)",
+				ErrUnsupported, path, path, filepath.Base(path))
+		}
+	}
+	f, c, err := openMachO(path, macho.CpuAmd64)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	if hardened, allow := machoSigning(f); hardened && !allow {
+		return fmt.Errorf("%w: %s is signed with the hardened runtime and lacks com.apple.security.get-task-allow; re-sign it ad hoc (codesign --force --sign - %s) or add the entitlement",
+			ErrUnsupported, path, path)
+	}
+	return nil
+}
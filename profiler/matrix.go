@@ -0,0 +1,317 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// matrixFunctions is how many functions Matrix.WriteText shows.
+const matrixFunctions = 20
+
+// Matrix compares runs of one workload built several ways, with other
+// compilers or flags, counter by counter (NewMatrix). Counts are by
+// Counters and then by Builds.
+type Matrix struct {
+	Builds    []string         `json:"builds"`
+	Counters  []string         `json:"counters"`
+	Totals    [][]uint64       `json:"totals"`
+	WallTime  []float64        `json:"wall_time_sec"`       // by build
+	Functions []MatrixFunction `json:"functions,omitempty"` // the most int ops in any build first
+	Changes   []MatrixChange   `json:"changes,omitempty"`
+}
+
+// MatrixFunction is one function's counts in each build, matched by
+// name; zero in a build that inlined it away or has no such function.
+type MatrixFunction struct {
+	Name   string     `json:"name"`
+	Counts [][]uint64 `json:"counts"` // by Matrix.Counters, then Builds
+}
+
+// MatrixChange is a counter of Build that is half or less, or twice or
+// more, the first build's. Note says what a fall in divisions most
+// likely means.
+type MatrixChange struct {
+	Build   string `json:"build"`
+	Counter string `json:"counter"`
+	Base    uint64 `json:"base"`
+	Count   uint64 `json:"count"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Pct returns the change from Base in percent, 0 when Base is.
+func (c MatrixChange) Pct() float64 {
+	if c.Base == 0 {
+		return 0
+	}
+	return 100 * (float64(c.Count) - float64(c.Base)) / float64(c.Base)
+}
+
+// NewMatrix compares results, the runs of the builds labelled builds,
+// the first one the baseline. The counters are the categories, the
+// division sites by divisor class when a run has them (Options.Divs),
+// and the vector and FP ops when a run counted them; the per-function
+// counts need Options.Funcs.
+func NewMatrix(builds []string, results []*Result) (*Matrix, error) {
+	if len(results) == 0 || len(builds) != len(results) {
+		return nil, errors.New("profiler: want one result per build")
+	}
+	m := &Matrix{Builds: builds}
+	m.Counters = append(m.Counters, CategoryNames...)
+	var divs, vec, fp bool
+	for _, c := range BitCategoryNames {
+		for _, r := range results {
+			if _, ok := r.Categories[c]; ok {
+				m.Counters = append(m.Counters, c)
+				break
+			}
+		}
+	}
+	for _, r := range results {
+		divs = divs || r.Divisors != nil
+		vec = vec || r.Vector != nil
+		fp = fp || r.FP != nil
+	}
+	if divs {
+		m.Counters = append(m.Counters, "div_pow2", "div_constant", "div_variable")
+	}
+	if vec {
+		m.Counters = append(m.Counters, "vec")
+	}
+	if fp {
+		m.Counters = append(m.Counters, "fp64", "fp32")
+	}
+
+	m.Totals = make([][]uint64, len(m.Counters))
+	for i, c := range m.Counters {
+		m.Totals[i] = make([]uint64, len(results))
+		for b, r := range results {
+			m.Totals[i][b] = matrixTotal(r, c)
+		}
+	}
+	for _, r := range results {
+		m.WallTime = append(m.WallTime, r.WallTimeSec)
+	}
+
+	byName := map[string]*MatrixFunction{}
+	most := map[string]uint64{}
+	var order []string
+	for b, r := range results {
+		for _, f := range r.Functions {
+			mf, ok := byName[f.Name]
+			if !ok {
+				mf = &MatrixFunction{Name: f.Name, Counts: make([][]uint64, len(m.Counters))}
+				for i := range mf.Counts {
+					mf.Counts[i] = make([]uint64, len(results))
+				}
+				byName[f.Name] = mf
+				order = append(order, f.Name)
+			}
+			for i, c := range m.Counters {
+				mf.Counts[i][b] += matrixFunction(f, c)
+			}
+			most[f.Name] = max(most[f.Name], f.Sum())
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return most[order[i]] > most[order[j]] })
+	for _, name := range order {
+		m.Functions = append(m.Functions, *byName[name])
+	}
+	m.changes()
+	return m, nil
+}
+
+// matrixTotal returns counter c of r's totals.
+func matrixTotal(r *Result, c string) uint64 {
+	switch c {
+	case "div_pow2", "div_constant", "div_variable":
+		if r.Divisors == nil {
+			return 0
+		}
+		return map[string]uint64{"div_pow2": r.Divisors.Pow2, "div_constant": r.Divisors.Constant, "div_variable": r.Divisors.Variable}[c]
+	case "vec":
+		return vecSum(r.Vector)
+	case "fp64", "fp32":
+		if r.FP == nil {
+			return 0
+		}
+		if c == "fp64" {
+			return r.FP.FP64.Sum()
+		}
+		return r.FP.FP32.Sum()
+	}
+	return r.Totals.Get(c)
+}
+
+// matrixFunction returns counter c of f; the division classes are only
+// counted per run.
+func matrixFunction(f Function, c string) uint64 {
+	switch c {
+	case "div_pow2", "div_constant", "div_variable":
+		return 0
+	case "vec":
+		return vecSum(f.Vector)
+	case "fp64":
+		return fpSum(f.FP64)
+	case "fp32":
+		return fpSum(f.FP32)
+	}
+	return f.Get(c)
+}
+
+// changes fills in m.Changes.
+func (m *Matrix) changes() {
+	idx := map[string]int{}
+	for i, c := range m.Counters {
+		idx[c] = i
+	}
+	for b := 1; b < len(m.Builds); b++ {
+		for i, c := range m.Counters {
+			base, n := m.Totals[i][0], m.Totals[i][b]
+			if n == base || (2*n > base && n < 2*base) {
+				continue
+			}
+			ch := MatrixChange{Build: m.Builds[b], Counter: c, Base: base, Count: n}
+			if c == "div" && n < base {
+				var to []string
+				for _, o := range []string{"mul", "shr"} {
+					if j, ok := idx[o]; ok && m.Totals[j][b] > m.Totals[j][0] {
+						to = append(to, o)
+					}
+				}
+				if len(to) > 0 {
+					ch.Note = "divisions strength-reduced to " + strings.Join(to, " and ")
+				} else {
+					ch.Note = "divisions removed or hoisted"
+				}
+			}
+			m.Changes = append(m.Changes, ch)
+		}
+	}
+}
+
+// WriteText renders the counters side by side, the int ops' mix, the
+// big changes from the first build and the busiest functions.
+func (m *Matrix) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	width := 14
+	for _, b := range m.Builds {
+		width = max(width, len(b)+2)
+	}
+	header := func(first string) {
+		fmt.Fprintf(bw, "  %-14s", first)
+		for _, b := range m.Builds {
+			fmt.Fprintf(bw, "%*s", width, b)
+		}
+		fmt.Fprintln(bw)
+	}
+	row := func(label string, vs []uint64) {
+		fmt.Fprintf(bw, "  %-14s", label)
+		for _, v := range vs {
+			fmt.Fprintf(bw, "%*d", width, v)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintf(bw, "----- Totals by build -----\n")
+	header("COUNTER")
+	for i, c := range m.Counters {
+		row(strings.ToUpper(c), m.Totals[i])
+	}
+	fmt.Fprintf(bw, "  %-14s", "WALL(s)")
+	for _, t := range m.WallTime {
+		fmt.Fprintf(bw, "%*.3f", width, t)
+	}
+	fmt.Fprintln(bw)
+
+	fmt.Fprintf(bw, "\n----- Mix (share of add+sub+mul+div) -----\n")
+	header("CATEGORY")
+	for i, c := range CategoryNames {
+		fmt.Fprintf(bw, "  %-14s", strings.ToUpper(c))
+		for b := range m.Builds {
+			var all uint64
+			for j := range CategoryNames {
+				all += m.Totals[j][b]
+			}
+			share := 0.0
+			if all > 0 {
+				share = 100 * float64(m.Totals[i][b]) / float64(all)
+			}
+			fmt.Fprintf(bw, "%*s", width, fmt.Sprintf("%.1f%%", share))
+		}
+		fmt.Fprintln(bw)
+	}
+
+	if len(m.Changes) > 0 {
+		fmt.Fprintf(bw, "\n----- Changes from %s (halved or doubled) -----\n", m.Builds[0])
+		for _, c := range m.Changes {
+			pct := "new"
+			if c.Base > 0 {
+				pct = fmt.Sprintf("%+.1f%%", c.Pct())
+			}
+			fmt.Fprintf(bw, "  %-14s %-14s %12d → %-12d %10s", c.Build, strings.ToUpper(c.Counter), c.Base, c.Count, pct)
+			if c.Note != "" {
+				fmt.Fprintf(bw, "  %s", c.Note)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	if len(m.Functions) > 0 {
+		fmt.Fprintf(bw, "\n----- Busiest functions -----\n")
+		for _, f := range m.Functions[:min(matrixFunctions, len(m.Functions))] {
+			fmt.Fprintf(bw, "%s\n", f.Name)
+			for i, c := range m.Counters {
+				for _, v := range f.Counts[i] {
+					if v != 0 {
+						row(strings.ToUpper(c), f.Counts[i])
+						break
+					}
+				}
+			}
+		}
+		if n := len(m.Functions) - matrixFunctions; n > 0 {
+			fmt.Fprintf(bw, "(%d more functions in the JSON and CSV forms)\n", n)
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteCSV renders the counters with one column per build:
+//
+//	scope,function,counter,<build>…
+//
+// with scope "total" for the runs' totals and "function" for every
+// function's nonzero counters; comma is ',' for CSV or '\t' for TSV.
+func (m *Matrix) WriteCSV(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	cw.Write(append([]string{"scope", "function", "counter"}, m.Builds...))
+	row := func(scope, function, counter string, vs []uint64) {
+		r := []string{scope, function, counter}
+		for _, v := range vs {
+			r = append(r, strconv.FormatUint(v, 10))
+		}
+		cw.Write(r)
+	}
+	for i, c := range m.Counters {
+		row("total", "", c, m.Totals[i])
+	}
+	for _, f := range m.Functions {
+		for i, c := range m.Counters {
+			for _, v := range f.Counts[i] {
+				if v != 0 {
+					row("function", f.Name, c, f.Counts[i])
+					break
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,110 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Metrics serves the latest Snapshot of a streaming run in the Prometheus
+// text exposition format, for scraping arithmetic rates off a profiled
+// service. Pass Update as Options.OnSnapshot and mount the Metrics on an
+// HTTP server, usually at /metrics; until the first snapshot it serves no
+// samples. The zero value is ready to use.
+type Metrics struct {
+	mu   sync.Mutex
+	last *Snapshot
+}
+
+// Update records s as the snapshot to serve.
+func (m *Metrics) Update(s Snapshot) {
+	m.mu.Lock()
+	m.last = &s
+	m.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics of the latest snapshot.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	s := m.last
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if s != nil {
+		s.WriteMetrics(w)
+	}
+}
+
+// metricsMemory maps the memory SnapshotCounts to their metric and label.
+var metricsMemory = map[string][2]string{
+	"loads":         {"memory_accesses", `kind="load"`},
+	"stores":        {"memory_accesses", `kind="store"`},
+	"bytes_read":    {"memory_bytes", `direction="read"`},
+	"bytes_written": {"memory_bytes", `direction="write"`},
+}
+
+// WriteMetrics writes s in the Prometheus text exposition format. The
+// counts are counters labelled with the pid, so a restarted target starts
+// new series:
+//
+//	int64profiler_ops_total{pid, category}
+//	int64profiler_function_ops_total{pid, function, image, category}
+//	int64profiler_memory_accesses_total{pid, kind}     (Options.Mem)
+//	int64profiler_memory_bytes_total{pid, direction}   (Options.Mem)
+//
+// int64profiler_elapsed_seconds, int64profiler_threads and
+// int64profiler_exited are gauges.
+func (s *Snapshot) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	pid := fmt.Sprintf(`pid="%d"`, s.Pid)
+	header := func(name, kind, help string) {
+		fmt.Fprintf(bw, "# HELP int64profiler_%s %s\n# TYPE int64profiler_%s %s\n", name, help, name, kind)
+	}
+
+	header("ops_total", "counter", "Operations executed since profiling started, by category.")
+	for _, c := range s.Categories() {
+		if _, ok := metricsMemory[c]; !ok {
+			fmt.Fprintf(bw, "int64profiler_ops_total{%s,category=%q} %d\n", pid, c, s.Cumulative[c])
+		}
+	}
+	if len(s.Functions) > 0 {
+		header("function_ops_total", "counter", "Operations executed since profiling started by the busiest functions, by category.")
+		for _, f := range s.Functions {
+			labels := fmt.Sprintf(`%s,function="%s",image="%s"`, pid, metricsLabel(f.Name), metricsLabel(f.Image))
+			for _, c := range categoriesOf(f.Cumulative) {
+				if _, ok := metricsMemory[c]; !ok {
+					fmt.Fprintf(bw, "int64profiler_function_ops_total{%s,category=%q} %d\n", labels, c, f.Cumulative[c])
+				}
+			}
+		}
+	}
+	if _, ok := s.Cumulative["loads"]; ok {
+		header("memory_accesses_total", "counter", "Memory loads and stores since profiling started.")
+		for _, c := range []string{"loads", "stores"} {
+			fmt.Fprintf(bw, "int64profiler_memory_accesses_total{%s,%s} %d\n", pid, metricsMemory[c][1], s.Cumulative[c])
+		}
+		header("memory_bytes_total", "counter", "Bytes read and written since profiling started.")
+		for _, c := range []string{"bytes_read", "bytes_written"} {
+			fmt.Fprintf(bw, "int64profiler_memory_bytes_total{%s,%s} %d\n", pid, metricsMemory[c][1], s.Cumulative[c])
+		}
+	}
+
+	header("elapsed_seconds", "gauge", "Seconds since profiling started, as of the latest snapshot.")
+	fmt.Fprintf(bw, "int64profiler_elapsed_seconds{%s} %g\n", pid, s.Elapsed)
+	header("threads", "gauge", "Threads the target has started.")
+	fmt.Fprintf(bw, "int64profiler_threads{%s} %d\n", pid, s.Threads)
+	header("exited", "gauge", "1 once the target has exited or the profiler detached.")
+	exited := 0
+	if s.Final {
+		exited = 1
+	}
+	fmt.Fprintf(bw, "int64profiler_exited{%s} %d\n", pid, exited)
+	return bw.Flush()
+}
+
+// metricsLabel escapes a label value: backslash, double quote and newline.
+func metricsLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,91 @@
+package profiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// migrations upgrade a decoded report one schema version at a time:
+// migrations[v] turns a version v report into a version v+1 one. They
+// work on the JSON objects, not on Result, so that fields this package
+// does not know survive a migration. Renaming or removing a field bumps
+// SchemaVersion and adds one here.
+var migrations = [SchemaVersion]func(map[string]any) error{
+	0: migrateV0,
+}
+
+// migrateV0 upgrades a report written without a schema_version to
+// version 1, filling in the fields version 1 readers expect: the tool,
+// and the counting mode, of the whole process unless the report has a
+// region.
+func migrateV0(m map[string]any) error {
+	if _, ok := m["totals"]; !ok {
+		return errors.New("profiler: migrate: not a report: no totals")
+	}
+	if _, ok := m["tool"]; !ok {
+		m["tool"] = "Int64Profiler"
+	}
+	if _, ok := m["mode"]; !ok {
+		m["mode"] = "whole"
+		if r, ok := m["region"].(map[string]any); ok {
+			m["mode"] = "marker"
+			if _, ok := r["addr"]; ok {
+				m["mode"] = "address"
+			}
+		}
+	}
+	return nil
+}
+
+// schemaVersionOf returns the schema_version of the report object m, 0
+// when it has none.
+func schemaVersionOf(m map[string]any) (int, error) {
+	v, ok := m["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("profiler: schema_version %v is not a number", v)
+	}
+	i, err := n.Int64()
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("profiler: schema_version %v is not a version", v)
+	}
+	return int(i), nil
+}
+
+// Migrate upgrades the JSON report data to SchemaVersion and returns it
+// with the version it had. A report already at SchemaVersion is returned
+// as it is; one newer than that is an ErrUnsupportedSchema.
+func Migrate(data []byte) ([]byte, int, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber() // counts above 2^53 must not round
+	var m map[string]any
+	if err := d.Decode(&m); err != nil {
+		return nil, 0, fmt.Errorf("profiler: decode report: %w", err)
+	}
+	from, err := schemaVersionOf(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from > SchemaVersion {
+		return nil, from, fmt.Errorf("%w: version %d (newest known %d)", ErrUnsupportedSchema, from, SchemaVersion)
+	}
+	if from == SchemaVersion {
+		return data, from, nil
+	}
+	for v := from; v < SchemaVersion; v++ {
+		if err := migrations[v](m); err != nil {
+			return nil, from, fmt.Errorf("%w (from version %d)", err, v)
+		}
+		m["schema_version"] = v + 1
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, from, fmt.Errorf("profiler: migrate: %w", err)
+	}
+	return append(out, '\n'), from, nil
+}
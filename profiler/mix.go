@@ -0,0 +1,56 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Mix is the instruction mix of a run (Options.Mix): every instruction
+// counted, and the control transfers and moves among them. Conditional
+// branches are split by outcome; Indirect counts the jumps and calls
+// through a register or memory, which are also in Jump and Call. Moves
+// are the MOV, MOVZX/MOVSX, CMOV and vector move family, register to
+// register, to or from memory, or of an immediate. Other is the rest,
+// arithmetic included.
+type Mix struct {
+	Instructions   uint64 `json:"instructions"`
+	BranchTaken    uint64 `json:"branch_taken"`
+	BranchNotTaken uint64 `json:"branch_not_taken"`
+	Jump           uint64 `json:"jump"`
+	Call           uint64 `json:"call"`
+	Ret            uint64 `json:"ret"`
+	Indirect       uint64 `json:"indirect"`
+	MoveReg        uint64 `json:"move_reg"`
+	MoveMem        uint64 `json:"move_mem"`
+	MoveImm        uint64 `json:"move_imm"`
+	Other          uint64 `json:"other"`
+}
+
+// Control returns the control transfers: branches, jumps, calls and returns.
+func (m Mix) Control() uint64 {
+	return m.BranchTaken + m.BranchNotTaken + m.Jump + m.Call + m.Ret
+}
+
+// Moves returns the moves of all kinds.
+func (m Mix) Moves() uint64 { return m.MoveReg + m.MoveMem + m.MoveImm }
+
+// writeMix renders the mix with each kind's share of the instructions.
+func writeMix(w io.Writer, m *Mix) {
+	fmt.Fprintf(w, "\n----- Instruction mix -----\n")
+	fmt.Fprintf(w, "%-18s%14s%8s\n", "KIND", "COUNT", "PCT")
+	for _, k := range []struct {
+		name string
+		n    uint64
+	}{
+		{"branch_taken", m.BranchTaken}, {"branch_not_taken", m.BranchNotTaken},
+		{"jump", m.Jump}, {"call", m.Call}, {"ret", m.Ret}, {"  indirect", m.Indirect},
+		{"move_reg", m.MoveReg}, {"move_mem", m.MoveMem}, {"move_imm", m.MoveImm},
+		{"other", m.Other}, {"total", m.Instructions},
+	} {
+		share := 0.0
+		if m.Instructions > 0 {
+			share = 100 * float64(k.n) / float64(m.Instructions)
+		}
+		fmt.Fprintf(w, "%-18s%14d%8s\n", k.name, k.n, fmt.Sprintf("%.1f%%", share))
+	}
+}
@@ -0,0 +1,188 @@
+package profiler
+
+import "fmt"
+
+// Name modes of Options.Names and Result.SetNames.
+const (
+	NamesDemangled = "demangled" // C++ and Rust symbols demangled (the default)
+	NamesRaw       = "raw"       // symbols as the images have them
+	NamesBoth      = "both"      // demangled, with csv, tsv, html and dot giving the raw symbol too
+)
+
+// NameModes lists the name modes.
+var NameModes = []string{NamesDemangled, NamesRaw, NamesBoth}
+
+// CheckNames validates a name mode; "" is NamesDemangled.
+func CheckNames(mode string) error {
+	switch mode {
+	case "", NamesDemangled, NamesRaw, NamesBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown names mode %q", mode)
+}
+
+// SetNames names the functions of r as mode says. Demangled (Demangle),
+// the symbol is kept in Function.Mangled and CallGraphFunction.Mangled
+// and what else names a function (loops, blocks, call stacks, division
+// sites…) is demangled alike; raw turns a demangled report back. Decode
+// and Profiler.Run demangle, so this is for changing modes, as
+// iccad report -names does. The filters (Options.Include, IncludeFunc…)
+// match the raw symbols whatever the mode.
+func (r *Result) SetNames(mode string) error {
+	if err := CheckNames(mode); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if mode == "" {
+		mode = NamesDemangled
+	}
+	if r.rawNames == nil {
+		r.rawNames = map[string]string{}
+	}
+	for _, f := range r.Functions {
+		if f.Mangled != "" {
+			r.rawNames[f.Name] = f.Mangled
+		}
+	}
+	if g := r.CallGraph; g != nil {
+		for _, f := range g.Functions {
+			if f.Mangled != "" {
+				r.rawNames[f.Name] = f.Mangled
+			}
+		}
+	}
+
+	rename := func(s *string) {
+		if mode == NamesRaw {
+			if raw, ok := r.rawNames[*s]; ok {
+				*s = raw
+			}
+			return
+		}
+		if _, ok := r.rawNames[*s]; ok {
+			return // demangled already
+		}
+		if d := Demangle(*s); d != *s {
+			r.rawNames[d] = *s
+			*s = d
+		}
+	}
+	mangled := func(name, mangled *string) {
+		if mode == NamesRaw {
+			if *mangled != "" {
+				*name, *mangled = *mangled, ""
+			}
+			return
+		}
+		if *mangled == "" {
+			raw := *name
+			if rename(name); *name != raw {
+				*mangled = raw
+			}
+		}
+	}
+
+	for i := range r.Functions {
+		f := &r.Functions[i]
+		mangled(&f.Name, &f.Mangled)
+	}
+	if g := r.CallGraph; g != nil {
+		for i := range g.Functions {
+			f := &g.Functions[i]
+			mangled(&f.Name, &f.Mangled)
+		}
+		for _, s := range g.Stacks {
+			for i := range s.Frames {
+				rename(&s.Frames[i])
+			}
+		}
+	}
+	r.eachFunctionName(rename)
+	r.names = mode
+	return nil
+}
+
+// eachFunctionName calls f with every function name of r but those of
+// Functions and CallGraph.
+func (r *Result) eachFunctionName(f func(*string)) {
+	if b := r.Butterflies; b != nil {
+		for i := range b.Transforms {
+			f(&b.Transforms[i].Function)
+		}
+	}
+	if d := r.Divisors; d != nil {
+		for i := range d.Sites {
+			f(&d.Sites[i].Function)
+		}
+	}
+	if b := r.Branches; b != nil {
+		for i := range b.Top {
+			f(&b.Top[i].Function)
+		}
+	}
+	if s := r.Strides; s != nil {
+		for i := range s.Top {
+			f(&s.Top[i].Function)
+		}
+	}
+	if u := r.Reuse; u != nil {
+		for i := range u.Top {
+			f(&u.Top[i].Function)
+		}
+		for i := range u.Sites {
+			f(&u.Sites[i].Function)
+		}
+	}
+	if fp := r.Footprint; fp != nil {
+		for i := range fp.Functions {
+			f(&fp.Functions[i].Function)
+		}
+	}
+	if t := r.Top; t != nil {
+		for i := range t.Categories {
+			for j := range t.Categories[i].Rows {
+				f(&t.Categories[i].Rows[j].Function)
+			}
+		}
+	}
+	for i := range r.Loops {
+		f(&r.Loops[i].Function)
+	}
+	for i := range r.Blocks {
+		f(&r.Blocks[i].Function)
+	}
+	for i := range r.Annotated {
+		f(&r.Annotated[i].Function)
+	}
+	if d := r.Dataflow; d != nil {
+		for i := range d.Nodes {
+			f(&d.Nodes[i].Function)
+		}
+	}
+	if d := r.DeadWork; d != nil {
+		for i := range d.Functions {
+			f(&d.Functions[i].Function)
+		}
+		for i := range d.Sites {
+			f(&d.Sites[i].Function)
+		}
+	}
+	if p := r.Perf; p != nil {
+		for i := range p.Functions {
+			f(&p.Functions[i].Name)
+		}
+	}
+	if g := r.GPU; g != nil {
+		for i := range g.Kernels {
+			f(&g.Kernels[i].Name)
+		}
+	}
+}
+
+// rawName returns the symbol name was demangled from, name itself when
+// it was not.
+func (r *Result) rawName(name string) string {
+	if raw, ok := r.rawNames[name]; ok {
+		return raw
+	}
+	return name
+}
@@ -0,0 +1,253 @@
+package profiler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Calibration is what the tool itself costs with a set of options, as
+// measured by Profiler.Calibrate: how long a workload that does nothing
+// takes under it, and how much slower known micro-kernels run under it
+// than natively. Options.Calibration turns it into a Result.Overhead.
+type Calibration struct {
+	Key        string         `json:"key"` // Profiler.CalibrationKey
+	Time       time.Time      `json:"time"`
+	StartupSec float64        `json:"startup_sec"` // Pin and tool start-up and exit
+	Slowdown   float64        `json:"slowdown"`    // geometric mean of the kernels'
+	Kernels    []KernelTiming `json:"kernels"`
+}
+
+// KernelTiming is one micro-kernel of a Calibration: its best native and
+// profiled times, start-up excluded from both.
+type KernelTiming struct {
+	Name        string  `json:"name"`
+	NativeSec   float64 `json:"native_sec"`
+	ProfiledSec float64 `json:"profiled_sec"`
+	Slowdown    float64 `json:"slowdown"`
+}
+
+// Overhead estimates how much of a launched run's wall time the tool
+// added, from Options.Calibration: the workload alone would have taken
+// about NativeSec, what is left of WallSec after start-up divided by the
+// slowdown. Kernels are not the workload, so it is an estimate, and a
+// rough one for workloads far from them (mostly system calls or I/O).
+type Overhead struct {
+	WallSec    float64   `json:"wall_sec"` // the run under the tool, start-up included
+	StartupSec float64   `json:"startup_sec"`
+	Slowdown   float64   `json:"slowdown"`
+	NativeSec  float64   `json:"native_sec"`
+	ToolSec    float64   `json:"tool_sec"` // WallSec - NativeSec
+	Calibrated time.Time `json:"calibrated"`
+}
+
+func (o *Overhead) String() string {
+	return fmt.Sprintf("%.2f s wall ≈ %.2f s workload + %.2f s tool (%.2f s start-up, %.1fx slowdown, calibrated %s)",
+		o.WallSec, o.NativeSec, o.ToolSec, o.StartupSec, o.Slowdown, o.Calibrated.Format("2006-01-02"))
+}
+
+// estimate is the Overhead of a run that took wall.
+func (c *Calibration) estimate(wall time.Duration) *Overhead {
+	o := &Overhead{WallSec: wall.Seconds(), StartupSec: c.StartupSec, Slowdown: c.Slowdown, Calibrated: c.Time}
+	o.NativeSec = math.Max(0, o.WallSec-o.StartupSec) / math.Max(1, c.Slowdown)
+	o.ToolSec = o.WallSec - o.NativeSec
+	return o
+}
+
+// calibrationRuns is how often Calibrate times each command natively and
+// under the tool; the fastest run counts.
+const calibrationRuns = 3
+
+// calibrator returns a copy of p for calibration runs: the options that
+// depend on the target or write files of their own are cleared.
+func (p *Profiler) calibrator() *Profiler {
+	q := *p
+	o := &q.opts
+	o.Func, o.StartMarker, o.StopMarker = "", "", ""
+	o.Checkpoint, o.CheckpointInterval, o.Resume = "", 0, ""
+	o.SyscallTrace, o.TimeSeries, o.Trace, o.TraceFormat = "", "", "", ""
+	o.Stream, o.StreamFuncs, o.OnSnapshot = 0, 0, nil
+	o.Timeout, o.MaxOps, o.MaxOutputBytes = 0, 0, 0
+	o.Stdin, o.Stdout, o.Stderr = nil, nil, nil
+	o.Calibration = nil
+	q.watches = nil
+	return &q
+}
+
+// CalibrationKey identifies what a Calibration of p measures: the Pin
+// kit, the pintool build and the knobs p's options turn into, the target
+// aside. Pin backend only.
+func (p *Profiler) CalibrationKey() (string, error) {
+	if p.opts.Backend != BackendPin {
+		return "", fmt.Errorf("%w: calibration needs the pin backend", ErrUnsupported)
+	}
+	args, err := p.calibrator().toolArgs("")
+	if err != nil {
+		return "", err
+	}
+	st, err := os.Stat(p.opts.Tool)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrToolNotFound, p.opts.Tool)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d %d\n%s\n%v\n", p.pin, st.Size(), st.ModTime().UnixNano(),
+		strings.Join(p.pinArgs(args...), "\x00"), p.opts.CPUs)
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// Calibrate measures p's own overhead. empty is a small program that does
+// nothing, for start-up; kernels are micro-kernels by name, each running
+// for a fraction of a second natively, and null is the kernels' program
+// doing nothing, whose time is taken off theirs. All are timed natively
+// and under the tool with p's options, calibrationRuns times each.
+func (p *Profiler) Calibrate(ctx context.Context, empty, null []string, kernels map[string][]string) (*Calibration, error) {
+	key, err := p.CalibrationKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(kernels) == 0 {
+		return nil, errors.New("profiler: calibration needs a kernel")
+	}
+	q := p.calibrator()
+	native := func(cmd []string) (time.Duration, error) {
+		c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		c.Env, c.Dir = p.opts.Env, p.opts.Dir
+		t0 := time.Now()
+		if err := p.opts.run(c); err != nil {
+			return 0, fmt.Errorf("profiler: calibrate: %s: %w", strings.Join(cmd, " "), err)
+		}
+		return time.Since(t0), nil
+	}
+	profiled := func(cmd []string) (time.Duration, error) {
+		t0 := time.Now()
+		if _, err := q.Run(ctx, cmd); err != nil {
+			return 0, fmt.Errorf("profiler: calibrate: %s: %w", strings.Join(cmd, " "), err)
+		}
+		return time.Since(t0), nil
+	}
+	best := func(cmd []string, run func([]string) (time.Duration, error)) (float64, error) {
+		min := time.Duration(math.MaxInt64)
+		for i := 0; i < calibrationRuns; i++ {
+			d, err := run(cmd)
+			if err != nil {
+				return 0, err
+			}
+			if d < min {
+				min = d
+			}
+		}
+		return min.Seconds(), nil
+	}
+
+	emptyNative, err := best(empty, native)
+	if err != nil {
+		return nil, err
+	}
+	emptyProfiled, err := best(empty, profiled)
+	if err != nil {
+		return nil, err
+	}
+	nullNative, err := best(null, native)
+	if err != nil {
+		return nil, err
+	}
+	nullProfiled, err := best(null, profiled)
+	if err != nil {
+		return nil, err
+	}
+	c := &Calibration{Key: key, Time: time.Now().UTC(), StartupSec: math.Max(0, emptyProfiled-emptyNative)}
+	names := make([]string, 0, len(kernels))
+	for name := range kernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	logSum := 0.0
+	for _, name := range names {
+		n, err := best(kernels[name], native)
+		if err != nil {
+			return nil, err
+		}
+		pr, err := best(kernels[name], profiled)
+		if err != nil {
+			return nil, err
+		}
+		k := KernelTiming{Name: name, NativeSec: math.Max(n-nullNative, 1e-6),
+			ProfiledSec: math.Max(pr-nullProfiled, 0)}
+		k.Slowdown = math.Max(1, k.ProfiledSec/k.NativeSec)
+		logSum += math.Log(k.Slowdown)
+		c.Kernels = append(c.Kernels, k)
+	}
+	c.Slowdown = math.Exp(logSum / float64(len(c.Kernels)))
+	return c, nil
+}
+
+// DefaultCalibrationPath is $ICCAD_CALIBRATION, else
+// $HOME/.iccad/calibration.json.
+func DefaultCalibrationPath() (string, error) {
+	if p := os.Getenv("ICCAD_CALIBRATION"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("profiler: calibration: %w", err)
+	}
+	return filepath.Join(home, ".iccad", "calibration.json"), nil
+}
+
+// readCalibrations decodes the calibration file at path, by key; a
+// missing file holds none.
+func readCalibrations(path string) (map[string]*Calibration, error) {
+	cs := map[string]*Calibration{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profiler: calibration: %w", err)
+	}
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return nil, fmt.Errorf("profiler: calibration %s: %w", path, err)
+	}
+	return cs, nil
+}
+
+// LoadCalibration returns the calibration saved in the file at path under
+// key, or nil when there is none.
+func LoadCalibration(path, key string) (*Calibration, error) {
+	cs, err := readCalibrations(path)
+	if err != nil {
+		return nil, err
+	}
+	return cs[key], nil
+}
+
+// SaveCalibration stores c in the file at path, replacing the one with the
+// same key.
+func SaveCalibration(path string, c *Calibration) error {
+	cs, err := readCalibrations(path)
+	if err != nil {
+		return err
+	}
+	cs[c.Key] = c
+	b, err := json.MarshalIndent(cs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("profiler: calibration: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("profiler: calibration: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("profiler: calibration: %w", err)
+	}
+	return nil
+}
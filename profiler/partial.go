@@ -0,0 +1,85 @@
+package profiler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// ErrPartial means the tool was killed before it wrote its report (a
+// SIGKILL, the OOM killer): the Result returned with it holds only the
+// totals of the tool's last update, see Result.Partial.
+var ErrPartial = errors.New("profiler: no report, partial totals")
+
+// Partial says a Result was read from the totals the tool keeps updating
+// while the target runs (every second), not from its report: those of
+// update Update, ElapsedSec into the run. Every other section is missing.
+type Partial struct {
+	Update     int     `json:"update"`
+	ElapsedSec float64 `json:"elapsed_sec"`
+}
+
+func (p *Partial) String() string {
+	return fmt.Sprintf("no report; totals of update %d, %.1f s into the run", p.Update, p.ElapsedSec)
+}
+
+// partialTotals reports whether launched runs keep partial totals: not
+// across exec'd children, nor sampled (the totals are not extrapolated),
+// nor on Windows.
+func (o *Options) partialTotals() bool {
+	return !o.FollowChildren && o.Sample == 0 && runtime.GOOS != "windows"
+}
+
+// Layout of the pintool's -partial file: a magic, the number of the last
+// complete update, then two slots of a length and a JSON snapshot, update
+// n in slot n % 2.
+const (
+	partialMagic = "I64PART1"
+	partialSlot  = 4096
+)
+
+// readPartial returns the Result of the last update in the -partial file at
+// path.
+func readPartial(path string) (*Result, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if len(b) < 16+2*partialSlot || string(b[:8]) != partialMagic {
+		return nil, fmt.Errorf("%w: %s", ErrNoReport, path)
+	}
+	seq := binary.LittleEndian.Uint64(b[8:])
+	at := 16 + int(seq%2)*partialSlot
+	n := binary.LittleEndian.Uint64(b[at:])
+	if seq == 0 || n > partialSlot-8 {
+		return nil, fmt.Errorf("%w: %s", ErrNoReport, path)
+	}
+	var s struct {
+		Snapshot
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(b[at+8:at+8+int(n)], &s); err != nil {
+		return nil, fmt.Errorf("profiler: %s: %w", path, err)
+	}
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "Int64Profiler",
+		Binary:        Binary{Pid: s.Pid},
+		Mode:          s.Mode,
+		WallTimeSec:   s.Elapsed,
+		Categories:    Categories{},
+		Partial:       &Partial{Update: s.Seq, ElapsedSec: s.Elapsed},
+	}
+	c := s.Cumulative
+	res.Totals = Counts{Add: c["add"], Sub: c["sub"], Mul: c["mul"], Div: c["div"],
+		Shl: c["shl"], Shr: c["shr"], Rol: c["rol"], And: c["and"], Or: c["or"], Xor: c["xor"], Not: c["not"]}
+	for _, name := range BitCategoryNames {
+		if _, ok := c[name]; ok {
+			res.Categories[name] = map[string]Variant{}
+		}
+	}
+	return res, nil
+}
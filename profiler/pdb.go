@@ -0,0 +1,271 @@
+package profiler
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// peSymbolAddr returns the link-time address (image base plus RVA) of
+// function name in the PE executable f at path: from its COFF symbol table
+// when it has one (MinGW, Go), else from the program database the linker
+// wrote next to it (MSVC, clang-cl).
+func peSymbolAddr(f *pe.File, path, name string) (uint64, error) {
+	var base uint64
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		base = oh.ImageBase
+	default:
+		return 0, fmt.Errorf("%w: %s is not a PE32+ (x64) executable", ErrUnsupported, path)
+	}
+	for _, s := range f.Symbols {
+		// section numbers are 1-based; type 0x20 is a function
+		if s.Name == name && s.SectionNumber > 0 && int(s.SectionNumber) <= len(f.Sections) && s.Type == 0x20 {
+			return base + uint64(f.Sections[s.SectionNumber-1].VirtualAddress) + uint64(s.Value), nil
+		}
+	}
+
+	pdbPath, guid, age, err := peCodeView(f)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s in %s (not a COFF symbol, and no PDB: %v)", ErrSymbolNotFound, name, path, err)
+	}
+	// the recorded path is the build machine's; also try next to the binary
+	var pdb *pdbFile
+	for _, cand := range []string{pdbPath, filepath.Join(filepath.Dir(path), filepath.Base(strings.ReplaceAll(pdbPath, `\`, "/"))),
+		strings.TrimSuffix(path, filepath.Ext(path)) + ".pdb"} {
+		if pdb, err = openPDB(cand); err == nil {
+			break
+		}
+	}
+	if pdb == nil {
+		return 0, fmt.Errorf("%w: %s in %s (PDB %s: %v)", ErrSymbolNotFound, name, path, pdbPath, err)
+	}
+	if pdb.guid != guid || pdb.age < age {
+		return 0, fmt.Errorf("profiler: %s does not match %s (rebuilt since?)", pdb.path, path)
+	}
+	seg, off, ok := pdb.lookup(name)
+	if !ok || seg == 0 || int(seg) > len(f.Sections) {
+		return 0, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, name, pdb.path)
+	}
+	return base + uint64(f.Sections[seg-1].VirtualAddress) + uint64(off), nil
+}
+
+// peCodeView returns the PDB path, GUID and age of the RSDS record in
+// the debug directory of f.
+func peCodeView(f *pe.File) (string, [16]byte, uint32, error) {
+	var guid [16]byte
+	oh, ok := f.OptionalHeader.(*pe.OptionalHeader64)
+	if !ok || oh.NumberOfRvaAndSizes <= pe.IMAGE_DIRECTORY_ENTRY_DEBUG {
+		return "", guid, 0, errors.New("no debug directory")
+	}
+	dir := oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+	read := func(rva, size uint32) []byte {
+		for _, s := range f.Sections {
+			if rva >= s.VirtualAddress && rva+size <= s.VirtualAddress+s.VirtualSize {
+				b := make([]byte, size)
+				if _, err := s.ReadAt(b, int64(rva-s.VirtualAddress)); err == nil {
+					return b
+				}
+			}
+		}
+		return nil
+	}
+	ents := read(dir.VirtualAddress, dir.Size)
+	// IMAGE_DEBUG_DIRECTORY: 28 bytes, Type at 12, SizeOfData at 16,
+	// AddressOfRawData at 20
+	for i := 0; i+28 <= len(ents); i += 28 {
+		e := ents[i : i+28]
+		if binary.LittleEndian.Uint32(e[12:]) != 2 { // IMAGE_DEBUG_TYPE_CODEVIEW
+			continue
+		}
+		cv := read(binary.LittleEndian.Uint32(e[20:]), binary.LittleEndian.Uint32(e[16:]))
+		if len(cv) < 25 || string(cv[:4]) != "RSDS" {
+			continue
+		}
+		copy(guid[:], cv[4:20])
+		name, _, _ := bytes.Cut(cv[24:], []byte{0})
+		return string(name), guid, binary.LittleEndian.Uint32(cv[20:]), nil
+	}
+	return "", guid, 0, errors.New("no CodeView record")
+}
+
+// pdbFile is the part of a program database (MSF 7.0 container) that
+// symbol lookup needs.
+type pdbFile struct {
+	path    string
+	guid    [16]byte
+	age     uint32
+	streams [][]byte
+}
+
+var msfMagic = []byte("Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00")
+
+func openPDB(path string) (*pdbFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, msfMagic) || len(data) < 56 {
+		return nil, fmt.Errorf("%s: not an MSF 7.0 PDB", path)
+	}
+	le := binary.LittleEndian
+	bs := le.Uint32(data[32:])
+	dirBytes, mapBlock := le.Uint32(data[44:]), le.Uint32(data[52:])
+	if bs == 0 || bs&(bs-1) != 0 {
+		return nil, fmt.Errorf("%s: bad block size %d", path, bs)
+	}
+	block := func(i uint32) []byte {
+		if off := uint64(i) * uint64(bs); off+uint64(bs) <= uint64(len(data)) {
+			return data[off : off+uint64(bs)]
+		}
+		return nil
+	}
+	blocks := func(size uint32) uint32 { return (size + bs - 1) / bs }
+	// gather concatenates the blocks listed at idx into size bytes
+	gather := func(idx []byte, size uint32) ([]byte, error) {
+		out := make([]byte, 0, size)
+		for i := uint32(0); i < blocks(size); i++ {
+			if len(idx) < int(4*i+4) {
+				return nil, fmt.Errorf("%s: truncated block list", path)
+			}
+			b := block(le.Uint32(idx[4*i:]))
+			if b == nil {
+				return nil, fmt.Errorf("%s: block out of range", path)
+			}
+			out = append(out, b...)
+		}
+		return out[:size], nil
+	}
+
+	dir, err := gather(block(mapBlock), dirBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(dir) < 4 {
+		return nil, fmt.Errorf("%s: empty stream directory", path)
+	}
+	n := le.Uint32(dir)
+	if uint64(len(dir)) < 4+4*uint64(n) {
+		return nil, fmt.Errorf("%s: truncated stream directory", path)
+	}
+	p := &pdbFile{path: path, streams: make([][]byte, n)}
+	idx := dir[4+4*n:]
+	for i := uint32(0); i < n; i++ {
+		size := le.Uint32(dir[4+4*i:])
+		if size == 0xffffffff { // deleted stream
+			continue
+		}
+		if p.streams[i], err = gather(idx, size); err != nil {
+			return nil, err
+		}
+		idx = idx[min(len(idx), int(4*blocks(size))):]
+	}
+
+	// PDB info stream: version, signature, age, GUID
+	if info := p.stream(1); len(info) >= 28 {
+		p.age = le.Uint32(info[8:])
+		copy(p.guid[:], info[12:28])
+	}
+	return p, nil
+}
+
+func (p *pdbFile) stream(i int) []byte {
+	if i < 0 || i >= len(p.streams) {
+		return nil
+	}
+	return p.streams[i]
+}
+
+// CodeView symbol records with a segment:offset address
+const (
+	cvPub32   = 0x110e
+	cvLProc32 = 0x110f
+	cvGProc32 = 0x1110
+)
+
+// lookup returns the section and offset of function name: a procedure of
+// some module, else a public symbol, whose decorated C++ name matches by
+// its leading identifier (?name@@… → name).
+func (p *pdbFile) lookup(name string) (uint16, uint32, bool) {
+	le := binary.LittleEndian
+	dbi := p.stream(3)
+	if len(dbi) < 64 {
+		return 0, 0, false
+	}
+	symRecords := int(le.Uint16(dbi[20:]))
+	modInfo := dbi[64:min(len(dbi), 64+int(le.Uint32(dbi[24:])))]
+
+	var seg uint16
+	var off uint32
+	found := false
+	match := func(kind uint16, rec []byte) bool {
+		var at uint16
+		var rel uint32
+		var sym []byte
+		switch kind {
+		case cvGProc32, cvLProc32:
+			// parent, end, next, length, dbg start, dbg end, type, offset, segment, flags, name
+			if len(rec) < 35 {
+				return false
+			}
+			rel, at, sym = le.Uint32(rec[28:]), le.Uint16(rec[32:]), rec[35:]
+		case cvPub32:
+			// flags, offset, segment, name
+			if len(rec) < 10 {
+				return false
+			}
+			rel, at, sym = le.Uint32(rec[4:]), le.Uint16(rec[8:]), rec[10:]
+		default:
+			return false
+		}
+		sym, _, _ = bytes.Cut(sym, []byte{0})
+		s := string(sym)
+		if s != name && !(strings.HasPrefix(s, "?") && strings.HasPrefix(s[1:], name+"@")) {
+			return false
+		}
+		seg, off, found = at, rel, true
+		return true
+	}
+	walk := func(syms []byte) bool {
+		for len(syms) >= 4 {
+			n := int(le.Uint16(syms))
+			if n < 2 || 2+n > len(syms) {
+				return false
+			}
+			if match(le.Uint16(syms[2:]), syms[4:2+n]) {
+				return true
+			}
+			syms = syms[2+n:]
+		}
+		return false
+	}
+
+	// module info entries: 64 bytes of header (symbol stream at 34, its
+	// size at 36), then the module and object names, 4-byte aligned
+	for len(modInfo) >= 64 && !found {
+		stream, size := int(int16(le.Uint16(modInfo[34:]))), int(le.Uint32(modInfo[36:]))
+		rest := modInfo[64:]
+		for k := 0; k < 2; k++ {
+			i := bytes.IndexByte(rest, 0)
+			if i < 0 {
+				rest = nil
+				break
+			}
+			rest = rest[i+1:]
+		}
+		used := len(modInfo) - len(rest)
+		modInfo = modInfo[min(len(modInfo), (used+3)&^3):]
+		if syms := p.stream(stream); size >= 4 && size <= len(syms) {
+			walk(syms[4:size]) // after the CV_SIGNATURE_C13 word
+		}
+	}
+	if !found {
+		walk(p.stream(symRecords))
+	}
+	return seg, off, found
+}
@@ -0,0 +1,210 @@
+package profiler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Backends selectable through Options.Backend.
+const (
+	// BackendPin counts every instruction with the Int64Profiler pintool.
+	BackendPin = "pin"
+	// BackendPerf reads hardware PMU counters through perf_event_open(2).
+	// It has near-zero overhead but only approximates a few categories.
+	BackendPerf = "perf"
+	// BackendStatic decodes an aarch64 (arm64) or RV64GC (riscv64)
+	// executable without running it and counts instructions as they occur
+	// in the code, not as they execute. Pin instruments x86 only.
+	BackendStatic = "static"
+	// BackendEBPF reads the same PMU counters at the entry and return of
+	// selected functions from eBPF programs on uprobes: near-zero overhead
+	// and no restart for a running process, at the price of precision.
+	BackendEBPF = "ebpf"
+	// BackendQEMU runs an aarch64 or riscv64 executable under QEMU
+	// user-mode emulation with a TCG plugin and classifies the executed
+	// instructions like the static backend, without target hardware.
+	BackendQEMU = "qemu"
+	// BackendGPU runs the workload under NVIDIA Nsight Compute or AMD
+	// rocprof and reports the integer and FP instructions of every GPU
+	// kernel it launches, which CPU-side counts miss.
+	BackendGPU = "gpu"
+	// BackendWASM instruments a WebAssembly (WASI) module with execution
+	// counters, runs it under Node.js and classifies the executed wasm
+	// instructions like the static backend.
+	BackendWASM = "wasm"
+	// BackendHybrid runs the workload under Pin counting only how often
+	// each basic block executes, and projects the op counts from the
+	// blocks' decoded code: a fraction of BackendPin's overhead for the
+	// same totals, without its per-instruction detail.
+	BackendHybrid = "hybrid"
+)
+
+// ErrUnsupported means the requested feature is not available with the
+// selected backend or platform.
+var ErrUnsupported = errors.New("profiler: not supported")
+
+// perf_event_attr.type values
+const (
+	perfTypeHardware = 0
+	perfTypeSoftware = 1
+	perfTypeRaw      = 4
+)
+
+// perfEvent is one counter opened by the perf backend. Category names the
+// report field the scaled value feeds ("mul", "div", "fp64", "fp32" or
+// "instructions"); informational events leave it empty.
+type perfEvent struct {
+	name     string
+	typ      uint32
+	config   uint64
+	category string
+	lanes    uint64 // multiplier for packed FP events
+}
+
+// intelRaw encodes an Intel core PMU event for PERF_TYPE_RAW.
+func intelRaw(event, umask uint64, edge bool, cmask uint64) uint64 {
+	c := event | umask<<8 | cmask<<24
+	if edge {
+		c |= 1 << 18
+	}
+	return c
+}
+
+// perfEvents returns the default counter set for the host CPU vendor.
+//
+// Intel: ARITH.DIVIDER_ACTIVE with edge detect counts divider activations
+// (integer and FP divides alike) and FP_ARITH_INST_RETIRED counts FP
+// instructions by width; the hardware counts an FMA as two operations.
+// There is no portable integer add/sub/mul event on current cores.
+// AMD Zen: PMCx0D4 (Div Op Count) counts divide µops.
+func perfEvents(vendor string) []perfEvent {
+	evs := []perfEvent{
+		{name: "instructions", typ: perfTypeHardware, config: 1, category: "instructions"},
+		{name: "task-clock", typ: perfTypeSoftware, config: 1},
+	}
+	switch vendor {
+	case "GenuineIntel":
+		evs = append(evs,
+			perfEvent{name: "arith.divider_active:edge", typ: perfTypeRaw,
+				config: intelRaw(0x14, 0x01, true, 1), category: "div"})
+		for _, fp := range []struct {
+			name  string
+			umask uint64
+			cat   string
+			lanes uint64
+		}{
+			{"scalar_double", 0x01, "fp64", 1}, {"scalar_single", 0x02, "fp32", 1},
+			{"128b_packed_double", 0x04, "fp64", 2}, {"128b_packed_single", 0x08, "fp32", 4},
+			{"256b_packed_double", 0x10, "fp64", 4}, {"256b_packed_single", 0x20, "fp32", 8},
+			{"512b_packed_double", 0x40, "fp64", 8}, {"512b_packed_single", 0x80, "fp32", 16},
+		} {
+			evs = append(evs, perfEvent{name: "fp_arith_inst_retired." + fp.name,
+				typ: perfTypeRaw, config: intelRaw(0xC7, fp.umask, false, 0),
+				category: fp.cat, lanes: fp.lanes})
+		}
+	case "AuthenticAMD":
+		evs = append(evs, perfEvent{name: "ex_div_count", typ: perfTypeRaw,
+			config: 0xD4, category: "div"})
+	}
+	return evs
+}
+
+// applyPerfOverrides replaces or adds category events from Options.PerfEvents.
+// Values use perf's raw syntax, "r<hex config>".
+func applyPerfOverrides(evs []perfEvent, over map[string]string) ([]perfEvent, error) {
+	for cat, spec := range over {
+		switch cat {
+		case "mul", "div", "fp64", "fp32":
+		default:
+			return nil, fmt.Errorf("profiler: perf event category %q (want mul, div, fp64, fp32)", cat)
+		}
+		if !strings.HasPrefix(spec, "r") {
+			return nil, fmt.Errorf("profiler: perf event %q: want r<hex>", spec)
+		}
+		cfg, err := strconv.ParseUint(spec[1:], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("profiler: perf event %q: %v", spec, err)
+		}
+		kept := evs[:0]
+		for _, e := range evs {
+			if e.category != cat {
+				kept = append(kept, e)
+			}
+		}
+		evs = append(kept, perfEvent{name: spec, typ: perfTypeRaw, config: cfg, category: cat, lanes: 1})
+	}
+	return evs, nil
+}
+
+// cpuVendor returns the vendor_id field of /proc/cpuinfo.
+func cpuVendor() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && strings.TrimSpace(k) == "vendor_id" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// Perf holds the raw counters of a perf- or eBPF-backend run. For the
+// eBPF backend the event values are the sums over Functions.
+type Perf struct {
+	Events    []PerfEvent    `json:"events"`
+	FP64Ops   uint64         `json:"fp64_ops"` // lane ops; FMA counts as 2 on Intel
+	FP32Ops   uint64         `json:"fp32_ops"`
+	Functions []PerfFunction `json:"functions,omitempty"` // eBPF backend
+}
+
+// PerfFunction holds the counters read between the entry and the return
+// of one function (eBPF backend). Calls is the number of returns counted;
+// Dropped the calls that returned on another CPU than they entered on,
+// whose counts cannot be attributed. Events maps event names to values.
+type PerfFunction struct {
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Calls   uint64            `json:"calls"`
+	Dropped uint64            `json:"dropped,omitempty"`
+	Events  map[string]uint64 `json:"events"`
+}
+
+// PerfEvent is one PMU counter. Value is scaled by enabled/running time
+// when the kernel had to multiplex counters.
+type PerfEvent struct {
+	Name      string `json:"name"`
+	Category  string `json:"category,omitempty"`
+	Config    string `json:"config"`
+	Supported bool   `json:"supported"`
+	Value     uint64 `json:"value"`
+	Scaled    bool   `json:"scaled,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Measured reports whether a supported event fed category cat.
+func (p *Perf) Measured(cat string) bool {
+	for _, e := range p.Events {
+		if e.Category == cat && e.Supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of the named event and whether it was counted.
+func (p *Perf) Get(name string) (uint64, bool) {
+	for _, e := range p.Events {
+		if e.Name == name && e.Supported {
+			return e.Value, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package profiler
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// perfEventAttr mirrors struct perf_event_attr (PERF_ATTR_SIZE_VER5).
+type perfEventAttr struct {
+	Type             uint32
+	Size             uint32
+	Config           uint64
+	SamplePeriod     uint64
+	SampleType       uint64
+	ReadFormat       uint64
+	Flags            uint64
+	WakeupEvents     uint32
+	BpType           uint32
+	Config1          uint64
+	Config2          uint64
+	BranchSampleType uint64
+	SampleRegsUser   uint64
+	SampleStackUser  uint32
+	ClockID          int32
+	SampleRegsIntr   uint64
+	AuxWatermark     uint32
+	SampleMaxStack   uint16
+	_                uint16
+}
+
+const (
+	perfFlagInherit       = 1 << 1
+	perfFlagExcludeKernel = 1 << 5
+	perfFlagExcludeHV     = 1 << 6
+
+	perfFormatTotalTimeEnabled = 1 << 0
+	perfFormatTotalTimeRunning = 1 << 1
+
+	perfFlagFdCloexec = 1 << 3
+)
+
+// perfEventOpen opens attr for pid (-1: every process) on cpu (-1: any).
+func perfEventOpen(attr *perfEventAttr, pid, cpu int) (int, error) {
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN,
+		uintptr(unsafe.Pointer(attr)), uintptr(pid), uintptr(cpu), ^uintptr(0),
+		perfFlagFdCloexec, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// runPerf launches cmd stopped at its first instruction (ptrace stops the
+// child after execve), attaches inherited user-space counters to it, and
+// lets it run to completion.
+func (p *Profiler) runPerf(ctx context.Context, cmd []string) (*Result, error) {
+	evs, err := applyPerfOverrides(perfEvents(cpuVendor()), p.opts.PerfEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	// ptrace requests must come from the thread that started the child
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d, capt := newWatchdog(&p.opts), newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Stdout, c.Stderr = d.writer(stdout), d.writer(stderr)
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	c.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+
+	start := time.Now()
+	if err := p.opts.start(c); err != nil {
+		return nil, fmt.Errorf("profiler: start %s: %w", cmd[0], err)
+	}
+	pid := c.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil || !ws.Stopped() {
+		c.Process.Kill()
+		c.Wait()
+		return nil, fmt.Errorf("profiler: %s did not stop at exec", cmd[0])
+	}
+
+	perf := &Perf{}
+	fds := make([]int, len(evs))
+	opened := 0
+	for i, e := range evs {
+		attr := perfEventAttr{
+			Type:       e.typ,
+			Config:     e.config,
+			ReadFormat: perfFormatTotalTimeEnabled | perfFormatTotalTimeRunning,
+			Flags:      perfFlagInherit | perfFlagExcludeKernel | perfFlagExcludeHV,
+		}
+		attr.Size = uint32(unsafe.Sizeof(attr))
+		fds[i], err = perfEventOpen(&attr, pid, -1)
+		pe := PerfEvent{Name: e.name, Category: e.category,
+			Config: fmt.Sprintf("%d:%#x", e.typ, e.config), Supported: err == nil}
+		if err != nil {
+			pe.Error = err.Error()
+		} else {
+			opened++
+			defer syscall.Close(fds[i])
+		}
+		perf.Events = append(perf.Events, pe)
+	}
+	if opened == 0 {
+		c.Process.Kill()
+		c.Wait()
+		return nil, fmt.Errorf("%w: no perf events could be opened (check perf_event_paranoid)", ErrUnsupported)
+	}
+
+	if err := syscall.PtraceDetach(pid); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, fmt.Errorf("profiler: detach %s: %w", cmd[0], err)
+	}
+	trunc, runErr := d.wait(c, killStop(c), 0)
+	wall := time.Since(start)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "perf_event",
+		Backend:       BackendPerf,
+		Approximate:   true,
+		Binary:        Binary{Path: c.Path, Args: cmd, Pid: pid},
+		Mode:          "whole",
+		WallTimeSec:   wall.Seconds(),
+		Perf:          perf,
+		Output:        capt.output(),
+	}
+	buf := make([]byte, 24)
+	for i, e := range evs {
+		pe := &perf.Events[i]
+		if !pe.Supported {
+			continue
+		}
+		if n, err := syscall.Read(fds[i], buf); err != nil || n != len(buf) {
+			pe.Supported, pe.Error = false, "read failed"
+			continue
+		}
+		val := binary.LittleEndian.Uint64(buf[0:])
+		enabled := binary.LittleEndian.Uint64(buf[8:])
+		running := binary.LittleEndian.Uint64(buf[16:])
+		if running > 0 && running < enabled {
+			val = uint64(float64(val) * float64(enabled) / float64(running))
+			pe.Scaled = true
+		}
+		pe.Value = val
+
+		lanes := e.lanes
+		if lanes == 0 {
+			lanes = 1
+		}
+		switch e.category {
+		case "mul":
+			res.Totals.Mul += val
+		case "div":
+			res.Totals.Div += val
+		case "fp64":
+			perf.FP64Ops += val * lanes
+		case "fp32":
+			perf.FP32Ops += val * lanes
+		}
+	}
+
+	if trunc != nil {
+		res.Truncated = trunc
+		return res, fmt.Errorf("%w: %s", ErrTruncated, trunc)
+	}
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
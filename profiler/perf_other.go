@@ -0,0 +1,12 @@
+//go:build !linux
+
+package profiler
+
+import (
+	"context"
+	"fmt"
+)
+
+func (p *Profiler) runPerf(ctx context.Context, cmd []string) (*Result, error) {
+	return nil, fmt.Errorf("%w: perf backend requires Linux", ErrUnsupported)
+}
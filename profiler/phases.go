@@ -0,0 +1,57 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Phase detection modes (Options.Phases).
+const (
+	PhasesAuto   = "auto"   // by changes in the operation mix
+	PhasesMarker = "marker" // at client-API phase markers
+)
+
+// Phases is the per-phase breakdown of a run. In PhasesAuto mode each
+// phase is named phase1, phase2, …; in PhasesMarker mode after the
+// roi.Phase or Int64ProfilerPhase call that started it, and the run
+// starts in phase "default". Phases without counted operations are left
+// out; a name may repeat.
+type Phases struct {
+	Mode string  `json:"mode"`
+	List []Phase `json:"list"` // in start order
+}
+
+// Phase is one phase of the run, from StartSec to EndSec.
+type Phase struct {
+	Name     string  `json:"name"`
+	StartSec float64 `json:"start_sec"`
+	EndSec   float64 `json:"end_sec"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// checkPhases validates the phase options of a pin backend run.
+func (o *Options) checkPhases() error {
+	switch o.Phases {
+	case "":
+		if o.PhaseInterval != 0 || o.PhaseShift != 0 {
+			return errors.New("profiler: PhaseInterval and PhaseShift need Phases")
+		}
+		return nil
+	case PhasesAuto, PhasesMarker:
+	default:
+		return fmt.Errorf("profiler: Phases %q is not %s or %s", o.Phases, PhasesAuto, PhasesMarker)
+	}
+	if o.Sample > 0 && o.Sample < 1 || o.FollowChildren || o.warming() {
+		return errors.New("profiler: Phases excludes Sample, FollowChildren and warm-up exclusion")
+	}
+	if o.PhaseInterval < 0 || o.PhaseShift < 0 || o.PhaseShift > 100 {
+		return errors.New("profiler: PhaseInterval must not be negative and PhaseShift must be in [0, 100]")
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// WritePprof renders r as a gzipped pprof profile (profile.proto), for
+// go tool pprof and the tools built on it. Each sample is a calling
+// context of r.CallGraph, or without one a function of r.Functions, or
+// else the whole program; its values are the op types of WriteCSV with
+// "int" (add+sub+mul+div), the default, in front. Byte counts have unit
+// "bytes", the rest "count". Functions have the symbol they were
+// demangled from as their system name. With a Top (Result.SetTop) the
+// functions in none of its tables are the one frame TopOther.
+func (r *Result) WritePprof(w io.Writer) error {
+	if r.Perf != nil {
+		return fmt.Errorf("%w: pprof profiles hold pintool or static counts, not perf events", ErrUnsupported)
+	}
+	p := newPprofBuilder()
+	p.raw = r.rawName
+	p.mapping(r.Binary.Path) // pprof takes the first mapping for the main binary
+	types := append([]string{"int"}, r.csvOps()...)
+	for _, t := range types {
+		unit := "count"
+		if strings.HasPrefix(t, "mem_bytes") {
+			unit = "bytes"
+		}
+		p.valueType(1, t, unit)
+	}
+
+	// where frames are found: Functions have files and lines, the call
+	// graph's nodes at least their image
+	funcs := map[string]Function{}
+	if g := r.CallGraph; g != nil {
+		for _, f := range g.Functions {
+			funcs[f.Name] = Function{Name: f.Name, Image: f.Image}
+		}
+	}
+	for _, f := range r.Functions {
+		funcs[f.Name] = f
+	}
+	var inTop map[string]bool
+	if r.Top != nil {
+		inTop = r.Top.names()
+	}
+	// v holds a sample's values in csvOps order
+	sample := func(frames []string, v []uint64) {
+		values := append([]uint64{v[0] + v[1] + v[2] + v[3]}, v...)
+		var nonzero bool
+		for _, n := range values {
+			nonzero = nonzero || n != 0
+		}
+		if !nonzero {
+			return
+		}
+		// pprof lists the innermost frame first
+		locs := make([]uint64, 0, len(frames))
+		for i := len(frames) - 1; i >= 0; i-- {
+			name := r.topFrame(inTop, frames[i])
+			if name == TopOther && i < len(frames)-1 && r.topFrame(inTop, frames[i+1]) == TopOther {
+				continue // one frame for a run of them
+			}
+			f, ok := funcs[name]
+			if !ok {
+				f = Function{Name: name}
+			}
+			locs = append(locs, p.location(f))
+		}
+		p.sample(locs, values)
+	}
+	switch {
+	case r.CallGraph != nil:
+		for _, s := range r.CallGraph.Stacks {
+			frames := s.Frames
+			if len(frames) == 0 {
+				frames = []string{"[unknown]"}
+			}
+			sample(frames, r.csvValues(s.Counts, s.Vector, s.Wide, s.FP64, s.FP32, s.Memory, s.Custom))
+		}
+	case len(r.Functions) > 0:
+		for _, f := range r.Functions {
+			sample([]string{f.Name}, r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom))
+		}
+	default:
+		// the totals, on a frame named after the program
+		name := filepath.Base(r.Binary.Path)
+		funcs[name] = Function{Name: name, Image: r.Binary.Path}
+		sample([]string{name}, r.totalValues())
+	}
+
+	p.int(10, int64(r.WallTimeSec*1e9))
+	p.valueType(11, "operations", "count")
+	p.int(12, 1)
+	p.int(13, p.str(strings.Join(append([]string{r.Binary.Path}, r.Binary.Args...), " ")))
+	p.int(14, p.str("int"))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.finish()); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	return nil
+}
+
+// pprofBuilder encodes a profile.proto Profile. Functions, locations and
+// mappings are interned, one location per function: the report has no
+// addresses.
+type pprofBuilder struct {
+	buf      []byte // the Profile's fields but the tables
+	tables   []byte // mappings, locations, functions
+	strings  []string
+	strIndex map[string]int64
+	funcs    map[string]uint64   // name → location and function id
+	mappings map[string]uint64   // image → mapping id
+	raw      func(string) string // a function's system name
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{strings: []string{""}, strIndex: map[string]int64{"": 0},
+		funcs: map[string]uint64{}, mappings: map[string]uint64{}}
+}
+
+// str returns s's index in the string table.
+func (p *pprofBuilder) str(s string) int64 {
+	if i, ok := p.strIndex[s]; ok {
+		return i
+	}
+	i := int64(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.strIndex[s] = i
+	return i
+}
+
+func (p *pprofBuilder) int(field int, v int64) {
+	if v != 0 {
+		p.buf = pbVarintField(p.buf, field, uint64(v))
+	}
+}
+
+// valueType adds a ValueType in field (sample_type or period_type).
+func (p *pprofBuilder) valueType(field int, typ, unit string) {
+	var m []byte
+	m = pbVarintField(m, 1, uint64(p.str(typ)))
+	m = pbVarintField(m, 2, uint64(p.str(unit)))
+	p.buf = pbBytesField(p.buf, field, m)
+}
+
+func (p *pprofBuilder) sample(locs, values []uint64) {
+	var m []byte
+	m = pbPacked(m, 1, locs)
+	m = pbPacked(m, 2, values)
+	p.buf = pbBytesField(p.buf, 2, m)
+}
+
+// mapping returns the id of image's mapping, adding it the first time.
+func (p *pprofBuilder) mapping(image string) uint64 {
+	if id, ok := p.mappings[image]; ok {
+		return id
+	}
+	id := uint64(len(p.mappings) + 1)
+	p.mappings[image] = id
+	var m []byte
+	m = pbVarintField(m, 1, id)
+	m = pbVarintField(m, 5, uint64(p.str(image)))
+	m = pbVarintField(m, 7, 1) // has_functions
+	p.tables = pbBytesField(p.tables, 3, m)
+	return id
+}
+
+// location returns the id of f's location, adding it, its function and
+// its image's mapping the first time.
+func (p *pprofBuilder) location(f Function) uint64 {
+	if id, ok := p.funcs[f.Name]; ok {
+		return id
+	}
+	id := uint64(len(p.funcs) + 1)
+	p.funcs[f.Name] = id
+
+	var mapping uint64
+	if f.Image != "" {
+		mapping = p.mapping(f.Image)
+	}
+
+	var fn []byte
+	fn = pbVarintField(fn, 1, id)
+	fn = pbVarintField(fn, 2, uint64(p.str(f.Name)))
+	fn = pbVarintField(fn, 3, uint64(p.str(p.raw(f.Name))))
+	if f.File != "" {
+		fn = pbVarintField(fn, 4, uint64(p.str(f.File)))
+	}
+	if f.Line > 0 {
+		fn = pbVarintField(fn, 5, uint64(f.Line))
+	}
+	p.tables = pbBytesField(p.tables, 5, fn)
+
+	var line []byte
+	line = pbVarintField(line, 1, id)
+	if f.Line > 0 {
+		line = pbVarintField(line, 2, uint64(f.Line))
+	}
+	var loc []byte
+	loc = pbVarintField(loc, 1, id)
+	if mapping != 0 {
+		loc = pbVarintField(loc, 2, mapping)
+	}
+	loc = pbBytesField(loc, 4, line)
+	p.tables = pbBytesField(p.tables, 4, loc)
+	return id
+}
+
+// finish returns the encoded Profile.
+func (p *pprofBuilder) finish() []byte {
+	out := append(p.buf, p.tables...)
+	for _, s := range p.strings {
+		out = pbBytesField(out, 6, []byte(s))
+	}
+	return out
+}
+
+// Protocol buffer wire format: varints (type 0) and length-delimited
+// fields (type 2) are all a profile needs.
+
+func pbVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbVarintField(b []byte, field int, v uint64) []byte {
+	return pbVarint(pbVarint(b, uint64(field)<<3), v)
+}
+
+func pbBytesField(b []byte, field int, v []byte) []byte {
+	b = pbVarint(pbVarint(b, uint64(field)<<3|2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbPacked(b []byte, field int, vs []uint64) []byte {
+	var m []byte
+	for _, v := range vs {
+		m = pbVarint(m, v)
+	}
+	return pbBytesField(b, field, m)
+}
@@ -0,0 +1,37 @@
+package profiler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// The Pin launcher and the pintool's file name.
+const (
+	pinExe      = "pin"
+	pinToolFile = "Int64Profiler.dylib"
+)
+
+// checkProcess returns an error unless proc is still running.
+func checkProcess(proc *os.Process) error { return proc.Signal(syscall.Signal(0)) }
+
+// processExe returns the path of the executable of process pid; macOS has
+// no /proc, so ask ps.
+func processExe(pid int) string {
+	out, err := exec.Command("ps", "-o", "comm=", "-p", fmt.Sprint(pid)).Output()
+	if err != nil {
+		return fmt.Sprintf("pid %d", pid)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// checkInstrumentable returns an error for executables macOS will not let
+// Pin control; see machoInstrumentable.
+func checkInstrumentable(path string) error {
+	if p, err := exec.LookPath(path); err == nil {
+		path = p
+	}
+	return machoInstrumentable(path)
+}
@@ -0,0 +1,25 @@
+//go:build !windows && !darwin
+
+package profiler
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// The Pin launcher and the pintool's file name.
+const (
+	pinExe      = "pin"
+	pinToolFile = "Int64Profiler.so"
+)
+
+// checkProcess returns an error unless proc is still running.
+func checkProcess(proc *os.Process) error { return proc.Signal(syscall.Signal(0)) }
+
+// processExe returns a path to the executable of process pid.
+func processExe(pid int) string { return fmt.Sprintf("/proc/%d/exe", pid) }
+
+// checkInstrumentable returns nil: Linux lets Pin trace any process it may
+// ptrace.
+func checkInstrumentable(string) error { return nil }
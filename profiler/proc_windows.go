@@ -0,0 +1,60 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The Pin launcher and the pintool's file name.
+const (
+	pinExe      = "pin.exe"
+	pinToolFile = "Int64Profiler.dll"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+var procQueryFullProcessImageName = syscall.NewLazyDLL("kernel32.dll").NewProc("QueryFullProcessImageNameW")
+
+// checkProcess returns an error unless proc is still running; Windows has
+// no signal 0 to probe with.
+func checkProcess(proc *os.Process) error {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(proc.Pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return err
+	}
+	if code != stillActive {
+		return errors.New("os: process already finished")
+	}
+	return nil
+}
+
+// processExe returns the path of the executable of process pid, or a
+// path that fails to open if it cannot be queried.
+func processExe(pid int) string {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return fmt.Sprintf("pid %d", pid)
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if r, _, _ := procQueryFullProcessImageName.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n))); r == 0 {
+		return fmt.Sprintf("pid %d", pid)
+	}
+	return syscall.UTF16ToString(buf[:n])
+}
+
+// checkInstrumentable returns nil: Windows lets Pin inject into any
+// process the user may debug.
+func checkInstrumentable(string) error { return nil }
@@ -0,0 +1,1139 @@
+// Package profiler runs workloads under the Int64Profiler pintool and
+// returns its report as a typed Result.
+//
+// The counting engine is the Pin-based Int64Profiler.so built by
+// installation/installer.sh; this package locates it, launches the target
+// under Pin and decodes the JSON report the tool writes on exit.
+//
+//	p, err := profiler.New(profiler.Options{Funcs: true})
+//	if err != nil { … }
+//	res, err := p.Run(ctx, []string{"./mycode", "arg"})
+package profiler
+
+import (
+	"context"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultPinVersion is the Pin kit the installer unpacks into $HOME.
+const DefaultPinVersion = "3.31"
+
+var (
+	// ErrPinNotFound means the Pin kit could not be located.
+	ErrPinNotFound = errors.New("profiler: Pin home not found")
+	// ErrToolNotFound means Int64Profiler.so has not been built.
+	ErrToolNotFound = errors.New("profiler: Int64Profiler.so missing")
+	// ErrSymbolNotFound means Options.Func is not a symbol of the target.
+	ErrSymbolNotFound = errors.New("profiler: function not found")
+	// ErrNoReport means Pin exited without writing a report.
+	ErrNoReport = errors.New("profiler: no report written")
+)
+
+// Options configures a Profiler. The zero value profiles the whole
+// program using the Pin kit in $HOME/pin-3.31. Options encode as JSON
+// (package agent sends them to remote agents) without the I/O fields and
+// OnSnapshot.
+type Options struct {
+	// Backend selects the counting engine: BackendPin (default),
+	// BackendPerf, BackendStatic, BackendEBPF, BackendQEMU, BackendGPU,
+	// BackendWASM or BackendHybrid. The perf and GPU backends only support
+	// whole-program counts; the static, QEMU, wasm and hybrid backends
+	// support Func, Funcs, FP, Vec and Ops; the eBPF backend counts the functions named by Func and
+	// the Include globs, and can attach.
+	Backend string
+	// PerfEvents overrides the perf and eBPF backends' event for a
+	// category ("mul", "div", "fp64", "fp32") with a raw "r<hex>" config.
+	PerfEvents map[string]string
+
+	// PinHome is the Pin kit directory (default $HOME/pin-3.31).
+	PinHome string
+	// Tool is the pintool path (default PinHome/source/tools/
+	// Int64Profiler/obj-intel64/Int64Profiler.so, Int64Profiler.dll on
+	// Windows).
+	Tool string
+	// QEMU is the qemu-user emulator of the QEMU backend (default
+	// qemu-aarch64 or qemu-riscv64 from PATH) and QEMUPlugin its counting
+	// plugin, built from installation/int64_qemu.c (default
+	// $HOME/iccad-qemu/libint64qemu.so).
+	QEMU, QEMUPlugin string
+	// GPUProfiler is the kernel profiler of the GPU backend: ncu (NVIDIA
+	// Nsight Compute) or rocprof (AMD), by name or path. By default ncu is
+	// used when in PATH, else rocprof.
+	GPUProfiler string
+	// WASMRuntime is the Node.js binary that runs the instrumented module
+	// of the wasm backend (default node from PATH; version 20 or later for
+	// its WASI support).
+	WASMRuntime string
+
+	// Func restricts counting to a single function, looked up in the
+	// target's symbol table (address mode).
+	Func string
+	// StartMarker and StopMarker select marker mode: counting runs
+	// between calls to the two functions. StopMarker may be empty to
+	// let the tool derive it (start_x → stop_x, begin_x → end_x).
+	StartMarker, StopMarker string
+	// Regions counts only inside client-API regions (package
+	// github.com/abe5240/iccad/client/roi, or client/int64profiler.h) and
+	// reports each named region; see Result.Regions.
+	Regions bool
+
+	// Funcs enables per-function attribution.
+	Funcs bool
+	// Lines enables per-source-line attribution (needs DWARF line tables).
+	Lines bool
+	// Loops attributes counts to the loops of each function's control-flow
+	// graph, with entries and trip counts; see Result.Loops. The static
+	// backend finds them from the jumps in the code, without trip counts.
+	Loops bool
+	// Blocks lists the N basic blocks that ran the most counted
+	// operations, with their decoded instructions; see Result.Blocks.
+	Blocks int
+	// Annotate counts every instruction of the functions matching these
+	// globs, listed in full; see Result.Annotated and AnnotateFunc.
+	Annotate []string
+	// Dataflow builds the dataflow graph of the counted code, which must
+	// be scoped with Func, StartMarker, Regions or a filter; see
+	// Result.Dataflow.
+	Dataflow bool
+	// DeadWork follows the results of the counted ops to flag those
+	// overwritten before being read; see Result.DeadWork. Pin backend
+	// only.
+	DeadWork bool
+	// Modules enables the per-module breakdown over the executable and
+	// its shared libraries, dlopen()ed ones included; see Result.Modules.
+	Modules bool
+	// FollowChildren also instruments forked and exec'd children and
+	// reports each of them; see Result.Processes.
+	FollowChildren bool
+	// CallGraph enables calling-context attribution; see Result.CallGraph.
+	CallGraph bool
+	// Threads enables the per-thread breakdown.
+	Threads bool
+	// PerCPU enables the per-CPU and per-NUMA-node breakdown, sampling
+	// the CPU each thread runs on; see Result.CPUs. Pin backend on Linux
+	// only.
+	PerCPU bool
+	// SysTime times the target's system calls; see Result.Syscalls. Pin
+	// backend only, without FollowChildren.
+	SysTime bool
+	// FP enables FP64/FP32 arithmetic counting.
+	FP bool
+	// Vec enables per-lane counting of packed int64 instructions; see
+	// Result.Vector.
+	Vec bool
+	// Mem enables load/store and bytes-moved counting; see Result.Memory.
+	Mem bool
+	// Cache simulates a cache hierarchy on the memory accesses to estimate
+	// DRAM traffic: "default" (DefaultCache) or levels such as
+	// "l1=32k:8,l2=1m:16,line=64". It implies Mem and excludes Sample; see
+	// Result.Cache.
+	Cache string
+	// Mix counts the whole instruction mix: branches taken and not, jumps,
+	// calls, returns and moves; see Result.Mix.
+	Mix bool
+	// ModArith recognizes modular multiply, add and subtract sequences;
+	// see Result.Modular.
+	ModArith bool
+	// Butterflies counts NTT/FFT butterflies per transform and implies
+	// ModArith; see Result.Butterflies. It excludes Sample.
+	Butterflies bool
+	// Divs classifies 64-bit division sites by their divisors; see
+	// Result.Divisors. It excludes Sample.
+	Divs bool
+	// Branches lists the N conditional branches a simulated predictor
+	// missed most, with their source lines and loops; see Result.Branches.
+	// It excludes Sample.
+	Branches int
+	// Strides classifies the memory accesses of loops as unit, constant or
+	// irregular stride, listing the N loops accessing most; it implies
+	// Loops and excludes Sample. See Result.Strides.
+	Strides int
+	// Reuse checks the counted ops of loops for computations repeated
+	// with the same operands, listing the N loops repeating most; it
+	// implies Loops and excludes Sample. See Result.Reuse.
+	Reuse int
+	// Footprint, when non-zero, tracks the working set: the distinct cache
+	// lines and pages touched per window of Footprint data accesses, per
+	// function and per phase; see Result.Footprint. It excludes Sample.
+	Footprint uint64
+	// MulVals, when non-zero, histograms the operand widths of every
+	// MulVals-th 64-bit multiply; see Result.MulWidths.
+	MulVals uint64
+	// Wide enables detection of multi-limb (128-bit and wider) integer
+	// arithmetic; see Result.Wide.
+	Wide bool
+	// Widths breaks the counted categories down by operand size: any of
+	// 8, 16, 32, 64 and 128 bits (OperandWidths), applying the 64-bit
+	// rules to narrower operands too; see Result.OpWidths. Pin backend
+	// only.
+	Widths []int
+	// Signedness splits the multiplies and divides into signed and
+	// unsigned ones; see Result.Signedness. Not for the perf, ebpf and
+	// gpu backends.
+	Signedness bool
+	// Atomics counts synchronization: atomic read-modify-writes,
+	// store-exclusives and futex calls; see Result.Atomics. Pin, static
+	// and qemu backends.
+	Atomics bool
+	// Compound is how FMAs, multiply-adds and LEAs adding two registers
+	// count: CompoundFused (default), CompoundSplit or CompoundBoth; see
+	// Result.Compound. Not for the perf and ebpf backends.
+	Compound string
+	// Agen counts the adds and shifts of LEAs and memory-operand
+	// addressing: AgenOff (default), AgenCategory or AgenFold; see
+	// Result.Agen. Pin backend only, not with CompoundSplit.
+	Agen string
+	// Ops selects optional categories from BitCategoryNames ("bitwise"
+	// selects all of them).
+	Ops []string
+	// Classes counts custom categories, each the name of a Classifier
+	// registered with RegisterClassifier or, for the pin backend,
+	// name=glob,… over x86 mnemonics; see Result.Custom.
+	Classes []string
+	// Watch counts custom categories of memory accesses, pin backend
+	// only: [name=]target[/size][:mode], every access by a read or write
+	// (":r", ":w", the default, or ":rw") to the size bytes at target, a
+	// data symbol of the main executable or a 0x link-time address of it
+	// (size then defaults to 8). The category is named name, else after
+	// the symbol, and is reported in Result.Custom with those of Classes.
+	Watch []string
+	// Include and Exclude are globs over function names ('*' matches any
+	// run of characters, dots and slashes included, '?' one character,
+	// [...] a class). With Include only matching functions are counted,
+	// and matches of Exclude never are; filtered code is not instrumented.
+	Include, Exclude []string
+	// IncludeFunc and ExcludeFunc do the same with POSIX extended regular
+	// expressions matched anywhere in the name, and IncludeModule and
+	// ExcludeModule with regexes over the path of the image (executable
+	// or shared library) holding the code. Code is counted when it passes
+	// every kind of include given and matches no exclude.
+	IncludeFunc, ExcludeFunc     []string
+	IncludeModule, ExcludeModule []string
+	// Names is how the Result names functions, one of NameModes (default
+	// NamesDemangled); see Result.SetNames. The filters above match the
+	// symbols as the images have them.
+	Names string
+	// DebugDirs are searched, before DefaultDebugDir, for the separate
+	// debug info of stripped images, and Debuginfod lists the servers to
+	// fetch the executable's and its libraries' from when it is found in
+	// none (see FindDebugFile and FetchDebugFile); the command line tools
+	// default it to $DEBUGINFOD_URLS. The images read so are listed in
+	// Result.DebugInfo.
+	DebugDirs  []string
+	Debuginfod []string
+	// Go splits the counts of a Go binary into user code, standard
+	// library and runtime; see Result.GoOrigins.
+	Go bool
+	// JIT names JIT-compiled code, which lies in no image, from the
+	// perf-PID.map and jit-PID.dump files JVM agents and .NET write to
+	// JITDir (default /tmp), instead of counting it as "[unknown]". The
+	// functions' Image is the map file. A launched .NET target gets
+	// DOTNET_PerfMapEnabled=1 unless its environment sets it.
+	JIT    bool
+	JITDir string
+	// Python attributes the counts of a CPython target to its Python
+	// functions, which the client module's hook reports (run the script
+	// with python3 -m int64profiler); see Result.Python.
+	Python bool
+	// Sample, when in (0, 1), counts only that fraction of instruction
+	// windows and extrapolates; see Result.Sampling. Window is the window
+	// length in instructions (default 1,000,000) and Seed the random seed.
+	Sample float64
+	Window uint64
+	Seed   uint64
+	// Stream, when non-zero, passes a Snapshot of the counts to
+	// OnSnapshot every Stream while the target runs, and a final one at exit. It excludes Sample.
+	// StreamFuncs adds the counts of that many top functions to each
+	// snapshot (Snapshot.Functions); it needs Funcs and briefly stops the
+	// target at every snapshot.
+	Stream      time.Duration
+	StreamFuncs int
+	OnSnapshot  func(Snapshot) `json:"-"`
+	// Duration bounds an Attach session; zero counts until the process
+	// exits or the context is cancelled. It is rounded up to whole seconds.
+	Duration time.Duration
+	// Timeout, MaxOps and MaxOutputBytes bound a launched run: the target
+	// is stopped after Timeout, once MaxOps operations are counted (pin
+	// backend, checked ten times a second) or once its output exceeds
+	// MaxOutputBytes, and Run returns the counts so far, flagged by
+	// Result.Truncated, with ErrTruncated. Zero means no limit.
+	Timeout        time.Duration
+	MaxOps         uint64
+	MaxOutputBytes int64
+	// CPUs pins a launched run, the tool with its target, to these CPUs,
+	// and Cgroup starts it in that cgroup v2 directory (relative paths are
+	// under /sys/fs/cgroup), created when missing and left in place; with
+	// CPUs too its cpuset is set to them. Concurrent runs on disjoint CPUs
+	// then don't share cores. Linux only.
+	CPUs   []int
+	Cgroup string
+	// Warmup, WarmupOps and SteadyState discard the counts of a warm-up
+	// phase (JIT compilation, cache warming): its first Warmup, its first
+	// WarmupOps operations (checked ten times a second), or everything up
+	// to the steady state, when for a second of 250ms intervals the rate of
+	// every category stays within SteadyTolerance percent (default 10) of
+	// its mean. Result.Warmup reports what was excluded. Pin backend only;
+	// at most one of the three.
+	Warmup          time.Duration
+	WarmupOps       uint64
+	SteadyState     bool
+	SteadyTolerance float64
+	// Phases breaks the report into phases of the run (PhasesAuto or
+	// PhasesMarker); see Result.Phases. In PhasesAuto mode a phase starts
+	// when the operation mix of two PhaseInterval periods in a row (default
+	// 500ms) differs from the current phase's by more than PhaseShift
+	// percent (default 25) of the operations. Pin backend only.
+	Phases        string
+	PhaseInterval time.Duration
+	PhaseShift    float64
+	// Checkpoint, when set, is a file the counts so far are written to
+	// every CheckpointInterval (default 10 minutes), replaced atomically,
+	// so a multi-day run that dies (reboot, OOM kill) loses at most one
+	// interval: Resume set to that file starts a new run from its counts.
+	// The options that decide what is counted must be the same, and the
+	// target starts over or picks up from its own saved state. Totals,
+	// functions, lines, regions, Mix and Compound carry over; the other
+	// sections cover the resumed run alone. Result.Resumed reports what was
+	// carried. Pin backend only; not with Sample, FollowChildren, Phases or
+	// a warm-up.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             string
+	// Calibration, from Profiler.Calibrate or LoadCalibration with this
+	// Profiler's CalibrationKey, has launched runs estimate the tool's
+	// share of their wall time in Result.Overhead. Pin backend only.
+	Calibration *Calibration
+	// Debug is the pintool debug verbosity (0‑2).
+	Debug int
+
+	// TimeSeries, when set, is a file to receive the counts of every
+	// TimeSeriesInterval (default 10ms) of the run, each stamped with the
+	// Unix time: TimeSeriesFormat "json" (one object per line) or "csv",
+	// by default csv for a .csv file and json otherwise. See
+	// ReadTimeSeries. Pin backend only.
+	TimeSeries         string
+	TimeSeriesInterval time.Duration
+	TimeSeriesFormat   string
+
+	// SyscallTrace, when set, is a file to receive a trace of the target's
+	// system calls, one "TID NR RET" line each (see ReadSyscalls).
+	SyscallTrace string
+
+	// Trace, when set, is a file to receive what the run counts, for
+	// architecture simulators to replay: TraceFormat "ops" (the default),
+	// a line per op counted with its thread, address and client-API
+	// region (see ReadTrace), or "champsim", every instruction the main
+	// thread executes while counting as a ChampSim trace record. A .gz
+	// file is gzip-compressed once the run ends. Pin backend only.
+	Trace       string
+	TraceFormat string
+
+	// Stdin is the launched target's input (default: none).
+	Stdin io.Reader `json:"-"`
+	// Stdout and Stderr receive the target's output (default: discarded).
+	Stdout, Stderr io.Writer `json:"-"`
+	// CaptureOutput, when positive, keeps the last CaptureOutput bytes of
+	// each of a launched target's streams in Result.Output, as well as
+	// sending them to Stdout and Stderr.
+	CaptureOutput int
+	// Env and Dir are passed to the launched process as in exec.Cmd.
+	Env []string
+	Dir string
+
+	// Logger, when set, receives the events of Result.Diagnostics as the
+	// run ends, at info or warn level, and the steps of the run, such as
+	// the Pin command line, at debug level.
+	Logger *slog.Logger `json:"-"`
+}
+
+// run runs c as start starts it.
+func (o *Options) run(c *exec.Cmd) error {
+	if err := o.start(c); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// env returns the environment of a launched target: Env, with .NET
+// writing its JIT maps for Options.JIT.
+func (o *Options) env() []string {
+	if !o.JIT {
+		return o.Env
+	}
+	env := o.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "DOTNET_PerfMapEnabled=") {
+			return env
+		}
+	}
+	return append(env[:len(env):len(env)], "DOTNET_PerfMapEnabled=1")
+}
+
+// maxCPUs bounds Options.CPUs, as CPU_SETSIZE does sched_setaffinity.
+const maxCPUs = 1024
+
+// filtering reports whether any function or module filter is set.
+func (o *Options) filtering() bool {
+	return len(o.Include)+len(o.Exclude)+len(o.IncludeFunc)+len(o.ExcludeFunc)+
+		len(o.IncludeModule)+len(o.ExcludeModule) > 0
+}
+
+// Profiler launches workloads under Int64Profiler.
+type Profiler struct {
+	opts    Options
+	pin     string
+	classes []Classifier // resolved Options.Classes
+	watches []watchpoint // parsed Options.Watch
+}
+
+// New validates opts and locates Pin and the pintool.
+func New(opts Options) (*Profiler, error) {
+	if opts.Backend == "" {
+		opts.Backend = BackendPin
+	}
+	classes, err := resolveClasses(opts.Classes, opts.Backend)
+	if err != nil {
+		return nil, err
+	}
+	watches, err := resolveWatch(opts.Watch, opts.Backend, classes)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.checkTrace(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkLimits(); err != nil {
+		return nil, err
+	}
+	if err := CheckNames(opts.Names); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if len(opts.CPUs) > 0 || opts.Cgroup != "" {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("%w: CPUs and Cgroup need Linux", ErrUnsupported)
+		}
+		for _, cpu := range opts.CPUs {
+			if cpu < 0 || cpu >= maxCPUs {
+				return nil, fmt.Errorf("profiler: CPU %d out of range [0, %d)", cpu, maxCPUs)
+			}
+		}
+	}
+	if err := opts.checkCompound(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkAgen(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkWidths(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkCache(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkBackend(); err != nil {
+		return nil, err
+	}
+	switch opts.Backend {
+	case BackendPin:
+	case BackendPerf, BackendGPU:
+		return &Profiler{opts: opts}, nil
+	case BackendStatic:
+		return &Profiler{opts: opts, classes: classes}, nil
+	case BackendQEMU:
+		if opts.QEMUPlugin == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("profiler: QEMU plugin: %w", err)
+			}
+			opts.QEMUPlugin = filepath.Join(home, "iccad-qemu", "libint64qemu.so")
+		}
+		return &Profiler{opts: opts, classes: classes}, nil
+	case BackendWASM:
+		for _, c := range wasmI32Classes {
+			i := 0
+			for i < len(classes) && classes[i].Name != c.Name {
+				i++
+			}
+			if i == len(classes) {
+				classes = append(classes, c)
+			}
+		}
+		return &Profiler{opts: opts, classes: classes}, nil
+	case BackendEBPF:
+		if opts.Func == "" && len(opts.Include) == 0 {
+			return nil, errors.New("profiler: ebpf backend needs Func or Include")
+		}
+		return &Profiler{opts: opts}, nil
+	case BackendHybrid:
+	default:
+		return nil, fmt.Errorf("profiler: unknown backend %q", opts.Backend)
+	}
+
+	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+		return nil, fmt.Errorf("%w: Pin has no Apple Silicon build; use the static backend for arm64 code", ErrUnsupported)
+	}
+	if opts.PinHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPinNotFound, err)
+		}
+		opts.PinHome = filepath.Join(home, "pin-"+DefaultPinVersion)
+	}
+	if opts.Tool == "" {
+		opts.Tool = filepath.Join(opts.PinHome, "source", "tools",
+			"Int64Profiler", "obj-intel64", pinToolFile)
+	}
+	if opts.Func != "" && opts.StartMarker != "" {
+		return nil, errors.New("profiler: Func and StartMarker are mutually exclusive")
+	}
+	if opts.Regions && (opts.Func != "" || opts.StartMarker != "") {
+		return nil, errors.New("profiler: Regions excludes Func and StartMarker")
+	}
+	if opts.Sample < 0 || opts.Sample > 1 {
+		return nil, fmt.Errorf("profiler: Sample %g out of range (0, 1]", opts.Sample)
+	}
+	if opts.Divs && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Divs excludes Sample")
+	}
+	if opts.Branches < 0 {
+		return nil, fmt.Errorf("profiler: Branches %d is negative", opts.Branches)
+	}
+	if opts.Branches != 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Branches excludes Sample")
+	}
+	if opts.Strides < 0 {
+		return nil, fmt.Errorf("profiler: Strides %d is negative", opts.Strides)
+	}
+	if opts.Strides != 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Strides excludes Sample")
+	}
+	if opts.Reuse < 0 {
+		return nil, fmt.Errorf("profiler: Reuse %d is negative", opts.Reuse)
+	}
+	if opts.Reuse != 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Reuse excludes Sample")
+	}
+	if opts.Footprint != 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Footprint excludes Sample")
+	}
+	if opts.Cache != "" && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Cache excludes Sample")
+	}
+	if opts.Butterflies && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Butterflies excludes Sample")
+	}
+	if opts.Blocks < 0 {
+		return nil, fmt.Errorf("profiler: Blocks %d is negative", opts.Blocks)
+	}
+	if opts.Blocks != 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Blocks excludes Sample")
+	}
+	if len(opts.Annotate) > 0 && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: Annotate excludes Sample")
+	}
+	if opts.Dataflow {
+		if opts.Sample > 0 && opts.Sample < 1 {
+			return nil, errors.New("profiler: Dataflow excludes Sample")
+		}
+		if opts.Func == "" && opts.StartMarker == "" && !opts.Regions && !opts.filtering() {
+			return nil, errors.New("profiler: Dataflow needs Func, StartMarker, Regions or a filter")
+		}
+	}
+	if opts.DeadWork && opts.Sample > 0 && opts.Sample < 1 {
+		return nil, errors.New("profiler: DeadWork excludes Sample")
+	}
+	if opts.Stream != 0 {
+		if opts.Sample > 0 && opts.Sample < 1 {
+			return nil, errors.New("profiler: Stream excludes Sample")
+		}
+		if opts.Stream < 0 || opts.OnSnapshot == nil {
+			return nil, errors.New("profiler: Stream needs a positive interval and OnSnapshot")
+		}
+	}
+	if opts.StreamFuncs < 0 || opts.StreamFuncs > 0 && (opts.Stream == 0 || !opts.Funcs) {
+		return nil, errors.New("profiler: StreamFuncs needs Stream and Funcs")
+	}
+	if opts.warming() {
+		n := 0
+		for _, on := range []bool{opts.Warmup != 0, opts.WarmupOps != 0, opts.SteadyState} {
+			if on {
+				n++
+			}
+		}
+		if n > 1 {
+			return nil, errors.New("profiler: Warmup, WarmupOps and SteadyState are mutually exclusive")
+		}
+		if opts.Warmup < 0 || opts.SteadyTolerance < 0 {
+			return nil, errors.New("profiler: Warmup and SteadyTolerance must not be negative")
+		}
+	}
+	if err := opts.checkPhases(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkCheckpoint(); err != nil {
+		return nil, err
+	}
+	if err := opts.checkTimeSeries(); err != nil {
+		return nil, err
+	}
+	if opts.CaptureOutput < 0 {
+		return nil, errors.New("profiler: CaptureOutput must not be negative")
+	}
+	if opts.SysTime && opts.FollowChildren {
+		return nil, fmt.Errorf("%w: SysTime with FollowChildren", ErrUnsupported)
+	}
+	if opts.SyscallTrace != "" && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("%w: syscall traces are Linux-only", ErrUnsupported)
+	}
+	if opts.PerCPU && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("%w: per-CPU counts are Linux-only", ErrUnsupported)
+	}
+
+	pin := filepath.Join(opts.PinHome, pinExe)
+	if _, err := os.Stat(pin); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPinNotFound, opts.PinHome)
+	}
+	if _, err := os.Stat(opts.Tool); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrToolNotFound, opts.Tool)
+	}
+	return &Profiler{opts: opts, pin: pin, classes: classes, watches: watches}, nil
+}
+
+// Run executes cmd (argv, cmd[0] is the target binary) under Pin and
+// returns the decoded report. Cancelling ctx kills the Pin process.
+//
+// If the target exits non-zero or crashes but the tool still wrote a
+// report, the Result is returned with an error wrapping ErrTargetFailed
+// and the exec error; Result.Exit says how the target ended.
+func (p *Profiler) Run(ctx context.Context, cmd []string) (*Result, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New("profiler: empty command")
+	}
+	found := p.fetchDebug(ctx, cmd[0])
+	res, err := p.launch(ctx, cmd)
+	p.diagnose(res, found)
+	return res, err
+}
+
+// launch runs cmd with the Options.Backend.
+func (p *Profiler) launch(ctx context.Context, cmd []string) (*Result, error) {
+	p.log(slog.LevelDebug, "run", "backend", p.opts.Backend, "command", cmd)
+	switch p.opts.Backend {
+	case BackendPerf:
+		return p.named(p.runPerf(ctx, cmd))
+	case BackendStatic:
+		return p.named(p.runStatic(cmd))
+	case BackendEBPF:
+		return p.named(p.runEBPF(ctx, cmd))
+	case BackendQEMU:
+		return p.named(p.runQEMU(ctx, cmd))
+	case BackendGPU:
+		return p.named(p.runGPU(ctx, cmd))
+	case BackendWASM:
+		return p.named(p.runWASM(ctx, cmd))
+	case BackendHybrid:
+		return p.named(p.runHybrid(ctx, cmd))
+	}
+	if err := checkInstrumentable(cmd[0]); err != nil {
+		return nil, err
+	}
+	args, err := p.toolArgs(cmd[0])
+	if err != nil {
+		return nil, err
+	}
+	return p.run(ctx, cmd, args)
+}
+
+// named names the functions of the run's res as Options.Names says.
+func (p *Profiler) named(res *Result, err error) (*Result, error) {
+	if res != nil {
+		res.SetNames(p.opts.Names)
+	}
+	return res, err
+}
+
+// run launches cmd under Pin with the pintool arguments args.
+func (p *Profiler) run(ctx context.Context, cmd, args []string) (*Result, error) {
+	out, err := os.CreateTemp("", "int64profiler-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	args = append(p.pinArgs("-t", p.opts.Tool), args...)
+	args = append(args, "-o", out.Name())
+	// the stop file also ends the target on an interrupt, and the partial
+	// totals outlive a tool killed before its report
+	stop, partial := out.Name()+".stop", ""
+	args = append(args, "-stop_file", stop)
+	defer os.Remove(stop)
+	if p.opts.partialTotals() {
+		partial = out.Name() + ".partial"
+		args = append(args, "-partial", partial)
+		defer os.Remove(partial)
+	}
+	if p.opts.Stream > 0 {
+		stream := out.Name() + ".stream"
+		wait, err := tailSnapshots(stream, p.opts.OnSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		defer wait()
+		args = append(args, "-stream_file", stream)
+	}
+	args = append(args, "--")
+	args = append(args, cmd...)
+
+	d, capt := newWatchdog(&p.opts), newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, p.pin, args...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, d.writer(stdout), d.writer(stderr)
+	c.Env, c.Dir = p.opts.env(), p.opts.Dir
+	c.Cancel = func() error {
+		fileStop(stop)(StopInterrupt)
+		return nil
+	}
+	c.WaitDelay = killGrace
+	p.log(slog.LevelDebug, "starting pin", "args", c.Args[1:])
+	t0 := time.Now()
+	if err := p.opts.start(c); err != nil {
+		return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], err)
+	}
+	trunc, runErr := d.wait(c, fileStop(stop), killGrace)
+	wall := time.Since(t0)
+	p.log(slog.LevelDebug, "pin exited", "wall", wall, "err", runErr)
+	if err := p.opts.finishTrace(); err != nil {
+		return nil, err
+	}
+
+	res, err := Load(out.Name())
+	if err != nil && partial != "" {
+		if pr, perr := readPartial(partial); perr == nil {
+			res, err = pr, nil
+			res.Binary.Path, res.Binary.Args = cmd[0], cmd
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if trunc != nil {
+			return nil, fmt.Errorf("%w: %s was killed %v after the %s limit", ErrNoReport, cmd[0], killGrace, trunc.Reason)
+		}
+		if runErr != nil {
+			return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+		}
+		return nil, err
+	}
+	res.SetNames(p.opts.Names)
+	res.Exit, res.Output = exitOf(runErr), capt.output()
+	if ctx.Err() != nil && res.Truncated == nil {
+		res.Truncated = &Truncation{Reason: StopInterrupt, ElapsedSec: wall.Seconds()}
+	}
+	if p.opts.Calibration != nil && res.Partial == nil {
+		res.Overhead = p.opts.Calibration.estimate(wall)
+	}
+	if res.Partial != nil {
+		if runErr != nil {
+			return res, fmt.Errorf("%w: run %s: %w", ErrPartial, cmd[0], runErr)
+		}
+		return res, fmt.Errorf("%w: run %s", ErrPartial, cmd[0])
+	}
+	if ctx.Err() != nil {
+		return res, fmt.Errorf("%w: %s: %w", ErrTruncated, res.Truncated, ctx.Err())
+	}
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	if res.Truncated != nil {
+		return res, fmt.Errorf("%w: %s", ErrTruncated, res.Truncated)
+	}
+	return res, budgetErr(res)
+}
+
+// Attach attaches Pin to the running process pid and returns the report
+// once counting stops: when the process exits, Options.Duration elapses or
+// ctx is cancelled. Cancelling ctx asks the tool to detach and still waits
+// for its report; the process keeps running after Pin detaches.
+func (p *Profiler) Attach(ctx context.Context, pid int) (*Result, error) {
+	if p.opts.Backend == BackendEBPF {
+		res, err := p.named(p.attachEBPF(ctx, pid))
+		p.diagnose(res, nil)
+		return res, err
+	}
+	if p.opts.Backend != BackendPin {
+		return nil, fmt.Errorf("%w: %s backend cannot attach", ErrUnsupported, p.opts.Backend)
+	}
+	if p.opts.limited() {
+		return nil, errors.New("profiler: Timeout, MaxOps and MaxOutputBytes bound launched runs; Duration bounds an Attach session")
+	}
+	if len(p.opts.CPUs) > 0 || p.opts.Cgroup != "" {
+		return nil, errors.New("profiler: CPUs and Cgroup isolate launched runs")
+	}
+	if p.opts.CaptureOutput != 0 {
+		return nil, errors.New("profiler: CaptureOutput keeps a launched target's output")
+	}
+	proc, err := os.FindProcess(pid)
+	if err == nil {
+		err = checkProcess(proc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profiler: attach %d: %w", pid, err)
+	}
+	exe := processExe(pid)
+	if err := checkInstrumentable(exe); err != nil {
+		return nil, err
+	}
+	found := p.fetchDebug(ctx, exe)
+	ctr := containerOf(pid)
+	if err := p.checkPinVisible(pid, ctr); err != nil {
+		return nil, err
+	}
+	args, err := p.toolArgs(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tool names files as the target sees them
+	dir, toolDir, cleanup, err := attachDir(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	out, stop := filepath.Join(dir, "report.json"), filepath.Join(dir, "stop")
+
+	args = append(p.pinArgs("-pid", fmt.Sprint(pid), "-t", p.opts.Tool), args...)
+	args = append(args, "-o", filepath.Join(toolDir, "report.json"), "-detach_file", filepath.Join(toolDir, "stop"))
+	if d := p.opts.Duration; d > 0 {
+		args = append(args, "-duration", fmt.Sprint(seconds(d)))
+	}
+	if p.opts.Stream > 0 {
+		wait, err := tailSnapshots(filepath.Join(dir, "stream.jsonl"), p.opts.OnSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		defer wait()
+		args = append(args, "-stream_file", filepath.Join(toolDir, "stream.jsonl"))
+	}
+	c := exec.Command(p.pin, args...)
+	c.Stdout, c.Stderr = p.opts.Stdout, p.opts.Stderr
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	p.log(slog.LevelDebug, "attaching pin", "pid", pid, "args", args)
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("profiler: attach %d: %w", pid, err)
+	}
+
+	// The tool renames the finished report into place at detach or exit.
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	done := ctx.Done()
+	for {
+		if _, err := os.Stat(out); err == nil {
+			res, err := Load(out)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.opts.finishTrace(); err != nil {
+				return nil, err
+			}
+			res.Container = ctr
+			res.SetNames(p.opts.Names)
+			p.diagnose(res, found)
+			return res, budgetErr(res)
+		}
+		if checkProcess(proc) != nil {
+			return nil, fmt.Errorf("%w: process %d exited", ErrNoReport, pid)
+		}
+		select {
+		case <-done:
+			if err := os.WriteFile(stop, nil, 0o644); err != nil {
+				return nil, fmt.Errorf("profiler: %w", err)
+			}
+			done = nil
+		case <-tick.C:
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds, the tool's time unit.
+func seconds(d time.Duration) int64 { return int64((d + time.Second - 1) / time.Second) }
+
+// pinArgs returns Pin's own options followed by args: -follow_execv to
+// carry the tool into exec'd children.
+func (p *Profiler) pinArgs(args ...string) []string {
+	if p.opts.FollowChildren {
+		return append([]string{"-follow_execv"}, args...)
+	}
+	return args
+}
+
+// toolArgs translates Options into pintool knobs.
+func (p *Profiler) toolArgs(target string) ([]string, error) {
+	args := []string{"-format", "json"}
+	switch {
+	case p.opts.Regions:
+		args = append(args, "-regions", "1")
+	case p.opts.StartMarker != "":
+		args = append(args, "-start", p.opts.StartMarker)
+		if p.opts.StopMarker != "" {
+			args = append(args, "-stop", p.opts.StopMarker)
+		}
+	case p.opts.Func != "":
+		addr, err := symbolAddr(target, p.opts.Func, p.opts.DebugDirs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-addr", fmt.Sprintf("%#x", addr))
+	}
+	if p.opts.Funcs {
+		args = append(args, "-funcs", "1")
+	}
+	if p.opts.Lines {
+		args = append(args, "-lines", "1")
+	}
+	if p.opts.Loops {
+		args = append(args, "-loops", "1")
+	}
+	if p.opts.Blocks != 0 {
+		args = append(args, "-blocks", fmt.Sprint(p.opts.Blocks))
+	}
+	for _, g := range p.opts.Annotate {
+		args = append(args, "-annotate", g)
+	}
+	if p.opts.Dataflow {
+		args = append(args, "-dfg", "1")
+	}
+	if p.opts.DeadWork {
+		args = append(args, "-dead", "1")
+	}
+	if p.opts.Modules {
+		args = append(args, "-modules", "1")
+	}
+	if p.opts.FollowChildren {
+		args = append(args, "-children", "1")
+	}
+	if p.opts.Stream > 0 {
+		args = append(args, "-stream", fmt.Sprint(p.opts.Stream.Seconds()))
+	}
+	if p.opts.SyscallTrace != "" {
+		args = append(args, "-syscalls", p.opts.SyscallTrace)
+	}
+	if p.opts.Trace != "" {
+		args = append(args, "-trace", p.opts.tracePath(), "-trace_format", p.opts.traceFormat())
+	}
+	if p.opts.TimeSeries != "" {
+		args = append(args, "-timeseries", p.opts.TimeSeries, "-timeseries_format", p.opts.timeSeriesFormat())
+		if p.opts.TimeSeriesInterval > 0 {
+			args = append(args, "-timeseries_interval", fmt.Sprint(p.opts.TimeSeriesInterval.Seconds()))
+		}
+	}
+	if p.opts.StreamFuncs > 0 {
+		args = append(args, "-stream_funcs", fmt.Sprint(p.opts.StreamFuncs))
+	}
+	if p.opts.CallGraph {
+		args = append(args, "-callgraph", "1")
+	}
+	if p.opts.Threads {
+		args = append(args, "-threads", "1")
+	}
+	if p.opts.PerCPU {
+		args = append(args, "-percpu", "1")
+	}
+	if p.opts.SysTime {
+		args = append(args, "-systime", "1")
+	}
+	if p.opts.FP {
+		args = append(args, "-fp", "1")
+	}
+	if p.opts.Compound != "" {
+		args = append(args, "-compound", p.opts.Compound)
+	}
+	if p.opts.Agen != "" {
+		args = append(args, "-agen", p.opts.Agen)
+	}
+	if p.opts.Vec {
+		args = append(args, "-vec", "1")
+	}
+	if p.opts.Mem {
+		args = append(args, "-mem", "1")
+	}
+	if p.opts.Cache != "" {
+		args = append(args, "-cache", p.opts.Cache)
+	}
+	if p.opts.Mix {
+		args = append(args, "-mix", "1")
+	}
+	if p.opts.ModArith {
+		args = append(args, "-modarith", "1")
+	}
+	if p.opts.Butterflies {
+		args = append(args, "-butterflies", "1")
+	}
+	if p.opts.Divs {
+		args = append(args, "-divs", "1")
+	}
+	if p.opts.Branches != 0 {
+		args = append(args, "-branches", fmt.Sprint(p.opts.Branches))
+	}
+	if p.opts.Strides != 0 {
+		args = append(args, "-strides", fmt.Sprint(p.opts.Strides))
+	}
+	if p.opts.Reuse != 0 {
+		args = append(args, "-reuse", fmt.Sprint(p.opts.Reuse))
+	}
+	if p.opts.Footprint != 0 {
+		args = append(args, "-footprint", fmt.Sprint(p.opts.Footprint))
+	}
+	if p.opts.MulVals > 0 {
+		args = append(args, "-mulvals", fmt.Sprint(p.opts.MulVals))
+	}
+	if p.opts.Wide {
+		args = append(args, "-wide", "1")
+	}
+	if len(p.opts.Widths) > 0 {
+		args = append(args, "-widths", widthsArg(p.opts.Widths))
+	}
+	if p.opts.Signedness {
+		args = append(args, "-signedness", "1")
+	}
+	if p.opts.Atomics {
+		args = append(args, "-atomics", "1")
+	}
+	if p.opts.MaxOps > 0 {
+		args = append(args, "-max_ops", fmt.Sprint(p.opts.MaxOps))
+	}
+	switch {
+	case p.opts.Warmup > 0:
+		args = append(args, "-warmup", fmt.Sprint(p.opts.Warmup.Seconds()))
+	case p.opts.WarmupOps > 0:
+		args = append(args, "-warmup_ops", fmt.Sprint(p.opts.WarmupOps))
+	case p.opts.SteadyState:
+		args = append(args, "-warmup", "auto")
+		if p.opts.SteadyTolerance > 0 {
+			args = append(args, "-steady_tol", fmt.Sprint(p.opts.SteadyTolerance))
+		}
+	}
+	if p.opts.Phases != "" {
+		args = append(args, "-phases", p.opts.Phases)
+	}
+	if p.opts.Checkpoint != "" {
+		if err := checkpointWritable(p.opts.Checkpoint); err != nil {
+			return nil, err
+		}
+		args = append(args, "-checkpoint", p.opts.Checkpoint)
+	}
+	if p.opts.CheckpointInterval > 0 {
+		args = append(args, "-checkpoint_interval", fmt.Sprint(p.opts.CheckpointInterval.Seconds()))
+	}
+	if p.opts.Resume != "" {
+		args = append(args, "-resume", p.opts.Resume)
+	}
+	if p.opts.PhaseInterval > 0 {
+		args = append(args, "-phase_interval", fmt.Sprint(p.opts.PhaseInterval.Seconds()))
+	}
+	if p.opts.PhaseShift > 0 {
+		args = append(args, "-phase_shift", fmt.Sprint(p.opts.PhaseShift))
+	}
+	if len(p.opts.Ops) > 0 {
+		args = append(args, "-ops", strings.Join(p.opts.Ops, ","))
+	}
+	args = append(args, classArgs(p.classes)...)
+	if len(p.watches) > 0 {
+		w, err := watchArgs(p.watches, target, p.opts.DebugDirs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, w...)
+	}
+	for _, f := range []struct {
+		knob string
+		pats []string
+	}{
+		{"-include", p.opts.Include}, {"-exclude", p.opts.Exclude},
+		{"-include_func", p.opts.IncludeFunc}, {"-exclude_func", p.opts.ExcludeFunc},
+		{"-include_module", p.opts.IncludeModule}, {"-exclude_module", p.opts.ExcludeModule},
+	} {
+		for _, pat := range f.pats {
+			args = append(args, f.knob, pat)
+		}
+	}
+	if p.opts.Go {
+		args = append(args, "-go", "1")
+	}
+	for _, d := range p.opts.DebugDirs {
+		args = append(args, "-debug_dir", d)
+	}
+	if p.opts.JIT {
+		args = append(args, "-jit", "1")
+		if p.opts.JITDir != "" {
+			args = append(args, "-jit_dir", p.opts.JITDir)
+		}
+	}
+	if p.opts.Python {
+		args = append(args, "-python", "1")
+	}
+	if p.opts.Sample > 0 && p.opts.Sample < 1 {
+		args = append(args, "-sample", fmt.Sprint(p.opts.Sample))
+		if p.opts.Window > 0 {
+			args = append(args, "-window", fmt.Sprint(p.opts.Window))
+		}
+		if p.opts.Seed > 0 {
+			args = append(args, "-seed", fmt.Sprint(p.opts.Seed))
+		}
+	}
+	if p.opts.Debug > 0 {
+		args = append(args, "-dbg", fmt.Sprint(p.opts.Debug))
+	}
+	return args, nil
+}
+
+// symbolAddr returns the link-time address of function name in path,
+// matching what `nm` reports, or for a PE executable what its COFF
+// symbols or PDB record. Mach-O names drop their leading underscore. A
+// stripped ELF file's symbols are read from its debug info in dirs.
+func symbolAddr(path, name string, dirs []string) (uint64, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		if pf, perr := pe.Open(path); perr == nil {
+			defer pf.Close()
+			return peSymbolAddr(pf, path, name)
+		}
+		if _, c, merr := openMachO(path, macho.CpuAmd64); merr == nil {
+			c.Close()
+			return machoSymbolAddr(path, name)
+		}
+		return 0, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	if df, _ := withDebug(f, path, "", dirs); df != f {
+		defer df.Close()
+		f = df
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return 0, fmt.Errorf("profiler: %s: %w", path, err)
+	}
+	for _, s := range syms {
+		if s.Name == name && elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 {
+			return s.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, name, path)
+}
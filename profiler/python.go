@@ -0,0 +1,69 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Python is the per-function breakdown of a CPython run (Options.Python).
+// The client module's profile hook (python3 -m int64profiler, or
+// int64profiler.functions()) reports every Python call and return, and
+// the counts between two of them go to the innermost frame: a function's
+// counts are its own, C code it calls included and its Python callees
+// left out. HookExcluded is false when the interpreter's
+// profile_trampoline had no symbol, so that the hook's own counts are in
+// the functions'.
+type Python struct {
+	HookExcluded bool             `json:"hook_excluded"`
+	Functions    []PythonFunction `json:"functions"` // by descending count
+}
+
+// PythonFunction is one Python function, by qualified name and the file
+// and first line of its code; Calls counts generator resumes as calls.
+type PythonFunction struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	Calls uint64 `json:"calls"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// writePython renders one row per Python function.
+func writePython(w io.Writer, r *Result, py *Python, ops []string) {
+	fmt.Fprintf(w, "\n----- Python functions (own counts) -----\n")
+	if !py.HookExcluded {
+		fmt.Fprintf(w, "(CPython's profile_trampoline has no symbol: the counts include the profile hook's)\n")
+	}
+	fmt.Fprintf(w, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	if r.Vector != nil {
+		fmt.Fprintf(w, "%14s", "VEC")
+	}
+	if r.Wide != nil {
+		fmt.Fprintf(w, "%14s", "WIDE")
+	}
+	if r.FP != nil {
+		fmt.Fprintf(w, "%14s%14s", "FP64", "FP32")
+	}
+	fmt.Fprintf(w, "%10s  FUNCTION\n", "CALLS")
+	for _, f := range py.Functions {
+		fmt.Fprintf(w, "%14d%14d%14d%14d", f.Add, f.Sub, f.Mul, f.Div)
+		writeOpCols(w, ops, f.Counts)
+		if r.Vector != nil {
+			fmt.Fprintf(w, "%14d", vecSum(f.Vector))
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(w, "%14d", wideSum(f.Wide))
+		}
+		if r.FP != nil {
+			fmt.Fprintf(w, "%14d%14d", fpSum(f.FP64), fpSum(f.FP32))
+		}
+		fmt.Fprintf(w, "%10d  %s  [%s:%d]\n", f.Calls, f.Name, f.File, f.Line)
+	}
+}
@@ -0,0 +1,199 @@
+package profiler
+
+import (
+	"bufio"
+	"context"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// qemuEmulators names the qemu-user emulator of each staticArchs ISA.
+var qemuEmulators = map[elf.Machine]string{
+	elf.EM_AARCH64: "qemu-aarch64",
+	elf.EM_RISCV:   "qemu-riscv64",
+}
+
+// runQEMU runs cmd, an aarch64 or riscv64 executable, under QEMU user-mode
+// emulation with the int64_qemu plugin, which counts the executions of
+// every translated block, and classifies the executed instructions with
+// the static backend's decoders.
+func (p *Profiler) runQEMU(ctx context.Context, cmd []string) (*Result, error) {
+	start := time.Now()
+	path, err := filepath.Abs(cmd[0])
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	arch, ok := staticArchs[f.Machine]
+	if _, emulated := qemuEmulators[f.Machine]; !ok || !emulated || f.Class != elf.ELFCLASS64 {
+		f.Close()
+		return nil, fmt.Errorf("%w: qemu backend emulates aarch64 and riscv64 binaries, %s is %v",
+			ErrUnsupported, cmd[0], f.Machine)
+	}
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "iccad-qemu",
+		Backend:       BackendQEMU,
+		Arch:          arch.name,
+		Binary:        Binary{Path: path, Args: cmd},
+		Mode:          "whole",
+		Categories:    Categories{},
+	}
+	if p.opts.Func != "" {
+		addr, ok := funcSymbol(f, path, p.opts.Func, p.opts.DebugDirs)
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, p.opts.Func, path)
+		}
+		res.Mode = "address"
+		res.Region = &Region{Addr: fmt.Sprintf("%#x", addr)}
+	}
+	emu := p.opts.QEMU
+	if emu == "" {
+		emu = qemuEmulators[f.Machine]
+	}
+	f.Close()
+	if emu, err = exec.LookPath(emu); err != nil {
+		return nil, fmt.Errorf("profiler: qemu backend: %w", err)
+	}
+	if _, err := os.Stat(p.opts.QEMUPlugin); err != nil {
+		return nil, fmt.Errorf("profiler: QEMU plugin (build installation/int64_qemu.c): %w", err)
+	}
+
+	out, err := os.CreateTemp("", "int64qemu-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	plugin := p.opts.QEMUPlugin + ",out=" + out.Name()
+	if p.opts.Atomics {
+		plugin += ",futex=98,futex=449" // futex, futex_waitv in both ISAs' syscall tables
+	}
+	args := append([]string{"-plugin", plugin, path}, cmd[1:]...)
+	capt := newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, emu, args...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, stdout, stderr
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	runErr := p.opts.run(c)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if c.Process != nil {
+		res.Binary.Pid = c.Process.Pid
+	}
+
+	if err := p.qemuCounts(out.Name(), arch, res); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+		}
+		return nil, err
+	}
+	res.WallTimeSec = time.Since(start).Seconds()
+	res.Output = capt.output()
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
+
+// funcSymbol returns the address of function name in f, the ELF file at
+// path, from its symbols or its dynamic symbols.
+func funcSymbol(f *elf.File, path, name string, dirs []string) (uint64, bool) {
+	sf := f
+	if df, _ := withDebug(f, path, "", dirs); df != f {
+		defer df.Close()
+		sf = df
+	}
+	syms, _ := sf.Symbols()
+	dyn, _ := f.DynamicSymbols()
+	for _, s := range append(syms, dyn...) {
+		if s.Name == name && elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 {
+			return s.Value, true
+		}
+	}
+	return 0, false
+}
+
+// qemuCounts classifies the plugin's report at name, one
+// "<executions> <vaddr> <bytes> <symbol>" line per instruction of each
+// executed block and, with Options.Atomics, a "# futex <calls>" line,
+// into res.
+func (p *Profiler) qemuCounts(name string, arch staticArch, res *Result) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+
+	t := p.newStaticTally(arch, res)
+	funcs := map[string]int{}
+	lines := 0
+	sc := bufio.NewScanner(f)
+	var futex uint64
+	for sc.Scan() {
+		if n, ok := strings.CutPrefix(sc.Text(), "# futex "); ok {
+			futex, _ = strconv.ParseUint(n, 10, 64)
+			continue
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 4 {
+			return fmt.Errorf("%w: QEMU plugin line %d: %q", ErrNoReport, lines+1, sc.Text())
+		}
+		lines++
+		sym := fields[3]
+		if p.opts.Func != "" && sym != p.opts.Func {
+			continue
+		}
+		execs, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: QEMU plugin line %d: %v", ErrNoReport, lines, err)
+		}
+		code, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return fmt.Errorf("%w: QEMU plugin line %d: %v", ErrNoReport, lines, err)
+		}
+		op, _, ok := arch.decode(code)
+		if !ok && len(t.classes) == 0 && !p.opts.Atomics {
+			continue
+		}
+		fn := -1
+		if sym != "-" {
+			id, seen := funcs[sym]
+			if !seen {
+				id = t.addFunc(sym)
+				funcs[sym] = id
+			}
+			fn = id
+		}
+		if ok {
+			t.count(op, fn, execs)
+			t.signed(code, op, fn, execs)
+		}
+		t.atomic(code, fn, execs)
+		t.classify(code, fn, execs)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if lines == 0 {
+		return fmt.Errorf("%w: QEMU plugin counted no instructions", ErrNoReport)
+	}
+	t.finish(res.Binary.Path)
+	if res.Atomics != nil {
+		res.Atomics.Futex = futex
+	}
+	return nil
+}
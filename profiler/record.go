@@ -0,0 +1,396 @@
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrReplayMismatch means a replayed run did not reproduce its recording;
+// the error lists the differences.
+var ErrReplayMismatch = errors.New("profiler: replay differs from recording")
+
+// A recording directory holds these files.
+const (
+	recordingFile = "recording.json" // the Recording
+	stdinFile     = "stdin"          // the target's input, with Recording.Stdin
+	syscallsFile  = "syscalls"       // the system call trace, with Recording.Syscalls
+	reportFile    = "report.json"    // the recorded run's report
+)
+
+// Recording is everything a run was given, saved by Profiler.Record so
+// that Profiler.Replay can run it again and check that the counts come
+// out the same.
+type Recording struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	Args         []string  `json:"args"`
+	Env          []string  `json:"env"`
+	Dir          string    `json:"dir"`
+	Binary       string    `json:"binary"`        // Args[0] resolved
+	BinarySHA256 string    `json:"binary_sha256"` // of the file at record time
+	Stdin        bool      `json:"stdin"`         // the stdin file holds the input
+	Syscalls     bool      `json:"syscalls"`      // the syscalls file holds a trace
+	ToolArgs     []string  `json:"tool_args"`     // the pintool options
+	// Hash identifies the inputs: a SHA-256 over the fields above but
+	// Created, and the input bytes.
+	Hash string `json:"hash"`
+
+	path string // the directory
+}
+
+// RecordingRef ties a report to the recording it made or replayed.
+type RecordingRef struct {
+	Hash   string `json:"hash"`
+	Replay bool   `json:"replay,omitempty"`
+}
+
+// Record runs cmd like Run and saves the run to dir, created if needed:
+// the command line, environment (Options.Env, else the current one),
+// working directory, a hash of the binary, the whole of Options.Stdin
+// (read before the target starts), the report and, with syscalls, a trace
+// of the target's system calls. The report's Recording names the
+// recording's hash.
+func (p *Profiler) Record(ctx context.Context, dir string, cmd []string, syscalls bool) (*Result, error) {
+	if p.opts.Backend != BackendPin {
+		return nil, fmt.Errorf("%w: %s backend cannot record", ErrUnsupported, p.opts.Backend)
+	}
+	if len(cmd) == 0 {
+		return nil, errors.New("profiler: empty command")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	rec := &Recording{Version: 1, Created: time.Now().UTC(), Args: cmd, Env: p.opts.Env, Dir: p.opts.Dir, path: dir}
+	if rec.Env == nil {
+		rec.Env = os.Environ()
+	}
+	if rec.Dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		rec.Dir = wd
+	}
+	bin, sum, err := binaryHash(cmd[0], rec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	rec.Binary, rec.BinarySHA256 = bin, sum
+
+	var input []byte
+	if p.opts.Stdin != nil {
+		if input, err = io.ReadAll(p.opts.Stdin); err != nil {
+			return nil, fmt.Errorf("profiler: read stdin: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, stdinFile), input, 0o644); err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		rec.Stdin = true
+	}
+
+	// the recorded options leave out what only concerns this session
+	q := *p
+	q.opts.Stream, q.opts.StreamFuncs, q.opts.SyscallTrace = 0, 0, ""
+	q.opts.Trace, q.opts.TraceFormat = "", ""
+	if err := checkInstrumentable(cmd[0]); err != nil {
+		return nil, err
+	}
+	if rec.ToolArgs, err = q.toolArgs(cmd[0]); err != nil {
+		return nil, err
+	}
+	rec.Syscalls = syscalls
+	rec.Hash = rec.hash(input)
+	if err := writeJSONFile(filepath.Join(dir, recordingFile), rec); err != nil {
+		return nil, err
+	}
+
+	q = *p
+	q.opts.Env, q.opts.Dir, q.opts.Stdin = rec.Env, rec.Dir, nil
+	if rec.Stdin {
+		q.opts.Stdin = bytes.NewReader(input)
+	}
+	if syscalls {
+		q.opts.SyscallTrace = filepath.Join(dir, syscallsFile)
+	}
+	args, err := q.toolArgs(cmd[0])
+	if err != nil {
+		return nil, err
+	}
+	res, runErr := q.run(ctx, cmd, args)
+	q.diagnose(res, nil)
+	if res == nil {
+		return nil, runErr
+	}
+	res.Recording = &RecordingRef{Hash: rec.Hash}
+	if err := writeJSONFile(filepath.Join(dir, reportFile), res); err != nil {
+		return res, err
+	}
+	return res, runErr
+}
+
+// LoadRecording reads the recording saved in dir.
+func LoadRecording(dir string) (*Recording, error) {
+	data, err := os.ReadFile(filepath.Join(dir, recordingFile))
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("profiler: decode recording: %w", err)
+	}
+	rec.path = dir
+	return &rec, nil
+}
+
+// Report loads the recorded run's report.
+func (rec *Recording) Report() (*Result, error) {
+	return Load(filepath.Join(rec.path, reportFile))
+}
+
+// Replay runs rec again with its command line, environment, working
+// directory, input and pintool options; of p's Options only the Pin
+// installation and the output writers apply. It then compares the run
+// with the recording: the binary's hash, the totals and per-function
+// counts, and the system calls each thread made (their numbers, not the
+// results, which hold addresses and pids). On any difference it returns
+// the Result with an error wrapping ErrReplayMismatch that lists them.
+func (p *Profiler) Replay(ctx context.Context, rec *Recording) (*Result, error) {
+	if p.opts.Backend != BackendPin {
+		return nil, fmt.Errorf("%w: %s backend cannot replay", ErrUnsupported, p.opts.Backend)
+	}
+	var diffs []string
+	if _, sum, err := binaryHash(rec.Binary, rec.Dir); err != nil {
+		return nil, err
+	} else if sum != rec.BinarySHA256 {
+		diffs = append(diffs, fmt.Sprintf("binary %s changed since the recording", rec.Binary))
+	}
+
+	q := *p
+	q.opts.Stream = 0
+	q.opts.Env, q.opts.Dir, q.opts.Stdin = rec.Env, rec.Dir, nil
+	if rec.Stdin {
+		input, err := os.ReadFile(filepath.Join(rec.path, stdinFile))
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		q.opts.Stdin = bytes.NewReader(input)
+	}
+	args := append([]string{}, rec.ToolArgs...)
+	var trace string
+	if rec.Syscalls {
+		f, err := os.CreateTemp("", "int64profiler-*.syscalls")
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %w", err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		trace = f.Name()
+		args = append(args, "-syscalls", trace)
+	}
+	res, runErr := q.run(ctx, rec.Args, args)
+	q.diagnose(res, nil)
+	if res == nil {
+		return nil, runErr
+	}
+	res.Recording = &RecordingRef{Hash: rec.Hash, Replay: true}
+
+	want, err := rec.Report()
+	if err != nil {
+		return res, err
+	}
+	diffs = append(diffs, compareCounts(want, res)...)
+	if rec.Syscalls {
+		old, err := ReadSyscalls(filepath.Join(rec.path, syscallsFile))
+		if err != nil {
+			return res, err
+		}
+		got, err := ReadSyscalls(trace)
+		if err != nil {
+			return res, err
+		}
+		diffs = append(diffs, compareSyscalls(old, got)...)
+	}
+	if len(diffs) > 0 {
+		return res, fmt.Errorf("%w:\n  %s", ErrReplayMismatch, strings.Join(diffs, "\n  "))
+	}
+	return res, runErr
+}
+
+// Syscall is one line of a system call trace (Options.SyscallTrace).
+type Syscall struct {
+	Thread   int   // Pin's thread number, 0 for the main thread
+	Number   int   // the system call number of the target's ABI
+	Return   int64 // its result
+	Returned bool  // false when it did not return (exit, execve)
+}
+
+// ReadSyscalls reads a system call trace.
+func ReadSyscalls(path string) ([]Syscall, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	var calls []Syscall
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("profiler: %s:%d: want \"tid nr ret\"", path, n)
+		}
+		var c Syscall
+		var err error
+		if c.Thread, err = strconv.Atoi(fields[0]); err == nil {
+			c.Number, err = strconv.Atoi(fields[1])
+		}
+		if err == nil && fields[2] != "-" {
+			c.Return, err = strconv.ParseInt(fields[2], 10, 64)
+			c.Returned = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %s:%d: %v", path, n, err)
+		}
+		calls = append(calls, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	return calls, nil
+}
+
+// compareCounts lists how got's totals and functions differ from want's.
+func compareCounts(want, got *Result) []string {
+	var diffs []string
+	for _, name := range append(append([]string{}, CategoryNames...), want.Ops()...) {
+		if w, g := want.Totals.Get(name), got.Totals.Get(name); w != g {
+			diffs = append(diffs, fmt.Sprintf("total %s: %d, recorded %d", strings.ToUpper(name), g, w))
+		}
+	}
+	funcs := map[string]Counts{}
+	for _, f := range got.Functions {
+		funcs[f.Name+"\x00"+f.Image] = f.Counts
+	}
+	n := 0
+	for _, f := range want.Functions {
+		g, ok := funcs[f.Name+"\x00"+f.Image]
+		delete(funcs, f.Name+"\x00"+f.Image)
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("function %s: not run, recorded %d ops", f.Name, f.Sum()))
+		case g != f.Counts:
+			diffs = append(diffs, fmt.Sprintf("function %s: %d ops, recorded %d", f.Name, g.Sum(), f.Sum()))
+		default:
+			continue
+		}
+		if n++; n == 10 {
+			diffs = append(diffs, "…")
+			return diffs
+		}
+	}
+	for _, f := range got.Functions {
+		if _, ok := funcs[f.Name+"\x00"+f.Image]; ok {
+			diffs = append(diffs, fmt.Sprintf("function %s: %d ops, not in the recording", f.Name, f.Sum()))
+		}
+	}
+	return diffs
+}
+
+// compareSyscalls reports, per thread, the first system call at which got
+// departs from want.
+func compareSyscalls(want, got []Syscall) []string {
+	byThread := func(calls []Syscall) map[int][]int {
+		m := map[int][]int{}
+		for _, c := range calls {
+			m[c.Thread] = append(m[c.Thread], c.Number)
+		}
+		return m
+	}
+	w, g := byThread(want), byThread(got)
+	var threads []int
+	for t := range w {
+		threads = append(threads, t)
+	}
+	for t := range g {
+		if _, ok := w[t]; !ok {
+			threads = append(threads, t)
+		}
+	}
+	sort.Ints(threads)
+	var diffs []string
+	for _, t := range threads {
+		a, b := w[t], g[t]
+		for i := 0; i < max(len(a), len(b)); i++ {
+			switch {
+			case i >= len(a):
+				diffs = append(diffs, fmt.Sprintf("thread %d: %d more system calls than recorded, from #%d (nr %d)", t, len(b)-len(a), i+1, b[i]))
+			case i >= len(b):
+				diffs = append(diffs, fmt.Sprintf("thread %d: %d fewer system calls than recorded, from #%d (nr %d)", t, len(a)-len(b), i+1, a[i]))
+			case a[i] != b[i]:
+				diffs = append(diffs, fmt.Sprintf("thread %d: system call #%d is nr %d, recorded nr %d", t, i+1, b[i], a[i]))
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return diffs
+}
+
+// hash returns the recording's Hash over its fields and input.
+func (rec *Recording) hash(input []byte) string {
+	r := *rec
+	r.Created, r.Hash = time.Time{}, ""
+	data, _ := json.Marshal(r)
+	h := sha256.New()
+	h.Write(data)
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// binaryHash resolves name like exec does from dir and hashes the file.
+func binaryHash(name, dir string) (path, sum string, err error) {
+	path = name
+	if !strings.Contains(name, "/") {
+		if path, err = exec.LookPath(name); err != nil {
+			return "", "", fmt.Errorf("profiler: %w", err)
+		}
+	} else if !filepath.IsAbs(name) {
+		path = filepath.Join(dir, name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", fmt.Errorf("profiler: %w", err)
+	}
+	return path, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeJSONFile saves v as indented JSON.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package profiler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotRedacted means Restore was given a report Redact did not write.
+var ErrNotRedacted = errors.New("profiler: report is not redacted")
+
+// A Redaction replaces the names in reports that could give away
+// proprietary code, those of functions, images and source files and the
+// client-API region and phase names, with tokens, so that the reports can
+// be shared and mapped back later. A name's token is a keyed hash: the
+// same under one Redaction in every report, so redacted reports still
+// diff and merge, and not guessable without Key. Names maps the tokens
+// back; the pair is the mapping file, to be kept, not shared.
+//
+// A function token is fn_ and 12 hex digits, an image's img_ and a
+// region's rgn_; a source file's is src_ and the file's extension, so
+// the language shows. Program arguments, captured output and container
+// identities have nothing to map back to and are blanked. Counts,
+// addresses, line numbers, disassembly and the report's layout are left
+// as they are.
+type Redaction struct {
+	Key   string            `json:"key"`   // hex HMAC-SHA256 key
+	Names map[string]string `json:"names"` // token → name
+}
+
+// redactKeys are the report fields, at any depth, that hold names, by
+// token kind.
+var redactKeys = map[string]string{
+	"function": "fn",
+	"mangled":  "fn",
+	"image":    "img",
+	"file":     "src",
+}
+
+// redactPaths are the other fields holding names, by their path from the
+// top of the report with array indices left out.
+var redactPaths = map[string]string{
+	"functions.name":           "fn",
+	"callgraph.functions.name": "fn",
+	"callgraph.stacks.frames":  "fn",
+	"python.functions.name":    "fn",
+	"perf.functions.name":      "fn",
+	"gpu.kernels.name":         "fn",
+	"filters.include":          "fn",
+	"filters.exclude":          "fn",
+	"filters.include_func":     "fn",
+	"filters.exclude_func":     "fn",
+	"filters.include_module":   "img",
+	"filters.exclude_module":   "img",
+	"binary.path":              "img",
+	"modules.path":             "img",
+	"images.path":              "img",
+	"debug_info.path":          "img",
+	"processes.list.binary":    "img",
+	"regions.name":             "rgn",
+	"budgets.region":           "rgn",
+	"phases.list.name":         "rgn",
+	"footprint.phases.name":    "rgn",
+}
+
+// redactBlank are the fields Redact empties.
+var redactBlank = map[string]bool{
+	"binary.args":         true,
+	"processes.list.args": true,
+	"output.stdout":       true,
+	"output.stderr":       true,
+	"container.id":        true,
+	"container.name":      true,
+	"container.pod":       true,
+	"container.namespace": true,
+	"container.pod_uid":   true,
+}
+
+// NewRedaction returns a Redaction with a new random key.
+func NewRedaction() (*Redaction, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	return &Redaction{Key: hex.EncodeToString(key), Names: map[string]string{}}, nil
+}
+
+// LoadRedaction reads the mapping file at path.
+func LoadRedaction(path string) (*Redaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	var x Redaction
+	if err := json.Unmarshal(data, &x); err != nil {
+		return nil, fmt.Errorf("profiler: redaction %s: %w", path, err)
+	}
+	if k, err := hex.DecodeString(x.Key); err != nil || len(k) == 0 {
+		return nil, fmt.Errorf("profiler: redaction %s: no valid key", path)
+	}
+	if x.Names == nil {
+		x.Names = map[string]string{}
+	}
+	return &x, nil
+}
+
+// Save writes x to the file at path, readable by its owner only.
+func (x *Redaction) Save(path string) error {
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	return nil
+}
+
+// token returns the token of name as a kind name, adding it to x.Names.
+// Empty and unknown names ("??", "-") and pseudo-images such as [vdso]
+// are kept.
+func (x *Redaction) token(kind, name string) (string, error) {
+	if name == "" || name == "??" || name == "-" || (strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]")) {
+		return name, nil
+	}
+	key, _ := hex.DecodeString(x.Key)
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(kind + "\x00" + name))
+	tok := kind + "_" + hex.EncodeToString(h.Sum(nil))[:12]
+	if kind == "src" {
+		tok += filepath.Ext(name)
+	}
+	if old, ok := x.Names[tok]; ok && old != name {
+		return "", fmt.Errorf("profiler: redact: %q and %q hash to %s", old, name, tok)
+	}
+	x.Names[tok] = name
+	return tok, nil
+}
+
+// Redact returns a copy of r with its names replaced by tokens, adding
+// the new ones to x.Names.
+func (x *Redaction) Redact(r *Result) (*Result, error) {
+	if r.Redacted {
+		return nil, errors.New("profiler: report is already redacted")
+	}
+	return rewriteReport(r, true, func(path string, v any) (any, error) {
+		key := path[strings.LastIndexByte(path, '.')+1:]
+		kind, ok := redactPaths[path]
+		if !ok {
+			kind, ok = redactKeys[key]
+		}
+		switch {
+		case redactBlank[path]:
+			if _, ok := v.([]any); ok {
+				return []any{}, nil
+			}
+			return "", nil
+		case !ok:
+			return v, nil
+		}
+		return mapStrings(v, func(s string) (string, error) { return x.token(kind, s) })
+	})
+}
+
+// Restore returns a copy of r, written by Redact with x or with a
+// Redaction sharing its key, with the tokens of x.Names mapped back to
+// their names. Blanked fields stay blank.
+func (x *Redaction) Restore(r *Result) (*Result, error) {
+	if !r.Redacted {
+		return nil, ErrNotRedacted
+	}
+	return rewriteReport(r, false, func(_ string, v any) (any, error) {
+		return mapStrings(v, func(s string) (string, error) {
+			if name, ok := x.Names[s]; ok {
+				return name, nil
+			}
+			return s, nil
+		})
+	})
+}
+
+// rewriteReport returns a copy of r with each field of its JSON form
+// replaced by what f returns for it and Redacted set to redacted. f is
+// called for the fields that are not objects, with their path.
+func rewriteReport(r *Result, redacted bool, f func(path string, v any) (any, error)) (*Result, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber() // counts above 2^53 must not round
+	var tree any
+	if err := d.Decode(&tree); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	var walk func(path string, v any) (any, error)
+	walk = func(path string, v any) (any, error) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, e := range v {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				var err error
+				if v[k], err = walk(p, e); err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		case []any:
+			if len(v) > 0 {
+				if _, ok := v[0].(map[string]any); ok {
+					for i, e := range v {
+						var err error
+						if v[i], err = walk(path, e); err != nil {
+							return nil, err
+						}
+					}
+					return v, nil
+				}
+			}
+		}
+		return f(path, v)
+	}
+	if tree, err = walk("", tree); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(tree); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	var out Result
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	out.Redacted = redacted
+	out.SetNames(NamesDemangled)
+	return &out, nil
+}
+
+// mapStrings applies f to v, a string or an array of them; other values
+// are returned as they are.
+func mapStrings(v any, f func(string) (string, error)) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return f(v)
+	case []any:
+		for i, e := range v {
+			if s, ok := e.(string); ok {
+				t, err := f(s)
+				if err != nil {
+					return nil, err
+				}
+				v[i] = t
+			}
+		}
+	}
+	return v, nil
+}
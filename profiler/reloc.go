@@ -0,0 +1,81 @@
+package profiler
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LoadedImage is an image the run mapped: the executable, a shared
+// library or the vDSO, the addresses it occupied, Low to High inclusive,
+// and its load bias, what the loader added to its link-time addresses.
+// The bias of a position-independent executable or a library changes from
+// run to run under ASLR; link-time addresses do not. Addresses are hex.
+type LoadedImage struct {
+	Path string `json:"path"`
+	Low  string `json:"low"`
+	High string `json:"high"`
+	Bias string `json:"bias"`
+}
+
+// bounds returns the image's range and bias.
+func (m LoadedImage) bounds() (low, high, bias uint64) {
+	low, _ = strconv.ParseUint(m.Low, 0, 64)
+	high, _ = strconv.ParseUint(m.High, 0, 64)
+	bias, _ = strconv.ParseUint(m.Bias, 0, 64)
+	return low, high, bias
+}
+
+// ImageAt returns the image mapped at addr, an address of the run's
+// process, or nil for code outside every image (JIT code) and for a
+// report without Images. Of images mapped at addr in turn, as after a
+// dlclose and another dlopen, the last is returned.
+func (r *Result) ImageAt(addr uint64) *LoadedImage {
+	for i := len(r.Images) - 1; i >= 0; i-- {
+		if low, high, _ := r.Images[i].bounds(); addr >= low && addr <= high {
+			return &r.Images[i]
+		}
+	}
+	return nil
+}
+
+// LinkAddress relocates addr, an address of the run as in a DivSite,
+// BranchSite, Block or AnnotatedFunction, to the image holding it and
+// the link-time address there: the one nm, objdump and the DWARF line
+// tables give, which is the same in every run. False when ImageAt is
+// nil or addr is not hex.
+func (r *Result) LinkAddress(addr string) (image string, link uint64, ok bool) {
+	a, err := strconv.ParseUint(addr, 0, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	m := r.ImageAt(a)
+	if m == nil {
+		return "", 0, false
+	}
+	_, _, bias := m.bounds()
+	return m.Path, a - bias, true
+}
+
+// RunAddress is the inverse of LinkAddress: the address, in hex, that
+// link-time address link of image had in the run; false when the run
+// did not map image.
+func (r *Result) RunAddress(image string, link uint64) (string, bool) {
+	for i := len(r.Images) - 1; i >= 0; i-- {
+		if r.Images[i].Path == image {
+			_, _, bias := r.Images[i].bounds()
+			return fmt.Sprintf("%#x", link+bias), true
+		}
+	}
+	return "", false
+}
+
+// Rebase returns the address in run to of addr, an address of r's run:
+// the same instruction wherever the two runs loaded its image. False
+// when either run lacks the image.
+func (r *Result) Rebase(addr string, to *Result) (string, bool) {
+	image, link, ok := r.LinkAddress(addr)
+	if !ok {
+		return "", false
+	}
+	return to.RunAddress(image, link)
+}
@@ -0,0 +1,1104 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WriteText renders r in the pintool's plain-text report layout.
+func (r *Result) WriteText(w io.Writer) error {
+	if r.Perf != nil {
+		return r.writePerfText(w)
+	}
+	bw := bufio.NewWriter(w)
+	if r.Container != nil {
+		fmt.Fprintf(bw, "Container: %s\n", r.Container)
+	}
+	if r.Redacted {
+		fmt.Fprintf(bw, "Redacted: functions, images and source files are named by tokens\n")
+	}
+	if t := r.Truncated; t != nil {
+		fmt.Fprintf(bw, "Truncated: stopped by %s after %.1f s; partial counts\n", t.Reason, t.ElapsedSec)
+	}
+	if r.Partial != nil {
+		fmt.Fprintf(bw, "Partial: %s\n", r.Partial)
+	}
+	if r.Exit != nil {
+		fmt.Fprintf(bw, "Exit: %s\n", r.Exit)
+	}
+	if r.Warmup != nil {
+		fmt.Fprintf(bw, "Warm-up: %s\n", r.Warmup)
+	}
+	if r.Resumed != nil {
+		fmt.Fprintf(bw, "Resumed: %s\n", r.Resumed)
+	}
+	if r.Overhead != nil {
+		fmt.Fprintf(bw, "Overhead: %s\n", r.Overhead)
+	}
+	for _, b := range r.OverBudget() {
+		fmt.Fprintf(bw, "Over budget: %s\n", b)
+	}
+	if r.Backend == BackendStatic {
+		fmt.Fprintf(bw, "Static counts (%s code, instructions in the binary, not executed)\n", r.Arch)
+	}
+	if r.Backend == BackendQEMU {
+		fmt.Fprintf(bw, "Emulated counts (%s code, executed under QEMU user-mode emulation)\n", r.Arch)
+	}
+	if r.Backend == BackendHybrid {
+		fmt.Fprintf(bw, "Projected counts (%s basic-block executions under Pin × the ops decoded in each block)\n", r.Arch)
+	}
+	if r.Backend == BackendWASM {
+		fmt.Fprintf(bw, "WebAssembly counts (wasm instructions, executed under Node.js)\n")
+	}
+	if r.Backend == BackendGPU {
+		fmt.Fprintf(bw, "GPU counts (%s kernels under %s; integer instructions are not split by operation)\n",
+			r.GPU.Vendor, r.GPU.Profiler)
+		fmt.Fprintf(bw, "ADD: n/a\nSUB: n/a\nMUL: n/a\nDIV: n/a\nINT: %d\n", r.GPU.Totals().Int)
+	} else {
+		fmt.Fprintf(bw, "ADD: %d\nSUB: %d\nMUL: %d\nDIV: %d\n",
+			r.Totals.Add, r.Totals.Sub, r.Totals.Mul, r.Totals.Div)
+	}
+	ops := r.Ops()
+	for _, c := range ops {
+		fmt.Fprintf(bw, "%s: %d\n", strings.ToUpper(c), r.Totals.Get(c))
+	}
+	custom := r.Custom.Names()
+	for _, c := range custom {
+		fmt.Fprintf(bw, "%s: %d\n", strings.ToUpper(c), r.Custom[c])
+	}
+
+	if r.Syscalls != nil {
+		writeSysTime(bw, r.Syscalls)
+	}
+	if s := r.Sampling; s != nil {
+		fmt.Fprintf(bw, "\n----- Sampling (extrapolated) -----\n")
+		fmt.Fprintf(bw, "Windows:      %d of %d (fraction %g, %d instructions each)\n",
+			s.WindowsSampled, s.WindowsTotal, s.Fraction, s.Window)
+		fmt.Fprintf(bw, "Instructions: %d of %d\n", s.InstructionsSampled, s.InstructionsTotal)
+		fmt.Fprintf(bw, "%6s%16s%16s\n", "", "ESTIMATE", "CI95 (+/-)")
+		for _, c := range CategoryNames {
+			e := s.Estimates[c]
+			ci := "n/a"
+			if e.CI95 != nil {
+				ci = fmt.Sprint(math.Round(*e.CI95))
+			}
+			fmt.Fprintf(bw, "%6s%16d%16s\n", strings.ToUpper(c), e.Value, ci)
+		}
+	}
+
+	if c := r.Compound; c != nil && c.Policy != CompoundFused {
+		writeCompound(bw, r)
+	}
+	if r.Agen != nil {
+		writeAgen(bw, r.Agen)
+	}
+
+	if fp := r.FP; fp != nil {
+		fmt.Fprintf(bw, "\n----- Floating point (lane ops) -----\n")
+		fmt.Fprintf(bw, "%6s%14s%14s%14s%14s%14s\n", "", "ADD", "SUB", "MUL", "DIV", "FMA")
+		for _, p := range []struct {
+			name string
+			ops  FPOps
+		}{{"FP64", fp.FP64}, {"FP32", fp.FP32}} {
+			fmt.Fprintf(bw, "%6s%14d%14d%14d%14d%14d\n",
+				p.name, p.ops.Add, p.ops.Sub, p.ops.Mul, p.ops.Div, p.ops.FMA)
+		}
+		if r.Backend != BackendGPU {
+			fmt.Fprintf(bw, "INT/FP ratio: %s\n", intFPRatio(r.Totals, fp.FP64.Sum()+fp.FP32.Sum()))
+		}
+	}
+
+	if v := r.Vector; v != nil {
+		fmt.Fprintf(bw, "\n----- Scalar vs vector (int64 lane ops) -----\n")
+		fmt.Fprintf(bw, "%6s%14s%14s\n", "", "SCALAR", "VECTOR")
+		fmt.Fprintf(bw, "%6s%14d%14d\n", "ADD", r.Totals.Add, v.Add)
+		fmt.Fprintf(bw, "%6s%14d%14d\n", "SUB", r.Totals.Sub, v.Sub)
+		fmt.Fprintf(bw, "%6s%14d%14d\n", "MUL", r.Totals.Mul, v.Mul)
+	}
+
+	if wd := r.Wide; wd != nil {
+		fmt.Fprintf(bw, "\n----- Wide-integer operations (estimated) -----\n%6s", "")
+		for bits := 128; bits <= 512; bits += 64 {
+			label := fmt.Sprintf("%d-bit", bits)
+			if bits == 512 {
+				label = "512+-bit"
+			}
+			fmt.Fprintf(bw, "%14s", label)
+		}
+		fmt.Fprintln(bw)
+		for _, k := range []struct {
+			name string
+			m    map[int]uint64
+		}{{"ADD", wd.Add}, {"SUB", wd.Sub}, {"MUL", wd.Mul}} {
+			fmt.Fprintf(bw, "%6s", k.name)
+			for bits := 128; bits <= 512; bits += 64 {
+				fmt.Fprintf(bw, "%14d", k.m[bits])
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	if r.OpWidths != nil {
+		writeOpWidths(bw, r)
+	}
+
+	if r.Signedness != nil {
+		writeSignedness(bw, r.Signedness, r.Arch != "" && r.Arch != "amd64")
+	}
+	if r.Atomics != nil {
+		writeAtomics(bw, r.Atomics, r.Totals.Sum()+r.Totals.BitSum(), r.Arch != "" && r.Arch != "amd64", r.Backend != BackendStatic)
+	}
+
+	if m := r.Memory; m != nil {
+		fmt.Fprintf(bw, "\n----- Memory -----\n")
+		fmt.Fprintf(bw, "Loads:         %d (%d bytes)\n", m.Loads, m.BytesRead)
+		fmt.Fprintf(bw, "Stores:        %d (%d bytes)\n", m.Stores, m.BytesWritten)
+		fmt.Fprintf(bw, "INT ops/byte:  %s\n", opsPerByte(m, m.IntOpsPerByte))
+		if r.FP != nil {
+			fmt.Fprintf(bw, "FP ops/byte:   %s\n", opsPerByte(m, m.FPOpsPerByte))
+		}
+	}
+
+	if r.Cache != nil {
+		writeCache(bw, r)
+	}
+
+	if f := r.Footprint; f != nil {
+		writeFootprint(bw, f)
+	}
+
+	if m := r.Mix; m != nil {
+		writeMix(bw, m)
+	}
+
+	if m := r.Modular; m != nil {
+		fmt.Fprintf(bw, "\n----- Modular arithmetic -----\n")
+		fmt.Fprintf(bw, "MODMUL:  %14d\n", m.Mul.Sum())
+		for _, k := range []struct {
+			name string
+			n    uint64
+		}{{"montgomery", m.Mul.Montgomery}, {"barrett", m.Mul.Barrett}, {"shoup", m.Mul.Shoup}, {"division", m.Mul.Division}} {
+			fmt.Fprintf(bw, "  %-12s%9d\n", k.name, k.n)
+		}
+		fmt.Fprintf(bw, "MODADD:  %14d\nMODSUB:  %14d\n", m.Add, m.Sub)
+	}
+	if b := r.Butterflies; b != nil {
+		fmt.Fprintf(bw, "\n----- NTT butterflies -----\nButterflies: %d\n", b.Total)
+		fmt.Fprintf(bw, "%14s%10s%12s%10s  FUNCTION\n", "BUTTERFLIES", "CALLS", "PER CALL", "SIZE")
+		for _, t := range b.Transforms {
+			size := "-"
+			if t.Size > 0 {
+				size = strconv.FormatUint(t.Size, 10)
+			}
+			fmt.Fprintf(bw, "%14d%10d%12d%10s  %s", t.Butterflies, t.Calls, t.PerCall, size, t.Function)
+			switch {
+			case t.File != "":
+				fmt.Fprintf(bw, "  (%s:%d)", t.File, t.Line)
+			case t.Image != "":
+				fmt.Fprintf(bw, "  [%s]", t.Image)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	if d := r.Divisors; d != nil {
+		writeDivisors(bw, d)
+	}
+	if b := r.Branches; b != nil {
+		writeBranches(bw, b)
+	}
+	if s := r.Strides; s != nil {
+		writeStrides(bw, s)
+	}
+	if u := r.Reuse; u != nil {
+		writeReuse(bw, u)
+	}
+	if r.Blocks != nil {
+		writeBlocks(bw, r.Blocks)
+	}
+	if r.Annotated != nil {
+		writeAnnotated(bw, r.Annotated)
+	}
+	if d := r.Dataflow; d != nil {
+		writeDataflow(bw, d)
+	}
+	if d := r.DeadWork; d != nil {
+		writeDeadWork(bw, d)
+	}
+	if m := r.MulWidths; m != nil {
+		writeMulWidths(bw, m)
+	}
+	if g := r.GoOrigins; g != nil {
+		writeGoOrigins(bw, r, g, ops)
+	}
+	if r.Modules != nil {
+		writeModules(bw, r, ops)
+	}
+	if r.Processes != nil {
+		writeProcesses(bw, r.Processes, ops)
+	}
+
+	if r.Top != nil {
+		writeTop(bw, r.Top)
+	} else if r.Functions != nil {
+		fmt.Fprintf(bw, "\n----- Per-function breakdown -----\n")
+		fmt.Fprintf(bw, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		if r.Vector != nil {
+			fmt.Fprintf(bw, "%14s", "VEC")
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(bw, "%14s", "WIDE")
+		}
+		if r.FP != nil {
+			fmt.Fprintf(bw, "%14s%14s%8s", "FP64", "FP32", "INT/FP")
+		}
+		if r.Memory != nil {
+			fmt.Fprintf(bw, "%14s%10s", "BYTES", "OPS/B")
+		}
+		if r.Modular != nil {
+			fmt.Fprintf(bw, "%14s", "MODMUL")
+		}
+		for _, c := range custom {
+			fmt.Fprintf(bw, "%14s", strings.ToUpper(c))
+		}
+		fmt.Fprintf(bw, "  FUNCTION\n")
+		for _, f := range r.Functions {
+			fmt.Fprintf(bw, "%14d%14d%14d%14d", f.Add, f.Sub, f.Mul, f.Div)
+			writeOpCols(bw, ops, f.Counts)
+			if r.Vector != nil {
+				fmt.Fprintf(bw, "%14d", vecSum(f.Vector))
+			}
+			if r.Wide != nil {
+				fmt.Fprintf(bw, "%14d", wideSum(f.Wide))
+			}
+			if r.FP != nil {
+				fp64, fp32 := fpSum(f.FP64), fpSum(f.FP32)
+				fmt.Fprintf(bw, "%14d%14d%8s", fp64, fp32, intFPRatio(f.Counts, fp64+fp32))
+			}
+			if r.Memory != nil {
+				m := f.Memory
+				if m == nil {
+					m = &Memory{}
+				}
+				fmt.Fprintf(bw, "%14d%10s", m.Bytes(), opsPerByte(m, m.IntOpsPerByte))
+			}
+			if r.Modular != nil {
+				var n uint64
+				if f.Modular != nil {
+					n = f.Modular.Mul.Sum()
+				}
+				fmt.Fprintf(bw, "%14d", n)
+			}
+			for _, c := range custom {
+				fmt.Fprintf(bw, "%14d", f.Custom[c])
+			}
+			fmt.Fprintf(bw, "  %s", f.Name)
+			switch {
+			case f.File != "":
+				fmt.Fprintf(bw, "  (%s:%d)", f.File, f.Line)
+			case f.Image != "":
+				fmt.Fprintf(bw, "  [%s]", f.Image)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	if g := r.CallGraph; g != nil {
+		fmt.Fprintf(bw, "\n----- Call graph (inclusive / exclusive) -----\n")
+		for _, p := range []string{"INCL-", "SELF-"} {
+			for _, c := range CategoryNames {
+				fmt.Fprintf(bw, "%14s", p+strings.ToUpper(c))
+			}
+		}
+		fmt.Fprintf(bw, "  FUNCTION\n")
+		for _, f := range g.Functions {
+			for _, c := range []Counts{f.Inclusive, f.Exclusive} {
+				fmt.Fprintf(bw, "%14d%14d%14d%14d", c.Add, c.Sub, c.Mul, c.Div)
+			}
+			fmt.Fprintf(bw, "  %s\n", f.Name)
+		}
+	}
+
+	if r.Lines != nil {
+		fmt.Fprintf(bw, "\n----- Per-line breakdown -----\n")
+		fmt.Fprintf(bw, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		if r.Vector != nil {
+			fmt.Fprintf(bw, "%14s", "VEC")
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(bw, "%14s", "WIDE")
+		}
+		if r.FP != nil {
+			fmt.Fprintf(bw, "%14s%14s", "FP64", "FP32")
+		}
+		fmt.Fprintf(bw, "  LOCATION\n")
+		for _, l := range r.Lines {
+			fmt.Fprintf(bw, "%14d%14d%14d%14d", l.Add, l.Sub, l.Mul, l.Div)
+			writeOpCols(bw, ops, l.Counts)
+			if r.Vector != nil {
+				fmt.Fprintf(bw, "%14d", vecSum(l.Vector))
+			}
+			if r.Wide != nil {
+				fmt.Fprintf(bw, "%14d", wideSum(l.Wide))
+			}
+			if r.FP != nil {
+				fmt.Fprintf(bw, "%14d%14d", fpSum(l.FP64), fpSum(l.FP32))
+			}
+			fmt.Fprintf(bw, "  %s:%d\n", l.File, l.Line)
+		}
+	}
+
+	if r.Loops != nil {
+		if r.Backend == BackendStatic {
+			fmt.Fprintf(bw, "\n----- Loops (static: operations in the code of each loop, including nested loops) -----\n")
+		} else {
+			fmt.Fprintf(bw, "\n----- Loops (including nested loops) -----\n")
+		}
+		fmt.Fprintf(bw, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		if r.Vector != nil {
+			fmt.Fprintf(bw, "%14s", "VEC")
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(bw, "%14s", "WIDE")
+		}
+		if r.FP != nil {
+			fmt.Fprintf(bw, "%14s%14s", "FP64", "FP32")
+		}
+		fmt.Fprintf(bw, "%12s%14s%10s%10s%7s  LOOP\n", "ENTRIES", "ITERATIONS", "TRIPS", "OPS/ITER", "DEPTH")
+		for _, l := range r.Loops {
+			fmt.Fprintf(bw, "%14d%14d%14d%14d", l.Add, l.Sub, l.Mul, l.Div)
+			writeOpCols(bw, ops, l.Counts)
+			if r.Vector != nil {
+				fmt.Fprintf(bw, "%14d", vecSum(l.Vector))
+			}
+			if r.Wide != nil {
+				fmt.Fprintf(bw, "%14d", wideSum(l.Wide))
+			}
+			if r.FP != nil {
+				fmt.Fprintf(bw, "%14d%14d", fpSum(l.FP64), fpSum(l.FP32))
+			}
+			if r.Backend == BackendStatic {
+				fmt.Fprintf(bw, "%12s%14s%10s%10s%7d  %s+%s", "-", "-", "-", "-", l.Depth, l.Function, l.Offset)
+			} else {
+				fmt.Fprintf(bw, "%12d%14d%10s%10s%7d  %s+%s", l.Entries, l.Iterations,
+					perText(l.Iterations, l.Entries), perText(l.Ops(), l.Iterations), l.Depth, l.Function, l.Offset)
+			}
+			if l.Line > 0 {
+				fmt.Fprintf(bw, "  (%s:%d)", l.File, l.Line)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	if r.Mode == "regions" {
+		fmt.Fprintf(bw, "\n----- Per-region breakdown -----\n")
+		fmt.Fprintf(bw, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		if r.Vector != nil {
+			fmt.Fprintf(bw, "%14s", "VEC")
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(bw, "%14s", "WIDE")
+		}
+		if r.FP != nil {
+			fmt.Fprintf(bw, "%14s%14s", "FP64", "FP32")
+		}
+		fmt.Fprintf(bw, "%10s  REGION\n", "ENTRIES")
+		for _, g := range r.Regions {
+			fmt.Fprintf(bw, "%14d%14d%14d%14d", g.Add, g.Sub, g.Mul, g.Div)
+			writeOpCols(bw, ops, g.Counts)
+			if r.Vector != nil {
+				fmt.Fprintf(bw, "%14d", vecSum(g.Vector))
+			}
+			if r.Wide != nil {
+				fmt.Fprintf(bw, "%14d", wideSum(g.Wide))
+			}
+			if r.FP != nil {
+				fmt.Fprintf(bw, "%14d%14d", fpSum(g.FP64), fpSum(g.FP32))
+			}
+			fmt.Fprintf(bw, "%10d  %s\n", g.Entries, g.Name)
+		}
+	}
+	if len(r.Budgets) > 0 {
+		fmt.Fprintf(bw, "\n----- Operation budgets -----\n")
+		fmt.Fprintf(bw, "%14s%14s%10s%10s  %-10sREGION\n", "LIMIT", "WORST", "EXITS", "OVER", "OP")
+		for _, b := range r.Budgets {
+			fmt.Fprintf(bw, "%14d%14d%10d%10d  %-10s%s\n", b.Limit, b.Worst, b.Exits, b.Over, b.Op, b.Region)
+		}
+	}
+	if py := r.Python; py != nil {
+		writePython(bw, r, py, ops)
+	}
+
+	if ph := r.Phases; ph != nil {
+		fmt.Fprintf(bw, "\n----- Per-phase breakdown (%s) -----\n", ph.Mode)
+		fmt.Fprintf(bw, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		if r.Vector != nil {
+			fmt.Fprintf(bw, "%14s", "VEC")
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(bw, "%14s", "WIDE")
+		}
+		if r.FP != nil {
+			fmt.Fprintf(bw, "%14s%14s", "FP64", "FP32")
+		}
+		fmt.Fprintf(bw, "%10s%10s  PHASE\n", "START", "END")
+		for _, p := range ph.List {
+			fmt.Fprintf(bw, "%14d%14d%14d%14d", p.Add, p.Sub, p.Mul, p.Div)
+			writeOpCols(bw, ops, p.Counts)
+			if r.Vector != nil {
+				fmt.Fprintf(bw, "%14d", vecSum(p.Vector))
+			}
+			if r.Wide != nil {
+				fmt.Fprintf(bw, "%14d", wideSum(p.Wide))
+			}
+			if r.FP != nil {
+				fmt.Fprintf(bw, "%14d%14d", fpSum(p.FP64), fpSum(p.FP32))
+			}
+			fmt.Fprintf(bw, "%10.1f%10.1f  %s\n", p.StartSec, p.EndSec, p.Name)
+		}
+	}
+
+	if r.Threads != nil {
+		fmt.Fprintf(bw, "\n----- Per-thread breakdown -----\n")
+		fmt.Fprintf(bw, "%6s%10s%14s%14s%14s%14s",
+			"TID", "OS-TID", "ADD", "SUB", "MUL", "DIV")
+		writeOpHeaders(bw, ops)
+		fmt.Fprintf(bw, "  STATUS\n")
+		for _, t := range r.Threads {
+			fmt.Fprintf(bw, "%6d%10d%14d%14d%14d%14d",
+				t.Tid, t.OSTid, t.Add, t.Sub, t.Mul, t.Div)
+			writeOpCols(bw, ops, t.Counts)
+			fmt.Fprintf(bw, "  ")
+			if t.Exited {
+				fmt.Fprintf(bw, "exited(%d)\n", t.ExitCode)
+			} else {
+				fmt.Fprintln(bw, "running")
+			}
+		}
+	}
+
+	if r.CPUs != nil {
+		writeCPUs(bw, r, ops)
+	}
+
+	if r.GPU != nil {
+		writeGPU(bw, r.GPU)
+	}
+	if r.Diagnostics != nil {
+		writeDiagnostics(bw, r.Diagnostics)
+	}
+	if r.Output != nil {
+		writeOutput(bw, r.Output)
+	}
+	return bw.Flush()
+}
+
+// writePerfText renders a perf- or eBPF-backend result: the categories the
+// PMU could measure, then every event with its raw value and, for eBPF,
+// each probed function's calls and events.
+func (r *Result) writePerfText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if r.Container != nil {
+		fmt.Fprintf(bw, "Container: %s\n", r.Container)
+	}
+	if r.Redacted {
+		fmt.Fprintf(bw, "Redacted: functions, images and source files are named by tokens\n")
+	}
+	if t := r.Truncated; t != nil {
+		fmt.Fprintf(bw, "Truncated: stopped by %s after %.1f s; partial counts\n", t.Reason, t.ElapsedSec)
+	}
+	if r.Exit != nil {
+		fmt.Fprintf(bw, "Exit: %s\n", r.Exit)
+	}
+	for _, c := range CategoryNames {
+		if r.Perf.Measured(c) {
+			fmt.Fprintf(bw, "%s: %d\n", strings.ToUpper(c), r.Totals.Get(c))
+		} else {
+			fmt.Fprintf(bw, "%s: n/a\n", strings.ToUpper(c))
+		}
+	}
+	if r.Perf.Measured("fp64") || r.Perf.Measured("fp32") {
+		fmt.Fprintf(bw, "FP64 lane ops: %d\nFP32 lane ops: %d\n", r.Perf.FP64Ops, r.Perf.FP32Ops)
+	}
+
+	fmt.Fprintf(bw, "\n----- perf events (approximate) -----\n")
+	for _, e := range r.Perf.Events {
+		switch {
+		case !e.Supported:
+			fmt.Fprintf(bw, "%16s  %-42s  (%s)\n", "n/a", e.Name, e.Error)
+		case e.Scaled:
+			fmt.Fprintf(bw, "%16d  %-42s  (multiplexed)\n", e.Value, e.Name)
+		default:
+			fmt.Fprintf(bw, "%16d  %s\n", e.Value, e.Name)
+		}
+	}
+
+	if len(r.Perf.Functions) > 0 {
+		fmt.Fprintf(bw, "\n----- Functions (uprobes, inclusive) -----\n")
+		for _, f := range r.Perf.Functions {
+			fmt.Fprintf(bw, "%s  calls %d", f.Name, f.Calls)
+			if f.Dropped > 0 {
+				fmt.Fprintf(bw, "  (%d dropped: returned on another CPU)", f.Dropped)
+			}
+			fmt.Fprintln(bw)
+			for _, e := range r.Perf.Events {
+				if v, ok := f.Events[e.Name]; ok {
+					fmt.Fprintf(bw, "%16d  %s\n", v, e.Name)
+				}
+			}
+		}
+	}
+	if r.Diagnostics != nil {
+		writeDiagnostics(bw, r.Diagnostics)
+	}
+	if r.Output != nil {
+		writeOutput(bw, r.Output)
+	}
+	return bw.Flush()
+}
+
+// writeOpHeaders and writeOpCols render the optional category columns.
+func writeOpHeaders(w io.Writer, ops []string) {
+	for _, c := range ops {
+		fmt.Fprintf(w, "%14s", strings.ToUpper(c))
+	}
+}
+
+func writeOpCols(w io.Writer, ops []string, c Counts) {
+	for _, op := range ops {
+		fmt.Fprintf(w, "%14d", c.Get(op))
+	}
+}
+
+// fpSum returns ops.Sum(), treating a missing breakdown as zero.
+func fpSum(ops *FPOps) uint64 {
+	if ops == nil {
+		return 0
+	}
+	return ops.Sum()
+}
+
+// vecSum returns v.Sum(), treating a missing breakdown as zero.
+func vecSum(v *Vector) uint64 {
+	if v == nil {
+		return 0
+	}
+	return v.Sum()
+}
+
+// wideSum returns w.Sum(), treating a missing breakdown as zero.
+func wideSum(w *WideCounts) uint64 {
+	if w == nil {
+		return 0
+	}
+	return w.Sum()
+}
+
+// opsPerByte formats an arithmetic intensity of m, "-" when no bytes
+// were moved.
+func opsPerByte(m *Memory, v float64) string {
+	if m.Bytes() == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f", v)
+}
+
+// perText formats n/d to one decimal, "-" when d is zero.
+func perText(n, d uint64) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", float64(n)/float64(d))
+}
+
+// intFPRatio formats the INT/FP column; "-" when there are no FP ops.
+func intFPRatio(c Counts, fp uint64) string {
+	if fp == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", float64(c.Sum())/float64(fp))
+}
+
+// WriteFolded renders r.CallGraph as collapsed stacks ("main;f;g 1000"),
+// the input format of flamegraph.pl and compatible tools. Each stack is
+// weighted by the sum of the weight op types (the WriteCSV column names,
+// e.g. "mul" or "fp64_fma") or groups ("int", "bitwise", "vec", "wide",
+// "fp64", "fp32", "fp"); the default is "int", add+sub+mul+div. It writes
+// nothing without a call graph.
+func (r *Result) WriteFolded(w io.Writer, weight ...string) error {
+	if r.CallGraph == nil {
+		return nil
+	}
+	sel, err := r.foldedWeight(weight)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, s := range r.CallGraph.Stacks {
+		var n uint64
+		v := r.csvValues(s.Counts, s.Vector, s.Wide, s.FP64, s.FP32, s.Memory, s.Custom)
+		for _, i := range sel {
+			n += v[i]
+		}
+		if n == 0 {
+			continue
+		}
+		frames := "[unknown]"
+		if len(s.Frames) > 0 {
+			names := make([]string, len(s.Frames))
+			for i, f := range s.Frames {
+				names[i] = strings.ReplaceAll(f, ";", ":")
+			}
+			frames = strings.Join(names, ";")
+		}
+		fmt.Fprintf(bw, "%s %d\n", frames, n)
+	}
+	return bw.Flush()
+}
+
+// foldedWeight resolves WriteFolded weight names to csvOps indices.
+func (r *Result) foldedWeight(weight []string) ([]int, error) {
+	if len(weight) == 0 {
+		weight = []string{"int"}
+	}
+	ops := r.csvOps()
+	seen := make([]bool, len(ops))
+	for _, name := range weight {
+		found := false
+		for i, op := range ops {
+			base := i < len(CategoryNames)
+			bit := !base && !strings.Contains(op, "_")
+			group := name == "vec" || name == "wide" || name == "fp64" || name == "fp32" || name == "fp"
+			if op == name || (name == "int" && base) || (name == "bitwise" && bit) ||
+				(group && strings.HasPrefix(op, name)) {
+				seen[i], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("profiler: weight %q is unknown or not in this report", name)
+		}
+	}
+	var sel []int
+	for i, ok := range seen {
+		if ok {
+			sel = append(sel, i)
+		}
+	}
+	return sel, nil
+}
+
+// WriteJSON renders r as an indented JSON report.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Formats are the report formats of WriteFormat.
+var Formats = []string{"text", "json", "csv", "tsv", "html", "pprof", "dot"}
+
+// CheckFormat validates a WriteFormat format and CSV layout.
+func CheckFormat(format, layout string) error {
+	known := false
+	for _, f := range Formats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if layout != LayoutLong && layout != LayoutWide {
+		return fmt.Errorf("unknown layout %q", layout)
+	}
+	return nil
+}
+
+// WriteFormat renders r in format, one of Formats; layout applies to csv
+// and tsv.
+func (r *Result) WriteFormat(w io.Writer, format, layout string) error {
+	if err := CheckFormat(format, layout); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	switch format {
+	case "json":
+		return r.WriteJSON(w)
+	case "csv":
+		return r.WriteCSV(w, ',', layout)
+	case "tsv":
+		return r.WriteCSV(w, '\t', layout)
+	case "html":
+		return r.WriteHTML(w)
+	case "pprof":
+		return r.WritePprof(w)
+	case "dot":
+		return r.WriteDOT(w)
+	default:
+		return r.WriteText(w)
+	}
+}
+
+// writeDivisors renders the per-class division counts and the ten
+// busiest division sites.
+func writeDivisors(w io.Writer, d *Divisors) {
+	n := map[string]uint64{"pow2": d.Pow2, "constant": d.Constant, "variable": d.Variable}
+	sites := map[string]int{}
+	for _, s := range d.Sites {
+		sites[s.Class]++
+	}
+	all := d.Pow2 + d.Constant + d.Variable
+	fmt.Fprintf(w, "\n----- Divisions by divisor -----\n")
+	for _, class := range []string{"pow2", "constant", "variable"} {
+		pct := 0.0
+		if all > 0 {
+			pct = 100 * float64(n[class]) / float64(all)
+		}
+		plural := "s"
+		if sites[class] == 1 {
+			plural = ""
+		}
+		fmt.Fprintf(w, "%-10s%14d%8s  (%d site%s)\n", strings.ToUpper(class)+":", n[class],
+			fmt.Sprintf("%.1f%%", pct), sites[class], plural)
+	}
+	fmt.Fprintf(w, "%14s%10s%22s%10s  LOCATION\n", "DIVISIONS", "DISTINCT", "DIVISOR", "CLASS")
+	for i, s := range d.Sites {
+		if i == 10 {
+			break
+		}
+		distinct := strconv.Itoa(s.Distinct)
+		if s.Distinct > divValues {
+			distinct = strconv.Itoa(divValues) + "+"
+		}
+		divisor := "-"
+		if s.Divisor != "" {
+			divisor = s.Divisor.String()
+		}
+		fmt.Fprintf(w, "%14d%10s%22s%10s  %s  (%s:%d)\n", s.Count, distinct, divisor, s.Class,
+			s.Function, s.File, s.Line)
+	}
+}
+
+// writeBranches renders the branch totals and the most missed branches.
+func writeBranches(w io.Writer, b *Branches) {
+	pct := func(n, all uint64) string {
+		p := 0.0
+		if all > 0 {
+			p = 100 * float64(n) / float64(all)
+		}
+		return fmt.Sprintf("%.1f%%", p)
+	}
+	sites := "sites"
+	if b.Sites == 1 {
+		sites = "site"
+	}
+	fmt.Fprintf(w, "\n----- Branch predictability -----\n")
+	fmt.Fprintf(w, "Branches:     %d %s, %d executions, %s taken\n", b.Sites, sites, b.Executions,
+		pct(b.Taken, b.Executions))
+	fmt.Fprintf(w, "Mispredicted: %d bimodal (%s), %d local history (%s)\n", b.BimodalMisses,
+		pct(b.BimodalMisses, b.Executions), b.LocalMisses, pct(b.LocalMisses, b.Executions))
+	fmt.Fprintf(w, "%14s%8s%9s%12s%9s%8s  DIR   LOOP  LOCATION\n",
+		"EXECUTIONS", "TAKEN", "ENTROPY", "MISSES", "BIMODAL", "LOCAL")
+	for _, s := range b.Top {
+		dir, loop := "fwd ", "-"
+		if s.Backward {
+			dir = "back"
+		}
+		if s.Loop != nil {
+			loop = fmt.Sprintf("d%d", s.Loop.Depth)
+		}
+		// from the counts, as the pintool prints it, not the rounded field
+		entropy := 0.0
+		if s.Taken > 0 && s.Taken < s.Executions {
+			p := float64(s.Taken) / float64(s.Executions)
+			entropy = -p*math.Log2(p) - (1-p)*math.Log2(1-p)
+		}
+		fmt.Fprintf(w, "%14d%8s%9.3f%12d%9s%8s  %s%6s  %s  (%s:%d)\n", s.Executions,
+			pct(s.Taken, s.Executions), entropy, s.BimodalMisses, pct(s.BimodalMisses, s.Executions),
+			pct(s.LocalMisses, s.Executions), dir, loop, s.Function, s.File, s.Line)
+	}
+}
+
+// writeBlocks renders the hottest basic blocks with their instructions and
+// the op each one counts as.
+func writeBlocks(w io.Writer, blocks []Block) {
+	fmt.Fprintf(w, "\n----- Hot basic blocks -----\n")
+	fmt.Fprintf(w, "%14s%14s%10s%7s  BLOCK\n", "OPS", "EXECUTIONS", "OPS/EXEC", "INSNS")
+	for _, b := range blocks {
+		fmt.Fprintf(w, "%14d%14d%10d%7d  %s+%s", b.Ops, b.Executions, b.OpsPerExecution,
+			len(b.Instructions), b.Function, b.FunctionOffset)
+		if b.Line > 0 {
+			fmt.Fprintf(w, "  (%s:%d)", b.File, b.Line)
+		}
+		fmt.Fprintln(w)
+		for _, in := range b.Instructions {
+			op := in.Op
+			if in.Lanes > 1 {
+				op += fmt.Sprintf(" x%d", in.Lanes)
+			}
+			if op == "" {
+				fmt.Fprintf(w, "%20s  %s\n", fmt.Sprintf("+%#x", in.Offset), in.Disasm)
+			} else {
+				fmt.Fprintf(w, "%20s  %-40s%s\n", fmt.Sprintf("+%#x", in.Offset), in.Disasm, op)
+			}
+		}
+	}
+}
+
+// writeDataflow renders the size of the dataflow graph and its ten most
+// frequent edges.
+func writeDataflow(w io.Writer, d *Dataflow) {
+	loads := 0
+	for _, n := range d.Nodes {
+		if n.Kind == "load" {
+			loads++
+		}
+	}
+	fmt.Fprintf(w, "\n----- Dataflow graph -----\n")
+	fmt.Fprintf(w, "Nodes: %d (%d ops, %d loads)  Edges: %d\n", len(d.Nodes), len(d.Nodes)-loads, loads, len(d.Edges))
+	fmt.Fprintf(w, "%14s  EDGE (10 most frequent)\n", "COUNT")
+	for i, e := range d.Edges {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(w, "%14d  %s -> %s\n", e.Count, d.Nodes[e.From].Label(), d.Nodes[e.To].Label())
+	}
+}
+
+// writeDeadWork renders the dead results of the ten functions and the ten
+// instructions with the most.
+func writeDeadWork(w io.Writer, d *DeadWork) {
+	pct := func(n, all uint64) string {
+		if all == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(all))
+	}
+	fmt.Fprintf(w, "\n----- Dead work -----\n")
+	fmt.Fprintf(w, "Results: %d, dead %d (%s), live at exit %d\n", d.Results, d.Dead, pct(d.Dead, d.Results), d.LiveAtExit)
+	fmt.Fprintf(w, "%14s%14s%8s  FUNCTION\n", "RESULTS", "DEAD", "DEAD%")
+	for i, f := range d.Functions {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(w, "%14d%14d%8s  %s\n", f.Results, f.Dead, pct(f.Dead, f.Results), f.Function)
+	}
+	if len(d.Sites) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%14s%8s  INSTRUCTION (10 most dead)\n", "DEAD", "DEAD%")
+	for i, s := range d.Sites {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(w, "%14d%8s  %s %s+%s  %s  (%s:%d)\n", s.Dead, pct(s.Dead, s.Results), s.Op, s.Function, s.Offset,
+			s.Disasm, s.File, s.Line)
+	}
+}
+
+// writeMulWidths renders the operand widths in 8-bit bands with the share
+// of multiplies that fit each band's upper width.
+func writeMulWidths(w io.Writer, m *MulWidths) {
+	fmt.Fprintf(w, "\n----- Multiply operand widths -----\n")
+	fmt.Fprintf(w, "Sampled: %d of %d multiplies\n", m.Sampled, m.Multiplies)
+	fmt.Fprintf(w, "%8s%14s%8s%14s%8s\n", "BITS", "WIDER", "FITS", "NARROWER", "FITS")
+	for hi := 8; hi <= 64; hi += 8 {
+		lo := hi - 7
+		if hi == 8 {
+			lo = 0
+		}
+		fmt.Fprintf(w, "%8s", fmt.Sprintf("%d-%d", lo, hi))
+		for _, hist := range []map[int]uint64{m.Wider, m.Narrower} {
+			var band uint64
+			for bits := lo; bits <= hi; bits++ {
+				band += hist[bits]
+			}
+			fmt.Fprintf(w, "%14d%8s", band, fmt.Sprintf("%.1f%%", 100*m.share(hist, hi)))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeGoOrigins renders one row per origin with its share of the
+// arithmetic ops.
+func writeGoOrigins(w io.Writer, r *Result, g *GoOrigins, ops []string) {
+	fmt.Fprintf(w, "\n----- Go code by origin -----\n")
+	fmt.Fprintf(w, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	if r.Vector != nil {
+		fmt.Fprintf(w, "%14s", "VEC")
+	}
+	if r.Wide != nil {
+		fmt.Fprintf(w, "%14s", "WIDE")
+	}
+	if r.FP != nil {
+		fmt.Fprintf(w, "%14s%14s", "FP64", "FP32")
+	}
+	fmt.Fprintf(w, "%8s%8s  ORIGIN\n", "SHARE", "FUNCS")
+	rows := []struct {
+		name string
+		o    *Origin
+	}{{"user", &g.User}, {"stdlib", &g.Stdlib}, {"runtime", &g.Runtime}, {"other", &g.Other}}
+	var all uint64
+	for _, row := range rows {
+		all += row.o.Sum()
+	}
+	for _, row := range rows {
+		o := row.o
+		fmt.Fprintf(w, "%14d%14d%14d%14d", o.Add, o.Sub, o.Mul, o.Div)
+		writeOpCols(w, ops, o.Counts)
+		if r.Vector != nil {
+			fmt.Fprintf(w, "%14d", vecSum(o.Vector))
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(w, "%14d", wideSum(o.Wide))
+		}
+		if r.FP != nil {
+			fmt.Fprintf(w, "%14d%14d", fpSum(o.FP64), fpSum(o.FP32))
+		}
+		share := 0.0
+		if all > 0 {
+			share = 100 * float64(o.Sum()) / float64(all)
+		}
+		fmt.Fprintf(w, "%8s%8d  %s\n", fmt.Sprintf("%.1f%%", share), o.Functions, row.name)
+	}
+}
+
+// writeModules renders one row per loaded image with its share of the
+// arithmetic ops.
+func writeModules(w io.Writer, r *Result, ops []string) {
+	fmt.Fprintf(w, "\n----- Per-module breakdown -----\n")
+	fmt.Fprintf(w, "%14s%14s%14s%14s", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	if r.Vector != nil {
+		fmt.Fprintf(w, "%14s", "VEC")
+	}
+	if r.Wide != nil {
+		fmt.Fprintf(w, "%14s", "WIDE")
+	}
+	if r.FP != nil {
+		fmt.Fprintf(w, "%14s%14s", "FP64", "FP32")
+	}
+	fmt.Fprintf(w, "%8s%8s  LOADED   MODULE\n", "SHARE", "FUNCS")
+	for _, m := range r.Modules {
+		fmt.Fprintf(w, "%14d%14d%14d%14d", m.Add, m.Sub, m.Mul, m.Div)
+		writeOpCols(w, ops, m.Counts)
+		if r.Vector != nil {
+			fmt.Fprintf(w, "%14d", vecSum(m.Vector))
+		}
+		if r.Wide != nil {
+			fmt.Fprintf(w, "%14d", wideSum(m.Wide))
+		}
+		if r.FP != nil {
+			fmt.Fprintf(w, "%14d%14d", fpSum(m.FP64), fpSum(m.FP32))
+		}
+		share := 0.0
+		if t := r.Totals.Sum(); t > 0 {
+			share = 100 * float64(m.Sum()) / float64(t)
+		}
+		loaded := m.Loaded
+		if loaded == "" {
+			loaded = "-"
+		}
+		fmt.Fprintf(w, "%8s%8d  %-9s%s", fmt.Sprintf("%.1f%%", share), m.Functions, loaded, m.Path)
+		if m.Unloaded {
+			fmt.Fprintf(w, "  (unloaded)")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeCPUs renders the per-CPU and per-NUMA-node breakdowns, each with
+// the busiest row against the mean.
+func writeCPUs(w io.Writer, r *Result, ops []string) {
+	all := r.Totals.Sum() + r.Totals.BitSum()
+	share := func(c Counts) string {
+		if all == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(c.Sum()+c.BitSum())/float64(all))
+	}
+
+	fmt.Fprintf(w, "\n----- Per-CPU breakdown -----\n")
+	fmt.Fprintf(w, "%6s%6s%9s%14s%14s%14s%14s", "CPU", "NODE", "THREADS", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	fmt.Fprintf(w, "%8s\n", "SHARE")
+	var cpus, nodes []busy
+	for _, c := range r.CPUs {
+		if c.CPU >= 0 {
+			fmt.Fprintf(w, "%6d%6d", c.CPU, c.Node)
+			cpus = append(cpus, busy{c.CPU, c.Sum() + c.BitSum()})
+		} else {
+			fmt.Fprintf(w, "%6s%6s", "?", "?")
+		}
+		fmt.Fprintf(w, "%9d%14d%14d%14d%14d", c.Threads, c.Add, c.Sub, c.Mul, c.Div)
+		writeOpCols(w, ops, c.Counts)
+		fmt.Fprintf(w, "%8s\n", share(c.Counts))
+	}
+	writeBusiest(w, "CPU", cpus)
+
+	fmt.Fprintf(w, "\n----- Per-NUMA-node breakdown -----\n")
+	fmt.Fprintf(w, "%6s%6s%14s%14s%14s%14s", "NODE", "CPUS", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	fmt.Fprintf(w, "%8s\n", "SHARE")
+	for _, n := range r.NUMANodes {
+		nodes = append(nodes, busy{n.Node, n.Sum() + n.BitSum()})
+		fmt.Fprintf(w, "%6d%6d%14d%14d%14d%14d", n.Node, n.CPUs, n.Add, n.Sub, n.Mul, n.Div)
+		writeOpCols(w, ops, n.Counts)
+		fmt.Fprintf(w, "%8s\n", share(n.Counts))
+	}
+	writeBusiest(w, "node", nodes)
+}
+
+// busy is a CPU or node number with its ops.
+type busy struct {
+	id  int
+	ops uint64
+}
+
+// writeBusiest names the row of rows with the most ops and how many
+// times the mean it has, the imbalance; nothing for fewer than two.
+func writeBusiest(w io.Writer, what string, rows []busy) {
+	if len(rows) < 2 {
+		return
+	}
+	top, sum := rows[0], uint64(0)
+	for _, b := range rows {
+		sum += b.ops
+		if b.ops > top.ops {
+			top = b
+		}
+	}
+	if sum == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Busiest: %s %d, %.2fx the mean of the %d with counts\n",
+		what, top.id, float64(top.ops)*float64(len(rows))/float64(sum), len(rows))
+}
+
+// writeProcesses renders one row per followed process and their total.
+func writeProcesses(w io.Writer, p *Processes, ops []string) {
+	fmt.Fprintf(w, "\n----- Per-process breakdown -----\n")
+	fmt.Fprintf(w, "%8s%8s  START %14s%14s%14s%14s", "PID", "PPID", "ADD", "SUB", "MUL", "DIV")
+	writeOpHeaders(w, ops)
+	fmt.Fprintf(w, "  COMMAND\n")
+	for _, pr := range p.List {
+		fmt.Fprintf(w, "%8d%8d  %-6s%14d%14d%14d%14d", pr.Pid, pr.PPid, pr.Started, pr.Add, pr.Sub, pr.Mul, pr.Div)
+		writeOpCols(w, ops, pr.Counts)
+		cmd := pr.Args
+		if len(cmd) == 0 {
+			cmd = []string{pr.Binary}
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(cmd, " "))
+	}
+	t := p.Total
+	fmt.Fprintf(w, "%8s%8s  %-6s%14d%14d%14d%14d", "", "", "all", t.Add, t.Sub, t.Mul, t.Div)
+	writeOpCols(w, ops, t)
+	fmt.Fprintf(w, "  (%d processes)\n", len(p.List))
+}
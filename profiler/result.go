@@ -0,0 +1,925 @@
+package profiler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// SchemaVersion is the newest report schema this package understands.
+const SchemaVersion = 1
+
+// ErrUnsupportedSchema means a report was written by a newer tool.
+var ErrUnsupportedSchema = errors.New("profiler: unsupported report schema")
+
+// Result is a decoded Int64Profiler report (JSON schema version 1).
+type Result struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Tool          string              `json:"tool"`
+	Backend       string              `json:"backend,omitempty"` // BackendPin when empty
+	Arch          string              `json:"arch,omitempty"`    // target ISA, amd64 when empty
+	Approximate   bool                `json:"approximate,omitempty"`
+	Redacted      bool                `json:"redacted,omitempty"` // names are Redaction tokens
+	Binary        Binary              `json:"binary"`
+	Container     *Container          `json:"container,omitempty"` // of an attached process
+	Attached      bool                `json:"attached,omitempty"`  // Profiler.Attach session
+	Detached      bool                `json:"detached,omitempty"`  // report written at detach, process kept running
+	Truncated     *Truncation         `json:"truncated,omitempty"` // stopped at an Options limit or interrupted
+	Partial       *Partial            `json:"partial,omitempty"`   // the tool was killed before its report
+	Exit          *Exit               `json:"exit,omitempty"`      // of a launched target, unless status 0
+	Output        *Output             `json:"output,omitempty"`    // Options.CaptureOutput
+	Warmup        *Warmup             `json:"warmup,omitempty"`    // Options.Warmup, WarmupOps or SteadyState
+	Resumed       *Resumed            `json:"resumed,omitempty"`   // Options.Resume
+	Mode          string              `json:"mode"`
+	Compound      *Compound           `json:"compound,omitempty"` // how compound instructions count
+	Agen          *Agen               `json:"agen,omitempty"`     // Options.Agen: address arithmetic
+	Region        *Region             `json:"region,omitempty"`
+	WallTimeSec   float64             `json:"wall_time_sec"`
+	Overhead      *Overhead           `json:"overhead,omitempty"` // Options.Calibration
+	Totals        Counts              `json:"totals"`
+	Categories    Categories          `json:"categories"`
+	FP            *FP                 `json:"fp,omitempty"`
+	Vector        *Vector             `json:"vector,omitempty"`
+	Wide          *Wide               `json:"wide,omitempty"`
+	OpWidths      OpWidths            `json:"op_widths,omitempty"`  // Options.Widths
+	Signedness    *Signedness         `json:"signedness,omitempty"` // Options.Signedness
+	Atomics       *Atomics            `json:"atomics,omitempty"`    // Options.Atomics
+	Memory        *Memory             `json:"memory,omitempty"`
+	Cache         *Cache              `json:"cache,omitempty"`     // Options.Cache
+	Footprint     *Footprint          `json:"footprint,omitempty"` // Options.Footprint
+	Mix           *Mix                `json:"mix,omitempty"`       // Options.Mix
+	Modular       *Modular            `json:"modular,omitempty"`
+	Custom        Custom              `json:"custom,omitempty"` // Options.Classes, Options.Watch
+	Butterflies   *Butterflies        `json:"butterflies,omitempty"`
+	Divisors      *Divisors           `json:"divisors,omitempty"`
+	Branches      *Branches           `json:"branches,omitempty"`
+	Strides       *Strides            `json:"strides,omitempty"` // Options.Strides
+	Reuse         *Reuse              `json:"reuse,omitempty"`   // Options.Reuse
+	MulWidths     *MulWidths          `json:"mul_widths,omitempty"`
+	Filters       *Filters            `json:"filters,omitempty"`
+	GoOrigins     *GoOrigins          `json:"go_origins,omitempty"`
+	Sampling      *Sampling           `json:"sampling,omitempty"`
+	Functions     []Function          `json:"functions,omitempty"`
+	Top           *Top                `json:"top,omitempty"` // SetTop
+	Lines         []Line              `json:"lines,omitempty"`
+	Loops         []Loop              `json:"loops,omitempty"`
+	Blocks        []Block             `json:"blocks,omitempty"`
+	Annotated     []AnnotatedFunction `json:"annotated,omitempty"` // Options.Annotate
+	Dataflow      *Dataflow           `json:"dataflow,omitempty"`
+	DeadWork      *DeadWork           `json:"dead_work,omitempty"` // Options.DeadWork
+	Modules       []Module            `json:"modules,omitempty"`
+	DebugInfo     []DebugFile         `json:"debug_info,omitempty"`
+	Images        []LoadedImage       `json:"images,omitempty"` // pin backend: the load map
+	Processes     *Processes          `json:"processes,omitempty"`
+	Threads       []Thread            `json:"threads,omitempty"`
+	CPUs          []CPU               `json:"cpus,omitempty"`       // Options.PerCPU
+	NUMANodes     []NUMANode          `json:"numa_nodes,omitempty"` // Options.PerCPU
+	Regions       []RegionCounts      `json:"regions,omitempty"`
+	Budgets       []Budget            `json:"budgets,omitempty"`  // declared by the workload
+	Syscalls      *SysTime            `json:"syscalls,omitempty"` // Options.SysTime
+	Python        *Python             `json:"python,omitempty"`   // Options.Python
+	Phases        *Phases             `json:"phases,omitempty"`   // Options.Phases
+	CallGraph     *CallGraph          `json:"callgraph,omitempty"`
+	Perf          *Perf               `json:"perf,omitempty"`
+	GPU           *GPU                `json:"gpu,omitempty"`       // BackendGPU, or merged with MergeGPU
+	Recording     *RecordingRef       `json:"recording,omitempty"` // Profiler.Record and Replay runs
+	Diagnostics   *Diagnostics        `json:"diagnostics,omitempty"`
+
+	// names is the SetNames mode, rawNames maps the demangled names to
+	// their symbols.
+	names    string
+	rawNames map[string]string
+}
+
+// Binary describes the profiled process.
+type Binary struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Pid  int      `json:"pid"`
+}
+
+// Region is the counting window in address or marker mode.
+type Region struct {
+	Addr  string `json:"addr,omitempty"`
+	Start string `json:"start,omitempty"`
+	Stop  string `json:"stop,omitempty"`
+}
+
+// Counts holds one value per operation category. The shift, rotate and
+// logic categories are only reported when selected with Options.Ops.
+type Counts struct {
+	Add uint64 `json:"add"`
+	Sub uint64 `json:"sub"`
+	Mul uint64 `json:"mul"`
+	Div uint64 `json:"div"`
+
+	Shl uint64 `json:"shl,omitempty"`
+	Shr uint64 `json:"shr,omitempty"`
+	Rol uint64 `json:"rol,omitempty"`
+	And uint64 `json:"and,omitempty"`
+	Or  uint64 `json:"or,omitempty"`
+	Xor uint64 `json:"xor,omitempty"`
+	Not uint64 `json:"not,omitempty"`
+}
+
+// Sum returns the total over the arithmetic categories (add, sub, mul,
+// div); see BitSum for the others.
+func (c Counts) Sum() uint64 { return c.Add + c.Sub + c.Mul + c.Div }
+
+// BitSum returns the total over the shift, rotate and logic categories.
+func (c Counts) BitSum() uint64 {
+	return c.Shl + c.Shr + c.Rol + c.And + c.Or + c.Xor + c.Not
+}
+
+// CategoryNames lists the arithmetic categories in report order.
+var CategoryNames = []string{"add", "sub", "mul", "div"}
+
+// BitCategoryNames lists the optional shift, rotate and logic categories
+// in report order.
+var BitCategoryNames = []string{"shl", "shr", "rol", "and", "or", "xor", "not"}
+
+// Get returns the count for category name ("add", "shl", …).
+func (c Counts) Get(name string) uint64 {
+	switch name {
+	case "add":
+		return c.Add
+	case "sub":
+		return c.Sub
+	case "mul":
+		return c.Mul
+	case "div":
+		return c.Div
+	case "shl":
+		return c.Shl
+	case "shr":
+		return c.Shr
+	case "rol":
+		return c.Rol
+	case "and":
+		return c.And
+	case "or":
+		return c.Or
+	case "xor":
+		return c.Xor
+	case "not":
+		return c.Not
+	}
+	return 0
+}
+
+// Ops returns the optional categories the run counted, in report order.
+func (r *Result) Ops() []string {
+	var ops []string
+	for _, c := range BitCategoryNames {
+		if _, ok := r.Categories[c]; ok {
+			ops = append(ops, c)
+		}
+	}
+	return ops
+}
+
+// Categories breaks each total down by instruction, keyed by category
+// ("add") and then instruction ("adc").
+type Categories map[string]map[string]Variant
+
+// Variant splits an instruction's count by operand form.
+type Variant struct {
+	RR uint64 `json:"rr"` // register–register
+	RM uint64 `json:"rm"` // register–memory
+}
+
+// FP holds floating-point lane-op counts by precision.
+type FP struct {
+	FP64       FPOps   `json:"fp64"`
+	FP32       FPOps   `json:"fp32"`
+	IntFPRatio float64 `json:"int_fp_ratio,omitempty"` // absent when no FP ops
+}
+
+// FPOps holds one precision's floating-point counts. Packed instructions
+// count one op per vector lane.
+type FPOps struct {
+	Add uint64 `json:"add"`
+	Sub uint64 `json:"sub"`
+	Mul uint64 `json:"mul"`
+	Div uint64 `json:"div"`
+	FMA uint64 `json:"fma"`
+}
+
+// Sum returns the total over all FP operations.
+func (f FPOps) Sum() uint64 { return f.Add + f.Sub + f.Mul + f.Div + f.FMA }
+
+// Vector holds packed int64 lane ops (a 512-bit VPADDQ is 8 adds). The
+// scalar counts in Totals do not include them.
+type Vector struct {
+	Add uint64 `json:"add"`
+	Sub uint64 `json:"sub"`
+	Mul uint64 `json:"mul"`
+}
+
+// Sum returns the total over all categories.
+func (v Vector) Sum() uint64 { return v.Add + v.Sub + v.Mul }
+
+// Memory holds data memory traffic: loads and stores are operand
+// accesses, counted with their bytes. The intensities are arithmetic ops
+// per byte moved, integer (add..div plus vector lanes) and, with
+// Options.FP, FP lane ops; both are absent when no bytes were moved. With
+// Options.Cache the DRAM bytes are the simulated transfers (see Cache),
+// with the intensities over them.
+type Memory struct {
+	Loads             uint64  `json:"loads"`
+	Stores            uint64  `json:"stores"`
+	BytesRead         uint64  `json:"bytes_read"`
+	BytesWritten      uint64  `json:"bytes_written"`
+	DRAMBytesRead     uint64  `json:"dram_bytes_read,omitempty"`
+	DRAMBytesWritten  uint64  `json:"dram_bytes_written,omitempty"`
+	IntOpsPerByte     float64 `json:"int_ops_per_byte,omitempty"`
+	FPOpsPerByte      float64 `json:"fp_ops_per_byte,omitempty"`
+	IntOpsPerDRAMByte float64 `json:"int_ops_per_dram_byte,omitempty"`
+	FPOpsPerDRAMByte  float64 `json:"fp_ops_per_dram_byte,omitempty"`
+}
+
+// Bytes returns the bytes moved in either direction.
+func (m Memory) Bytes() uint64 { return m.BytesRead + m.BytesWritten }
+
+// DRAMBytes returns the simulated DRAM traffic in either direction.
+func (m Memory) DRAMBytes() uint64 { return m.DRAMBytesRead + m.DRAMBytesWritten }
+
+// Modular holds the recognized modular-arithmetic sequences
+// (Options.ModArith): modular multiplies by reduction, and modular adds
+// and subtracts. Their instructions are also in the add/sub/mul counts.
+type Modular struct {
+	Mul ModMul `json:"mul"`
+	Add uint64 `json:"add"`
+	Sub uint64 `json:"sub"`
+}
+
+// Custom holds the counts of the custom categories of Options.Classes,
+// by classifier name, and of the watchpoints of Options.Watch, the
+// memory accesses by name. Their instructions may also be in the
+// built-in counts.
+type Custom map[string]uint64
+
+// Names returns the categories in c, sorted.
+func (c Custom) Names() []string {
+	names := make([]string, 0, len(c))
+	for n := range c {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ModMul counts modular multiplies by reduction: Montgomery REDC,
+// Barrett, Shoup (precomputed quotient) and a 128-by-64 division
+// (DIV or a __umodti3 call) of the product.
+type ModMul struct {
+	Montgomery uint64 `json:"montgomery"`
+	Barrett    uint64 `json:"barrett"`
+	Shoup      uint64 `json:"shoup"`
+	Division   uint64 `json:"division"`
+}
+
+// Sum returns the modular multiplies over all reductions.
+func (m ModMul) Sum() uint64 { return m.Montgomery + m.Barrett + m.Shoup + m.Division }
+
+// Butterflies holds the NTT/FFT butterflies (Options.Butterflies): basic
+// blocks with a modular multiply, add and subtract, tallied per
+// invocation of their function.
+type Butterflies struct {
+	Total      uint64      `json:"total"`
+	Transforms []Transform `json:"transforms"` // most butterflies first
+}
+
+// Transform is the invocations of one function that each ran PerCall
+// butterflies. Size is the radix-2 transform length N with
+// (N/2)·log₂N = PerCall, zero when there is none.
+type Transform struct {
+	Function    string `json:"function"`
+	Image       string `json:"image"`
+	File        string `json:"file,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Calls       uint64 `json:"calls"`
+	PerCall     uint64 `json:"per_call"`
+	Size        uint64 `json:"size,omitempty"`
+	Butterflies uint64 `json:"butterflies"`
+}
+
+// Divisors classes the executed 64-bit DIV/IDIV sites (Options.Divs) by
+// the divisors they saw: Pow2, Constant and Variable are divisions at
+// power-of-two sites (shifts), single-constant sites (multiply by a
+// reciprocal) and variable sites.
+type Divisors struct {
+	Pow2     uint64    `json:"pow2"`
+	Constant uint64    `json:"constant"`
+	Variable uint64    `json:"variable"`
+	Sites    []DivSite `json:"sites"` // most divisions first
+}
+
+// divValues is how many distinct divisors the pintool tells apart per
+// division site.
+const divValues = 8
+
+// DivSite is one division instruction. Distinct stops counting at 9;
+// Divisor is set when the site only ever divided by one value, signed for
+// IDIV and unsigned (up to 2⁶⁴-1) for DIV. Class is "pow2", "constant" or
+// "variable".
+type DivSite struct {
+	Address  string      `json:"address"`
+	Function string      `json:"function"`
+	File     string      `json:"file"` // "??", Line 0 without DWARF line info
+	Line     int         `json:"line"`
+	Signed   bool        `json:"signed"`
+	Count    uint64      `json:"count"`
+	Pow2     uint64      `json:"pow2"` // divisions by a power of two
+	Distinct int         `json:"distinct"`
+	Divisor  json.Number `json:"divisor,omitempty"`
+	Class    string      `json:"class"`
+}
+
+// Branches rates how predictable the executed conditional branches were
+// (Options.Branches), over all Sites of them: each thread's branches run
+// through a simulated bimodal predictor (a 2-bit counter per branch) and
+// a local-history one (2-bit counters indexed by the branch's last 8
+// outcomes). Top lists the branches the bimodal predictor missed most.
+type Branches struct {
+	Sites         int          `json:"sites"`
+	Executions    uint64       `json:"executions"`
+	Taken         uint64       `json:"taken"`
+	BimodalMisses uint64       `json:"bimodal_misses"`
+	LocalMisses   uint64       `json:"local_misses"`
+	Top           []BranchSite `json:"top"`
+}
+
+// BranchSite is one conditional branch. Backward branches jump to a lower
+// address, as loops close; Loop is its innermost loop with Options.Loops.
+// Entropy is of its outcomes at its taken rate, in bits: 0 for a branch
+// that always goes one way, 1 for a coin flip. Transitions counts the
+// executions that went the other way from the one before.
+type BranchSite struct {
+	Address       string      `json:"address"`
+	Function      string      `json:"function"`
+	File          string      `json:"file"` // "??", Line 0 without DWARF line info
+	Line          int         `json:"line"`
+	Backward      bool        `json:"backward"`
+	Loop          *BranchLoop `json:"loop,omitempty"`
+	Executions    uint64      `json:"executions"`
+	Taken         uint64      `json:"taken"`
+	Transitions   uint64      `json:"transitions"`
+	Entropy       float64     `json:"entropy"`
+	BimodalMisses uint64      `json:"bimodal_misses"`
+	LocalMisses   uint64      `json:"local_misses"`
+}
+
+// BranchLoop is the loop of a branch: its header's offset in the
+// function, the header's line and the loop's nesting depth.
+type BranchLoop struct {
+	Offset string `json:"offset"`
+	Line   int    `json:"line"`
+	Depth  int    `json:"depth"`
+}
+
+// MulWidths histograms the effective operand widths of the sampled
+// 64-bit multiplies (Options.MulVals), keyed by bits (0–64): Wider counts
+// each multiply under its wider operand and Narrower under the other.
+// Widths are unsigned for MUL/MULX and two's complement, sign bit
+// included, for IMUL.
+type MulWidths struct {
+	Period     uint64         `json:"period"`     // every Period-th multiply was read
+	Multiplies uint64         `json:"multiplies"` // all counted multiplies
+	Sampled    uint64         `json:"sampled"`
+	Wider      map[int]uint64 `json:"wider"`
+	Narrower   map[int]uint64 `json:"narrower"`
+}
+
+// Fits returns the share of sampled multiplies whose operands both fit
+// in bits, i.e. that a bits×bits multiplier would take.
+func (m *MulWidths) Fits(bits int) float64 {
+	return m.share(m.Wider, bits)
+}
+
+func (m *MulWidths) share(hist map[int]uint64, bits int) float64 {
+	if m.Sampled == 0 {
+		return 0
+	}
+	var n uint64
+	for w, c := range hist {
+		if w <= bits {
+			n += c
+		}
+	}
+	return float64(n) / float64(m.Sampled)
+}
+
+// Filters are the function and module filters of Options (Include,
+// IncludeFunc, IncludeModule, …); code they filter out was not counted.
+type Filters struct {
+	Include       []string `json:"include,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	IncludeFunc   []string `json:"include_func,omitempty"`
+	ExcludeFunc   []string `json:"exclude_func,omitempty"`
+	IncludeModule []string `json:"include_module,omitempty"`
+	ExcludeModule []string `json:"exclude_module,omitempty"`
+}
+
+// GoOrigins folds the functions of a Go binary by where their code comes
+// from: the user's packages (main and module paths), the standard
+// library, and the runtime with the internal packages and assembly it is
+// made of. Other holds code outside Go images (libc, the vDSO, …).
+type GoOrigins struct {
+	User    Origin `json:"user"`
+	Stdlib  Origin `json:"stdlib"`
+	Runtime Origin `json:"runtime"`
+	Other   Origin `json:"other"`
+}
+
+// Origin is one row of GoOrigins; Functions counts those with counts.
+type Origin struct {
+	Functions int `json:"functions"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// Wide holds the detected multi-limb operations, keyed by operand width
+// in bits (128, 192, …; 512 also collects anything wider). Limb widths
+// are estimated from instruction patterns; see the README.
+type Wide struct {
+	Add map[int]uint64 `json:"add"`
+	Sub map[int]uint64 `json:"sub"`
+	Mul map[int]uint64 `json:"mul"`
+}
+
+// WideCounts is a breakdown row's number of wide operations per kind.
+type WideCounts struct {
+	Add uint64 `json:"add"`
+	Sub uint64 `json:"sub"`
+	Mul uint64 `json:"mul"`
+}
+
+// Sum returns the total over all kinds.
+func (w WideCounts) Sum() uint64 { return w.Add + w.Sub + w.Mul }
+
+// Sampling describes a sampled run (Options.Sample). Every count in the
+// Result has then been extrapolated from the sampled windows.
+type Sampling struct {
+	Fraction            float64             `json:"fraction"`
+	Window              uint64              `json:"window"`
+	Seed                uint64              `json:"seed"`
+	WindowsTotal        uint64              `json:"windows_total"`
+	WindowsSampled      uint64              `json:"windows_sampled"`
+	InstructionsTotal   uint64              `json:"instructions_total"`
+	InstructionsSampled uint64              `json:"instructions_sampled"`
+	Estimates           map[string]Estimate `json:"estimates"` // keyed by category
+}
+
+// Estimate is an extrapolated total with the half-width of its 95%
+// confidence interval; CI95 is nil when fewer than two windows were sampled.
+type Estimate struct {
+	Value uint64   `json:"value"`
+	CI95  *float64 `json:"ci95,omitempty"`
+}
+
+// Function is one row of the per-function breakdown.
+type Function struct {
+	Name    string `json:"name"`
+	Mangled string `json:"mangled,omitempty"` // the symbol Name was demangled from; see Result.SetNames
+	Image   string `json:"image"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	// Origin is "user", "stdlib", "runtime" or "other"; present with
+	// Options.Go.
+	Origin string `json:"origin,omitempty"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"` // present with Options.Vec
+	Wide    *WideCounts `json:"wide,omitempty"`   // present with Options.Wide
+	FP64    *FPOps      `json:"fp64,omitempty"`   // present with Options.FP
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`  // present with Options.Mem
+	Modular *Modular    `json:"modular,omitempty"` // present with Options.ModArith
+	Custom  Custom      `json:"custom,omitempty"`  // present with Options.Classes or Options.Watch
+	// OpWidths is present with Options.Widths, Signedness with
+	// Options.Signedness and Atomics with Options.Atomics.
+	OpWidths   OpWidths    `json:"op_widths,omitempty"`
+	Signedness *Signedness `json:"signedness,omitempty"`
+	Atomics    *Atomics    `json:"atomics,omitempty"`
+}
+
+// Line is one row of the per-source-line breakdown. Instructions without
+// DWARF line info are collected under File "??", Line 0.
+type Line struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// Loop is a natural loop of a function's control-flow graph
+// (Options.Loops): the blocks that reach a back edge to its header, which
+// dominates them. Its counts include those of the loops nested in it, but
+// not of the functions it calls. Loops are identified by ID, unique within
+// the report; Parent is the enclosing loop's, and Depth is 1 for an
+// outermost loop. Offset is the header's address from the function start,
+// File and Line its source location ("??", 0 without DWARF line info).
+//
+// Entries counts how often the loop was entered and Iterations how often
+// its body ran: the back edges taken for a loop tested at the header, the
+// header executions for one tested at the bottom. Both are 0 for the
+// static backend, whose loops span the code from a jump back's target to
+// the jump and count its instructions once.
+type Loop struct {
+	ID         int    `json:"id"`
+	Parent     *int   `json:"parent,omitempty"` // nil for an outermost loop
+	Depth      int    `json:"depth"`
+	Function   string `json:"function"`
+	Image      string `json:"image"`
+	Offset     string `json:"offset"`
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Blocks     int    `json:"blocks"`
+	Entries    uint64 `json:"entries"`
+	Iterations uint64 `json:"iterations"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// TripCount returns the average iterations per entry, 0 if the loop was
+// never entered while counting.
+func (l Loop) TripCount() float64 {
+	if l.Entries == 0 {
+		return 0
+	}
+	return float64(l.Iterations) / float64(l.Entries)
+}
+
+// Ops returns the loop's arithmetic, shift and logic, vector lane and FP
+// lane operations.
+func (l Loop) Ops() uint64 {
+	return l.Sum() + l.BitSum() + vecSum(l.Vector) + fpSum(l.FP64) + fpSum(l.FP32)
+}
+
+// OpsPerIteration returns Ops over Iterations, 0 without iterations.
+func (l Loop) OpsPerIteration() float64 {
+	if l.Iterations == 0 {
+		return 0
+	}
+	return float64(l.Ops()) / float64(l.Iterations)
+}
+
+// Block is one of the basic blocks that ran the most counted operations
+// (Options.Blocks), with its decoded instructions, for flows that turn hot
+// code into accelerator kernels. Blocks are Pin's: single entry, single
+// exit, ended by any control transfer, calls included. Addresses are hex;
+// ImageOffset and FunctionOffset are the block's address from the image's
+// load address and the function start. Ops is OpsPerExecution, the
+// counted instructions with their vector and FP lanes, times Executions.
+type Block struct {
+	Address         string             `json:"address"`
+	Image           string             `json:"image"`
+	ImageOffset     string             `json:"image_offset"`
+	Function        string             `json:"function"`
+	FunctionOffset  string             `json:"function_offset"`
+	File            string             `json:"file"`
+	Line            int                `json:"line"`
+	Executions      uint64             `json:"executions"`
+	OpsPerExecution uint64             `json:"ops_per_execution"`
+	Ops             uint64             `json:"ops"`
+	Instructions    []BlockInstruction `json:"instructions"`
+}
+
+// BlockInstruction is one instruction of a Block. Offset is from the
+// block start and Bytes its encoding in hex. Op is the WriteCSV op type
+// it counts as, e.g. "mul", "vec_add" or "fp64_fma", empty if it is not
+// counted; Lanes is its vector or FP lanes when more than one.
+type BlockInstruction struct {
+	Offset   uint64    `json:"offset"`
+	Bytes    string    `json:"bytes"`
+	Mnemonic string    `json:"mnemonic"`
+	Disasm   string    `json:"disasm"`
+	Op       string    `json:"op,omitempty"`
+	Lanes    int       `json:"lanes,omitempty"`
+	Operands []Operand `json:"operands"`
+}
+
+// Operand is an explicit or implicit operand of a BlockInstruction. Kind
+// is "reg" (Reg), "mem" or "agen" (a LEA's address: Base + Index*Scale +
+// Disp), "imm" (Value) or "rel" (a branch; Target is the destination's
+// offset from the block start, 0 for an indirect one). Width is in bits
+// and Access is "r", "w" or "rw".
+type Operand struct {
+	Kind     string `json:"kind"`
+	Reg      string `json:"reg,omitempty"`
+	Base     string `json:"base,omitempty"`
+	Index    string `json:"index,omitempty"`
+	Scale    int    `json:"scale,omitempty"`
+	Disp     int64  `json:"disp,omitempty"`
+	Value    int64  `json:"value,omitempty"`
+	Target   int64  `json:"target,omitempty"`
+	Width    int    `json:"width"`
+	Access   string `json:"access"`
+	Implicit bool   `json:"implicit,omitempty"`
+}
+
+// AnnotatedFunction is a function matched by Options.Annotate, every
+// instruction of it whether executed or not. Address is hex and
+// Executions the sum over its instructions.
+type AnnotatedFunction struct {
+	Function     string                 `json:"function"`
+	Image        string                 `json:"image"`
+	Address      string                 `json:"address"`
+	Executions   uint64                 `json:"executions"`
+	Instructions []AnnotatedInstruction `json:"instructions"`
+}
+
+// AnnotatedInstruction is one instruction of an AnnotatedFunction. Offset
+// is from the function start; Bytes, Op and Lanes are as in a
+// BlockInstruction. File and Line are empty without line tables.
+type AnnotatedInstruction struct {
+	Offset     uint64 `json:"offset"`
+	Bytes      string `json:"bytes"`
+	Mnemonic   string `json:"mnemonic"`
+	Disasm     string `json:"disasm"`
+	Op         string `json:"op,omitempty"`
+	Lanes      int    `json:"lanes,omitempty"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Executions uint64 `json:"executions"`
+}
+
+// Dataflow is the dataflow graph of the counted code (Options.Dataflow):
+// how values flowed between its operations while it ran. Nodes are static
+// instructions in address order, their ID their index: the counted ones
+// (Kind "op") and the loads of values the graph did not produce (Kind
+// "load", its inputs). An edge joins the producer of a value to an
+// instruction that consumed it, Count the times it did. Values are
+// followed through registers and memory, so spills and moves do not break
+// a chain, but not between threads; a loop-carried dependency, such as an
+// accumulator, is an edge back to an earlier node or to the node itself.
+type Dataflow struct {
+	Nodes []DataflowNode `json:"nodes"`
+	Edges []DataflowEdge `json:"edges"` // most frequent first
+}
+
+// DataflowNode is an instruction of a Dataflow. Op is the WriteCSV op
+// type of an "op" node and Lanes its vector or FP lanes when more than
+// one; Offset is from the function start.
+type DataflowNode struct {
+	ID         int    `json:"id"`
+	Kind       string `json:"kind"`
+	Op         string `json:"op,omitempty"`
+	Lanes      int    `json:"lanes,omitempty"`
+	Function   string `json:"function"`
+	Image      string `json:"image"`
+	Offset     string `json:"offset"`
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Disasm     string `json:"disasm"`
+	Executions uint64 `json:"executions"`
+}
+
+// Label names n by its op, or "load", and location, e.g. "mul mat+0x2a".
+func (n DataflowNode) Label() string {
+	op := n.Op
+	if n.Kind == "load" {
+		op = "load"
+	}
+	return op + " " + n.Function + "+" + n.Offset
+}
+
+// DataflowEdge is a value dependency between two Dataflow nodes.
+type DataflowEdge struct {
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+	Count uint64 `json:"count"`
+}
+
+// DeadWork counts the results of counted add..div and -ops ops that were
+// overwritten before anything read them (Options.DeadWork): wasted or
+// speculative work. A result is followed through general registers, the
+// flags and memory; a plain move copies it without using it, so a spill
+// never reloaded is dead too. LiveAtExit were still held, unread, when
+// the run ended, and are not dead. Functions lists every function with
+// results and Sites the instructions with dead ones, most dead first.
+type DeadWork struct {
+	Results    uint64         `json:"results"`
+	Dead       uint64         `json:"dead"`
+	LiveAtExit uint64         `json:"live_at_exit"`
+	Functions  []DeadFunction `json:"functions"`
+	Sites      []DeadSite     `json:"sites"`
+}
+
+// DeadFunction is the results of one function's counted ops.
+type DeadFunction struct {
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	Results  uint64 `json:"results"`
+	Dead     uint64 `json:"dead"`
+}
+
+// DeadSite is one counted instruction with dead results. Op is its
+// WriteCSV op type and Offset is from the function start.
+type DeadSite struct {
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	Offset   string `json:"offset"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Op       string `json:"op"`
+	Disasm   string `json:"disasm"`
+	Results  uint64 `json:"results"`
+	Dead     uint64 `json:"dead"`
+}
+
+// Module is one row of the per-module breakdown: an image the process
+// loaded, its counts and how many of its functions had any. Loaded is
+// "startup" for the executable and the libraries it was linked with,
+// "dlopen" for one loaded while the program ran, and empty for the
+// "[unknown]" row collecting code outside any known image.
+type Module struct {
+	Path      string `json:"path"`
+	Loaded    string `json:"loaded,omitempty"`
+	Unloaded  bool   `json:"unloaded,omitempty"` // dlclose()d before exit
+	Functions int    `json:"functions"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// Processes is the per-process breakdown of a run that followed its
+// children (Options.FollowChildren). The launched process comes first;
+// the rest of the Result counts it alone, Total sums every process.
+type Processes struct {
+	List  []Process `json:"list"`
+	Total Counts    `json:"total"`
+}
+
+// Process is one instrumented image. Started is "launch", "attach",
+// "fork" or "exec"; a process that forks and then execs appears twice
+// under the same Pid.
+type Process struct {
+	Pid     int      `json:"pid"`
+	PPid    int      `json:"ppid"`
+	Started string   `json:"started"`
+	Binary  string   `json:"binary"`
+	Args    []string `json:"args"`
+	Counts
+}
+
+// Thread is one row of the per-thread breakdown. Tid is Pin's thread
+// index (0 = main thread); OSTid is the kernel thread id.
+type Thread struct {
+	Tid         int  `json:"tid"`
+	OSTid       int  `json:"os_tid"`
+	ParentOSTid int  `json:"parent_os_tid,omitempty"`
+	Exited      bool `json:"exited"`
+	ExitCode    int  `json:"exit_code,omitempty"`
+	Counts
+}
+
+// CPU is one row of the per-CPU breakdown: the counts charged to CPU by
+// sampling each thread's CPU every 10ms, CPU -1 for threads never seen
+// on one. Threads is how many threads were charged to it and Node its
+// NUMA node (-1 with CPU -1).
+type CPU struct {
+	CPU     int `json:"cpu"`
+	Node    int `json:"node"`
+	Threads int `json:"threads"`
+	Counts
+}
+
+// NUMANode sums the CPU rows of one NUMA node; CPUs is how many of them.
+type NUMANode struct {
+	Node int `json:"node"`
+	CPUs int `json:"cpus"`
+	Counts
+}
+
+// RegionCounts is one named client-API region in regions mode, summed
+// over all threads. Entries is how many times the region was closed.
+type RegionCounts struct {
+	Name    string `json:"name"`
+	Entries uint64 `json:"entries"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+}
+
+// CallGraph is the calling-context breakdown. Contexts come from a shadow
+// stack of function entries, so tail calls replace their caller and code
+// reached before any known function entry has no frames.
+type CallGraph struct {
+	Functions []CallGraphFunction `json:"functions"` // by descending inclusive count
+	Stacks    []Stack             `json:"stacks"`
+}
+
+// CallGraphFunction holds a function's counts including (Inclusive) and
+// excluding (Exclusive) its callees. Recursive calls count once.
+type CallGraphFunction struct {
+	Name      string `json:"name"`
+	Mangled   string `json:"mangled,omitempty"` // as Function.Mangled
+	Image     string `json:"image"`
+	Inclusive Counts `json:"inclusive"`
+	Exclusive Counts `json:"exclusive"`
+}
+
+// Stack is one calling context, outermost frame first, with the counts of
+// its innermost function.
+type Stack struct {
+	Frames []string `json:"frames"`
+	Counts
+	Vector  *Vector     `json:"vector,omitempty"`
+	Wide    *WideCounts `json:"wide,omitempty"`
+	FP64    *FPOps      `json:"fp64,omitempty"`
+	FP32    *FPOps      `json:"fp32,omitempty"`
+	Memory  *Memory     `json:"memory,omitempty"`
+	Modular *Modular    `json:"modular,omitempty"`
+	Custom  Custom      `json:"custom,omitempty"`
+	Atomics *Atomics    `json:"atomics,omitempty"`
+}
+
+// Decode reads a JSON report from r, upgrading a report of an older
+// schema as Migrate does. Functions of the images in DebugInfo with no
+// source location get the one their debug file, if still there, gives.
+func Decode(r io.Reader) (*Result, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("profiler: decode report: %w", err)
+	}
+	var v struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("profiler: decode report: %w", err)
+	}
+	if v.SchemaVersion == nil || *v.SchemaVersion != SchemaVersion {
+		var err error
+		if raw, _, err = Migrate(raw); err != nil {
+			return nil, err
+		}
+	}
+	var res Result
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("profiler: decode report: %w", err)
+	}
+	res.debugLines()
+	res.SetNames(NamesDemangled)
+	return &res, nil
+}
+
+// Load reads a JSON report from the file at path, the report of a bundle
+// (see Bundle), or the totals of a pintool -partial file (see
+// Result.Partial).
+func Load(path string) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if fi.Size() == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoReport, path)
+	}
+	magic := make([]byte, len(partialMagic))
+	if _, err := io.ReadFull(f, magic); err == nil && string(magic) == partialMagic {
+		return readPartial(path)
+	}
+	if string(magic[:len(bundleMagic)]) == bundleMagic {
+		b, err := ReadBundle(path)
+		if err != nil {
+			return nil, err
+		}
+		return b.Result, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	return Decode(f)
+}
@@ -0,0 +1,108 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Reuse is how often the counted ops of loops repeated a computation
+// (Options.Reuse), the work memoization or a hardware result-reuse cache
+// could save. Each execution of an add..div or bitwise op in a loop looks
+// its opcode and source operands up in a per-thread reuse buffer of
+// Entries recent computations, direct-mapped: Repeated is the hits, and
+// Same the executions with the same operands as the instruction's
+// previous one, loop-invariant work a compiler could hoist. The operands
+// include implicit ones, such as the RAX of MUL and the carry of ADC, and
+// those of commutative ops are taken in either order. Loops is how many
+// loops had counted ops and the totals are over all of them; Top lists
+// the Options.Reuse loops with the most hits and Sites as many
+// instructions.
+type Reuse struct {
+	Entries int `json:"entries"`
+	Loops   int `json:"loops"`
+	ReuseCounts
+	Top   []ReuseLoop `json:"top"`
+	Sites []ReuseSite `json:"sites"`
+}
+
+// ReuseCounts is the checked ops of one or more loops or instructions.
+type ReuseCounts struct {
+	Ops      uint64 `json:"ops"`
+	Repeated uint64 `json:"repeated"`
+	Same     uint64 `json:"same"`
+}
+
+// Share returns the part of the ops that repeated a computation.
+func (c ReuseCounts) Share() float64 {
+	if c.Ops == 0 {
+		return 0
+	}
+	return float64(c.Repeated) / float64(c.Ops)
+}
+
+// ReuseLoop is the checked ops of one loop, identified as in
+// Result.Loops; Sites is how many of its instructions were checked.
+type ReuseLoop struct {
+	Loop     int    `json:"loop"`
+	Depth    int    `json:"depth"`
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	Offset   string `json:"offset"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Sites    int    `json:"sites"`
+	ReuseCounts
+}
+
+// ReuseSite is the checked executions of one instruction, at Offset in
+// Function, of loop Loop.
+type ReuseSite struct {
+	Loop     int    `json:"loop"`
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	Offset   string `json:"offset"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Op       string `json:"op"`
+	Disasm   string `json:"disasm"`
+	ReuseCounts
+}
+
+// writeReuse renders the repeated-computation totals, the loops
+// repeating most and their ten most repeated instructions.
+func writeReuse(w io.Writer, r *Reuse) {
+	pct := func(n, all uint64) string {
+		p := 0.0
+		if all > 0 {
+			p = 100 * float64(n) / float64(all)
+		}
+		return fmt.Sprintf("%.1f%%", p)
+	}
+	loops := "loops"
+	if r.Loops == 1 {
+		loops = "loop"
+	}
+	fmt.Fprintf(w, "\n----- Repeated computations (loops) -----\n")
+	fmt.Fprintf(w, "Ops:  %d checked in %d %s: %s repeated (%d-entry reuse buffer), %s same as last time\n",
+		r.Ops, r.Loops, loops, pct(r.Repeated, r.Ops), r.Entries, pct(r.Same, r.Ops))
+	fmt.Fprintf(w, "%14s%14s%8s%8s%7s  DEPTH  LOOP\n", "OPS", "REPEATED", "REPEAT%", "SAME%", "SITES")
+	for _, l := range r.Top {
+		fmt.Fprintf(w, "%14d%14d%8s%8s%7d%7d  %s+%s", l.Ops, l.Repeated, pct(l.Repeated, l.Ops),
+			pct(l.Same, l.Ops), l.Sites, l.Depth, l.Function, l.Offset)
+		if l.Line > 0 {
+			fmt.Fprintf(w, "  (%s:%d)", l.File, l.Line)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(r.Sites) == 0 || r.Sites[0].Repeated == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%14s%8s%8s  INSTRUCTION (10 most repeated)\n", "REPEATED", "REPEAT%", "SAME%")
+	for i, s := range r.Sites {
+		if i == 10 || s.Repeated == 0 {
+			break
+		}
+		fmt.Fprintf(w, "%14d%8s%8s  %s %s+%s  %s  (%s:%d)\n", s.Repeated, pct(s.Repeated, s.Ops),
+			pct(s.Same, s.Ops), s.Op, s.Function, s.Offset, s.Disasm, s.File, s.Line)
+	}
+}
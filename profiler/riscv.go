@@ -0,0 +1,243 @@
+package profiler
+
+import "encoding/binary"
+
+// rv64Insns lists the instruction names classifyRV64 reports under each
+// arithmetic category.
+var rv64Insns = map[string][]string{
+	"add": {"add"},
+	"sub": {"sub"},
+	"mul": {"mul", "mulh"},
+	"div": {"div", "rem"},
+}
+
+// decodeRV64 classifies the RV64GC instruction at the start of code,
+// which is 2 bytes long for the C extension and 4 bytes otherwise.
+func decodeRV64(code []byte) (staticOp, int, bool) {
+	if len(code) < 2 {
+		return staticOp{}, len(code), false
+	}
+	h := binary.LittleEndian.Uint16(code)
+	if h&3 != 3 {
+		op, ok := classifyRVC(h)
+		return op, 2, ok
+	}
+	if len(code) < 4 {
+		return staticOp{}, len(code), false
+	}
+	op, ok := classifyRV64(binary.LittleEndian.Uint32(code))
+	return op, 4, ok
+}
+
+// classifyRV64 decodes one 32-bit RV64 instruction. As on the other ISAs
+// only 64-bit register forms count: the *W word forms, immediates,
+// set-less-than, moves (an x0 source) and writes to x0 are left out;
+// shifts by an immediate are counted and XORI rd, rs, -1 counts as NOT.
+// REM and REMU count as divides, the MULH family as multiplies. Zbb
+// rotates count under rol. Vector (V) add/sub/mul count once per
+// instruction because element width and length are set at run time.
+func classifyRV64(w uint32) (staticOp, bool) {
+	rd, f3, rs1, rs2 := w>>7&31, w>>12&7, w>>15&31, w>>20&31
+	f7 := w >> 25
+
+	switch w & 0x7F {
+	case 0x33: // OP
+		if rd == 0 {
+			return staticOp{}, false
+		}
+		switch f7 {
+		case 0x01: // M extension
+			switch f3 {
+			case 0:
+				return staticOp{"mul", "mul", 1}, true
+			case 1, 2, 3:
+				return staticOp{"mul", "mulh", 1}, true
+			case 4, 5:
+				return staticOp{"div", "div", 1}, true
+			case 6, 7:
+				return staticOp{"div", "rem", 1}, true
+			}
+		case 0x00:
+			switch f3 {
+			case 0:
+				if rs1 != 0 && rs2 != 0 {
+					return staticOp{"add", "add", 1}, true
+				}
+			case 1:
+				return staticOp{"shl", "shl", 1}, true
+			case 4:
+				return staticOp{"xor", "xor", 1}, true
+			case 5:
+				return staticOp{"shr", "shr", 1}, true
+			case 6:
+				if rs1 != 0 && rs2 != 0 {
+					return staticOp{"or", "or", 1}, true
+				}
+			case 7:
+				return staticOp{"and", "and", 1}, true
+			}
+		case 0x20:
+			switch f3 {
+			case 0: // SUB; NEG when rs1 is x0
+				if rs1 != 0 {
+					return staticOp{"sub", "sub", 1}, true
+				}
+			case 5:
+				return staticOp{"shr", "shr", 1}, true
+			}
+		case 0x30: // Zbb ROL / ROR
+			if f3 == 1 || f3 == 5 {
+				return staticOp{"rol", "rol", 1}, true
+			}
+		}
+
+	case 0x13: // OP-IMM
+		if rd == 0 {
+			return staticOp{}, false
+		}
+		switch f3 {
+		case 1: // SLLI
+			if w>>26 == 0 {
+				return staticOp{"shl", "shl", 1}, true
+			}
+		case 5:
+			switch w >> 26 {
+			case 0x00, 0x10: // SRLI, SRAI
+				return staticOp{"shr", "shr", 1}, true
+			case 0x18: // Zbb RORI
+				return staticOp{"rol", "rol", 1}, true
+			}
+		case 4: // NOT is XORI rd, rs, -1
+			if w>>20 == 0xFFF {
+				return staticOp{"not", "not", 1}, true
+			}
+		}
+
+	case 0x53: // OP-FP
+		prec, ok := rvFPType(f7 & 3)
+		if !ok {
+			return staticOp{}, false
+		}
+		switch f7 >> 2 {
+		case 0:
+			return staticOp{prec + "_add", "fadd", 1}, true
+		case 1:
+			return staticOp{prec + "_sub", "fsub", 1}, true
+		case 2:
+			return staticOp{prec + "_mul", "fmul", 1}, true
+		case 3:
+			return staticOp{prec + "_div", "fdiv", 1}, true
+		}
+
+	case 0x43, 0x47, 0x4B, 0x4F: // FMADD, FMSUB, FNMSUB, FNMADD
+		if prec, ok := rvFPType(f7 & 3); ok {
+			return staticOp{prec + "_fma", "fmadd", 1}, true
+		}
+
+	case 0x57: // OP-V
+		switch f6 := w >> 26; f3 {
+		case 0, 3, 4: // OPIVV, OPIVI, OPIVX
+			switch f6 {
+			case 0x00:
+				return staticOp{"vec_add", "vadd", 1}, true
+			case 0x02, 0x03: // VSUB, VRSUB
+				return staticOp{"vec_sub", "vsub", 1}, true
+			}
+		case 2, 6: // OPMVV, OPMVX
+			switch f6 {
+			case 0x25, 0x24, 0x26, 0x27: // VMUL, VMULH*
+				return staticOp{"vec_mul", "vmul", 1}, true
+			}
+		}
+	}
+	return staticOp{}, false
+}
+
+// classifyRVC decodes one 16-bit compressed instruction under the same
+// rules; C.ADDW, C.SUBW, C.MV and immediate forms are left out.
+func classifyRVC(h uint16) (staticOp, bool) {
+	f3 := h >> 13
+	shamt := h>>7&0x20 | h>>2&0x1F
+
+	switch h & 3 {
+	case 1:
+		if f3 != 4 {
+			break
+		}
+		switch h >> 10 & 3 {
+		case 0, 1: // C.SRLI, C.SRAI
+			if shamt != 0 {
+				return staticOp{"shr", "shr", 1}, true
+			}
+		case 3:
+			if h>>12&1 == 1 { // C.SUBW, C.ADDW
+				break
+			}
+			switch h >> 5 & 3 {
+			case 0:
+				return staticOp{"sub", "sub", 1}, true
+			case 1:
+				return staticOp{"xor", "xor", 1}, true
+			case 2:
+				return staticOp{"or", "or", 1}, true
+			case 3:
+				return staticOp{"and", "and", 1}, true
+			}
+		}
+
+	case 2:
+		rd, rs2 := h>>7&31, h>>2&31
+		switch {
+		case f3 == 0 && rd != 0 && shamt != 0: // C.SLLI
+			return staticOp{"shl", "shl", 1}, true
+		case f3 == 4 && h>>12&1 == 1 && rd != 0 && rs2 != 0: // C.ADD
+			return staticOp{"add", "add", 1}, true
+		}
+	}
+	return staticOp{}, false
+}
+
+// rvFPType returns the precision of an FP instruction's fmt field.
+func rvFPType(fmt uint32) (string, bool) {
+	switch fmt {
+	case 0:
+		return "fp32", true
+	case 1:
+		return "fp64", true
+	}
+	return "", false
+}
+
+// branchRV64 returns the target of the RV64GC JAL x0, conditional
+// branch, C.J, C.BEQZ or C.BNEZ at the start of code, at addr.
+func branchRV64(code []byte, addr uint64) (uint64, bool) {
+	if len(code) < 2 {
+		return 0, false
+	}
+	h := binary.LittleEndian.Uint16(code)
+	var off int64
+	switch {
+	case h&3 == 1 && h>>13 == 5: // C.J
+		v := int64(h>>12&1)<<11 | int64(h>>11&1)<<4 | int64(h>>9&3)<<8 | int64(h>>8&1)<<10 |
+			int64(h>>7&1)<<6 | int64(h>>6&1)<<7 | int64(h>>3&7)<<1 | int64(h>>2&1)<<5
+		off = v << 52 >> 52
+	case h&3 == 1 && h>>13 >= 6: // C.BEQZ, C.BNEZ
+		v := int64(h>>12&1)<<8 | int64(h>>10&3)<<3 | int64(h>>5&3)<<6 | int64(h>>3&3)<<1 | int64(h>>2&1)<<5
+		off = v << 55 >> 55
+	case h&3 != 3 || len(code) < 4:
+		return 0, false
+	default:
+		w := binary.LittleEndian.Uint32(code)
+		switch {
+		case w&0x7F == 0x6F && w>>7&31 == 0: // JAL x0
+			v := int64(w>>31)<<20 | int64(w>>21&0x3FF)<<1 | int64(w>>20&1)<<11 | int64(w>>12&0xFF)<<12
+			off = v << 43 >> 43
+		case w&0x7F == 0x63: // BEQ … BGEU
+			v := int64(w>>31)<<12 | int64(w>>25&0x3F)<<5 | int64(w>>8&0xF)<<1 | int64(w>>7&1)<<11
+			off = v << 51 >> 51
+		default:
+			return 0, false
+		}
+	}
+	return addr + uint64(off), true
+}
@@ -0,0 +1,376 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Machine holds the peak rates a roofline is drawn against, per second.
+// A zero peak leaves that roof, and its points, out.
+type Machine struct {
+	PeakInt   float64 `json:"peak_int_ops"`          // integer ops/s
+	PeakFP    float64 `json:"peak_fp_ops,omitempty"` // FP lane ops/s
+	Bandwidth float64 `json:"bandwidth"`             // bytes/s
+}
+
+// Attainable returns the fastest rate ops of the given intensity (ops per
+// byte) can run at under peak: min(peak, bandwidth × intensity).
+func (m Machine) Attainable(peak, intensity float64) float64 {
+	return math.Min(peak, m.Bandwidth*intensity)
+}
+
+// RooflinePoint is one kernel placed on the roofline. Kind is "int"
+// (add..div plus vector lanes, against PeakInt) or "fp" (FP lane ops,
+// against PeakFP).
+type RooflinePoint struct {
+	Name       string  `json:"name"`
+	Kind       string  `json:"kind"`
+	Ops        uint64  `json:"ops"`
+	Bytes      uint64  `json:"bytes"`
+	Intensity  float64 `json:"intensity"`          // ops per byte
+	Attainable float64 `json:"attainable"`         // ops/s allowed by the roof
+	Achieved   float64 `json:"achieved,omitempty"` // ops/s, only with a measured run time
+	Bound      string  `json:"bound"`              // "memory" or "compute"
+}
+
+// rate is where the point is drawn: its achieved rate when known, else
+// its place on the roof.
+func (p RooflinePoint) rate() float64 {
+	if p.Achieved > 0 {
+		return p.Achieved
+	}
+	return p.Attainable
+}
+
+// Roofline is a report's kernels placed against a machine.
+type Roofline struct {
+	Title   string          `json:"title"`
+	Machine Machine         `json:"machine"`
+	Points  []RooflinePoint `json:"points"`
+}
+
+// ProgramKernel names the whole-program point of a roofline.
+const ProgramKernel = "(program)"
+
+// Roofline places r, recorded with Options.Mem, against m. The whole
+// program comes first, then up to top functions by ops (all with top <=
+// 0); kernels that did no ops or moved no bytes are left out. With
+// Options.Cache the bytes are the simulated DRAM traffic. FP points
+// need Options.FP and m.PeakFP. Per-function times are not known, so
+// functions sit on the roof at their intensity; seconds > 0 is a native
+// run time of the program, which gives the program point its achieved
+// rate (instrumented wall time is far too slow to be useful).
+func (r *Result) Roofline(m Machine, top int, seconds float64) (*Roofline, error) {
+	if r.Memory == nil {
+		return nil, errors.New("profiler: report has no memory counts (record with --mem)")
+	}
+	if m.Bandwidth <= 0 || m.PeakInt <= 0 && m.PeakFP <= 0 {
+		return nil, errors.New("profiler: roofline needs a bandwidth and at least one peak rate")
+	}
+	rl := &Roofline{Title: filepath.Base(r.Binary.Path), Machine: m}
+
+	type kernel struct {
+		name          string
+		intOps, fpOps uint64
+		mem           *Memory
+	}
+	prog := kernel{name: ProgramKernel, intOps: r.Totals.Sum() + vecSum(r.Vector), mem: r.Memory}
+	if r.FP != nil {
+		prog.fpOps = r.FP.FP64.Sum() + r.FP.FP32.Sum()
+	}
+	var funcs []kernel
+	for _, f := range r.Functions {
+		if f.Memory != nil && r.memoryBytes(f.Memory) > 0 {
+			funcs = append(funcs, kernel{name: f.Name, intOps: f.Sum() + vecSum(f.Vector),
+				fpOps: fpSum(f.FP64) + fpSum(f.FP32), mem: f.Memory})
+		}
+	}
+	sort.SliceStable(funcs, func(i, j int) bool {
+		return funcs[i].intOps+funcs[i].fpOps > funcs[j].intOps+funcs[j].fpOps
+	})
+	if top > 0 && len(funcs) > top {
+		funcs = funcs[:top]
+	}
+
+	for i, k := range append([]kernel{prog}, funcs...) {
+		for _, kind := range []struct {
+			name string
+			ops  uint64
+			peak float64
+		}{{"int", k.intOps, m.PeakInt}, {"fp", k.fpOps, m.PeakFP}} {
+			bytes := r.memoryBytes(k.mem)
+			if kind.peak <= 0 || kind.ops == 0 || bytes == 0 || kind.name == "fp" && r.FP == nil {
+				continue
+			}
+			p := RooflinePoint{Name: k.name, Kind: kind.name, Ops: kind.ops, Bytes: bytes,
+				Intensity: float64(kind.ops) / float64(bytes), Bound: "compute"}
+			p.Attainable = m.Attainable(kind.peak, p.Intensity)
+			if p.Intensity < kind.peak/m.Bandwidth {
+				p.Bound = "memory"
+			}
+			if i == 0 && seconds > 0 {
+				p.Achieved = float64(kind.ops) / seconds
+			}
+			rl.Points = append(rl.Points, p)
+		}
+	}
+	if len(rl.Points) == 0 {
+		return nil, errors.New("profiler: no kernel both computed and moved bytes")
+	}
+	return rl, nil
+}
+
+// roof is one ceiling of the plot.
+type roof struct {
+	kind, label string
+	peak        float64
+}
+
+func (rl *Roofline) roofs() []roof {
+	var rs []roof
+	if p := rl.Machine.PeakInt; p > 0 {
+		rs = append(rs, roof{"int", "INT peak " + siRate(p, "op/s"), p})
+	}
+	if p := rl.Machine.PeakFP; p > 0 {
+		rs = append(rs, roof{"fp", "FP peak " + siRate(p, "op/s"), p})
+	}
+	return rs
+}
+
+// axes returns the decade-aligned plot range: log10 of the smallest and
+// largest intensity and rate.
+func (rl *Roofline) axes() (x0, x1, y0, y1 float64) {
+	xlo, xhi := math.Inf(1), math.Inf(-1)
+	ylo, yhi := math.Inf(1), math.Inf(-1)
+	for _, p := range rl.Points {
+		xlo, xhi = math.Min(xlo, p.Intensity), math.Max(xhi, p.Intensity)
+		ylo, yhi = math.Min(ylo, p.rate()), math.Max(yhi, p.rate())
+	}
+	for _, r := range rl.roofs() {
+		ridge := r.peak / rl.Machine.Bandwidth
+		xlo, xhi = math.Min(xlo, ridge), math.Max(xhi, ridge)
+		yhi = math.Max(yhi, r.peak)
+	}
+	x0, x1 = math.Floor(math.Log10(xlo/2)), math.Ceil(math.Log10(xhi*2))
+	ylo = math.Min(ylo, rl.Machine.Bandwidth*math.Pow(10, x0))
+	y0, y1 = math.Floor(math.Log10(ylo)), math.Ceil(math.Log10(yhi*1.5))
+	return x0, x1, y0, y1
+}
+
+// roofLine returns the corners of r's ceiling across [10^x0, 10^x1].
+func (rl *Roofline) roofLine(r roof, x0, x1 float64) [][2]float64 {
+	lo, hi := math.Pow(10, x0), math.Pow(10, x1)
+	ridge := r.peak / rl.Machine.Bandwidth
+	return [][2]float64{{lo, rl.Machine.Attainable(r.peak, lo)}, {ridge, r.peak}, {hi, r.peak}}
+}
+
+// siRate formats v with an SI prefix, e.g. "25.6 GB/s".
+func siRate(v float64, unit string) string {
+	prefixes := []string{"", "K", "M", "G", "T", "P", "E"}
+	i := 0
+	for v >= 1000 && i < len(prefixes)-1 {
+		v /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g %s%s", v, prefixes[i], unit)
+}
+
+// shortName truncates long (C++) kernel names for plot labels.
+func shortName(s string) string {
+	if r := []rune(s); len(r) > 40 {
+		return string(r[:39]) + "…"
+	}
+	return s
+}
+
+// WriteText lists the kernels with their intensity, attainable rate and
+// bound.
+func (rl *Roofline) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Roofline of %s: bandwidth %s", rl.Title, siRate(rl.Machine.Bandwidth, "B/s"))
+	for _, r := range rl.roofs() {
+		fmt.Fprintf(bw, ", %s (ridge %.3g ops/byte)", r.label, r.peak/rl.Machine.Bandwidth)
+	}
+	fmt.Fprintf(bw, "\n%-5s%16s%16s%12s%16s%16s%9s  KERNEL\n",
+		"KIND", "OPS", "BYTES", "OPS/BYTE", "ATTAINABLE", "ACHIEVED", "BOUND")
+	for _, p := range rl.Points {
+		achieved := "-"
+		if p.Achieved > 0 {
+			achieved = siRate(p.Achieved, "op/s")
+		}
+		fmt.Fprintf(bw, "%-5s%16d%16d%12.4f%16s%16s%9s  %s\n", strings.ToUpper(p.Kind), p.Ops, p.Bytes,
+			p.Intensity, siRate(p.Attainable, "op/s"), achieved, p.Bound, p.Name)
+	}
+	return bw.Flush()
+}
+
+var rooflineColors = map[string]string{"int": "#1f77b4", "fp": "#d62728"}
+
+// WriteSVG draws the roofline as a log-log SVG image: one ceiling per
+// peak and one dot per kernel, labelled with its name.
+func (rl *Roofline) WriteSVG(w io.Writer) error {
+	const (
+		width, height            = 960, 600
+		left, right, top, bottom = 90, 40, 50, 60
+		pw, ph                   = width - left - right, height - top - bottom
+	)
+	x0, x1, y0, y1 := rl.axes()
+	sx := func(x float64) float64 { return left + (math.Log10(x)-x0)/(x1-x0)*pw }
+	sy := func(y float64) float64 { return top + ph - (math.Log10(y)-y0)/(y1-y0)*ph }
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n", width, height)
+	fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	fmt.Fprintf(bw, "<text x=\"%d\" y=\"30\" font-size=\"16\">Roofline: %s</text>\n", left, html.EscapeString(rl.Title))
+	for e := x0; e <= x1; e++ {
+		x := sx(math.Pow(10, e))
+		fmt.Fprintf(bw, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%d\" stroke=\"#ddd\"/>\n", x, top, x, top+ph)
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%g</text>\n", x, top+ph+18, math.Pow(10, e))
+	}
+	for e := y0; e <= y1; e++ {
+		y := sy(math.Pow(10, e))
+		fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", left, y, left+pw, y)
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", left-6, y+4, siRate(math.Pow(10, e), ""))
+	}
+	fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"black\"/>\n", left, top, pw, ph)
+	fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">arithmetic intensity (ops/byte)</text>\n", left+pw/2, height-15)
+	fmt.Fprintf(bw, "<text transform=\"translate(20,%d) rotate(-90)\" text-anchor=\"middle\">ops/s</text>\n", top+ph/2)
+
+	for i, r := range rl.roofs() {
+		pts := rl.roofLine(r, x0, x1)
+		fmt.Fprintf(bw, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"", rooflineColors[r.kind])
+		for _, p := range pts {
+			fmt.Fprintf(bw, "%.1f,%.1f ", sx(p[0]), sy(p[1]))
+		}
+		fmt.Fprintf(bw, "\"/>\n")
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\" fill=\"%s\">%s</text>\n",
+			left+pw-6, sy(r.peak)-6, rooflineColors[r.kind], html.EscapeString(r.label))
+		if i == 0 {
+			fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\">%s</text>\n", sx(pts[0][0])+8, sy(pts[0][1])-8,
+				siRate(rl.Machine.Bandwidth, "B/s"))
+		}
+	}
+	for _, p := range rl.Points {
+		x, y := sx(p.Intensity), sy(p.rate())
+		weight := "normal"
+		if p.Name == ProgramKernel {
+			weight = "bold"
+		}
+		fmt.Fprintf(bw, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"4\" fill=\"%s\"><title>%s (%s): %.4f ops/byte, %s bound</title></circle>\n",
+			x, y, rooflineColors[p.Kind], html.EscapeString(p.Name), p.Kind, p.Intensity, p.Bound)
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%.1f\" font-size=\"10\" font-weight=\"%s\">%s</text>\n",
+			x+6, y-6, weight, html.EscapeString(shortName(p.Name)))
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// WriteVegaLite writes a Vega-Lite v5 spec of the roofline with the data
+// inlined.
+func (rl *Roofline) WriteVegaLite(w io.Writer) error {
+	x0, x1, y0, y1 := rl.axes()
+	var roofs []map[string]any
+	for _, r := range rl.roofs() {
+		for i, p := range rl.roofLine(r, x0, x1) {
+			roofs = append(roofs, map[string]any{"roof": r.label, "order": i, "intensity": p[0], "rate": p[1]})
+		}
+	}
+	var points []map[string]any
+	for _, p := range rl.Points {
+		points = append(points, map[string]any{"name": p.Name, "label": shortName(p.Name), "kind": p.Kind,
+			"ops": p.Ops, "bytes": p.Bytes, "intensity": p.Intensity, "rate": p.rate(), "bound": p.Bound})
+	}
+	x := map[string]any{"field": "intensity", "type": "quantitative", "title": "arithmetic intensity (ops/byte)",
+		"scale": map[string]any{"type": "log", "domain": []float64{math.Pow(10, x0), math.Pow(10, x1)}}}
+	y := map[string]any{"field": "rate", "type": "quantitative", "title": "ops/s",
+		"scale": map[string]any{"type": "log", "domain": []float64{math.Pow(10, y0), math.Pow(10, y1)}}}
+	spec := map[string]any{
+		"$schema": "https://vega.github.io/schema/vega-lite/v5.json",
+		"title":   "Roofline: " + rl.Title,
+		"width":   800,
+		"height":  500,
+		"layer": []any{
+			map[string]any{
+				"data": map[string]any{"values": roofs},
+				"mark": map[string]any{"type": "line", "strokeWidth": 2},
+				"encoding": map[string]any{"x": x, "y": y, "order": map[string]any{"field": "order"},
+					"color": map[string]any{"field": "roof", "type": "nominal", "title": "ceiling"}},
+			},
+			map[string]any{
+				"data": map[string]any{"values": points},
+				"mark": map[string]any{"type": "point", "filled": true, "size": 60},
+				"encoding": map[string]any{"x": x, "y": y,
+					"shape": map[string]any{"field": "kind", "type": "nominal"},
+					"tooltip": []any{
+						map[string]any{"field": "name"}, map[string]any{"field": "kind"},
+						map[string]any{"field": "ops", "type": "quantitative"},
+						map[string]any{"field": "bytes", "type": "quantitative"},
+						map[string]any{"field": "intensity", "type": "quantitative", "format": ".4f"},
+						map[string]any{"field": "bound"},
+					}},
+			},
+			map[string]any{
+				"data":     map[string]any{"values": points},
+				"mark":     map[string]any{"type": "text", "align": "left", "dx": 6, "dy": -6, "fontSize": 10},
+				"encoding": map[string]any{"x": x, "y": y, "text": map[string]any{"field": "label"}},
+			},
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(spec)
+}
+
+// WriteGnuplot writes a gnuplot script that renders the roofline as a
+// PNG on its standard output.
+func (rl *Roofline) WriteGnuplot(w io.Writer) error {
+	x0, x1, y0, y1 := rl.axes()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Roofline of %s; render with: gnuplot script > roofline.png\n", rl.Title)
+	fmt.Fprintf(bw, "set terminal pngcairo size 960,600\nset logscale xy\nset grid\nset key top left\n")
+	fmt.Fprintf(bw, "set title %s\n", gnuplotStr("Roofline: "+rl.Title))
+	fmt.Fprintf(bw, "set xlabel \"arithmetic intensity (ops/byte)\"\nset ylabel \"ops/s\"\n")
+	fmt.Fprintf(bw, "set xrange [%g:%g]\nset yrange [%g:%g]\n", math.Pow(10, x0), math.Pow(10, x1), math.Pow(10, y0), math.Pow(10, y1))
+
+	var plots []string
+	for _, r := range rl.roofs() {
+		fmt.Fprintf(bw, "$roof_%s << EOD\n", r.kind)
+		for _, p := range rl.roofLine(r, x0, x1) {
+			fmt.Fprintf(bw, "%g %g\n", p[0], p[1])
+		}
+		fmt.Fprintf(bw, "EOD\n")
+		plots = append(plots, fmt.Sprintf("$roof_%s with lines lw 2 lc rgb %q title %s",
+			r.kind, rooflineColors[r.kind], gnuplotStr(r.label)))
+	}
+	for _, kind := range []string{"int", "fp"} {
+		var rows []string
+		for _, p := range rl.Points {
+			if p.Kind == kind {
+				rows = append(rows, fmt.Sprintf("%s %.6g %.6g", gnuplotStr(shortName(p.Name)), p.Intensity, p.rate()))
+			}
+		}
+		if rows == nil {
+			continue
+		}
+		fmt.Fprintf(bw, "$points_%s << EOD\n%s\nEOD\n", kind, strings.Join(rows, "\n"))
+		plots = append(plots,
+			fmt.Sprintf("$points_%s using 2:3 with points pt 7 lc rgb %q title \"%s kernels\"",
+				kind, rooflineColors[kind], strings.ToUpper(kind)),
+			fmt.Sprintf("$points_%s using 2:3:1 with labels left offset 1,0.5 font \",8\" notitle", kind))
+	}
+	fmt.Fprintf(bw, "plot %s\n", strings.Join(plots, ", \\\n     "))
+	return bw.Flush()
+}
+
+// gnuplotStr quotes s as a gnuplot string.
+func gnuplotStr(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,123 @@
+package profiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Signedness splits the multiplies and divides into signed and unsigned
+// ones (Options.Signedness), which the hardware runs on different units
+// with different latencies. Each is classed by its instruction: x86 IMUL
+// and IDIV are signed, MUL, MULX and DIV unsigned; A64 SMULL, SMADDL,
+// SMULH and SDIV are signed and their U forms unsigned; RISC-V MULH,
+// MULHSU, DIV and REM are signed, MULHU, DIVU and REMU unsigned, as are
+// WebAssembly's _s and _u forms. The low-half multiplies of A64 (MUL,
+// MADD), RISC-V (MUL) and WebAssembly (i64.mul) give the same result
+// either way and are Either. The three sum to the category's total.
+type Signedness struct {
+	Mul SignCounts `json:"mul"`
+	Div SignCounts `json:"div"`
+}
+
+// SignCounts is one category's operations by signedness.
+type SignCounts struct {
+	Signed   uint64 `json:"signed"`
+	Unsigned uint64 `json:"unsigned"`
+	Either   uint64 `json:"either,omitempty"`
+}
+
+// Signedness classes of an instruction.
+type signKind int
+
+const (
+	signEither signKind = iota
+	signSigned
+	signUnsigned
+)
+
+// add counts n operations of kind k.
+func (c *SignCounts) add(k signKind, n uint64) {
+	switch k {
+	case signSigned:
+		c.Signed += n
+	case signUnsigned:
+		c.Unsigned += n
+	default:
+		c.Either += n
+	}
+}
+
+// signA64 classes the A64 multiply or divide insn.
+func signA64(insn []byte) signKind {
+	if len(insn) < 4 {
+		return signEither
+	}
+	w := binary.LittleEndian.Uint32(insn)
+	switch {
+	case w&0x7F000000 == 0x1B000000: // data-processing (3 source)
+		switch w >> 21 & 7 {
+		case 1, 2: // SMADDL / SMSUBL, SMULH
+			return signSigned
+		case 5, 6: // UMADDL / UMSUBL, UMULH
+			return signUnsigned
+		}
+	case w&0x7FE00000 == 0x1AC00000: // data-processing (2 source)
+		switch w >> 10 & 63 {
+		case 2:
+			return signUnsigned
+		case 3:
+			return signSigned
+		}
+	}
+	return signEither
+}
+
+// signRV64 classes the RV64 multiply or divide insn.
+func signRV64(insn []byte) signKind {
+	if len(insn) < 4 {
+		return signEither
+	}
+	switch binary.LittleEndian.Uint32(insn) >> 12 & 7 {
+	case 1, 2, 4, 6: // MULH, MULHSU, DIV, REM
+		return signSigned
+	case 3, 5, 7: // MULHU, DIVU, REMU
+		return signUnsigned
+	}
+	return signEither
+}
+
+// signWasm classes the WebAssembly multiply or divide insn.
+func signWasm(insn []byte) signKind {
+	if len(insn) == 0 {
+		return signEither
+	}
+	switch insn[0] {
+	case 0x7f, 0x81: // i64.div_s, i64.rem_s
+		return signSigned
+	case 0x80, 0x82: // i64.div_u, i64.rem_u
+		return signUnsigned
+	}
+	return signEither
+}
+
+// writeSignedness renders s; either adds the column of the ISAs with
+// sign-agnostic multiplies.
+func writeSignedness(w io.Writer, s *Signedness, either bool) {
+	fmt.Fprintf(w, "\n----- Signed vs unsigned -----\n")
+	fmt.Fprintf(w, "%6s%14s%14s", "", "SIGNED", "UNSIGNED")
+	if either {
+		fmt.Fprintf(w, "%14s", "EITHER")
+	}
+	fmt.Fprintln(w)
+	for _, k := range []struct {
+		name string
+		c    SignCounts
+	}{{"MUL", s.Mul}, {"DIV", s.Div}} {
+		fmt.Fprintf(w, "%6s%14d%14d", k.name, k.c.Signed, k.c.Unsigned)
+		if either {
+			fmt.Fprintf(w, "%14d", k.c.Either)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,574 @@
+package profiler
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// staticOp is a classified machine instruction. Category is a report
+// category ("add", "shl", "vec_add", "fp64_fma", …) and Insn the
+// instruction name under it in Result.Categories; Lanes is the lane count
+// of vector ops.
+type staticOp struct {
+	category string
+	insn     string
+	lanes    uint64
+}
+
+// staticArch is an ISA the static backend decodes. Decode classifies the
+// instruction at the start of code and returns its length in bytes;
+// branch returns the target of a direct jump or conditional branch, not a
+// call, at addr.
+type staticArch struct {
+	name   string
+	insns  map[string][]string // instruction names per arithmetic category
+	decode func(code []byte) (op staticOp, size int, ok bool)
+	sign   func(insn []byte) signKind // of a multiply or divide
+	atomic func(insn []byte) atomicKind
+	branch func(code []byte, addr uint64) (target uint64, ok bool)
+	mem    func(insn []byte) bool // a register–memory form; nil for load/store ISAs
+}
+
+var staticArchs = map[elf.Machine]staticArch{
+	elf.EM_X86_64:  {"amd64", x86Insns, decodeX86, signX86, atomicX86, branchX86, memX86},
+	elf.EM_AARCH64: {"arm64", a64Insns, decodeA64, signA64, atomicA64, branchA64, nil},
+	elf.EM_RISCV:   {"riscv64", rv64Insns, decodeRV64, signRV64, atomicRV64, branchRV64, nil},
+}
+
+// undecodable reports whether arch's decode of code, which returned size
+// and ok, failed on bytes that are no instruction it knows rather than on
+// an instruction it does not count. Only the x86-64 decoder tells the two
+// apart.
+func undecodable(arch staticArch, code []byte, size int, ok bool) bool {
+	if ok || size != 1 || arch.name != "amd64" {
+		return false
+	}
+	_, known := decodeX86Insn(code)
+	return !known
+}
+
+// staticScope accumulates the counts of the whole binary or of one
+// function.
+type staticScope struct {
+	counts Counts
+	vec    Vector
+	fp64   FPOps
+	fp32   FPOps
+	sign   Signedness
+	atom   Atomics
+	custom []uint64 // per staticTally class
+}
+
+// add counts n occurrences of op.
+func (s *staticScope) add(op staticOp, n uint64) {
+	lanes := op.lanes * n
+	if k, ok := strings.CutPrefix(op.category, "vec_"); ok {
+		switch k {
+		case "add":
+			s.vec.Add += lanes
+		case "sub":
+			s.vec.Sub += lanes
+		case "mul":
+			s.vec.Mul += lanes
+		}
+		return
+	}
+	if prec, k, ok := strings.Cut(op.category, "_"); ok {
+		f := &s.fp32
+		if prec == "fp64" {
+			f = &s.fp64
+		}
+		switch k {
+		case "add":
+			f.Add += lanes
+		case "sub":
+			f.Sub += lanes
+		case "mul":
+			f.Mul += lanes
+		case "div":
+			f.Div += lanes
+		case "fma":
+			f.FMA += lanes
+		}
+		return
+	}
+	*s.counts.field(op.category) += n
+}
+
+// field returns a pointer to the count for category name.
+func (c *Counts) field(name string) *uint64 {
+	switch name {
+	case "add":
+		return &c.Add
+	case "sub":
+		return &c.Sub
+	case "mul":
+		return &c.Mul
+	case "div":
+		return &c.Div
+	case "shl":
+		return &c.Shl
+	case "shr":
+		return &c.Shr
+	case "rol":
+		return &c.Rol
+	case "and":
+		return &c.And
+	case "or":
+		return &c.Or
+	case "xor":
+		return &c.Xor
+	case "not":
+		return &c.Not
+	}
+	panic("profiler: unknown category " + name)
+}
+
+// staticFunc is a function symbol of the decoded binary.
+type staticFunc struct {
+	name       string
+	start, end uint64
+}
+
+// codeSection is a section of machine code at its link-time address.
+type codeSection struct {
+	addr uint64
+	code []byte
+}
+
+// staticImage returns the ISA, code sections and function symbols of the
+// binary at path, in address order, and the debug info in dirs the
+// symbols of a stripped ELF file were read from.
+func staticImage(path string, dirs []string) (staticArch, []codeSection, []staticFunc, DebugFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		if mf, c, merr := openMachO(path, macho.CpuArm64); merr == nil {
+			defer c.Close()
+			arch, secs, funcs, err := machoImage(mf, path)
+			return arch, secs, funcs, DebugFile{}, err
+		}
+		if errors.Is(err, fs.ErrNotExist) && runtime.GOOS == "darwin" {
+			// macOS 11 and later keep system libraries only in the cache
+			cache, cerr := openDyldCache()
+			if cerr == nil {
+				defer cache.close()
+				secs, funcs, cerr := cache.image(path)
+				if cerr == nil {
+					return staticArchs[elf.EM_AARCH64], secs, funcs, DebugFile{}, nil
+				}
+			}
+		}
+		return staticArch{}, nil, nil, DebugFile{}, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	arch, ok := staticArchs[f.Machine]
+	if !ok || f.Class != elf.ELFCLASS64 {
+		return staticArch{}, nil, nil, DebugFile{}, fmt.Errorf("%w: static backend decodes x86-64, aarch64 and riscv64 binaries, %s is %v",
+			ErrUnsupported, path, f.Machine)
+	}
+
+	var secs []codeSection
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		code, err := sec.Data()
+		if err != nil {
+			return staticArch{}, nil, nil, DebugFile{}, fmt.Errorf("profiler: %s: %w", sec.Name, err)
+		}
+		secs = append(secs, codeSection{sec.Addr, code})
+	}
+
+	sf, debug := withDebug(f, path, "", dirs)
+	if sf != f {
+		defer sf.Close()
+	}
+	syms, _ := sf.Symbols()
+	if len(syms) == 0 {
+		syms, _ = f.DynamicSymbols()
+	}
+	var funcs []staticFunc
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Value != 0 && s.Size != 0 {
+			funcs = append(funcs, staticFunc{s.Name, s.Value, s.Value + s.Size})
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].start < funcs[j].start })
+	return arch, secs, funcs, debug, nil
+}
+
+// machoImage is staticImage for the arm64 Mach-O file f.
+func machoImage(f *macho.File, path string) (staticArch, []codeSection, []staticFunc, error) {
+	if f.Cpu != macho.CpuArm64 {
+		return staticArch{}, nil, nil, fmt.Errorf("%w: static backend decodes arm64 Mach-O binaries, %s is %v",
+			ErrUnsupported, path, f.Cpu)
+	}
+	var secs []codeSection
+	for _, sec := range f.Sections {
+		if sec.Flags&machoPureInstructions == 0 {
+			continue
+		}
+		code, err := sec.Data()
+		if err != nil {
+			return staticArch{}, nil, nil, fmt.Errorf("profiler: %s: %w", sec.Name, err)
+		}
+		secs = append(secs, codeSection{sec.Addr, code})
+	}
+	return staticArchs[elf.EM_AARCH64], secs, machoFuncs(f), nil
+}
+
+// staticTally classifies decoded instructions into a Result for the static
+// and QEMU backends, keeping the categories the options select.
+type staticTally struct {
+	arch    staticArch
+	res     *Result
+	opts    *Options
+	ops     map[string]bool
+	classes []Classifier
+	total   staticScope
+	funcs   []staticScope // indexed by the ids addFunc returns
+	names   []string
+	images  []string      // by function when set, else finish's image
+	loops   []staticScope // Options.Loops, by staticLoop
+
+	// Set by runStatic for each instruction it counts: whether it is a
+	// register–memory form and the loops it is in.
+	rm bool
+	in []int
+}
+
+func (p *Profiler) newStaticTally(arch staticArch, res *Result) *staticTally {
+	t := &staticTally{arch: arch, res: res, opts: &p.opts, ops: map[string]bool{}, classes: p.classes}
+	res.Compound = &Compound{Policy: p.opts.compoundPolicy()}
+	t.total.custom = make([]uint64, len(t.classes))
+	for _, c := range p.opts.Ops {
+		if c == "bitwise" {
+			for _, b := range BitCategoryNames {
+				t.ops[b] = true
+			}
+		}
+		t.ops[c] = true
+	}
+	for _, c := range BitCategoryNames {
+		if t.ops[c] {
+			res.Categories[c] = map[string]Variant{c: {}}
+		}
+	}
+	return t
+}
+
+// addFunc registers a function and returns its id for count. Functions
+// with equal counts are reported in the order they were added.
+func (t *staticTally) addFunc(name string) int {
+	t.funcs = append(t.funcs, staticScope{custom: make([]uint64, len(t.classes))})
+	t.names = append(t.names, name)
+	return len(t.funcs) - 1
+}
+
+// counted reports whether the options count op's category.
+func (t *staticTally) counted(op staticOp) bool {
+	switch {
+	case strings.HasPrefix(op.category, "vec_"):
+		return t.opts.Vec
+	case strings.HasPrefix(op.category, "fp"):
+		return t.opts.FP
+	}
+	_, arith := t.arch.insns[op.category]
+	return arith || t.ops[op.category]
+}
+
+// count adds n occurrences of op, in function fn unless fn is negative.
+func (t *staticTally) count(op staticOp, fn int, n uint64) {
+	if !t.counted(op) {
+		return
+	}
+	if split := t.res.Compound.tally(op, n); split != nil && t.opts.Compound == CompoundSplit {
+		for _, c := range split {
+			if t.counted(c) {
+				t.record(c, fn, n)
+			}
+		}
+		return
+	}
+	if isLEA(op) { // address arithmetic unless split
+		return
+	}
+	t.record(op, fn, n)
+}
+
+// record adds n occurrences of the counted op.
+func (t *staticTally) record(op staticOp, fn int, n uint64) {
+	t.total.add(op, n)
+	if !strings.Contains(op.category, "_") {
+		cat := t.res.Categories[op.category]
+		if cat == nil {
+			cat = map[string]Variant{}
+			t.res.Categories[op.category] = cat
+		}
+		v := cat[op.insn]
+		if t.rm {
+			v.RM += n
+		} else {
+			v.RR += n
+		}
+		cat[op.insn] = v
+	}
+	if fn >= 0 {
+		t.funcs[fn].add(op, n)
+	}
+	for _, l := range t.in {
+		t.loops[l].add(op, n)
+	}
+}
+
+// signed adds n occurrences of op, decoded from insn, to the Signedness
+// of function fn and the total when op is a multiply or divide.
+func (t *staticTally) signed(insn []byte, op staticOp, fn int, n uint64) {
+	if !t.opts.Signedness || op.category != "mul" && op.category != "div" {
+		return
+	}
+	k := t.arch.sign(insn)
+	scopes := []*staticScope{&t.total}
+	if fn >= 0 {
+		scopes = append(scopes, &t.funcs[fn])
+	}
+	for _, s := range scopes {
+		c := &s.sign.Mul
+		if op.category == "div" {
+			c = &s.sign.Div
+		}
+		c.add(k, n)
+	}
+}
+
+// atomic adds n occurrences of insn to the Atomics of function fn and
+// the total when it is an atomic RMW or a store-exclusive.
+func (t *staticTally) atomic(insn []byte, fn int, n uint64) {
+	if !t.opts.Atomics {
+		return
+	}
+	k := t.arch.atomic(insn)
+	t.total.atom.add(k, n)
+	if fn >= 0 {
+		t.funcs[fn].atom.add(k, n)
+	}
+}
+
+// classify adds n occurrences of insn, an instruction whatever its
+// built-in category, to the custom classes that match it.
+func (t *staticTally) classify(insn []byte, fn int, n uint64) {
+	for k, c := range t.classes {
+		if c.Match(t.arch.name, insn) {
+			t.total.custom[k] += n
+			if fn >= 0 {
+				t.funcs[fn].custom[k] += n
+			}
+		}
+	}
+}
+
+// custom returns a scope's class counts for a report.
+func (t *staticTally) custom(s *staticScope) Custom {
+	if len(t.classes) == 0 {
+		return nil
+	}
+	c := Custom{}
+	for k, cl := range t.classes {
+		c[cl.Name] = s.custom[k]
+	}
+	return c
+}
+
+// finish fills in the totals, categories and, with Options.Funcs, the
+// functions of image, busiest first.
+func (t *staticTally) finish(image string) {
+	res := t.res
+	res.Totals = t.total.counts
+	res.Custom = t.custom(&t.total)
+	for cat, insns := range t.arch.insns {
+		if res.Categories[cat] == nil {
+			res.Categories[cat] = map[string]Variant{}
+		}
+		for _, in := range insns {
+			res.Categories[cat][in] = res.Categories[cat][in]
+		}
+	}
+	if t.opts.Vec {
+		res.Vector = &t.total.vec
+	}
+	if t.opts.Signedness {
+		res.Signedness = &t.total.sign
+	}
+	if t.opts.Atomics {
+		res.Atomics = &t.total.atom
+	}
+	if t.opts.FP {
+		res.FP = &FP{FP64: t.total.fp64, FP32: t.total.fp32}
+		if n := t.total.fp64.Sum() + t.total.fp32.Sum(); n > 0 {
+			res.FP.IntFPRatio = float64(t.total.counts.Sum()) / float64(n)
+		}
+	}
+	if t.opts.Funcs {
+		for i, name := range t.names {
+			s := &t.funcs[i]
+			var custom uint64
+			for _, n := range s.custom {
+				custom += n
+			}
+			if s.counts.Sum()+s.counts.BitSum()+s.vec.Sum()+s.fp64.Sum()+s.fp32.Sum()+custom+s.atom.Sum() == 0 {
+				continue
+			}
+			row := Function{Name: name, Image: image, Counts: s.counts, Custom: t.custom(s)}
+			if t.images != nil {
+				row.Image = t.images[i]
+			}
+			if t.opts.Vec {
+				row.Vector = &s.vec
+			}
+			if t.opts.FP {
+				row.FP64, row.FP32 = &s.fp64, &s.fp32
+			}
+			if t.opts.Signedness {
+				row.Signedness = &s.sign
+			}
+			if t.opts.Atomics {
+				row.Atomics = &s.atom
+			}
+			res.Functions = append(res.Functions, row)
+		}
+		sort.SliceStable(res.Functions, func(i, j int) bool {
+			return res.Functions[i].Sum() > res.Functions[j].Sum()
+		})
+	}
+}
+
+// runStatic decodes the executable sections of cmd[0], a 64-bit ELF file
+// for one of staticArchs, an arm64 Mach-O file or, on macOS, an arm64
+// system library in the dyld shared cache, and counts each classified
+// instruction once per occurrence. With Options.Loops it also reports the
+// loops the jumps of each function form (findStaticLoops).
+func (p *Profiler) runStatic(cmd []string) (*Result, error) {
+	start := time.Now()
+	path, err := filepath.Abs(cmd[0])
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	arch, secs, funcs, debug, err := staticImage(path, p.opts.DebugDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "iccad-static",
+		Backend:       BackendStatic,
+		Arch:          arch.name,
+		Binary:        Binary{Path: path, Args: cmd},
+		Mode:          "whole",
+		Categories:    Categories{},
+	}
+	if debug.Path != "" {
+		res.DebugInfo = []DebugFile{debug}
+	}
+	lo, hi := uint64(0), ^uint64(0)
+	if p.opts.Func != "" {
+		i := 0
+		for i < len(funcs) && funcs[i].name != p.opts.Func {
+			i++
+		}
+		if i == len(funcs) {
+			return nil, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, p.opts.Func, path)
+		}
+		lo, hi = funcs[i].start, funcs[i].end
+		res.Mode = "address"
+		res.Region = &Region{Addr: fmt.Sprintf("%#x", lo)}
+	}
+
+	t := p.newStaticTally(arch, res)
+	for _, fn := range funcs {
+		t.addFunc(fn.name)
+	}
+	var loops []staticLoop
+	if p.opts.Loops {
+		loops = findStaticLoops(arch, secs, funcs, lo, hi)
+		t.loops = make([]staticScope, len(loops))
+	}
+	var bad, unnamed, ops uint64 // bytes that did not decode, ops in no function and all
+	for _, sec := range secs {
+		next := sort.Search(len(loops), func(i int) bool { return loops[i].start >= sec.addr })
+		t.in = t.in[:0]
+		for off, size := 0, 0; off < len(sec.code); off += size {
+			addr := sec.addr + uint64(off)
+			var op staticOp
+			var ok bool
+			op, size, ok = arch.decode(sec.code[off:])
+			if addr < lo || addr >= hi {
+				continue
+			}
+			if undecodable(arch, sec.code[off:], size, ok) {
+				bad++
+			}
+			if !ok && len(t.classes) == 0 && !p.opts.Atomics {
+				continue
+			}
+			fn := staticFuncAt(funcs, addr)
+			if len(loops) > 0 {
+				in := t.in[:0]
+				for _, l := range t.in {
+					if loops[l].end > addr {
+						in = append(in, l)
+					}
+				}
+				for ; next < len(loops) && loops[next].start <= addr; next++ {
+					if loops[next].end > addr {
+						in = append(in, next)
+					}
+				}
+				t.in = in
+			}
+			if ok {
+				ops++
+				if fn < 0 {
+					unnamed++
+				}
+				insn := sec.code[off : off+size]
+				t.rm = arch.mem != nil && arch.mem(insn)
+				t.count(op, fn, 1)
+				t.signed(insn, op, fn, 1)
+			}
+			t.atomic(sec.code[off:off+size], fn, 1)
+			t.classify(sec.code[off:off+size], fn, 1)
+		}
+	}
+	t.finish(path)
+	if bad > 0 {
+		res.diagnose(Diagnostic{Level: DiagWarn, Kind: DiagDecodeFailure, Image: path, Count: bad,
+			Message: "bytes of the executable sections did not decode and were stepped over one at a time; the instructions after them may be misread"})
+	}
+	if unnamed > 0 {
+		res.diagnose(unnamedOps(path, unnamed, ops, "ops counted in code outside any function symbol, in the totals only"))
+	}
+	if p.opts.Loops {
+		res.Loops = t.loopRows(loops, funcs, path, staticDWARF(path, p.opts.DebugDirs))
+	}
+	res.WallTimeSec = time.Since(start).Seconds()
+	return res, nil
+}
+
+// staticFuncAt returns the index of the function of funcs holding addr,
+// -1 when none does.
+func staticFuncAt(funcs []staticFunc, addr uint64) int {
+	fn := sort.Search(len(funcs), func(i int) bool { return funcs[i].end > addr })
+	if fn == len(funcs) || funcs[fn].start > addr {
+		return -1
+	}
+	return fn
+}
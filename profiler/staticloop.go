@@ -0,0 +1,148 @@
+package profiler
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sort"
+)
+
+// staticLoop is a loop the static backend finds in a function: the code
+// from the target of a jump back within the function to the end of the
+// last jump back to it. Jumps back to the function's entry, retries after
+// growing a Go stack and tail recursion, are not loops. Parent is the index of the innermost loop holding
+// it, -1 for none.
+type staticLoop struct {
+	fn         int
+	start, end uint64
+	blocks     int
+	parent     int
+	depth      int
+}
+
+// findStaticLoops returns the loops of the functions of secs between lo
+// and hi, by start address and the outer of two loops with the same
+// start first. A loop that overlaps another without holding it or being
+// held is not nested in it.
+func findStaticLoops(arch staticArch, secs []codeSection, funcs []staticFunc, lo, hi uint64) []staticLoop {
+	var loops []staticLoop
+	var leaders []uint64
+	head := map[uint64]int{}
+	for _, sec := range secs {
+		for off, size := 0, 0; off < len(sec.code); off += size {
+			addr := sec.addr + uint64(off)
+			_, size, _ = arch.decode(sec.code[off:])
+			to, ok := arch.branch(sec.code[off:], addr)
+			if !ok {
+				continue
+			}
+			end := addr + uint64(size)
+			leaders = append(leaders, to, end)
+			fn := staticFuncAt(funcs, addr)
+			if fn < 0 || to > addr || to <= funcs[fn].start || to < lo || addr >= hi {
+				continue
+			}
+			if i, ok := head[to]; ok {
+				loops[i].end = max(loops[i].end, end)
+				continue
+			}
+			head[to] = len(loops)
+			loops = append(loops, staticLoop{fn: fn, start: to, end: end})
+		}
+	}
+	sort.Slice(leaders, func(i, j int) bool { return leaders[i] < leaders[j] })
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].start != loops[j].start {
+			return loops[i].start < loops[j].start
+		}
+		return loops[i].end > loops[j].end
+	})
+
+	var open []int // the loops holding the current one, outermost first
+	for i := range loops {
+		l := &loops[i]
+		for len(open) > 0 {
+			o := loops[open[len(open)-1]]
+			if o.fn == l.fn && l.end <= o.end {
+				break
+			}
+			open = open[:len(open)-1]
+		}
+		l.parent = -1
+		if len(open) > 0 {
+			l.parent = open[len(open)-1]
+		}
+		open = append(open, i)
+		l.depth = len(open)
+		// the blocks start at the header and after each jump or branch,
+		// and at each target
+		first := sort.Search(len(leaders), func(k int) bool { return leaders[k] > l.start })
+		l.blocks = 1
+		for k := first; k < len(leaders) && leaders[k] < l.end; k++ {
+			if leaders[k] != leaders[k-1] {
+				l.blocks++
+			}
+		}
+	}
+	return loops
+}
+
+// loopRows returns the loops t counted operations in, the most first, named
+// by the function of funcs they are in and located with dw when the
+// binary at image has line info.
+func (t *staticTally) loopRows(loops []staticLoop, funcs []staticFunc, image string, dw *dwarf.Data) []Loop {
+	rows := make([]Loop, 0, len(loops))
+	for i, l := range loops {
+		s := &t.loops[i]
+		if s.counts.Sum()+s.counts.BitSum()+s.vec.Sum()+s.fp64.Sum()+s.fp32.Sum() == 0 {
+			continue
+		}
+		row := Loop{
+			ID:       i,
+			Depth:    l.depth,
+			Function: funcs[l.fn].name,
+			Image:    image,
+			Offset:   fmt.Sprintf("%#x", l.start-funcs[l.fn].start),
+			File:     "??",
+			Blocks:   l.blocks,
+			Counts:   s.counts,
+		}
+		if l.parent >= 0 {
+			parent := l.parent
+			row.Parent = &parent
+		}
+		if dw != nil {
+			if file, line := dwarfLineAt(dw, l.start); file != "" {
+				row.File, row.Line = file, line
+			}
+		}
+		if t.opts.Vec {
+			row.Vector = &s.vec
+		}
+		if t.opts.FP {
+			row.FP64, row.FP32 = &s.fp64, &s.fp32
+		}
+		rows = append(rows, row)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Ops() > rows[j].Ops() })
+	return rows
+}
+
+// staticDWARF returns the DWARF of the ELF file at path, or of its
+// separate debug file in dirs; nil without line info.
+func staticDWARF(path string, dirs []string) *dwarf.Data {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	sf, _ := withDebug(f, path, "", dirs)
+	if sf != f {
+		defer sf.Close()
+	}
+	dw, err := sf.DWARF()
+	if err != nil {
+		return nil
+	}
+	return dw
+}
@@ -0,0 +1,189 @@
+package profiler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DefaultCVThreshold is the coefficient of variation, in percent, above
+// which Summarize flags a counter as unstable.
+const DefaultCVThreshold = 1.0
+
+// Stat summarizes one counter over repeated runs.
+type Stat struct {
+	Name     string  `json:"name"`               // a WriteCSV op type
+	Function string  `json:"function,omitempty"` // empty for the totals
+	Mean     float64 `json:"mean"`
+	Median   float64 `json:"median"`
+	Stddev   float64 `json:"stddev"` // sample standard deviation, 0 for one run
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	CV       float64 `json:"cv_pct"` // Stddev / Mean in percent, 0 when Mean is
+	Unstable bool    `json:"unstable,omitempty"`
+}
+
+// RunStats is the spread of every counter over repeated runs of one
+// workload (Summarize).
+type RunStats struct {
+	Runs        int     `json:"runs"`
+	CVThreshold float64 `json:"cv_threshold_pct"`
+	Totals      []Stat  `json:"totals"`              // in WriteCSV op order
+	Functions   []Stat  `json:"functions,omitempty"` // busiest function first
+	WallTimeSec Stat    `json:"wall_time_sec"`       // never flagged
+}
+
+// Summarize computes the mean, median, standard deviation and range of
+// every total and per-function counter over results, repeated runs with
+// the same options, and flags the counters whose coefficient of variation
+// exceeds threshold percent. Functions missing from a run count zero in
+// it; per-function counters that are zero in every run are left out.
+func Summarize(results []*Result, threshold float64) (*RunStats, error) {
+	if len(results) == 0 {
+		return nil, errors.New("profiler: no runs to summarize")
+	}
+	ops := results[0].csvOps()
+	for _, r := range results[1:] {
+		if strings.Join(r.csvOps(), ",") != strings.Join(ops, ",") {
+			return nil, errors.New("profiler: runs were recorded with different options")
+		}
+	}
+	s := &RunStats{Runs: len(results), CVThreshold: threshold}
+
+	totals := make([][]float64, len(ops))
+	for _, r := range results {
+		for i, v := range r.totalValues() {
+			totals[i] = append(totals[i], float64(v))
+		}
+	}
+	for i, op := range ops {
+		s.Totals = append(s.Totals, newStat(op, "", totals[i], threshold))
+	}
+
+	// functions in the order of their mean operations, matched by name
+	// and image
+	type key struct{ name, image string }
+	vals := map[key][][]float64{}
+	var order []key
+	weight := map[key]float64{}
+	for run, r := range results {
+		for _, f := range r.Functions {
+			k := key{f.Name, f.Image}
+			if _, ok := vals[k]; !ok {
+				vals[k] = make([][]float64, len(ops))
+				for i := range ops {
+					vals[k][i] = make([]float64, len(results))
+				}
+				order = append(order, k)
+			}
+			for i, v := range r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom) {
+				vals[k][i][run] = float64(v)
+			}
+			weight[k] += float64(f.Sum())
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return weight[order[i]] > weight[order[j]] })
+	for _, k := range order {
+		for i, op := range ops {
+			st := newStat(op, k.name, vals[k][i], threshold)
+			if st.Max > 0 {
+				s.Functions = append(s.Functions, st)
+			}
+		}
+	}
+
+	var wall []float64
+	for _, r := range results {
+		wall = append(wall, r.WallTimeSec)
+	}
+	s.WallTimeSec = newStat("wall_time_sec", "", wall, threshold)
+	s.WallTimeSec.Unstable = false
+	return s, nil
+}
+
+// newStat summarizes vs, flagging it above threshold percent.
+func newStat(name, function string, vs []float64, threshold float64) Stat {
+	st := Stat{Name: name, Function: function, Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range vs {
+		st.Mean += v
+		st.Min = math.Min(st.Min, v)
+		st.Max = math.Max(st.Max, v)
+	}
+	st.Mean /= float64(len(vs))
+	sorted := append([]float64(nil), vs...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 1 {
+		st.Median = sorted[n/2]
+	} else {
+		st.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	if len(vs) > 1 {
+		for _, v := range vs {
+			st.Stddev += (v - st.Mean) * (v - st.Mean)
+		}
+		st.Stddev = math.Sqrt(st.Stddev / float64(len(vs)-1))
+	}
+	if st.Mean != 0 {
+		st.CV = 100 * st.Stddev / st.Mean
+	}
+	st.Unstable = st.CV > threshold
+	return st
+}
+
+// Unstable returns the flagged counters, totals first.
+func (s *RunStats) Unstable() []Stat {
+	var out []Stat
+	for _, st := range append(append([]Stat{}, s.Totals...), s.Functions...) {
+		if st.Unstable {
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// WriteText renders the totals and per-function statistics as tables,
+// marking with "!" the counters above the threshold.
+func (s *RunStats) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "----- Statistics over %d runs (! = CV above %g%%) -----\n", s.Runs, s.CVThreshold)
+	header := func(first string) {
+		fmt.Fprintf(bw, "  %-18s%16s%16s%14s%16s%16s%9s\n", first, "MEAN", "MEDIAN", "STDDEV", "MIN", "MAX", "CV%")
+	}
+	row := func(label string, st Stat, num string) {
+		mark := " "
+		if st.Unstable {
+			mark = "!"
+		}
+		fmt.Fprintf(bw, "%s %-18s"+num+num+"%14.2f"+num+num+"%9.3f\n", mark, label,
+			st.Mean, st.Median, st.Stddev, st.Min, st.Max, st.CV)
+	}
+	header("COUNTER")
+	for _, st := range s.Totals {
+		row(strings.ToUpper(st.Name), st, "%16.1f")
+	}
+	row("WALL(s)", s.WallTimeSec, "%16.3f")
+
+	if len(s.Functions) > 0 {
+		fmt.Fprintln(bw, "\n----- Functions -----")
+		fn := ""
+		for _, st := range s.Functions {
+			if st.Function != fn {
+				fn = st.Function
+				fmt.Fprintf(bw, "%s\n", fn)
+				header("COUNTER")
+			}
+			row(strings.ToUpper(st.Name), st, "%16.1f")
+		}
+	}
+
+	if n := len(s.Unstable()); n > 0 {
+		fmt.Fprintf(bw, "\n%d counters vary by more than %g%% between runs\n", n, s.CVThreshold)
+	} else {
+		fmt.Fprintf(bw, "\nall counters within %g%% between runs\n", s.CVThreshold)
+	}
+	return bw.Flush()
+}
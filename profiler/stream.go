@@ -0,0 +1,104 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Snapshot is one periodic reading of a streaming run (Options.Stream):
+// the counts since the start and over the last interval. Readings are
+// taken while the target runs and may lag by a few instructions; the one
+// marked Final, taken at exit or detach, matches the report.
+type Snapshot struct {
+	Seq        int            `json:"seq"`
+	Pid        int            `json:"pid"`
+	Elapsed    float64        `json:"elapsed_sec"`
+	Interval   float64        `json:"interval_sec"`
+	Threads    int            `json:"threads"`
+	Final      bool           `json:"final,omitempty"`
+	Cumulative SnapshotCounts `json:"cumulative"`
+	Delta      SnapshotCounts `json:"delta"`
+	// Functions, with Options.StreamFuncs, are the functions with the most
+	// operations so far, busiest first.
+	Functions []SnapshotFunction `json:"functions,omitempty"`
+}
+
+// SnapshotFunction is one function's counts since the start.
+type SnapshotFunction struct {
+	Name       string         `json:"name"`
+	Image      string         `json:"image"`
+	Cumulative SnapshotCounts `json:"cumulative"`
+}
+
+// SnapshotCounts maps a category to its count: the CategoryNames, the
+// BitCategoryNames selected with Options.Ops, and "vec" (Options.Vec),
+// "wide" (Options.Wide), "fp64" and "fp32" (Options.FP), "loads",
+// "stores", "bytes_read" and "bytes_written" (Options.Mem).
+type SnapshotCounts map[string]uint64
+
+// snapshotOrder is the report order of every SnapshotCounts key.
+var snapshotOrder = append(append(append([]string{}, CategoryNames...), BitCategoryNames...),
+	"vec", "wide", "fp64", "fp32", "loads", "stores", "bytes_read", "bytes_written")
+
+// Categories returns the categories s counts, in report order.
+func (s *Snapshot) Categories() []string { return categoriesOf(s.Cumulative) }
+
+// categoriesOf returns the keys of c in report order.
+func categoriesOf(c SnapshotCounts) []string {
+	var names []string
+	for _, name := range snapshotOrder {
+		if _, ok := c[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// tailSnapshots creates the file the tool appends snapshots to and passes
+// each complete line to fn as it arrives. The returned func, called once
+// the tool is done writing, delivers the remaining lines and removes the
+// file.
+func tailSnapshots(path string, fn func(Snapshot)) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		br := bufio.NewReader(f)
+		var line []byte
+		stopping := false
+		for {
+			b, err := br.ReadBytes('\n')
+			line = append(line, b...)
+			if err == nil {
+				var s Snapshot
+				if json.Unmarshal(line, &s) == nil {
+					fn(s)
+				}
+				line = line[:0]
+				continue
+			}
+			// at the end of the file: once the tool is done, one more pass
+			// picks up what it wrote last
+			if stopping {
+				return
+			}
+			select {
+			case <-done:
+				stopping = true
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		f.Close()
+		os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,89 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Strides classifies the memory accesses of loops (Options.Strides) by how
+// each memory operand steps from its previous access: by its own size
+// either way (Unit), as it stepped before (Constant, a zero step
+// included), or otherwise (Irregular). An operand's first access is not
+// classified, nor its second unless unit. Loops is how many loops had
+// classified accesses and the totals are over all of them; Top lists the
+// Options.Strides loops with the most, each access counted in the
+// innermost loop of its instruction.
+type Strides struct {
+	Loops int `json:"loops"`
+	StrideCounts
+	Top []StrideLoop `json:"top"`
+}
+
+// StrideCounts is the classified accesses of one or more loops.
+type StrideCounts struct {
+	Unit      uint64 `json:"unit"`
+	Constant  uint64 `json:"constant"`
+	Irregular uint64 `json:"irregular"`
+}
+
+// Accesses returns the classified accesses.
+func (c StrideCounts) Accesses() uint64 { return c.Unit + c.Constant + c.Irregular }
+
+// Pattern summarizes the counts for choosing a data mover: "unit" when at
+// least 90% of the accesses are unit stride (streaming DMA), "strided"
+// when 90% are unit or constant stride (strided DMA), else "irregular"
+// (gather/scatter).
+func (c StrideCounts) Pattern() string {
+	n := c.Accesses()
+	switch {
+	case c.Unit*10 >= n*9:
+		return "unit"
+	case (c.Unit+c.Constant)*10 >= n*9:
+		return "strided"
+	}
+	return "irregular"
+}
+
+// StrideLoop is the classified accesses of one loop, identified as in
+// Result.Loops; Sites is how many memory operands it has.
+type StrideLoop struct {
+	Loop     int    `json:"loop"`
+	Depth    int    `json:"depth"`
+	Function string `json:"function"`
+	Image    string `json:"image"`
+	Offset   string `json:"offset"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Sites    int    `json:"sites"`
+	StrideCounts
+}
+
+// writeStrides renders the access-pattern totals and the loops accessing
+// most.
+func writeStrides(w io.Writer, s *Strides) {
+	pct := func(n, all uint64) string {
+		p := 0.0
+		if all > 0 {
+			p = 100 * float64(n) / float64(all)
+		}
+		return fmt.Sprintf("%.1f%%", p)
+	}
+	loops := "loops"
+	if s.Loops == 1 {
+		loops = "loop"
+	}
+	n := s.Accesses()
+	fmt.Fprintf(w, "\n----- Access patterns (loops) -----\n")
+	fmt.Fprintf(w, "Accesses:  %d classified in %d %s: %s unit stride, %s constant stride, %s irregular\n",
+		n, s.Loops, loops, pct(s.Unit, n), pct(s.Constant, n), pct(s.Irregular, n))
+	fmt.Fprintf(w, "%14s%8s%10s%11s%7s  PATTERN    DEPTH  LOOP\n", "ACCESSES", "UNIT", "CONSTANT", "IRREGULAR", "SITES")
+	for _, l := range s.Top {
+		c := l.Accesses()
+		fmt.Fprintf(w, "%14d%8s%10s%11s%7d  %-11s%5d  %s+%s", c, pct(l.Unit, c), pct(l.Constant, c),
+			pct(l.Irregular, c), l.Sites, l.Pattern(), l.Depth, l.Function, l.Offset)
+		if l.Line > 0 {
+			fmt.Fprintf(w, "  (%s:%d)", l.File, l.Line)
+		}
+		fmt.Fprintln(w)
+	}
+}
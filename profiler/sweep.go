@@ -0,0 +1,363 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SweepParam is one parameter of a sweep grid and the values it takes.
+type SweepParam struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// Sweep is how the counts of a workload scale with its parameters: one
+// run per point of a grid (NewSweep).
+type Sweep struct {
+	Params  []SweepParam   `json:"params"`
+	Ops     []string       `json:"ops"`    // "int" (add+sub+mul+div), then the WriteCSV op types
+	Points  []SweepPoint   `json:"points"` // in SweepPoints order
+	Scaling []SweepScaling `json:"scaling,omitempty"`
+	Fits    []SweepFit     `json:"fits,omitempty"`
+}
+
+// SweepPoint is the run at one point of the grid: Values by Params,
+// Totals by Ops. Error is set for a point whose run failed.
+type SweepPoint struct {
+	Values      []string `json:"values"`
+	Totals      []uint64 `json:"totals,omitempty"`
+	WallTimeSec float64  `json:"wall_time_sec"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// SweepScaling is the exponent k of count ∝ Param^k for one op type,
+// fitted on a log-log scale to each series of points that differ in
+// Param only and averaged over the Series fitted.
+type SweepScaling struct {
+	Param    string  `json:"param"`
+	Op       string  `json:"op"`
+	Exponent float64 `json:"exponent"`
+	Series   int     `json:"series"`
+}
+
+var sweepName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseSweep parses a sweep grid: parameters separated by ';', each a
+// name, '=' and its values separated by ',', as in
+// "N=1024,2048,4096;threads=1,4,16".
+func ParseSweep(spec string) ([]SweepParam, error) {
+	var params []SweepParam
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !sweepName.MatchString(name) {
+			return nil, fmt.Errorf("profiler: sweep %q: want name=value,value… for each parameter", spec)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("profiler: sweep %q: parameter %s given twice", spec, name)
+		}
+		seen[name] = true
+		p := SweepParam{Name: name}
+		for _, v := range strings.Split(values, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				return nil, fmt.Errorf("profiler: sweep %q: empty value of %s", spec, name)
+			}
+			p.Values = append(p.Values, v)
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("profiler: sweep %q: no parameters", spec)
+	}
+	return params, nil
+}
+
+// SweepPoints returns every point of the grid, each its values by
+// params, the last parameter varying fastest.
+func SweepPoints(params []SweepParam) [][]string {
+	points := [][]string{nil}
+	for _, p := range params {
+		var next [][]string
+		for _, pt := range points {
+			for _, v := range p.Values {
+				next = append(next, append(append([]string{}, pt...), v))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// SweepCommand returns cmd with {name} in its arguments replaced by the
+// value of each parameter at a point; a parameter that appears nowhere
+// in cmd is an error.
+func SweepCommand(cmd []string, params []SweepParam, values []string) ([]string, error) {
+	out := append([]string{}, cmd...)
+	for i, p := range params {
+		ph := "{" + p.Name + "}"
+		used := false
+		for j := range out {
+			used = used || strings.Contains(out[j], ph)
+			out[j] = strings.ReplaceAll(out[j], ph, values[i])
+		}
+		if !used {
+			return nil, fmt.Errorf("profiler: sweep parameter %s is not in the command (write %s where its values go)", p.Name, ph)
+		}
+	}
+	return out, nil
+}
+
+// NewSweep combines the results of the runs at each of SweepPoints, nil
+// for those that failed, and fits how each op type scales with each
+// parameter whose values are all positive numbers, and which complexity
+// model the int ops of the run and of its busiest functions follow.
+func NewSweep(params []SweepParam, results []*Result) (*Sweep, error) {
+	points := SweepPoints(params)
+	if len(results) != len(points) {
+		return nil, fmt.Errorf("profiler: sweep of %d points has %d results", len(points), len(results))
+	}
+	s := &Sweep{Params: params}
+	var ops []string
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		if ops == nil {
+			ops = r.csvOps()
+		} else if strings.Join(r.csvOps(), ",") != strings.Join(ops, ",") {
+			return nil, errors.New("profiler: runs were recorded with different options")
+		}
+	}
+	s.Ops = append([]string{"int"}, ops...)
+	for i, r := range results {
+		pt := SweepPoint{Values: points[i]}
+		if r != nil {
+			tv := r.totalValues()
+			pt.Totals = append([]uint64{tv[0] + tv[1] + tv[2] + tv[3]}, tv...)
+			pt.WallTimeSec = r.WallTimeSec
+		}
+		s.Points = append(s.Points, pt)
+	}
+	s.fit()
+	s.fitModels(results)
+	return s, nil
+}
+
+// fit fills in s.Scaling.
+func (s *Sweep) fit() {
+	for k, p := range s.Params {
+		x, ok := s.numeric(k)
+		if !ok {
+			continue
+		}
+		series := s.series(k)
+		for op := range s.Ops {
+			var sum float64
+			var n int
+			for _, points := range series {
+				var lx, ly []float64
+				for _, i := range points {
+					pt := s.Points[i]
+					if pt.Totals == nil || pt.Totals[op] == 0 {
+						continue
+					}
+					lx = append(lx, math.Log(x[i]))
+					ly = append(ly, math.Log(float64(pt.Totals[op])))
+				}
+				if slope, ok := logSlope(lx, ly); ok {
+					sum += slope
+					n++
+				}
+			}
+			if n > 0 {
+				s.Scaling = append(s.Scaling, SweepScaling{Param: p.Name, Op: s.Ops[op], Exponent: sum / float64(n), Series: n})
+			}
+		}
+	}
+}
+
+// numeric returns the value of parameter k at each point, false unless
+// the parameter has two or more values and all are positive numbers.
+func (s *Sweep) numeric(k int) ([]float64, bool) {
+	if len(s.Params[k].Values) < 2 {
+		return nil, false
+	}
+	x := make([]float64, len(s.Points))
+	for i, pt := range s.Points {
+		v, err := strconv.ParseFloat(pt.Values[k], 64)
+		if err != nil || v <= 0 {
+			return nil, false
+		}
+		x[i] = v
+	}
+	return x, true
+}
+
+// series groups the points that differ in parameter k only, in the
+// order of their first point.
+func (s *Sweep) series(k int) [][]int {
+	index := map[string]int{}
+	var series [][]int
+	for i, pt := range s.Points {
+		rest := strings.Join(s.others(k, pt.Values), "\x00")
+		j, ok := index[rest]
+		if !ok {
+			j = len(series)
+			index[rest] = j
+			series = append(series, nil)
+		}
+		series[j] = append(series[j], i)
+	}
+	return series
+}
+
+// others returns values, a point's, without parameter k.
+func (s *Sweep) others(k int, values []string) []string {
+	return append(append([]string{}, values[:k]...), values[k+1:]...)
+}
+
+// logSlope returns the least-squares slope of ys over xs; false with
+// fewer than two distinct xs.
+func logSlope(xs, ys []float64) (float64, bool) {
+	if len(xs) < 2 {
+		return 0, false
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(len(xs))
+	my /= float64(len(xs))
+	var sxy, sxx float64
+	for i := range xs {
+		sxy += (xs[i] - mx) * (ys[i] - my)
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if sxx == 0 {
+		return 0, false
+	}
+	return sxy / sxx, true
+}
+
+// Failed returns how many points of s have no result.
+func (s *Sweep) Failed() int {
+	n := 0
+	for _, pt := range s.Points {
+		if pt.Error != "" || pt.Totals == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText renders a table of every point's totals and one of the
+// exponents fitted per op type and parameter.
+func (s *Sweep) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	names := make([]string, len(s.Params))
+	widths := make([]int, len(s.Params))
+	for i, p := range s.Params {
+		names[i] = p.Name
+		widths[i] = len(p.Name)
+		for _, v := range p.Values {
+			widths[i] = max(widths[i], len(v))
+		}
+		widths[i] += 2
+	}
+	fmt.Fprintf(bw, "----- Sweep over %s (%d points) -----\n", strings.Join(names, " × "), len(s.Points))
+	for i, n := range names {
+		fmt.Fprintf(bw, "%*s", widths[i], n)
+	}
+	for _, op := range s.Ops {
+		fmt.Fprintf(bw, "%14s", strings.ToUpper(op))
+	}
+	fmt.Fprintf(bw, "%10s\n", "WALL(s)")
+	for _, pt := range s.Points {
+		for i, v := range pt.Values {
+			fmt.Fprintf(bw, "%*s", widths[i], v)
+		}
+		if pt.Totals == nil {
+			fmt.Fprintf(bw, "  error: %s\n", pt.Error)
+			continue
+		}
+		for _, v := range pt.Totals {
+			fmt.Fprintf(bw, "%14d", v)
+		}
+		fmt.Fprintf(bw, "%10.3f\n", pt.WallTimeSec)
+	}
+
+	if len(s.Scaling) > 0 {
+		var fitted []string
+		exp := map[[2]string]float64{}
+		for _, sc := range s.Scaling {
+			if len(fitted) == 0 || fitted[len(fitted)-1] != sc.Param {
+				fitted = append(fitted, sc.Param)
+			}
+			exp[[2]string{sc.Op, sc.Param}] = sc.Exponent
+		}
+		fmt.Fprintf(bw, "\n----- Scaling exponents (count ∝ param^e) -----\n  %-18s", "COUNTER")
+		for _, p := range fitted {
+			fmt.Fprintf(bw, "%10s", p)
+		}
+		fmt.Fprintln(bw)
+		for _, op := range s.Ops {
+			fmt.Fprintf(bw, "  %-18s", strings.ToUpper(op))
+			for _, p := range fitted {
+				if k, ok := exp[[2]string{op, p}]; ok {
+					fmt.Fprintf(bw, "%10.2f", k)
+				} else {
+					fmt.Fprintf(bw, "%10s", "-")
+				}
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	if len(s.Fits) > 0 {
+		s.writeFits(bw)
+	}
+	if n := s.Failed(); n > 0 {
+		fmt.Fprintf(bw, "\n%d of %d points failed\n", n, len(s.Points))
+	}
+	return bw.Flush()
+}
+
+// WriteCSV renders one row per point: the parameters, the totals by Ops,
+// wall_time_sec and error; comma is ',' for CSV or '\t' for TSV.
+func (s *Sweep) WriteCSV(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	var header []string
+	for _, p := range s.Params {
+		header = append(header, p.Name)
+	}
+	cw.Write(append(append(header, s.Ops...), "wall_time_sec", "error"))
+	for _, pt := range s.Points {
+		row := append([]string{}, pt.Values...)
+		for i := range s.Ops {
+			v := ""
+			if pt.Totals != nil {
+				v = strconv.FormatUint(pt.Totals[i], 10)
+			}
+			row = append(row, v)
+		}
+		wall := ""
+		if pt.Totals != nil {
+			wall = strconv.FormatFloat(pt.WallTimeSec, 'f', 3, 64)
+		}
+		cw.Write(append(row, wall, pt.Error))
+	}
+	cw.Flush()
+	return cw.Error()
+}
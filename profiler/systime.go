@@ -0,0 +1,51 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// SysTime is the kernel's share of a run, apart from the counts, which
+// are of user-mode instructions only (Options.SysTime): the system calls,
+// timed from entry to exit and summed over the threads, blocked time
+// included, and the process's CPU time since the tool started.
+type SysTime struct {
+	Calls   uint64  `json:"calls"`
+	Errors  uint64  `json:"errors"`   // calls that failed
+	TimeSec float64 `json:"time_sec"` // wall time in calls
+	// KernelCPUSec and UserCPUSec are from times(2) (not on Windows);
+	// the user time includes the tool's own.
+	KernelCPUSec *float64      `json:"kernel_cpu_sec,omitempty"`
+	UserCPUSec   *float64      `json:"user_cpu_sec,omitempty"`
+	ByCall       []SyscallTime `json:"by_call"` // the longest total time first
+}
+
+// SyscallTime is the time spent in one system call. Name is the Linux
+// x86-64 name of call Nr, or syscall_Nr.
+type SyscallTime struct {
+	Nr      int64   `json:"nr"`
+	Name    string  `json:"name"`
+	Calls   uint64  `json:"calls"`
+	Errors  uint64  `json:"errors"`
+	TimeSec float64 `json:"time_sec"`
+	MaxSec  float64 `json:"max_sec"` // the longest call
+}
+
+// writeSysTime renders s in the pintool's text layout.
+func writeSysTime(w io.Writer, s *SysTime) {
+	fmt.Fprintf(w, "\n----- System calls -----\n")
+	fmt.Fprintf(w, "The counts above are of user-mode instructions; the kernel work below is not counted.\n")
+	fmt.Fprintf(w, "Calls:    %d (%d failed)\n", s.Calls, s.Errors)
+	fmt.Fprintf(w, "In calls: %.3f s wall, summed over threads\n", s.TimeSec)
+	if s.KernelCPUSec != nil && s.UserCPUSec != nil {
+		fmt.Fprintf(w, "CPU:      %.3f s kernel, %.3f s user (the tool's included)\n", *s.KernelCPUSec, *s.UserCPUSec)
+	}
+	fmt.Fprintf(w, "%12s%10s%12s%12s%12s  SYSCALL\n", "CALLS", "ERRORS", "TIME_S", "AVG_US", "MAX_US")
+	for _, c := range s.ByCall {
+		avg := 0.0
+		if c.Calls > 0 {
+			avg = c.TimeSec * 1e6 / float64(c.Calls)
+		}
+		fmt.Fprintf(w, "%12d%10d%12.3f%12.1f%12.1f  %s\n", c.Calls, c.Errors, c.TimeSec, avg, c.MaxSec*1e6, c.Name)
+	}
+}
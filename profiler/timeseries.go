@@ -0,0 +1,138 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time series formats (Options.TimeSeriesFormat).
+const (
+	TimeSeriesJSON = "json" // one JSON object per line
+	TimeSeriesCSV  = "csv"  // a header, then one row per period
+)
+
+// TimeSeriesPoint is one period of an Options.TimeSeries file: the counts
+// of the IntervalSec seconds that ended ElapsedSec into the run, at Unix
+// time Time.
+type TimeSeriesPoint struct {
+	Time        float64        `json:"time"`
+	ElapsedSec  float64        `json:"elapsed_sec"`
+	IntervalSec float64        `json:"interval_sec"`
+	Counts      SnapshotCounts `json:"counts"`
+}
+
+// Timestamp returns p.Time as a time.Time.
+func (p *TimeSeriesPoint) Timestamp() time.Time {
+	sec, frac := math.Modf(p.Time)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// Rate returns the operations of category per second over the period.
+func (p *TimeSeriesPoint) Rate(category string) float64 {
+	if p.IntervalSec <= 0 {
+		return 0
+	}
+	return float64(p.Counts[category]) / p.IntervalSec
+}
+
+// timeSeriesFormat returns the format of Options.TimeSeries: as set, or
+// csv for a .csv file and json otherwise.
+func (o *Options) timeSeriesFormat() string {
+	if o.TimeSeriesFormat != "" {
+		return o.TimeSeriesFormat
+	}
+	if strings.EqualFold(filepath.Ext(o.TimeSeries), ".csv") {
+		return TimeSeriesCSV
+	}
+	return TimeSeriesJSON
+}
+
+// checkTimeSeries validates the time series options of a pin backend run.
+func (o *Options) checkTimeSeries() error {
+	if o.TimeSeries == "" {
+		if o.TimeSeriesInterval != 0 || o.TimeSeriesFormat != "" {
+			return errors.New("profiler: TimeSeriesInterval and TimeSeriesFormat need TimeSeries")
+		}
+		return nil
+	}
+	if f := o.timeSeriesFormat(); f != TimeSeriesJSON && f != TimeSeriesCSV {
+		return fmt.Errorf("profiler: TimeSeriesFormat %q is not %s or %s", f, TimeSeriesJSON, TimeSeriesCSV)
+	}
+	if o.TimeSeriesInterval < 0 {
+		return errors.New("profiler: TimeSeriesInterval must not be negative")
+	}
+	if o.Sample > 0 && o.Sample < 1 {
+		return errors.New("profiler: TimeSeries excludes Sample")
+	}
+	return nil
+}
+
+// ReadTimeSeries reads an Options.TimeSeries file in either format.
+func ReadTimeSeries(path string) ([]TimeSeriesPoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	first, err := br.Peek(1)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	var points []TimeSeriesPoint
+	if first[0] == '{' {
+		dec := json.NewDecoder(br)
+		for n := 1; ; n++ {
+			var p TimeSeriesPoint
+			if err := dec.Decode(&p); err == io.EOF {
+				return points, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("profiler: %s: point %d: %v", path, n, err)
+			}
+			points = append(points, p)
+		}
+	}
+
+	r := csv.NewReader(br)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %s: %v", path, err)
+	}
+	if len(header) < 3 || header[0] != "time" || header[1] != "elapsed_sec" || header[2] != "interval_sec" {
+		return nil, fmt.Errorf("profiler: %s: not a time series", path)
+	}
+	for n := 2; ; n++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profiler: %s: %v", path, err)
+		}
+		p := TimeSeriesPoint{Counts: SnapshotCounts{}}
+		for i, dst := range []*float64{&p.Time, &p.ElapsedSec, &p.IntervalSec} {
+			if *dst, err = strconv.ParseFloat(row[i], 64); err != nil {
+				return nil, fmt.Errorf("profiler: %s:%d: %v", path, n, err)
+			}
+		}
+		for i, name := range header[3:] {
+			if p.Counts[name], err = strconv.ParseUint(row[3+i], 10, 64); err != nil {
+				return nil, fmt.Errorf("profiler: %s:%d: %v", path, n, err)
+			}
+		}
+		points = append(points, p)
+	}
+}
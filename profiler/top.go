@@ -0,0 +1,151 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TopOther names the row of a top-N table summing the functions below
+// the N biggest.
+const TopOther = "[other]"
+
+// Top is the N functions with the most of each op type (Result.SetTop),
+// the rest summed into one TopOther row, for reports too long to read
+// function by function.
+type Top struct {
+	N          int           `json:"n"`
+	Categories []TopCategory `json:"categories"`
+}
+
+// TopCategory ranks the functions by one op type: "int" (add+sub+mul+div)
+// or one of WriteCSV's. Rows are the N functions with the most, then
+// TopOther when more functions had some.
+type TopCategory struct {
+	Category string   `json:"category"`
+	Total    uint64   `json:"total"`
+	Rows     []TopRow `json:"rows"`
+}
+
+// TopRow is one row of a TopCategory. Share is its fraction of the
+// category's total and Cumulative that of it and the rows above it;
+// Functions is how many functions a TopOther row sums.
+type TopRow struct {
+	Function   string  `json:"function"`
+	Image      string  `json:"image,omitempty"`
+	File       string  `json:"file,omitempty"`
+	Line       int     `json:"line,omitempty"`
+	Count      uint64  `json:"count"`
+	Share      float64 `json:"share"`
+	Cumulative float64 `json:"cumulative"`
+	Functions  int     `json:"functions,omitempty"`
+}
+
+// SetTop ranks the functions of r, recorded with Options.Funcs, by each
+// op type and keeps the n biggest of each in r.Top; n 0 removes it. The
+// writers then show those instead of every function: text and html a
+// table per op type, csv and tsv their rows (the wide layout the
+// functions in any table, by int ops), pprof and dot the rest as the one
+// function TopOther. json keeps the whole report and adds r.Top.
+func (r *Result) SetTop(n int) error {
+	if n < 0 {
+		return fmt.Errorf("profiler: top %d: want a positive number of functions", n)
+	}
+	if n == 0 {
+		r.Top = nil
+		return nil
+	}
+	if r.Functions == nil {
+		return errors.New("profiler: report has no per-function breakdown (record with --funcs)")
+	}
+	values := make([][]uint64, len(r.Functions))
+	for i, f := range r.Functions {
+		v := r.csvValues(f.Counts, f.Vector, f.Wide, f.FP64, f.FP32, f.Memory, f.Custom)
+		values[i] = append([]uint64{f.Sum()}, v...)
+	}
+	t := &Top{N: n}
+	for c, name := range append([]string{"int"}, r.csvOps()...) {
+		var ranked []int
+		var total uint64
+		for i, v := range values {
+			if v[c] > 0 {
+				ranked = append(ranked, i)
+				total += v[c]
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		sort.SliceStable(ranked, func(a, b int) bool { return values[ranked[a]][c] > values[ranked[b]][c] })
+		tc := TopCategory{Category: name, Total: total}
+		var sum uint64
+		row := func(tr TopRow) {
+			sum += tr.Count
+			tr.Share = float64(tr.Count) / float64(total)
+			tr.Cumulative = float64(sum) / float64(total)
+			tc.Rows = append(tc.Rows, tr)
+		}
+		for _, i := range ranked[:min(n, len(ranked))] {
+			f := r.Functions[i]
+			row(TopRow{Function: f.Name, Image: f.Image, File: f.File, Line: f.Line, Count: values[i][c]})
+		}
+		if rest := len(ranked) - n; rest > 0 {
+			row(TopRow{Function: TopOther, Count: total - sum, Functions: rest})
+		}
+		t.Categories = append(t.Categories, tc)
+	}
+	r.Top = t
+	return nil
+}
+
+// names returns the set of functions in any of t's tables.
+func (t *Top) names() map[string]bool {
+	in := map[string]bool{}
+	for _, c := range t.Categories {
+		for _, row := range c.Rows {
+			if row.Function != TopOther {
+				in[row.Function] = true
+			}
+		}
+	}
+	return in
+}
+
+// topFrame returns name, or TopOther when r has a Top that leaves it out.
+func (r *Result) topFrame(in map[string]bool, name string) string {
+	if r.Top == nil || in[name] {
+		return name
+	}
+	return TopOther
+}
+
+// functions describes how many functions a TopOther row sums.
+func (row TopRow) functions() string {
+	if row.Functions == 1 {
+		return "1 function"
+	}
+	return fmt.Sprintf("%d functions", row.Functions)
+}
+
+// writeTop renders a table per op type of t.
+func writeTop(w io.Writer, t *Top) {
+	for _, c := range t.Categories {
+		fmt.Fprintf(w, "\n----- Top %d functions by %s (of %d) -----\n", t.N, strings.ToUpper(c.Category), c.Total)
+		fmt.Fprintf(w, "%14s%8s%8s  FUNCTION\n", "COUNT", "SHARE", "CUM")
+		for _, row := range c.Rows {
+			fmt.Fprintf(w, "%14d%8s%8s  %s", row.Count, fmt.Sprintf("%.1f%%", 100*row.Share),
+				fmt.Sprintf("%.1f%%", 100*row.Cumulative), row.Function)
+			switch {
+			case row.Function == TopOther:
+				fmt.Fprintf(w, "  (%s)", row.functions())
+			case row.File != "":
+				fmt.Fprintf(w, "  (%s:%d)", row.File, row.Line)
+			case row.Image != "":
+				fmt.Fprintf(w, "  [%s]", row.Image)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
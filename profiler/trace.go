@@ -0,0 +1,156 @@
+package profiler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Trace formats (Options.TraceFormat).
+const (
+	TraceOps      = "ops"      // a "TID PC OP REGION" line per op counted
+	TraceChampSim = "champsim" // ChampSim input_instr records
+)
+
+// TraceOp is one op of an Options.Trace file in the ops format.
+type TraceOp struct {
+	Thread int    // Pin's thread number, 0 for the main thread
+	PC     uint64 // the instruction's run-time address; see Result.Images
+	Op     string // add, sub, mul, div, a category of Options.Ops or Options.Classes
+	Region string // the innermost client-API region, "" outside one
+}
+
+// traceFormat returns the format of Options.Trace, ops by default.
+func (o *Options) traceFormat() string {
+	if o.TraceFormat != "" {
+		return o.TraceFormat
+	}
+	return TraceOps
+}
+
+// checkTrace validates the trace options.
+func (o *Options) checkTrace() error {
+	if o.Trace == "" {
+		if o.TraceFormat != "" {
+			return errors.New("profiler: TraceFormat needs Trace")
+		}
+		return nil
+	}
+	if o.Backend != BackendPin {
+		return fmt.Errorf("%w: traces need the pin backend", ErrUnsupported)
+	}
+	if f := o.traceFormat(); f != TraceOps && f != TraceChampSim {
+		return fmt.Errorf("profiler: TraceFormat %q is not %s or %s", f, TraceOps, TraceChampSim)
+	}
+	if o.Sample > 0 && o.Sample < 1 {
+		return errors.New("profiler: Trace excludes Sample")
+	}
+	if strings.HasSuffix(o.Trace, ".xz") {
+		return fmt.Errorf("profiler: Trace %s: only gzip compression (.gz) is built in", o.Trace)
+	}
+	return nil
+}
+
+// tracePath returns the file the tool writes the trace to: Options.Trace,
+// or for a .gz one the file finishTrace compresses into it.
+func (o *Options) tracePath() string {
+	if strings.HasSuffix(o.Trace, ".gz") {
+		return o.Trace + ".part"
+	}
+	return o.Trace
+}
+
+// finishTrace compresses the trace of a run into a .gz Options.Trace.
+func (o *Options) finishTrace() error {
+	if o.Trace == "" || o.tracePath() == o.Trace {
+		return nil
+	}
+	part := o.tracePath()
+	in, err := os.Open(part)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // the tool never started
+	}
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	defer os.Remove(part)
+	defer in.Close()
+	out, err := os.Create(o.Trace)
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return fmt.Errorf("profiler: trace: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("profiler: trace: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	return nil
+}
+
+// ReadTrace calls f with each op of an ops-format Options.Trace file,
+// gzipped or not, in the order they were written: by thread in bursts,
+// since threads buffer their ops. It stops at the first error f returns.
+func ReadTrace(path string, f func(TraceOp) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("profiler: trace %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	regions := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "region "); ok {
+			id, name, _ := strings.Cut(rest, " ")
+			regions[id] = name
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return fmt.Errorf("profiler: trace %s:%d: want TID PC OP REGION", path, n)
+		}
+		tid, err1 := strconv.Atoi(fields[0])
+		pc, err2 := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("profiler: trace %s:%d: bad thread or address", path, n)
+		}
+		op := TraceOp{Thread: tid, PC: pc, Op: fields[2]}
+		if fields[3] != "-" {
+			name, ok := regions[fields[3]]
+			if !ok {
+				return fmt.Errorf("profiler: trace %s:%d: undeclared region %s", path, n, fields[3])
+			}
+			op.Region = name
+		}
+		if err := f(op); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("profiler: trace %s: %w", path, err)
+	}
+	return nil
+}
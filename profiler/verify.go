@@ -0,0 +1,277 @@
+package profiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// VerifyCategories are the categories Verify compares, in report order:
+// those the perf backend's events can measure.
+var VerifyCategories = []string{"instructions", "div", "mul", "fp64", "fp32"}
+
+// Verification compares the counts of an instrumenting backend with the
+// PMU counters of the perf backend over another run of the same workload
+// (iccad verify), to tell how far the counts can be trusted. Neither
+// side is exact: the hardware events count more or other work than the
+// categories (the Intel divider event counts FP divides and square roots
+// as well, an FMA counts 2 in the FP events), so each measured category
+// within TolerancePct is a match and the others list their likely causes.
+type Verification struct {
+	Backend      string           `json:"backend"`
+	TolerancePct float64          `json:"tolerance_pct"`
+	Categories   []VerifyCategory `json:"categories"`
+}
+
+// VerifyCategory is the comparison of one category. Counted is made
+// comparable with the events: instructions is Result.Mix.Instructions,
+// div and mul include the narrower operand sizes of Result.OpWidths (and
+// div the FP divides on Intel), and fp64 and fp32 count an FMA twice.
+// Events names the PMU events behind Hardware; a category not Measured
+// says why in Note. OffPct is relative to Hardware.
+type VerifyCategory struct {
+	Category string   `json:"category"`
+	Measured bool     `json:"measured"`
+	Note     string   `json:"note,omitempty"`
+	Counted  uint64   `json:"counted"`
+	Hardware uint64   `json:"hardware"`
+	Events   []string `json:"events,omitempty"`
+	OffPct   float64  `json:"off_pct"`
+	Causes   []string `json:"causes,omitempty"`
+}
+
+// Off reports whether c was measured and is more than tol percent off.
+func (c VerifyCategory) Off(tol float64) bool {
+	return c.Measured && math.Abs(c.OffPct) > tol
+}
+
+// Verify compares counted, the result of an instrumenting backend, with
+// hw, that of the perf backend, allowing tolerancePct percent.
+func Verify(counted, hw *Result, tolerancePct float64) (*Verification, error) {
+	if hw.Perf == nil {
+		return nil, fmt.Errorf("profiler: the %s result has no PMU counters", hw.Backend)
+	}
+	v := &Verification{Backend: counted.Backend, TolerancePct: tolerancePct}
+	if v.Backend == "" {
+		v.Backend = BackendPin
+	}
+	for _, cat := range VerifyCategories {
+		c := VerifyCategory{Category: cat}
+		scaled := false
+		for _, e := range hw.Perf.Events {
+			if e.Category != cat {
+				continue
+			}
+			if !e.Supported {
+				if c.Note == "" && e.Error != "" {
+					c.Note = fmt.Sprintf("%s: %s", e.Name, e.Error)
+				}
+				continue
+			}
+			c.Events = append(c.Events, e.Name)
+			scaled = scaled || e.Scaled
+			if cat != "fp64" && cat != "fp32" {
+				c.Hardware += e.Value
+			}
+		}
+		switch cat {
+		case "fp64":
+			c.Hardware = hw.Perf.FP64Ops
+		case "fp32":
+			c.Hardware = hw.Perf.FP32Ops
+		}
+		var ok bool
+		c.Counted, ok = verifyCounted(counted, hw, cat)
+		switch {
+		case len(c.Events) == 0:
+			c.Events = nil
+			if c.Note == "" {
+				c.Note = "no PMU event; -perf-event " + cat + "=r<hex> selects one"
+			}
+		case !ok:
+			c.Note = verifyMissing(counted, cat)
+		default:
+			c.Measured, c.Note = true, ""
+			c.OffPct = 100 * (float64(c.Counted) - float64(c.Hardware)) / math.Max(float64(c.Hardware), 1)
+			if c.Off(tolerancePct) {
+				c.Causes = verifyCauses(counted, hw, cat, c.Counted < c.Hardware, scaled)
+			}
+		}
+		v.Categories = append(v.Categories, c)
+	}
+	return v, nil
+}
+
+// intelDivider reports whether hw's div event is Intel's divider event,
+// which counts FP divides too.
+func intelDivider(hw *Result) bool {
+	for _, e := range hw.Perf.Events {
+		if e.Category == "div" && e.Supported && strings.HasPrefix(e.Name, "arith.divider_active") {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCounted returns r's count of cat as the PMU events count it, and
+// whether r has it.
+func verifyCounted(r, hw *Result, cat string) (uint64, bool) {
+	narrow := func(op string) uint64 {
+		m := r.OpWidths[op]
+		return m[8] + m[16] + m[32]
+	}
+	switch cat {
+	case "instructions":
+		if r.Mix == nil {
+			return 0, false
+		}
+		return r.Mix.Instructions, true
+	case "div":
+		n := r.Totals.Div + narrow("div")
+		if intelDivider(hw) {
+			if r.FP == nil {
+				return 0, false
+			}
+			n += r.FP.FP64.Div + r.FP.FP32.Div
+		}
+		return n, true
+	case "mul":
+		return r.Totals.Mul + narrow("mul"), true
+	case "fp64", "fp32":
+		if r.FP == nil {
+			return 0, false
+		}
+		f := r.FP.FP64
+		if cat == "fp32" {
+			f = r.FP.FP32
+		}
+		return f.Sum() + f.FMA, true
+	}
+	return 0, false
+}
+
+// verifyMissing says what r lacks to compare cat.
+func verifyMissing(r *Result, cat string) string {
+	if cat == "instructions" {
+		if r.Backend != "" && r.Backend != BackendPin {
+			return fmt.Sprintf("the %s backend does not count all instructions", r.Backend)
+		}
+		return "counted without -mix"
+	}
+	return "counted without -fp"
+}
+
+// verifyCauses lists the likely causes of counted being off hw in cat:
+// short when it counted less than the hardware.
+func verifyCauses(counted, hw *Result, cat string, short, scaled bool) []string {
+	var causes []string
+	add := func(format string, args ...any) { causes = append(causes, fmt.Sprintf(format, args...)) }
+	if short {
+		if counted.Mode != "whole" {
+			add("only part of the run was counted (mode %s); the hardware counts all of it", counted.Mode)
+		}
+		if counted.Filters != nil {
+			add("uncounted code: the filters left functions or libraries out, which the hardware counts")
+		}
+		if counted.Processes == nil {
+			add("child processes: the hardware counts those the workload starts, -follow-children counts them too")
+		}
+		if counted.Sampling != nil {
+			add("the counts are extrapolated from samples of the run")
+		}
+	}
+	switch cat {
+	case "instructions":
+		if short {
+			kernel := "kernel time: entering and leaving the kernel (system calls, interrupts, page faults) adds user-mode instructions to the hardware count"
+			if s := counted.Syscalls; s != nil {
+				kernel += fmt.Sprintf("; the run made %d system calls", s.Calls)
+			}
+			add("%s", kernel)
+		} else {
+			add("timing-dependent code, such as spin-waits, polling and timer loops, runs longer under instrumentation")
+		}
+	case "div":
+		switch {
+		case short && intelDivider(hw):
+			add("the divider event also counts FP square roots, which are not divides here")
+		case short:
+			add("the divide event may count FP or vector divides too")
+		case intelDivider(hw):
+			add("the divider event counts busy periods: divides issued back to back can count once")
+			if counted.FP != nil && counted.FP.FP64.Div+counted.FP.FP32.Div > 0 {
+				add("vector folding: a packed FP divide counts a lane each here, once in the divider event")
+			}
+		}
+		if short && counted.OpWidths == nil {
+			add("divides narrower than 64 bits are only counted with -widths")
+		}
+	case "mul":
+		if short {
+			add("the multiply event may count FP, vector or address multiplies too")
+			if counted.OpWidths == nil {
+				add("multiplies narrower than 64 bits are only counted with -widths")
+			}
+		}
+	case "fp64", "fp32":
+		if short {
+			add("the FP events also count SQRT, MIN, MAX, RCP14 and RSQRT14, which the FP counts leave out")
+			add("vector folding: the FP events count every lane of a packed instruction, masked-off lanes included")
+		} else {
+			add("FP ops the events do not count, such as x87 ones")
+		}
+	}
+	if scaled {
+		add("multiplexed counters: the kernel scaled the %s event from part of the run", cat)
+	}
+	if counted.Truncated != nil {
+		add("the counted run was cut short: %s", counted.Truncated)
+	}
+	if hw.Truncated != nil {
+		add("the hardware run was cut short: %s", hw.Truncated)
+	}
+	add("run-to-run variation: scheduling, timing and inputs can change the work between the two runs")
+	return causes
+}
+
+// WriteText renders v as a table with the likely causes of the
+// categories off by more than the tolerance.
+func (v *Verification) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "----- Verification: %s counts against PMU counters -----\n", v.Backend)
+	fmt.Fprintf(w, "%-13s%16s%16s%10s  EVENTS\n", "CATEGORY", "COUNTED", "HARDWARE", "OFF")
+	off := 0
+	for _, c := range v.Categories {
+		if !c.Measured {
+			fmt.Fprintf(w, "%-13s%16s%16s%10s  not measured: %s\n", c.Category, "-", "-", "-", c.Note)
+			continue
+		}
+		mark := ""
+		if c.Off(v.TolerancePct) {
+			mark, off = " *", off+1
+		}
+		fmt.Fprintf(w, "%-13s%16d%16d%+9.2f%%  %s%s\n", c.Category, c.Counted, c.Hardware, c.OffPct,
+			strings.Join(c.Events, ", "), mark)
+	}
+	if off == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nLikely causes of the differences over %.4g%% (*):\n", v.TolerancePct)
+	for _, c := range v.Categories {
+		if !c.Off(v.TolerancePct) {
+			continue
+		}
+		fmt.Fprintf(w, "  %s:\n", c.Category)
+		for _, cause := range c.Causes {
+			fmt.Fprintf(w, "    - %s\n", cause)
+		}
+	}
+}
+
+// WriteJSON writes v as indented JSON.
+func (v *Verification) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
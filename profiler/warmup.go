@@ -0,0 +1,31 @@
+package profiler
+
+import "fmt"
+
+// Warm-up modes.
+const (
+	WarmupTime   = "time" // Options.Warmup
+	WarmupOps    = "ops"  // Options.WarmupOps
+	WarmupSteady = "auto" // Options.SteadyState
+)
+
+// Warmup describes the warm-up phase a run discarded. When it did not end
+// before the target exited (Reached false) the counts include it.
+type Warmup struct {
+	Mode       string         `json:"mode"` // WarmupTime, WarmupOps or WarmupSteady
+	Reached    bool           `json:"reached"`
+	ElapsedSec float64        `json:"elapsed_sec,omitempty"` // into the run
+	Excluded   SnapshotCounts `json:"excluded,omitempty"`    // the discarded counts
+}
+
+func (w *Warmup) String() string {
+	if !w.Reached {
+		return "not over at exit; the counts include it"
+	}
+	return fmt.Sprintf("first %.1f s excluded (%s)", w.ElapsedSec, w.Mode)
+}
+
+// warming reports whether a warm-up phase is discarded.
+func (o *Options) warming() bool {
+	return o.Warmup != 0 || o.WarmupOps != 0 || o.SteadyState
+}
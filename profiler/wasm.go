@@ -0,0 +1,773 @@
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wasmInsns lists the instruction names the wasm backend reports under
+// each arithmetic category.
+var wasmInsns = map[string][]string{
+	"add": {"i64.add"},
+	"sub": {"i64.sub"},
+	"mul": {"i64.mul"},
+	"div": {"i64.div_s", "i64.div_u", "i64.rem_s", "i64.rem_u"},
+}
+
+// wasmArch is the staticArch of WebAssembly modules. Instructions are
+// classified while the module is instrumented, so it has no decoder.
+var wasmArch = staticArch{name: "wasm", insns: wasmInsns, sign: signWasm}
+
+// wasmOps classifies the single-byte numeric opcodes. As on the native
+// ISAs only 64-bit integer arithmetic is in the built-in categories: the
+// i32 forms are the i32_* classes. Remainders count as divides.
+var wasmOps = map[byte]staticOp{
+	0x7c: {"add", "i64.add", 1}, 0x7d: {"sub", "i64.sub", 1}, 0x7e: {"mul", "i64.mul", 1},
+	0x7f: {"div", "i64.div_s", 1}, 0x80: {"div", "i64.div_u", 1},
+	0x81: {"div", "i64.rem_s", 1}, 0x82: {"div", "i64.rem_u", 1},
+	0x83: {"and", "i64.and", 1}, 0x84: {"or", "i64.or", 1}, 0x85: {"xor", "i64.xor", 1},
+	0x86: {"shl", "i64.shl", 1}, 0x87: {"shr", "i64.shr_s", 1}, 0x88: {"shr", "i64.shr_u", 1},
+	0x89: {"rol", "i64.rotl", 1}, 0x8a: {"rol", "i64.rotr", 1},
+	0x92: {"fp32_add", "f32.add", 1}, 0x93: {"fp32_sub", "f32.sub", 1},
+	0x94: {"fp32_mul", "f32.mul", 1}, 0x95: {"fp32_div", "f32.div", 1},
+	0xa0: {"fp64_add", "f64.add", 1}, 0xa1: {"fp64_sub", "f64.sub", 1},
+	0xa2: {"fp64_mul", "f64.mul", 1}, 0xa3: {"fp64_div", "f64.div", 1},
+}
+
+// wasmSIMDOps classifies the 0xFD-prefixed SIMD opcodes of the lanes the
+// other backends count: i64x2 integer and f32x4/f64x2 arithmetic, and the
+// relaxed-SIMD fused multiply-adds.
+var wasmSIMDOps = map[uint32]staticOp{
+	206: {"vec_add", "i64x2.add", 2}, 209: {"vec_sub", "i64x2.sub", 2}, 213: {"vec_mul", "i64x2.mul", 2},
+	228: {"fp32_add", "f32x4.add", 4}, 229: {"fp32_sub", "f32x4.sub", 4},
+	230: {"fp32_mul", "f32x4.mul", 4}, 231: {"fp32_div", "f32x4.div", 4},
+	240: {"fp64_add", "f64x2.add", 2}, 241: {"fp64_sub", "f64x2.sub", 2},
+	242: {"fp64_mul", "f64x2.mul", 2}, 243: {"fp64_div", "f64x2.div", 2},
+	0x105: {"fp32_fma", "f32x4.relaxed_madd", 4}, 0x107: {"fp64_fma", "f64x2.relaxed_madd", 2},
+}
+
+// wasmI32Classes are the classes the wasm backend always counts: the i32
+// integer arithmetic, as 32-bit code is common in wasm32 kernels.
+var wasmI32Classes = []Classifier{
+	{Name: "i32_add", Match: matchWasm(0x6a)},
+	{Name: "i32_sub", Match: matchWasm(0x6b)},
+	{Name: "i32_mul", Match: matchWasm(0x6c)},
+	{Name: "i32_div", Match: matchWasm(0x6d, 0x6e, 0x6f, 0x70)},
+}
+
+// matchWasm returns a Match for single-byte wasm opcodes.
+func matchWasm(ops ...byte) func(string, []byte) bool {
+	return func(arch string, insn []byte) bool {
+		return arch == "wasm" && len(insn) > 0 && bytes.IndexByte(ops, insn[0]) >= 0
+	}
+}
+
+// wasmBuf reads the LEB128 and byte fields of a module; the first
+// malformed or truncated field sets err and later reads return zero.
+type wasmBuf struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *wasmBuf) fail(what string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("profiler: malformed wasm module: %s at offset %d", what, r.off)
+	}
+}
+
+func (r *wasmBuf) byte() byte {
+	if r.err != nil || r.off >= len(r.b) {
+		r.fail("truncated")
+		return 0
+	}
+	r.off++
+	return r.b[r.off-1]
+}
+
+func (r *wasmBuf) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b)-r.off < n {
+		r.fail("truncated")
+		return nil
+	}
+	r.off += n
+	return r.b[r.off-n : r.off]
+}
+
+// uleb reads an unsigned LEB128 number.
+func (r *wasmBuf) uleb() uint64 {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		c := r.byte()
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	r.fail("LEB128 too long")
+	return 0
+}
+
+func (r *wasmBuf) u32() int { return int(uint32(r.uleb())) }
+
+// sleb skips a signed LEB128 number and returns its first byte.
+func (r *wasmBuf) sleb() byte {
+	first := r.off
+	r.uleb()
+	if r.err != nil {
+		return 0
+	}
+	return r.b[first]
+}
+
+func (r *wasmBuf) name() string { return string(r.bytes(r.u32())) }
+
+// limits skips the limits of a table or memory type.
+func (r *wasmBuf) limits() {
+	flags := r.byte()
+	r.uleb()
+	if flags&1 != 0 {
+		r.uleb()
+	}
+}
+
+// valtype skips a value type, typed references included.
+func (r *wasmBuf) valtype() {
+	if t := r.byte(); t == 0x63 || t == 0x64 {
+		r.sleb()
+	}
+}
+
+// memarg skips a memory operand: alignment (with a memory index when bit
+// 6 is set) and offset.
+func (r *wasmBuf) memarg() {
+	if r.u32()&0x40 != 0 {
+		r.u32()
+	}
+	r.uleb()
+}
+
+// wasmSection is a section of a module, its payload without the header.
+type wasmSection struct {
+	id   byte
+	data []byte
+}
+
+// wasmModule is a parsed module: its sections in file order and the index
+// spaces the instrumentation extends.
+type wasmModule struct {
+	sections       []wasmSection
+	types          int      // entries of the type section
+	importedFuncs  int      // imports come first in the function index space
+	importedGlobal int      // and in the global index space
+	funcTypes      []int    // type of each defined function
+	globals        int      // defined globals
+	names          []string // of every function, imported ones included
+	code           []byte   // code section payload
+}
+
+// parseWasm splits a binary module into sections and reads the function
+// names from the name section or, failing that, the exports.
+func parseWasm(b []byte) (*wasmModule, error) {
+	if len(b) < 8 || string(b[:4]) != "\x00asm" {
+		return nil, errors.New("profiler: not a WebAssembly module")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:]); v != 1 {
+		return nil, fmt.Errorf("%w: wasm binary version %d (components are not modules)", ErrUnsupported, v)
+	}
+	m := &wasmModule{}
+	r := &wasmBuf{b: b, off: 8}
+	exports := map[int]string{}
+	var funcNames map[int]string
+	for r.off < len(b) && r.err == nil {
+		id := r.byte()
+		data := r.bytes(r.u32())
+		if r.err != nil {
+			break
+		}
+		m.sections = append(m.sections, wasmSection{id, data})
+		s := &wasmBuf{b: data}
+		switch id {
+		case 0:
+			if s.name() == "name" && s.err == nil {
+				funcNames = wasmFuncNames(s)
+			}
+		case 1:
+			m.types = s.u32()
+		case 2:
+			for n := s.u32(); n > 0 && s.err == nil; n-- {
+				s.name()
+				s.name()
+				switch s.byte() {
+				case 0:
+					s.u32()
+					m.importedFuncs++
+				case 1:
+					s.valtype()
+					s.limits()
+				case 2:
+					s.limits()
+				case 3:
+					s.valtype()
+					s.byte()
+					m.importedGlobal++
+				case 4:
+					s.byte()
+					s.u32()
+				default:
+					s.fail("import kind")
+				}
+			}
+		case 3:
+			for n := s.u32(); n > 0 && s.err == nil; n-- {
+				m.funcTypes = append(m.funcTypes, s.u32())
+			}
+		case 6:
+			m.globals = s.u32()
+		case 7:
+			for n := s.u32(); n > 0 && s.err == nil; n-- {
+				name := s.name()
+				if kind, idx := s.byte(), s.u32(); kind == 0 {
+					exports[idx] = name
+				}
+			}
+		case 10:
+			m.code = data
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	m.names = make([]string, m.importedFuncs+len(m.funcTypes))
+	for i := range m.names {
+		switch {
+		case funcNames[i] != "":
+			m.names[i] = funcNames[i]
+		case exports[i] != "":
+			m.names[i] = exports[i]
+		default:
+			m.names[i] = fmt.Sprintf("func[%d]", i)
+		}
+	}
+	return m, nil
+}
+
+// wasmFuncNames reads the function names subsection of a name section.
+func wasmFuncNames(s *wasmBuf) map[int]string {
+	for s.off < len(s.b) && s.err == nil {
+		id := s.byte()
+		sub := &wasmBuf{b: s.bytes(s.u32())}
+		if id != 1 {
+			continue
+		}
+		names := map[int]string{}
+		for n := sub.u32(); n > 0 && sub.err == nil; n-- {
+			idx := sub.u32()
+			names[idx] = sub.name()
+		}
+		if sub.err == nil {
+			return names
+		}
+	}
+	return nil
+}
+
+// wasmInsn decodes the instruction at r.off, skipping its immediates, and
+// returns its opcode: the byte, or prefix<<16 | sub-opcode for the 0xFC,
+// 0xFD and 0xFE prefixes.
+func (r *wasmBuf) wasmInsn() uint32 {
+	op := r.byte()
+	switch {
+	case op == 0x02 || op == 0x03 || op == 0x04 || op == 0x06: // block, loop, if, try
+		r.sleb()
+	case op >= 0x07 && op <= 0x09, op >= 0x0c && op <= 0x0d, op == 0x10, op == 0x12, op == 0x18,
+		op >= 0x20 && op <= 0x26, op == 0xd2: // tag, label, function, local, global or table index
+		r.u32()
+	case op == 0x0e: // br_table
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.u32()
+		}
+		r.u32()
+	case op == 0x11 || op == 0x13: // call_indirect, return_call_indirect
+		r.u32()
+		r.u32()
+	case op == 0x1c: // select t*
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.valtype()
+		}
+	case op >= 0x28 && op <= 0x3e:
+		r.memarg()
+	case op == 0x3f || op == 0x40:
+		r.u32()
+	case op == 0x41 || op == 0x42:
+		r.sleb()
+	case op == 0x43:
+		r.bytes(4)
+	case op == 0x44:
+		r.bytes(8)
+	case op == 0xd0: // ref.null ht
+		r.sleb()
+	case op == 0xfc:
+		sub := uint32(r.u32())
+		switch {
+		case sub == 8 || sub == 10 || sub == 12 || sub == 14:
+			r.u32()
+			r.u32()
+		case sub >= 9 && sub <= 17:
+			r.u32()
+		case sub > 17:
+			r.fail(fmt.Sprintf("opcode 0xfc %d", sub))
+		}
+		return 0xfc<<16 | sub
+	case op == 0xfd:
+		sub := uint32(r.u32())
+		switch {
+		case sub <= 11 || sub == 92 || sub == 93:
+			r.memarg()
+		case sub == 12 || sub == 13:
+			r.bytes(16)
+		case sub >= 21 && sub <= 34:
+			r.byte()
+		case sub >= 84 && sub <= 91:
+			r.memarg()
+			r.byte()
+		}
+		return 0xfd<<16 | sub
+	case op == 0xfe:
+		sub := uint32(r.u32())
+		if sub == 3 {
+			r.byte()
+		} else {
+			r.memarg()
+		}
+		return 0xfe<<16 | sub
+	case op == 0x00 || op == 0x01 || op == 0x05 || op == 0x0b || op == 0x0f || op == 0x19 ||
+		op == 0x1a || op == 0x1b || op >= 0x45 && op <= 0xc4 || op == 0xd1:
+	default:
+		r.fail(fmt.Sprintf("opcode %#x", op))
+	}
+	return uint32(op)
+}
+
+// wasmEndsRegion reports whether the instruction after op starts a new
+// straight-line region: op enters or leaves a block body, or branches.
+func wasmEndsRegion(op uint32) bool {
+	switch op {
+	case 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x00, 0x12, 0x13, 0x18, 0x19:
+		return true
+	}
+	return false
+}
+
+// wasmInsnOp is a decoded instruction of a region: its classification, if
+// any, and its encoding for the classes.
+type wasmInsnOp struct {
+	op   staticOp
+	ok   bool
+	insn []byte
+}
+
+// wasmRegion is a straight-line run of instructions of one function that
+// executes as a whole, with its counted instructions.
+type wasmRegion struct {
+	fn    int // staticTally function id
+	insns []wasmInsnOp
+}
+
+// wasmCounterChunk is the number of counters each exported
+// __iccad_count_<k> function returns: optimizing compilers take
+// superlinear time over one br_table of every counter. wasmHarness has
+// it too.
+const wasmCounterChunk = 256
+
+// instrument returns m with a mutable i64 global counting the executions
+// of every region of counted instructions, incremented at the region's
+// start, and exported functions __iccad_count_<k>(i) returning counter
+// k*wasmCounterChunk+i. Only the functions for which keep is true are
+// instrumented; fn maps a function index to its staticTally id.
+func (m *wasmModule) instrument(classes []Classifier, keep func(idx int) bool, fn func(idx int) int) ([]byte, []wasmRegion, error) {
+	r := &wasmBuf{b: m.code}
+	n := 0
+	if m.code != nil {
+		n = r.u32()
+	}
+	if n != len(m.funcTypes) {
+		return nil, nil, fmt.Errorf("profiler: malformed wasm module: %d function bodies for %d functions", n, len(m.funcTypes))
+	}
+	var regions []wasmRegion
+	var code bytes.Buffer
+	for i := 0; i < n && r.err == nil; i++ {
+		idx := m.importedFuncs + i
+		size := r.u32()
+		body := &wasmBuf{b: r.bytes(size)}
+		if r.err != nil || !keep(idx) {
+			code.Write(uleb(uint64(size)))
+			code.Write(body.b)
+			continue
+		}
+		for k := body.u32(); k > 0 && body.err == nil; k-- {
+			body.u32()
+			body.valtype()
+		}
+		var out bytes.Buffer
+		out.Write(body.b[:body.off])
+		// decode into regions, emitting each with its counter in front
+		var cur []wasmInsnOp
+		start := body.off
+		flush := func(end int) {
+			if len(cur) > 0 {
+				regions = append(regions, wasmRegion{fn: fn(idx), insns: cur})
+				global := m.importedGlobal + m.globals + len(regions) - 1
+				out.Write(counterIncr(global))
+			}
+			out.Write(body.b[start:end])
+			cur, start = nil, end
+		}
+		for body.off < len(body.b) && body.err == nil {
+			at := body.off
+			opc := body.wasmInsn()
+			if body.err != nil {
+				break
+			}
+			insn := body.b[at:body.off]
+			var op staticOp
+			ok := false
+			switch {
+			case opc < 0x100:
+				op, ok = wasmOps[byte(opc)]
+			case opc>>16 == 0xfd:
+				op, ok = wasmSIMDOps[opc&0xffff]
+			}
+			matched := false
+			for _, c := range classes {
+				matched = matched || c.Match(wasmArch.name, insn)
+			}
+			if ok || matched {
+				cur = append(cur, wasmInsnOp{op, ok, insn})
+			}
+			if wasmEndsRegion(opc) {
+				flush(body.off)
+			}
+		}
+		if body.err != nil {
+			return nil, nil, fmt.Errorf("%w in %s", body.err, m.names[idx])
+		}
+		if start < len(body.b) {
+			flush(len(body.b))
+		}
+		code.Write(uleb(uint64(out.Len())))
+		code.Write(out.Bytes())
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if len(regions) > 1000000-m.importedGlobal-m.globals {
+		return nil, nil, fmt.Errorf("%w: %d counted regions, more than wasm engines allow globals", ErrUnsupported, len(regions))
+	}
+	chunks := (len(regions) + wasmCounterChunk - 1) / wasmCounterChunk
+	for k := 0; k < chunks; k++ {
+		first := k * wasmCounterChunk
+		getter := counterGetter(m.importedGlobal+m.globals+first, min(wasmCounterChunk, len(regions)-first))
+		code.Write(uleb(uint64(len(getter))))
+		code.Write(getter)
+	}
+	return m.rebuild(len(regions), chunks, append(uleb(uint64(n+chunks)), code.Bytes()...)), regions, nil
+}
+
+// counterIncr returns the code adding 1 to global g.
+func counterIncr(g int) []byte {
+	idx := uleb(uint64(g))
+	b := append([]byte{0x23}, idx...)
+	b = append(b, 0x42, 0x01, 0x7c, 0x24)
+	return append(b, idx...)
+}
+
+// counterGetter returns the body of the (i32) -> i64 function returning
+// counter i, the global first+i, or 0 out of range: a br_table out of n+1
+// nested blocks into a global.get each.
+func counterGetter(first, n int) []byte {
+	b := []byte{0x00} // no locals
+	for i := 0; i <= n; i++ {
+		b = append(b, 0x02, 0x40)
+	}
+	b = append(b, 0x20, 0x00, 0x0e)
+	b = append(b, uleb(uint64(n))...)
+	for i := 0; i <= n; i++ {
+		b = append(b, uleb(uint64(i))...)
+	}
+	for i := 0; i < n; i++ {
+		b = append(b, 0x0b, 0x23)
+		b = append(b, uleb(uint64(first+i))...)
+		b = append(b, 0x0f)
+	}
+	return append(b, 0x0b, 0x42, 0x00, 0x0b)
+}
+
+// rebuild returns the module with counters globals, the getters
+// functions of type (i32) -> i64 and their exports, and code as the code
+// section.
+func (m *wasmModule) rebuild(counters, getters int, code []byte) []byte {
+	vec := func(data []byte, add int, extra []byte) []byte {
+		r := &wasmBuf{b: data}
+		n := 0
+		if data != nil {
+			n = r.u32()
+		}
+		out := uleb(uint64(n + add))
+		out = append(out, data[r.off:]...)
+		return append(out, extra...)
+	}
+	var globals []byte
+	for i := 0; i < counters; i++ {
+		globals = append(globals, 0x7e, 0x01, 0x42, 0x00, 0x0b)
+	}
+	var funcs, exports []byte
+	for k := 0; k < getters; k++ {
+		funcs = append(funcs, uleb(uint64(m.types))...)
+		name := fmt.Sprintf("__iccad_count_%d", k)
+		exports = append(append(exports, uleb(uint64(len(name)))...), name...)
+		exports = append(append(exports, 0x00), uleb(uint64(m.importedFuncs+len(m.funcTypes)+k))...)
+	}
+
+	repl := map[byte][]byte{
+		1:  vec(m.section(1), 1, []byte{0x60, 0x01, 0x7f, 0x01, 0x7e}),
+		3:  vec(m.section(3), getters, funcs),
+		6:  vec(m.section(6), counters, globals),
+		7:  vec(m.section(7), getters, exports),
+		10: code,
+	}
+	// sections in the order of the spec, the custom ones kept in place
+	order := map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	emit := func(id byte, data []byte) {
+		out = append(out, id)
+		out = append(out, uleb(uint64(len(data)))...)
+		out = append(out, data...)
+	}
+	for _, s := range m.sections {
+		if s.id != 0 {
+			for _, id := range []byte{1, 3, 6, 7, 10} {
+				if data, ok := repl[id]; ok && order[id] < order[s.id] && m.section(id) == nil {
+					emit(id, data)
+					delete(repl, id)
+				}
+			}
+		}
+		if data, ok := repl[s.id]; ok {
+			emit(s.id, data)
+			delete(repl, s.id)
+			continue
+		}
+		emit(s.id, s.data)
+	}
+	for _, id := range []byte{1, 3, 6, 7, 10} {
+		if data, ok := repl[id]; ok {
+			emit(id, data)
+		}
+	}
+	return out
+}
+
+// section returns the payload of the first section id, or nil.
+func (m *wasmModule) section(id byte) []byte {
+	for _, s := range m.sections {
+		if s.id == id {
+			return s.data
+		}
+	}
+	return nil
+}
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// wasmHarness runs an instrumented WASI module under Node.js and writes
+// its region counters, one per line, when it returns or exits:
+// node harness.js <counters file> <count> <module> <argv0> [args…].
+const wasmHarness = `'use strict';
+const CHUNK = 256; // wasmCounterChunk
+const fs = require('fs');
+const { WASI } = require('wasi');
+const [out, n, mod, ...argv] = process.argv.slice(2);
+const wasi = new WASI({
+  version: 'preview1', args: argv, env: process.env,
+  preopens: { '/': '/', '.': '.' }, returnOnExit: true,
+});
+const inst = new WebAssembly.Instance(new WebAssembly.Module(fs.readFileSync(mod)),
+  { wasi_snapshot_preview1: wasi.wasiImport });
+let code = 1;
+try {
+  code = wasi.start(inst);
+} finally {
+  const lines = [];
+  for (let i = 0; i < +n; i++) {
+    lines.push(inst.exports['__iccad_count_' + Math.floor(i / CHUNK)](i % CHUNK).toString());
+  }
+  fs.writeFileSync(out, lines.join('\n') + '\n');
+}
+process.exitCode = code;
+`
+
+// runWASM instruments cmd[0], a WASI command module, with a counter per
+// straight-line region of code, runs it under Node.js and classifies its
+// executed instructions like the static backend.
+func (p *Profiler) runWASM(ctx context.Context, cmd []string) (*Result, error) {
+	start := time.Now()
+	path, err := filepath.Abs(cmd[0])
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	m, err := parseWasm(b)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{
+		SchemaVersion: SchemaVersion,
+		Tool:          "iccad-wasm",
+		Backend:       BackendWASM,
+		Arch:          wasmArch.name,
+		Binary:        Binary{Path: path, Args: cmd},
+		Mode:          "whole",
+		Categories:    Categories{},
+	}
+	only := -1
+	if p.opts.Func != "" {
+		for i, name := range m.names {
+			if name == p.opts.Func && i >= m.importedFuncs {
+				only = i
+			}
+		}
+		if only < 0 {
+			return nil, fmt.Errorf("%w: %s in %s", ErrSymbolNotFound, p.opts.Func, path)
+		}
+		res.Mode = "address"
+		res.Region = &Region{Addr: fmt.Sprintf("func[%d]", only)}
+	}
+
+	t := p.newStaticTally(wasmArch, res)
+	ids := map[int]int{}
+	mod, regions, err := m.instrument(t.classes,
+		func(idx int) bool { return only < 0 || idx == only },
+		func(idx int) int {
+			id, ok := ids[idx]
+			if !ok {
+				id = t.addFunc(m.names[idx])
+				ids[idx] = id
+			}
+			return id
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	node := p.opts.WASMRuntime
+	if node == "" {
+		node = "node"
+	}
+	if node, err = exec.LookPath(node); err != nil {
+		return nil, fmt.Errorf("profiler: wasm backend: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "int64wasm-")
+	if err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	instrumented, harness, out := filepath.Join(dir, "module.wasm"), filepath.Join(dir, "harness.js"), filepath.Join(dir, "counts.txt")
+	if err := os.WriteFile(instrumented, mod, 0o644); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+	if err := os.WriteFile(harness, []byte(wasmHarness), 0o644); err != nil {
+		return nil, fmt.Errorf("profiler: %w", err)
+	}
+
+	args := append([]string{"--no-warnings", "--stack-size=8192", harness, out, strconv.Itoa(len(regions)), instrumented, path}, cmd[1:]...)
+	capt := newCapture(&p.opts)
+	stdout, stderr := capt.writers(p.opts.Stdout, p.opts.Stderr)
+	c := exec.CommandContext(ctx, node, args...)
+	c.Stdin, c.Stdout, c.Stderr = p.opts.Stdin, stdout, stderr
+	c.Env, c.Dir = p.opts.Env, p.opts.Dir
+	runErr := p.opts.run(c)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if c.Process != nil {
+		res.Binary.Pid = c.Process.Pid
+	}
+
+	if err := wasmCounts(out, regions, t); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("profiler: run %s: %w", cmd[0], runErr)
+		}
+		return nil, err
+	}
+	t.finish(path)
+	res.WallTimeSec = time.Since(start).Seconds()
+	res.Output = capt.output()
+	if runErr != nil {
+		return res, targetErr(res, cmd[0], runErr)
+	}
+	return res, nil
+}
+
+// wasmCounts tallies the instructions of every region as often as the
+// harness's counters at name say it executed.
+func wasmCounts(name string, regions []wasmRegion, t *staticTally) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: wasm harness wrote no counters", ErrNoReport)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	i := 0
+	for ; sc.Scan() && i < len(regions); i++ {
+		execs, err := strconv.ParseUint(strings.TrimSpace(sc.Text()), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: wasm counter %d: %v", ErrNoReport, i, err)
+		}
+		if execs == 0 {
+			continue
+		}
+		fn := regions[i].fn
+		for _, in := range regions[i].insns {
+			if in.ok {
+				t.count(in.op, fn, execs)
+				t.signed(in.insn, in.op, fn, execs)
+			}
+			t.classify(in.insn, fn, execs)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("profiler: %w", err)
+	}
+	if i < len(regions) {
+		return fmt.Errorf("%w: %d of %d wasm counters", ErrNoReport, i, len(regions))
+	}
+	return nil
+}
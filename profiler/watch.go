@@ -0,0 +1,163 @@
+package profiler
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A watchpoint is one entry of Options.Watch: a custom category counting
+// the accesses to a range of the main executable's data.
+type watchpoint struct {
+	name   string
+	symbol string // else addr
+	addr   uint64 // link-time
+	size   uint64 // 0: the symbol's
+	mode   string // r, w or rw
+}
+
+// parseWatch parses an Options.Watch entry, [name=]target[/size][:mode]:
+// target is a data symbol or a 0x-prefixed link-time address, size the
+// bytes watched (the symbol's size, or 8 at an address, by default) and
+// mode r, w (the default) or rw. The name defaults to the symbol's.
+func parseWatch(spec string) (watchpoint, error) {
+	w := watchpoint{mode: "w"}
+	rest := spec
+	if i := strings.LastIndexByte(rest, ':'); i >= 0 {
+		switch m := rest[i+1:]; m {
+		case "r", "w", "rw":
+			w.mode, rest = m, rest[:i]
+		}
+	}
+	if name, target, ok := strings.Cut(rest, "="); ok {
+		w.name, rest = name, target
+	}
+	if i := strings.LastIndexByte(rest, '/'); i >= 0 {
+		n, err := strconv.ParseUint(rest[i+1:], 0, 64)
+		if err != nil || n == 0 {
+			return w, fmt.Errorf("profiler: watch %q: bad size %q", spec, rest[i+1:])
+		}
+		w.size, rest = n, rest[:i]
+	}
+	switch {
+	case rest == "":
+		return w, fmt.Errorf("profiler: watch %q: no symbol or address", spec)
+	case strings.HasPrefix(rest, "0x") || strings.HasPrefix(rest, "0X"):
+		a, err := strconv.ParseUint(rest[2:], 16, 64)
+		if err != nil {
+			return w, fmt.Errorf("profiler: watch %q: bad address %q", spec, rest)
+		}
+		w.addr = a
+		if w.size == 0 {
+			w.size = 8
+		}
+		if w.name == "" {
+			return w, fmt.Errorf("profiler: watch %q: an address needs a name=", spec)
+		}
+	default:
+		w.symbol = rest
+		if w.name == "" {
+			w.name = rest
+		}
+	}
+	if err := checkClassifier(Classifier{Name: w.name}); err != nil {
+		return w, fmt.Errorf("profiler: watch %q: %v", spec, strings.TrimPrefix(err.Error(), "profiler: classifier "))
+	}
+	return w, nil
+}
+
+// resolveWatch parses Options.Watch for backend next to the resolved
+// classes, whose names its categories must not take.
+func resolveWatch(specs []string, backend string, classes []Classifier) ([]watchpoint, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	if backend != BackendPin {
+		return nil, fmt.Errorf("%w: watchpoints need the pin backend", ErrUnsupported)
+	}
+	seen := map[string]bool{}
+	for _, c := range classes {
+		seen[c.Name] = true
+	}
+	var out []watchpoint
+	for _, s := range specs {
+		w, err := parseWatch(s)
+		if err != nil {
+			return nil, err
+		}
+		if seen[w.name] {
+			return nil, fmt.Errorf("profiler: watch %s: category given twice", w.name)
+		}
+		seen[w.name] = true
+		out = append(out, w)
+	}
+	if len(classes)+len(out) > maxPinClasses {
+		return nil, fmt.Errorf("profiler: the pintool counts at most %d classes and watchpoints", maxPinClasses)
+	}
+	return out, nil
+}
+
+// watchArgs returns the pintool knobs of watches, looking their symbols
+// up in the ELF executable at path or its debug file.
+func watchArgs(watches []watchpoint, path string, dirs []string) ([]string, error) {
+	var syms []elf.Symbol
+	var args []string
+	for _, w := range watches {
+		if w.symbol != "" {
+			if syms == nil {
+				var err error
+				if syms, err = dataSymbols(path, dirs); err != nil {
+					return nil, err
+				}
+			}
+			s, ok := findData(syms, w.symbol)
+			if !ok {
+				return nil, fmt.Errorf("profiler: watch %s: no data symbol %s in %s", w.name, w.symbol, path)
+			}
+			w.addr = s.Value
+			if w.size == 0 {
+				if w.size = s.Size; w.size == 0 {
+					w.size = 8
+				}
+			}
+		}
+		args = append(args, "-watch", fmt.Sprintf("%s=%#x:%d:%s", w.name, w.addr, w.size, w.mode))
+	}
+	return args, nil
+}
+
+// dataSymbols returns the symbols of the ELF file at path, those of its
+// debug file when it has one.
+func dataSymbols(path string, dirs []string) ([]elf.Symbol, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: watchpoints need an ELF executable: %v", ErrUnsupported, err)
+	}
+	defer f.Close()
+	if df, _ := withDebug(f, path, "", dirs); df != f {
+		defer df.Close()
+		f = df
+	}
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("profiler: %s: %w", path, err)
+	}
+	if syms == nil {
+		syms = []elf.Symbol{}
+	}
+	return syms, nil
+}
+
+// findData returns the data object called name among syms.
+func findData(syms []elf.Symbol, name string) (elf.Symbol, bool) {
+	for _, s := range syms {
+		if s.Name == name && s.Value != 0 && s.Section != elf.SHN_UNDEF {
+			if t := elf.ST_TYPE(s.Info); t == elf.STT_OBJECT || t == elf.STT_COMMON {
+				return s, true
+			}
+		}
+	}
+	return elf.Symbol{}, false
+}
@@ -0,0 +1,125 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OperandWidths lists the operand sizes, in bits, Options.Widths may ask for.
+var OperandWidths = []int{8, 16, 32, 64, 128}
+
+// OpWidths breaks the integer operations down by operand size
+// (Options.Widths): category ("add", "sub", "mul", "div" and the selected
+// bitwise ones) to bits to count, for the sizes asked for. An instruction
+// is tallied under its register and memory operands' size when the
+// category would count it with 64-bit ones, so 8, 16 and 32 hold the
+// narrower operations the totals leave out. The 64-bit multiplies and
+// divides of double width (MUL, one-operand IMUL, MULX, DIV, IDIV) are
+// under 128, and 64 and 128 together make up the category's total, less
+// the LEAs of CompoundSplit and the address arithmetic of AgenFold.
+type OpWidths map[string]map[int]uint64
+
+// Categories returns the categories in w in report order.
+func (w OpWidths) Categories() []string {
+	var cats []string
+	for _, c := range append([]string{"add", "sub", "mul", "div"}, BitCategoryNames...) {
+		if _, ok := w[c]; ok {
+			cats = append(cats, c)
+		}
+	}
+	return cats
+}
+
+// Bits returns the operand sizes counted in w, ascending.
+func (w OpWidths) Bits() []int {
+	seen := map[int]bool{}
+	for _, m := range w {
+		for bits := range m {
+			seen[bits] = true
+		}
+	}
+	bits := make([]int, 0, len(seen))
+	for b := range seen {
+		bits = append(bits, b)
+	}
+	sort.Ints(bits)
+	return bits
+}
+
+// ByWidth returns the operations of each size, summed over the categories.
+func (w OpWidths) ByWidth() map[int]uint64 {
+	n := map[int]uint64{}
+	for _, m := range w {
+		for bits, c := range m {
+			n[bits] += c
+		}
+	}
+	return n
+}
+
+// checkWidths validates Options.Widths.
+func (o *Options) checkWidths() error {
+	if len(o.Widths) == 0 {
+		return nil
+	}
+	for _, bits := range o.Widths {
+		ok := false
+		for _, b := range OperandWidths {
+			ok = ok || bits == b
+		}
+		if !ok {
+			return fmt.Errorf("profiler: operand width %d is not 8, 16, 32, 64 or 128", bits)
+		}
+	}
+	if o.Backend != BackendPin {
+		return fmt.Errorf("%w: %s backend does not decode operand sizes", ErrUnsupported, o.Backend)
+	}
+	return nil
+}
+
+// widthsArg returns the pintool's -widths value for bits.
+func widthsArg(bits []int) string {
+	s := make([]string, len(bits))
+	for i, b := range bits {
+		s[i] = fmt.Sprint(b)
+	}
+	return strings.Join(s, ",")
+}
+
+// writeOpWidths renders the operand sizes of r's categories and, with a
+// per-function breakdown, of each function's operations.
+func writeOpWidths(w io.Writer, r *Result) {
+	bits := r.OpWidths.Bits()
+	header := func() {
+		fmt.Fprintf(w, "%6s", "")
+		for _, b := range bits {
+			fmt.Fprintf(w, "%14s", fmt.Sprintf("%d-bit", b))
+		}
+	}
+	fmt.Fprintf(w, "\n----- Operand widths -----\n")
+	header()
+	fmt.Fprintln(w)
+	for _, c := range r.OpWidths.Categories() {
+		fmt.Fprintf(w, "%6s", strings.ToUpper(c))
+		for _, b := range bits {
+			fmt.Fprintf(w, "%14d", r.OpWidths[c][b])
+		}
+		fmt.Fprintln(w)
+	}
+	if r.Functions == nil {
+		return
+	}
+	fmt.Fprintf(w, "\n----- Operand widths by function -----\n")
+	header()
+	fmt.Fprintf(w, "  FUNCTION\n")
+	for _, f := range r.Functions {
+		n := f.OpWidths.ByWidth()
+		fmt.Fprintf(w, "%6s", "")
+		for _, b := range bits {
+			fmt.Fprintf(w, "%14d", n[b])
+		}
+		fmt.Fprintf(w, "  %s\n", f.Name)
+	}
+}
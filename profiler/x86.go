@@ -0,0 +1,490 @@
+package profiler
+
+import "encoding/binary"
+
+// x86Insns lists the instruction names decodeX86 reports under each
+// arithmetic category, the pintool's.
+var x86Insns = map[string][]string{
+	"add": {"add", "adc", "adcx", "adox"},
+	"sub": {"sub", "sbb"},
+	"mul": {"mul", "mulx"},
+	"div": {"div"},
+}
+
+// x86Rip is the base register of a RIP-relative x86Insn operand.
+const x86Rip = 16
+
+// x86Insn is a decoded x86-64 instruction. Reg, rm, base, index and vvvv
+// are register numbers with their REX, VEX or EVEX extension bits, base
+// and index -1 when absent; rm is a register only when mem is false.
+type x86Insn struct {
+	size    int
+	pp      byte // SIMD prefix: 0 none, 1 66, 2 F3, 3 F2
+	w       bool // REX.W, VEX.W or EVEX.W
+	vex     bool // VEX or EVEX encoded
+	evex    bool
+	vl      int // vector length in bits: 128 for legacy SSE
+	opmap   int // 0 one-byte, 1 0F, 2 0F38, 3 0F3A, or an EVEX map
+	op      byte
+	modrm   bool
+	reg, rm int
+	vvvv    int
+	mem     bool
+	base    int
+	index   int
+	scale   int
+	rel     int64 // displacement of a direct jump or branch
+	jump    bool
+}
+
+// decodeX86Insn decodes the length and operands of the x86-64
+// instruction at the start of code; false when it is truncated.
+func decodeX86Insn(code []byte) (x86Insn, bool) {
+	in := x86Insn{vl: 128, base: -1, index: -1}
+	var rex byte
+	var p66, f2, f3, a32 bool
+	i := 0
+	byteAt := func(j int) (byte, bool) {
+		if j >= len(code) || j >= 15 {
+			return 0, false
+		}
+		return code[j], true
+	}
+	for {
+		b, ok := byteAt(i)
+		if !ok {
+			return in, false
+		}
+		switch {
+		case b == 0x66:
+			p66 = true
+		case b == 0x67:
+			a32 = true
+		case b == 0xF2:
+			f2, f3 = true, false
+		case b == 0xF3:
+			f3, f2 = true, false
+		case b == 0xF0 || b == 0x2E || b == 0x36 || b == 0x3E || b == 0x26 || b == 0x64 || b == 0x65:
+		case b&0xF0 == 0x40:
+			rex = b & 0xF
+			i++
+			goto opcode
+		default:
+			goto opcode
+		}
+		i++
+	}
+
+opcode:
+	switch {
+	case f3:
+		in.pp = 2
+	case f2:
+		in.pp = 3
+	case p66:
+		in.pp = 1
+	}
+	in.w = rex&8 != 0
+	b, ok := byteAt(i)
+	if !ok {
+		return in, false
+	}
+	switch b {
+	case 0xC5: // two-byte VEX
+		p, ok := byteAt(i + 1)
+		if !ok {
+			return in, false
+		}
+		rex = ^p >> 5 & 4
+		in.vvvv = int(^p >> 3 & 15)
+		in.vl = 128 << (p >> 2 & 1)
+		in.pp, in.opmap, in.vex, in.w = p&3, 1, true, false
+		i += 2
+	case 0xC4: // three-byte VEX
+		p1, ok1 := byteAt(i + 1)
+		p2, ok2 := byteAt(i + 2)
+		if !ok1 || !ok2 {
+			return in, false
+		}
+		rex = ^p1 >> 5 & 7
+		in.opmap = int(p1 & 31)
+		in.w = p2&0x80 != 0
+		in.vvvv = int(^p2 >> 3 & 15)
+		in.vl = 128 << (p2 >> 2 & 1)
+		in.pp, in.vex = p2&3, true
+		i += 3
+	case 0x62: // EVEX
+		p0, ok0 := byteAt(i + 1)
+		p1, ok1 := byteAt(i + 2)
+		p2, ok2 := byteAt(i + 3)
+		if !ok0 || !ok1 || !ok2 {
+			return in, false
+		}
+		rex = ^p0 >> 5 & 7
+		in.opmap = int(p0 & 7)
+		in.w = p1&0x80 != 0
+		in.vvvv = int(^p1>>3&15) | int(^p2>>3&1)<<4
+		in.vl = 128 << min(p2>>5&3, 2)
+		in.pp, in.vex, in.evex = p1&3, true, true
+		i += 4
+	case 0x0F:
+		in.opmap = 1
+		i++
+		if b, ok = byteAt(i); ok && (b == 0x38 || b == 0x3A) {
+			in.opmap = 2 + int(b>>1&1)
+			i++
+		}
+	}
+	if in.op, ok = byteAt(i); !ok {
+		return in, false
+	}
+	i++
+
+	reg := byte(0)
+	in.modrm = x86HasModRM(in.opmap, in.op, in.vex)
+	if in.modrm {
+		m, ok := byteAt(i)
+		if !ok {
+			return in, false
+		}
+		i++
+		mod, rm := m>>6, int(m&7)
+		reg = m >> 3 & 7
+		in.reg = int(reg) | int(rex&4)<<1
+		disp := 0
+		switch {
+		case mod == 3:
+			in.rm = rm | int(rex&1)<<3
+		case rm == 4:
+			sib, ok := byteAt(i)
+			if !ok {
+				return in, false
+			}
+			i++
+			in.mem, in.scale = true, 1<<(sib>>6)
+			if idx := int(sib>>3&7) | int(rex&2)<<2; idx != 4 {
+				in.index = idx
+			}
+			if sib&7 == 5 && mod == 0 {
+				disp = 4
+			} else {
+				in.base = int(sib&7) | int(rex&1)<<3
+			}
+		case rm == 5 && mod == 0:
+			in.mem, in.base, disp = true, x86Rip, 4
+		default:
+			in.mem, in.base, in.scale = true, rm|int(rex&1)<<3, 1
+		}
+		switch mod {
+		case 1:
+			disp = 1
+		case 2:
+			disp = 4
+		}
+		i += disp
+	}
+
+	imm := x86ImmSize(&in, reg, p66, a32)
+	if i+imm > len(code) || i+imm > 15 {
+		return in, false
+	}
+	switch {
+	case in.opmap == 0 && (in.op >= 0x70 && in.op <= 0x7F || in.op == 0xEB || in.op >= 0xE0 && in.op <= 0xE3):
+		in.rel, in.jump = int64(int8(code[i])), true
+	case in.opmap == 0 && in.op == 0xE9 || in.opmap == 1 && !in.vex && in.op >= 0x80 && in.op <= 0x8F:
+		in.rel, in.jump = int64(int32(binary.LittleEndian.Uint32(code[i:]))), true
+	}
+	in.size = i + imm
+	return in, true
+}
+
+// x86HasModRM reports whether opcode op of map opmap takes a ModRM byte.
+func x86HasModRM(opmap int, op byte, vex bool) bool {
+	switch opmap {
+	case 0:
+		switch {
+		case op < 0x40:
+			return op&7 < 4
+		case op == 0x62 || op == 0x63 || op == 0x69 || op == 0x6B || op >= 0x80 && op <= 0x8F:
+			return true
+		case op == 0xC0 || op == 0xC1 || op >= 0xC4 && op <= 0xC7 || op >= 0xD0 && op <= 0xD3 || op >= 0xD8 && op <= 0xDF:
+			return true
+		case op == 0xF6 || op == 0xF7 || op == 0xFE || op == 0xFF:
+			return true
+		}
+		return false
+	case 1:
+		if vex {
+			return op != 0x77
+		}
+		switch {
+		case op >= 0x05 && op <= 0x09 || op == 0x0B || op == 0x0E || op >= 0x30 && op <= 0x3F || op == 0x77:
+			return false
+		case op >= 0x80 && op <= 0x8F, op >= 0xA0 && op <= 0xA2, op >= 0xA8 && op <= 0xAA, op >= 0xC8 && op <= 0xCF:
+			return false
+		}
+	}
+	return true
+}
+
+// x86ImmSize returns the size in bytes of in's immediate or relative
+// displacement; reg is the unextended ModRM reg field.
+func x86ImmSize(in *x86Insn, reg byte, p66, a32 bool) int {
+	z := 4
+	if p66 {
+		z = 2
+	}
+	op := in.op
+	switch in.opmap {
+	case 0:
+		switch {
+		case op < 0x40 && op&7 == 4:
+			return 1
+		case op < 0x40 && op&7 == 5:
+			return z
+		case op >= 0x70 && op <= 0x7F || op >= 0xB0 && op <= 0xB7 || op >= 0xE0 && op <= 0xE7:
+			return 1
+		case op >= 0xB8 && op <= 0xBF:
+			if in.w {
+				return 8
+			}
+			return z
+		case op >= 0xA0 && op <= 0xA3:
+			if a32 {
+				return 4
+			}
+			return 8
+		}
+		switch op {
+		case 0x6A, 0x6B, 0x80, 0x82, 0x83, 0xA8, 0xC0, 0xC1, 0xC6, 0xCD, 0xD4, 0xD5, 0xEB:
+			return 1
+		case 0x68, 0x69, 0x81, 0xA9, 0xC7:
+			return z
+		case 0xE8, 0xE9:
+			return 4
+		case 0xC2, 0xCA:
+			return 2
+		case 0xC8:
+			return 3
+		case 0xF6:
+			if reg < 2 {
+				return 1
+			}
+		case 0xF7:
+			if reg < 2 {
+				return z
+			}
+		}
+	case 1:
+		switch {
+		case op >= 0x70 && op <= 0x73, op == 0xC2, op >= 0xC4 && op <= 0xC6:
+			return 1
+		case !in.vex && (op == 0x0F || op == 0xA4 || op == 0xAC || op == 0xBA):
+			return 1
+		case !in.vex && op >= 0x80 && op <= 0x8F:
+			return 4
+		}
+	case 3:
+		return 1
+	}
+	return 0
+}
+
+// stack reports whether in reads or writes RSP or RBP, as a register
+// operand (its ModRM reg when withReg) or in its address; the pintool
+// leaves such instructions out as stack and frame arithmetic.
+func (in *x86Insn) stack(withReg bool) bool {
+	sp := func(r int) bool { return r == 4 || r == 5 }
+	if withReg && sp(in.reg) {
+		return true
+	}
+	if in.mem {
+		return sp(in.base) || in.index == 5
+	}
+	return sp(in.rm)
+}
+
+// decodeX86 classifies the x86-64 instruction at the start of code under
+// the pintool's rules: only 64-bit general-register forms count, and
+// immediates, compares, tests, moves and stack or frame arithmetic do
+// not; shifts and rotates by an immediate count. MUL and IMUL count as
+// multiplies, DIV and IDIV as divides, SAR and SHRD under shr, RCL and
+// RCR under rol. A LEA adding two registers is reported as the insn
+// "lea" ("lea_scaled" when it scales the index), counted only split.
+// SSE and AVX FP and 64-bit-lane vector ops count per lane of the
+// instruction's vector length.
+func decodeX86(code []byte) (staticOp, int, bool) {
+	in, ok := decodeX86Insn(code)
+	if !ok {
+		return staticOp{}, 1, false
+	}
+	op, ok := classifyX86(&in)
+	return op, in.size, ok
+}
+
+// x86ALU names the categories of the one-byte ALU opcodes of a 64-bit
+// register with a register or memory operand, by the opcode's high bits.
+var x86ALU = [8][2]string{
+	{"add", "add"}, {"or", "or"}, {"add", "adc"}, {"sub", "sbb"},
+	{"and", "and"}, {"sub", "sub"}, {"xor", "xor"}, {},
+}
+
+// x86Shift names the categories of the shift group's ModRM reg field:
+// ROL, ROR, RCL, RCR, SHL, SHR, SAL and SAR.
+var x86Shift = [8]string{"rol", "rol", "rol", "rol", "shl", "shr", "shl", "shr"}
+
+// classifyX86 classifies the decoded in.
+func classifyX86(in *x86Insn) (staticOp, bool) {
+	if op, ok := classifyX86FP(in); ok {
+		return op, ok
+	}
+	if !in.w {
+		return staticOp{}, false
+	}
+	gpr := func(category, insn string, withReg bool) (staticOp, bool) {
+		if in.stack(withReg) || in.vex && (in.vvvv == 4 || in.vvvv == 5) {
+			return staticOp{}, false
+		}
+		return staticOp{category, insn, 1}, true
+	}
+	switch {
+	case in.vex:
+		switch {
+		case in.opmap == 2 && in.op == 0xF6 && in.pp == 3:
+			return gpr("mul", "mulx", true)
+		case in.opmap == 2 && in.op == 0xF7 && in.pp == 1:
+			return gpr("shl", "shl", true)
+		case in.opmap == 2 && in.op == 0xF7 && in.pp >= 2:
+			return gpr("shr", "shr", true)
+		case in.opmap == 2 && in.op == 0xF2 && in.pp == 0:
+			return gpr("and", "and", true)
+		case in.opmap == 3 && in.op == 0xF0 && in.pp == 3:
+			return gpr("rol", "rol", true)
+		}
+	case in.opmap == 0 && in.op < 0x40 && in.op&7 < 4 && in.op&1 == 1:
+		if c := x86ALU[in.op>>3]; c[0] != "" {
+			return gpr(c[0], c[1], true)
+		}
+	case in.opmap == 0 && in.op == 0xF7:
+		switch in.reg & 7 {
+		case 2:
+			return gpr("not", "not", false)
+		case 4, 5:
+			return gpr("mul", "mul", false)
+		case 6, 7:
+			return gpr("div", "div", false)
+		}
+	case in.opmap == 0 && (in.op == 0xC1 || in.op == 0xD1 || in.op == 0xD3):
+		c := x86Shift[in.reg&7]
+		return gpr(c, c, false)
+	case in.opmap == 0 && in.op == 0x8D && in.mem:
+		if in.base < 0 || in.base == x86Rip || in.index < 0 {
+			break
+		}
+		if in.scale > 1 {
+			return staticOp{"add", "lea_scaled", 1}, true
+		}
+		return staticOp{"add", "lea", 1}, true
+	case in.opmap == 1 && in.op == 0xAF:
+		return gpr("mul", "mul", true)
+	case in.opmap == 1 && (in.op == 0xA4 || in.op == 0xA5):
+		return gpr("shl", "shl", true)
+	case in.opmap == 1 && (in.op == 0xAC || in.op == 0xAD):
+		return gpr("shr", "shr", true)
+	case in.opmap == 2 && in.op == 0xF6 && in.pp == 1:
+		return gpr("add", "adcx", true)
+	case in.opmap == 2 && in.op == 0xF6 && in.pp == 2:
+		return gpr("add", "adox", true)
+	}
+	return staticOp{}, false
+}
+
+// classifyX86FP classifies the SSE, AVX and AVX-512 FP and 64-bit-lane
+// vector integer ops of in.
+func classifyX86FP(in *x86Insn) (staticOp, bool) {
+	lanes := func(bits int) uint64 { return uint64(in.vl / bits) }
+	switch {
+	case in.opmap == 1 && (in.op == 0x58 || in.op == 0x59 || in.op == 0x5C || in.op == 0x5E || in.op == 0xD0):
+		k := map[byte]string{0x58: "add", 0x59: "mul", 0x5C: "sub", 0x5E: "div", 0xD0: "add"}[in.op]
+		if in.op == 0xD0 && in.pp != 1 && in.pp != 3 { // ADDSUBPD, ADDSUBPS
+			break
+		}
+		switch in.pp {
+		case 0:
+			return staticOp{"fp32_" + k, k + "ps", lanes(32)}, true
+		case 1:
+			return staticOp{"fp64_" + k, k + "pd", lanes(64)}, true
+		case 2:
+			return staticOp{"fp32_" + k, k + "ss", 1}, true
+		case 3:
+			if in.op == 0xD0 {
+				return staticOp{"fp32_" + k, k + "ps", lanes(32)}, true
+			}
+			return staticOp{"fp64_" + k, k + "sd", 1}, true
+		}
+	case in.vex && in.opmap == 2 && in.pp == 1 && in.op&0xF >= 6 && (in.op>>4 == 9 || in.op>>4 == 0xA || in.op>>4 == 0xB):
+		prec, bits := "fp32", 32
+		if in.w {
+			prec, bits = "fp64", 64
+		}
+		n := lanes(bits)
+		if in.op&0xF >= 9 && in.op&1 == 1 { // the scalar forms
+			n = 1
+		}
+		return staticOp{prec + "_fma", "fma", n}, true
+	}
+
+	n := lanes(64)
+	if !in.vex && in.pp != 1 { // MMX
+		n = 1
+	}
+	switch {
+	case in.opmap == 1 && in.op == 0xD4 && (in.pp == 1 || !in.vex && in.pp == 0):
+		return staticOp{"vec_add", "paddq", n}, true
+	case in.opmap == 1 && in.op == 0xFB && (in.pp == 1 || !in.vex && in.pp == 0):
+		return staticOp{"vec_sub", "psubq", n}, true
+	case in.opmap == 1 && in.op == 0xF4 && (in.pp == 1 || !in.vex && in.pp == 0):
+		return staticOp{"vec_mul", "pmuludq", n}, true
+	case in.opmap == 2 && in.op == 0x28 && in.pp == 1:
+		return staticOp{"vec_mul", "pmuldq", n}, true
+	case in.opmap == 2 && in.op == 0x40 && in.pp == 1 && in.evex && in.w:
+		return staticOp{"vec_mul", "pmullq", n}, true
+	case in.opmap == 2 && (in.op == 0xB4 || in.op == 0xB5) && in.pp == 1 && in.evex && in.w:
+		return staticOp{"vec_mul", "pmadd52", n}, true
+	}
+	return staticOp{}, false
+}
+
+// signX86 classes the x86 multiply or divide insn.
+func signX86(insn []byte) signKind {
+	in, ok := decodeX86Insn(insn)
+	switch {
+	case !ok:
+	case in.opmap == 0 && in.op == 0xF7:
+		if in.reg&1 == 1 { // IMUL, IDIV
+			return signSigned
+		}
+		return signUnsigned
+	case in.opmap == 1 && in.op == 0xAF:
+		return signSigned
+	case in.vex && in.op == 0xF6: // MULX
+		return signUnsigned
+	}
+	return signEither
+}
+
+// memX86 reports whether the x86 insn is a register–memory form.
+func memX86(insn []byte) bool {
+	in, ok := decodeX86Insn(insn)
+	return ok && in.mem && !(in.opmap == 0 && in.op == 0x8D)
+}
+
+// branchX86 returns the target of the x86 JMP, Jcc, LOOP or JRCXZ with a
+// relative displacement at the start of code, at addr.
+func branchX86(code []byte, addr uint64) (uint64, bool) {
+	in, ok := decodeX86Insn(code)
+	if !ok || !in.jump {
+		return 0, false
+	}
+	return addr + uint64(in.size) + uint64(in.rel), true
+}
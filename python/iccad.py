@@ -0,0 +1,123 @@
+"""iccad analysis API for Python (ctypes bindings to libiccad.so).
+
+    import iccad
+    base, new = iccad.load("base.json"), iccad.load("new.json")
+    d = iccad.diff(base, new, threshold=5)
+    print(d["regressions"], d["totals"]["mul"]["pct"])
+    print(iccad.cost(new, "model.json")["total"])
+    open("new.html", "w").write(iccad.report(new, "html"))
+
+Reports are dicts as in the JSON schema, or JSON text; analyses return
+dicts, or str with format="text".  libiccad.so is built from cmd/libiccad
+and located via $ICCAD_LIB or next to this file:
+
+    go build -buildmode=c-shared -o python/libiccad.so ./cmd/libiccad
+"""
+import ctypes
+import json
+import os
+
+__all__ = ["IccadError", "load", "loads", "report", "diff", "cost", "energy", "stats"]
+
+
+class IccadError(Exception):
+    """An error reported by the profiler package."""
+
+
+_path = os.environ.get("ICCAD_LIB") or os.path.join(
+    os.path.dirname(os.path.abspath(__file__)), "libiccad.so")
+_lib = ctypes.CDLL(_path)
+_out = [ctypes.POINTER(ctypes.c_size_t), ctypes.POINTER(ctypes.c_void_p)]
+_s, _d, _u64 = ctypes.c_char_p, ctypes.c_double, ctypes.c_uint64
+for _name, _args in [
+        ("iccad_load", [_s]),
+        ("iccad_report", [_s, _s, _s]),
+        ("iccad_diff", [_s, _s, _d, _u64, _s]),
+        ("iccad_cost", [_s, _s, _s]),
+        ("iccad_energy", [_s, _s, _s, _d, _s]),
+        ("iccad_stats", [_s, _d, _s])]:
+    _fn = getattr(_lib, _name)
+    _fn.argtypes = _args + _out
+    _fn.restype = ctypes.c_void_p
+_lib.iccad_free.argtypes = [ctypes.c_void_p]
+
+
+def _call(fn, *args):
+    """Call fn and return its output as bytes, raising IccadError."""
+    n, err = ctypes.c_size_t(), ctypes.c_void_p()
+    args = [a.encode() if isinstance(a, str) else a for a in args]
+    p = fn(*args, ctypes.byref(n), ctypes.byref(err))
+    if not p:
+        msg = ctypes.string_at(err.value).decode()
+        _lib.iccad_free(err)
+        raise IccadError(msg)
+    try:
+        return ctypes.string_at(p, n.value)
+    finally:
+        _lib.iccad_free(p)
+
+
+def _text(res):
+    if isinstance(res, bytes):
+        return res.decode()
+    return res if isinstance(res, str) else json.dumps(res)
+
+
+def _model(model):
+    """Return a cost model given as a dict, JSON text or a file path as text."""
+    if isinstance(model, dict):
+        return json.dumps(model)
+    if model.lstrip().startswith("{"):
+        return model
+    with open(model) as f:
+        return f.read()
+
+
+def _result(out, format):
+    return json.loads(out) if format == "json" else out.decode()
+
+
+def load(path):
+    """Read the JSON report at path."""
+    return json.loads(_call(_lib.iccad_load, os.fspath(path)))
+
+
+def loads(text):
+    """Parse and check the JSON report in text."""
+    return json.loads(_call(_lib.iccad_report, _text(text), "json", "long"))
+
+
+def report(res, format="text", layout="long"):
+    """Render res as text, json, csv, tsv, html, dot (str) or pprof (bytes)."""
+    out = _call(_lib.iccad_report, _text(res), format, layout)
+    return out if format == "pprof" else out.decode()
+
+
+def diff(a, b, threshold=5.0, min_delta=0, format="json"):
+    """Compare run b against the baseline a.
+
+    Counters that grow by more than threshold percent and min_delta are
+    regressed; pct is None for a counter that appears from zero.
+    """
+    out = _call(_lib.iccad_diff, _text(a), _text(b), threshold, min_delta, format)
+    return _result(out, format)
+
+
+def cost(res, model, format="json"):
+    """Price res with a cost model: a dict, JSON text or a file path."""
+    return _result(_call(_lib.iccad_cost, _text(res), _model(model), format), format)
+
+
+def energy(res, node="7nm", model=None, dram_fraction=1.0, format="json"):
+    """Estimate the energy of res with the model of node (7nm, 16nm), or
+    with model, an energy cost model as in cost()."""
+    model = _model(model) if model else ""
+    out = _call(_lib.iccad_energy, _text(res), node, model, dram_fraction, format)
+    return _result(out, format)
+
+
+def stats(results, cv=1.0, format="json"):
+    """Summarize repeated runs of one workload, flagging counters whose
+    coefficient of variation exceeds cv percent."""
+    text = "[" + ",".join(_text(r) for r in results) + "]"
+    return _result(_call(_lib.iccad_stats, text, cv, format), format)